@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tamcore/ephemeron/internal/config"
+	"github.com/tamcore/ephemeron/internal/memregistry"
+)
+
+// demoImages are the sample images the demo registry starts with, as
+// "repository:tag" with the layer size in bytes. The tags cover short,
+// long, clamped and default TTLs.
+var demoImages = []struct {
+	image string
+	size  int
+}{
+	{"demo/web:5m", 48 << 10},
+	{"demo/web:1h", 52 << 10},
+	{"demo/api:30m", 128 << 10},
+	{"demo/api:1d", 132 << 10},
+	{"demo/api:latest", 140 << 10},
+	{"demo/nightly:2w", 256 << 10},
+}
+
+func demoCmd() *cobra.Command {
+	var registryPort int
+	cmd := &cobra.Command{
+		Use:   "demo",
+		Short: "Try ephemeron with a built-in registry and sample images",
+		Long: "Start an in-memory OCI registry on --registry-port, seeded with sample images, " +
+			"and serve ephemeron against it with the memory store. Images pushed to the " +
+			"registry, e.g. with docker push localhost:5000/demo/app:10m, are tracked like in a " +
+			"real deployment. Nothing is persisted.",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := setupLogger(config.LogFormat("text"))
+			cfg := newConfig(logger)
+			cfg.StoreBackend = config.StoreBackendMemory
+			cfg.RegistryURL = fmt.Sprintf("http://127.0.0.1:%d", registryPort)
+			cfg.RegistryUsername, cfg.RegistryPassword = "", ""
+			if os.Getenv("HOSTNAME_OVERRIDE") == "" {
+				cfg.Hostname = fmt.Sprintf("localhost:%d", registryPort)
+			}
+			if cfg.HookToken == "" {
+				b := make([]byte, 16)
+				if _, err := rand.Read(b); err != nil {
+					return err
+				}
+				cfg.HookToken = hex.EncodeToString(b)
+			}
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+
+			ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+			defer cancel()
+
+			webhook := fmt.Sprintf("http://127.0.0.1:%d/v1/hook/registry-event", cfg.Port)
+			reg := memregistry.New(memregistry.WebhookNotifier(webhook, cfg.HookToken, logger),
+				logger.With("component", "registry"))
+			if err := seedDemo(reg); err != nil {
+				return err
+			}
+			ln, err := net.Listen("tcp", fmt.Sprintf(":%d", registryPort))
+			if err != nil {
+				return fmt.Errorf("listening on registry port %d: %w", registryPort, err)
+			}
+			srv := &http.Server{Handler: reg, ReadHeaderTimeout: 5 * time.Second}
+			go func() { _ = srv.Serve(ln) }()
+			defer func() { _ = srv.Close() }()
+
+			logger.Info("demo registry started",
+				"registry", cfg.Hostname,
+				"ui", fmt.Sprintf("http://localhost:%d", cfg.Port),
+				"images", len(demoImages),
+			)
+			// The store starts empty, so serve recovers the sample images
+			// from the registry catalog before it starts serving.
+			return serve(ctx, cfg, logger)
+		},
+	}
+	cmd.Flags().IntVar(&registryPort, "registry-port", 5000, "Port of the built-in registry")
+	return cmd
+}
+
+// seedDemo pushes demoImages to reg, each with a random layer.
+func seedDemo(reg *memregistry.Registry) error {
+	for _, img := range demoImages {
+		repo, tag, _ := strings.Cut(img.image, ":")
+		layer := make([]byte, img.size)
+		if _, err := rand.Read(layer); err != nil {
+			return err
+		}
+		reg.PushImage(repo, tag, layer)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tamcore/ephemeron/internal/config"
+	"github.com/tamcore/ephemeron/internal/e2e"
+)
+
+func e2eTestCmd() *cobra.Command {
+	var output *string
+	var url, repository, tag string
+	var timeout time.Duration
+	cmd := &cobra.Command{
+		Use:   "e2etest",
+		Short: "Smoke-test a running deployment end to end",
+		Long: "Push a small image to REGISTRY_URL, send the push webhook to the ephemeron " +
+			"server at --url, check that the image is tracked in the store, expire it and " +
+			"wait for the server's reaper to delete it. Each run pushes to a new repository " +
+			"below --repository. Exits non-zero if any step fails.",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(*output); err != nil {
+				return err
+			}
+			logger := setupCLILogger(config.LogFormat("json"), *output)
+			cfg := newConfig(logger)
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			if url == "" {
+				url = "http://localhost:" + strconv.Itoa(cfg.Port)
+			}
+
+			rdb, err := newStore(cfg)
+			if err != nil {
+				return fmt.Errorf("connecting to store: %w", err)
+			}
+			defer func() { _ = rdb.Close() }()
+
+			runner := e2e.New(newRegistryClient(cfg), rdb, e2e.Config{
+				WebhookURL:   strings.TrimRight(url, "/") + "/v1/hook/registry-event",
+				HookToken:    cfg.HookToken,
+				Repository:   fmt.Sprintf("%s/run-%d", repository, time.Now().Unix()),
+				Tag:          tag,
+				Timeout:      timeout,
+				PollInterval: time.Second,
+			})
+			res := runner.Run(context.Background())
+			err = render(cmd.OutOrStdout(), *output, res, func(tw *tabwriter.Writer) {
+				_, _ = fmt.Fprintln(tw, "STEP\tSTATUS\tDURATION\tERROR")
+				for _, s := range res.Steps {
+					_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.Name, s.Status, s.Duration, s.Error)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			if !res.Passed {
+				return errors.New("end-to-end test failed")
+			}
+			return nil
+		},
+	}
+	output = addOutputFlag(cmd)
+	cmd.Flags().StringVar(&url, "url", "", "Base URL of the ephemeron server (default: http://localhost:$PORT)")
+	cmd.Flags().StringVar(&repository, "repository", "ephemeron-e2e", "Repository below which test images are pushed")
+	cmd.Flags().StringVar(&tag, "tag", "1h", "Tag of the test image; a TTL longer than the test")
+	cmd.Flags().DurationVar(&timeout, "timeout", 5*time.Minute,
+		"How long to wait for the image to be tracked and to be reaped; longer than the reap interval")
+	return cmd
+}
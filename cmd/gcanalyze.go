@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tamcore/ephemeron/internal/config"
+	"github.com/tamcore/ephemeron/internal/gcanalyze"
+)
+
+func gcAnalyzeCmd() *cobra.Command {
+	var output *string
+	cmd := &cobra.Command{
+		Use:   "gc-analyze",
+		Short: "Estimate the storage registry garbage collection would reclaim",
+		Long: "Walk the registry API for the blobs referenced by tagged manifests and compare them " +
+			"with the blobs in the storage directory at REGISTRY_DATA_PATH, reporting the unreferenced " +
+			"storage per repository. The estimate assumes garbage collection with --delete-untagged.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(*output); err != nil {
+				return err
+			}
+			logger := setupCLILogger(config.LogFormat("json"), *output)
+			cfg := newConfig(logger)
+			if cfg.RegistryDataPath == "" {
+				return errors.New("gc-analyze requires REGISTRY_DATA_PATH")
+			}
+
+			a := gcanalyze.New(newRegistryClient(cfg), cfg.RegistryDataPath, logger.With("component", "gc-analyze"))
+			rep, err := a.Analyze(context.Background())
+			if err != nil {
+				return err
+			}
+
+			return render(cmd.OutOrStdout(), *output, rep, func(tw *tabwriter.Writer) {
+				_, _ = fmt.Fprintln(tw, "REPOSITORY\tBLOBS\tBYTES\tUNREFERENCED\tRECLAIMABLE BYTES")
+				for _, r := range rep.Repositories {
+					_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\n",
+						r.Name, r.Blobs, r.Bytes, r.Unreferenced, r.ReclaimableBytes)
+				}
+				_, _ = fmt.Fprintf(tw, "(unlinked)\t\t\t\t%d\n", rep.UnlinkedBytes)
+				_, _ = fmt.Fprintf(tw, "TOTAL\t%d\t%d\t\t%d\n", rep.TotalBlobs, rep.TotalBytes, rep.ReclaimableBytes)
+			})
+		},
+	}
+	output = addOutputFlag(cmd)
+	return cmd
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v2"
+
+	"github.com/tamcore/ephemeron/internal/apiauth"
+	"github.com/tamcore/ephemeron/internal/config"
+	recoverlib "github.com/tamcore/ephemeron/internal/recover"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+	"github.com/tamcore/ephemeron/internal/rules"
+)
+
+// Outcomes of an init step.
+const (
+	initCreated = "created"
+	initExists  = "exists"
+)
+
+// initStep is what init did about one part of the store.
+type initStep struct {
+	Step   string `json:"step" yaml:"step"`
+	Status string `json:"status" yaml:"status"`
+	Detail string `json:"detail,omitempty" yaml:"detail,omitempty"`
+}
+
+// initResult is the CLI representation of an init run. AdminToken is only
+// set when the admin token was created with a generated secret.
+type initResult struct {
+	SchemaVersion int        `json:"schema_version" yaml:"schema_version"`
+	Steps         []initStep `json:"steps" yaml:"steps"`
+	AdminToken    string     `json:"admin_token,omitempty" yaml:"admin_token,omitempty"`
+}
+
+// seedRule is a rule in the file given to init --rules, keyed by kind.
+type seedRule struct {
+	Pattern    string `yaml:"pattern"`
+	DefaultTTL string `yaml:"default_ttl"`
+	MaxTTL     string `yaml:"max_ttl"`
+	NonTTLTags string `yaml:"non_ttl_tags"`
+}
+
+// initOptions selects what init sets up besides the schema version.
+type initOptions struct {
+	adminToken  string
+	adminSecret string
+	rules       map[string][]seedRule
+	// recover populates the store from the registry catalog before it is
+	// first marked initialized; nil marks it right away.
+	recover func(ctx context.Context) error
+}
+
+func initCmd() *cobra.Command {
+	var output *string
+	var adminToken, rulesFile string
+	var skipRecover bool
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Prepare the store for first use; safe to re-run",
+		Long: "Record the store schema version, add the rules from --rules, create the admin " +
+			"token named by --admin-token and mark the store initialized, recovering the " +
+			"tracked images from the registry catalog first unless --skip-recover is set. " +
+			"Anything already in place is left alone, so init can run on every deploy. " +
+			"The admin token secret is read from INIT_ADMIN_TOKEN, or generated and " +
+			"printed once if that is unset.",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(*output); err != nil {
+				return err
+			}
+			logger := setupCLILogger(config.LogFormat("json"), *output)
+			cfg := newConfig(logger)
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+
+			opts := initOptions{adminToken: adminToken, adminSecret: os.Getenv("INIT_ADMIN_TOKEN")}
+			if rulesFile != "" {
+				data, err := os.ReadFile(rulesFile)
+				if err != nil {
+					return fmt.Errorf("reading rules: %w", err)
+				}
+				if err := yaml.UnmarshalStrict(data, &opts.rules); err != nil {
+					return fmt.Errorf("parsing rules %s: %w", rulesFile, err)
+				}
+			}
+
+			rdb, err := newStore(cfg)
+			if err != nil {
+				return fmt.Errorf("connecting to store: %w", err)
+			}
+			defer func() { _ = rdb.Close() }()
+
+			if !skipRecover {
+				// Rules are seeded before recovering, so load them then.
+				ruleSet := newRules(rdb, cfg, logger)
+				rec := recoverlib.New(rdb, newRegistryClient(cfg), cfg.DefaultTTL, cfg.MaxTTL,
+					logger.With("component", "recover"), recoverlib.WithNonTTLTags(nonTTLTags(ruleSet, cfg)))
+				opts.recover = func(ctx context.Context) error {
+					if err := ruleSet.Refresh(ctx); err != nil {
+						return err
+					}
+					_, err := rec.Recover(ctx)
+					return err
+				}
+			}
+
+			res, err := runInit(context.Background(), rdb, opts)
+			if err != nil {
+				return err
+			}
+			return render(cmd.OutOrStdout(), *output, res, func(tw *tabwriter.Writer) {
+				_, _ = fmt.Fprintln(tw, "STEP\tSTATUS\tDETAIL")
+				for _, s := range res.Steps {
+					_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Step, s.Status, s.Detail)
+				}
+				if res.AdminToken != "" {
+					_, _ = fmt.Fprintf(tw, "\nadmin token (shown once): %s\n", res.AdminToken)
+				}
+			})
+		},
+	}
+	output = addOutputFlag(cmd)
+	cmd.Flags().StringVar(&adminToken, "admin-token", "", "Name of the admin API token to create (default: none)")
+	cmd.Flags().StringVar(&rulesFile, "rules", "",
+		"YAML or JSON file of rules to add, keyed by kind: "+
+			redisclient.RuleImmutableTag+", "+redisclient.RuleProtected+" or "+redisclient.RuleRepoPolicy)
+	cmd.Flags().BoolVar(&skipRecover, "skip-recover", false,
+		"Mark the store initialized without scanning the registry catalog, e.g. for a new registry")
+	return cmd
+}
+
+// runInit brings store up to the state described by opts, creating only
+// what is missing. It refuses stores written by a newer schema.
+func runInit(ctx context.Context, store redisclient.Store, opts initOptions) (initResult, error) {
+	res := initResult{SchemaVersion: redisclient.CurrentSchema}
+
+	v, err := checkSchema(ctx, store)
+	if err != nil {
+		return res, err
+	}
+	switch {
+	case v == redisclient.CurrentSchema:
+		res.add("schema", initExists, fmt.Sprintf("version %d", v))
+	default:
+		if err := store.SetSchemaVersion(ctx, redisclient.CurrentSchema); err != nil {
+			return res, fmt.Errorf("recording schema version: %w", err)
+		}
+		res.add("schema", initCreated, fmt.Sprintf("version %d", redisclient.CurrentSchema))
+	}
+
+	for _, kind := range slices.Sorted(maps.Keys(opts.rules)) {
+		for _, r := range opts.rules[kind] {
+			added, err := rules.Seed(ctx, store, kind, r.Pattern, r.DefaultTTL, r.MaxTTL, r.NonTTLTags)
+			if err != nil {
+				return res, err
+			}
+			res.add("rule", outcome(added), kind+" "+r.Pattern)
+		}
+	}
+
+	if opts.adminToken != "" {
+		secret, created, err := apiauth.Ensure(ctx, store, opts.adminToken, redisclient.RoleAdmin, opts.adminSecret)
+		if err != nil {
+			return res, fmt.Errorf("admin token: %w", err)
+		}
+		if created && opts.adminSecret == "" {
+			res.AdminToken = secret
+		}
+		res.add("admin token", outcome(created), opts.adminToken)
+	}
+
+	initialized, err := store.IsInitialized(ctx)
+	if err != nil {
+		return res, fmt.Errorf("checking initialization state: %w", err)
+	}
+	if initialized {
+		res.add("initialized", initExists, "")
+		return res, nil
+	}
+	detail := "recovery skipped"
+	if opts.recover != nil {
+		if err := opts.recover(ctx); err != nil {
+			return res, fmt.Errorf("recovering from registry: %w", err)
+		}
+		detail = "recovered from registry"
+	}
+	if err := store.SetInitialized(ctx); err != nil {
+		return res, fmt.Errorf("setting initialized flag: %w", err)
+	}
+	res.add("initialized", initCreated, detail)
+	return res, nil
+}
+
+// checkSchema returns the schema version recorded in store, 0 if none, and
+// refuses a version this build does not know, such as one written by a
+// newer release.
+func checkSchema(ctx context.Context, store redisclient.Store) (int, error) {
+	v, err := store.SchemaVersion(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("reading schema version: %w", err)
+	}
+	if v < 0 || v > redisclient.CurrentSchema {
+		return v, fmt.Errorf("store schema version %d is unknown, this build reads up to %d; upgrade ephemeron",
+			v, redisclient.CurrentSchema)
+	}
+	return v, nil
+}
+
+func (r *initResult) add(step, status, detail string) {
+	r.Steps = append(r.Steps, initStep{Step: step, Status: status, Detail: detail})
+}
+
+func outcome(created bool) string {
+	if created {
+		return initCreated
+	}
+	return initExists
+}
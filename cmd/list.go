@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tamcore/ephemeron/internal/config"
+	"github.com/tamcore/ephemeron/internal/owners"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// imageRecord is the CLI representation of a tracked image.
+type imageRecord struct {
+	Image     string    `json:"image" yaml:"image"`
+	ExpiresAt time.Time `json:"expires_at" yaml:"expires_at"`
+	SizeBytes int64     `json:"size_bytes" yaml:"size_bytes"`
+	Digest    string    `json:"digest,omitempty" yaml:"digest,omitempty"`
+	Owner     string    `json:"owner,omitempty" yaml:"owner,omitempty"`
+
+	redisclient.ImageMeta `yaml:",inline"`
+}
+
+func listCmd() *cobra.Command {
+	var output *string
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List tracked images and their expiry",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(*output); err != nil {
+				return err
+			}
+			logger := setupCLILogger(config.LogFormat("json"), *output)
+			cfg := newConfig(logger)
+
+			rdb, err := newStore(cfg)
+			if err != nil {
+				return fmt.Errorf("connecting to store: %w", err)
+			}
+			defer func() { _ = rdb.Close() }()
+
+			owned, err := owners.Parse(cfg.RepoOwners)
+			if err != nil {
+				return fmt.Errorf("REPO_OWNERS: %w", err)
+			}
+			records, err := listImageRecords(context.Background(), rdb, owned)
+			if err != nil {
+				return err
+			}
+
+			return render(cmd.OutOrStdout(), *output, records, func(tw *tabwriter.Writer) {
+				_, _ = fmt.Fprintln(tw, "IMAGE\tEXPIRES\tSIZE BYTES\tDIGEST\tOWNER\tPUSHED BY")
+				for _, rec := range records {
+					_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n",
+						rec.Image, rec.ExpiresAt.Format(time.RFC3339), rec.SizeBytes, rec.Digest, rec.Owner, rec.Actor)
+				}
+			})
+		},
+	}
+	output = addOutputFlag(cmd)
+	return cmd
+}
+
+// listImageRecords loads all tracked images from the store, sorted by expiry.
+func listImageRecords(ctx context.Context, store redisclient.Store, owned *owners.Resolver) ([]imageRecord, error) {
+	images, err := store.ListImages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing images: %w", err)
+	}
+
+	records := make([]imageRecord, 0, len(images))
+	for _, image := range images {
+		expires, err := store.GetExpiry(ctx, image)
+		if err != nil {
+			return nil, fmt.Errorf("getting expiry for %s: %w", image, err)
+		}
+		size, err := store.GetImageSize(ctx, image)
+		if err != nil {
+			return nil, fmt.Errorf("getting size for %s: %w", image, err)
+		}
+		digest, err := store.GetImageDigest(ctx, image)
+		if err != nil {
+			return nil, fmt.Errorf("getting digest for %s: %w", image, err)
+		}
+		meta, err := store.GetImageMeta(ctx, image)
+		if err != nil {
+			return nil, fmt.Errorf("getting metadata for %s: %w", image, err)
+		}
+		records = append(records, imageRecord{
+			Image:     image,
+			ExpiresAt: time.UnixMilli(expires).UTC(),
+			SizeBytes: size,
+			Digest:    digest,
+			Owner:     owned.Owner(repoOf(image)),
+			ImageMeta: meta,
+		})
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ExpiresAt.Before(records[j].ExpiresAt)
+	})
+	return records, nil
+}
+
+// repoOf returns the repository part of a "repo:tag" image reference.
+func repoOf(image string) string {
+	repo, _, _ := strings.Cut(image, ":")
+	return repo
+}
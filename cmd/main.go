@@ -1,45 +1,59 @@
 package main
 
 import (
-	"context"
 	"fmt"
+	"io"
 	"log/slog"
-	"net"
 	"net/http"
+	"net/url"
 	"os"
-	"os/signal"
-	"strconv"
-	"strings"
-	"syscall"
-	"time"
 
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 
 	"github.com/tamcore/ephemeron/internal/config"
-	"github.com/tamcore/ephemeron/internal/health"
+	"github.com/tamcore/ephemeron/internal/deletehook"
+	"github.com/tamcore/ephemeron/internal/faults"
 	"github.com/tamcore/ephemeron/internal/hooks"
+	"github.com/tamcore/ephemeron/internal/kube"
+	"github.com/tamcore/ephemeron/internal/memstore"
 	"github.com/tamcore/ephemeron/internal/reaper"
-	recoverlib "github.com/tamcore/ephemeron/internal/recover"
 	redisclient "github.com/tamcore/ephemeron/internal/redis"
 	"github.com/tamcore/ephemeron/internal/registry"
-	"github.com/tamcore/ephemeron/internal/web"
+	"github.com/tamcore/ephemeron/internal/rules"
+	"github.com/tamcore/ephemeron/internal/secrets"
 )
 
 var (
 	version = "dev"
 	commit  = "none"
+	date    = "unknown"
 )
 
+// faultInjection is set by the hidden --fault-injection flag.
+var faultInjection bool
+
 func main() {
 	rootCmd := &cobra.Command{
 		Use:   "ephemeron",
 		Short: "Ephemeral container registry manager",
 	}
+	rootCmd.PersistentFlags().BoolVar(&faultInjection, "fault-injection", false,
+		"Randomly delay and fail store and registry calls (resilience testing only)")
+	_ = rootCmd.PersistentFlags().MarkHidden("fault-injection")
 
 	rootCmd.AddCommand(serveCmd())
 	rootCmd.AddCommand(reapCmd())
 	rootCmd.AddCommand(recoverCmd())
+	rootCmd.AddCommand(reconcileCmd())
+	rootCmd.AddCommand(initCmd())
+	rootCmd.AddCommand(listCmd())
+	rootCmd.AddCommand(freezeCmd())
+	rootCmd.AddCommand(unfreezeCmd())
+	rootCmd.AddCommand(tokenCmd())
+	rootCmd.AddCommand(reencryptCmd())
+	rootCmd.AddCommand(gcAnalyzeCmd())
+	rootCmd.AddCommand(e2eTestCmd())
+	rootCmd.AddCommand(demoCmd())
 	rootCmd.AddCommand(versionCmd())
 
 	if err := rootCmd.Execute(); err != nil {
@@ -47,281 +61,194 @@ func main() {
 	}
 }
 
+// newConfig reads the configuration from the environment, with fault
+// injection enabled by the --fault-injection flag.
 func newConfig(logger *slog.Logger) *config.Config {
-	return &config.Config{
-		Port:                   envInt(logger, "PORT", 8000),
-		InternalPort:           envInt(logger, "INTERNAL_PORT", 9090),
-		RedisURL:               envStr("REDIS_URL", envStr("REDISCLOUD_URL", "redis://localhost:6379")),
-		HookToken:              envStr("HOOK_TOKEN", ""),
-		RegistryURL:            envStr("REGISTRY_URL", "http://localhost:5000"),
-		Hostname:               envStr("HOSTNAME_OVERRIDE", "localhost"),
-		DefaultTTL:             envDuration(logger, "DEFAULT_TTL", time.Hour),
-		MaxTTL:                 envDuration(logger, "MAX_TTL", 24*time.Hour),
-		ReapInterval:           envDuration(logger, "REAP_INTERVAL", time.Minute),
-		LogFormat:              envStr("LOG_FORMAT", "json"),
-		ImmutableTagPatterns:   envStrSlice("IMMUTABLE_TAG_PATTERNS", nil),
-		HealthFailureThreshold: envInt(logger, "HEALTH_FAILURE_THRESHOLD", 3),
-	}
+	cfg := config.FromEnv(logger)
+	cfg.Faults.Enabled = faultInjection
+	return cfg
 }
 
-func setupLogger(format string) *slog.Logger {
-	var handler slog.Handler
-	if format == "text" {
-		handler = slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug})
-	} else {
-		handler = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo})
-	}
-	return slog.New(handler)
+func newRegistryClient(cfg *config.Config) *registry.Client {
+	transport := proxyTransport(cfg)
+	if cfg.Faults.Enabled {
+		transport = faults.New(cfg.Faults).Transport(transport)
+	}
+	return registry.New(cfg.RegistryURL,
+		registry.WithAcceptTypes(cfg.ManifestAcceptTypes),
+		registry.WithBasicAuth(cfg.RegistryUsername, cfg.RegistryPassword),
+		registry.WithTimeout(cfg.RegistryTimeout),
+		registry.WithRetries(cfg.RegistryRetries, cfg.RegistryRetryBackoff),
+		registry.WithTransport(transport),
+	)
 }
 
-func serveCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "serve",
-		Short: "Start the webhook server, reaper loop, and landing page",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			logger := setupLogger(envStr("LOG_FORMAT", "json"))
-			cfg := newConfig(logger)
-			if err := cfg.Validate(); err != nil {
-				return err
-			}
-
-			rdb, err := redisclient.New(cfg.RedisURL)
-			if err != nil {
-				return fmt.Errorf("connecting to redis: %w", err)
-			}
-			defer func() { _ = rdb.Close() }()
-
-			ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
-			defer cancel()
-
-			if err := rdb.Ping(ctx); err != nil {
-				return fmt.Errorf("redis ping failed: %w", err)
-			}
-			logger.Info("connected to redis")
-
-			// Auto-recover if Redis is not initialized.
-			reg := registry.New(cfg.RegistryURL)
-			rec := recoverlib.New(rdb, reg, cfg.DefaultTTL, cfg.MaxTTL, logger.With("component", "recover"))
-			if err := rec.RunIfNeeded(ctx); err != nil {
-				logger.Error("auto-recovery failed", "error", err)
-			}
-
-			// Start reaper in background.
-			healthChecker := health.New(cfg.HealthFailureThreshold, logger.With("component", "health"))
-			r := reaper.New(rdb, cfg.RegistryURL, logger.With("component", "reaper"), reaper.WithHealthReporter(healthChecker))
-			go r.RunLoop(ctx, cfg.ReapInterval)
-
-			// Set up public HTTP routes (webhook + landing page).
-			mux := http.NewServeMux()
-
-			hookHandler := hooks.NewHandler(
-				rdb, reg, cfg.HookToken, cfg.DefaultTTL, cfg.MaxTTL,
-				cfg.ImmutableTagPatterns,
-				logger.With("component", "hooks"),
-			)
-			mux.Handle("POST /v1/hook/registry-event", hookHandler)
-
-			webHandler, err := web.NewHandler(cfg.Hostname, cfg.DefaultTTL, cfg.MaxTTL, version, logger.With("component", "web"))
-			if err != nil {
-				return fmt.Errorf("creating web handler: %w", err)
-			}
-			mux.Handle("GET /{$}", webHandler)
-
-			// Set up internal HTTP routes (probes + metrics).
-			internalMux := http.NewServeMux()
-			internalMux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
-				if !healthChecker.IsHealthy() {
-					w.WriteHeader(http.StatusServiceUnavailable)
-					_, _ = w.Write([]byte(`{"status":"unhealthy","reason":"registry unreachable"}`))
-					return
-				}
-				w.WriteHeader(http.StatusOK)
-				_, _ = w.Write([]byte(`{"status":"ok"}`))
-			})
-			internalMux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
-				if err := rdb.Ping(r.Context()); err != nil {
-					w.WriteHeader(http.StatusServiceUnavailable)
-					_, _ = w.Write([]byte(`{"status":"not ready"}`))
-					return
-				}
-				w.WriteHeader(http.StatusOK)
-				_, _ = w.Write([]byte(`{"status":"ok"}`))
-			})
-			internalMux.Handle("GET /metrics", promhttp.Handler())
-
-			srv := &http.Server{
-				Handler:           mux,
-				ReadHeaderTimeout: 5 * time.Second,
-			}
-			internalSrv := &http.Server{
-				Handler:           internalMux,
-				ReadHeaderTimeout: 5 * time.Second,
-			}
+// newHarborClient returns the HTTP client for Harbor API requests.
+func newHarborClient(cfg *config.Config) *http.Client {
+	return &http.Client{Timeout: cfg.HarborTimeout, Transport: proxyTransport(cfg)}
+}
 
-			ln, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.Port))
-			if err != nil {
-				return fmt.Errorf("listening on port %d: %w", cfg.Port, err)
-			}
-			internalLn, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.InternalPort))
-			if err != nil {
-				return fmt.Errorf("listening on internal port %d: %w", cfg.InternalPort, err)
-			}
+// proxyTransport returns the transport for registry and Harbor requests: the
+// default one, which honours HTTP_PROXY, HTTPS_PROXY and NO_PROXY, or one
+// sending everything through REGISTRY_PROXY.
+func proxyTransport(cfg *config.Config) http.RoundTripper {
+	if cfg.RegistryProxy == "" {
+		return http.DefaultTransport
+	}
+	proxy, _ := url.Parse(cfg.RegistryProxy) // Validated by config.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxy)
+	return transport
+}
 
-			return runServers(ctx, logger, srv, internalSrv, ln, internalLn)
-		},
+// newStore creates the image tracking store selected by STORE_BACKEND.
+// Fault injection and extra only apply to the Redis backend.
+func newStore(cfg *config.Config, extra ...redisclient.Option) (redisclient.Store, error) {
+	if cfg.StoreBackend == config.StoreBackendMemory {
+		return memstore.New(), nil
+	}
+	opts := []redisclient.Option{
+		redisclient.WithKeyPrefix(cfg.RedisKeyPrefix),
+		redisclient.WithDB(cfg.RedisDB),
+		redisclient.WithNativeExpiry(cfg.RedisNativeExpiry),
+		redisclient.WithMaxReplicationLag(cfg.RedisMaxReplicationLag),
 	}
+	if password := cfg.RedisPassword; password != "" {
+		opts = append(opts, redisclient.WithPassword(func() string { return password }))
+	}
+	keyring, err := cfg.FieldKeyring()
+	if err != nil {
+		return nil, err
+	}
+	if keyring != nil {
+		opts = append(opts, redisclient.WithFieldEncryption(keyring))
+	}
+	if cfg.Faults.Enabled {
+		opts = append(opts, redisclient.WithHooks(faults.New(cfg.Faults).RedisHook()))
+	}
+	return redisclient.New(cfg.RedisURL, append(opts, extra...)...)
 }
 
-const shutdownTimeout = 10 * time.Second
-
-// runServers serves both HTTP servers until the context is cancelled, then
-// shuts them down gracefully and waits for in-flight requests to drain
-// before returning.
-func runServers(
-	ctx context.Context,
-	logger *slog.Logger,
-	srv, internalSrv *http.Server,
-	ln, internalLn net.Listener,
-) error {
-	shutdownDone := make(chan struct{})
-	go func() {
-		defer close(shutdownDone)
-		<-ctx.Done()
-		logger.Info("shutting down HTTP servers")
-		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
-		defer shutdownCancel()
-		_ = srv.Shutdown(shutdownCtx)
-		_ = internalSrv.Shutdown(shutdownCtx)
-	}()
-
-	go func() {
-		logger.Info("starting internal server", "addr", internalLn.Addr().String())
-		if err := internalSrv.Serve(internalLn); err != nil && err != http.ErrServerClosed {
-			logger.Error("internal server failed", "error", err)
+// newSecret returns the secret name with its value from startup, refreshed
+// from ref by RefreshLoop if ref is set.
+func newSecret(cfg *config.Config, name, value, ref string, logger *slog.Logger) (*secrets.Secret, error) {
+	var src secrets.Source
+	if ref != "" {
+		var err error
+		if src, err = cfg.Secrets.Parse(ref); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
 		}
-	}()
-
-	logger.Info("starting server", "addr", ln.Addr().String())
-	if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
-		return err
 	}
-
-	// Serve only returns ErrServerClosed after Shutdown was initiated, so
-	// wait for the shutdown goroutine to finish draining both servers.
-	<-shutdownDone
-	return nil
+	return secrets.New(name, src, value, logger), nil
 }
 
-func reapCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "reap",
-		Short: "Run a single reap cycle (for CronJob or debugging)",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			logger := setupLogger(envStr("LOG_FORMAT", "json"))
-			cfg := newConfig(logger)
-			if err := cfg.Validate(); err != nil {
-				return err
-			}
-
-			rdb, err := redisclient.New(cfg.RedisURL)
-			if err != nil {
-				return fmt.Errorf("connecting to redis: %w", err)
-			}
-			defer func() { _ = rdb.Close() }()
+func setupLogger(format string) *slog.Logger {
+	return setupLoggerTo(os.Stdout, format)
+}
 
-			ctx := context.Background()
-			r := reaper.New(rdb, cfg.RegistryURL, logger.With("component", "reaper"))
-			return r.ReapOnce(ctx)
-		},
+// setupCLILogger returns a logger for one-shot commands. When machine-readable
+// output is requested, logs go to stderr so stdout stays parseable.
+func setupCLILogger(format, output string) *slog.Logger {
+	if output != outputTable {
+		return setupLoggerTo(os.Stderr, format)
 	}
+	return setupLogger(format)
 }
 
-func recoverCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "recover",
-		Short: "Re-populate Redis by scanning the registry catalog",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			logger := setupLogger(envStr("LOG_FORMAT", "json"))
-			cfg := newConfig(logger)
-			if err := cfg.Validate(); err != nil {
-				return err
-			}
-
-			rdb, err := redisclient.New(cfg.RedisURL)
-			if err != nil {
-				return fmt.Errorf("connecting to redis: %w", err)
-			}
-			defer func() { _ = rdb.Close() }()
-
-			ctx := context.Background()
-			reg := registry.New(cfg.RegistryURL)
-			rec := recoverlib.New(rdb, reg, cfg.DefaultTTL, cfg.MaxTTL, logger.With("component", "recover"))
-
-			if err := rec.Run(ctx); err != nil {
-				return err
-			}
-
-			return rdb.SetInitialized(ctx)
-		},
+func setupLoggerTo(w io.Writer, format string) *slog.Logger {
+	var handler slog.Handler
+	if format == "text" {
+		handler = slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: levelNames})
+	} else {
+		handler = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelInfo, ReplaceAttr: levelNames})
 	}
+	return slog.New(handler)
 }
 
-func versionCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "version",
-		Short: "Print version information",
-		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Printf("ephemeron %s (commit: %s)\n", version, commit)
-		},
+// levelNames names the decision trace level "TRACE" in log records.
+func levelNames(_ []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && a.Value.Any() == hooks.LevelTrace {
+		a.Value = slog.StringValue("TRACE")
 	}
+	return a
 }
 
-func envStr(key, fallback string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
+// newRepoCleaner returns the RepoCleaner selected by REPO_CLEANUP, or nil
+// to keep empty repositories.
+func newRepoCleaner(cfg *config.Config) reaper.RepoCleaner {
+	switch cfg.RepoCleanup {
+	case config.RepoCleanupHarbor:
+		return reaper.NewHarborCleaner(cfg.HarborURL, cfg.HarborUsername, cfg.HarborPassword, newHarborClient(cfg))
+	case config.RepoCleanupFilesystem:
+		return reaper.NewFilesystemCleaner(cfg.RegistryDataPath)
+	default:
+		return nil
 	}
-	return fallback
 }
 
-func envInt(logger *slog.Logger, key string, fallback int) int {
-	v := os.Getenv(key)
-	if v == "" {
-		return fallback
-	}
-	n, err := strconv.Atoi(v)
-	if err != nil {
-		logger.Warn("invalid integer in environment variable, using fallback",
-			"key", key, "value", v, "fallback", fallback)
-		return fallback
+// newDeleteHooks returns the hooks run before and after each deletion, the
+// command before the webhook.
+func newDeleteHooks(cfg *config.Config) (pre, post []reaper.DeleteHook) {
+	hooks := func(command, webhook string) []reaper.DeleteHook {
+		var out []reaper.DeleteHook
+		if command != "" {
+			out = append(out, deletehook.NewCommand(command, cfg.DeleteHookTimeout))
+		}
+		if webhook != "" {
+			out = append(out, deletehook.NewWebhook(webhook, cfg.DeleteHookTimeout))
+		}
+		return out
 	}
-	return n
+	return hooks(cfg.PreDeleteCommand, cfg.PreDeleteWebhook), hooks(cfg.PostDeleteCommand, cfg.PostDeleteWebhook)
 }
 
-func envDuration(logger *slog.Logger, key string, fallback time.Duration) time.Duration {
-	v := os.Getenv(key)
-	if v == "" {
-		return fallback
-	}
-	d, err := time.ParseDuration(v)
-	if err != nil {
-		logger.Warn("invalid duration in environment variable, using fallback",
-			"key", key, "value", v, "fallback", fallback.String())
-		return fallback
-	}
-	return d
+// newWorkloadScanner returns the scanner of images referenced by workloads
+// in the cluster ephemeron runs in.
+func newWorkloadScanner(cfg *config.Config, logger *slog.Logger) (*kube.Scanner, error) {
+	return kube.NewInCluster(kube.Config{
+		Kinds:         cfg.KubeWorkloadKinds,
+		Namespaces:    cfg.KubeNamespaces,
+		LabelSelector: cfg.KubeLabelSelector,
+		RegistryHosts: append([]string{cfg.Hostname}, cfg.KubeRegistryHosts...),
+	}, 3*cfg.KubeScanInterval, logger.With("component", "kube"))
 }
 
-func envStrSlice(key string, fallback []string) []string {
-	v := os.Getenv(key)
-	if v == "" {
-		return fallback
+// newRules returns the static rules from cfg, to be merged with those
+// managed at runtime once refreshed.
+func newRules(store redisclient.Store, cfg *config.Config, logger *slog.Logger) *rules.Set {
+	return rules.New(store, cfg.ImmutableTagPatterns, cfg.ProtectedPatterns, logger.With("component", "rules"))
+}
+
+// ttlOptions returns the webhook handler options that decide the TTL limits
+// of a push.
+func ttlOptions(cfg *config.Config, ruleSet *rules.Set) []hooks.HandlerOption {
+	return []hooks.HandlerOption{
+		hooks.WithArtifactTTLs(cfg.ArtifactTTLs),
+		hooks.WithCacheRepos(cfg.CacheRepos, cfg.CacheRepoTTL),
+		hooks.WithRules(ruleSet),
 	}
-	var result []string
-	for s := range strings.SplitSeq(v, ",") {
-		trimmed := strings.TrimSpace(s)
-		if trimmed != "" {
-			result = append(result, trimmed)
+}
+
+// ttlLimits returns a webhook handler that only resolves TTL limits, for
+// the reaper to keep retracked images within what a push would get.
+func ttlLimits(
+	store redisclient.Store,
+	reg *registry.Client,
+	cfg *config.Config,
+	ruleSet *rules.Set,
+	logger *slog.Logger,
+) *hooks.Handler {
+	return hooks.NewHandler(store, reg, "", cfg.DefaultTTL, cfg.MaxTTL, cfg.ImmutableTagPatterns,
+		logger.With("component", "hooks"), ttlOptions(cfg, ruleSet)...)
+}
+
+// nonTTLTags returns the action for pushes of tags that are not TTLs to a
+// repository: its repository policy's, or cfg's.
+func nonTTLTags(ruleSet *rules.Set, cfg *config.Config) func(repo string) string {
+	return func(repo string) string {
+		if action := ruleSet.NonTTLTags(repo); action != "" {
+			return action
 		}
+		return cfg.NonTTLTags
 	}
-	return result
 }
@@ -2,14 +2,25 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/tamcore/ephemeron/internal/apiauth"
+	"github.com/tamcore/ephemeron/internal/hooks"
+	"github.com/tamcore/ephemeron/internal/memstore"
+	"github.com/tamcore/ephemeron/internal/owners"
+	"github.com/tamcore/ephemeron/internal/reaper"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
 )
 
 func newTestListener(t *testing.T) net.Listener {
@@ -94,83 +105,216 @@ func TestRunServers_DrainsInFlightRequestsOnShutdown(t *testing.T) {
 	}
 }
 
-func TestEnvInt(t *testing.T) {
+func TestRunServers_ReturnsPromptlyWhenIdle(t *testing.T) {
+	srv := &http.Server{Handler: http.NewServeMux(), ReadHeaderTimeout: time.Second}
+	internalSrv := &http.Server{Handler: http.NewServeMux(), ReadHeaderTimeout: time.Second}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- runServers(ctx, slog.Default(), srv, internalSrv, newTestListener(t), newTestListener(t))
+	}()
+
+	cancel()
+
+	select {
+	case err := <-runDone:
+		if err != nil {
+			t.Fatalf("runServers returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("runServers did not return after context cancellation")
+	}
+}
+
+func TestCheckReapResult(t *testing.T) {
 	tests := []struct {
-		name     string
-		value    string
-		set      bool
-		fallback int
-		want     int
+		name        string
+		res         reaper.Result
+		maxFailures int
+		wantErr     bool
 	}{
-		{name: "unset uses fallback", set: false, fallback: 8000, want: 8000},
-		{name: "valid value", value: "9999", set: true, fallback: 8000, want: 9999},
-		{name: "negative value", value: "-1", set: true, fallback: 8000, want: -1},
-		{name: "malformed value uses fallback", value: "abc", set: true, fallback: 8000, want: 8000},
-		{name: "trailing garbage uses fallback", value: "12x", set: true, fallback: 8000, want: 8000},
-		{name: "empty value uses fallback", value: "", set: true, fallback: 8000, want: 8000},
+		{name: "no failures", res: reaper.Result{Attempted: 3, Reaped: 3}, wantErr: false},
+		{name: "failure with zero threshold", res: reaper.Result{Attempted: 3, Reaped: 2, Failed: 1}, wantErr: true},
+		{name: "failures within threshold", res: reaper.Result{Attempted: 3, Reaped: 1, Failed: 2}, maxFailures: 2},
+		{name: "failures above threshold", res: reaper.Result{Attempted: 3, Failed: 3}, maxFailures: 2, wantErr: true},
+		{name: "skipped cycle", res: reaper.Result{Skipped: true}, wantErr: false},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			key := "TEST_ENV_INT"
-			if tt.set {
-				t.Setenv(key, tt.value)
-			}
-			got := envInt(slog.Default(), key, tt.fallback)
-			if got != tt.want {
-				t.Errorf("expected %d, got %d", tt.want, got)
+			err := checkReapResult(tt.res, tt.maxFailures)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error=%v, got %v", tt.wantErr, err)
 			}
 		})
 	}
 }
 
-func TestEnvDuration(t *testing.T) {
-	tests := []struct {
-		name     string
-		value    string
-		set      bool
-		fallback time.Duration
-		want     time.Duration
-	}{
-		{name: "unset uses fallback", set: false, fallback: time.Hour, want: time.Hour},
-		{name: "valid value", value: "30m", set: true, fallback: time.Hour, want: 30 * time.Minute},
-		{name: "malformed value uses fallback", value: "abc", set: true, fallback: time.Hour, want: time.Hour},
-		{name: "bare number uses fallback", value: "30", set: true, fallback: time.Hour, want: time.Hour},
-		{name: "empty value uses fallback", value: "", set: true, fallback: time.Hour, want: time.Hour},
+func TestListImageRecords(t *testing.T) {
+	store := memstore.New()
+	meta := redisclient.ImageMeta{Actor: "ci-bot", SourceAddr: "10.0.0.7:43122", UserAgent: "docker/27.0"}
+	_ = store.TrackImage(t.Context(), "late:2h", time.Now().Add(2*time.Hour), 2, "sha256:b", redisclient.ImageMeta{})
+	_ = store.TrackImage(t.Context(), "early:1h", time.Now().Add(time.Hour), 1, "sha256:a", meta)
+
+	owned, _ := owners.Parse([]string{"early=#team-early"})
+	records, err := listImageRecords(t.Context(), store, owned)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 2 || records[0].Image != "early:1h" {
+		t.Fatalf("expected records sorted by expiry, got %+v", records)
+	}
+	if records[0].Owner != "#team-early" || records[1].Owner != "" {
+		t.Errorf("unexpected owners %q, %q", records[0].Owner, records[1].Owner)
+	}
+	if records[0].ImageMeta != meta {
+		t.Errorf("expected metadata %+v, got %+v", meta, records[0].ImageMeta)
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			key := "TEST_ENV_DURATION"
-			if tt.set {
-				t.Setenv(key, tt.value)
-			}
-			got := envDuration(slog.Default(), key, tt.fallback)
-			if got != tt.want {
-				t.Errorf("expected %s, got %s", tt.want, got)
-			}
-		})
+	out, _ := json.Marshal(records[0])
+	if !strings.Contains(string(out), `"actor":"ci-bot"`) {
+		t.Errorf("expected flattened actor field in JSON, got %s", out)
 	}
 }
 
-func TestRunServers_ReturnsPromptlyWhenIdle(t *testing.T) {
-	srv := &http.Server{Handler: http.NewServeMux(), ReadHeaderTimeout: time.Second}
-	internalSrv := &http.Server{Handler: http.NewServeMux(), ReadHeaderTimeout: time.Second}
+func TestApplyFreeze(t *testing.T) {
+	store := memstore.New()
+	if _, err := applyFreeze(t.Context(), store, "team/*", "audit", "48h"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	freezes, err := applyFreeze(t.Context(), store, "", "incident", "1h")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(freezes) != 2 || freezes[0].Pattern != "" || freezes[1].Reason != "audit" {
+		t.Fatalf("expected global and team freezes sorted by pattern, got %+v", freezes)
+	}
+	if until := time.Until(freezes[1].Until); until < 47*time.Hour || until > 48*time.Hour {
+		t.Errorf("team freeze lasts %v, want 48h", until)
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	runDone := make(chan error, 1)
-	go func() {
-		runDone <- runServers(ctx, slog.Default(), srv, internalSrv, newTestListener(t), newTestListener(t))
-	}()
+	if list, _ := applyFreeze(t.Context(), store, "", "", ""); len(list) != 2 {
+		t.Errorf("listing without --for should not add a freeze, got %+v", list)
+	}
+	if _, err := applyFreeze(t.Context(), store, "team/*", "", "forever"); err == nil {
+		t.Error("expected error for invalid duration")
+	}
+}
 
-	cancel()
+func TestListTokens(t *testing.T) {
+	store := memstore.New()
+	for _, name := range []string{"dashboard", "ci"} {
+		if _, err := apiauth.Create(t.Context(), store, name, redisclient.RoleReadOnly, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tokens, err := listTokens(t.Context(), store)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tokens) != 2 || tokens[0].Name != "ci" || tokens[0].Hash != "" {
+		t.Errorf("expected tokens sorted by name without hashes, got %+v", tokens)
+	}
+}
 
-	select {
-	case err := <-runDone:
-		if err != nil {
-			t.Fatalf("runServers returned error: %v", err)
+func TestCheckSchema(t *testing.T) {
+	store := memstore.New()
+	if v, err := checkSchema(t.Context(), store); err != nil || v != 0 {
+		t.Errorf("unversioned store: checkSchema = %d, %v; want 0, nil", v, err)
+	}
+	_ = store.SetSchemaVersion(t.Context(), redisclient.CurrentSchema)
+	if _, err := checkSchema(t.Context(), store); err != nil {
+		t.Errorf("current schema: %v", err)
+	}
+	_ = store.SetSchemaVersion(t.Context(), redisclient.CurrentSchema+1)
+	if _, err := checkSchema(t.Context(), store); err == nil {
+		t.Error("expected error for a store written by a newer schema")
+	}
+}
+
+func TestRunInit(t *testing.T) {
+	store := memstore.New()
+	recovered := 0
+	opts := initOptions{
+		adminToken: "admin",
+		rules: map[string][]seedRule{
+			redisclient.RuleRepoPolicy: {{Pattern: "ci/*", DefaultTTL: "1d"}},
+			redisclient.RuleProtected:  {{Pattern: "base/*:stable"}},
+		},
+		recover: func(context.Context) error { recovered++; return nil },
+	}
+
+	res, err := runInit(t.Context(), store, opts)
+	if err != nil {
+		t.Fatalf("runInit: %v", err)
+	}
+	for _, s := range res.Steps {
+		if s.Status != initCreated {
+			t.Errorf("first run: step %+v, want created", s)
 		}
-	case <-time.After(5 * time.Second):
-		t.Fatal("runServers did not return after context cancellation")
+	}
+	if res.AdminToken == "" || recovered != 1 {
+		t.Errorf("first run: admin token %q, recovered %d times; want a secret and one recovery", res.AdminToken, recovered)
+	}
+
+	res, err = runInit(t.Context(), store, opts)
+	if err != nil {
+		t.Fatalf("re-run runInit: %v", err)
+	}
+	for _, s := range res.Steps {
+		if s.Status != initExists {
+			t.Errorf("re-run: step %+v, want exists", s)
+		}
+	}
+	if res.AdminToken != "" || recovered != 1 {
+		t.Errorf("re-run: admin token %q, recovered %d times; want neither again", res.AdminToken, recovered)
+	}
+
+	_ = store.SetSchemaVersion(t.Context(), redisclient.CurrentSchema+1)
+	if _, err := runInit(t.Context(), store, opts); err == nil {
+		t.Error("expected error for a store written by a newer schema")
+	}
+}
+
+func TestSetupLoggerTo_TraceLevel(t *testing.T) {
+	for _, format := range []string{"json", "text"} {
+		var buf strings.Builder
+		setupLoggerTo(&buf, format).Log(context.Background(), hooks.LevelTrace, "push decision trace")
+		if !strings.Contains(buf.String(), "TRACE") {
+			t.Errorf("%s log = %q, want the TRACE level", format, buf.String())
+		}
+	}
+}
+
+func TestListen_UnixSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "ephemeron")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "ephemeron.sock")
+
+	ln, err := listen("unix://"+socket, 8000)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	if _, err := listen("unix://"+socket, 8000); err == nil {
+		t.Fatal("expected an error for a socket in use")
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = ln.Close()
+
+	ln, err = listen("unix://"+socket, 8000)
+	if err != nil {
+		t.Fatalf("listen over a stale socket: %v", err)
+	}
+	_ = ln.Close()
+
+	if err := os.WriteFile(socket, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listen("unix://"+socket, 8000); err == nil {
+		t.Fatal("expected an error for a regular file at the socket path")
 	}
 }
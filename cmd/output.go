@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v2"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// addOutputFlag registers the --output flag on cmd and returns a pointer to
+// its value.
+func addOutputFlag(cmd *cobra.Command) *string {
+	out := new(string)
+	cmd.Flags().StringVarP(out, "output", "o", outputTable, "Output format: table, json, or yaml")
+	_ = cmd.RegisterFlagCompletionFunc("output", cobra.FixedCompletions(
+		[]string{outputTable, outputJSON, outputYAML}, cobra.ShellCompDirectiveNoFileComp,
+	))
+	return out
+}
+
+// validateOutput returns an error for unsupported output formats.
+func validateOutput(format string) error {
+	switch format {
+	case outputTable, outputJSON, outputYAML:
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format %q (want table, json, or yaml)", format)
+	}
+}
+
+// render writes v to w in the requested format. For table output, the
+// table callback receives a tabwriter that is flushed afterwards.
+func render(w io.Writer, format string, v any, table func(tw *tabwriter.Writer)) error {
+	switch format {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case outputYAML:
+		out, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(out)
+		return err
+	case outputTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		table(tw)
+		return tw.Flush()
+	default:
+		return validateOutput(format)
+	}
+}
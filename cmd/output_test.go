@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"text/tabwriter"
+)
+
+func TestRender(t *testing.T) {
+	v := versionInfo{Version: "v1.2.3", Commit: "abc"}
+	table := func(tw *tabwriter.Writer) {
+		_, _ = fmt.Fprintln(tw, "VERSION\tCOMMIT")
+		_, _ = fmt.Fprintf(tw, "%s\t%s\n", v.Version, v.Commit)
+	}
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := render(&buf, outputJSON, v, table); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var got versionInfo
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("invalid json output: %v", err)
+		}
+		if got != v {
+			t.Errorf("expected %+v, got %+v", v, got)
+		}
+	})
+
+	t.Run("yaml", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := render(&buf, outputYAML, v, table); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(buf.String(), "version: v1.2.3") {
+			t.Errorf("unexpected yaml output: %q", buf.String())
+		}
+	})
+
+	t.Run("table", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := render(&buf, outputTable, v, table); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.HasPrefix(buf.String(), "VERSION") {
+			t.Errorf("unexpected table output: %q", buf.String())
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		if err := render(&bytes.Buffer{}, "xml", v, table); err == nil {
+			t.Error("expected error for unsupported format")
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tamcore/ephemeron/internal/archive"
+	"github.com/tamcore/ephemeron/internal/config"
+	"github.com/tamcore/ephemeron/internal/reaper"
+	"github.com/tamcore/ephemeron/internal/schedule"
+)
+
+func reapCmd() *cobra.Command {
+	var output *string
+	cmd := &cobra.Command{
+		Use:          "reap",
+		Short:        "Run a single reap cycle (for CronJob or debugging)",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(*output); err != nil {
+				return err
+			}
+			logger := setupCLILogger(config.LogFormat("json"), *output)
+			cfg := newConfig(logger)
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+
+			rdb, err := newStore(cfg)
+			if err != nil {
+				return fmt.Errorf("connecting to store: %w", err)
+			}
+			defer func() { _ = rdb.Close() }()
+
+			ctx := context.Background()
+			ruleSet := newRules(rdb, cfg, logger)
+			if err := ruleSet.Refresh(ctx); err != nil {
+				return err
+			}
+			reg := newRegistryClient(cfg)
+			reaperOpts := []reaper.Option{
+				reaper.WithRegistryClient(reg),
+				reaper.WithProtection(ruleSet),
+				reaper.WithAdaptivePacing(cfg.ReapLatencyThreshold, cfg.ReapPacingDelay),
+				reaper.WithDeleteVerification(cfg.ReapVerifyDeletes),
+				reaper.WithDigestStrategy(cfg.ReapDigestStrategy),
+				reaper.WithOverwritePolicy(cfg.ReapOverwritePolicy, cfg.DefaultTTL,
+					ttlLimits(rdb, reg, cfg, ruleSet, logger).MaxTTL),
+				reaper.WithTombstones(cfg.TombstoneRetention),
+				reaper.WithRestore(cfg.RestoreEnabled),
+				reaper.WithArchive(archive.New(cfg.Archive)),
+				reaper.WithQuarantine(cfg.ReapQuarantineAfter),
+				reaper.WithCycleBudget(cfg.ReapMaxDeletes, cfg.ReapMaxDuration),
+				reaper.WithRepoCleanup(newRepoCleaner(cfg)),
+				reaper.WithDeleteHooks(newDeleteHooks(cfg)),
+			}
+			if cfg.KubeScan {
+				scanner, err := newWorkloadScanner(cfg, logger)
+				if err != nil {
+					return fmt.Errorf("creating workload scanner: %w", err)
+				}
+				if err := scanner.Scan(ctx); err != nil {
+					return fmt.Errorf("scanning workloads: %w", err)
+				}
+				reaperOpts = append(reaperOpts, reaper.WithWorkloadReferences(scanner))
+			}
+			r := reaper.New(rdb, cfg.RegistryURL, logger.With("component", "reaper"), reaperOpts...)
+			res, err := r.Reap(ctx)
+			if err != nil {
+				return err
+			}
+
+			err = render(cmd.OutOrStdout(), *output, res, func(tw *tabwriter.Writer) {
+				_, _ = fmt.Fprintln(tw, "TOTAL\tATTEMPTED\tREAPED\tFAILED\tSKIPPED")
+				_, _ = fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%t\n",
+					res.Total, res.Attempted, res.Reaped, res.Failed, res.Skipped)
+			})
+			if err != nil {
+				return err
+			}
+			return checkReapResult(res, cfg.ReapMaxFailures)
+		},
+	}
+	output = addOutputFlag(cmd)
+	return cmd
+}
+
+// checkReapResult returns an error when more deletions failed than allowed,
+// so the one-shot reap command exits non-zero and CronJobs surface failures.
+func checkReapResult(res reaper.Result, maxFailures int) error {
+	if res.Failed > maxFailures {
+		return fmt.Errorf("%d of %d deletions failed (max allowed: %d)", res.Failed, res.Attempted, maxFailures)
+	}
+	return nil
+}
+
+// reapSchedule returns when the reaper runs: at REAP_SCHEDULE if set and
+// every REAP_INTERVAL otherwise, delayed by up to REAP_JITTER.
+func reapSchedule(cfg *config.Config) (schedule.Schedule, error) {
+	s := schedule.Every(cfg.ReapInterval)
+	if cfg.ReapSchedule != "" {
+		c, err := schedule.ParseCron(cfg.ReapSchedule)
+		if err != nil {
+			return nil, fmt.Errorf("REAP_SCHEDULE: %w", err)
+		}
+		s = c
+	}
+	return schedule.WithJitter(s, cfg.ReapJitter), nil
+}
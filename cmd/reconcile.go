@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tamcore/ephemeron/internal/config"
+	recoverlib "github.com/tamcore/ephemeron/internal/recover"
+)
+
+func reconcileCmd() *cobra.Command {
+	var output *string
+	cmd := &cobra.Command{
+		Use:   "reconcile",
+		Short: "Compare the registry catalog with the tracked images",
+		Long: "List the registry tags that are not tracked and will never expire, and the tracked " +
+			"images whose tag is gone from the registry. Nothing is changed.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(*output); err != nil {
+				return err
+			}
+			logger := setupCLILogger(config.LogFormat("json"), *output)
+			cfg := newConfig(logger)
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+
+			rdb, err := newStore(cfg)
+			if err != nil {
+				return fmt.Errorf("connecting to store: %w", err)
+			}
+			defer func() { _ = rdb.Close() }()
+
+			ctx := context.Background()
+			ruleSet := newRules(rdb, cfg, logger)
+			if err := ruleSet.Refresh(ctx); err != nil {
+				return err
+			}
+			rec := recoverlib.New(rdb, newRegistryClient(cfg), cfg.DefaultTTL, cfg.MaxTTL,
+				logger.With("component", "reconcile"),
+				recoverlib.WithConcurrency(cfg.ReconcileConcurrency),
+				recoverlib.WithTagListRate(cfg.ReconcileRate),
+				recoverlib.WithNonTTLTags(nonTTLTags(ruleSet, cfg)))
+
+			d, err := rec.Diff(ctx)
+			if err != nil {
+				return err
+			}
+
+			return render(cmd.OutOrStdout(), *output, d, func(tw *tabwriter.Writer) {
+				_, _ = fmt.Fprintln(tw, "KIND\tNAME")
+				for _, image := range d.Untracked {
+					_, _ = fmt.Fprintf(tw, "untracked\t%s\n", image)
+				}
+				for _, image := range d.Ghosts {
+					_, _ = fmt.Fprintf(tw, "ghost\t%s\n", image)
+				}
+				for _, repo := range d.SkippedRepositories {
+					_, _ = fmt.Fprintf(tw, "skipped\t%s\n", repo)
+				}
+			})
+		},
+	}
+	output = addOutputFlag(cmd)
+	return cmd
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tamcore/ephemeron/internal/config"
+	recoverlib "github.com/tamcore/ephemeron/internal/recover"
+)
+
+func recoverCmd() *cobra.Command {
+	var output *string
+	cmd := &cobra.Command{
+		Use:   "recover",
+		Short: "Re-populate Redis by scanning the registry catalog",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(*output); err != nil {
+				return err
+			}
+			logger := setupCLILogger(config.LogFormat("json"), *output)
+			cfg := newConfig(logger)
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+
+			rdb, err := newStore(cfg)
+			if err != nil {
+				return fmt.Errorf("connecting to store: %w", err)
+			}
+			defer func() { _ = rdb.Close() }()
+
+			ctx := context.Background()
+			reg := newRegistryClient(cfg)
+			ruleSet := newRules(rdb, cfg, logger)
+			if err := ruleSet.Refresh(ctx); err != nil {
+				return err
+			}
+			rec := recoverlib.New(rdb, reg, cfg.DefaultTTL, cfg.MaxTTL, logger.With("component", "recover"),
+				recoverlib.WithConcurrency(cfg.ReconcileConcurrency),
+				recoverlib.WithTagListRate(cfg.ReconcileRate),
+				recoverlib.WithNonTTLTags(nonTTLTags(ruleSet, cfg)))
+
+			res, err := rec.Recover(ctx)
+			if err != nil {
+				return err
+			}
+
+			if err := rdb.SetInitialized(ctx); err != nil {
+				return err
+			}
+
+			return render(cmd.OutOrStdout(), *output, res, func(tw *tabwriter.Writer) {
+				_, _ = fmt.Fprintln(tw, "REPOSITORIES\tRECOVERED\tTOTAL BYTES")
+				_, _ = fmt.Fprintf(tw, "%d\t%d\t%d\n", res.Repositories, res.Recovered, res.TotalBytes)
+			})
+		},
+	}
+	output = addOutputFlag(cmd)
+	return cmd
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tamcore/ephemeron/internal/config"
+)
+
+// reencrypter is implemented by stores that encrypt fields at rest.
+type reencrypter interface {
+	Reencrypt(ctx context.Context) (int, error)
+}
+
+func reencryptCmd() *cobra.Command {
+	var output *string
+	cmd := &cobra.Command{
+		Use:   "reencrypt",
+		Short: "Re-encrypt stored fields with the current encryption key",
+		Long: "Rewrite every encrypted field in Redis that is not encrypted with FIELD_ENCRYPTION_KEY, " +
+			"including fields written before encryption was enabled. Run it after moving the previous " +
+			"key to FIELD_ENCRYPTION_OLD_KEYS, and remove the old key once it succeeds.",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(*output); err != nil {
+				return err
+			}
+			logger := setupCLILogger(config.LogFormat("json"), *output)
+			cfg := newConfig(logger)
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			if cfg.FieldEncryptionKey == "" {
+				return errors.New("FIELD_ENCRYPTION_KEY is required")
+			}
+
+			rdb, err := newStore(cfg)
+			if err != nil {
+				return fmt.Errorf("connecting to store: %w", err)
+			}
+			defer func() { _ = rdb.Close() }()
+			r, ok := rdb.(reencrypter)
+			if !ok {
+				return fmt.Errorf("the %s store does not keep data at rest", cfg.StoreBackend)
+			}
+
+			n, err := r.Reencrypt(context.Background())
+			if err != nil {
+				return fmt.Errorf("re-encrypting after %d fields: %w", n, err)
+			}
+			res := struct {
+				Rewritten int `json:"rewritten" yaml:"rewritten"`
+			}{n}
+			return render(cmd.OutOrStdout(), *output, res, func(tw *tabwriter.Writer) {
+				_, _ = fmt.Fprintln(tw, "REWRITTEN")
+				_, _ = fmt.Fprintf(tw, "%d\n", n)
+			})
+		},
+	}
+	output = addOutputFlag(cmd)
+	return cmd
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cobra"
+
+	"github.com/tamcore/ephemeron/internal/apiauth"
+	"github.com/tamcore/ephemeron/internal/archive"
+	"github.com/tamcore/ephemeron/internal/bucketusage"
+	"github.com/tamcore/ephemeron/internal/calendar"
+	"github.com/tamcore/ephemeron/internal/config"
+	"github.com/tamcore/ephemeron/internal/export"
+	"github.com/tamcore/ephemeron/internal/harbor"
+	"github.com/tamcore/ephemeron/internal/health"
+	"github.com/tamcore/ephemeron/internal/hooks"
+	"github.com/tamcore/ephemeron/internal/imagedebug"
+	"github.com/tamcore/ephemeron/internal/journal"
+	"github.com/tamcore/ephemeron/internal/metrics"
+	"github.com/tamcore/ephemeron/internal/owners"
+	"github.com/tamcore/ephemeron/internal/policy"
+	"github.com/tamcore/ephemeron/internal/pullrequest"
+	"github.com/tamcore/ephemeron/internal/pushstats"
+	"github.com/tamcore/ephemeron/internal/reaper"
+	recoverlib "github.com/tamcore/ephemeron/internal/recover"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+	"github.com/tamcore/ephemeron/internal/report"
+	"github.com/tamcore/ephemeron/internal/slack"
+	"github.com/tamcore/ephemeron/internal/status"
+	"github.com/tamcore/ephemeron/internal/usage"
+	"github.com/tamcore/ephemeron/internal/web"
+)
+
+// journalReplayInterval is how often fail-open mode retries journaled events.
+const journalReplayInterval = 10 * time.Second
+
+// rulesRefreshInterval is how often runtime-managed rules are reloaded, so
+// changes made through another replica take effect.
+const rulesRefreshInterval = 10 * time.Second
+
+// deleteProbeRepository is the repository the delete probe deletes a
+// nonexistent manifest from.
+const deleteProbeRepository = "ephemeron/delete-probe"
+
+// policyReloadInterval is how often the Rego policy file is checked for
+// changes.
+const policyReloadInterval = 10 * time.Second
+
+func serveCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "serve",
+		Short: "Start the webhook server, reaper loop, and landing page",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := setupLogger(config.LogFormat("json"))
+			cfg := newConfig(logger)
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+
+			ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+			defer cancel()
+			return serve(ctx, cfg, logger)
+		},
+	}
+}
+
+// serve runs the webhook server, the reaper loop and the landing page with
+// cfg until ctx is cancelled.
+func serve(ctx context.Context, cfg *config.Config, logger *slog.Logger) error {
+	if cfg.Faults.Enabled {
+		logger.Warn("fault injection enabled, store and registry calls will randomly fail",
+			"fail_rate", cfg.Faults.FailRate,
+			"delay_rate", cfg.Faults.DelayRate,
+			"max_delay", cfg.Faults.MaxDelay,
+		)
+	}
+
+	secretsLogger := logger.With("component", "secrets")
+	hookToken, err := newSecret(cfg, "HOOK_TOKEN", cfg.HookToken, cfg.HookTokenRef, secretsLogger)
+	if err != nil {
+		return err
+	}
+	go hookToken.RefreshLoop(ctx, cfg.Secrets.RefreshInterval)
+	var storeOpts []redisclient.Option
+	if cfg.RedisPasswordRef != "" {
+		redisPassword, err := newSecret(cfg, "REDIS_PASSWORD", cfg.RedisPassword, cfg.RedisPasswordRef, secretsLogger)
+		if err != nil {
+			return err
+		}
+		go redisPassword.RefreshLoop(ctx, cfg.Secrets.RefreshInterval)
+		storeOpts = append(storeOpts, redisclient.WithPassword(redisPassword.Value))
+	}
+
+	rdb, err := newStore(cfg, storeOpts...)
+	if err != nil {
+		return fmt.Errorf("connecting to store: %w", err)
+	}
+	defer func() { _ = rdb.Close() }()
+
+	if err := rdb.Ping(ctx); err != nil {
+		return fmt.Errorf("store ping failed: %w", err)
+	}
+	logger.Info("connected to store", "backend", cfg.StoreBackend)
+	if _, err := checkSchema(ctx, rdb); err != nil {
+		return err
+	}
+
+	ruleSet := newRules(rdb, cfg, logger)
+	ruleSet.Start(ctx, rulesRefreshInterval)
+
+	// Auto-recover if Redis is not initialized.
+	reg := newRegistryClient(cfg)
+	rec := recoverlib.New(rdb, reg, cfg.DefaultTTL, cfg.MaxTTL, logger.With("component", "recover"),
+		recoverlib.WithConcurrency(cfg.ReconcileConcurrency),
+		recoverlib.WithTagListRate(cfg.ReconcileRate),
+		recoverlib.WithResumeWindow(cfg.ReconcileInterval),
+		recoverlib.WithBatchSize(cfg.ReconcileBatchSize),
+		recoverlib.WithNonTTLTags(nonTTLTags(ruleSet, cfg)),
+	)
+	rec.Start(ctx, cfg.ReconcileInterval)
+
+	// The probe sends a real DELETE, so it only runs when asked for.
+	if cfg.RequireDelete || cfg.DeleteProbeInterval > 0 {
+		deleteProbe := health.NewDeleteProbe(func(ctx context.Context) error {
+			return reg.ProbeDelete(ctx, deleteProbeRepository)
+		}, logger.With("component", "health"))
+		if err := deleteProbe.Start(ctx, cfg.DeleteProbeInterval, cfg.RequireDelete); err != nil {
+			return err
+		}
+	}
+
+	// Start reaper in background.
+	healthChecker := health.New(cfg.HealthFailureThreshold, logger.With("component", "health"))
+	reaperOpts := []reaper.Option{
+		reaper.WithRegistryClient(reg),
+		reaper.WithProtection(ruleSet),
+		reaper.WithHealthReporter(healthChecker),
+		reaper.WithAdaptivePacing(cfg.ReapLatencyThreshold, cfg.ReapPacingDelay),
+		reaper.WithDeleteVerification(cfg.ReapVerifyDeletes),
+		reaper.WithDigestStrategy(cfg.ReapDigestStrategy),
+		reaper.WithOverwritePolicy(cfg.ReapOverwritePolicy, cfg.DefaultTTL,
+			ttlLimits(rdb, reg, cfg, ruleSet, logger).MaxTTL),
+		reaper.WithTombstones(cfg.TombstoneRetention),
+		reaper.WithRestore(cfg.RestoreEnabled),
+		reaper.WithArchive(archive.New(cfg.Archive)),
+		reaper.WithQuarantine(cfg.ReapQuarantineAfter),
+		reaper.WithCycleBudget(cfg.ReapMaxDeletes, cfg.ReapMaxDuration),
+		reaper.WithDiskProbe(cfg.RegistryDataPath, cfg.EvictionMinFreeBytes),
+		reaper.WithRepoCleanup(newRepoCleaner(cfg)),
+		reaper.WithDeleteHooks(newDeleteHooks(cfg)),
+	}
+	if cfg.KubeScan {
+		scanner, err := newWorkloadScanner(cfg, logger)
+		if err != nil {
+			return fmt.Errorf("creating workload scanner: %w", err)
+		}
+		go scanner.RunLoop(ctx, cfg.KubeScanInterval)
+		reaperOpts = append(reaperOpts, reaper.WithWorkloadReferences(scanner))
+	}
+	r := reaper.New(rdb, cfg.RegistryURL, logger.With("component", "reaper"), reaperOpts...)
+	sched, err := reapSchedule(cfg)
+	if err != nil {
+		return err
+	}
+	r.Start(ctx, reaper.Loops{
+		Schedule:            sched,
+		SweepInterval:       cfg.SweepInterval,
+		SweepBatchSize:      cfg.SweepBatchSize,
+		SizeRepairInterval:  cfg.SizeRepairInterval,
+		SizeRepairBatchSize: cfg.SizeRepairBatchSize,
+	})
+	usage.New(rdb, cfg.UsageHistoryRetention, logger.With("component", "usage")).Start(ctx, cfg.UsageHistoryInterval)
+
+	if n, ok := rdb.(reaper.ExpiryNotifier); ok && cfg.RedisNativeExpiry {
+		if err := r.StartExpiryWatch(ctx, n); err != nil {
+			return err
+		}
+	}
+
+	// Set up public HTTP routes (webhook + landing page).
+	mux := http.NewServeMux()
+
+	tracer := hooks.NewTracer(cfg.DecisionTrace, logger.With("component", "hooks"))
+	hookOpts := append(ttlOptions(cfg, ruleSet),
+		hooks.WithMaxEvents(cfg.WebhookMaxEvents),
+		hooks.WithEventFailures(cfg.WebhookEventFailures),
+		hooks.WithClockSkew(cfg.WebhookSkewTolerance, cfg.WebhookSkewWarn),
+		hooks.WithExtendTags(cfg.ExtendTagSeparator),
+		hooks.WithBackpressure(cfg.WebhookMaxInFlight, cfg.WebhookStoreLatency, cfg.WebhookRetryAfter),
+		hooks.WithDecisionTrace(tracer),
+		hooks.WithNonTTLTags(nonTTLTags(ruleSet, cfg)),
+	)
+	if cfg.TTLTagValidation == config.TTLTagValidationObserve || cfg.TTLTagValidation == config.TTLTagValidationEnforce {
+		hookOpts = append(hookOpts, hooks.WithTTLTagValidation(cfg.TTLTagValidation == config.TTLTagValidationEnforce))
+	}
+	if cfg.HarborCreateProjects {
+		hookOpts = append(hookOpts, hooks.WithProjectProvisioning(harbor.New(
+			cfg.HarborURL, cfg.HarborUsername, cfg.HarborPassword, logger.With("component", "harbor"),
+			harbor.WithRetention(cfg.HarborProjectRetention),
+			harbor.WithPublic(cfg.HarborProjectPublic),
+			harbor.WithHTTPClient(newHarborClient(cfg)),
+		)))
+	}
+	if cfg.PolicyWebhookURL != "" {
+		hookOpts = append(hookOpts, hooks.WithPolicy(policy.New(
+			cfg.PolicyWebhookURL, cfg.PolicyWebhookToken, cfg.PolicyWebhookTimeout,
+			cfg.PolicyWebhookDefault == config.PolicyAllow, logger.With("component", "policy"),
+		)))
+	}
+	if cfg.PolicyRegoFile != "" {
+		p, err := policy.NewRego(ctx, cfg.PolicyRegoFile,
+			cfg.PolicyWebhookDefault == config.PolicyAllow, logger.With("component", "policy"))
+		if err != nil {
+			return err
+		}
+		go p.ReloadLoop(ctx, policyReloadInterval)
+		hookOpts = append(hookOpts, hooks.WithPolicy(p))
+	}
+	if cfg.PolicyCELTTL != "" || cfg.PolicyCELProtect != "" || cfg.PolicyCELPriority != "" {
+		p, err := policy.NewCEL(cfg.PolicyCELTTL, cfg.PolicyCELProtect, cfg.PolicyCELPriority,
+			logger.With("component", "policy"))
+		if err != nil {
+			return err
+		}
+		hookOpts = append(hookOpts, hooks.WithPolicy(p))
+	}
+	var statusOpts []status.Option
+	if cfg.StoreFailureMode == config.StoreFailOpen || cfg.JournalWriteAhead {
+		j, err := journal.Open(cfg.JournalPath)
+		if err != nil {
+			return err
+		}
+		statusOpts = append(statusOpts, status.WithJournal(j))
+		// Events acknowledged before a crash are replayed before new ones
+		// arrive; in fail-open mode, new ones keep being replayed.
+		var replayInterval time.Duration
+		if cfg.StoreFailureMode == config.StoreFailOpen {
+			replayInterval = journalReplayInterval
+			hookOpts = append(hookOpts, hooks.WithFailOpen(j))
+		}
+		j.Start(ctx, rdb, replayInterval, logger.With("component", "journal"))
+		if cfg.JournalWriteAhead {
+			hookOpts = append(hookOpts, hooks.WithWriteAhead(j))
+		}
+	}
+	if len(cfg.HookSourceTokens) > 0 {
+		sources, err := hooks.ParseSourceTokens(cfg.HookSourceTokens)
+		if err != nil {
+			return fmt.Errorf("HOOK_SOURCE_TOKENS: %w", err)
+		}
+		hookOpts = append(hookOpts, hooks.WithSourceTokens(sources))
+	}
+	if len(cfg.HookSources) > 0 {
+		names, err := hooks.ParseSourceNames(cfg.HookSources)
+		if err != nil {
+			return fmt.Errorf("HOOK_SOURCES: %w", err)
+		}
+		hookOpts = append(hookOpts, hooks.WithSourceNames(names))
+	}
+	hookHandler := hooks.NewHandler(
+		rdb, reg, cfg.HookToken, cfg.DefaultTTL, cfg.MaxTTL,
+		cfg.ImmutableTagPatterns,
+		logger.With("component", "hooks"),
+		append(hookOpts, hooks.WithHookTokenFunc(hookToken.Value))...,
+	)
+	mux.Handle("POST /v1/hook/registry-event", hookHandler)
+
+	evictor := hooks.EvictorFunc(func(ctx context.Context, target int64) error {
+		_, err := r.Evict(ctx, target)
+		return err
+	})
+	mux.Handle("POST /v1/hook/alertmanager", hooks.NewAlertHandler(
+		ctx, evictor, cfg.HookToken, cfg.EvictionTargetBytes, logger.With("component", "alerts"),
+		hooks.WithAlertTokenFunc(hookToken.Value),
+	))
+	if cfg.SlackSigningSecret != "" {
+		scopes, err := slack.ParseScopes(cfg.SlackUserScopes)
+		if err != nil {
+			return fmt.Errorf("SLACK_USER_SCOPES: %w", err)
+		}
+		mux.Handle("POST /v1/hook/slack", slack.NewHandler(rdb, cfg.SlackSigningSecret, scopes, cfg.MaxTTL,
+			logger.With("component", "slack"), slack.WithRules(ruleSet)))
+	}
+	if cfg.PRWebhookSecret != "" {
+		repos, err := pullrequest.ParseRepositories(cfg.PRRepositories)
+		if err != nil {
+			return fmt.Errorf("PR_REPOSITORIES: %w", err)
+		}
+		mux.Handle("POST /v1/hook/pull-request", pullrequest.NewHandler(rdb, cfg.PRWebhookSecret,
+			regexp.MustCompile(cfg.PRTagPattern), repos, logger.With("component", "pullrequest")))
+	}
+
+	templateVars, err := web.ParseTemplateVars(cfg.WebTemplateVars)
+	if err != nil {
+		return fmt.Errorf("WEB_TEMPLATE_VARS: %w", err)
+	}
+	webOpts := []web.Option{
+		web.WithBasePath(cfg.BasePath),
+		web.WithTemplateDir(cfg.WebTemplateDir),
+		web.WithTemplateVars(templateVars),
+	}
+	if cfg.OIDCIssuerURL != "" {
+		webOpts = append(webOpts, web.WithSignIn())
+	}
+	webHandler, err := web.NewHandler(cfg.Hostname, cfg.DefaultTTL, cfg.MaxTTL, version, logger.With("component", "web"),
+		webOpts...)
+	if err != nil {
+		return fmt.Errorf("creating web handler: %w", err)
+	}
+	webHandler.Register(mux)
+
+	var authOpts []apiauth.Option
+	var login *web.OIDC
+	if cfg.OIDCIssuerURL != "" {
+		groupRoles, err := web.ParseGroupRoles(cfg.OIDCGroupRoles)
+		if err != nil {
+			return fmt.Errorf("OIDC_GROUP_ROLES: %w", err)
+		}
+		redirectURL := cfg.OIDCRedirectURL
+		if redirectURL == "" {
+			redirectURL = "https://" + cfg.Hostname + cfg.BasePath + "/auth/callback"
+		}
+		login = web.NewOIDC(web.OIDCConfig{
+			IssuerURL:     cfg.OIDCIssuerURL,
+			ClientID:      cfg.OIDCClientID,
+			ClientSecret:  cfg.OIDCClientSecret,
+			RedirectURL:   redirectURL,
+			Scopes:        cfg.OIDCScopes,
+			GroupsClaim:   cfg.OIDCGroupsClaim,
+			GroupRoles:    groupRoles,
+			SessionSecret: cfg.OIDCSessionSecret,
+			SessionTTL:    cfg.OIDCSessionTTL,
+			BasePath:      cfg.BasePath,
+		}, logger.With("component", "oidc"))
+		login.Register(mux)
+		authOpts = append(authOpts, apiauth.WithSessions(login))
+	}
+
+	// Set up internal HTTP routes (probes + metrics).
+	internalMux := http.NewServeMux()
+	// The probes are served on the internal port and, with PROBE_PORT, on a
+	// listener of their own that never requires authentication.
+	probeMux := http.NewServeMux()
+	probeMux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		if !healthChecker.IsHealthy() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"status":"unhealthy","reason":"registry unreachable"}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"status":"ok"}`))
+	})
+	probeMux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := rdb.Ping(r.Context()); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"status":"not ready"}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"status":"ok"}`))
+	})
+	internalMux.Handle("GET /healthz", probeMux)
+	internalMux.Handle("GET /readyz", probeMux)
+	if cfg.ReportInterval > 0 {
+		sched := report.NewScheduler(rdb, r, prometheus.DefaultGatherer, cfg.ReportInterval,
+			logger.With("component", "report"), report.WithStoragePrice(cfg.StoragePrice))
+		go sched.Run(ctx)
+		internalMux.Handle("GET /v1/api/reports/weekly", sched.Handler())
+	}
+	if cfg.ReconcileInterval > 0 {
+		internalMux.Handle("GET /v1/api/reconcile/diff", rec.DiffHandler())
+		internalMux.Handle("GET /v1/api/reconcile/status", rec.StatusHandler())
+	}
+	internalMux.Handle("GET /v1/api/status", status.New(version, cfg.Hash(), rdb, healthChecker, r,
+		logger.With("component", "status"), statusOpts...).Handler())
+	internalMux.Handle("GET /v1/api/reap/preview", r.PreviewHandler())
+	internalMux.Handle("GET /v1/api/reap/status", r.StatusHandler())
+	internalMux.Handle("POST /v1/api/images/bulk-extend", r.BulkExtendHandler())
+	internalMux.Handle("GET /v1/api/aliases", r.AliasesHandler())
+	internalMux.Handle("GET /v1/api/images/top", r.TopImagesHandler())
+	internalMux.Handle("GET /v1/api/stats/repositories", pushstats.Handler(rdb, logger.With("component", "pushstats")))
+	internalMux.Handle("GET /v1/api/stats/usage", usage.Handler(rdb, logger.With("component", "usage")))
+	internalMux.Handle("/v1/api/debug/decision-trace", tracer.Handler())
+	if cfg.ImageDebugRateLimit > 0 {
+		internalMux.Handle("GET /v1/api/images/{image...}", imagedebug.New(rdb, reg, r, hookHandler,
+			logger.With("component", "imagedebug"), imagedebug.WithRateLimit(cfg.ImageDebugRateLimit)).Handler())
+	}
+	internalMux.Handle("GET /v1/api/expiries.ics",
+		calendar.New(rdb, cfg.Hostname, logger.With("component", "calendar")).Handler())
+	internalMux.Handle("/v1/api/freeze", r.FreezeHandler())
+	internalMux.Handle("GET /v1/api/rules", ruleSet.Handler())
+	internalMux.Handle("/v1/api/rules/{kind}", ruleSet.Handler())
+	if len(cfg.ApproverTokens) > 0 {
+		approvers, err := reaper.ParseApprovers(cfg.ApproverTokens)
+		if err != nil {
+			return fmt.Errorf("APPROVER_TOKENS: %w", err)
+		}
+		deletions := r.DeletionHandler(approvers)
+		internalMux.Handle("GET /v1/api/deletions", deletions)
+		internalMux.Handle("POST /v1/api/deletions", deletions)
+		internalMux.Handle("POST /v1/api/deletions/{id}/{action}", deletions)
+	}
+	owned, err := owners.Parse(cfg.RepoOwners)
+	if err != nil {
+		return fmt.Errorf("REPO_OWNERS: %w", err)
+	}
+	internalMux.Handle("GET /v1/api/export", export.Handler(rdb, owned, logger.With("component", "export")))
+	if cfg.Export.Bucket != "" {
+		uploader := export.NewUploader(cfg.Export, rdb, owned, logger.With("component", "export"))
+		go uploader.RunLoop(ctx, cfg.ExportInterval)
+	}
+	if cfg.TombstoneRetention > 0 {
+		internalMux.Handle("GET /v1/api/tombstones", r.TombstonesHandler())
+		if cfg.RestoreEnabled || cfg.Archive.Enabled() {
+			internalMux.Handle("POST /v1/api/tombstones/{image...}", r.RestoreHandler())
+		}
+	}
+	internalMux.Handle("GET /v1/api/quarantine", r.QuarantineHandler())
+	internalMux.Handle("DELETE /v1/api/quarantine/{image...}", r.QuarantineHandler())
+	prometheus.MustRegister(metrics.NewStoreCollector(rdb))
+	if cfg.StoragePrice > 0 {
+		prometheus.MustRegister(metrics.NewCostCollector(rdb, cfg.StoragePrice))
+	}
+	if cfg.LargestImagesMetric > 0 {
+		prometheus.MustRegister(metrics.NewLargestImagesCollector(r.LargestImageSizes, cfg.LargestImagesMetric))
+	}
+	if cfg.RegistryDataPath != "" {
+		prometheus.MustRegister(metrics.NewFilesystemCollector(cfg.RegistryDataPath))
+	}
+	if cfg.Bucket.Bucket != "" {
+		prober := bucketusage.New(cfg.Bucket, logger.With("component", "bucket"))
+		go prober.RunLoop(ctx, cfg.BucketProbeInterval)
+	}
+	internalMux.Handle("GET /metrics", promhttp.Handler())
+
+	var handler http.Handler = mux
+	var internalHandler http.Handler = internalMux
+	if cfg.APIAuth {
+		if cfg.APIAuthAllPaths {
+			authOpts = append(authOpts, apiauth.WithAllPaths(cfg.AuthExemptPaths))
+		}
+		internalHandler = apiauth.Middleware(rdb, logger.With("component", "apiauth"), internalMux, authOpts...)
+	} else {
+		internalHandler = apiauth.ReadOnly(internalMux)
+	}
+	if login != nil {
+		handler = login.CSRFProtect(handler)
+		internalHandler = login.CSRFProtect(internalHandler)
+	}
+	security := web.SecurityConfig{ContentSecurityPolicy: cfg.ContentSecurityPolicy, HSTSMaxAge: cfg.HSTSMaxAge}
+	handler = web.SecurityHeaders(security, handler)
+	internalHandler = web.SecurityHeaders(security, internalHandler)
+	handler = web.StripBasePath(cfg.BasePath, handler)
+	internalHandler = web.StripBasePath(cfg.BasePath, internalHandler)
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	internalSrv := &http.Server{
+		Handler:           internalHandler,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	ln, err := listen(cfg.ListenAddr, cfg.Port)
+	if err != nil {
+		return err
+	}
+	internalLn, err := listen(cfg.InternalListenAddr, cfg.InternalPort)
+	if err != nil {
+		return fmt.Errorf("internal server: %w", err)
+	}
+	if cfg.ProbePort > 0 {
+		probeLn, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.ProbePort))
+		if err != nil {
+			return fmt.Errorf("listening on probe port %d: %w", cfg.ProbePort, err)
+		}
+		go serveProbes(ctx, logger, probeMux, probeLn)
+	}
+
+	return runServers(ctx, logger, srv, internalSrv, ln, internalLn)
+}
+
+const shutdownTimeout = 10 * time.Second
+
+// listen listens on addr, a LISTEN_ADDR value, or on port on every interface
+// if addr is empty. A socket file left behind by an earlier process is
+// replaced; a socket something still listens on, or any other file at the
+// path, is an error.
+func listen(addr string, port int) (net.Listener, error) {
+	if addr == "" {
+		addr = strconv.Itoa(port)
+	}
+	network, address, err := config.ParseListenAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if network == "unix" {
+		if info, err := os.Lstat(address); err == nil && info.Mode().Type() == os.ModeSocket {
+			if conn, err := net.Dial(network, address); err == nil {
+				_ = conn.Close()
+				return nil, fmt.Errorf("socket %s is in use", address)
+			}
+			if err := os.Remove(address); err != nil {
+				return nil, fmt.Errorf("removing stale socket %s: %w", address, err)
+			}
+		}
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", addr, err)
+	}
+	return ln, nil
+}
+
+// serveProbes serves the health probes on ln until ctx is cancelled. Probes
+// are short, so they are not drained on shutdown.
+func serveProbes(ctx context.Context, logger *slog.Logger, probes http.Handler, ln net.Listener) {
+	srv := &http.Server{Handler: probes, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	logger.Info("starting probe server", "addr", ln.Addr().String())
+	if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+		logger.Error("probe server failed", "error", err)
+	}
+}
+
+// runServers serves both HTTP servers until the context is cancelled, then
+// shuts them down gracefully and waits for in-flight requests to drain
+// before returning.
+func runServers(
+	ctx context.Context,
+	logger *slog.Logger,
+	srv, internalSrv *http.Server,
+	ln, internalLn net.Listener,
+) error {
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		logger.Info("shutting down HTTP servers")
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer shutdownCancel()
+		_ = srv.Shutdown(shutdownCtx)
+		_ = internalSrv.Shutdown(shutdownCtx)
+	}()
+
+	go func() {
+		logger.Info("starting internal server", "addr", internalLn.Addr().String())
+		if err := internalSrv.Serve(internalLn); err != nil && err != http.ErrServerClosed {
+			logger.Error("internal server failed", "error", err)
+		}
+	}()
+
+	logger.Info("starting server", "addr", ln.Addr().String())
+	if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	// Serve only returns ErrServerClosed after Shutdown was initiated, so
+	// wait for the shutdown goroutine to finish draining both servers.
+	<-shutdownDone
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tamcore/ephemeron/internal/apiauth"
+	"github.com/tamcore/ephemeron/internal/config"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+func tokenCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "token",
+		Short: "Manage API tokens for the internal API",
+	}
+	cmd.AddCommand(tokenCreateCmd(), tokenListCmd(), tokenRevokeCmd())
+	return cmd
+}
+
+func tokenCreateCmd() *cobra.Command {
+	var role string
+	var repositories []string
+	cmd := &cobra.Command{
+		Use:   "create NAME",
+		Short: "Create an API token and print it",
+		Long: "Create an API token with the given role. The token is printed once " +
+			"and cannot be retrieved later. --repository limits the changes it may make " +
+			"to matching repositories.",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := setupCLILogger(config.LogFormat("json"), outputTable)
+			cfg := newConfig(logger)
+
+			rdb, err := newStore(cfg)
+			if err != nil {
+				return fmt.Errorf("connecting to store: %w", err)
+			}
+			defer func() { _ = rdb.Close() }()
+
+			secret, err := apiauth.Create(context.Background(), rdb, args[0], role, repositories)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintln(cmd.OutOrStdout(), secret)
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&role, "role", redisclient.RoleReadOnly,
+		"Role of the token: "+strings.Join(apiauth.Roles, ", "))
+	cmd.Flags().StringArrayVar(&repositories, "repository", nil,
+		"Glob of repositories the token may change; repeatable (default: all)")
+	return cmd
+}
+
+func tokenListCmd() *cobra.Command {
+	var output *string
+	cmd := &cobra.Command{
+		Use:          "list",
+		Short:        "List API tokens",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(*output); err != nil {
+				return err
+			}
+			logger := setupCLILogger(config.LogFormat("json"), *output)
+			cfg := newConfig(logger)
+
+			rdb, err := newStore(cfg)
+			if err != nil {
+				return fmt.Errorf("connecting to store: %w", err)
+			}
+			defer func() { _ = rdb.Close() }()
+
+			tokens, err := listTokens(context.Background(), rdb)
+			if err != nil {
+				return err
+			}
+			return render(cmd.OutOrStdout(), *output, tokens, func(tw *tabwriter.Writer) {
+				_, _ = fmt.Fprintln(tw, "NAME\tROLE\tREPOSITORIES\tCREATED")
+				for _, t := range tokens {
+					repos := strings.Join(t.Repositories, ",")
+					if repos == "" {
+						repos = "(all)"
+					}
+					_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.Name, t.Role, repos, t.CreatedAt.Format(time.RFC3339))
+				}
+			})
+		},
+	}
+	output = addOutputFlag(cmd)
+	return cmd
+}
+
+func tokenRevokeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:          "revoke NAME",
+		Short:        "Revoke an API token",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := setupLogger(config.LogFormat("json"))
+			cfg := newConfig(logger)
+
+			rdb, err := newStore(cfg)
+			if err != nil {
+				return fmt.Errorf("connecting to store: %w", err)
+			}
+			defer func() { _ = rdb.Close() }()
+
+			found, err := rdb.DeleteAPIToken(context.Background(), args[0])
+			if err != nil {
+				return fmt.Errorf("revoking token: %w", err)
+			}
+			if !found {
+				return fmt.Errorf("no token named %q", args[0])
+			}
+			logger.Info("API token revoked", "name", args[0])
+			return nil
+		},
+	}
+}
+
+// listTokens returns the API tokens sorted by name, without their hashes.
+func listTokens(ctx context.Context, store redisclient.Store) ([]redisclient.APIToken, error) {
+	tokens, err := store.ListAPITokens(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing tokens: %w", err)
+	}
+	for i := range tokens {
+		tokens[i].Hash = ""
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Name < tokens[j].Name })
+	return tokens, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/mod/semver"
+)
+
+const latestReleaseURL = "https://api.github.com/repos/tamcore/ephemeron/releases/latest"
+
+// releaseClient queries latestReleaseURL.
+var releaseClient = &http.Client{Timeout: 10 * time.Second}
+
+// versionInfo is the structured form of the version command output.
+type versionInfo struct {
+	Version   string `json:"version" yaml:"version"`
+	Commit    string `json:"commit" yaml:"commit"`
+	Date      string `json:"date" yaml:"date"`
+	GoVersion string `json:"go_version" yaml:"go_version"`
+	Latest    string `json:"latest,omitempty" yaml:"latest,omitempty"`
+	UpToDate  *bool  `json:"up_to_date,omitempty" yaml:"up_to_date,omitempty"`
+}
+
+func versionCmd() *cobra.Command {
+	var output *string
+	var checkLatest bool
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Print version information",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(*output); err != nil {
+				return err
+			}
+
+			info := versionInfo{
+				Version:   version,
+				Commit:    commit,
+				Date:      date,
+				GoVersion: runtime.Version(),
+			}
+			if checkLatest {
+				latest, err := fetchLatestRelease(cmd.Context(), releaseClient, latestReleaseURL)
+				if err != nil {
+					return fmt.Errorf("checking latest release: %w", err)
+				}
+				info.Latest = latest
+				if upToDate, ok := isUpToDate(version, latest); ok {
+					info.UpToDate = &upToDate
+				}
+			}
+
+			return render(cmd.OutOrStdout(), *output, info, func(tw *tabwriter.Writer) {
+				_, _ = fmt.Fprintf(tw, "ephemeron %s (commit: %s)\n", info.Version, info.Commit)
+				_, _ = fmt.Fprintf(tw, "Build date:\t%s\n", info.Date)
+				_, _ = fmt.Fprintf(tw, "Go version:\t%s\n", info.GoVersion)
+				if info.Latest != "" {
+					_, _ = fmt.Fprintf(tw, "Latest release:\t%s\n", info.Latest)
+				}
+				if info.UpToDate != nil && !*info.UpToDate {
+					_, _ = fmt.Fprintln(tw, "A newer release is available.")
+				}
+			})
+		},
+	}
+	output = addOutputFlag(cmd)
+	cmd.Flags().BoolVar(&checkLatest, "check-latest", false, "Query GitHub for the latest released version")
+	return cmd
+}
+
+// fetchLatestRelease returns the tag name of the latest GitHub release.
+func fetchLatestRelease(ctx context.Context, client *http.Client, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("releases request failed: status %d", resp.StatusCode)
+	}
+
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return "", fmt.Errorf("decoding release: %w", err)
+	}
+	if release.TagName == "" {
+		return "", fmt.Errorf("release has no tag name")
+	}
+	return release.TagName, nil
+}
+
+// isUpToDate reports whether current is at least latest, with or without a
+// leading "v". ok is false if either is not a semantic version, such as the
+// "dev" of local builds, as there is nothing to compare then.
+func isUpToDate(current, latest string) (upToDate, ok bool) {
+	current, latest = canonicalVersion(current), canonicalVersion(latest)
+	if !semver.IsValid(current) || !semver.IsValid(latest) {
+		return false, false
+	}
+	return semver.Compare(current, latest) >= 0, true
+}
+
+// canonicalVersion adds the leading "v" semver expects.
+func canonicalVersion(v string) string {
+	if strings.HasPrefix(v, "v") {
+		return v
+	}
+	return "v" + v
+}
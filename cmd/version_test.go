@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchLatestRelease(t *testing.T) {
+	t.Run("returns tag name", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"tag_name":"v1.4.0"}`))
+		}))
+		defer srv.Close()
+
+		got, err := fetchLatestRelease(t.Context(), srv.Client(), srv.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != "v1.4.0" {
+			t.Errorf("expected v1.4.0, got %s", got)
+		}
+	})
+
+	t.Run("non-200 status", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer srv.Close()
+
+		if _, err := fetchLatestRelease(t.Context(), srv.Client(), srv.URL); err == nil {
+			t.Error("expected error for non-200 status")
+		}
+	})
+}
+
+func TestIsUpToDate(t *testing.T) {
+	tests := []struct {
+		current, latest string
+		want, wantOK    bool
+	}{
+		{"v1.2.3", "v1.2.3", true, true},
+		{"1.2.3", "v1.2.3", true, true},
+		{"v1.2.3", "v1.3.0", false, true},
+		{"v1.10.0", "v1.9.0", true, true},
+		{"v1.3.0-rc.1", "v1.3.0", false, true},
+		{"dev", "v1.3.0", false, false},
+		{"v1.3.0", "nightly", false, false},
+	}
+	for _, tt := range tests {
+		got, ok := isUpToDate(tt.current, tt.latest)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("isUpToDate(%q, %q) = %v, %v; want %v, %v",
+				tt.current, tt.latest, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
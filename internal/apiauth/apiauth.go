@@ -0,0 +1,419 @@
+// Package apiauth authorizes requests to the internal API with tokens that
+// carry a role and, optionally, the repositories they may change. Listing
+// stays open to read-only tokens while changes require an operator or
+// admin token within scope.
+package apiauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// tokenPrefix marks ephemeron API tokens, so leaked ones are easy to spot.
+const tokenPrefix = "eph_"
+
+// Lower bounds for secrets supplied to Ensure: the characters after the
+// prefix, and the bits per character they must carry on average. Random
+// hex of that length carries about 3.6.
+const (
+	minSecretLen     = 32
+	minSecretEntropy = 3.0
+)
+
+// maxBody bounds the request body read to find the targeted repository.
+const maxBody = 1 << 20
+
+// Paths under the API prefix and how they are authorized.
+const (
+	apiPrefix = "/v1/api/"
+	// deletionsPath authenticates approvers itself.
+	deletionsPath  = "/v1/api/deletions"
+	rulesPath      = "/v1/api/rules"
+	quarantinePath = "/v1/api/quarantine/"
+	tombstonesPath = "/v1/api/tombstones/"
+)
+
+// Roles in ascending order of privilege.
+var Roles = []string{redisclient.RoleReadOnly, redisclient.RoleOperator, redisclient.RoleAdmin}
+
+// errConflictingTarget is returned by targetRepository when the query and
+// body name different repositories.
+var errConflictingTarget = errors.New("conflicting repository in query and body")
+
+// Create stores a new token named name and returns its secret, which is
+// shown only once.
+func Create(ctx context.Context, store redisclient.Store, name, role string, repos []string) (string, error) {
+	if err := validate(name, role, repos); err != nil {
+		return "", err
+	}
+	tokens, err := store.ListAPITokens(ctx)
+	if err != nil {
+		return "", fmt.Errorf("listing tokens: %w", err)
+	}
+	if slices.ContainsFunc(tokens, func(t redisclient.APIToken) bool { return t.Name == name }) {
+		return "", fmt.Errorf("token %q already exists", name)
+	}
+	return put(ctx, store, name, role, repos, "")
+}
+
+// Ensure makes sure a token named name exists, for bootstrapping that may
+// be re-run. A missing token is stored with secret, or a generated one if
+// secret is empty, and the secret is returned with created set. An
+// existing token is kept, but must have role and, if given, secret, so
+// that drift is reported rather than ignored.
+func Ensure(ctx context.Context, store redisclient.Store, name, role, secret string) (string, bool, error) {
+	if err := validate(name, role, nil); err != nil {
+		return "", false, err
+	}
+	if secret != "" {
+		if err := checkSecret(secret); err != nil {
+			return "", false, err
+		}
+	}
+	tokens, err := store.ListAPITokens(ctx)
+	if err != nil {
+		return "", false, fmt.Errorf("listing tokens: %w", err)
+	}
+	i := slices.IndexFunc(tokens, func(t redisclient.APIToken) bool { return t.Name == name })
+	if i < 0 {
+		secret, err = put(ctx, store, name, role, nil, secret)
+		return secret, err == nil, err
+	}
+	switch t := tokens[i]; {
+	case t.Role != role:
+		return "", false, fmt.Errorf("token %q exists with role %s, want %s", name, t.Role, role)
+	case secret != "" && subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash(secret))) != 1:
+		return "", false, fmt.Errorf("token %q exists with a different secret", name)
+	}
+	return "", false, nil
+}
+
+// checkSecret rejects secrets without the token prefix, and ones too short
+// or too repetitive to withstand guessing.
+func checkSecret(secret string) error {
+	body, ok := strings.CutPrefix(secret, tokenPrefix)
+	if !ok {
+		return fmt.Errorf("secret must start with %q", tokenPrefix)
+	}
+	if len(body) < minSecretLen {
+		return fmt.Errorf("secret must have at least %d characters after %q", minSecretLen, tokenPrefix)
+	}
+	counts := make(map[rune]int)
+	for _, c := range body {
+		counts[c]++
+	}
+	var entropy float64
+	for _, n := range counts {
+		p := float64(n) / float64(len(body))
+		entropy -= p * math.Log2(p)
+	}
+	if entropy < minSecretEntropy {
+		return errors.New("secret is too predictable, generate it randomly, e.g. with openssl rand -hex 32")
+	}
+	return nil
+}
+
+func validate(name, role string, repos []string) error {
+	if name == "" {
+		return errors.New("name is required")
+	}
+	if !slices.Contains(Roles, role) {
+		return fmt.Errorf("role must be one of %s", strings.Join(Roles, ", "))
+	}
+	for _, pattern := range repos {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// put stores a token with secret, generating one if it is empty, and
+// returns the secret.
+func put(
+	ctx context.Context, store redisclient.Store, name, role string, repos []string, secret string,
+) (string, error) {
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		secret = tokenPrefix + hex.EncodeToString(b)
+	}
+	err := store.PutAPIToken(ctx, redisclient.APIToken{
+		Name:         name,
+		Role:         role,
+		Repositories: repos,
+		Hash:         hash(secret),
+		CreatedAt:    time.Now().UTC(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("storing token: %w", err)
+	}
+	return secret, nil
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// rank returns the privilege level of role, -1 for unknown roles.
+func rank(role string) int {
+	return slices.Index(Roles, role)
+}
+
+// Authenticator identifies callers by other means than API tokens, such as
+// a web session, as a token with their role.
+type Authenticator interface {
+	Authenticate(r *http.Request) (redisclient.APIToken, bool)
+}
+
+// Option configures Middleware.
+type Option func(*options)
+
+type options struct {
+	sessions Authenticator
+	allPaths bool
+	exempt   []string
+}
+
+// WithSessions also accepts requests without an API token that sessions
+// authenticates.
+func WithSessions(sessions Authenticator) Option {
+	return func(o *options) { o.sessions = sessions }
+}
+
+// WithAllPaths requires a token on every path, not only on the API, except
+// on paths matching one of the exempt globs, such as health probes.
+func WithAllPaths(exempt []string) Option {
+	return func(o *options) {
+		o.allPaths = true
+		o.exempt = exempt
+	}
+}
+
+// protects reports whether requests to p need a token.
+func (o *options) protects(p string) bool {
+	if strings.HasPrefix(p, deletionsPath) {
+		return false
+	}
+	if !o.allPaths {
+		return strings.HasPrefix(p, apiPrefix)
+	}
+	return !slices.ContainsFunc(o.exempt, func(pattern string) bool {
+		ok, _ := path.Match(pattern, p)
+		return ok
+	})
+}
+
+// ReadOnly refuses every API request that could change state with 403
+// Forbidden, for an internal port served without Middleware, so that no
+// unauthenticated caller can change rules, freezes or expiries. Deletion
+// requests, which authenticate approvers themselves, are let through.
+func ReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, apiPrefix) && !readOnly(r) && !strings.HasPrefix(r.URL.Path, deletionsPath) {
+			http.Error(w, "changes through the API require API_AUTH=true", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Middleware requires a valid token on every API request, or with
+// WithAllPaths on every request, except deletion requests, which
+// authenticate approvers themselves. Reads need the
+// read-only role, changes to rules the admin role and all other changes
+// the operator role. Tokens limited to repositories may only change those.
+func Middleware(store redisclient.Store, logger *slog.Logger, next http.Handler, opts ...Option) http.Handler {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !o.protects(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok, err := authenticate(r, store, o.sessions)
+		if err != nil {
+			logger.Error("failed to list API tokens", "error", err)
+			http.Error(w, "store unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if !ok {
+			http.Error(w, "missing or invalid API token", http.StatusUnauthorized)
+			return
+		}
+
+		if rank(token.Role) < rank(requiredRole(r)) {
+			logger.Warn("API request denied", "token", token.Name, "role", token.Role,
+				"method", r.Method, "path", r.URL.Path)
+			http.Error(w, "insufficient role", http.StatusForbidden)
+			return
+		}
+		if len(token.Repositories) > 0 && !readOnly(r) {
+			target, err := targetRepository(r)
+			if errors.Is(err, errConflictingTarget) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if !inScope(token.Repositories, target) {
+				logger.Warn("API request outside token scope", "token", token.Name, "repository", target,
+					"method", r.Method, "path", r.URL.Path)
+				http.Error(w, "repository outside token scope", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate returns the token of the bearer token in r or, without one,
+// of the caller's session.
+func authenticate(
+	r *http.Request,
+	store redisclient.Store,
+	sessions Authenticator,
+) (redisclient.APIToken, bool, error) {
+	secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || secret == "" {
+		if sessions == nil {
+			return redisclient.APIToken{}, false, nil
+		}
+		token, ok := sessions.Authenticate(r)
+		return token, ok, nil
+	}
+	tokens, err := store.ListAPITokens(r.Context())
+	if err != nil {
+		return redisclient.APIToken{}, false, err
+	}
+	token, ok := lookup(tokens, secret)
+	return token, ok, nil
+}
+
+// lookup returns the token whose hash matches secret.
+func lookup(tokens []redisclient.APIToken, secret string) (redisclient.APIToken, bool) {
+	h := []byte(hash(secret))
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare(h, []byte(t.Hash)) == 1 {
+			return t, true
+		}
+	}
+	return redisclient.APIToken{}, false
+}
+
+func readOnly(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
+// requiredRole returns the least privileged role allowed to make r.
+func requiredRole(r *http.Request) string {
+	switch {
+	case readOnly(r):
+		return redisclient.RoleReadOnly
+	case strings.HasPrefix(r.URL.Path, rulesPath):
+		return redisclient.RoleAdmin
+	default:
+		return redisclient.RoleOperator
+	}
+}
+
+// targetRepository returns the repository or repository pattern a change
+// applies to: the image in the path of a quarantine release or restore, or
+// the "repository" or "pattern" of the query or JSON body. Handlers read
+// either source, so every value given must agree; otherwise the request is
+// rejected with errConflictingTarget. It is empty when the change is not
+// limited to a repository, such as a global freeze. The body is restored for
+// next.
+func targetRepository(r *http.Request) (string, error) {
+	for _, prefix := range []string{quarantinePath, tombstonesPath} {
+		if image, ok := strings.CutPrefix(r.URL.Path, prefix); ok {
+			repo, _, _ := strings.Cut(image, ":")
+			return repo, nil
+		}
+	}
+	var targets []string
+	for _, key := range []string{"repository", "pattern"} {
+		if v := r.URL.Query().Get(key); v != "" {
+			targets = append(targets, v)
+		}
+	}
+	if r.Body != nil {
+		fields, err := bodyTargets(r)
+		if err != nil {
+			return "", err
+		}
+		targets = append(targets, fields...)
+	}
+	var target string
+	for _, v := range targets {
+		if target != "" && v != target {
+			return "", errConflictingTarget
+		}
+		target = v
+	}
+	return target, nil
+}
+
+// bodyTargets returns the non-empty "repository" and "pattern" fields of the
+// JSON body of r, restoring the body for next.
+func bodyTargets(r *http.Request) ([]string, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxBody {
+		return nil, errors.New("body too large")
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	var fields struct {
+		Repository string `json:"repository"`
+		Pattern    string `json:"pattern"`
+	}
+	_ = json.Unmarshal(body, &fields)
+	var targets []string
+	for _, v := range []string{fields.Repository, fields.Pattern} {
+		if v != "" {
+			targets = append(targets, v)
+		}
+	}
+	return targets, nil
+}
+
+// inScope reports whether target, a repository or repository pattern, is
+// covered by one of patterns. A pattern target is covered when it is
+// matched as a literal, e.g. "team/*" by "team/*" but not by "team/app".
+func inScope(patterns []string, target string) bool {
+	if target == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
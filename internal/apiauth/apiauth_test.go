@@ -0,0 +1,249 @@
+package apiauth
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// newServer returns the middleware in front of a handler echoing the body,
+// and tokens created for each role plus a token scoped to team-a.
+func newServer(t *testing.T) (http.Handler, map[string]string) {
+	t.Helper()
+	store := memstore.New()
+	tokens := make(map[string]string)
+	for name, role := range map[string]string{
+		"reader":   redisclient.RoleReadOnly,
+		"operator": redisclient.RoleOperator,
+		"admin":    redisclient.RoleAdmin,
+	} {
+		secret, err := Create(t.Context(), store, name, role, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[name] = secret
+	}
+	secret, err := Create(t.Context(), store, "team-a", redisclient.RoleOperator, []string{"team-a/*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens["team-a"] = secret
+
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { _, _ = io.Copy(w, r.Body) })
+	return Middleware(store, slog.New(slog.DiscardHandler), echo), tokens
+}
+
+func do(h http.Handler, method, target, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddleware_Roles(t *testing.T) {
+	h, tokens := newServer(t)
+	for _, tc := range []struct {
+		name, method, target, token string
+		want                        int
+	}{
+		{"missing token", http.MethodGet, "/v1/api/aliases", "", http.StatusUnauthorized},
+		{"unknown token", http.MethodGet, "/v1/api/aliases", "eph_nope", http.StatusUnauthorized},
+		{"reader reads", http.MethodGet, "/v1/api/reap/preview", tokens["reader"], http.StatusOK},
+		{"reader changes", http.MethodPost, "/v1/api/freeze", tokens["reader"], http.StatusForbidden},
+		{"operator changes", http.MethodPost, "/v1/api/freeze", tokens["operator"], http.StatusOK},
+		{"operator changes rules", http.MethodPost, "/v1/api/rules/protected", tokens["operator"],
+			http.StatusForbidden},
+		{"admin changes rules", http.MethodPost, "/v1/api/rules/protected", tokens["admin"], http.StatusOK},
+		{"deletions authenticate themselves", http.MethodPost, "/v1/api/deletions", "", http.StatusOK},
+		{"health stays open", http.MethodGet, "/healthz", "", http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if rec := do(h, tc.method, tc.target, tc.token, "{}"); rec.Code != tc.want {
+				t.Errorf("expected %d, got %d", tc.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestMiddleware_RepositoryScope(t *testing.T) {
+	h, tokens := newServer(t)
+	for _, tc := range []struct {
+		name, method, target, body string
+		want                       int
+	}{
+		{"read anything", http.MethodGet, "/v1/api/aliases", "", http.StatusOK},
+		{"bulk extend in scope", http.MethodPost, "/v1/api/images/bulk-extend",
+			`{"repository":"team-a/app","extend":"1d"}`, http.StatusOK},
+		{"bulk extend out of scope", http.MethodPost, "/v1/api/images/bulk-extend",
+			`{"repository":"team-b/app","extend":"1d"}`, http.StatusForbidden},
+		{"freeze own pattern", http.MethodPost, "/v1/api/freeze", `{"pattern":"team-a/*","for":"1h"}`,
+			http.StatusOK},
+		{"freeze everything", http.MethodPost, "/v1/api/freeze", `{"for":"1h"}`, http.StatusForbidden},
+		{"broader pattern", http.MethodDelete, "/v1/api/freeze?pattern=team-*", "", http.StatusForbidden},
+		{"query and body disagree", http.MethodPost, "/v1/api/images/bulk-extend?repository=team-a/app",
+			`{"repository":"*","extend":"1d"}`, http.StatusBadRequest},
+		{"query and body agree", http.MethodPost, "/v1/api/images/bulk-extend?repository=team-a/app",
+			`{"repository":"team-a/app","extend":"1d"}`, http.StatusOK},
+		{"release quarantined", http.MethodDelete, "/v1/api/quarantine/team-a/app:1h", "", http.StatusOK},
+		{"release other quarantined", http.MethodDelete, "/v1/api/quarantine/team-b/app:1h", "",
+			http.StatusForbidden},
+		{"restore", http.MethodPost, "/v1/api/tombstones/team-a/app:1h", "", http.StatusOK},
+		{"restore other", http.MethodPost, "/v1/api/tombstones/team-b/app:1h", "", http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := do(h, tc.method, tc.target, tokens["team-a"], tc.body)
+			if rec.Code != tc.want {
+				t.Fatalf("expected %d, got %d", tc.want, rec.Code)
+			}
+			if rec.Code == http.StatusOK && rec.Body.String() != tc.body {
+				t.Errorf("expected body %q to reach the handler, got %q", tc.body, rec.Body)
+			}
+		})
+	}
+}
+
+func TestCreate_Invalid(t *testing.T) {
+	store := memstore.New()
+	if _, err := Create(t.Context(), store, "ci", "root", nil); err == nil {
+		t.Error("expected error for unknown role")
+	}
+	if _, err := Create(t.Context(), store, "ci", redisclient.RoleAdmin, []string{"["}); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+	if _, err := Create(t.Context(), store, "ci", redisclient.RoleAdmin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := Create(t.Context(), store, "ci", redisclient.RoleAdmin, nil); err == nil {
+		t.Error("expected error for duplicate name")
+	}
+}
+
+func TestEnsure(t *testing.T) {
+	store := memstore.New()
+	const secret = "eph_4f9c2a7e1b8d3065c9e2f1a7b4d80c3e"
+
+	got, created, err := Ensure(t.Context(), store, "admin", redisclient.RoleAdmin, secret)
+	if err != nil || !created || got != secret {
+		t.Fatalf("Ensure = %q, %v, %v; want %q, true, nil", got, created, err, secret)
+	}
+	got, created, err = Ensure(t.Context(), store, "admin", redisclient.RoleAdmin, secret)
+	if err != nil || created || got != "" {
+		t.Fatalf("re-run Ensure = %q, %v, %v; want \"\", false, nil", got, created, err)
+	}
+	const other = "eph_b3e8f0a1c7d2946e5b1f8c0a3d7e2964"
+	if _, _, err := Ensure(t.Context(), store, "admin", redisclient.RoleAdmin, other); err == nil {
+		t.Error("expected error for a different secret")
+	}
+	for _, weak := range []string{"eph_bootstrap", "eph_" + strings.Repeat("ab", 20)} {
+		if _, _, err := Ensure(t.Context(), store, "ci", redisclient.RoleAdmin, weak); err == nil {
+			t.Errorf("expected error for the weak secret %q", weak)
+		}
+	}
+	if _, _, err := Ensure(t.Context(), store, "admin", redisclient.RoleOperator, ""); err == nil {
+		t.Error("expected error for a different role")
+	}
+	if _, _, err := Ensure(t.Context(), store, "ci", redisclient.RoleAdmin, "not-a-token"); err == nil {
+		t.Error("expected error for a secret without the token prefix")
+	}
+
+	h := Middleware(store, slog.New(slog.DiscardHandler), http.NotFoundHandler())
+	if rec := do(h, http.MethodDelete, "/v1/api/rules/protected?pattern=x", secret, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("seeded secret: status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestMiddleware_AllPaths(t *testing.T) {
+	store := memstore.New()
+	secret, err := Create(t.Context(), store, "reader", redisclient.RoleReadOnly, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	scoped := Middleware(store, slog.New(slog.DiscardHandler), ok)
+	global := Middleware(store, slog.New(slog.DiscardHandler), ok, WithAllPaths([]string{"/healthz", "/readyz"}))
+
+	tests := []struct {
+		name  string
+		h     http.Handler
+		path  string
+		token string
+		want  int
+	}{
+		{"metrics open by default", scoped, "/metrics", "", http.StatusOK},
+		{"metrics protected", global, "/metrics", "", http.StatusUnauthorized},
+		{"metrics with token", global, "/metrics", secret, http.StatusOK},
+		{"probe exempt", global, "/healthz", "", http.StatusOK},
+		{"api still protected", global, "/v1/api/rules", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := do(tt.h, http.MethodGet, tt.path, tt.token, ""); rec.Code != tt.want {
+				t.Errorf("GET %s: status %d, want %d", tt.path, rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+// sessionFunc adapts a function to Authenticator.
+type sessionFunc func(r *http.Request) (redisclient.APIToken, bool)
+
+func (f sessionFunc) Authenticate(r *http.Request) (redisclient.APIToken, bool) { return f(r) }
+
+func TestMiddleware_Sessions(t *testing.T) {
+	sessions := sessionFunc(func(r *http.Request) (redisclient.APIToken, bool) {
+		if _, err := r.Cookie("session"); err != nil {
+			return redisclient.APIToken{}, false
+		}
+		return redisclient.APIToken{Name: "oidc:alice", Role: redisclient.RoleReadOnly}, true
+	})
+	ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	h := Middleware(memstore.New(), slog.New(slog.DiscardHandler), ok, WithSessions(sessions))
+
+	for _, tc := range []struct {
+		method string
+		want   int
+	}{
+		{http.MethodGet, http.StatusOK},
+		{http.MethodPost, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(tc.method, "/v1/api/freeze", strings.NewReader("{}"))
+		req.AddCookie(&http.Cookie{Name: "session", Value: "x"})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s with session: expected %d, got %d", tc.method, tc.want, rec.Code)
+		}
+	}
+	if rec := do(h, http.MethodGet, "/v1/api/freeze", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without session, got %d", rec.Code)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	h := ReadOnly(http.NotFoundHandler())
+	for _, tc := range []struct {
+		method, target string
+		want           int
+	}{
+		{http.MethodGet, "/v1/api/rules", http.StatusNotFound},
+		{http.MethodHead, "/v1/api/status", http.StatusNotFound},
+		{http.MethodPost, "/v1/api/freeze", http.StatusForbidden},
+		{http.MethodPut, "/v1/api/debug/decision-trace", http.StatusForbidden},
+		{http.MethodDelete, "/v1/api/quarantine/app:1h", http.StatusForbidden},
+		{http.MethodPost, "/v1/api/deletions", http.StatusNotFound},
+		{http.MethodPost, "/other", http.StatusNotFound},
+	} {
+		if rec := do(h, tc.method, tc.target, "", ""); rec.Code != tc.want {
+			t.Errorf("%s %s: status %d, want %d", tc.method, tc.target, rec.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Package archive keeps the manifests and config blobs of deleted images,
+// keyed by digest, in an S3-compatible bucket or a local directory. Unlike
+// tombstones they survive the registry's garbage collection, for
+// provenance audits and restoring images whose content is gone.
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned by Get for keys that were never archived.
+var ErrNotFound = errors.New("not in archive")
+
+// Config selects where content is archived. Dir and Bucket are mutually
+// exclusive; with neither set archiving is disabled.
+type Config struct {
+	// Dir is a local directory, e.g. a mounted volume.
+	Dir string
+	// Endpoint is the storage API base URL, e.g. https://s3.eu-central-1.amazonaws.com
+	// or https://storage.googleapis.com.
+	Endpoint string
+	// Bucket is the bucket name.
+	Bucket string
+	// Prefix is prepended to the object keys.
+	Prefix string
+	// Region is the SigV4 signing region; "auto" works for GCS.
+	Region string
+	// AccessKeyID and SecretAccessKey are the HMAC credentials.
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// Enabled reports whether cfg configures an archive.
+func (cfg Config) Enabled() bool {
+	return cfg.Dir != "" || cfg.Bucket != ""
+}
+
+// Archive stores content by key.
+type Archive interface {
+	Put(ctx context.Context, key string, body []byte) error
+	// Get returns the content stored under key, or an error wrapping
+	// ErrNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// New returns the archive cfg configures, or nil if it is disabled.
+func New(cfg Config) Archive {
+	switch {
+	case cfg.Dir != "":
+		return Dir(cfg.Dir)
+	case cfg.Bucket != "":
+		return NewBucket(cfg)
+	}
+	return nil
+}
+
+// ManifestKey is the key of the manifest with the given digest.
+func ManifestKey(digest string) string {
+	return "manifests/" + strings.Replace(digest, ":", "/", 1)
+}
+
+// BlobKey is the key of the blob with the given digest.
+func BlobKey(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+// Dir archives content as files below a local directory.
+type Dir string
+
+// Put writes body to the file for key, replacing it atomically.
+func (d Dir) Put(_ context.Context, key string, body []byte) error {
+	name, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(body); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// Get reads the file for key.
+func (d Dir) Get(_ context.Context, key string) ([]byte, error) {
+	name, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	body, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	return body, err
+}
+
+// path returns the file name of key, refusing keys that leave the directory.
+func (d Dir) path(key string) (string, error) {
+	if !filepath.IsLocal(key) {
+		return "", fmt.Errorf("invalid archive key %q", key)
+	}
+	return filepath.Join(string(d), filepath.FromSlash(key)), nil
+}
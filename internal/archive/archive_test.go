@@ -0,0 +1,109 @@
+package archive
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestKeys(t *testing.T) {
+	if got := ManifestKey("sha256:abc"); got != "manifests/sha256/abc" {
+		t.Errorf("ManifestKey = %q", got)
+	}
+	if got := BlobKey("sha256:abc"); got != "blobs/sha256/abc" {
+		t.Errorf("BlobKey = %q", got)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if New(Config{}) != nil {
+		t.Error("expected no archive without Dir or Bucket")
+	}
+	if _, ok := New(Config{Dir: t.TempDir()}).(Dir); !ok {
+		t.Error("expected Dir")
+	}
+	if _, ok := New(Config{Bucket: "b", Endpoint: "http://s3"}).(*Bucket); !ok {
+		t.Error("expected Bucket")
+	}
+}
+
+func TestDir(t *testing.T) {
+	d := Dir(t.TempDir())
+	key := ManifestKey("sha256:abc")
+	if _, err := d.Get(t.Context(), key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := d.Put(t.Context(), key, []byte("{}")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	body, err := d.Get(t.Context(), key)
+	if err != nil || string(body) != "{}" {
+		t.Errorf("Get = %q, %v", body, err)
+	}
+	if err := d.Put(t.Context(), "../escape", nil); err == nil {
+		t.Error("expected key outside the directory to be refused")
+	}
+}
+
+func TestBucket(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") == "" {
+			t.Error("expected signed request")
+		}
+		switch r.Method {
+		case http.MethodPut:
+			objects[r.URL.Path], _ = io.ReadAll(r.Body)
+		case http.MethodGet:
+			body, ok := objects[r.URL.Path]
+			if !ok {
+				http.Error(w, "NoSuchKey", http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(body)
+		}
+	}))
+	defer srv.Close()
+
+	b := NewBucket(Config{
+		Endpoint:        srv.URL + "/",
+		Bucket:          "audit",
+		Prefix:          "ephemeron/",
+		Region:          "auto",
+		AccessKeyID:     "AK",
+		SecretAccessKey: "SK",
+	})
+	key := BlobKey("sha256:abc")
+	if _, err := b.Get(t.Context(), key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := b.Put(t.Context(), key, []byte("config")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, ok := objects["/audit/ephemeron/blobs/sha256/abc"]; !ok {
+		t.Errorf("expected object below the prefix, got %v", objects)
+	}
+	body, err := b.Get(t.Context(), key)
+	if err != nil || string(body) != "config" {
+		t.Errorf("Get = %q, %v", body, err)
+	}
+}
+
+func TestBucket_PutError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	b := NewBucket(Config{Endpoint: srv.URL, Bucket: "audit"})
+	if err := b.Put(t.Context(), "k", nil); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected error quoting status 403, got %v", err)
+	}
+}
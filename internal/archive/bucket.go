@@ -0,0 +1,85 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/sigv4"
+)
+
+// maxObjectBytes bounds the objects Get reads; manifests and configs are
+// far smaller.
+const maxObjectBytes = 16 << 20
+
+// Bucket archives content as objects in an S3-compatible bucket.
+type Bucket struct {
+	cfg        Config
+	httpClient *http.Client
+	now        func() time.Time
+}
+
+// NewBucket returns a Bucket storing objects in cfg.Bucket.
+func NewBucket(cfg Config) *Bucket {
+	cfg.Endpoint = strings.TrimRight(cfg.Endpoint, "/")
+	return &Bucket{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		now:        time.Now,
+	}
+}
+
+// Put uploads body as the object for key.
+func (b *Bucket) Put(ctx context.Context, key string, body []byte) error {
+	resp, err := b.do(ctx, http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("uploading %s returned %d: %s", key, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Get downloads the object for key.
+func (b *Bucket) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := b.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(io.LimitReader(resp.Body, maxObjectBytes))
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	default:
+		return nil, fmt.Errorf("downloading %s returned %d", key, resp.StatusCode)
+	}
+}
+
+func (b *Bucket) do(ctx context.Context, method, key string, body []byte) (*http.Response, error) {
+	key = strings.TrimPrefix(path.Join(b.cfg.Prefix, key), "/")
+	escaped := strings.ReplaceAll(url.PathEscape(key), "%2F", "/")
+	req, err := http.NewRequestWithContext(ctx, method,
+		b.cfg.Endpoint+"/"+url.PathEscape(b.cfg.Bucket)+"/"+escaped, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	if b.cfg.AccessKeyID != "" {
+		sigv4.Sign(req, b.cfg.AccessKeyID, b.cfg.SecretAccessKey, b.cfg.Region, sigv4.PayloadHash(body), b.now().UTC())
+	}
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", method, key, err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,209 @@
+// Package bucketusage measures how much object storage a registry with an
+// S3-compatible storage backend actually consumes. It talks to the
+// ListObjectsV2 API directly, which S3, MinIO and the GCS XML API (using
+// HMAC keys) all implement.
+package bucketusage
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+	"github.com/tamcore/ephemeron/internal/sigv4"
+)
+
+// blobShards is the number of two-hex-digit directories the registry
+// spreads blobs across.
+const blobShards = 256
+
+// maxPages bounds each listing so a bucket returning a broken continuation
+// token cannot keep the prober looping forever.
+const maxPages = 100000
+
+// Config describes the registry's storage bucket.
+type Config struct {
+	// Endpoint is the storage API base URL, e.g. https://s3.eu-central-1.amazonaws.com
+	// or https://storage.googleapis.com.
+	Endpoint string
+	// Bucket is the bucket name.
+	Bucket string
+	// Prefix is the registry's rootdirectory within the bucket, if any.
+	Prefix string
+	// Region is the SigV4 signing region; "auto" works for GCS.
+	Region string
+	// AccessKeyID and SecretAccessKey are the HMAC credentials.
+	AccessKeyID     string
+	SecretAccessKey string
+	// SampleShards is how many of the 256 blob shard directories are listed
+	// and extrapolated from. Zero or 256 lists the whole registry prefix.
+	SampleShards int
+}
+
+// Estimate is the measured or extrapolated usage of the registry prefix.
+type Estimate struct {
+	Bytes   int64
+	Objects int64
+	// Sampled is true when Bytes and Objects were extrapolated from a
+	// subset of blob shards.
+	Sampled bool
+}
+
+// Prober lists the registry prefix of a bucket.
+type Prober struct {
+	cfg        Config
+	httpClient *http.Client
+	logger     *slog.Logger
+	now        func() time.Time
+}
+
+// New creates a Prober.
+func New(cfg Config, logger *slog.Logger) *Prober {
+	cfg.Endpoint = strings.TrimRight(cfg.Endpoint, "/")
+	return &Prober{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		logger:     logger,
+		now:        time.Now,
+	}
+}
+
+// RunLoop probes the bucket immediately and then at the given interval,
+// publishing results as metrics. It blocks until the context is cancelled.
+func (p *Prober) RunLoop(ctx context.Context, interval time.Duration) {
+	p.logger.Info("starting bucket usage prober",
+		"bucket", p.cfg.Bucket,
+		"interval", interval.String(),
+		"sample_shards", p.cfg.SampleShards,
+	)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p.probe(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Prober) probe(ctx context.Context) {
+	start := time.Now()
+	est, err := p.Usage(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			metrics.BucketProbeErrors.Inc()
+			p.logger.Error("bucket usage probe failed", "error", err)
+		}
+		return
+	}
+	metrics.BucketUsageBytes.Set(float64(est.Bytes))
+	metrics.BucketObjects.Set(float64(est.Objects))
+	p.logger.Info("bucket usage probed",
+		"bytes", est.Bytes,
+		"objects", est.Objects,
+		"sampled", est.Sampled,
+		"duration", time.Since(start).String(),
+	)
+}
+
+// Usage lists the registry prefix, or a sample of its blob shards, and
+// returns the total size of the objects found.
+func (p *Prober) Usage(ctx context.Context) (Estimate, error) {
+	root := path.Join(p.cfg.Prefix, "docker/registry/v2") + "/"
+	k := p.cfg.SampleShards
+	if k <= 0 || k >= blobShards {
+		bytes, objects, err := p.list(ctx, strings.TrimPrefix(root, "/"))
+		return Estimate{Bytes: bytes, Objects: objects}, err
+	}
+
+	// Blobs are stored under their SHA-256 digest, so shards are uniformly
+	// filled and an evenly spaced sample extrapolates well.
+	var est Estimate
+	for i := range k {
+		shard := fmt.Sprintf("%02x", i*blobShards/k)
+		prefix := strings.TrimPrefix(root+"blobs/sha256/"+shard+"/", "/")
+		bytes, objects, err := p.list(ctx, prefix)
+		if err != nil {
+			return Estimate{}, err
+		}
+		est.Bytes += bytes
+		est.Objects += objects
+	}
+	est.Bytes = est.Bytes * blobShards / int64(k)
+	est.Objects = est.Objects * blobShards / int64(k)
+	est.Sampled = true
+	return est, nil
+}
+
+// listResult is the subset of a ListObjectsV2 response used by the prober.
+type listResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Size int64 `xml:"Size"`
+	} `xml:"Contents"`
+}
+
+// list sums the size of all objects under prefix.
+func (p *Prober) list(ctx context.Context, prefix string) (bytes, objects int64, err error) {
+	var token string
+	for range maxPages {
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		res, err := p.listPage(ctx, q)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, obj := range res.Contents {
+			bytes += obj.Size
+			objects++
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return bytes, objects, nil
+		}
+		token = res.NextContinuationToken
+	}
+	return 0, 0, fmt.Errorf("listing %s: exceeded %d pages", prefix, maxPages)
+}
+
+func (p *Prober) listPage(ctx context.Context, q url.Values) (*listResult, error) {
+	u := p.cfg.Endpoint + "/" + url.PathEscape(p.cfg.Bucket) + "?" + q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	if p.cfg.AccessKeyID != "" {
+		sigv4.Sign(req, p.cfg.AccessKeyID, p.cfg.SecretAccessKey, p.cfg.Region, sigv4.EmptyPayloadHash, p.now())
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("listing bucket %s: %w", p.cfg.Bucket, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("listing bucket %s returned %d: %s",
+			p.cfg.Bucket, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var res listResult
+	if err := xml.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("decoding list response: %w", err)
+	}
+	return &res, nil
+}
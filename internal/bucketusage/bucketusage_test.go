@@ -0,0 +1,112 @@
+package bucketusage
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeBucket serves ListObjectsV2 for objects keyed by path, two per page.
+func fakeBucket(t *testing.T, objects map[string]int64) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/registry-bucket" || r.URL.Query().Get("list-type") != "2" {
+			t.Errorf("unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AK/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		prefix := r.URL.Query().Get("prefix")
+		var keys []string
+		for k := range objects {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+
+		start := 0
+		if tok := r.URL.Query().Get("continuation-token"); tok != "" {
+			_, _ = fmt.Sscan(tok, &start)
+		}
+		end := min(start+2, len(keys))
+		var b strings.Builder
+		b.WriteString("<ListBucketResult>")
+		for _, k := range keys[start:end] {
+			fmt.Fprintf(&b, "<Contents><Key>%s</Key><Size>%d</Size></Contents>", k, objects[k])
+		}
+		if end < len(keys) {
+			fmt.Fprintf(&b, "<IsTruncated>true</IsTruncated><NextContinuationToken>%d</NextContinuationToken>", end)
+		} else {
+			b.WriteString("<IsTruncated>false</IsTruncated>")
+		}
+		b.WriteString("</ListBucketResult>")
+		_, _ = w.Write([]byte(b.String()))
+	}))
+}
+
+func newTestProber(endpoint string, shards int) *Prober {
+	return New(Config{
+		Endpoint:        endpoint,
+		Bucket:          "registry-bucket",
+		Prefix:          "/reg",
+		Region:          "auto",
+		AccessKeyID:     "AK",
+		SecretAccessKey: "secret",
+		SampleShards:    shards,
+	}, slog.Default())
+}
+
+func TestUsage_FullListing(t *testing.T) {
+	srv := fakeBucket(t, map[string]int64{
+		"reg/docker/registry/v2/blobs/sha256/00/aa/data":       100,
+		"reg/docker/registry/v2/blobs/sha256/80/bb/data":       200,
+		"reg/docker/registry/v2/repositories/app/_layers/link": 71,
+		"other/file": 1000,
+	})
+	defer srv.Close()
+
+	est, err := newTestProber(srv.URL, 0).Usage(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if est.Bytes != 371 || est.Objects != 3 || est.Sampled {
+		t.Errorf("unexpected estimate %+v", est)
+	}
+}
+
+func TestUsage_Sampled(t *testing.T) {
+	srv := fakeBucket(t, map[string]int64{
+		"reg/docker/registry/v2/blobs/sha256/00/aa/data": 100,
+		"reg/docker/registry/v2/blobs/sha256/80/bb/data": 300,
+		"reg/docker/registry/v2/blobs/sha256/81/cc/data": 5000,
+	})
+	defer srv.Close()
+
+	// Two shards sample 00 and 80 and scale by 128.
+	est, err := newTestProber(srv.URL, 2).Usage(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if est.Bytes != 400*128 || est.Objects != 2*128 || !est.Sampled {
+		t.Errorf("unexpected estimate %+v", est)
+	}
+}
+
+func TestUsage_ListError(t *testing.T) {
+	srv := fakeBucket(t, nil)
+	defer srv.Close()
+
+	p := newTestProber(srv.URL, 0)
+	p.cfg.AccessKeyID = "wrong"
+	if _, err := p.Usage(t.Context()); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("expected 403 error, got %v", err)
+	}
+}
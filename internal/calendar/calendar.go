@@ -0,0 +1,184 @@
+// Package calendar serves upcoming image expirations as an iCalendar feed,
+// so teams can subscribe in their calendar apps and see when the images
+// they rely on will be deleted.
+package calendar
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// icsTime is the iCalendar UTC date-time format.
+const icsTime = "20060102T150405Z"
+
+// maxLine is the line length in octets after which iCalendar content lines
+// are folded.
+const maxLine = 75
+
+// expiry is an upcoming expiration.
+type expiry struct {
+	image     string
+	expiresAt time.Time
+	sizeBytes int64
+	digest    string
+	actor     string
+}
+
+// Feed builds the iCalendar feed from the tracked images.
+type Feed struct {
+	store    redisclient.Store
+	hostname string
+	logger   *slog.Logger
+	now      func() time.Time
+}
+
+// New returns a Feed of the images tracked in store. hostname qualifies
+// event UIDs and appears in the calendar name.
+func New(store redisclient.Store, hostname string, logger *slog.Logger) *Feed {
+	return &Feed{store: store, hostname: hostname, logger: logger, now: time.Now}
+}
+
+// upcoming returns the images expiring after now whose repository matches
+// the repo glob, or all of them if repo is empty, soonest first.
+func (f *Feed) upcoming(ctx context.Context, repo string, now time.Time) ([]expiry, error) {
+	images, err := f.store.ListImages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing images: %w", err)
+	}
+	var out []expiry
+	for _, image := range images {
+		name, _, _ := strings.Cut(image, ":")
+		if repo != "" {
+			if ok, _ := path.Match(repo, name); !ok {
+				continue
+			}
+		}
+		ms, err := f.store.GetExpiry(ctx, image)
+		if err != nil {
+			return nil, fmt.Errorf("getting expiry for %s: %w", image, err)
+		}
+		e := expiry{image: image, expiresAt: time.UnixMilli(ms).UTC()}
+		if !e.expiresAt.After(now) {
+			continue
+		}
+		if e.sizeBytes, err = f.store.GetImageSize(ctx, image); err != nil {
+			return nil, fmt.Errorf("getting size for %s: %w", image, err)
+		}
+		if e.digest, err = f.store.GetImageDigest(ctx, image); err != nil {
+			return nil, fmt.Errorf("getting digest for %s: %w", image, err)
+		}
+		meta, err := f.store.GetImageMeta(ctx, image)
+		if err != nil {
+			return nil, fmt.Errorf("getting metadata for %s: %w", image, err)
+		}
+		e.actor = meta.Actor
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].expiresAt.Equal(out[j].expiresAt) {
+			return out[i].expiresAt.Before(out[j].expiresAt)
+		}
+		return out[i].image < out[j].image
+	})
+	return out, nil
+}
+
+// write renders expiries as an iCalendar object. Each image keeps its UID
+// across refreshes, so extending its TTL moves the event instead of adding
+// one.
+func (f *Feed) write(w io.Writer, expiries []expiry, now time.Time) error {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//ephemeron//expiries//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:" + escape(f.hostname+" image expiries"),
+		"REFRESH-INTERVAL;VALUE=DURATION:PT1H",
+		"X-PUBLISHED-TTL:PT1H",
+	}
+	for _, e := range expiries {
+		desc := fmt.Sprintf("%s will be deleted from %s.\nSize: %d bytes", e.image, f.hostname, e.sizeBytes)
+		if e.digest != "" {
+			desc += "\nDigest: " + e.digest
+		}
+		if e.actor != "" {
+			desc += "\nPushed by: " + e.actor
+		}
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			"UID:"+escape(e.image+"@"+f.hostname),
+			"DTSTAMP:"+now.UTC().Format(icsTime),
+			"DTSTART:"+e.expiresAt.Format(icsTime),
+			"DURATION:PT15M",
+			"SUMMARY:"+escape(e.image+" expires"),
+			"DESCRIPTION:"+escape(desc),
+			"TRANSP:TRANSPARENT",
+			"END:VEVENT",
+		)
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(fold(line))
+		b.WriteString("\r\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// escape escapes an iCalendar TEXT value.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// fold splits a content line into lines of at most maxLine octets, each
+// continuation starting with a space, without splitting UTF-8 sequences.
+func fold(line string) string {
+	var b strings.Builder
+	width := 0
+	for _, r := range line {
+		n := len(string(r))
+		if width+n > maxLine {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += n
+	}
+	return b.String()
+}
+
+// Handler serves the feed of upcoming expirations, limited to repositories
+// matching the optional "repo" glob query parameter.
+func (f *Feed) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		repo := req.URL.Query().Get("repo")
+		if _, err := path.Match(repo, ""); err != nil {
+			http.Error(w, "invalid repo pattern", http.StatusBadRequest)
+			return
+		}
+		now := f.now()
+		expiries, err := f.upcoming(req.Context(), repo, now)
+		if err != nil {
+			f.logger.Error("failed to list upcoming expirations", "error", err)
+			http.Error(w, "expirations unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("Content-Disposition", `inline; filename="expiries.ics"`)
+		if err := f.write(w, expiries, now); err != nil {
+			f.logger.Error("failed to write calendar", "error", err)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package calendar
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+func TestHandler(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := memstore.New()
+	_ = store.TrackImage(t.Context(), "demo/app:2h", now.Add(2*time.Hour), 100, "sha256:a",
+		redisclient.ImageMeta{Actor: "ci-bot"})
+	_ = store.TrackImage(t.Context(), "demo/app:1h", now.Add(time.Hour), 50, "", redisclient.ImageMeta{})
+	_ = store.TrackImage(t.Context(), "other:1h", now.Add(time.Hour), 10, "", redisclient.ImageMeta{})
+	_ = store.TrackImage(t.Context(), "demo/old:1h", now.Add(-time.Minute), 10, "", redisclient.ImageMeta{})
+
+	f := New(store, "reg.example.com", slog.New(slog.DiscardHandler))
+	f.now = func() time.Time { return now }
+
+	rec := httptest.NewRecorder()
+	f.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/api/expiries.ics?repo=demo/*", nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/calendar") {
+		t.Fatalf("expected calendar, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	body := rec.Body.String()
+	if !strings.HasPrefix(body, "BEGIN:VCALENDAR\r\n") || !strings.HasSuffix(body, "END:VCALENDAR\r\n") {
+		t.Errorf("expected CRLF-terminated calendar, got %q", body)
+	}
+	if n := strings.Count(body, "BEGIN:VEVENT"); n != 2 {
+		t.Fatalf("expected 2 upcoming demo/* events, got %d:\n%s", n, body)
+	}
+	first := strings.Index(body, "UID:demo/app:1h@reg.example.com")
+	second := strings.Index(body, "UID:demo/app:2h@reg.example.com")
+	if first < 0 || second < first {
+		t.Errorf("expected events soonest first:\n%s", body)
+	}
+	if !strings.Contains(body, "DTSTART:20260501T140000Z") {
+		t.Errorf("expected start at expiry:\n%s", body)
+	}
+	unfolded := strings.ReplaceAll(body, "\r\n ", "")
+	if !strings.Contains(unfolded, `Size: 100 bytes\nDigest: sha256:a\nPushed by: ci-bot`) {
+		t.Errorf("expected escaped description with image details:\n%s", body)
+	}
+	for line := range strings.SplitSeq(body, "\r\n") {
+		if len(line) > maxLine {
+			t.Errorf("line longer than %d octets: %q", maxLine, line)
+		}
+	}
+}
+
+func TestHandler_InvalidPattern(t *testing.T) {
+	f := New(memstore.New(), "reg.example.com", slog.New(slog.DiscardHandler))
+	rec := httptest.NewRecorder()
+	f.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/api/expiries.ics?repo=[", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid pattern, got %d", rec.Code)
+	}
+}
+
+func TestEscapeAndFold(t *testing.T) {
+	if got := escape("a,b;c\\d\ne"); got != `a\,b\;c\\d\ne` {
+		t.Errorf("escape = %q", got)
+	}
+	line := "SUMMARY:" + strings.Repeat("é", 40)
+	folded := fold(line)
+	for part := range strings.SplitSeq(folded, "\r\n") {
+		if len(part) > maxLine {
+			t.Errorf("folded line longer than %d octets: %q", maxLine, part)
+		}
+	}
+	if strings.ReplaceAll(folded, "\r\n ", "") != line {
+		t.Errorf("unfolding %q does not restore the line", folded)
+	}
+}
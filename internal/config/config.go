@@ -1,10 +1,66 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/tamcore/ephemeron/internal/archive"
+	"github.com/tamcore/ephemeron/internal/bucketusage"
+	"github.com/tamcore/ephemeron/internal/export"
+	"github.com/tamcore/ephemeron/internal/faults"
+	"github.com/tamcore/ephemeron/internal/fieldcrypt"
+	"github.com/tamcore/ephemeron/internal/hooks"
+	"github.com/tamcore/ephemeron/internal/kube"
+	"github.com/tamcore/ephemeron/internal/owners"
+	"github.com/tamcore/ephemeron/internal/reaper"
+	"github.com/tamcore/ephemeron/internal/schedule"
+	"github.com/tamcore/ephemeron/internal/secrets"
+)
+
+// Supported values for StoreBackend.
+const (
+	StoreBackendRedis  = "redis"
+	StoreBackendMemory = "memory"
+)
+
+// Supported values for StoreFailureMode.
+const (
+	StoreFailClosed = "closed"
+	StoreFailOpen   = "open"
+)
+
+// Supported values for RepoCleanup.
+const (
+	RepoCleanupHarbor     = "harbor"
+	RepoCleanupFilesystem = "filesystem"
 )
 
+// Supported values for PolicyWebhookDefault.
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+)
+
+// Supported values for TTLTagValidation.
+const (
+	TTLTagValidationOff     = "off"
+	TTLTagValidationObserve = "observe"
+	TTLTagValidationEnforce = "enforce"
+)
+
+// maxLargestImagesMetric bounds LargestImagesMetric, and with it the series
+// of the largest images gauge.
+const maxLargestImagesMetric = 100
+
 // Config holds all configuration for the application.
 type Config struct {
 	// Port for the public HTTP server (webhook + landing page).
@@ -13,27 +69,414 @@ type Config struct {
 	// InternalPort for health/readiness probes and metrics (not publicly exposed).
 	InternalPort int
 
+	// ListenAddr and InternalListenAddr override Port and InternalPort with a
+	// full address, as accepted by ParseListenAddr. Empty listens on the port
+	// on every interface.
+	ListenAddr         string
+	InternalListenAddr string
+
+	// BasePath serves every route of both servers below a path prefix such
+	// as "/ephemeron", without a trailing slash. Empty serves them at the root.
+	BasePath string
+
+	// ProbePort serves only the health and readiness probes, never
+	// authenticated, for kubelets. Zero disables the probe listener.
+	ProbePort int
+
+	// StoreBackend selects where tracking state is kept: "redis" or "memory".
+	StoreBackend string
+
 	// RedisURL is the Redis connection URL.
 	RedisURL string
 
+	// RedisPassword overrides the password in RedisURL. RedisPasswordRef is
+	// the secret reference it was read from, if any, and is refreshed
+	// while the server runs.
+	RedisPassword    string
+	RedisPasswordRef string
+
+	// RedisKeyPrefix is prepended to every Redis key, including the reaper lock.
+	RedisKeyPrefix string
+
+	// RedisDB selects the Redis logical database. Negative keeps the URL's database.
+	RedisDB int
+
+	// RedisNativeExpiry sets Redis TTLs on expiry markers and reaps images as
+	// soon as Redis reports them expired, instead of waiting for ReapInterval.
+	RedisNativeExpiry bool
+
+	// RedisMaxReplicationLag is how far a replica of the Redis primary may
+	// lag behind before the reaper stops deleting. Zero disables the check;
+	// failovers and loading servers always suspend deletions.
+	RedisMaxReplicationLag time.Duration
+
+	// FieldEncryptionKey is a base64 AES key encrypting who pushed each
+	// image and the audit trail of deletion requests in Redis. Empty stores
+	// them in plain text. FieldEncryptionKeyRef is the secret reference it
+	// was read from, if any. FieldEncryptionOldKeys are previous keys, still
+	// accepted for reading until the store is re-encrypted.
+	FieldEncryptionKey     string
+	FieldEncryptionKeyRef  string
+	FieldEncryptionOldKeys []string
+
+	// StoreFailureMode controls webhook behaviour while the store is down:
+	// "closed" rejects the event so the registry retries, "open" accepts it
+	// and journals it to JournalPath for replay.
+	StoreFailureMode string
+
+	// JournalPath is the file used to journal events in fail-open and
+	// write-ahead mode.
+	JournalPath string
+
+	// JournalWriteAhead journals every push event before the store write and
+	// replays uncompleted entries on startup.
+	JournalWriteAhead bool
+
 	// HookToken is the shared secret for registry webhook authentication.
-	HookToken string
+	// HookTokenRef is the secret reference it was read from, if any, and is
+	// refreshed while the server runs.
+	HookToken    string
+	HookTokenRef string
+
+	// HookSourceTokens are "name=token" entries giving each registry
+	// instance its own webhook token. Events sent with one are labelled with
+	// the instance name in store records, logs and metrics.
+	HookSourceTokens []string
+
+	// HookSources are the further instance names registries using the
+	// shared token may claim in an X-Registry-Source header. Others are
+	// labelled unknown.
+	HookSources []string
+
+	// WebhookMaxEvents limits the events accepted per webhook request. Zero
+	// removes the limit.
+	WebhookMaxEvents int
+
+	// WebhookEventFailures is what a webhook request does when one of its
+	// events fails: stop and fail (hooks.EventFailuresAbort), or handle the
+	// rest and report every outcome (hooks.EventFailuresReport, or
+	// hooks.EventFailuresRetry to still fail on retryable errors).
+	WebhookEventFailures string
+
+	// WebhookSkewTolerance is how far the timestamp of a push event may
+	// be off from its receipt time for the expiry to be anchored at it. Zero
+	// anchors every expiry at the receipt time.
+	WebhookSkewTolerance time.Duration
+
+	// WebhookSkewWarn is the skew of an event timestamp above which a
+	// warning is logged. Zero disables the warning.
+	WebhookSkewWarn time.Duration
+
+	// WebhookMaxInFlight is the number of webhook requests handled at once
+	// before further requests get 429. Zero removes the limit.
+	WebhookMaxInFlight int
+
+	// WebhookStoreLatency is the average store write latency above which
+	// webhook requests get 429. Zero disables the check.
+	WebhookStoreLatency time.Duration
+
+	// WebhookRetryAfter is the Retry-After sent with 429 responses.
+	WebhookRetryAfter time.Duration
+
+	// PolicyWebhookURL is the policy service consulted on every push. Empty
+	// disables it.
+	PolicyWebhookURL string
+
+	// PolicyWebhookToken is sent to the policy service as a bearer token.
+	PolicyWebhookToken string
+
+	// PolicyWebhookTimeout bounds each policy call.
+	PolicyWebhookTimeout time.Duration
+
+	// PolicyWebhookDefault is the decision used when the policy service
+	// fails or times out, or the Rego policy fails to evaluate: PolicyAllow
+	// or PolicyDeny.
+	PolicyWebhookDefault string
+
+	// PolicyRegoFile is a Rego policy evaluated on every push instead of
+	// calling a policy webhook. It is reloaded when it changes. Empty
+	// disables it.
+	PolicyRegoFile string
+
+	// PolicyCELTTL is a CEL expression yielding the TTL of a pushed image.
+	// Empty keeps the TTL parsed from the tag.
+	PolicyCELTTL string
+
+	// PolicyCELProtect is a CEL expression yielding whether a pushed image
+	// is protected from reaping. Empty protects nothing.
+	PolicyCELProtect string
+
+	// PolicyCELPriority is a CEL expression yielding the deletion priority
+	// of a pushed image. Empty leaves every image at priority zero.
+	PolicyCELPriority string
+
+	// DecisionTrace logs the full evaluation of every push at start-up. It
+	// can be switched at runtime through the internal API.
+	DecisionTrace bool
+
+	// ExtendTagSeparator marks pushes of "<tag><separator><duration>" as
+	// expiry extensions of <tag>. Empty disables extension tags.
+	ExtendTagSeparator string
 
 	// RegistryURL is the base URL of the OCI registry (used by the reaper).
 	RegistryURL string
 
+	// RegistryUsername and RegistryPassword authenticate registry API calls.
+	// Token-based registries exchange them for bearer tokens.
+	RegistryUsername string
+	RegistryPassword string
+
+	// ManifestAcceptTypes overrides the Accept header used when fetching
+	// manifests. Empty uses the registry client's defaults.
+	ManifestAcceptTypes []string
+
+	// RegistryTimeout bounds each registry API request. Zero removes the
+	// bound.
+	RegistryTimeout time.Duration
+
+	// RegistryRetries is how often a registry request failing with a network
+	// error, 429 or 502/503/504 is sent again, waiting RegistryRetryBackoff
+	// before the first retry and doubling it for each further one.
+	RegistryRetries      int
+	RegistryRetryBackoff time.Duration
+
+	// RegistryProxy is the proxy for registry and Harbor API requests, an
+	// http, https or socks5 URL. Empty uses HTTP_PROXY, HTTPS_PROXY and
+	// NO_PROXY.
+	RegistryProxy string
+
 	// Hostname is the public hostname for the landing page.
 	Hostname string
 
+	// WebTemplateDir holds a custom landing page template, index.html, and
+	// its assets below static/. Missing files fall back to the embedded page.
+	WebTemplateDir string
+
+	// WebTemplateVars are "key=value" entries available to the landing page
+	// template as .Vars.
+	WebTemplateVars []string
+
 	// DefaultTTL is the TTL applied when a tag has no parseable duration.
 	DefaultTTL time.Duration
 
 	// MaxTTL is the maximum allowed TTL.
 	MaxTTL time.Duration
 
+	// ArtifactTTLs maps artifact types ("image", "helm", "wasm", "artifact")
+	// to the TTL applied when their tag has no parseable duration.
+	ArtifactTTLs map[string]time.Duration
+
+	// CacheRepos are path.Match patterns of build cache repositories,
+	// matched against the trailing path segments, tracked with
+	// CacheRepoTTL.
+	CacheRepos []string
+
+	// CacheRepoTTL is the default and maximum TTL of cache repositories.
+	// Zero treats them like any other repository.
+	CacheRepoTTL time.Duration
+
+	// TTLTagValidation handles pushes whose tag is meant as a TTL but does
+	// not parse, like "2hours": "off" gives them the default TTL, "observe"
+	// also logs and counts them, and "enforce" rejects them.
+	TTLTagValidation string
+
+	// NonTTLTags is what happens to pushes whose tag is not a TTL, unless a
+	// repository policy says otherwise: "track" gives them the default TTL,
+	// "ignore" leaves them untracked so they are never reaped, and "reject"
+	// fails the webhook.
+	NonTTLTags string
+
 	// ReapInterval is how often the reaper checks for expired images.
 	ReapInterval time.Duration
 
+	// ReapSchedule is a cron expression replacing ReapInterval. Empty keeps
+	// the fixed interval.
+	ReapSchedule string
+
+	// ReapJitter delays every reap cycle by a random duration below it, so
+	// deployments sharing a registry do not reap in lockstep.
+	ReapJitter time.Duration
+
+	// ReapLatencyThreshold is the p95 registry latency above which the reaper
+	// pauses between deletions. Zero disables adaptive pacing.
+	ReapLatencyThreshold time.Duration
+
+	// ReapPacingDelay is how long the reaper pauses while the registry is slow.
+	ReapPacingDelay time.Duration
+
+	// ReapMaxFailures is the number of failed deletions the one-shot reap
+	// command tolerates before exiting non-zero.
+	ReapMaxFailures int
+
+	// ReapVerifyDeletes re-checks that a manifest is gone after the reaper
+	// deletes it, retrying the DELETE once.
+	ReapVerifyDeletes bool
+
+	// ReapDigestStrategy decides which digest an expired tag is deleted by
+	// when the registry reports a different one than was pushed, one of
+	// reaper.DigestStrategies.
+	ReapDigestStrategy string
+
+	// ReapOverwritePolicy decides what happens to an expired tag overwritten
+	// since it was tracked, one of reaper.OverwritePolicies.
+	ReapOverwritePolicy string
+
+	// TombstoneRetention is how long deleted images stay listed with when and
+	// why they were deleted. Zero disables tombstones.
+	TombstoneRetention time.Duration
+
+	// RestoreEnabled keeps the manifest of deleted images with their
+	// tombstone so they can be restored while their blobs remain.
+	RestoreEnabled bool
+
+	// Archive is where the manifests and config blobs of deleted images are
+	// archived. Neither Archive.Dir nor Archive.Bucket disables archiving.
+	Archive archive.Config
+
+	// ReapQuarantineAfter is the number of refused deletions after which an
+	// image is quarantined and skipped by the reaper. Zero disables it.
+	ReapQuarantineAfter int
+
+	// ReapMaxDeletes limits the deletions of one reap cycle, and
+	// ReapMaxDuration how long it keeps starting new ones. Expired images are
+	// deleted by descending policy priority, so the rest wait for the next
+	// cycle. Zero removes the respective limit.
+	ReapMaxDeletes  int
+	ReapMaxDuration time.Duration
+
+	// EvictionTargetBytes is how much tracked storage an emergency eviction
+	// triggered by an Alertmanager webhook tries to reclaim.
+	EvictionTargetBytes int64
+
+	// RegistryDataPath is the registry's storage directory, when mounted
+	// into this container. Empty disables filesystem usage probing.
+	RegistryDataPath string
+
+	// EvictionMinFreeBytes makes the reaper evict images ahead of expiry
+	// while the filesystem at RegistryDataPath has less free space. Zero
+	// disables it.
+	EvictionMinFreeBytes int64
+
+	// RepoCleanup selects how repositories left without tags by the reaper
+	// are deleted: "harbor", "filesystem" (via RegistryDataPath), or empty to
+	// keep them.
+	RepoCleanup string
+
+	// PreDeleteCommand and PreDeleteWebhook run before each deletion; a
+	// failure aborts it. PostDeleteCommand and PostDeleteWebhook run after
+	// it. Commands run through sh -c. Empty disables the respective hook.
+	PreDeleteCommand  string
+	PreDeleteWebhook  string
+	PostDeleteCommand string
+	PostDeleteWebhook string
+
+	// DeleteHookTimeout bounds each delete hook.
+	DeleteHookTimeout time.Duration
+
+	// HarborURL, HarborUsername and HarborPassword address the Harbor API
+	// used with RepoCleanup=harbor.
+	HarborURL      string
+	HarborUsername string
+	HarborPassword string
+
+	// HarborTimeout bounds each Harbor API request. Zero removes the bound.
+	HarborTimeout time.Duration
+
+	// HarborCreateProjects creates the Harbor project of every pushed
+	// repository, its first path segment, if missing. New projects get a
+	// retention policy keeping artifacts pushed within
+	// HarborProjectRetention, unless it is zero, and are public if
+	// HarborProjectPublic is set.
+	HarborCreateProjects   bool
+	HarborProjectRetention time.Duration
+	HarborProjectPublic    bool
+
+	// Bucket describes the registry's object storage bucket. An empty
+	// Bucket.Bucket disables bucket usage probing.
+	Bucket bucketusage.Config
+
+	// BucketProbeInterval is how often the bucket is listed.
+	BucketProbeInterval time.Duration
+
+	// StoragePrice is the storage price in dollars per GiB-month used for
+	// cost estimates. Zero disables them.
+	StoragePrice float64
+
+	// LargestImagesMetric is how many of the largest tracked images are
+	// exported as a gauge each. Zero disables the gauge.
+	LargestImagesMetric int
+
+	// Export describes the bucket the inventory is exported to. An empty
+	// Export.Bucket disables scheduled exports.
+	Export export.Config
+
+	// ExportInterval is how often the inventory is exported.
+	ExportInterval time.Duration
+
+	// KubeScan makes the reaper defer deleting images still referenced by
+	// workloads in the cluster it runs in.
+	KubeScan bool
+
+	// KubeScanInterval is how often workloads are listed. The reaper stops
+	// deleting if no scan succeeded for three intervals.
+	KubeScanInterval time.Duration
+
+	// KubeWorkloadKinds are the workload kinds listed, e.g. "pods" and
+	// "deployments".
+	KubeWorkloadKinds []string
+
+	// KubeNamespaces limits the scan to these namespaces; empty scans all.
+	KubeNamespaces []string
+
+	// KubeLabelSelector filters the listed workloads.
+	KubeLabelSelector string
+
+	// KubeRegistryHosts are additional host names workloads pull images
+	// from this registry by, besides Hostname.
+	KubeRegistryHosts []string
+
+	// SweepInterval is how often a batch of tracked images is checked for
+	// manifests deleted outside ephemeron. Zero disables the sweeper.
+	SweepInterval time.Duration
+
+	// SweepBatchSize is the number of tracked images checked per sweep.
+	SweepBatchSize int
+
+	// SizeRepairInterval is how often the manifests of a batch of images
+	// tracked with size 0 are fetched again. Zero disables the worker.
+	SizeRepairInterval time.Duration
+
+	// SizeRepairBatchSize is the number of images retried per run.
+	SizeRepairBatchSize int
+
+	// UsageHistoryInterval is how often the bytes tracked per repository
+	// are recorded in today's usage history. Zero disables the history.
+	UsageHistoryInterval time.Duration
+
+	// UsageHistoryRetention is how long daily usage records are kept.
+	UsageHistoryRetention time.Duration
+
+	// ReportInterval is the length of a reporting period. Zero disables
+	// scheduled reports.
+	ReportInterval time.Duration
+
+	// ReconcileInterval is how often the registry's tags are compared with
+	// the tracked images. Zero disables the comparison.
+	ReconcileInterval time.Duration
+
+	// ReconcileConcurrency is how many repositories' tags are listed at once
+	// while reconciling.
+	ReconcileConcurrency int
+
+	// ReconcileRate limits tag listings per second while reconciling. Zero
+	// does not limit them.
+	ReconcileRate float64
+
+	// ReconcileBatchSize is how many repositories each reconcile run
+	// compares, continuing where the previous run stopped. Zero compares the
+	// whole catalog every run.
+	ReconcileBatchSize int
+
 	// LogFormat controls log output: "json" or "text".
 	LogFormat string
 
@@ -41,19 +484,198 @@ type Config struct {
 	// Empty list = observability mode only (default). Example: ["prod-*", "release-*"]
 	ImmutableTagPatterns []string
 
+	// ProtectedPatterns are glob patterns for "repo:tag" images the reaper
+	// never deletes. More can be added at runtime through the rules API.
+	ProtectedPatterns []string
+
+	// ApproverTokens are "name=token" entries identifying the people who
+	// may request and approve deletions of protected images. Empty disables
+	// the deletion API.
+	ApproverTokens []string
+
+	// OIDCIssuerURL enables signing in to the web UI through this OpenID
+	// Connect provider. Empty disables it.
+	OIDCIssuerURL string
+
+	// OIDCClientID and OIDCClientSecret identify ephemeron to the provider.
+	OIDCClientID     string
+	OIDCClientSecret string
+
+	// OIDCRedirectURL is the callback URL registered with the provider.
+	// Empty uses https://<Hostname>/auth/callback.
+	OIDCRedirectURL string
+
+	// OIDCScopes are the scopes requested at sign-in.
+	OIDCScopes []string
+
+	// OIDCGroupsClaim is the ID token claim holding the user's groups.
+	OIDCGroupsClaim string
+
+	// OIDCGroupRoles are "group=role" entries granting RBAC roles to the
+	// members of a group; the group "*" means every user.
+	OIDCGroupRoles []string
+
+	// OIDCSessionSecret signs session cookies.
+	OIDCSessionSecret string
+
+	// OIDCSessionTTL is how long a sign-in lasts.
+	OIDCSessionTTL time.Duration
+
+	// ContentSecurityPolicy is sent with every response of both servers.
+	ContentSecurityPolicy string
+
+	// HSTSMaxAge enables Strict-Transport-Security when positive.
+	HSTSMaxAge time.Duration
+
+	// APIAuth requires an API token, managed with "ephemeron token", on
+	// every internal API request.
+	APIAuth bool
+
+	// APIAuthAllPaths extends APIAuth to every path of the internal port,
+	// such as metrics, except AuthExemptPaths.
+	APIAuthAllPaths bool
+
+	// AuthExemptPaths are globs of internal paths served without a token
+	// when APIAuthAllPaths is set.
+	AuthExemptPaths []string
+
+	// ImageDebugRateLimit is the number of image debug dumps served per
+	// minute. Zero disables the endpoint.
+	ImageDebugRateLimit int
+
+	// SlackSigningSecret verifies Slack slash command requests. Empty
+	// disables the Slack endpoint.
+	SlackSigningSecret string
+
+	// SlackUserScopes are "user=pattern" entries granting Slack user IDs
+	// the repositories they may manage; the user "*" means everyone.
+	SlackUserScopes []string
+
+	// PRWebhookSecret authenticates GitHub and GitLab pull request
+	// webhooks. Empty disables the pull request endpoint.
+	PRWebhookSecret string
+
+	// PRTagPattern is a regular expression whose first capture group
+	// matches the pull request number in a tag.
+	PRTagPattern string
+
+	// PRRepositories are "source=pattern" entries mapping source
+	// repositories to the registry repository globs of their images.
+	PRRepositories []string
+
+	// RepoOwners maps repository glob patterns to owner contacts as
+	// "pattern=owner" entries. The first matching entry wins.
+	RepoOwners []string
+
 	// HealthFailureThreshold is the number of consecutive all-failed reap cycles
 	// before the liveness probe reports unhealthy.
 	HealthFailureThreshold int
+
+	// DeleteProbeInterval is how often the registry is checked for accepting
+	// deletions after the check at startup. Zero only checks at startup.
+	DeleteProbeInterval time.Duration
+
+	// RequireDelete refuses to start when the registry refuses deletions.
+	RequireDelete bool
+
+	// Faults randomly delays and fails store and registry calls, for
+	// resilience testing in staging.
+	Faults faults.Config
+
+	// Secrets configures the secret managers that secret references are
+	// fetched from, and how often they are refreshed.
+	Secrets secrets.Config
+}
+
+// FieldKeyring returns the keyring for FieldEncryptionKey and
+// FieldEncryptionOldKeys, or nil if field encryption is disabled.
+func (c *Config) FieldKeyring() (*fieldcrypt.Keyring, error) {
+	if c.FieldEncryptionKey == "" {
+		return nil, nil
+	}
+	primary, err := fieldcrypt.ParseKey(c.FieldEncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("FIELD_ENCRYPTION_KEY: %w", err)
+	}
+	old := make([][]byte, 0, len(c.FieldEncryptionOldKeys))
+	for _, s := range c.FieldEncryptionOldKeys {
+		key, err := fieldcrypt.ParseKey(s)
+		if err != nil {
+			return nil, fmt.Errorf("FIELD_ENCRYPTION_OLD_KEYS: %w", err)
+		}
+		old = append(old, key)
+	}
+	return fieldcrypt.New(primary, old...)
+}
+
+// Hash returns a short fingerprint of the configuration, so replicas
+// running different settings can be told apart without revealing them.
+func (c *Config) Hash() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%#v", *c)))
+	return hex.EncodeToString(sum[:6])
 }
 
 // Validate checks that all required configuration values are set.
 func (c *Config) Validate() error {
-	if c.RedisURL == "" {
-		return fmt.Errorf("REDIS_URL is required")
+	switch c.StoreBackend {
+	case StoreBackendRedis, "":
+		if c.RedisURL == "" {
+			return fmt.Errorf("REDIS_URL is required")
+		}
+	case StoreBackendMemory:
+		if c.RedisNativeExpiry {
+			return fmt.Errorf("REDIS_NATIVE_EXPIRY requires STORE_BACKEND=redis")
+		}
+	default:
+		return fmt.Errorf("STORE_BACKEND must be %q or %q", StoreBackendRedis, StoreBackendMemory)
+	}
+	// Falling back to plain text would leave encrypted fields unreadable.
+	if c.FieldEncryptionKey == "" && c.FieldEncryptionKeyRef != "" {
+		return fmt.Errorf("FIELD_ENCRYPTION_KEY could not be read from %s", c.FieldEncryptionKeyRef)
+	}
+	if c.FieldEncryptionKey == "" && len(c.FieldEncryptionOldKeys) > 0 {
+		return fmt.Errorf("FIELD_ENCRYPTION_OLD_KEYS requires FIELD_ENCRYPTION_KEY")
+	}
+	if _, err := c.FieldKeyring(); err != nil {
+		return err
+	}
+	if c.RedisMaxReplicationLag < 0 {
+		return fmt.Errorf("REDIS_MAX_REPLICATION_LAG must not be negative")
+	}
+	switch c.StoreFailureMode {
+	case StoreFailClosed, "":
+	case StoreFailOpen:
+		if c.JournalPath == "" {
+			return fmt.Errorf("JOURNAL_PATH is required when STORE_FAILURE_MODE=%s", StoreFailOpen)
+		}
+	default:
+		return fmt.Errorf("STORE_FAILURE_MODE must be %q or %q", StoreFailClosed, StoreFailOpen)
+	}
+	if c.JournalWriteAhead && c.JournalPath == "" {
+		return fmt.Errorf("JOURNAL_PATH is required when JOURNAL_WRITE_AHEAD is enabled")
+	}
+	if c.HookTokenRef != "" {
+		if _, err := c.Secrets.Parse(c.HookTokenRef); err != nil {
+			return fmt.Errorf("HOOK_TOKEN: %w", err)
+		}
+	}
+	if c.RedisPasswordRef != "" {
+		if _, err := c.Secrets.Parse(c.RedisPasswordRef); err != nil {
+			return fmt.Errorf("REDIS_PASSWORD: %w", err)
+		}
+	}
+	if c.HookToken == "" && c.HookTokenRef != "" {
+		return fmt.Errorf("HOOK_TOKEN could not be read from %s", c.HookTokenRef)
 	}
 	if c.HookToken == "" {
 		return fmt.Errorf("HOOK_TOKEN is required")
 	}
+	if c.RedisPassword == "" && c.RedisPasswordRef != "" {
+		return fmt.Errorf("REDIS_PASSWORD could not be read from %s", c.RedisPasswordRef)
+	}
+	if c.Secrets.RefreshInterval < 0 {
+		return fmt.Errorf("SECRET_REFRESH_INTERVAL must not be negative")
+	}
 	if c.RegistryURL == "" {
 		return fmt.Errorf("REGISTRY_URL is required")
 	}
@@ -66,8 +688,346 @@ func (c *Config) Validate() error {
 	if c.DefaultTTL > c.MaxTTL {
 		return fmt.Errorf("DEFAULT_TTL (%s) must not exceed MAX_TTL (%s)", c.DefaultTTL, c.MaxTTL)
 	}
+	switch c.TTLTagValidation {
+	case TTLTagValidationOff, TTLTagValidationObserve, TTLTagValidationEnforce, "":
+	default:
+		return fmt.Errorf("TTL_TAG_VALIDATION must be %q, %q or %q",
+			TTLTagValidationOff, TTLTagValidationObserve, TTLTagValidationEnforce)
+	}
+	if c.NonTTLTags != "" && !slices.Contains(hooks.NonTTLActions, c.NonTTLTags) {
+		return fmt.Errorf("NON_TTL_TAGS must be one of %s", strings.Join(hooks.NonTTLActions, ", "))
+	}
+	for kind, ttl := range c.ArtifactTTLs {
+		if ttl <= 0 {
+			return fmt.Errorf("ARTIFACT_TTLS entry for %q must be positive", kind)
+		}
+		if ttl > c.MaxTTL {
+			return fmt.Errorf("ARTIFACT_TTLS entry for %q (%s) must not exceed MAX_TTL (%s)", kind, ttl, c.MaxTTL)
+		}
+	}
+	if c.CacheRepoTTL < 0 {
+		return fmt.Errorf("CACHE_REPO_TTL must not be negative")
+	}
+	for _, pattern := range c.CacheRepos {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("CACHE_REPOS entry %q: %w", pattern, err)
+		}
+	}
+	if c.ReapLatencyThreshold < 0 {
+		return fmt.Errorf("REAP_LATENCY_THRESHOLD must not be negative")
+	}
+	if c.ReapLatencyThreshold > 0 && c.ReapPacingDelay <= 0 {
+		return fmt.Errorf("REAP_PACING_DELAY must be positive when REAP_LATENCY_THRESHOLD is set")
+	}
+	if c.ReapMaxFailures < 0 {
+		return fmt.Errorf("REAP_MAX_FAILURES must not be negative")
+	}
+	if c.ReapQuarantineAfter < 0 {
+		return fmt.Errorf("REAP_QUARANTINE_AFTER must not be negative")
+	}
+	if c.ReapSchedule != "" {
+		if _, err := schedule.ParseCron(c.ReapSchedule); err != nil {
+			return fmt.Errorf("REAP_SCHEDULE: %w", err)
+		}
+	}
+	if c.ReapJitter < 0 {
+		return fmt.Errorf("REAP_JITTER must not be negative")
+	}
+	if c.ReapMaxDeletes < 0 {
+		return fmt.Errorf("REAP_MAX_DELETES must not be negative")
+	}
+	if c.ReapMaxDuration < 0 {
+		return fmt.Errorf("REAP_MAX_DURATION must not be negative")
+	}
+	if c.TombstoneRetention < 0 {
+		return fmt.Errorf("TOMBSTONE_RETENTION must not be negative")
+	}
+	if c.RestoreEnabled && c.TombstoneRetention == 0 {
+		return fmt.Errorf("RESTORE_ENABLED requires TOMBSTONE_RETENTION")
+	}
+	if c.Archive.Dir != "" && c.Archive.Bucket != "" {
+		return fmt.Errorf("ARCHIVE_DIR and ARCHIVE_BUCKET are mutually exclusive")
+	}
+	if c.Archive.Bucket != "" && c.Archive.Endpoint == "" {
+		return fmt.Errorf("ARCHIVE_BUCKET_ENDPOINT is required with ARCHIVE_BUCKET")
+	}
+	if c.ReapDigestStrategy != "" && !slices.Contains(reaper.DigestStrategies, c.ReapDigestStrategy) {
+		return fmt.Errorf("REAP_DIGEST_STRATEGY must be one of %s", strings.Join(reaper.DigestStrategies, ", "))
+	}
+	if c.ReapOverwritePolicy != "" && !slices.Contains(reaper.OverwritePolicies, c.ReapOverwritePolicy) {
+		return fmt.Errorf("REAP_OVERWRITE_POLICY must be one of %s", strings.Join(reaper.OverwritePolicies, ", "))
+	}
+	if c.EvictionTargetBytes <= 0 {
+		return fmt.Errorf("EVICTION_TARGET_BYTES must be positive")
+	}
+	if c.EvictionMinFreeBytes < 0 {
+		return fmt.Errorf("EVICTION_MIN_FREE_BYTES must not be negative")
+	}
+	if c.EvictionMinFreeBytes > 0 && c.RegistryDataPath == "" {
+		return fmt.Errorf("EVICTION_MIN_FREE_BYTES requires REGISTRY_DATA_PATH")
+	}
+	switch c.RepoCleanup {
+	case "":
+	case RepoCleanupHarbor:
+		if c.HarborURL == "" || c.HarborUsername == "" {
+			return fmt.Errorf("HARBOR_URL and HARBOR_USERNAME are required when REPO_CLEANUP=%s", RepoCleanupHarbor)
+		}
+	case RepoCleanupFilesystem:
+		if c.RegistryDataPath == "" {
+			return fmt.Errorf("REGISTRY_DATA_PATH is required when REPO_CLEANUP=%s", RepoCleanupFilesystem)
+		}
+	default:
+		return fmt.Errorf("REPO_CLEANUP must be %q or %q", RepoCleanupHarbor, RepoCleanupFilesystem)
+	}
+	if c.HarborCreateProjects && (c.HarborURL == "" || c.HarborUsername == "") {
+		return fmt.Errorf("HARBOR_URL and HARBOR_USERNAME are required when HARBOR_CREATE_PROJECTS is enabled")
+	}
+	if c.HarborProjectRetention < 0 {
+		return fmt.Errorf("HARBOR_PROJECT_RETENTION must not be negative")
+	}
+	if c.Bucket.Bucket != "" {
+		if c.Bucket.Endpoint == "" {
+			return fmt.Errorf("STORAGE_BUCKET_ENDPOINT is required with STORAGE_BUCKET")
+		}
+		if c.Bucket.SampleShards < 0 || c.Bucket.SampleShards > 256 {
+			return fmt.Errorf("STORAGE_BUCKET_SAMPLE_SHARDS must be between 0 and 256")
+		}
+		if c.BucketProbeInterval <= 0 {
+			return fmt.Errorf("STORAGE_BUCKET_PROBE_INTERVAL must be positive")
+		}
+	}
+	if len(c.SlackUserScopes) > 0 && c.SlackSigningSecret == "" {
+		return fmt.Errorf("SLACK_SIGNING_SECRET is required with SLACK_USER_SCOPES")
+	}
+	if c.PRWebhookSecret != "" {
+		re, err := regexp.Compile(c.PRTagPattern)
+		if err != nil {
+			return fmt.Errorf("PR_TAG_PATTERN: %w", err)
+		}
+		if re.NumSubexp() < 1 {
+			return fmt.Errorf("PR_TAG_PATTERN must capture the pull request number")
+		}
+	}
+	if c.StoragePrice < 0 {
+		return fmt.Errorf("STORAGE_PRICE_PER_GB_MONTH must not be negative")
+	}
+	if c.LargestImagesMetric < 0 || c.LargestImagesMetric > maxLargestImagesMetric {
+		return fmt.Errorf("LARGEST_IMAGES_METRIC must be between 0 and %d", maxLargestImagesMetric)
+	}
+	if c.Export.Bucket != "" {
+		if c.Export.Endpoint == "" {
+			return fmt.Errorf("EXPORT_BUCKET_ENDPOINT is required with EXPORT_BUCKET")
+		}
+		if !slices.Contains(export.Formats, c.Export.Format) {
+			return fmt.Errorf("EXPORT_FORMAT must be one of %s", strings.Join(export.Formats, ", "))
+		}
+		if c.ExportInterval <= 0 {
+			return fmt.Errorf("EXPORT_INTERVAL must be positive")
+		}
+	}
+	if c.OIDCIssuerURL != "" {
+		if c.OIDCClientID == "" {
+			return fmt.Errorf("OIDC_CLIENT_ID is required with OIDC_ISSUER_URL")
+		}
+		if len(c.OIDCSessionSecret) < 32 {
+			return fmt.Errorf("OIDC_SESSION_SECRET must be at least 32 characters")
+		}
+		if len(c.OIDCGroupRoles) == 0 {
+			return fmt.Errorf("OIDC_GROUP_ROLES is required with OIDC_ISSUER_URL")
+		}
+		if c.OIDCSessionTTL <= 0 {
+			return fmt.Errorf("OIDC_SESSION_TTL must be positive")
+		}
+	}
+	if c.HSTSMaxAge < 0 {
+		return fmt.Errorf("HSTS_MAX_AGE must not be negative")
+	}
+	if c.KubeScan {
+		if c.KubeScanInterval <= 0 {
+			return fmt.Errorf("KUBE_SCAN_INTERVAL must be positive")
+		}
+		if len(c.KubeWorkloadKinds) == 0 {
+			return fmt.Errorf("KUBE_WORKLOAD_KINDS must not be empty")
+		}
+		for _, kind := range c.KubeWorkloadKinds {
+			if !kube.ValidKind(kind) {
+				return fmt.Errorf("KUBE_WORKLOAD_KINDS: unsupported kind %q", kind)
+			}
+		}
+	}
+	if c.SweepInterval < 0 {
+		return fmt.Errorf("SWEEP_INTERVAL must not be negative")
+	}
+	if c.SweepInterval > 0 && c.SweepBatchSize <= 0 {
+		return fmt.Errorf("SWEEP_BATCH_SIZE must be positive when SWEEP_INTERVAL is set")
+	}
+	if c.SizeRepairInterval < 0 {
+		return fmt.Errorf("SIZE_REPAIR_INTERVAL must not be negative")
+	}
+	if c.SizeRepairInterval > 0 && c.SizeRepairBatchSize <= 0 {
+		return fmt.Errorf("SIZE_REPAIR_BATCH_SIZE must be positive when SIZE_REPAIR_INTERVAL is set")
+	}
+	if c.UsageHistoryInterval < 0 {
+		return fmt.Errorf("USAGE_HISTORY_INTERVAL must not be negative")
+	}
+	if c.UsageHistoryInterval > 0 && c.UsageHistoryRetention < 24*time.Hour {
+		return fmt.Errorf("USAGE_HISTORY_RETENTION must be at least 24h when USAGE_HISTORY_INTERVAL is set")
+	}
+	if _, err := owners.Parse(c.RepoOwners); err != nil {
+		return fmt.Errorf("REPO_OWNERS: %w", err)
+	}
+	policies := 0
+	for _, set := range []bool{
+		c.PolicyWebhookURL != "",
+		c.PolicyRegoFile != "",
+		c.PolicyCELTTL != "" || c.PolicyCELProtect != "" || c.PolicyCELPriority != "",
+	} {
+		if set {
+			policies++
+		}
+	}
+	if policies > 1 {
+		return fmt.Errorf("POLICY_WEBHOOK_URL, POLICY_REGO_FILE and POLICY_CEL_* are mutually exclusive")
+	}
+	if c.PolicyWebhookURL != "" && c.PolicyWebhookTimeout <= 0 {
+		return fmt.Errorf("POLICY_WEBHOOK_TIMEOUT must be positive")
+	}
+	if c.PolicyWebhookURL != "" || c.PolicyRegoFile != "" {
+		if c.PolicyWebhookDefault != PolicyAllow && c.PolicyWebhookDefault != PolicyDeny {
+			return fmt.Errorf("POLICY_WEBHOOK_DEFAULT must be %q or %q", PolicyAllow, PolicyDeny)
+		}
+	}
+	if c.PreDeleteCommand != "" || c.PreDeleteWebhook != "" || c.PostDeleteCommand != "" || c.PostDeleteWebhook != "" {
+		if c.DeleteHookTimeout <= 0 {
+			return fmt.Errorf("DELETE_HOOK_TIMEOUT must be positive")
+		}
+	}
+	if c.WebhookMaxInFlight < 0 {
+		return fmt.Errorf("WEBHOOK_MAX_IN_FLIGHT must not be negative")
+	}
+	if c.WebhookStoreLatency < 0 {
+		return fmt.Errorf("WEBHOOK_STORE_LATENCY_THRESHOLD must not be negative")
+	}
+	if (c.WebhookMaxInFlight > 0 || c.WebhookStoreLatency > 0) && c.WebhookRetryAfter <= 0 {
+		return fmt.Errorf("WEBHOOK_RETRY_AFTER must be positive when back-pressure is enabled")
+	}
+	if c.RegistryTimeout < 0 {
+		return fmt.Errorf("REGISTRY_TIMEOUT must not be negative")
+	}
+	if c.RegistryRetries < 0 {
+		return fmt.Errorf("REGISTRY_RETRIES must not be negative")
+	}
+	if c.RegistryRetries > 0 && c.RegistryRetryBackoff <= 0 {
+		return fmt.Errorf("REGISTRY_RETRY_BACKOFF must be positive when REGISTRY_RETRIES is set")
+	}
+	if c.RegistryProxy != "" {
+		u, err := url.Parse(c.RegistryProxy)
+		if err != nil || u.Host == "" || !slices.Contains([]string{"http", "https", "socks5"}, u.Scheme) {
+			return fmt.Errorf("REGISTRY_PROXY must be an http, https or socks5 URL")
+		}
+	}
+	if c.HarborTimeout < 0 {
+		return fmt.Errorf("HARBOR_TIMEOUT must not be negative")
+	}
+	if c.WebhookMaxEvents < 0 {
+		return fmt.Errorf("WEBHOOK_MAX_EVENTS must not be negative")
+	}
+	if c.WebhookEventFailures != "" && !slices.Contains(hooks.EventFailureModes, c.WebhookEventFailures) {
+		return fmt.Errorf("WEBHOOK_EVENT_FAILURES must be one of %s", strings.Join(hooks.EventFailureModes, ", "))
+	}
+	if c.WebhookSkewTolerance < 0 {
+		return fmt.Errorf("WEBHOOK_CLOCK_SKEW_TOLERANCE must not be negative")
+	}
+	if c.WebhookSkewWarn < 0 {
+		return fmt.Errorf("WEBHOOK_CLOCK_SKEW_WARN must not be negative")
+	}
+	if c.ImageDebugRateLimit < 0 {
+		return fmt.Errorf("IMAGE_DEBUG_RATE_LIMIT must not be negative")
+	}
+	if c.ReconcileInterval < 0 {
+		return fmt.Errorf("RECONCILE_INTERVAL must not be negative")
+	}
+	if c.ReconcileConcurrency < 0 {
+		return fmt.Errorf("RECONCILE_CONCURRENCY must not be negative")
+	}
+	if c.ReconcileRate < 0 {
+		return fmt.Errorf("RECONCILE_RATE must not be negative")
+	}
+	if c.ReconcileBatchSize < 0 {
+		return fmt.Errorf("RECONCILE_BATCH_SIZE must not be negative")
+	}
+	if c.ReportInterval < 0 {
+		return fmt.Errorf("REPORT_INTERVAL must not be negative")
+	}
 	if c.HealthFailureThreshold <= 0 {
 		return fmt.Errorf("HEALTH_FAILURE_THRESHOLD must be positive")
 	}
+	listenAddrs := []struct{ env, addr string }{
+		{"LISTEN_ADDR", c.ListenAddr},
+		{"INTERNAL_LISTEN_ADDR", c.InternalListenAddr},
+	}
+	for _, l := range listenAddrs {
+		if l.addr == "" {
+			continue
+		}
+		if _, _, err := ParseListenAddr(l.addr); err != nil {
+			return fmt.Errorf("%s: %w", l.env, err)
+		}
+	}
+	if c.ListenAddr != "" && c.ListenAddr == c.InternalListenAddr {
+		return fmt.Errorf("LISTEN_ADDR must differ from INTERNAL_LISTEN_ADDR")
+	}
+	if c.BasePath != "" && (!strings.HasPrefix(c.BasePath, "/") || path.Clean(c.BasePath) != c.BasePath ||
+		strings.ContainsAny(c.BasePath, "?# ")) {
+		return fmt.Errorf("BASE_PATH %q must be an absolute path like /ephemeron", c.BasePath)
+	}
+	if c.ProbePort < 0 {
+		return fmt.Errorf("PROBE_PORT must not be negative")
+	}
+	if c.ProbePort > 0 && (c.ProbePort == c.Port || c.ProbePort == c.InternalPort) {
+		return fmt.Errorf("PROBE_PORT must differ from PORT and INTERNAL_PORT")
+	}
+	for _, pattern := range c.AuthExemptPaths {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("AUTH_EXEMPT_PATHS entry %q: %w", pattern, err)
+		}
+	}
+	if c.DeleteProbeInterval < 0 {
+		return fmt.Errorf("DELETE_PROBE_INTERVAL must not be negative")
+	}
+	if c.Faults.Enabled {
+		if c.Faults.FailRate < 0 || c.Faults.FailRate > 1 {
+			return fmt.Errorf("FAULT_FAIL_RATE must be between 0 and 1")
+		}
+		if c.Faults.DelayRate < 0 || c.Faults.DelayRate > 1 {
+			return fmt.Errorf("FAULT_DELAY_RATE must be between 0 and 1")
+		}
+		if c.Faults.MaxDelay < 0 {
+			return fmt.Errorf("FAULT_MAX_DELAY must not be negative")
+		}
+	}
 	return nil
 }
+
+// ParseListenAddr returns the network and address to listen on for addr: a
+// port ("8000"), a TCP address ("127.0.0.1:8000", "[::]:8000") or a unix
+// socket path ("unix:///var/run/ephemeron.sock").
+func ParseListenAddr(addr string) (network, address string, err error) {
+	if socket, ok := strings.CutPrefix(addr, "unix://"); ok {
+		if socket == "" {
+			return "", "", fmt.Errorf("unix socket address %q has no path", addr)
+		}
+		return "unix", socket, nil
+	}
+	if _, err := strconv.ParseUint(addr, 10, 16); err == nil {
+		return "tcp", ":" + addr, nil
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid listen address %q: %w", addr, err)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", "", fmt.Errorf("invalid port in listen address %q", addr)
+	}
+	return "tcp", addr, nil
+}
@@ -1,8 +1,13 @@
 package config
 
 import (
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/tamcore/ephemeron/internal/archive"
+	"github.com/tamcore/ephemeron/internal/bucketusage"
+	"github.com/tamcore/ephemeron/internal/export"
 )
 
 func TestValidate(t *testing.T) {
@@ -16,6 +21,7 @@ func TestValidate(t *testing.T) {
 			DefaultTTL:             time.Hour,
 			MaxTTL:                 24 * time.Hour,
 			ReapInterval:           time.Minute,
+			EvictionTargetBytes:    1 << 30,
 			LogFormat:              "text",
 			HealthFailureThreshold: 3,
 		}
@@ -36,6 +42,590 @@ func TestValidate(t *testing.T) {
 		}
 	})
 
+	t.Run("memory backend without redis url", func(t *testing.T) {
+		c := base()
+		c.StoreBackend = StoreBackendMemory
+		c.RedisURL = ""
+		if err := c.Validate(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	})
+
+	t.Run("memory backend with native expiry", func(t *testing.T) {
+		c := base()
+		c.StoreBackend = StoreBackendMemory
+		c.RedisNativeExpiry = true
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for native expiry without redis backend")
+		}
+	})
+
+	t.Run("unknown store backend", func(t *testing.T) {
+		c := base()
+		c.StoreBackend = "etcd"
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for unknown StoreBackend")
+		}
+	})
+
+	t.Run("fail open without journal path", func(t *testing.T) {
+		c := base()
+		c.StoreFailureMode = StoreFailOpen
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for fail-open mode without JournalPath")
+		}
+		c.JournalPath = "/tmp/journal"
+		if err := c.Validate(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	})
+
+	t.Run("write-ahead journal without path", func(t *testing.T) {
+		c := base()
+		c.JournalWriteAhead = true
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for write-ahead journal without JournalPath")
+		}
+	})
+
+	t.Run("unknown store failure mode", func(t *testing.T) {
+		c := base()
+		c.StoreFailureMode = "sometimes"
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for unknown StoreFailureMode")
+		}
+	})
+
+	t.Run("unknown ttl tag validation", func(t *testing.T) {
+		c := base()
+		c.TTLTagValidation = "strict"
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for unknown TTLTagValidation")
+		}
+		c.TTLTagValidation = TTLTagValidationEnforce
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("listen addresses", func(t *testing.T) {
+		c := base()
+		c.ListenAddr = "[::]:8000"
+		c.InternalListenAddr = "unix:///var/run/ephemeron-internal.sock"
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		c.ListenAddr = "localhost"
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for LISTEN_ADDR without a port")
+		}
+		c.ListenAddr = c.InternalListenAddr
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for LISTEN_ADDR equal to INTERNAL_LISTEN_ADDR")
+		}
+	})
+
+	t.Run("largest images metric", func(t *testing.T) {
+		c := base()
+		c.LargestImagesMetric = 101
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for LARGEST_IMAGES_METRIC above 100")
+		}
+		c.LargestImagesMetric = -1
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for negative LARGEST_IMAGES_METRIC")
+		}
+	})
+
+	t.Run("base path", func(t *testing.T) {
+		c := base()
+		c.BasePath = "/tools/ephemeron"
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, p := range []string{"ephemeron", "/ephemeron/", "/a//b", "/a/../b", "/a?b"} {
+			c.BasePath = p
+			if err := c.Validate(); err == nil {
+				t.Errorf("expected error for BASE_PATH %q", p)
+			}
+		}
+	})
+
+	t.Run("registry retries without backoff", func(t *testing.T) {
+		c := base()
+		c.RegistryRetries = 3
+		c.RegistryRetryBackoff = 0
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for RegistryRetries without RegistryRetryBackoff")
+		}
+	})
+
+	t.Run("registry proxy", func(t *testing.T) {
+		for proxy, valid := range map[string]bool{
+			"http://proxy.internal:3128": true,
+			"socks5://127.0.0.1:1080":    true,
+			"proxy.internal:3128":        false,
+			"ftp://proxy.internal":       false,
+		} {
+			c := base()
+			c.RegistryProxy = proxy
+			if err := c.Validate(); (err == nil) != valid {
+				t.Errorf("RegistryProxy %q: Validate() = %v, want valid %v", proxy, err, valid)
+			}
+		}
+	})
+
+	t.Run("unknown webhook event failure mode", func(t *testing.T) {
+		c := base()
+		c.WebhookEventFailures = "ignore"
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for unknown WebhookEventFailures")
+		}
+	})
+
+	t.Run("negative webhook skew tolerance", func(t *testing.T) {
+		c := base()
+		c.WebhookSkewTolerance = -time.Minute
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for negative WebhookSkewTolerance")
+		}
+	})
+
+	t.Run("unknown non-ttl tag action", func(t *testing.T) {
+		c := base()
+		c.NonTTLTags = "drop"
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for unknown NonTTLTags")
+		}
+	})
+
+	t.Run("harbor projects without harbor", func(t *testing.T) {
+		c := base()
+		c.HarborCreateProjects = true
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for HarborCreateProjects without HarborUsername")
+		}
+		c.HarborURL, c.HarborUsername = "https://harbor.example.com", "robot$ephemeron"
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		c.HarborProjectRetention = -time.Hour
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for negative HarborProjectRetention")
+		}
+	})
+
+	t.Run("sweeper without batch size", func(t *testing.T) {
+		c := base()
+		c.SweepInterval = time.Minute
+		c.SweepBatchSize = 0
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for SweepInterval without SweepBatchSize")
+		}
+	})
+
+	t.Run("size repair without batch size", func(t *testing.T) {
+		c := base()
+		c.SizeRepairInterval = time.Minute
+		c.SizeRepairBatchSize = 0
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for SizeRepairInterval without SizeRepairBatchSize")
+		}
+	})
+
+	t.Run("usage history retention", func(t *testing.T) {
+		c := base()
+		c.UsageHistoryInterval = time.Hour
+		c.UsageHistoryRetention = time.Hour
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for UsageHistoryRetention shorter than a day")
+		}
+		c.UsageHistoryInterval = 0
+		if err := c.Validate(); err != nil {
+			t.Errorf("unexpected error with the history disabled: %v", err)
+		}
+	})
+
+	t.Run("redis max replication lag", func(t *testing.T) {
+		c := base()
+		c.RedisMaxReplicationLag = -time.Second
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for negative RedisMaxReplicationLag")
+		}
+	})
+
+	t.Run("probe port", func(t *testing.T) {
+		c := base()
+		c.InternalPort, c.ProbePort = 9090, 9090
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for PROBE_PORT equal to INTERNAL_PORT")
+		}
+		c.ProbePort = 9091
+		c.AuthExemptPaths = []string{"["}
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for an invalid AUTH_EXEMPT_PATHS glob")
+		}
+	})
+
+	t.Run("secret references", func(t *testing.T) {
+		c := base()
+		c.HookToken, c.HookTokenRef = "", "file:/run/secrets/hook-token"
+		if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "could not be read") {
+			t.Errorf("expected error for an unreadable HOOK_TOKEN, got %v", err)
+		}
+		c.HookToken, c.HookTokenRef = "token", "vault:secret/data/ephemeron#hook_token"
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for a vault reference without VAULT_ADDR")
+		}
+		c.Secrets.VaultAddr, c.Secrets.VaultToken = "https://vault:8200", "s.token"
+		if err := c.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		c.RedisPassword, c.RedisPasswordRef = "pw", "ssm:ephemeron"
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for an unknown secret reference scheme")
+		}
+	})
+
+	t.Run("field encryption", func(t *testing.T) {
+		c := base()
+		c.FieldEncryptionOldKeys = []string{"AAAAAAAAAAAAAAAAAAAAAA=="}
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for old keys without FIELD_ENCRYPTION_KEY")
+		}
+		c.FieldEncryptionKeyRef = "file:/run/secrets/field-key"
+		if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "could not be read") {
+			t.Errorf("expected error for an unreadable FIELD_ENCRYPTION_KEY, got %v", err)
+		}
+		c.FieldEncryptionKey = "c2hvcnQ="
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for a 5-byte key")
+		}
+		c.FieldEncryptionKey = "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="
+		if err := c.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if k, err := c.FieldKeyring(); err != nil || k == nil {
+			t.Errorf("FieldKeyring() = %v, %v; want a keyring", k, err)
+		}
+	})
+
+	t.Run("fault injection", func(t *testing.T) {
+		c := base()
+		c.Faults.FailRate = 2
+		if err := c.Validate(); err != nil {
+			t.Errorf("rates must not be checked while fault injection is off: %v", err)
+		}
+		c.Faults.Enabled = true
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for FailRate above 1")
+		}
+		c.Faults.FailRate = 0.5
+		c.Faults.DelayRate = -0.1
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for negative DelayRate")
+		}
+	})
+
+	t.Run("reap schedule", func(t *testing.T) {
+		c := base()
+		c.ReapSchedule = "*/5 * * * *"
+		c.ReapJitter = 30 * time.Second
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		c.ReapSchedule = "*/5 * * *"
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for invalid ReapSchedule")
+		}
+		c = base()
+		c.ReapJitter = -time.Second
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for negative ReapJitter")
+		}
+	})
+
+	t.Run("reap cycle budget", func(t *testing.T) {
+		c := base()
+		c.ReapMaxDeletes = -1
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for negative ReapMaxDeletes")
+		}
+		c = base()
+		c.ReapMaxDuration = -time.Minute
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for negative ReapMaxDuration")
+		}
+	})
+
+	t.Run("tombstone retention", func(t *testing.T) {
+		c := base()
+		c.TombstoneRetention = -time.Hour
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for negative TombstoneRetention")
+		}
+	})
+
+	t.Run("reconcile", func(t *testing.T) {
+		c := base()
+		c.ReconcileConcurrency = -1
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for negative ReconcileConcurrency")
+		}
+		c = base()
+		c.ReconcileRate = -1
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for negative ReconcileRate")
+		}
+		c = base()
+		c.ReconcileBatchSize = -1
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for negative ReconcileBatchSize")
+		}
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		c := base()
+		c.RestoreEnabled = true
+		c.TombstoneRetention = 0
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for RestoreEnabled without TombstoneRetention")
+		}
+		c.TombstoneRetention = time.Hour
+		if err := c.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("archive", func(t *testing.T) {
+		c := base()
+		c.Archive = archive.Config{Bucket: "audit"}
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for archive bucket without endpoint")
+		}
+		c.Archive.Endpoint = "https://storage.googleapis.com"
+		if err := c.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		c.Archive.Dir = "/archive"
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for both archive directory and bucket")
+		}
+	})
+
+	t.Run("digest strategy", func(t *testing.T) {
+		c := base()
+		c.ReapDigestStrategy = "index"
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		c.ReapDigestStrategy = "newest"
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for unknown digest strategy")
+		}
+	})
+
+	t.Run("cache repos", func(t *testing.T) {
+		c := base()
+		c.CacheRepoTTL = -time.Hour
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for negative CacheRepoTTL")
+		}
+		c.CacheRepoTTL = time.Hour
+		c.CacheRepos = []string{"[cache"}
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for malformed CacheRepos pattern")
+		}
+	})
+
+	t.Run("overwrite policy", func(t *testing.T) {
+		c := base()
+		c.ReapOverwritePolicy = "retrack"
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		c.ReapOverwritePolicy = "ignore"
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for unknown overwrite policy")
+		}
+	})
+
+	t.Run("delete probe", func(t *testing.T) {
+		c := base()
+		c.DeleteProbeInterval = -time.Minute
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for negative DeleteProbeInterval")
+		}
+	})
+
+	t.Run("hsts", func(t *testing.T) {
+		c := base()
+		c.HSTSMaxAge = -time.Second
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for negative HSTS max age")
+		}
+	})
+
+	t.Run("image debug rate limit", func(t *testing.T) {
+		c := base()
+		c.ImageDebugRateLimit = -1
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for negative IMAGE_DEBUG_RATE_LIMIT")
+		}
+	})
+
+	t.Run("oidc", func(t *testing.T) {
+		c := base()
+		c.OIDCIssuerURL = "https://idp.example.com"
+		c.OIDCClientID = "ephemeron"
+		c.OIDCSessionSecret = strings.Repeat("s", 32)
+		c.OIDCGroupRoles = []string{"sre=admin"}
+		c.OIDCSessionTTL = time.Hour
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		c.OIDCSessionSecret = "short"
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for short session secret")
+		}
+		c.OIDCSessionSecret = strings.Repeat("s", 32)
+		c.OIDCGroupRoles = nil
+		if err := c.Validate(); err == nil {
+			t.Error("expected error without group roles")
+		}
+	})
+
+	t.Run("kube scan", func(t *testing.T) {
+		c := base()
+		c.KubeScan = true
+		c.KubeScanInterval = time.Minute
+		c.KubeWorkloadKinds = []string{"pods", "cronjobs"}
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		c.KubeWorkloadKinds = []string{"pods", "services"}
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for unsupported workload kind")
+		}
+		c.KubeWorkloadKinds = []string{"pods"}
+		c.KubeScanInterval = 0
+		if err := c.Validate(); err == nil {
+			t.Error("expected error for KubeScanInterval = 0")
+		}
+	})
+
+	t.Run("non-positive eviction target", func(t *testing.T) {
+		c := base()
+		c.EvictionTargetBytes = 0
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for EvictionTargetBytes = 0")
+		}
+	})
+
+	t.Run("min free bytes without data path", func(t *testing.T) {
+		c := base()
+		c.EvictionMinFreeBytes = 1 << 30
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for EvictionMinFreeBytes without RegistryDataPath")
+		}
+		c.RegistryDataPath = "/var/lib/registry"
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("repo cleanup", func(t *testing.T) {
+		c := base()
+		c.RepoCleanup = "nuke"
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for unknown RepoCleanup")
+		}
+		c.RepoCleanup = RepoCleanupFilesystem
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for filesystem cleanup without RegistryDataPath")
+		}
+		c.RepoCleanup = RepoCleanupHarbor
+		c.HarborURL = "https://harbor.example.com"
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for harbor cleanup without HarborUsername")
+		}
+		c.HarborUsername = "robot$ephemeron"
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("policy webhook", func(t *testing.T) {
+		c := base()
+		c.PolicyWebhookURL = "http://policy.example.com/check"
+		c.PolicyWebhookTimeout = 2 * time.Second
+		c.PolicyWebhookDefault = "maybe"
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for unknown PolicyWebhookDefault")
+		}
+		c.PolicyWebhookDefault = PolicyDeny
+		c.PolicyWebhookTimeout = 0
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for zero PolicyWebhookTimeout")
+		}
+		c.PolicyWebhookTimeout = time.Second
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("rego policy", func(t *testing.T) {
+		c := base()
+		c.PolicyRegoFile = "/etc/ephemeron/policy.rego"
+		c.PolicyWebhookDefault = PolicyAllow
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		c.PolicyWebhookURL = "http://policy.example.com/check"
+		c.PolicyWebhookTimeout = time.Second
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for both a policy webhook and a Rego file")
+		}
+	})
+
+	t.Run("cel policy", func(t *testing.T) {
+		c := base()
+		c.PolicyCELProtect = `actor == "release-bot"`
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		c.PolicyRegoFile = "/etc/ephemeron/policy.rego"
+		c.PolicyWebhookDefault = PolicyAllow
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for both CEL expressions and a Rego file")
+		}
+	})
+
+	t.Run("bucket without endpoint", func(t *testing.T) {
+		c := base()
+		c.Bucket = bucketusage.Config{Bucket: "registry"}
+		c.BucketProbeInterval = time.Hour
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for Bucket without Endpoint")
+		}
+		c.Bucket.Endpoint = "https://storage.googleapis.com"
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		c.Bucket.SampleShards = 257
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for SampleShards > 256")
+		}
+	})
+
+	t.Run("invalid repo owner entry", func(t *testing.T) {
+		c := base()
+		c.RepoOwners = []string{"team-a/*"}
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for RepoOwners entry without owner")
+		}
+	})
+
 	t.Run("missing hook token", func(t *testing.T) {
 		c := base()
 		c.HookToken = ""
@@ -68,6 +658,38 @@ func TestValidate(t *testing.T) {
 		}
 	})
 
+	t.Run("non-positive artifact ttl", func(t *testing.T) {
+		c := base()
+		c.ArtifactTTLs = map[string]time.Duration{"helm": 0}
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for zero artifact TTL")
+		}
+	})
+
+	t.Run("artifact ttl above max ttl", func(t *testing.T) {
+		c := base()
+		c.ArtifactTTLs = map[string]time.Duration{"helm": c.MaxTTL + time.Hour}
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for an artifact TTL above MaxTTL")
+		}
+	})
+
+	t.Run("negative latency threshold", func(t *testing.T) {
+		c := base()
+		c.ReapLatencyThreshold = -time.Second
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for negative ReapLatencyThreshold")
+		}
+	})
+
+	t.Run("latency threshold without pacing delay", func(t *testing.T) {
+		c := base()
+		c.ReapLatencyThreshold = time.Second
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error when ReapPacingDelay is not set")
+		}
+	})
+
 	t.Run("zero health threshold", func(t *testing.T) {
 		c := base()
 		c.HealthFailureThreshold = 0
@@ -75,4 +697,102 @@ func TestValidate(t *testing.T) {
 			t.Fatal("expected error for zero HealthFailureThreshold")
 		}
 	})
+
+	t.Run("delete hook without timeout", func(t *testing.T) {
+		c := base()
+		c.PostDeleteWebhook = "http://cmdb.local/hooks/deleted"
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error when DeleteHookTimeout is not set")
+		}
+		c.DeleteHookTimeout = time.Second
+		if err := c.Validate(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	})
+
+	t.Run("slack scopes without signing secret", func(t *testing.T) {
+		c := base()
+		c.SlackUserScopes = []string{"U01ABC=team-a/*"}
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error when SlackSigningSecret is not set")
+		}
+	})
+
+	t.Run("pull request tag pattern", func(t *testing.T) {
+		c := base()
+		c.PRWebhookSecret = "s3cret"
+		c.PRTagPattern = `^pr-\d+`
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for pattern without capture group")
+		}
+		c.PRTagPattern = `^pr-(\d+`
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for invalid pattern")
+		}
+		c.PRTagPattern = `^pr-(\d+)`
+		if err := c.Validate(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	})
+
+	t.Run("negative storage price", func(t *testing.T) {
+		c := base()
+		c.StoragePrice = -0.02
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for negative StoragePrice")
+		}
+	})
+
+	t.Run("export bucket", func(t *testing.T) {
+		c := base()
+		c.Export = export.Config{Bucket: "chargeback", Format: export.FormatCSV}
+		c.ExportInterval = 24 * time.Hour
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for export bucket without endpoint")
+		}
+		c.Export.Endpoint = "https://s3.eu-central-1.amazonaws.com"
+		if err := c.Validate(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		c.Export.Format = "xlsx"
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected error for unknown export format")
+		}
+	})
+}
+
+func TestHash(t *testing.T) {
+	a := Config{RedisURL: "redis://localhost:6379", ArtifactTTLs: map[string]time.Duration{"helm": time.Hour}}
+	b := a
+	if a.Hash() != b.Hash() {
+		t.Error("equal configs should hash equally")
+	}
+	b.ReapInterval = time.Hour
+	if a.Hash() == b.Hash() {
+		t.Error("different configs should hash differently")
+	}
+}
+
+func TestParseListenAddr(t *testing.T) {
+	tests := []struct {
+		addr, network, address string
+		wantErr                bool
+	}{
+		{addr: "8000", network: "tcp", address: ":8000"},
+		{addr: ":8000", network: "tcp", address: ":8000"},
+		{addr: "[::]:8000", network: "tcp", address: "[::]:8000"},
+		{addr: "127.0.0.1:9090", network: "tcp", address: "127.0.0.1:9090"},
+		{addr: "unix:///var/run/ephemeron.sock", network: "unix", address: "/var/run/ephemeron.sock"},
+		{addr: "unix://", wantErr: true},
+		{addr: "70000", wantErr: true},
+		{addr: "localhost:http", wantErr: true},
+		{addr: "::1", wantErr: true},
+	}
+	for _, tt := range tests {
+		network, address, err := ParseListenAddr(tt.addr)
+		if (err != nil) != tt.wantErr || network != tt.network || address != tt.address {
+			t.Errorf("ParseListenAddr(%q) = %q, %q, %v; want %q, %q, error %v",
+				tt.addr, network, address, err, tt.network, tt.address, tt.wantErr)
+		}
+	}
 }
@@ -0,0 +1,362 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/archive"
+	"github.com/tamcore/ephemeron/internal/bucketusage"
+	"github.com/tamcore/ephemeron/internal/export"
+	"github.com/tamcore/ephemeron/internal/faults"
+	"github.com/tamcore/ephemeron/internal/hooks"
+	"github.com/tamcore/ephemeron/internal/imagedebug"
+	"github.com/tamcore/ephemeron/internal/kube"
+	"github.com/tamcore/ephemeron/internal/reaper"
+	"github.com/tamcore/ephemeron/internal/registry"
+	"github.com/tamcore/ephemeron/internal/secrets"
+	"github.com/tamcore/ephemeron/internal/web"
+)
+
+// secretFetchTimeout bounds reading each secret reference at startup.
+const secretFetchTimeout = 30 * time.Second
+
+// LogFormat returns LOG_FORMAT, or fallback if it is unset, for the logger
+// the rest of the configuration is read with.
+func LogFormat(fallback string) string {
+	return envStr("LOG_FORMAT", fallback)
+}
+
+// FromEnv reads the configuration from the environment. Malformed values are
+// logged and replaced by their defaults; secrets given by reference are read
+// once here. Fault injection is left disabled.
+func FromEnv(logger *slog.Logger) *Config {
+	sc := secrets.Config{
+		VaultAddr:          envStr("VAULT_ADDR", ""),
+		VaultToken:         envStr("VAULT_TOKEN", ""),
+		VaultTokenFile:     envStr("VAULT_TOKEN_FILE", ""),
+		AWSRegion:          envStr("AWS_REGION", envStr("AWS_DEFAULT_REGION", "")),
+		AWSAccessKeyID:     envStr("AWS_ACCESS_KEY_ID", ""),
+		AWSSecretAccessKey: envStr("AWS_SECRET_ACCESS_KEY", ""),
+		AWSSessionToken:    envStr("AWS_SESSION_TOKEN", ""),
+		AWSEndpoint:        envStr("AWS_SECRETS_MANAGER_ENDPOINT", ""),
+		AWSKMSEndpoint:     envStr("AWS_KMS_ENDPOINT", ""),
+		RefreshInterval:    envDuration(logger, "SECRET_REFRESH_INTERVAL", 5*time.Minute),
+	}
+	return &Config{
+		Port:                   envInt(logger, "PORT", 8000),
+		InternalPort:           envInt(logger, "INTERNAL_PORT", 9090),
+		ListenAddr:             envStr("LISTEN_ADDR", ""),
+		InternalListenAddr:     envStr("INTERNAL_LISTEN_ADDR", ""),
+		BasePath:               strings.TrimRight(envStr("BASE_PATH", ""), "/"),
+		ProbePort:              envInt(logger, "PROBE_PORT", 0),
+		StoreBackend:           envStr("STORE_BACKEND", StoreBackendRedis),
+		RedisURL:               envStr("REDIS_URL", envStr("REDISCLOUD_URL", "redis://localhost:6379")),
+		RedisPassword:          envSecret(logger, sc, "REDIS_PASSWORD"),
+		RedisPasswordRef:       secretRef("REDIS_PASSWORD"),
+		RedisKeyPrefix:         envStr("REDIS_KEY_PREFIX", ""),
+		RedisDB:                envInt(logger, "REDIS_DB", -1),
+		RedisNativeExpiry:      envBool(logger, "REDIS_NATIVE_EXPIRY", false),
+		RedisMaxReplicationLag: envDuration(logger, "REDIS_MAX_REPLICATION_LAG", 0),
+		FieldEncryptionKey:     envSecret(logger, sc, "FIELD_ENCRYPTION_KEY"),
+		FieldEncryptionKeyRef:  secretRef("FIELD_ENCRYPTION_KEY"),
+		FieldEncryptionOldKeys: splitList(envSecret(logger, sc, "FIELD_ENCRYPTION_OLD_KEYS")),
+		StoreFailureMode:       envStr("STORE_FAILURE_MODE", StoreFailClosed),
+		JournalPath:            envStr("JOURNAL_PATH", ""),
+		JournalWriteAhead:      envBool(logger, "JOURNAL_WRITE_AHEAD", false),
+		HookToken:              envSecret(logger, sc, "HOOK_TOKEN"),
+		HookTokenRef:           secretRef("HOOK_TOKEN"),
+		HookSourceTokens:       envStrSlice("HOOK_SOURCE_TOKENS", nil),
+		HookSources:            envStrSlice("HOOK_SOURCES", nil),
+		WebhookMaxEvents:       envInt(logger, "WEBHOOK_MAX_EVENTS", hooks.DefaultMaxEvents),
+		WebhookEventFailures:   envStr("WEBHOOK_EVENT_FAILURES", hooks.EventFailuresAbort),
+		WebhookSkewTolerance:   envDuration(logger, "WEBHOOK_CLOCK_SKEW_TOLERANCE", 0),
+		WebhookSkewWarn:        envDuration(logger, "WEBHOOK_CLOCK_SKEW_WARN", time.Minute),
+		WebhookMaxInFlight:     envInt(logger, "WEBHOOK_MAX_IN_FLIGHT", 0),
+		WebhookStoreLatency:    envDuration(logger, "WEBHOOK_STORE_LATENCY_THRESHOLD", 0),
+		WebhookRetryAfter:      envDuration(logger, "WEBHOOK_RETRY_AFTER", 10*time.Second),
+		PolicyWebhookURL:       envStr("POLICY_WEBHOOK_URL", ""),
+		PolicyWebhookToken:     envSecret(logger, sc, "POLICY_WEBHOOK_TOKEN"),
+		PolicyWebhookTimeout:   envDuration(logger, "POLICY_WEBHOOK_TIMEOUT", 2*time.Second),
+		PolicyWebhookDefault:   envStr("POLICY_WEBHOOK_DEFAULT", PolicyAllow),
+		PolicyRegoFile:         envStr("POLICY_REGO_FILE", ""),
+		PolicyCELTTL:           envStr("POLICY_CEL_TTL", ""),
+		PolicyCELProtect:       envStr("POLICY_CEL_PROTECT", ""),
+		PolicyCELPriority:      envStr("POLICY_CEL_PRIORITY", ""),
+		DecisionTrace:          envBool(logger, "DECISION_TRACE", false),
+		ExtendTagSeparator:     envStr("EXTEND_TAG_SEPARATOR", hooks.DefaultExtendSeparator),
+		RegistryURL:            envStr("REGISTRY_URL", "http://localhost:5000"),
+		RegistryUsername:       envStr("REGISTRY_USERNAME", ""),
+		RegistryPassword:       envSecret(logger, sc, "REGISTRY_PASSWORD"),
+		ManifestAcceptTypes:    envStrSlice("REGISTRY_MANIFEST_ACCEPT", nil),
+		RegistryTimeout:        envDuration(logger, "REGISTRY_TIMEOUT", registry.DefaultTimeout),
+		RegistryRetries:        envInt(logger, "REGISTRY_RETRIES", 0),
+		RegistryRetryBackoff:   envDuration(logger, "REGISTRY_RETRY_BACKOFF", 500*time.Millisecond),
+		RegistryProxy:          envStr("REGISTRY_PROXY", ""),
+		Hostname:               envStr("HOSTNAME_OVERRIDE", "localhost"),
+		WebTemplateDir:         envStr("WEB_TEMPLATE_DIR", ""),
+		WebTemplateVars:        envStrSlice("WEB_TEMPLATE_VARS", nil),
+		DefaultTTL:             envDuration(logger, "DEFAULT_TTL", time.Hour),
+		MaxTTL:                 envDuration(logger, "MAX_TTL", 24*time.Hour),
+		ArtifactTTLs:           envDurationMap(logger, "ARTIFACT_TTLS"),
+		CacheRepos:             envStrSlice("CACHE_REPOS", hooks.DefaultCacheRepos),
+		CacheRepoTTL:           envDuration(logger, "CACHE_REPO_TTL", 0),
+		TTLTagValidation:       envStr("TTL_TAG_VALIDATION", TTLTagValidationOff),
+		NonTTLTags:             envStr("NON_TTL_TAGS", hooks.NonTTLTrack),
+		ReapInterval:           envDuration(logger, "REAP_INTERVAL", time.Minute),
+		ReapSchedule:           envStr("REAP_SCHEDULE", ""),
+		ReapJitter:             envDuration(logger, "REAP_JITTER", 0),
+		ReapLatencyThreshold:   envDuration(logger, "REAP_LATENCY_THRESHOLD", 0),
+		ReapPacingDelay:        envDuration(logger, "REAP_PACING_DELAY", 5*time.Second),
+		ReapMaxFailures:        envInt(logger, "REAP_MAX_FAILURES", 0),
+		ReapVerifyDeletes:      envBool(logger, "REAP_VERIFY_DELETES", false),
+		ReapDigestStrategy:     envStr("REAP_DIGEST_STRATEGY", reaper.DigestPushed),
+		ReapOverwritePolicy:    envStr("REAP_OVERWRITE_POLICY", reaper.OverwriteDelete),
+		TombstoneRetention:     envDuration(logger, "TOMBSTONE_RETENTION", 7*24*time.Hour),
+		RestoreEnabled:         envBool(logger, "RESTORE_ENABLED", false),
+		ReapQuarantineAfter:    envInt(logger, "REAP_QUARANTINE_AFTER", 0),
+		ReapMaxDeletes:         envInt(logger, "REAP_MAX_DELETES", 0),
+		ReapMaxDuration:        envDuration(logger, "REAP_MAX_DURATION", 0),
+		EvictionTargetBytes:    int64(envInt(logger, "EVICTION_TARGET_BYTES", 1<<30)),
+		RegistryDataPath:       envStr("REGISTRY_DATA_PATH", ""),
+		EvictionMinFreeBytes:   int64(envInt(logger, "EVICTION_MIN_FREE_BYTES", 0)),
+		RepoCleanup:            envStr("REPO_CLEANUP", ""),
+		PreDeleteCommand:       envStr("PRE_DELETE_COMMAND", ""),
+		PreDeleteWebhook:       envStr("PRE_DELETE_WEBHOOK", ""),
+		PostDeleteCommand:      envStr("POST_DELETE_COMMAND", ""),
+		PostDeleteWebhook:      envStr("POST_DELETE_WEBHOOK", ""),
+		DeleteHookTimeout:      envDuration(logger, "DELETE_HOOK_TIMEOUT", 30*time.Second),
+		HarborURL:              envStr("HARBOR_URL", envStr("REGISTRY_URL", "http://localhost:5000")),
+		HarborUsername:         envStr("HARBOR_USERNAME", ""),
+		HarborPassword:         envSecret(logger, sc, "HARBOR_PASSWORD"),
+		HarborTimeout:          envDuration(logger, "HARBOR_TIMEOUT", 10*time.Second),
+		HarborCreateProjects:   envBool(logger, "HARBOR_CREATE_PROJECTS", false),
+		HarborProjectRetention: envDuration(logger, "HARBOR_PROJECT_RETENTION", 0),
+		HarborProjectPublic:    envBool(logger, "HARBOR_PROJECT_PUBLIC", false),
+		KubeScan:               envBool(logger, "KUBE_SCAN", false),
+		KubeScanInterval:       envDuration(logger, "KUBE_SCAN_INTERVAL", time.Minute),
+		KubeWorkloadKinds:      envStrSlice("KUBE_WORKLOAD_KINDS", kube.DefaultKinds),
+		KubeNamespaces:         envStrSlice("KUBE_NAMESPACES", nil),
+		KubeLabelSelector:      envStr("KUBE_LABEL_SELECTOR", ""),
+		KubeRegistryHosts:      envStrSlice("KUBE_REGISTRY_HOSTS", nil),
+		SweepInterval:          envDuration(logger, "SWEEP_INTERVAL", 0),
+		SweepBatchSize:         envInt(logger, "SWEEP_BATCH_SIZE", 20),
+		SizeRepairInterval:     envDuration(logger, "SIZE_REPAIR_INTERVAL", 15*time.Minute),
+		SizeRepairBatchSize:    envInt(logger, "SIZE_REPAIR_BATCH_SIZE", 20),
+		UsageHistoryInterval:   envDuration(logger, "USAGE_HISTORY_INTERVAL", time.Hour),
+		UsageHistoryRetention:  envDuration(logger, "USAGE_HISTORY_RETENTION", 90*24*time.Hour),
+		ReportInterval:         envDuration(logger, "REPORT_INTERVAL", 7*24*time.Hour),
+		ReconcileInterval:      envDuration(logger, "RECONCILE_INTERVAL", 0),
+		ReconcileConcurrency:   envInt(logger, "RECONCILE_CONCURRENCY", 4),
+		ReconcileRate:          envFloat(logger, "RECONCILE_RATE", 0),
+		ReconcileBatchSize:     envInt(logger, "RECONCILE_BATCH_SIZE", 0),
+		LogFormat:              envStr("LOG_FORMAT", "json"),
+		ImmutableTagPatterns:   envStrSlice("IMMUTABLE_TAG_PATTERNS", nil),
+		ProtectedPatterns:      envStrSlice("PROTECTED_PATTERNS", nil),
+		ApproverTokens:         envStrSlice("APPROVER_TOKENS", nil),
+		APIAuth:                envBool(logger, "API_AUTH", false),
+		APIAuthAllPaths:        envBool(logger, "API_AUTH_ALL_PATHS", false),
+		AuthExemptPaths:        envStrSlice("AUTH_EXEMPT_PATHS", []string{"/healthz", "/readyz"}),
+		ImageDebugRateLimit:    envInt(logger, "IMAGE_DEBUG_RATE_LIMIT", imagedebug.DefaultRateLimit),
+		OIDCIssuerURL:          envStr("OIDC_ISSUER_URL", ""),
+		OIDCClientID:           envStr("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:       envSecret(logger, sc, "OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:        envStr("OIDC_REDIRECT_URL", ""),
+		OIDCScopes:             envStrSlice("OIDC_SCOPES", []string{"openid", "profile", "email"}),
+		OIDCGroupsClaim:        envStr("OIDC_GROUPS_CLAIM", "groups"),
+		OIDCGroupRoles:         envStrSlice("OIDC_GROUP_ROLES", nil),
+		OIDCSessionSecret:      envSecret(logger, sc, "OIDC_SESSION_SECRET"),
+		OIDCSessionTTL:         envDuration(logger, "OIDC_SESSION_TTL", 8*time.Hour),
+		ContentSecurityPolicy:  envStr("CONTENT_SECURITY_POLICY", web.DefaultContentSecurityPolicy),
+		HSTSMaxAge:             envDuration(logger, "HSTS_MAX_AGE", 0),
+		SlackSigningSecret:     envSecret(logger, sc, "SLACK_SIGNING_SECRET"),
+		SlackUserScopes:        envStrSlice("SLACK_USER_SCOPES", nil),
+		PRWebhookSecret:        envSecret(logger, sc, "PR_WEBHOOK_SECRET"),
+		PRTagPattern:           envStr("PR_TAG_PATTERN", `^pr-(\d+)`),
+		PRRepositories:         envStrSlice("PR_REPOSITORIES", nil),
+		RepoOwners:             envStrSlice("REPO_OWNERS", nil),
+		HealthFailureThreshold: envInt(logger, "HEALTH_FAILURE_THRESHOLD", 3),
+		DeleteProbeInterval:    envDuration(logger, "DELETE_PROBE_INTERVAL", 0),
+		RequireDelete:          envBool(logger, "REQUIRE_DELETE", false),
+		Bucket: bucketusage.Config{
+			Endpoint:        envStr("STORAGE_BUCKET_ENDPOINT", ""),
+			Bucket:          envStr("STORAGE_BUCKET", ""),
+			Prefix:          envStr("STORAGE_BUCKET_PREFIX", ""),
+			Region:          envStr("STORAGE_BUCKET_REGION", "us-east-1"),
+			AccessKeyID:     envStr("STORAGE_BUCKET_ACCESS_KEY_ID", envStr("AWS_ACCESS_KEY_ID", "")),
+			SecretAccessKey: envStr("STORAGE_BUCKET_SECRET_ACCESS_KEY", envStr("AWS_SECRET_ACCESS_KEY", "")),
+			SampleShards:    envInt(logger, "STORAGE_BUCKET_SAMPLE_SHARDS", 16),
+		},
+		Faults: faults.Config{
+			FailRate:  envFloat(logger, "FAULT_FAIL_RATE", 0.05),
+			DelayRate: envFloat(logger, "FAULT_DELAY_RATE", 0.1),
+			MaxDelay:  envDuration(logger, "FAULT_MAX_DELAY", 2*time.Second),
+		},
+		BucketProbeInterval: envDuration(logger, "STORAGE_BUCKET_PROBE_INTERVAL", time.Hour),
+		Export: export.Config{
+			Endpoint:        envStr("EXPORT_BUCKET_ENDPOINT", ""),
+			Bucket:          envStr("EXPORT_BUCKET", ""),
+			Prefix:          envStr("EXPORT_BUCKET_PREFIX", ""),
+			Region:          envStr("EXPORT_BUCKET_REGION", "us-east-1"),
+			AccessKeyID:     envStr("EXPORT_BUCKET_ACCESS_KEY_ID", envStr("AWS_ACCESS_KEY_ID", "")),
+			SecretAccessKey: envStr("EXPORT_BUCKET_SECRET_ACCESS_KEY", envStr("AWS_SECRET_ACCESS_KEY", "")),
+			Format:          envStr("EXPORT_FORMAT", export.FormatCSV),
+		},
+		ExportInterval:      envDuration(logger, "EXPORT_INTERVAL", 24*time.Hour),
+		StoragePrice:        envFloat(logger, "STORAGE_PRICE_PER_GB_MONTH", 0),
+		LargestImagesMetric: envInt(logger, "LARGEST_IMAGES_METRIC", 10),
+		Archive: archive.Config{
+			Dir:             envStr("ARCHIVE_DIR", ""),
+			Endpoint:        envStr("ARCHIVE_BUCKET_ENDPOINT", ""),
+			Bucket:          envStr("ARCHIVE_BUCKET", ""),
+			Prefix:          envStr("ARCHIVE_BUCKET_PREFIX", ""),
+			Region:          envStr("ARCHIVE_BUCKET_REGION", "us-east-1"),
+			AccessKeyID:     envStr("ARCHIVE_BUCKET_ACCESS_KEY_ID", envStr("AWS_ACCESS_KEY_ID", "")),
+			SecretAccessKey: envStr("ARCHIVE_BUCKET_SECRET_ACCESS_KEY", envStr("AWS_SECRET_ACCESS_KEY", "")),
+		},
+		Secrets: sc,
+	}
+}
+
+func envStr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// secretRef returns the secret reference the secret key is read from: the
+// file named by KEY_FILE, or the secret manager reference in KEY_FROM. It
+// returns "" if the secret is set directly in KEY.
+func secretRef(key string) string {
+	if path := os.Getenv(key + "_FILE"); path != "" {
+		return secrets.SchemeFile + ":" + path
+	}
+	return os.Getenv(key + "_FROM")
+}
+
+// envSecret returns the secret key, read from its secretRef if it has one
+// and from KEY otherwise. Errors are logged and return "", which Validate
+// reports for required secrets.
+func envSecret(logger *slog.Logger, sc secrets.Config, key string) string {
+	ref := secretRef(key)
+	if ref == "" {
+		return os.Getenv(key)
+	}
+	if os.Getenv(key) != "" {
+		logger.Warn("secret is set both directly and by reference, using the reference", "key", key)
+	}
+	src, err := sc.Parse(ref)
+	if err != nil {
+		logger.Error("invalid secret reference", "key", key, "error", err)
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), secretFetchTimeout)
+	defer cancel()
+	v, err := src.Fetch(ctx)
+	if err != nil {
+		logger.Error("failed to read secret", "key", key, "ref", ref, "error", err)
+		return ""
+	}
+	return v
+}
+
+func envInt(logger *slog.Logger, key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		logger.Warn("invalid integer in environment variable, using fallback",
+			"key", key, "value", v, "fallback", fallback)
+		return fallback
+	}
+	return n
+}
+
+func envFloat(logger *slog.Logger, key string, fallback float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		logger.Warn("invalid number in environment variable, using fallback",
+			"key", key, "value", v, "fallback", fallback)
+		return fallback
+	}
+	return f
+}
+
+func envDuration(logger *slog.Logger, key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		logger.Warn("invalid duration in environment variable, using fallback",
+			"key", key, "value", v, "fallback", fallback.String())
+		return fallback
+	}
+	return d
+}
+
+func envBool(logger *slog.Logger, key string, fallback bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		logger.Warn("invalid boolean in environment variable, using fallback",
+			"key", key, "value", v, "fallback", fallback)
+		return fallback
+	}
+	return b
+}
+
+func envStrSlice(key string, fallback []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	return splitList(v)
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(v string) []string {
+	var result []string
+	for s := range strings.SplitSeq(v, ",") {
+		trimmed := strings.TrimSpace(s)
+		if trimmed != "" {
+			result = append(result, trimmed)
+		}
+	}
+	return result
+}
+
+// envDurationMap parses "key=duration" pairs separated by commas, e.g.
+// "helm=24h,wasm=2h". Malformed entries are skipped with a warning.
+func envDurationMap(logger *slog.Logger, key string) map[string]time.Duration {
+	entries := envStrSlice(key, nil)
+	if len(entries) == 0 {
+		return nil
+	}
+	result := make(map[string]time.Duration, len(entries))
+	for _, entry := range entries {
+		name, value, ok := strings.Cut(entry, "=")
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if !ok || err != nil {
+			logger.Warn("invalid entry in environment variable, skipping",
+				"key", key, "entry", entry)
+			continue
+		}
+		result[strings.TrimSpace(name)] = d
+	}
+	return result
+}
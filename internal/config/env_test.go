@@ -0,0 +1,203 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/hooks"
+	"github.com/tamcore/ephemeron/internal/secrets"
+)
+
+func TestEnvInt(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		set      bool
+		fallback int
+		want     int
+	}{
+		{name: "unset uses fallback", set: false, fallback: 8000, want: 8000},
+		{name: "valid value", value: "9999", set: true, fallback: 8000, want: 9999},
+		{name: "negative value", value: "-1", set: true, fallback: 8000, want: -1},
+		{name: "malformed value uses fallback", value: "abc", set: true, fallback: 8000, want: 8000},
+		{name: "trailing garbage uses fallback", value: "12x", set: true, fallback: 8000, want: 8000},
+		{name: "empty value uses fallback", value: "", set: true, fallback: 8000, want: 8000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := "TEST_ENV_INT"
+			if tt.set {
+				t.Setenv(key, tt.value)
+			}
+			got := envInt(slog.Default(), key, tt.fallback)
+			if got != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEnvDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		set      bool
+		fallback time.Duration
+		want     time.Duration
+	}{
+		{name: "unset uses fallback", set: false, fallback: time.Hour, want: time.Hour},
+		{name: "valid value", value: "30m", set: true, fallback: time.Hour, want: 30 * time.Minute},
+		{name: "malformed value uses fallback", value: "abc", set: true, fallback: time.Hour, want: time.Hour},
+		{name: "bare number uses fallback", value: "30", set: true, fallback: time.Hour, want: time.Hour},
+		{name: "empty value uses fallback", value: "", set: true, fallback: time.Hour, want: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := "TEST_ENV_DURATION"
+			if tt.set {
+				t.Setenv(key, tt.value)
+			}
+			got := envDuration(slog.Default(), key, tt.fallback)
+			if got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEnvFloat(t *testing.T) {
+	t.Setenv("TEST_ENV_FLOAT", "0.023")
+	if got := envFloat(slog.Default(), "TEST_ENV_FLOAT", 0); got != 0.023 {
+		t.Errorf("expected 0.023, got %v", got)
+	}
+	t.Setenv("TEST_ENV_FLOAT", "cheap")
+	if got := envFloat(slog.Default(), "TEST_ENV_FLOAT", 1.5); got != 1.5 {
+		t.Errorf("expected fallback 1.5 for malformed value, got %v", got)
+	}
+}
+
+func TestEnvBool(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		set      bool
+		fallback bool
+		want     bool
+	}{
+		{name: "unset uses fallback", set: false, fallback: true, want: true},
+		{name: "true", value: "true", set: true, want: true},
+		{name: "numeric", value: "1", set: true, want: true},
+		{name: "false overrides fallback", value: "false", set: true, fallback: true, want: false},
+		{name: "malformed uses fallback", value: "yes please", set: true, fallback: true, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := "TEST_ENV_BOOL"
+			if tt.set {
+				t.Setenv(key, tt.value)
+			}
+			if got := envBool(slog.Default(), key, tt.fallback); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEnvDurationMap(t *testing.T) {
+	t.Setenv("TEST_ENV_DURATION_MAP", "helm=24h, wasm = 2h,bogus,image=nope")
+	got := envDurationMap(slog.Default(), "TEST_ENV_DURATION_MAP")
+	if len(got) != 2 || got["helm"] != 24*time.Hour || got["wasm"] != 2*time.Hour {
+		t.Errorf("unexpected map: %v", got)
+	}
+}
+
+func TestEnvSecret(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	t.Setenv("TEST_SECRET", "direct")
+	if got := envSecret(logger, secrets.Config{}, "TEST_SECRET"); got != "direct" {
+		t.Errorf("expected direct, got %q", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_SECRET_FILE", path)
+	if got := envSecret(logger, secrets.Config{}, "TEST_SECRET"); got != "from-file" {
+		t.Errorf("expected the file to win over the variable, got %q", got)
+	}
+	if ref := secretRef("TEST_SECRET"); ref != "file:"+path {
+		t.Errorf("expected ref file:%s, got %q", path, ref)
+	}
+
+	t.Setenv("TEST_SECRET_FILE", "")
+	t.Setenv("TEST_SECRET_FROM", "vault:secret/data/app#token")
+	if got := envSecret(logger, secrets.Config{}, "TEST_SECRET"); got != "" {
+		t.Errorf("expected empty value for an unresolvable reference, got %q", got)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		got  func(c *Config) any
+		want any
+	}{
+		{name: "port default", env: map[string]string{"PORT": ""},
+			got: func(c *Config) any { return c.Port }, want: 8000},
+		{name: "port", env: map[string]string{"PORT": "8080"},
+			got: func(c *Config) any { return c.Port }, want: 8080},
+		{name: "malformed port uses default", env: map[string]string{"PORT": "eighty"},
+			got: func(c *Config) any { return c.Port }, want: 8000},
+		{name: "redis url default", env: map[string]string{"REDIS_URL": "", "REDISCLOUD_URL": ""},
+			got: func(c *Config) any { return c.RedisURL }, want: "redis://localhost:6379"},
+		{name: "redis url from REDISCLOUD_URL", env: map[string]string{"REDIS_URL": "", "REDISCLOUD_URL": "redis://cloud"},
+			got: func(c *Config) any { return c.RedisURL }, want: "redis://cloud"},
+		{name: "base path without trailing slash", env: map[string]string{"BASE_PATH": "/ephemeron/"},
+			got: func(c *Config) any { return c.BasePath }, want: "/ephemeron"},
+		{name: "harbor url defaults to registry url",
+			env: map[string]string{"HARBOR_URL": "", "REGISTRY_URL": "https://registry.example.com"},
+			got: func(c *Config) any { return c.HarborURL }, want: "https://registry.example.com"},
+		{name: "bucket credentials fall back to AWS",
+			env: map[string]string{"STORAGE_BUCKET_ACCESS_KEY_ID": "", "AWS_ACCESS_KEY_ID": "AKIA"},
+			got: func(c *Config) any { return c.Bucket.AccessKeyID }, want: "AKIA"},
+		{name: "aws region from AWS_DEFAULT_REGION",
+			env: map[string]string{"AWS_REGION": "", "AWS_DEFAULT_REGION": "eu-west-1"},
+			got: func(c *Config) any { return c.Secrets.AWSRegion }, want: "eu-west-1"},
+		{name: "opt-in background jobs off by default",
+			env: map[string]string{"SWEEP_INTERVAL": "", "DELETE_PROBE_INTERVAL": "", "RECONCILE_INTERVAL": ""},
+			got: func(c *Config) any {
+				return []time.Duration{c.SweepInterval, c.DeleteProbeInterval, c.ReconcileInterval}
+			},
+			want: []time.Duration{0, 0, 0}},
+		{name: "api auth off by default", env: map[string]string{"API_AUTH": ""},
+			got: func(c *Config) any { return c.APIAuth }, want: false},
+		{name: "cache repos default", env: map[string]string{"CACHE_REPOS": ""},
+			got: func(c *Config) any { return c.CacheRepos }, want: hooks.DefaultCacheRepos},
+		{name: "list drops empty entries", env: map[string]string{"PROTECTED_PATTERNS": "prod/*, release/*,"},
+			got: func(c *Config) any { return c.ProtectedPatterns }, want: []string{"prod/*", "release/*"}},
+		{name: "duration map", env: map[string]string{"ARTIFACT_TTLS": "helm=24h"},
+			got: func(c *Config) any { return c.ArtifactTTLs }, want: map[string]time.Duration{"helm": 24 * time.Hour}},
+		{name: "fault injection left disabled", env: map[string]string{"FAULT_FAIL_RATE": "0.5"},
+			got: func(c *Config) any { return c.Faults.Enabled }, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			cfg := FromEnv(slog.New(slog.DiscardHandler))
+			if got := tt.got(cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
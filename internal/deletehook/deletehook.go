@@ -0,0 +1,122 @@
+// Package deletehook runs user-supplied actions around the deletion of an
+// image, such as archiving its manifest, updating an external inventory or
+// invalidating caches. Actions are shell commands or webhooks and receive
+// the image's metadata.
+package deletehook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// Phases in which a hook runs.
+const (
+	PhasePreDelete  = "pre_delete"
+	PhasePostDelete = "post_delete"
+)
+
+// maxOutput bounds how much command output is quoted in errors.
+const maxOutput = 1024
+
+// waitDelay bounds how long a timed-out command's children may keep its
+// output open.
+const waitDelay = time.Second
+
+// Event describes the image being deleted.
+type Event struct {
+	Phase      string    `json:"phase"`
+	Image      string    `json:"image"`
+	Repository string    `json:"repository"`
+	Tag        string    `json:"tag"`
+	Digest     string    `json:"digest,omitempty"`
+	SizeBytes  int64     `json:"size_bytes"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Actor      string    `json:"actor,omitempty"`
+}
+
+// Command runs a shell command with the event as JSON on stdin and as
+// EPHEMERON_* environment variables.
+type Command struct {
+	command string
+	timeout time.Duration
+}
+
+// NewCommand returns a Command running command through sh -c, killed
+// after timeout.
+func NewCommand(command string, timeout time.Duration) *Command {
+	return &Command{command: command, timeout: timeout}
+}
+
+// Run runs the command for ev. A non-zero exit status is an error that
+// quotes the command's output.
+func (c *Command) Run(ctx context.Context, ev Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.command)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.WaitDelay = waitDelay
+	cmd.Env = append(os.Environ(),
+		"EPHEMERON_PHASE="+ev.Phase,
+		"EPHEMERON_IMAGE="+ev.Image,
+		"EPHEMERON_REPOSITORY="+ev.Repository,
+		"EPHEMERON_TAG="+ev.Tag,
+		"EPHEMERON_DIGEST="+ev.Digest,
+		"EPHEMERON_SIZE_BYTES="+strconv.FormatInt(ev.SizeBytes, 10),
+		"EPHEMERON_EXPIRES_AT="+ev.ExpiresAt.Format(time.RFC3339),
+		"EPHEMERON_ACTOR="+ev.Actor,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if len(out) > maxOutput {
+			out = out[:maxOutput]
+		}
+		return fmt.Errorf("%s hook command: %w: %s", ev.Phase, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// Webhook POSTs the event as JSON.
+type Webhook struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewWebhook returns a Webhook posting to url, giving up after timeout.
+func NewWebhook(url string, timeout time.Duration) *Webhook {
+	return &Webhook{url: url, httpClient: &http.Client{Timeout: timeout}}
+}
+
+// Run posts ev. Any status other than 2xx is an error.
+func (w *Webhook) Run(ctx context.Context, ev Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating %s hook request: %w", ev.Phase, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("calling %s hook webhook: %w", ev.Phase, err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s hook webhook returned %d", ev.Phase, resp.StatusCode)
+	}
+	return nil
+}
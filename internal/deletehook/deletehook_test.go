@@ -0,0 +1,75 @@
+package deletehook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testEvent = Event{
+	Phase:      PhasePreDelete,
+	Image:      "team/app:1h",
+	Repository: "team/app",
+	Tag:        "1h",
+	Digest:     "sha256:abc",
+	SizeBytes:  1024,
+	ExpiresAt:  time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	Actor:      "ci-bot",
+}
+
+func TestCommand(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	c := NewCommand(`echo "$EPHEMERON_PHASE $EPHEMERON_IMAGE $EPHEMERON_SIZE_BYTES" > `+out+` && cat >> `+out, time.Second)
+	if err := c.Run(t.Context(), testEvent); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, stdin, _ := strings.Cut(string(data), "\n")
+	if env != "pre_delete team/app:1h 1024" {
+		t.Errorf("environment = %q", env)
+	}
+	var got Event
+	if err := json.Unmarshal([]byte(stdin), &got); err != nil || got != testEvent {
+		t.Errorf("stdin = %q (%v), want the event as JSON", stdin, err)
+	}
+}
+
+func TestCommand_Failure(t *testing.T) {
+	err := NewCommand("echo archive unavailable; exit 3", time.Second).Run(t.Context(), testEvent)
+	if err == nil || !strings.Contains(err.Error(), "archive unavailable") {
+		t.Errorf("Run = %v, want an error quoting the output", err)
+	}
+	if err := NewCommand("sleep 5", 50*time.Millisecond).Run(t.Context(), testEvent); err == nil {
+		t.Error("expected an error for a command exceeding the timeout")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var got Event
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	w := NewWebhook(srv.URL, time.Second)
+	if err := w.Run(t.Context(), testEvent); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got != testEvent {
+		t.Errorf("webhook received %+v, want %+v", got, testEvent)
+	}
+	status = http.StatusBadGateway
+	if err := w.Run(t.Context(), testEvent); err == nil {
+		t.Error("expected an error for a 502 response")
+	}
+}
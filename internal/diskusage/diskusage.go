@@ -0,0 +1,19 @@
+// Package diskusage reads the capacity and free space of the filesystem
+// holding the registry's storage, for deployments where ephemeron runs
+// next to the registry with its data volume mounted.
+package diskusage
+
+import "errors"
+
+// ErrUnsupported is returned by Stat on platforms without statfs support.
+var ErrUnsupported = errors.New("filesystem stats not supported on this platform")
+
+// Usage describes the space on a filesystem.
+type Usage struct {
+	// TotalBytes is the size of the filesystem.
+	TotalBytes uint64
+	// FreeBytes is the space available to unprivileged users.
+	FreeBytes uint64
+	// UsedBytes is the space in use, including blocks reserved for root.
+	UsedBytes uint64
+}
@@ -0,0 +1,30 @@
+package diskusage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestStat(t *testing.T) {
+	u, err := Stat(t.TempDir())
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if u.TotalBytes == 0 {
+		t.Error("expected a non-zero filesystem size")
+	}
+	if u.FreeBytes > u.TotalBytes || u.UsedBytes > u.TotalBytes {
+		t.Errorf("inconsistent usage: %+v", u)
+	}
+}
+
+func TestStat_MissingPath(t *testing.T) {
+	_, err := Stat(filepath.Join(t.TempDir(), "missing"))
+	if err == nil {
+		t.Fatal("expected error for missing path")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin)
+
+package diskusage
+
+// Stat returns ErrUnsupported.
+func Stat(string) (Usage, error) {
+	return Usage{}, ErrUnsupported
+}
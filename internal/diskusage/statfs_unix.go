@@ -0,0 +1,22 @@
+//go:build linux || darwin
+
+package diskusage
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Stat returns the usage of the filesystem containing path.
+func Stat(path string) (Usage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return Usage{}, fmt.Errorf("statfs %s: %w", path, err)
+	}
+	bsize := uint64(st.Bsize)
+	return Usage{
+		TotalBytes: st.Blocks * bsize,
+		FreeBytes:  st.Bavail * bsize,
+		UsedBytes:  (st.Blocks - st.Bfree) * bsize,
+	}, nil
+}
@@ -0,0 +1,264 @@
+// Package e2e smoke-tests a running deployment end to end: it pushes a
+// small image to the registry, sends ephemeron the push webhook, checks
+// that the image is tracked, expires it and waits for the reaper to delete
+// it. It talks to the same registry and store as the deployment and is
+// meant for CI/CD pipelines after a rollout.
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/hooks"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+// Step names, in the order they run.
+const (
+	StepPush    = "push"
+	StepWebhook = "webhook"
+	StepTracked = "tracked"
+	StepExpire  = "expire"
+	StepReaped  = "reaped"
+	StepCleanup = "cleanup"
+)
+
+// Step outcomes.
+const (
+	StatusPassed = "passed"
+	StatusFailed = "failed"
+)
+
+// Media types of the pushed test image.
+const (
+	mediaTypeConfig = "application/vnd.oci.image.config.v1+json"
+	mediaTypeLayer  = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// Registry is the subset of registry operations the test needs.
+type Registry interface {
+	PutBlob(ctx context.Context, repo, digest string, body []byte) error
+	PutManifest(ctx context.Context, repo, tag string, m *registry.RawManifest) (string, error)
+	ManifestDigest(ctx context.Context, repo, ref string) (string, bool, error)
+	DeleteManifest(ctx context.Context, repo, digest string) error
+}
+
+// Config describes the deployment under test.
+type Config struct {
+	// WebhookURL is ephemeron's registry event endpoint.
+	WebhookURL string
+	// HookToken authenticates the webhook; empty sends none.
+	HookToken string
+	// Repository and Tag name the pushed image. The tag must be a TTL
+	// longer than the test takes, so that only the explicit expiry makes
+	// the reaper delete it.
+	Repository string
+	Tag        string
+	// Timeout bounds each wait for ephemeron to act.
+	Timeout time.Duration
+	// PollInterval is how often a wait checks again.
+	PollInterval time.Duration
+}
+
+// Step is the outcome of one part of the test.
+type Step struct {
+	Name     string        `json:"name" yaml:"name"`
+	Status   string        `json:"status" yaml:"status"`
+	Duration time.Duration `json:"duration" yaml:"duration"`
+	Error    string        `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Result is the outcome of a test run.
+type Result struct {
+	Image  string `json:"image" yaml:"image"`
+	Passed bool   `json:"passed" yaml:"passed"`
+	Steps  []Step `json:"steps" yaml:"steps"`
+}
+
+// Runner runs the test against one deployment.
+type Runner struct {
+	reg    Registry
+	store  redisclient.Store
+	client *http.Client
+	cfg    Config
+}
+
+// New returns a Runner pushing to reg and inspecting store.
+func New(reg Registry, store redisclient.Store, cfg Config) *Runner {
+	return &Runner{reg: reg, store: store, client: &http.Client{Timeout: 30 * time.Second}, cfg: cfg}
+}
+
+// Run performs the test, stopping at the first failed step. If the image
+// was pushed but not reaped, it is deleted from the registry and the store
+// again so that failed runs leave nothing behind.
+func (r *Runner) Run(ctx context.Context) Result {
+	res := Result{Image: r.cfg.Repository + ":" + r.cfg.Tag}
+	var digest string
+	steps := []struct {
+		name string
+		run  func(ctx context.Context) error
+	}{
+		{StepPush, func(ctx context.Context) (err error) {
+			digest, err = r.push(ctx)
+			return err
+		}},
+		{StepWebhook, func(ctx context.Context) error { return r.sendWebhook(ctx, digest) }},
+		{StepTracked, func(ctx context.Context) error {
+			return r.waitFor(ctx, "image to be tracked", func(ctx context.Context) (bool, error) {
+				_, err := r.store.GetExpiry(ctx, res.Image)
+				return err == nil, nil
+			})
+		}},
+		{StepExpire, func(ctx context.Context) error {
+			ok, err := r.store.SetExpiry(ctx, res.Image, time.Now().Add(-time.Second))
+			if err == nil && !ok {
+				err = fmt.Errorf("%s is no longer tracked", res.Image)
+			}
+			return err
+		}},
+		{StepReaped, func(ctx context.Context) error {
+			return r.waitFor(ctx, "image to be reaped", func(ctx context.Context) (bool, error) {
+				_, found, err := r.reg.ManifestDigest(ctx, r.cfg.Repository, r.cfg.Tag)
+				if err != nil || found {
+					return false, err
+				}
+				_, err = r.store.GetExpiry(ctx, res.Image)
+				return err != nil, nil
+			})
+		}},
+	}
+
+	res.Passed = true
+	for _, s := range steps {
+		if !res.record(ctx, s.name, s.run) {
+			res.Passed = false
+			break
+		}
+	}
+	if !res.Passed && digest != "" {
+		res.record(ctx, StepCleanup, func(ctx context.Context) error { return r.cleanup(ctx, res.Image, digest) })
+	}
+	return res
+}
+
+// record runs step and appends its outcome, reporting whether it passed.
+func (res *Result) record(ctx context.Context, name string, step func(context.Context) error) bool {
+	start := time.Now()
+	err := step(ctx)
+	s := Step{Name: name, Status: StatusPassed, Duration: time.Since(start).Round(time.Millisecond)}
+	if err != nil {
+		s.Status = StatusFailed
+		s.Error = err.Error()
+	}
+	res.Steps = append(res.Steps, s)
+	return err == nil
+}
+
+// push uploads a one-layer image whose layer is random, so every run
+// pushes a new manifest, and returns the manifest digest.
+func (r *Runner) push(ctx context.Context) (string, error) {
+	layer := make([]byte, 64)
+	if _, err := rand.Read(layer); err != nil {
+		return "", err
+	}
+	config := []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":[]}}`)
+	for _, blob := range [][]byte{config, layer} {
+		if err := r.reg.PutBlob(ctx, r.cfg.Repository, blobDigest(blob), blob); err != nil {
+			return "", fmt.Errorf("uploading blob: %w", err)
+		}
+	}
+	manifest, err := json.Marshal(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     registry.MediaTypeOCIManifest,
+		"config": registry.Descriptor{
+			MediaType: mediaTypeConfig, Digest: blobDigest(config), Size: int64(len(config)),
+		},
+		"layers": []registry.Descriptor{
+			{MediaType: mediaTypeLayer, Digest: blobDigest(layer), Size: int64(len(layer))},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	m := &registry.RawManifest{MediaType: registry.MediaTypeOCIManifest, Body: manifest}
+	digest, err := r.reg.PutManifest(ctx, r.cfg.Repository, r.cfg.Tag, m)
+	if err != nil {
+		return "", fmt.Errorf("uploading manifest: %w", err)
+	}
+	if digest == "" {
+		digest = m.Digest()
+	}
+	return digest, nil
+}
+
+// sendWebhook notifies ephemeron of the push as the registry would.
+func (r *Runner) sendWebhook(ctx context.Context, digest string) error {
+	body, err := json.Marshal(hooks.EventEnvelope{Events: []hooks.RegistryEvent{{
+		Action: "push",
+		Target: hooks.EventTarget{Repository: r.cfg.Repository, Tag: r.cfg.Tag, Digest: digest},
+		Actor:  hooks.EventActor{Name: "ephemeron-e2etest"},
+	}}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.docker.distribution.events.v1+json")
+	if r.cfg.HookToken != "" {
+		req.Header.Set("Authorization", "Token "+r.cfg.HookToken)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending webhook: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// waitFor polls done until it reports true, fails, or the timeout passes.
+func (r *Runner) waitFor(ctx context.Context, what string, done func(context.Context) (bool, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		ok, err := done(ctx)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s waiting for %s", r.cfg.Timeout, what)
+		case <-ticker.C:
+		}
+	}
+}
+
+// cleanup removes the test image from the registry and the store.
+func (r *Runner) cleanup(ctx context.Context, image, digest string) error {
+	if err := r.reg.DeleteManifest(ctx, r.cfg.Repository, digest); err != nil {
+		return fmt.Errorf("deleting manifest: %w", err)
+	}
+	return r.store.RemoveImage(ctx, image)
+}
+
+func blobDigest(b []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+}
@@ -0,0 +1,168 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/hooks"
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+// fakeRegistry accepts uploads and remembers which manifests exist.
+type fakeRegistry struct {
+	mu        sync.Mutex
+	manifests map[string]string // tag -> digest
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/blobs/uploads/"):
+		w.Header().Set("Location", "/upload/1")
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/upload/"):
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(path, "/manifests/"):
+		ref := path[strings.LastIndex(path, "/")+1:]
+		switch r.Method {
+		case http.MethodPut:
+			f.manifests[ref] = "sha256:abc"
+			w.Header().Set("Docker-Content-Digest", "sha256:abc")
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodHead:
+			if _, ok := f.manifests[ref]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodDelete:
+			for tag, digest := range f.manifests {
+				if digest == ref {
+					delete(f.manifests, tag)
+				}
+			}
+			w.WriteHeader(http.StatusAccepted)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// newDeployment starts a fake registry and an ephemeron stand-in that
+// tracks pushed images and, if reap is set, deletes them once expired.
+func newDeployment(t *testing.T, reap bool) (*registry.Client, redisclient.Store, string) {
+	t.Helper()
+	store := memstore.New()
+	fake := &fakeRegistry{manifests: make(map[string]string)}
+	regSrv := httptest.NewServer(fake)
+	t.Cleanup(regSrv.Close)
+	reg := registry.New(regSrv.URL)
+
+	hookSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var env hooks.EventEnvelope
+		_ = json.NewDecoder(r.Body).Decode(&env)
+		for _, e := range env.Events {
+			image := e.Target.Repository + ":" + e.Target.Tag
+			_ = store.TrackImage(r.Context(), image, time.Now().Add(time.Hour), 0, e.Target.Digest, redisclient.ImageMeta{})
+		}
+	}))
+	t.Cleanup(hookSrv.Close)
+
+	if reap {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		go func() {
+			for ctx.Err() == nil {
+				images, _ := store.ListImages(ctx)
+				for _, image := range images {
+					if expires, _ := store.GetExpiry(ctx, image); expires < time.Now().UnixMilli() {
+						digest, _ := store.GetImageDigest(ctx, image)
+						repo, _, _ := strings.Cut(image, ":")
+						_ = reg.DeleteManifest(ctx, repo, digest)
+						_ = store.RemoveImage(ctx, image)
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		}()
+	}
+	return reg, store, hookSrv.URL
+}
+
+func testConfig(webhookURL string) Config {
+	return Config{
+		WebhookURL:   webhookURL,
+		HookToken:    "secret",
+		Repository:   "ephemeron-e2e/run",
+		Tag:          "1h",
+		Timeout:      time.Second,
+		PollInterval: 5 * time.Millisecond,
+	}
+}
+
+func TestRun_Passes(t *testing.T) {
+	reg, store, hookURL := newDeployment(t, true)
+
+	res := New(reg, store, testConfig(hookURL)).Run(t.Context())
+	if !res.Passed {
+		t.Fatalf("Run failed: %+v", res.Steps)
+	}
+	var names []string
+	for _, s := range res.Steps {
+		names = append(names, s.Name)
+	}
+	want := []string{StepPush, StepWebhook, StepTracked, StepExpire, StepReaped}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("steps = %v, want %v", names, want)
+	}
+}
+
+func TestRun_NotReapedCleansUp(t *testing.T) {
+	reg, store, hookURL := newDeployment(t, false)
+	cfg := testConfig(hookURL)
+	cfg.Timeout = 50 * time.Millisecond
+
+	res := New(reg, store, cfg).Run(t.Context())
+	if res.Passed {
+		t.Fatal("Run passed without a reaper")
+	}
+	last := res.Steps[len(res.Steps)-2]
+	if last.Name != StepReaped || last.Status != StatusFailed {
+		t.Errorf("failed step = %+v, want %s failed", last, StepReaped)
+	}
+	if cleanup := res.Steps[len(res.Steps)-1]; cleanup.Name != StepCleanup || cleanup.Status != StatusPassed {
+		t.Errorf("cleanup step = %+v, want passed", cleanup)
+	}
+	if _, found, _ := reg.ManifestDigest(t.Context(), cfg.Repository, cfg.Tag); found {
+		t.Error("manifest left behind after cleanup")
+	}
+	if _, err := store.GetExpiry(t.Context(), res.Image); err == nil {
+		t.Error("image still tracked after cleanup")
+	}
+}
+
+func TestRun_WebhookRejected(t *testing.T) {
+	reg, store, hookURL := newDeployment(t, true)
+	cfg := testConfig(hookURL)
+	cfg.HookToken = "wrong"
+
+	res := New(reg, store, cfg).Run(t.Context())
+	if res.Passed {
+		t.Fatal("Run passed with a rejected webhook")
+	}
+	if s := res.Steps[1]; s.Name != StepWebhook || !strings.Contains(s.Error, "401") {
+		t.Errorf("webhook step = %+v, want a 401 failure", s)
+	}
+}
@@ -0,0 +1,176 @@
+// Package export writes the tracked image inventory as CSV or Parquet, so
+// platform teams can attribute registry storage to the teams that use it.
+// The inventory is served on the internal API and can be uploaded to an
+// S3-compatible bucket on a schedule.
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/tamcore/ephemeron/internal/owners"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// Supported export formats.
+const (
+	FormatCSV     = "csv"
+	FormatParquet = "parquet"
+)
+
+// Formats lists the supported export formats.
+var Formats = []string{FormatCSV, FormatParquet}
+
+// header is the CSV header, in the order of Record's fields.
+var header = []string{
+	"repository", "tag", "digest", "size_bytes", "pushed_by", "owner", "created_at", "expires_at",
+}
+
+// Record is one tracked image. Zero times are unknown: images tracked
+// before push times were recorded have no CreatedAt.
+type Record struct {
+	Repository string    `parquet:"repository"`
+	Tag        string    `parquet:"tag"`
+	Digest     string    `parquet:"digest,optional"`
+	SizeBytes  int64     `parquet:"size_bytes"`
+	PushedBy   string    `parquet:"pushed_by,optional"`
+	Owner      string    `parquet:"owner,optional"`
+	CreatedAt  time.Time `parquet:"created_at,optional,timestamp(millisecond)"`
+	ExpiresAt  time.Time `parquet:"expires_at,timestamp(millisecond)"`
+}
+
+// Load reads the inventory from store, sorted by repository and tag. Owners
+// come from owned, which may be nil.
+func Load(ctx context.Context, store redisclient.Store, owned *owners.Resolver) ([]Record, error) {
+	images, err := store.ListImages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing images: %w", err)
+	}
+	records := make([]Record, 0, len(images))
+	for _, image := range images {
+		expires, err := store.GetExpiry(ctx, image)
+		if err != nil {
+			return nil, fmt.Errorf("getting expiry for %s: %w", image, err)
+		}
+		created, err := store.GetCreatedTimestamp(ctx, image)
+		if err != nil {
+			return nil, fmt.Errorf("getting created time for %s: %w", image, err)
+		}
+		size, err := store.GetImageSize(ctx, image)
+		if err != nil {
+			return nil, fmt.Errorf("getting size for %s: %w", image, err)
+		}
+		digest, err := store.GetImageDigest(ctx, image)
+		if err != nil {
+			return nil, fmt.Errorf("getting digest for %s: %w", image, err)
+		}
+		meta, err := store.GetImageMeta(ctx, image)
+		if err != nil {
+			return nil, fmt.Errorf("getting metadata for %s: %w", image, err)
+		}
+		repo, tag, _ := strings.Cut(image, ":")
+		rec := Record{
+			Repository: repo,
+			Tag:        tag,
+			Digest:     digest,
+			SizeBytes:  size,
+			PushedBy:   meta.Actor,
+			Owner:      owned.Owner(repo),
+			ExpiresAt:  time.UnixMilli(expires).UTC(),
+		}
+		if created > 0 {
+			rec.CreatedAt = time.UnixMilli(created).UTC()
+		}
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Repository != records[j].Repository {
+			return records[i].Repository < records[j].Repository
+		}
+		return records[i].Tag < records[j].Tag
+	})
+	return records, nil
+}
+
+// Write encodes records to w in format.
+func Write(w io.Writer, format string, records []Record) error {
+	switch format {
+	case FormatCSV:
+		return writeCSV(w, records)
+	case FormatParquet:
+		return parquet.Write(w, records)
+	default:
+		return fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+func writeCSV(w io.Writer, records []Record) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write(header)
+	for _, r := range records {
+		_ = cw.Write([]string{
+			r.Repository,
+			r.Tag,
+			r.Digest,
+			strconv.FormatInt(r.SizeBytes, 10),
+			r.PushedBy,
+			r.Owner,
+			formatTime(r.CreatedAt),
+			formatTime(r.ExpiresAt),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatTime formats t as RFC 3339, leaving unknown times empty.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// contentType returns the media type of format.
+func contentType(format string) string {
+	if format == FormatParquet {
+		return "application/vnd.apache.parquet"
+	}
+	return "text/csv"
+}
+
+// Handler serves the inventory in the format given by the "format" query
+// parameter, CSV by default.
+func Handler(store redisclient.Store, owned *owners.Resolver, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		format := req.URL.Query().Get("format")
+		if format == "" {
+			format = FormatCSV
+		}
+		if format != FormatCSV && format != FormatParquet {
+			http.Error(w, "unsupported format, want one of "+strings.Join(Formats, ", "), http.StatusBadRequest)
+			return
+		}
+		records, err := Load(req.Context(), store, owned)
+		if err != nil {
+			logger.Error("failed to load inventory", "error", err)
+			http.Error(w, "inventory unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", contentType(format))
+		w.Header().Set("Content-Disposition", `attachment; filename="inventory.`+format+`"`)
+		if err := Write(w, format, records); err != nil {
+			logger.Error("failed to write inventory", "format", format, "error", err)
+		}
+	})
+}
@@ -0,0 +1,181 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	"github.com/tamcore/ephemeron/internal/owners"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+func newStore(t *testing.T) *memstore.Store {
+	t.Helper()
+	store := memstore.New()
+	expires := time.Now().Add(time.Hour)
+	_ = store.TrackImage(t.Context(), "team-a/app:v2", expires, 200, "sha256:b", redisclient.ImageMeta{Actor: "ci-bot"})
+	_ = store.TrackImage(t.Context(), "team-a/app:v1", expires, 100, "sha256:a", redisclient.ImageMeta{})
+	_ = store.TrackImage(t.Context(), "other:1h", expires, 50, "", redisclient.ImageMeta{})
+	return store
+}
+
+func TestLoad(t *testing.T) {
+	owned, err := owners.Parse([]string{"team-a/*=#team-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := Load(t.Context(), newStore(t), owned)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 3 || records[0].Repository != "other" || records[1].Tag != "v1" || records[2].Tag != "v2" {
+		t.Fatalf("expected records sorted by repository and tag, got %+v", records)
+	}
+	r := records[2]
+	if r.Digest != "sha256:b" || r.SizeBytes != 200 || r.PushedBy != "ci-bot" || r.Owner != "#team-a" {
+		t.Errorf("unexpected record %+v", r)
+	}
+	if r.CreatedAt.IsZero() || r.ExpiresAt.IsZero() {
+		t.Errorf("expected created and expiry times, got %+v", r)
+	}
+	if records[0].Owner != "" {
+		t.Errorf("expected no owner for unmatched repository, got %q", records[0].Owner)
+	}
+}
+
+func TestWrite_CSV(t *testing.T) {
+	records := []Record{{
+		Repository: "team-a/app",
+		Tag:        "v1",
+		SizeBytes:  100,
+		ExpiresAt:  time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}}
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatCSV, records); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	want := []string{"team-a/app", "v1", "", "100", "", "", "", "2026-01-02T03:04:05Z"}
+	if len(rows) != 2 || strings.Join(rows[0], ",") != strings.Join(header, ",") ||
+		strings.Join(rows[1], ",") != strings.Join(want, ",") {
+		t.Errorf("unexpected CSV %q", rows)
+	}
+}
+
+func TestWrite_Parquet(t *testing.T) {
+	records := []Record{{
+		Repository: "team-a/app",
+		Tag:        "v1",
+		Digest:     "sha256:a",
+		SizeBytes:  100,
+		PushedBy:   "ci-bot",
+		CreatedAt:  time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		ExpiresAt:  time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC),
+	}}
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatParquet, records); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := parquet.Read[Record](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("invalid Parquet: %v", err)
+	}
+	if len(got) != 1 || got[0].Digest != "sha256:a" || !got[0].ExpiresAt.Equal(records[0].ExpiresAt) {
+		t.Errorf("round trip = %+v, want %+v", got, records)
+	}
+}
+
+func TestWrite_UnknownFormat(t *testing.T) {
+	if err := Write(io.Discard, "xlsx", nil); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	h := Handler(newStore(t), nil, slog.New(slog.DiscardHandler))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/api/export", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/csv" {
+		t.Fatalf("expected CSV by default, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if lines := strings.Count(rec.Body.String(), "\n"); lines != 4 {
+		t.Errorf("expected header and 3 rows, got %d lines", lines)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/api/export?format=parquet", nil))
+	if rec.Code != http.StatusOK || !bytes.HasPrefix(rec.Body.Bytes(), []byte("PAR1")) {
+		t.Errorf("expected Parquet file, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/api/export?format=xlsx", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown format, got %d", rec.Code)
+	}
+}
+
+func TestUploader_Upload(t *testing.T) {
+	var gotPath, gotAuth, gotHash string
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("expected PUT, got %s", r.Method)
+		}
+		gotPath, gotAuth = r.URL.Path, r.Header.Get("Authorization")
+		gotHash = r.Header.Get("X-Amz-Content-Sha256")
+		gotBody, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	u := NewUploader(Config{
+		Endpoint:        srv.URL + "/",
+		Bucket:          "chargeback",
+		Prefix:          "ephemeron/",
+		Region:          "auto",
+		AccessKeyID:     "AK",
+		SecretAccessKey: "SK",
+		Format:          FormatCSV,
+	}, newStore(t), nil, slog.New(slog.DiscardHandler))
+	u.now = func() time.Time { return time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC) }
+
+	key, err := u.Upload(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if key != "ephemeron/inventory-20260304T050607Z.csv" || gotPath != "/chargeback/"+key {
+		t.Errorf("unexpected key %q, path %q", key, gotPath)
+	}
+	if !strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=AK/20260304/auto/s3/aws4_request") {
+		t.Errorf("expected signed request, got %q", gotAuth)
+	}
+	if !strings.HasPrefix(string(gotBody), "repository,tag,") || gotHash == "" {
+		t.Errorf("expected CSV body with payload hash, got %q (hash %q)", gotBody, gotHash)
+	}
+}
+
+func TestUploader_UploadError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	u := NewUploader(Config{Endpoint: srv.URL, Bucket: "chargeback", Format: FormatCSV},
+		newStore(t), nil, slog.New(slog.DiscardHandler))
+	if _, err := u.Upload(t.Context()); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected error quoting status 403, got %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+	"github.com/tamcore/ephemeron/internal/owners"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+	"github.com/tamcore/ephemeron/internal/sigv4"
+)
+
+// Config describes the bucket scheduled exports are uploaded to.
+type Config struct {
+	// Endpoint is the storage API base URL, e.g. https://s3.eu-central-1.amazonaws.com
+	// or https://storage.googleapis.com.
+	Endpoint string
+	// Bucket is the bucket name. Empty disables scheduled exports.
+	Bucket string
+	// Prefix is prepended to the object keys.
+	Prefix string
+	// Region is the SigV4 signing region; "auto" works for GCS.
+	Region string
+	// AccessKeyID and SecretAccessKey are the HMAC credentials.
+	AccessKeyID     string
+	SecretAccessKey string
+	// Format is the format of the uploaded files.
+	Format string
+}
+
+// Uploader periodically uploads the inventory to a bucket.
+type Uploader struct {
+	cfg        Config
+	store      redisclient.Store
+	owned      *owners.Resolver
+	httpClient *http.Client
+	logger     *slog.Logger
+	now        func() time.Time
+}
+
+// NewUploader creates an Uploader exporting the inventory of store.
+func NewUploader(cfg Config, store redisclient.Store, owned *owners.Resolver, logger *slog.Logger) *Uploader {
+	cfg.Endpoint = strings.TrimRight(cfg.Endpoint, "/")
+	return &Uploader{
+		cfg:        cfg,
+		store:      store,
+		owned:      owned,
+		httpClient: &http.Client{Timeout: 5 * time.Minute},
+		logger:     logger,
+		now:        time.Now,
+	}
+}
+
+// RunLoop uploads the inventory at the given interval until the context is
+// cancelled. Failed uploads are retried at the next interval.
+func (u *Uploader) RunLoop(ctx context.Context, interval time.Duration) {
+	u.logger.Info("starting inventory exporter",
+		"bucket", u.cfg.Bucket,
+		"format", u.cfg.Format,
+		"interval", interval.String(),
+	)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		key, err := u.Upload(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				metrics.ExportUploadErrors.Inc()
+				u.logger.Error("inventory export failed", "error", err)
+			}
+			continue
+		}
+		u.logger.Info("inventory exported", "bucket", u.cfg.Bucket, "key", key)
+	}
+}
+
+// Upload writes the current inventory to a new object named after the
+// export time and returns its key.
+func (u *Uploader) Upload(ctx context.Context) (string, error) {
+	records, err := Load(ctx, u.store, u.owned)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, u.cfg.Format, records); err != nil {
+		return "", fmt.Errorf("encoding inventory: %w", err)
+	}
+
+	now := u.now().UTC()
+	key := path.Join(u.cfg.Prefix, "inventory-"+now.Format("20060102T150405Z")+"."+u.cfg.Format)
+	key = strings.TrimPrefix(key, "/")
+	escaped := strings.ReplaceAll(url.PathEscape(key), "%2F", "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut,
+		u.cfg.Endpoint+"/"+url.PathEscape(u.cfg.Bucket)+"/"+escaped, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return "", fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType(u.cfg.Format))
+	if u.cfg.AccessKeyID != "" {
+		sigv4.Sign(req, u.cfg.AccessKeyID, u.cfg.SecretAccessKey, u.cfg.Region, sigv4.PayloadHash(buf.Bytes()), now)
+	}
+
+	resp, err := u.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("uploading %s: %w", key, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("uploading %s returned %d: %s", key, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return key, nil
+}
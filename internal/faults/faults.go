@@ -0,0 +1,121 @@
+// Package faults randomly delays and fails calls to the store and the
+// registry, so that retries, circuit breakers and queues can be exercised
+// in staging. It is meant for resilience testing only and must not be
+// enabled in production.
+package faults
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+)
+
+// Targets of injected faults, used as the "target" metric label.
+const (
+	TargetStore    = "store"
+	TargetRegistry = "registry"
+)
+
+// ErrInjected is returned by calls failed on purpose.
+var ErrInjected = errors.New("injected fault")
+
+// Config sets how often faults are injected.
+type Config struct {
+	// Enabled turns fault injection on.
+	Enabled bool
+
+	// FailRate is the probability, from 0 to 1, of a call failing.
+	FailRate float64
+
+	// DelayRate is the probability, from 0 to 1, of a call being delayed
+	// by a random duration up to MaxDelay before it runs.
+	DelayRate float64
+	MaxDelay  time.Duration
+}
+
+// Injector decides which calls to delay or fail.
+type Injector struct {
+	cfg Config
+	// float returns a random number in [0, 1); replaced in tests.
+	float func() float64
+}
+
+// New returns an Injector for cfg.
+func New(cfg Config) *Injector {
+	return &Injector{cfg: cfg, float: rand.Float64}
+}
+
+// Inject delays the call to target if the dice say so, then returns
+// ErrInjected if it should fail. It returns early with the context's error
+// if ctx is done while delaying.
+func (i *Injector) Inject(ctx context.Context, target string) error {
+	if i.cfg.MaxDelay > 0 && i.float() < i.cfg.DelayRate {
+		metrics.FaultsInjectedTotal.WithLabelValues(target, "delay").Inc()
+		t := time.NewTimer(time.Duration(i.float() * float64(i.cfg.MaxDelay)))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	if i.float() < i.cfg.FailRate {
+		metrics.FaultsInjectedTotal.WithLabelValues(target, "fail").Inc()
+		return fmt.Errorf("%s: %w", target, ErrInjected)
+	}
+	return nil
+}
+
+// Transport returns a RoundTripper injecting faults into registry requests
+// before passing them to next.
+func (i *Injector) Transport(next http.RoundTripper) http.RoundTripper {
+	return roundTripper(func(req *http.Request) (*http.Response, error) {
+		if err := i.Inject(req.Context(), TargetRegistry); err != nil {
+			return nil, err
+		}
+		return next.RoundTrip(req)
+	})
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// RedisHook returns a go-redis hook injecting faults into store commands.
+// A pipeline is delayed or failed as a whole.
+func (i *Injector) RedisHook() redis.Hook {
+	return hook{i}
+}
+
+type hook struct{ i *Injector }
+
+func (h hook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h hook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if err := h.i.Inject(ctx, TargetStore); err != nil {
+			cmd.SetErr(err)
+			return err
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h hook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if err := h.i.Inject(ctx, TargetStore); err != nil {
+			for _, cmd := range cmds {
+				cmd.SetErr(err)
+			}
+			return err
+		}
+		return next(ctx, cmds)
+	}
+}
@@ -0,0 +1,76 @@
+package faults
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fixed returns a float source yielding vals in turn.
+func fixed(vals ...float64) func() float64 {
+	return func() float64 {
+		v := vals[0]
+		vals = vals[1:]
+		return v
+	}
+}
+
+func TestInject(t *testing.T) {
+	cfg := Config{Enabled: true, FailRate: 0.2, DelayRate: 0.5, MaxDelay: 20 * time.Millisecond}
+	tests := []struct {
+		name      string
+		dice      []float64
+		wantErr   bool
+		wantDelay bool
+	}{
+		{"no fault", []float64{0.9, 0.9}, false, false},
+		{"fail", []float64{0.9, 0.1}, true, false},
+		{"delay", []float64{0.1, 0.5, 0.9}, false, true},
+		{"delay and fail", []float64{0.1, 0.5, 0.1}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := New(cfg)
+			i.float = fixed(tt.dice...)
+			start := time.Now()
+			err := i.Inject(t.Context(), TargetStore)
+			if got := errors.Is(err, ErrInjected); got != tt.wantErr {
+				t.Errorf("Inject error = %v, want injected %v", err, tt.wantErr)
+			}
+			if delayed := time.Since(start) >= 10*time.Millisecond; delayed != tt.wantDelay {
+				t.Errorf("delayed = %v, want %v", delayed, tt.wantDelay)
+			}
+		})
+	}
+}
+
+func TestInject_ContextDone(t *testing.T) {
+	i := New(Config{Enabled: true, DelayRate: 1, MaxDelay: time.Hour})
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if err := i.Inject(ctx, TargetRegistry); !errors.Is(err, context.Canceled) {
+		t.Errorf("Inject = %v, want context.Canceled", err)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	i := New(Config{Enabled: true, FailRate: 1})
+	client := &http.Client{Transport: i.Transport(http.DefaultTransport)}
+	if _, err := client.Get(srv.URL); !errors.Is(err, ErrInjected) {
+		t.Errorf("Get = %v, want ErrInjected", err)
+	}
+
+	i = New(Config{Enabled: true})
+	client = &http.Client{Transport: i.Transport(http.DefaultTransport)}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get without faults: %v", err)
+	}
+	_ = resp.Body.Close()
+}
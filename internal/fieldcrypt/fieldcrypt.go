@@ -0,0 +1,130 @@
+// Package fieldcrypt encrypts individual store fields, such as who pushed
+// an image, with AES-GCM so they are not readable by everyone with access
+// to a shared Redis.
+//
+// Encrypted values are "enc:v1:<key id>:<base64 nonce and ciphertext>",
+// where the key ID is derived from the key. Values without that prefix
+// were written before encryption was enabled and are returned unchanged,
+// so encryption can be turned on for an existing store. A Keyring holds
+// one primary key that encrypts and any number of old keys that can still
+// decrypt, so keys can be rotated without losing data.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// prefix marks an encrypted value.
+const prefix = "enc:v1:"
+
+// ErrUnknownKey is returned when decrypting a value encrypted with a key
+// that is not in the keyring.
+var ErrUnknownKey = errors.New("value encrypted with an unknown key")
+
+// ParseKey decodes a base64-encoded AES key of 16, 24 or 32 bytes.
+func ParseKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("decoding key: %w", err)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("key is %d bytes, want 16, 24 or 32", len(key))
+	}
+}
+
+// KeyID returns the ID under which values encrypted with key are stored.
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// Keyring encrypts with its primary key and decrypts with any of its keys.
+// A nil Keyring stores values in plain text.
+type Keyring struct {
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+// New creates a Keyring encrypting with primary and also decrypting
+// values encrypted with old.
+func New(primary []byte, old ...[]byte) (*Keyring, error) {
+	k := &Keyring{primary: KeyID(primary), aeads: make(map[string]cipher.AEAD, 1+len(old))}
+	for _, key := range append([][]byte{primary}, old...) {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.aeads[KeyID(key)] = aead
+	}
+	return k, nil
+}
+
+// Encrypt encrypts value with the primary key. Empty values are kept
+// empty, so missing fields still read as missing.
+func (k *Keyring) Encrypt(value string) (string, error) {
+	if k == nil || value == "" {
+		return value, nil
+	}
+	aead := k.aeads[k.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), nil)
+	return prefix + k.primary + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns the plain text of value. Values that are not encrypted
+// are returned unchanged.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	rest, ok := strings.CutPrefix(value, prefix)
+	if !ok {
+		return value, nil
+	}
+	if k == nil {
+		return "", errors.New("value is encrypted but no encryption key is configured")
+	}
+	id, data, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", errors.New("malformed encrypted value")
+	}
+	aead, ok := k.aeads[id]
+	if !ok {
+		return "", fmt.Errorf("%w %s", ErrUnknownKey, id)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("decrypting value: %w", err)
+	}
+	return string(plain), nil
+}
+
+// Current reports whether value is stored as the keyring would store it
+// now: empty, or encrypted with the primary key. Values that are not
+// need re-encrypting after a key rotation.
+func (k *Keyring) Current(value string) bool {
+	if k == nil || value == "" {
+		return true
+	}
+	return strings.HasPrefix(value, prefix+k.primary+":")
+}
@@ -0,0 +1,90 @@
+package fieldcrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	got, err := ParseKey(base64.StdEncoding.EncodeToString(key) + "\n")
+	if err != nil || !bytes.Equal(got, key) {
+		t.Errorf("ParseKey() = %x, %v; want %x", got, err, key)
+	}
+	if _, err := ParseKey(base64.StdEncoding.EncodeToString(key[:20])); err == nil {
+		t.Error("expected error for a 20-byte key")
+	}
+	if _, err := ParseKey("not base64!"); err == nil {
+		t.Error("expected error for invalid base64")
+	}
+}
+
+func TestKeyring_RoundTrip(t *testing.T) {
+	k, err := New(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := k.Encrypt("ci-bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(enc, prefix) || strings.Contains(enc, "ci-bot") {
+		t.Errorf("Encrypt() = %q, want an encrypted value", enc)
+	}
+	if again, _ := k.Encrypt("ci-bot"); again == enc {
+		t.Error("Encrypt() is deterministic, want a random nonce")
+	}
+	if got, err := k.Decrypt(enc); err != nil || got != "ci-bot" {
+		t.Errorf("Decrypt() = %q, %v; want ci-bot", got, err)
+	}
+	if !k.Current(enc) {
+		t.Error("Current() = false for a value encrypted with the primary key")
+	}
+
+	if enc, _ := k.Encrypt(""); enc != "" {
+		t.Errorf("Encrypt(\"\") = %q, want empty", enc)
+	}
+	if got, err := k.Decrypt("legacy plain text"); err != nil || got != "legacy plain text" {
+		t.Errorf("Decrypt(plain) = %q, %v; want it unchanged", got, err)
+	}
+	if k.Current("legacy plain text") {
+		t.Error("Current() = true for a plain-text value")
+	}
+	tampered := enc[:len(enc)-2] + "AA"
+	if _, err := k.Decrypt(tampered); err == nil {
+		t.Error("expected error for a tampered value")
+	}
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 16)
+	before, _ := New(oldKey)
+	enc, _ := before.Encrypt("10.0.0.7")
+
+	after, err := New(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := after.Decrypt(enc); err != nil || got != "10.0.0.7" {
+		t.Errorf("Decrypt() with the old key = %q, %v", got, err)
+	}
+	if after.Current(enc) {
+		t.Error("Current() = true for a value encrypted with an old key")
+	}
+
+	retired, _ := New(newKey)
+	if _, err := retired.Decrypt(enc); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Decrypt() after retiring the old key = %v, want ErrUnknownKey", err)
+	}
+
+	var plain *Keyring
+	if got, _ := plain.Encrypt("x"); got != "x" {
+		t.Errorf("nil Keyring Encrypt() = %q, want plain text", got)
+	}
+	if _, err := plain.Decrypt(enc); err == nil {
+		t.Error("expected error decrypting without a keyring")
+	}
+}
@@ -0,0 +1,243 @@
+// Package gcanalyze estimates how much storage the registry's garbage
+// collector would reclaim. Deleting a manifest through the API only removes
+// links; the blobs stay on disk until garbage collection runs. The analysis
+// walks the registry API for the blobs still referenced by tagged manifests
+// and compares them with the blobs in the registry's storage directory.
+package gcanalyze
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+// Repository is the storage linked to one repository.
+type Repository struct {
+	Name string `json:"repository" yaml:"repository"`
+	// Blobs and Bytes count the layers and manifests linked to the
+	// repository.
+	Blobs int   `json:"blobs" yaml:"blobs"`
+	Bytes int64 `json:"bytes" yaml:"bytes"`
+	// Unreferenced and ReclaimableBytes count the linked blobs no tagged
+	// manifest in any repository references.
+	Unreferenced     int   `json:"unreferenced_blobs" yaml:"unreferenced_blobs"`
+	ReclaimableBytes int64 `json:"reclaimable_bytes" yaml:"reclaimable_bytes"`
+}
+
+// Report is the result of an analysis. A blob linked to several
+// repositories counts toward each of them, but only once in the totals.
+type Report struct {
+	Repositories []Repository `json:"repositories" yaml:"repositories"`
+	// TotalBlobs and TotalBytes cover every blob in storage.
+	TotalBlobs int   `json:"total_blobs" yaml:"total_blobs"`
+	TotalBytes int64 `json:"total_bytes" yaml:"total_bytes"`
+	// ReclaimableBytes is what garbage collection would free.
+	ReclaimableBytes int64 `json:"reclaimable_bytes" yaml:"reclaimable_bytes"`
+	// UnlinkedBytes is the part of ReclaimableBytes linked to no
+	// repository, such as the manifests of deleted images.
+	UnlinkedBytes int64 `json:"unlinked_bytes" yaml:"unlinked_bytes"`
+}
+
+// Analyzer compares the registry's references with its storage.
+type Analyzer struct {
+	registry *registry.Client
+	root     string
+	logger   *slog.Logger
+}
+
+// New returns an Analyzer for a registry whose storage root is mounted at
+// dataPath.
+func New(reg *registry.Client, dataPath string, logger *slog.Logger) *Analyzer {
+	return &Analyzer{
+		registry: reg,
+		root:     filepath.Join(dataPath, "docker", "registry", "v2"),
+		logger:   logger,
+	}
+}
+
+// Analyze estimates the reclaimable storage per repository. The estimate
+// matches a garbage collection run with --delete-untagged; without it,
+// untagged manifests the registry still holds keep their blobs. Pushes
+// during the analysis can make blobs of new images appear reclaimable.
+func (a *Analyzer) Analyze(ctx context.Context) (Report, error) {
+	var rep Report
+
+	// Read storage first, so blobs pushed while the API is walked are
+	// not mistaken for unreferenced ones.
+	sizes, err := a.blobSizes()
+	if err != nil {
+		return rep, fmt.Errorf("reading blob storage: %w", err)
+	}
+	links, err := a.repositoryLinks()
+	if err != nil {
+		return rep, fmt.Errorf("reading repository links: %w", err)
+	}
+	referenced, err := a.referenced(ctx)
+	if err != nil {
+		return rep, err
+	}
+
+	linked := make(map[string]bool)
+	for name, digests := range links {
+		repo := Repository{Name: name}
+		for _, digest := range digests {
+			size, ok := sizes[digest]
+			if !ok {
+				continue // link to a blob already collected
+			}
+			linked[digest] = true
+			repo.Blobs++
+			repo.Bytes += size
+			if !referenced[digest] {
+				repo.Unreferenced++
+				repo.ReclaimableBytes += size
+			}
+		}
+		rep.Repositories = append(rep.Repositories, repo)
+	}
+	sort.Slice(rep.Repositories, func(i, j int) bool {
+		ri, rj := rep.Repositories[i], rep.Repositories[j]
+		if ri.ReclaimableBytes != rj.ReclaimableBytes {
+			return ri.ReclaimableBytes > rj.ReclaimableBytes
+		}
+		return ri.Name < rj.Name
+	})
+
+	for digest, size := range sizes {
+		rep.TotalBlobs++
+		rep.TotalBytes += size
+		if referenced[digest] {
+			continue
+		}
+		rep.ReclaimableBytes += size
+		if !linked[digest] {
+			rep.UnlinkedBytes += size
+		}
+	}
+	return rep, nil
+}
+
+// referenced walks every tag in the registry and returns the digests of
+// the manifests and blobs they reference. Any failure aborts the walk, as
+// a missed reference would report live blobs as reclaimable.
+func (a *Analyzer) referenced(ctx context.Context) (map[string]bool, error) {
+	repos, err := a.registry.ListRepositories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	referenced := make(map[string]bool)
+	for _, repo := range repos {
+		tags, err := a.registry.ListTags(ctx, repo)
+		if errors.Is(err, registry.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		a.logger.Debug("walking repository", "repo", repo, "tags", len(tags))
+		for _, tag := range tags {
+			if err := a.walk(ctx, repo, tag, referenced); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return referenced, nil
+}
+
+// walk marks the manifest repo:ref and everything it references, including
+// the children of an index, as referenced.
+func (a *Analyzer) walk(ctx context.Context, repo, ref string, referenced map[string]bool) error {
+	refs, err := a.registry.ManifestReferences(ctx, repo, ref)
+	if errors.Is(err, registry.ErrNotFound) {
+		return nil // deleted since the tags were listed
+	}
+	if err != nil {
+		return err
+	}
+	referenced[refs.Digest] = true
+	for _, b := range refs.Blobs {
+		referenced[b.Digest] = true
+	}
+	for _, m := range refs.Manifests {
+		if referenced[m.Digest] {
+			continue
+		}
+		if err := a.walk(ctx, repo, m.Digest, referenced); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blobSizes returns the size of every blob in storage by digest.
+func (a *Analyzer) blobSizes() (map[string]int64, error) {
+	dir := filepath.Join(a.root, "blobs", "sha256")
+	shards, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]int64)
+	for _, shard := range shards {
+		blobs, err := os.ReadDir(filepath.Join(dir, shard.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range blobs {
+			info, err := os.Stat(filepath.Join(dir, shard.Name(), blob.Name(), "data"))
+			if errors.Is(err, fs.ErrNotExist) {
+				continue // upload in progress or partially deleted
+			}
+			if err != nil {
+				return nil, err
+			}
+			sizes["sha256:"+blob.Name()] = info.Size()
+		}
+	}
+	return sizes, nil
+}
+
+// repositoryLinks returns the digests of the layers and manifest revisions
+// linked to each repository.
+func (a *Analyzer) repositoryLinks() (map[string][]string, error) {
+	dir := filepath.Join(a.root, "repositories")
+	links := make(map[string][]string)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		var linkDir string
+		switch d.Name() {
+		case "_layers":
+			linkDir = filepath.Join(p, "sha256")
+		case "_manifests":
+			linkDir = filepath.Join(p, "revisions", "sha256")
+		case "_uploads":
+			return filepath.SkipDir
+		default:
+			return nil
+		}
+		repo, err := filepath.Rel(dir, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(linkDir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		name := filepath.ToSlash(repo)
+		for _, e := range entries {
+			if _, err := os.Stat(filepath.Join(linkDir, e.Name(), "link")); err == nil {
+				links[name] = append(links[name], "sha256:"+e.Name())
+			}
+		}
+		return filepath.SkipDir
+	})
+	return links, err
+}
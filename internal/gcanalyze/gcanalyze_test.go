@@ -0,0 +1,135 @@
+package gcanalyze
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+// writeBlob stores a blob of size bytes under digest hex in the storage
+// layout rooted at root.
+func writeBlob(t *testing.T, root, hex string, size int) {
+	t.Helper()
+	dir := filepath.Join(root, "docker", "registry", "v2", "blobs", "sha256", hex[:2], hex)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "data"), make([]byte, size), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeLink links blob hex to repo as a layer, or as a manifest revision.
+func writeLink(t *testing.T, root, repo, hex string, manifest bool) {
+	t.Helper()
+	dir := filepath.Join(root, "docker", "registry", "v2", "repositories", repo, "_layers", "sha256", hex)
+	if manifest {
+		dir = filepath.Join(root, "docker", "registry", "v2", "repositories", repo,
+			"_manifests", "revisions", "sha256", hex)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "link"), []byte("sha256:"+hex), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	// team/app:v1 is an index over one image manifest; other:old was
+	// deleted through the API, leaving its layer and manifest blobs behind.
+	manifests := map[string]string{
+		"/v2/team/app/manifests/v1": `{"manifests": [{"digest": "sha256:aa02", "size": 10}]}`,
+		"/v2/team/app/manifests/sha256:aa02": `{"config": {"digest": "sha256:bb01", "size": 100},
+			"layers": [{"digest": "sha256:cc01", "size": 1000}]}`,
+	}
+	digests := map[string]string{
+		"/v2/team/app/manifests/v1":          "sha256:aa01",
+		"/v2/team/app/manifests/sha256:aa02": "sha256:aa02",
+	}
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/_catalog":
+			_, _ = w.Write([]byte(`{"repositories": ["team/app", "other"]}`))
+		case r.URL.Path == "/v2/team/app/tags/list":
+			_, _ = w.Write([]byte(`{"tags": ["v1"]}`))
+		case r.URL.Path == "/v2/other/tags/list":
+			w.WriteHeader(http.StatusNotFound)
+		case strings.Contains(r.URL.Path, "/manifests/"):
+			body, ok := manifests[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", digests[r.URL.Path])
+			_, _ = w.Write([]byte(body))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer reg.Close()
+
+	root := t.TempDir()
+	for hex, size := range map[string]int{"aa01": 10, "aa02": 20, "bb01": 100, "cc01": 1000, "dd01": 5000, "ee01": 7} {
+		writeBlob(t, root, hex, size)
+	}
+	writeLink(t, root, "team/app", "aa01", true)
+	writeLink(t, root, "team/app", "aa02", true)
+	writeLink(t, root, "team/app", "bb01", false)
+	writeLink(t, root, "team/app", "cc01", false)
+	writeLink(t, root, "other", "cc01", false)
+	writeLink(t, root, "other", "dd01", false)
+	writeLink(t, root, "other", "ff01", false) // blob already collected
+
+	rep, err := New(registry.New(reg.URL), root, slog.Default()).Analyze(t.Context())
+	if err != nil {
+		t.Fatalf("Analyze: %v", err)
+	}
+
+	want := []Repository{
+		{Name: "other", Blobs: 2, Bytes: 6000, Unreferenced: 1, ReclaimableBytes: 5000},
+		{Name: "team/app", Blobs: 4, Bytes: 1130},
+	}
+	if len(rep.Repositories) != len(want) {
+		t.Fatalf("repositories = %+v, want %+v", rep.Repositories, want)
+	}
+	for i := range want {
+		if rep.Repositories[i] != want[i] {
+			t.Errorf("repositories[%d] = %+v, want %+v", i, rep.Repositories[i], want[i])
+		}
+	}
+	if rep.TotalBlobs != 6 || rep.TotalBytes != 6137 {
+		t.Errorf("totals = %d blobs, %d bytes; want 6, 6137", rep.TotalBlobs, rep.TotalBytes)
+	}
+	if rep.ReclaimableBytes != 5007 || rep.UnlinkedBytes != 7 {
+		t.Errorf("reclaimable = %d, unlinked = %d; want 5007, 7", rep.ReclaimableBytes, rep.UnlinkedBytes)
+	}
+}
+
+func TestAnalyze_RegistryError(t *testing.T) {
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/_catalog":
+			_, _ = w.Write([]byte(`{"repositories": ["app"]}`))
+		case "/v2/app/tags/list":
+			_, _ = w.Write([]byte(`{"tags": ["v1"]}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer reg.Close()
+
+	root := t.TempDir()
+	writeBlob(t, root, "aa01", 10)
+	writeLink(t, root, "app", "aa01", false)
+
+	if _, err := New(registry.New(reg.URL), root, slog.Default()).Analyze(t.Context()); err == nil {
+		t.Error("expected an error when a manifest cannot be read, not live blobs reported reclaimable")
+	}
+}
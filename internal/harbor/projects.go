@@ -0,0 +1,233 @@
+// Package harbor provisions Harbor projects for the repositories ephemeron
+// sees pushed, so a Harbor instance downstream of the registry, such as a
+// replication target, has a project with retention settings ready for every
+// new repository prefix without anyone creating it by hand.
+package harbor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+)
+
+// Outcomes of EnsureProject, as counted in metrics.
+const (
+	outcomeCreated = "created"
+	outcomeExists  = "exists"
+	outcomeFailed  = "failed"
+)
+
+// retentionCron runs project retention policies daily at midnight, in
+// Harbor's six-field cron syntax.
+const retentionCron = "0 0 0 * * *"
+
+// Provisioner creates Harbor projects through the Harbor v2 API.
+type Provisioner struct {
+	baseURL    string
+	username   string
+	password   string
+	retention  time.Duration
+	public     bool
+	httpClient *http.Client
+	logger     *slog.Logger
+
+	mu    sync.Mutex
+	known map[string]struct{}
+}
+
+// Option configures a Provisioner.
+type Option func(*Provisioner)
+
+// WithRetention gives new projects a retention policy keeping artifacts
+// pushed within d, rounded up to whole days, and run daily by Harbor. Zero
+// creates projects without one.
+func WithRetention(d time.Duration) Option {
+	return func(p *Provisioner) {
+		p.retention = d
+	}
+}
+
+// WithHTTPClient sends Harbor API requests with client instead of one with
+// a 10 second timeout.
+func WithHTTPClient(client *http.Client) Option {
+	return func(p *Provisioner) {
+		p.httpClient = client
+	}
+}
+
+// WithPublic makes new projects public instead of private.
+func WithPublic(public bool) Option {
+	return func(p *Provisioner) {
+		p.public = public
+	}
+}
+
+// New returns a Provisioner for the Harbor at baseURL, authenticating with
+// basic auth. The account needs permission to create projects and, with a
+// retention, their retention policies.
+func New(baseURL, username, password string, logger *slog.Logger, opts ...Option) *Provisioner {
+	p := &Provisioner{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		username:   username,
+		password:   password,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		logger:     logger,
+		known:      make(map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// EnsureProject creates the project of repo, its first path segment, unless
+// it exists. Repositories without one have no project of their own and are
+// skipped. Projects found or created are remembered, so only the first push
+// to each costs a Harbor call.
+func (p *Provisioner) EnsureProject(ctx context.Context, repo string) error {
+	project, _, ok := strings.Cut(repo, "/")
+	if !ok || project == "" {
+		return nil
+	}
+	p.mu.Lock()
+	_, known := p.known[project]
+	p.mu.Unlock()
+	if known {
+		return nil
+	}
+
+	outcome, err := p.ensure(ctx, project)
+	metrics.HarborProjects.WithLabelValues(outcome).Inc()
+	if err != nil {
+		return fmt.Errorf("provisioning Harbor project %s: %w", project, err)
+	}
+	if outcome == outcomeCreated {
+		p.logger.Info("created Harbor project", "project", project, "retention", p.retention.String())
+	}
+	p.mu.Lock()
+	p.known[project] = struct{}{}
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *Provisioner) ensure(ctx context.Context, project string) (string, error) {
+	status, err := p.do(ctx, http.MethodHead, "/projects?project_name="+url.QueryEscape(project), nil, nil)
+	if err != nil {
+		return outcomeFailed, err
+	}
+	if status == http.StatusOK {
+		return outcomeExists, nil
+	}
+	if status != http.StatusNotFound {
+		return outcomeFailed, fmt.Errorf("HEAD project returned %d", status)
+	}
+
+	body := map[string]any{
+		"project_name": project,
+		"metadata":     map[string]string{"public": fmt.Sprint(p.public)},
+	}
+	status, err = p.do(ctx, http.MethodPost, "/projects", body, nil)
+	switch {
+	case err != nil:
+		return outcomeFailed, err
+	case status == http.StatusConflict:
+		// Created by another replica in the meantime.
+		return outcomeExists, nil
+	case status != http.StatusCreated:
+		return outcomeFailed, fmt.Errorf("POST project returned %d", status)
+	}
+
+	if p.retention > 0 {
+		if err := p.addRetention(ctx, project); err != nil {
+			// Later pushes find the project and leave it alone.
+			return outcomeFailed, fmt.Errorf("project created without retention policy: %w", err)
+		}
+	}
+	return outcomeCreated, nil
+}
+
+// addRetention adds the retention policy to the new project.
+func (p *Provisioner) addRetention(ctx context.Context, project string) error {
+	var info struct {
+		ProjectID int64 `json:"project_id"`
+	}
+	status, err := p.do(ctx, http.MethodGet, "/projects/"+url.PathEscape(project), nil, &info)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("GET project returned %d", status)
+	}
+
+	days := int(math.Ceil(p.retention.Hours() / 24))
+	policy := map[string]any{
+		"algorithm": "or",
+		"rules": []map[string]any{{
+			"action":   "retain",
+			"template": "nDaysSinceLastPush",
+			"params":   map[string]int{"nDaysSinceLastPush": days},
+			"tag_selectors": []map[string]string{
+				{"kind": "doublestar", "decoration": "matches", "pattern": "**"},
+			},
+			"scope_selectors": map[string]any{
+				"repository": []map[string]string{
+					{"kind": "doublestar", "decoration": "repoMatches", "pattern": "**"},
+				},
+			},
+		}},
+		"trigger": map[string]any{"kind": "Schedule", "settings": map[string]string{"cron": retentionCron}},
+		"scope":   map[string]any{"level": "project", "ref": info.ProjectID},
+	}
+	status, err = p.do(ctx, http.MethodPost, "/retentions", policy, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusCreated {
+		return fmt.Errorf("POST retention returned %d", status)
+	}
+	return nil
+}
+
+// do calls the Harbor API at path below /api/v2.0, sending body and
+// decoding a 200 response into out if they are set, and returns the status.
+func (p *Provisioner) do(ctx context.Context, method, path string, body, out any) (int, error) {
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+"/api/v2.0"+path, reader)
+	if err != nil {
+		return 0, fmt.Errorf("creating %s request: %w", method, err)
+	}
+	req.SetBasicAuth(p.username, p.password)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if out != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return 0, fmt.Errorf("decoding %s %s: %w", method, path, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,99 @@
+package harbor
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeHarbor serves the project and retention endpoints of the Harbor API.
+type fakeHarbor struct {
+	mu       sync.Mutex
+	projects map[string]int64
+	calls    []string
+	// retention is the last retention policy posted.
+	retention map[string]any
+	// created is the last project posted.
+	created map[string]any
+}
+
+func (f *fakeHarbor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, r.Method+" "+r.URL.Path)
+	if user, _, _ := r.BasicAuth(); user != "robot$ephemeron" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == http.MethodHead && r.URL.Path == "/api/v2.0/projects":
+		if _, ok := f.projects[r.URL.Query().Get("project_name")]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v2.0/projects":
+		_ = json.NewDecoder(r.Body).Decode(&f.created)
+		f.projects[f.created["project_name"].(string)] = int64(len(f.projects) + 1)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet:
+		name := r.URL.Path[len("/api/v2.0/projects/"):]
+		_ = json.NewEncoder(w).Encode(map[string]any{"project_id": f.projects[name], "name": name})
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v2.0/retentions":
+		_ = json.NewDecoder(r.Body).Decode(&f.retention)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func TestEnsureProject(t *testing.T) {
+	fake := &fakeHarbor{projects: map[string]int64{"existing": 1}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	p := New(srv.URL, "robot$ephemeron", "secret", slog.Default(), WithRetention(36*time.Hour))
+
+	for _, repo := range []string{"team/app", "team/other/app", "existing/app", "library-less"} {
+		if err := p.EnsureProject(t.Context(), repo); err != nil {
+			t.Fatalf("EnsureProject(%s): %v", repo, err)
+		}
+	}
+
+	want := []string{
+		"HEAD /api/v2.0/projects",
+		"POST /api/v2.0/projects",
+		"GET /api/v2.0/projects/team",
+		"POST /api/v2.0/retentions",
+		"HEAD /api/v2.0/projects",
+	}
+	if !slices.Equal(fake.calls, want) {
+		t.Errorf("calls = %v, want %v", fake.calls, want)
+	}
+	if fake.created["project_name"] != "team" || fake.created["metadata"].(map[string]any)["public"] != "false" {
+		t.Errorf("created project = %v, want a private team project", fake.created)
+	}
+	scope := fake.retention["scope"].(map[string]any)
+	params := fake.retention["rules"].([]any)[0].(map[string]any)["params"].(map[string]any)
+	if scope["ref"] != float64(2) || params["nDaysSinceLastPush"] != float64(2) {
+		t.Errorf("retention = %v, want 2 days on project 2", fake.retention)
+	}
+}
+
+func TestEnsureProject_Failure(t *testing.T) {
+	fake := &fakeHarbor{projects: map[string]int64{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	p := New(srv.URL, "someone", "secret", slog.Default())
+
+	for range 2 {
+		if err := p.EnsureProject(t.Context(), "team/app"); err == nil {
+			t.Fatal("expected an error for a refused request")
+		}
+	}
+	if len(fake.calls) != 2 {
+		t.Errorf("calls = %v, want failures retried on the next push", fake.calls)
+	}
+}
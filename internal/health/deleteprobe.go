@@ -0,0 +1,67 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+// DeleteProbe watches whether the registry accepts deletions, which it
+// silently stops doing when restarted without deletion enabled.
+type DeleteProbe struct {
+	probe  func(ctx context.Context) error
+	logger *slog.Logger
+}
+
+// NewDeleteProbe creates a DeleteProbe running probe, which returns an
+// error wrapping registry.ErrDeleteRefused while deletions are refused.
+func NewDeleteProbe(probe func(ctx context.Context) error, logger *slog.Logger) *DeleteProbe {
+	return &DeleteProbe{probe: probe, logger: logger}
+}
+
+// Check probes the registry once and updates the delete_enabled gauge. A
+// probe that fails for other reasons leaves the gauge unchanged.
+func (p *DeleteProbe) Check(ctx context.Context) error {
+	err := p.probe(ctx)
+	switch {
+	case err == nil:
+		metrics.RegistryDeleteEnabled.Set(1)
+	case errors.Is(err, registry.ErrDeleteRefused):
+		metrics.RegistryDeleteEnabled.Set(0)
+		p.logger.Error("registry refuses deletions, expired images cannot be reaped", "error", err)
+	default:
+		p.logger.Warn("delete probe failed", "error", err)
+	}
+	return err
+}
+
+// Start checks once and then, with a positive interval, every interval in
+// the background until ctx is cancelled. With required set, a registry
+// refusing deletions is an error.
+func (p *DeleteProbe) Start(ctx context.Context, interval time.Duration, required bool) error {
+	if err := p.Check(ctx); required && errors.Is(err, registry.ErrDeleteRefused) {
+		return err
+	}
+	if interval > 0 {
+		go p.RunLoop(ctx, interval)
+	}
+	return nil
+}
+
+// RunLoop checks at the given interval until ctx is cancelled.
+func (p *DeleteProbe) RunLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = p.Check(ctx)
+		}
+	}
+}
@@ -1,9 +1,17 @@
 package health
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"sync"
 	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+	"github.com/tamcore/ephemeron/internal/registry"
 )
 
 func TestNew_StartsHealthy(t *testing.T) {
@@ -102,3 +110,48 @@ func TestConcurrentAccess(t *testing.T) {
 		t.Errorf("expected 50 failures after concurrent writes, got %d", c.ConsecutiveFailures())
 	}
 }
+
+func TestDeleteProbe_Check(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want float64
+	}{
+		{name: "enabled", err: nil, want: 1},
+		{name: "refused", err: fmt.Errorf("%w: disabled", registry.ErrDeleteRefused), want: 0},
+		{name: "unreachable keeps last value", err: errors.New("connection refused"), want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewDeleteProbe(func(context.Context) error { return tt.err }, slog.New(slog.DiscardHandler))
+			if err := p.Check(context.Background()); !errors.Is(err, tt.err) {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+			if got := testutil.ToFloat64(metrics.RegistryDeleteEnabled); got != tt.want {
+				t.Errorf("delete_enabled = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteProbe_Start(t *testing.T) {
+	refused := fmt.Errorf("%w: disabled", registry.ErrDeleteRefused)
+	tests := []struct {
+		name     string
+		err      error
+		required bool
+		wantErr  bool
+	}{
+		{name: "refused and required", err: refused, required: true, wantErr: true},
+		{name: "refused", err: refused},
+		{name: "unreachable and required", err: errors.New("connection refused"), required: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewDeleteProbe(func(context.Context) error { return tt.err }, slog.New(slog.DiscardHandler))
+			if err := p.Start(t.Context(), 0, tt.required); (err != nil) != tt.wantErr {
+				t.Errorf("Start() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// alertStatusFiring is the Alertmanager status of an active alert.
+const alertStatusFiring = "firing"
+
+// evictBytesAnnotation lets an alert override the eviction target.
+const evictBytesAnnotation = "ephemeron_evict_bytes"
+
+// AlertmanagerMessage is the payload of an Alertmanager webhook.
+type AlertmanagerMessage struct {
+	Status string  `json:"status"`
+	Alerts []Alert `json:"alerts"`
+}
+
+// Alert is a single alert within an Alertmanager webhook.
+type Alert struct {
+	Status      string            `json:"status"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// Evictor frees registry storage ahead of expiry.
+type Evictor interface {
+	Evict(ctx context.Context, targetBytes int64) error
+}
+
+// EvictorFunc adapts a function to the Evictor interface.
+type EvictorFunc func(ctx context.Context, targetBytes int64) error
+
+// Evict calls f.
+func (f EvictorFunc) Evict(ctx context.Context, targetBytes int64) error {
+	return f(ctx, targetBytes)
+}
+
+// AlertHandler receives Alertmanager webhooks and starts an emergency
+// eviction while any alert is firing.
+type AlertHandler struct {
+	ctx         context.Context
+	evictor     Evictor
+	token       func() string
+	targetBytes int64
+	logger      *slog.Logger
+	running     atomic.Bool
+}
+
+// AlertOption configures an AlertHandler.
+type AlertOption func(*AlertHandler)
+
+// WithAlertTokenFunc is like WithHookTokenFunc for the AlertHandler.
+func WithAlertTokenFunc(token func() string) AlertOption {
+	return func(h *AlertHandler) {
+		h.token = token
+	}
+}
+
+// NewAlertHandler creates an AlertHandler. Evictions run in the background
+// under ctx so Alertmanager is not kept waiting; at most one runs at a time.
+func NewAlertHandler(
+	ctx context.Context,
+	evictor Evictor,
+	token string,
+	targetBytes int64,
+	logger *slog.Logger,
+	opts ...AlertOption,
+) *AlertHandler {
+	h := &AlertHandler{
+		ctx:         ctx,
+		evictor:     evictor,
+		token:       func() string { return token },
+		targetBytes: targetBytes,
+		logger:      logger,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// ServeHTTP handles POST /v1/hook/alertmanager.
+func (h *AlertHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !validToken(r, h.token()) {
+		h.logger.Warn("unauthorized alertmanager request")
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "invalid or missing token")
+		return
+	}
+
+	var msg AlertmanagerMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		h.logger.Error("failed to decode alertmanager body", "error", err)
+		writeError(w, http.StatusBadRequest, codeBadRequest, "invalid alertmanager body")
+		return
+	}
+
+	target, firing := h.target(msg)
+	if !firing {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("{}"))
+		return
+	}
+
+	if !h.running.CompareAndSwap(false, true) {
+		h.logger.Info("emergency eviction already running, ignoring alert")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("{}"))
+		return
+	}
+	h.logger.Warn("alert firing, starting emergency eviction", "target_bytes", target)
+	go func() {
+		defer h.running.Store(false)
+		if err := h.evictor.Evict(h.ctx, target); err != nil {
+			h.logger.Error("emergency eviction failed", "error", err)
+		}
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write([]byte("{}"))
+}
+
+// target returns the eviction target for msg and whether any alert is
+// firing. The largest valid ephemeron_evict_bytes annotation among firing
+// alerts overrides the configured target.
+func (h *AlertHandler) target(msg AlertmanagerMessage) (int64, bool) {
+	target := h.targetBytes
+	var firing, overridden bool
+	for _, a := range msg.Alerts {
+		if a.Status != alertStatusFiring {
+			continue
+		}
+		firing = true
+		v, ok := a.Annotations[evictBytesAnnotation]
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			h.logger.Warn("ignoring invalid eviction target annotation", "value", v)
+			continue
+		}
+		if !overridden || n > target {
+			target, overridden = n, true
+		}
+	}
+	return target, firing
+}
@@ -0,0 +1,71 @@
+package hooks
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func alertRequest(token, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/v1/hook/alertmanager", strings.NewReader(body))
+	req.Header.Set("Authorization", "Token "+token)
+	return req
+}
+
+func TestAlertHandler_Unauthorized(t *testing.T) {
+	h := NewAlertHandler(t.Context(), EvictorFunc(func(context.Context, int64) error {
+		t.Error("evictor must not be called")
+		return nil
+	}), "secret", 100, slog.Default())
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, alertRequest("wrong", `{"alerts":[{"status":"firing"}]}`))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", rr.Code)
+	}
+}
+
+func TestAlertHandler_ResolvedDoesNotEvict(t *testing.T) {
+	h := NewAlertHandler(t.Context(), EvictorFunc(func(context.Context, int64) error {
+		t.Error("evictor must not be called")
+		return nil
+	}), "secret", 100, slog.Default())
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, alertRequest("secret", `{"status":"resolved","alerts":[{"status":"resolved"}]}`))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rr.Code)
+	}
+}
+
+func TestAlertHandler_FiringEvicts(t *testing.T) {
+	targets := make(chan int64, 1)
+	h := NewAlertHandler(t.Context(), EvictorFunc(func(_ context.Context, target int64) error {
+		targets <- target
+		return nil
+	}), "secret", 100, slog.Default())
+
+	body := `{"status":"firing","alerts":[
+		{"status":"firing","annotations":{"ephemeron_evict_bytes":"500"}},
+		{"status":"firing","annotations":{"ephemeron_evict_bytes":"bogus"}},
+		{"status":"resolved","annotations":{"ephemeron_evict_bytes":"900"}}
+	]}`
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, alertRequest("secret", body))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rr.Code)
+	}
+
+	select {
+	case got := <-targets:
+		if got != 500 {
+			t.Errorf("expected target 500 from annotation, got %d", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("evictor was not called")
+	}
+}
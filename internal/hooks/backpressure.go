@@ -0,0 +1,100 @@
+package hooks
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+)
+
+// Reasons a request is rejected by back-pressure, used as metric labels.
+const (
+	overloadInFlight     = "in_flight"
+	overloadStoreLatency = "store_latency"
+)
+
+// latencyWeight is the weight of the newest store write in the moving
+// average of store latency.
+const latencyWeight = 0.2
+
+// backpressure rejects webhook requests while too many are being handled at
+// once or store writes are slow, so the registry backs off instead of
+// piling on more work.
+type backpressure struct {
+	maxInFlight      int64
+	latencyThreshold time.Duration
+	retryAfter       time.Duration
+
+	inFlight atomic.Int64
+
+	mu       sync.Mutex
+	latency  time.Duration // moving average of store write latency
+	observed time.Time     // time of the last store write
+}
+
+// WithBackpressure answers 429 Too Many Requests with a Retry-After header
+// while maxInFlight requests are already being handled, or while the moving
+// average of store write latency exceeds latencyThreshold. Zero disables
+// the respective check. The latency check lapses once no write has been
+// observed for retryAfter, so the next request can measure the store again.
+func WithBackpressure(maxInFlight int, latencyThreshold, retryAfter time.Duration) HandlerOption {
+	return func(h *Handler) {
+		if maxInFlight <= 0 && latencyThreshold <= 0 {
+			return
+		}
+		h.backpressure = &backpressure{
+			maxInFlight:      int64(maxInFlight),
+			latencyThreshold: latencyThreshold,
+			retryAfter:       retryAfter,
+		}
+	}
+}
+
+// admit reserves a slot for a request. It returns a release function on
+// success, or the reason the request must be rejected.
+func (b *backpressure) admit() (release func(), reason string) {
+	if b.overloadedStore() {
+		return nil, overloadStoreLatency
+	}
+	n := b.inFlight.Add(1)
+	if b.maxInFlight > 0 && n > b.maxInFlight {
+		b.inFlight.Add(-1)
+		return nil, overloadInFlight
+	}
+	metrics.WebhookInFlight.Set(float64(n))
+	return func() { metrics.WebhookInFlight.Set(float64(b.inFlight.Add(-1))) }, ""
+}
+
+// observeStore feeds the latency of a store write into the moving average.
+func (b *backpressure) observeStore(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.observed.IsZero() {
+		b.latency = d
+	} else {
+		b.latency = time.Duration(latencyWeight*float64(d) + (1-latencyWeight)*float64(b.latency))
+	}
+	b.observed = time.Now()
+}
+
+// overloadedStore reports whether recent store writes were slower than the
+// threshold.
+func (b *backpressure) overloadedStore() bool {
+	if b.latencyThreshold <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.latency > b.latencyThreshold && time.Since(b.observed) < b.retryAfter
+}
+
+// reject writes a 429 response asking the registry to retry later.
+func (b *backpressure) reject(w http.ResponseWriter, reason string) {
+	metrics.WebhookRejections.WithLabelValues(reason).Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(b.retryAfter.Seconds()))))
+	writeError(w, http.StatusTooManyRequests, codeOverloaded, "overloaded ("+reason+"), retry later")
+}
@@ -0,0 +1,71 @@
+package hooks
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBackpressure_InFlight(t *testing.T) {
+	h := NewHandler(nil, nil, "tok", 0, 0, nil, slog.Default(), WithBackpressure(1, 0, time.Second))
+	b := h.backpressure
+
+	release, _ := b.admit()
+	if release == nil {
+		t.Fatal("first request should be admitted")
+	}
+	if r, reason := b.admit(); r != nil || reason != overloadInFlight {
+		t.Fatalf("second request: admitted=%v reason=%q, want rejection for %q", r != nil, reason, overloadInFlight)
+	}
+	release()
+	if r, _ := b.admit(); r == nil {
+		t.Error("request after release should be admitted")
+	}
+}
+
+func TestBackpressure_StoreLatency(t *testing.T) {
+	h := NewHandler(nil, nil, "tok", 0, 0, nil, slog.Default(),
+		WithBackpressure(0, 100*time.Millisecond, 50*time.Millisecond))
+	b := h.backpressure
+
+	b.observeStore(10 * time.Millisecond)
+	if r, _ := b.admit(); r == nil {
+		t.Fatal("fast store should not reject requests")
+	}
+	b.observeStore(time.Second)
+	if r, reason := b.admit(); r != nil || reason != overloadStoreLatency {
+		t.Fatalf("slow store: admitted=%v reason=%q, want rejection", r != nil, reason)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if r, _ := b.admit(); r == nil {
+		t.Error("latency check should lapse after retryAfter without writes")
+	}
+}
+
+func TestBackpressure_Disabled(t *testing.T) {
+	h := NewHandler(nil, nil, "tok", 0, 0, nil, slog.Default(), WithBackpressure(0, 0, time.Second))
+	if h.backpressure != nil {
+		t.Error("back-pressure should be disabled without limits")
+	}
+}
+
+func TestHandler_BackpressureResponse(t *testing.T) {
+	h := NewHandler(nil, nil, "tok", 0, 0, nil, slog.Default(), WithBackpressure(1, 0, 1500*time.Millisecond))
+	release, _ := h.backpressure.admit()
+	defer release()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader([]byte("{}")))
+	req.Header.Set("Authorization", "Token tok")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+}
@@ -0,0 +1,49 @@
+package hooks
+
+import (
+	"slices"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+// actionMount is the action of a blob mounted from another repository
+// instead of uploaded, as clients like buildkit do for shared layers.
+const actionMount = "mount"
+
+// manifestMediaTypes are the target media types of manifest events. Other
+// targets of push and mount events are blobs.
+var manifestMediaTypes = []string{
+	registry.MediaTypeOCIManifest,
+	registry.MediaTypeDockerManifest,
+	registry.MediaTypeDockerSchema1,
+	registry.MediaTypeDockerSchema1JWS,
+	registry.MediaTypeOCIIndex,
+	registry.MediaTypeDockerList,
+	"application/vnd.oci.artifact.manifest.v1+json",
+}
+
+// isBlob reports whether the event is about a blob (a layer or config)
+// rather than a manifest. Blobs have no tag, so an event naming one is
+// always a manifest, even of a media type not listed here.
+func (t EventTarget) isBlob() bool {
+	return t.Tag == "" && !slices.Contains(manifestMediaTypes, t.MediaType)
+}
+
+// countBlob records a blob push or mount. Blobs are not tracked: the size
+// of an image is that of its manifest, layers included, so the counters
+// only show how much was uploaded and how much was mounted across
+// repositories instead.
+func (h *Handler) countBlob(event RegistryEvent, source string) {
+	metrics.WebhookBlobEvents.WithLabelValues(event.Action).Inc()
+	metrics.WebhookBlobBytes.WithLabelValues(event.Action).Add(float64(max(event.Target.Size, 0)))
+	if event.Action == actionMount {
+		h.logger.Debug("blob mounted across repositories",
+			"repository", event.Target.Repository,
+			"from", event.Target.FromRepository,
+			"digest", event.Target.Digest,
+			"size_bytes", event.Target.Size,
+			"source", source,
+		)
+	}
+}
@@ -0,0 +1,90 @@
+package hooks
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+func TestHandler_BlobEvents(t *testing.T) {
+	store := newMockStore()
+	handler := NewHandler(store, &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default())
+
+	pushes := testutil.ToFloat64(metrics.WebhookBlobEvents.WithLabelValues(actionPush))
+	mounts := testutil.ToFloat64(metrics.WebhookBlobEvents.WithLabelValues(actionMount))
+	mountedBytes := testutil.ToFloat64(metrics.WebhookBlobBytes.WithLabelValues(actionMount))
+
+	const layer = "application/vnd.oci.image.layer.v1.tar+gzip"
+	rr := postSummary(t, handler, "",
+		RegistryEvent{Action: testPush, Target: EventTarget{
+			Repository: testApp, Digest: "sha256:layer", MediaType: layer, Size: 100,
+		}},
+		RegistryEvent{Action: actionMount, Target: EventTarget{
+			Repository: testApp, Digest: "sha256:cache", MediaType: layer, Size: 2048, FromRepository: "cache/app",
+		}},
+		RegistryEvent{Action: testPush, Target: EventTarget{
+			Repository: testApp, Tag: "1h", Digest: "sha256:manifest", MediaType: registry.MediaTypeOCIManifest,
+		}},
+		RegistryEvent{Action: testPush, Target: EventTarget{
+			Repository: testApp, Digest: "sha256:untagged", MediaType: registry.MediaTypeOCIIndex,
+		}},
+		RegistryEvent{Action: testPush, Target: EventTarget{
+			Repository: testApp, Tag: "2h", Digest: "sha256:artifact",
+			MediaType: "application/vnd.example.manifest.v1+json",
+		}},
+	)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rr.Code)
+	}
+	var got summary
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	results := make([]string, len(got.Events))
+	for i, e := range got.Events {
+		results[i] = e.Result
+	}
+	if want := []string{resultCounted, resultCounted, resultTracked, resultSkipped, resultTracked}; !slices.Equal(results, want) {
+		t.Errorf("results = %v, want %v", results, want)
+	}
+	if !slices.Equal(got.Tracked, []string{testAppTTL, testApp + ":2h"}) {
+		t.Errorf("tracked = %v, want only the tagged manifests", got.Tracked)
+	}
+
+	if d := testutil.ToFloat64(metrics.WebhookBlobEvents.WithLabelValues(actionPush)) - pushes; d != 1 {
+		t.Errorf("blob pushes = %v, want 1", d)
+	}
+	if d := testutil.ToFloat64(metrics.WebhookBlobEvents.WithLabelValues(actionMount)) - mounts; d != 1 {
+		t.Errorf("blob mounts = %v, want 1", d)
+	}
+	if d := testutil.ToFloat64(metrics.WebhookBlobBytes.WithLabelValues(actionMount)) - mountedBytes; d != 2048 {
+		t.Errorf("mounted bytes = %v, want 2048", d)
+	}
+}
+
+func TestEventTarget_IsBlob(t *testing.T) {
+	tests := []struct {
+		target EventTarget
+		want   bool
+	}{
+		{EventTarget{MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip"}, true},
+		{EventTarget{MediaType: "application/octet-stream"}, true},
+		{EventTarget{MediaType: "application/vnd.example.manifest.v1+json", Tag: "1h"}, false},
+		{EventTarget{MediaType: registry.MediaTypeDockerList}, false},
+		{EventTarget{Tag: "1h"}, false},
+		{EventTarget{}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.target.isBlob(); got != tt.want {
+			t.Errorf("%+v: isBlob = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package hooks
+
+import (
+	"context"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// DefaultCacheRepos are the repository patterns of build caches, as
+// written by buildkit's registry cache exporter by convention.
+var DefaultCacheRepos = []string{"*/cache", "*-cache"}
+
+// WithCacheRepos gives repositories matching any of the path.Match
+// patterns, see isCacheRepo, the TTL ttl, both as default and as maximum, so build caches
+// that are rewritten by every CI run do not pile up for the global TTLs.
+// Rules for a repository still take precedence. When a tag of such a
+// repository is pushed again with another manifest, the manifest it
+// pointed at is kept as superseded until its own expiry, for the reaper to
+// delete by digest: untagged, it would otherwise stay until garbage
+// collection deletes untagged manifests, which most registries don't. A
+// zero ttl disables cache repositories.
+func WithCacheRepos(patterns []string, ttl time.Duration) HandlerOption {
+	return func(h *Handler) {
+		h.cacheRepos = patterns
+		h.cacheTTL = ttl
+	}
+}
+
+// isCacheRepo reports whether repo is a build cache repository. A pattern
+// is matched against as many trailing path segments of repo as it has, as
+// "*" does not cross a "/": "*/cache" matches "org/team/cache" as well.
+func (h *Handler) isCacheRepo(repo string) bool {
+	if h.cacheTTL <= 0 {
+		return false
+	}
+	segments := strings.Split(repo, "/")
+	return slices.ContainsFunc(h.cacheRepos, func(pattern string) bool {
+		n := strings.Count(pattern, "/") + 1
+		if n > len(segments) {
+			return false
+		}
+		ok, _ := path.Match(pattern, strings.Join(segments[len(segments)-n:], "/"))
+		return ok
+	})
+}
+
+// superseded returns the manifest image points at, if it is not digest and
+// image is a tag of a cache repository, to pass to supersede once image is
+// tracked with digest. It returns nil otherwise.
+func (h *Handler) superseded(ctx context.Context, repo, image, digest string) *redisclient.SupersededManifest {
+	if digest == "" || !h.isCacheRepo(repo) {
+		return nil
+	}
+	old, err := h.redis.GetImageDigest(ctx, image)
+	if err != nil || old == "" || old == digest {
+		return nil
+	}
+	expiresAt, err := h.redis.GetExpiry(ctx, image)
+	if err != nil {
+		return nil
+	}
+	return &redisclient.SupersededManifest{Repository: repo, Digest: old, ExpiresAt: time.UnixMilli(expiresAt)}
+}
+
+// supersede keeps m, as returned by superseded, until its own expiry for the
+// reaper to delete. It is called only after the push that replaced m is
+// tracked, so a failed write does not orphan the manifest the tag still
+// points at. Manifests other tracked tags still share are left to those tags.
+func (h *Handler) supersede(ctx context.Context, image string, m *redisclient.SupersededManifest) {
+	if m == nil {
+		return
+	}
+	if tagged, err := h.redis.TaggedImages(ctx, m.Repository, m.Digest); err != nil || len(tagged) > 0 {
+		return
+	}
+	if err := h.redis.AddSuperseded(ctx, *m); err != nil {
+		h.logger.Warn("failed to keep superseded cache manifest", "image", image, "digest", m.Digest, "error", err)
+		return
+	}
+	metrics.SupersededManifests.Inc()
+	h.logger.Debug("cache tag pushed again, superseded manifest expires on its own",
+		"image", image, "digest", m.Digest, "expires_at", m.ExpiresAt.Format(time.RFC3339))
+}
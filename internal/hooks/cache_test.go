@@ -0,0 +1,88 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+func TestHandler_CacheRepos(t *testing.T) {
+	store := newMockStore()
+	reg := &mockRegistry{digests: map[string]string{"ci/cache:main": "sha256:new", "app:main": "sha256:new"}}
+	handler := NewHandler(store, reg, "tok", time.Hour, 24*time.Hour, nil, slog.Default(),
+		WithCacheRepos(DefaultCacheRepos, 10*time.Minute))
+
+	old := time.Now().Add(5 * time.Minute)
+	for image, digest := range map[string]string{"ci/cache:main": "sha256:old", "app:main": "sha256:app"} {
+		store.images[image] = old
+		store.digests[image] = digest
+	}
+
+	ctx := context.Background()
+	for _, image := range []string{"ci/cache:main", "ci/cache:2h", "app:main"} {
+		repo, tag, _ := strings.Cut(image, ":")
+		start := time.Now()
+		if _, err := handler.handlePush(ctx, repo, tag, redisclient.ImageMeta{}, start, start); err != nil {
+			t.Fatalf("%s: %v", image, err)
+		}
+		want := time.Hour
+		if repo == "ci/cache" {
+			want = 10 * time.Minute
+		}
+		if got := store.images[image].Sub(start); got != want {
+			t.Errorf("%s: TTL = %v, want %v", image, got, want)
+		}
+	}
+	if want := []string{"ci/cache@sha256:old"}; !slices.Equal(store.superseded, want) {
+		t.Errorf("superseded = %v, want %v", store.superseded, want)
+	}
+}
+
+func TestHandler_CacheReposTrackFailure(t *testing.T) {
+	store := newMockStore()
+	reg := &mockRegistry{digests: map[string]string{"ci/cache:main": "sha256:new"}}
+	handler := NewHandler(store, reg, "tok", time.Hour, 24*time.Hour, nil, slog.Default(),
+		WithCacheRepos(DefaultCacheRepos, 10*time.Minute))
+	store.images["ci/cache:main"] = time.Now().Add(5 * time.Minute)
+	store.digests["ci/cache:main"] = "sha256:old"
+	store.trackErr = errors.New("connection refused")
+
+	ctx, start := context.Background(), time.Now()
+	if _, err := handler.handlePush(ctx, "ci/cache", "main", redisclient.ImageMeta{}, start, start); err == nil {
+		t.Fatal("handlePush should fail when TrackImage fails")
+	}
+	// The tag still points at the old manifest, which must not be reaped.
+	if len(store.superseded) != 0 {
+		t.Errorf("superseded = %v after a failed write, want none", store.superseded)
+	}
+}
+
+func TestHandler_IsCacheRepo(t *testing.T) {
+	handler := NewHandler(newMockStore(), &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default(),
+		WithCacheRepos(DefaultCacheRepos, time.Minute))
+	for repo, want := range map[string]bool{
+		"team/cache":       true,
+		"org/team/cache":   true,
+		"app-cache":        true,
+		"team/app-cache":   true,
+		"cache":            false,
+		"team/cache/build": false,
+		"team/app":         false,
+	} {
+		if got := handler.isCacheRepo(repo); got != want {
+			t.Errorf("isCacheRepo(%q) = %v, want %v", repo, got, want)
+		}
+	}
+
+	disabled := NewHandler(newMockStore(), &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default(),
+		WithCacheRepos(DefaultCacheRepos, 0))
+	if disabled.isCacheRepo("team/cache") {
+		t.Error("cache repositories should be disabled without a TTL")
+	}
+}
@@ -0,0 +1,127 @@
+package hooks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxEvents is the default limit on events accepted per request.
+const DefaultMaxEvents = 1000
+
+// ErrTooManyEvents is returned when a request carries more events than the
+// handler accepts.
+var ErrTooManyEvents = errors.New("too many events")
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// decodeEvents reads events from r, which may hold an envelope with an
+// "events" array, a bare array of events, or a single event object. The
+// body is decoded as a stream so oversized batches are rejected after
+// maxEvents events rather than being read into memory first.
+func decodeEvents(r io.Reader, maxEvents int) ([]RegistryEvent, error) {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('['):
+		return decodeEventArray(dec, maxEvents)
+	case json.Delim('{'):
+	default:
+		return nil, fmt.Errorf("expected object or array, got %v", tok)
+	}
+
+	// An object is either an envelope or a single event. Stream the events
+	// array if there is one and keep the other fields in case there isn't.
+	var events []RegistryEvent
+	var envelope bool
+	fields := make(map[string]json.RawMessage)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := tok.(string)
+		if key == "events" {
+			envelope = true
+			if events, err = decodeEventList(dec, maxEvents); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		if !envelope {
+			fields[key] = v
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	if envelope || len(fields) == 0 {
+		return events, nil
+	}
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var event RegistryEvent
+	if err := json.Unmarshal(raw, &event); err != nil {
+		return nil, err
+	}
+	return []RegistryEvent{event}, nil
+}
+
+// decodeEventList decodes the value of an envelope's "events" field, which
+// may be null.
+func decodeEventList(dec *json.Decoder, maxEvents int) ([]RegistryEvent, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('['):
+		return decodeEventArray(dec, maxEvents)
+	case nil:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("expected events array, got %v", tok)
+	}
+}
+
+// decodeEventArray decodes array elements after the opening bracket has
+// been consumed, up to and including the closing bracket.
+func decodeEventArray(dec *json.Decoder, maxEvents int) ([]RegistryEvent, error) {
+	var events []RegistryEvent
+	for dec.More() {
+		if maxEvents > 0 && len(events) >= maxEvents {
+			return nil, fmt.Errorf("%w: limit is %d", ErrTooManyEvents, maxEvents)
+		}
+		var event RegistryEvent
+		if err := dec.Decode(&event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,82 @@
+package hooks
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeEvents(t *testing.T) {
+	push := `{"action":"push","target":{"repository":"app","tag":"1h"}}`
+	tests := []struct {
+		name     string
+		body     string
+		wantRepo []string
+		wantErr  bool
+	}{
+		{"envelope", `{"events":[` + push + `,` + push + `]}`, []string{"app", "app"}, false},
+		{"envelope with extra fields", `{"id":"x","events":[` + push + `],"more":1}`, []string{"app"}, false},
+		{"empty envelope", `{}`, nil, false},
+		{"null events", `{"events":null}`, nil, false},
+		{"bare array", `[` + push + `]`, []string{"app"}, false},
+		{"single event", push, []string{"app"}, false},
+		{"not json", `not json`, nil, true},
+		{"scalar", `42`, nil, true},
+		{"events not an array", `{"events":{}}`, nil, true},
+		{"truncated", `{"events":[` + push, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := decodeEvents(strings.NewReader(tt.body), 10)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeEvents() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(events) != len(tt.wantRepo) {
+				t.Fatalf("got %d events, want %d", len(events), len(tt.wantRepo))
+			}
+			for i, e := range events {
+				if e.Target.Repository != tt.wantRepo[i] {
+					t.Errorf("event %d repository = %q, want %q", i, e.Target.Repository, tt.wantRepo[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeEvents_MaxEvents(t *testing.T) {
+	var parts []string
+	for range 5 {
+		parts = append(parts, `{"action":"push"}`)
+	}
+	body := fmt.Sprintf(`{"events":[%s]}`, strings.Join(parts, ","))
+
+	if _, err := decodeEvents(strings.NewReader(body), 4); !errors.Is(err, ErrTooManyEvents) {
+		t.Fatalf("expected ErrTooManyEvents, got %v", err)
+	}
+	if events, err := decodeEvents(strings.NewReader(body), 5); err != nil || len(events) != 5 {
+		t.Fatalf("expected 5 events, got %d, %v", len(events), err)
+	}
+	if events, err := decodeEvents(strings.NewReader(body), 0); err != nil || len(events) != 5 {
+		t.Fatalf("expected no limit with 0, got %d, %v", len(events), err)
+	}
+}
+
+func TestHandler_TooManyEvents(t *testing.T) {
+	h := NewHandler(nil, nil, "secret", 0, 0, nil, slog.Default(), WithMaxEvents(1))
+	body := `[{"action":"push"},{"action":"push"}]`
+	req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", strings.NewReader(body))
+	req.Header.Set("Authorization", "Token secret")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", rr.Code)
+	}
+	if code := errorCode(t, rr); code != codeTooManyEvents {
+		t.Errorf("expected code %q, got %q", codeTooManyEvents, code)
+	}
+}
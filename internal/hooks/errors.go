@@ -0,0 +1,68 @@
+package hooks
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Sentinel errors classifying webhook failures. Wrap them with %w so
+// writeError can pick a status code the registry retries appropriately:
+// 4xx for rejections that will never succeed, 5xx for transient failures.
+var (
+	ErrRegistryUnavailable = errors.New("registry unavailable")
+	ErrStoreUnavailable    = errors.New("store unavailable")
+	ErrImmutableTag        = errors.New("immutable tag overwrite rejected")
+	ErrInvalidTTL          = errors.New("invalid ttl")
+)
+
+// Error codes returned in JSON error bodies.
+const (
+	codeBadRequest          = "bad_request"
+	codeUnauthorized        = "unauthorized"
+	codeMethodNotAllowed    = "method_not_allowed"
+	codeRegistryUnavailable = "registry_unavailable"
+	codeStoreUnavailable    = "store_unavailable"
+	codeImmutableTag        = "immutable_tag"
+	codeInvalidTTL          = "invalid_ttl"
+	codeTooManyEvents       = "too_many_events"
+	codeOverloaded          = "overloaded"
+	codeInternal            = "internal"
+)
+
+// outcomeOK labels successful requests and events in metrics; failures are
+// labelled with their error code.
+const outcomeOK = "ok"
+
+// errorBody is the JSON body written for failed webhook requests.
+type errorBody struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// classify maps an error to its HTTP status and error code.
+func classify(err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrImmutableTag):
+		return http.StatusConflict, codeImmutableTag
+	case errors.Is(err, ErrInvalidTTL):
+		return http.StatusUnprocessableEntity, codeInvalidTTL
+	case errors.Is(err, ErrRegistryUnavailable):
+		return http.StatusBadGateway, codeRegistryUnavailable
+	case errors.Is(err, ErrStoreUnavailable):
+		return http.StatusServiceUnavailable, codeStoreUnavailable
+	default:
+		return http.StatusInternalServerError, codeInternal
+	}
+}
+
+// writeError writes a JSON error body with the given status and code.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorBody{Error: errorDetail{Code: code, Message: message}})
+}
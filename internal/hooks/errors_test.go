@@ -0,0 +1,149 @@
+package hooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/journal"
+)
+
+// errorCode decodes the error code from a JSON error response.
+func errorCode(t *testing.T, rr *httptest.ResponseRecorder) string {
+	t.Helper()
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected JSON error body, got Content-Type %q", ct)
+	}
+	var body errorBody
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding error body: %v", err)
+	}
+	return body.Error.Code
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{fmt.Errorf("%w: tag prod", ErrImmutableTag), http.StatusConflict, codeImmutableTag},
+		{fmt.Errorf("%w: 0s", ErrInvalidTTL), http.StatusUnprocessableEntity, codeInvalidTTL},
+		{fmt.Errorf("%w: timeout", ErrRegistryUnavailable), http.StatusBadGateway, codeRegistryUnavailable},
+		{fmt.Errorf("%w: refused", ErrStoreUnavailable), http.StatusServiceUnavailable, codeStoreUnavailable},
+		{errors.New("boom"), http.StatusInternalServerError, codeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.wantCode, func(t *testing.T) {
+			status, code := classify(tt.err)
+			if status != tt.wantStatus || code != tt.wantCode {
+				t.Errorf("classify(%v) = %d, %q; want %d, %q", tt.err, status, code, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestHandler_StoreFailure(t *testing.T) {
+	store := newMockStore()
+	store.trackErr = errors.New("connection refused")
+	handler := NewHandler(store, &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default())
+
+	body, _ := json.Marshal(EventEnvelope{Events: []RegistryEvent{
+		{Action: testPush, Target: EventTarget{Repository: testApp, Tag: "1h"}},
+	}})
+	req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Token tok")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 on store failure, got %d", rr.Code)
+	}
+	if code := errorCode(t, rr); code != codeStoreUnavailable {
+		t.Fatalf("expected error code %q, got %q", codeStoreUnavailable, code)
+	}
+}
+
+func TestHandler_UnauthorizedBody(t *testing.T) {
+	handler := NewHandler(nil, nil, "tok", 0, 0, nil, slog.Default())
+	req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader([]byte("{}")))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if code := errorCode(t, rr); code != codeUnauthorized {
+		t.Fatalf("expected error code %q, got %q", codeUnauthorized, code)
+	}
+}
+
+func TestHandler_StoreFailure_FailOpen(t *testing.T) {
+	j, err := journal.Open(filepath.Join(t.TempDir(), "journal.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newMockStore()
+	store.trackErr = errors.New("connection refused")
+	handler := NewHandler(store, &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default(),
+		WithFailOpen(j),
+	)
+
+	body, _ := json.Marshal(EventEnvelope{Events: []RegistryEvent{
+		{Action: testPush, Target: EventTarget{Repository: testApp, Tag: "1h"}},
+	}})
+	req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Token tok")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 in fail-open mode, got %d", rr.Code)
+	}
+	pending, _ := j.Pending()
+	if len(pending) != 1 || pending[0].Image != testAppTTL {
+		t.Fatalf("expected %s to be journaled, got %+v", testAppTTL, pending)
+	}
+}
+
+func TestHandler_WriteAhead(t *testing.T) {
+	j, err := journal.Open(filepath.Join(t.TempDir(), "journal.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newMockStore()
+	handler := NewHandler(store, &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default(),
+		WithWriteAhead(j),
+	)
+
+	push := func() int {
+		body, _ := json.Marshal(EventEnvelope{Events: []RegistryEvent{
+			{Action: testPush, Target: EventTarget{Repository: testApp, Tag: "1h"}},
+		}})
+		req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Token tok")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := push(); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if pending, _ := j.Pending(); len(pending) != 0 {
+		t.Fatalf("expected completed entry to leave no pending entries, got %+v", pending)
+	}
+
+	// Fail-closed: the registry retries, so the entry must not be replayed.
+	store.trackErr = errors.New("connection refused")
+	if code := push(); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", code)
+	}
+	if pending, _ := j.Pending(); len(pending) != 0 {
+		t.Fatalf("expected rejected entry to be dropped, got %+v", pending)
+	}
+}
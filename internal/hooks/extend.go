@@ -0,0 +1,102 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+// DefaultExtendSeparator separates the tag to extend from the extension
+// duration in a marker tag such as "1h.extend-2h".
+const DefaultExtendSeparator = ".extend-"
+
+// extendMarkerTTL is how long a marker tag the registry refuses to delete is
+// tracked before the reaper removes it.
+const extendMarkerTTL = 5 * time.Minute
+
+// parseExtendTag splits an extension marker tag into the tag it extends and
+// the extension duration. ok is false for ordinary tags.
+func (h *Handler) parseExtendTag(marker string) (tag string, d time.Duration, ok bool) {
+	if h.extendSeparator == "" {
+		return "", 0, false
+	}
+	i := strings.LastIndex(marker, h.extendSeparator)
+	if i <= 0 {
+		return "", 0, false
+	}
+	d = ParseTTL(marker[i+len(h.extendSeparator):])
+	if d <= 0 {
+		return "", 0, false
+	}
+	return marker[:i], d, true
+}
+
+// handleExtend pushes the expiry of repo:tag back by d, capped at maxTTL from
+// now, and deletes the marker tag. The marker is deleted even when repo:tag
+// is not tracked so it does not linger untracked in the registry; if the
+// registry refuses to delete tags, the marker is tracked with a short TTL for
+// the reaper to remove instead. It returns resultExtended, or resultIgnored
+// if repo:tag is not tracked.
+func (h *Handler) handleExtend(ctx context.Context, repo, tag, marker string, d time.Duration) (string, error) {
+	imageWithTag := repo + ":" + tag
+	result := resultIgnored
+
+	if current, err := h.redis.GetExpiry(ctx, imageWithTag); err != nil {
+		h.logger.Warn("extension target not tracked, ignoring", "image", imageWithTag, "marker", marker)
+	} else {
+		now := time.Now()
+		expiresAt := time.UnixMilli(max(current, now.UnixMilli())).Add(d)
+		if limit := now.Add(h.ttls(repo, "").maxTTL); expiresAt.After(limit) {
+			expiresAt = limit
+		}
+		ok, err := h.redis.SetExpiry(ctx, imageWithTag, expiresAt)
+		if err != nil {
+			return "", fmt.Errorf("%w: extending %s: %w", ErrStoreUnavailable, imageWithTag, err)
+		}
+		if ok {
+			result = resultExtended
+			h.logger.Info("extended image expiry",
+				"image", imageWithTag,
+				"extension", d.String(),
+				"expires_at", expiresAt.Format(time.RFC3339),
+			)
+			metrics.ExpiryExtensions.Inc()
+		}
+	}
+
+	// The extension is already applied, so a failed delete must not make
+	// the registry retry the event and extend a second time.
+	err := h.registry.DeleteTag(ctx, repo, marker)
+	var statusErr *registry.StatusError
+	switch {
+	case errors.As(err, &statusErr) && statusErr.Permanent():
+		h.trackMarker(ctx, repo, marker)
+	case err != nil:
+		h.logger.Warn("failed to delete extension marker tag", "image", repo+":"+marker, "error", err)
+	}
+	return result, nil
+}
+
+// trackMarker tracks a marker tag the registry refused to delete with
+// extendMarkerTTL. The reaper then untags it if it shares its manifest with
+// the tracked image it extended, and deletes the manifest otherwise.
+func (h *Handler) trackMarker(ctx context.Context, repo, marker string) {
+	image := repo + ":" + marker
+	info, err := h.registry.GetImageManifestInfo(ctx, repo, marker)
+	if err == nil {
+		err = h.redis.TrackImage(ctx, image, time.Now().Add(extendMarkerTTL), info.SizeBytes, info.Digest,
+			redisclient.ImageMeta{})
+	}
+	if err != nil {
+		h.logger.Warn("failed to track undeletable extension marker tag", "image", image, "error", err)
+		return
+	}
+	h.logger.Info("registry refused to delete extension marker tag, tracking it for the reaper",
+		"image", image, "ttl", extendMarkerTTL.String())
+}
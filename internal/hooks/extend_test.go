@@ -0,0 +1,151 @@
+package hooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+func TestParseExtendTag(t *testing.T) {
+	h := NewHandler(nil, nil, "tok", time.Hour, 24*time.Hour, nil, slog.Default(),
+		WithExtendTags(DefaultExtendSeparator))
+
+	tests := []struct {
+		marker  string
+		wantTag string
+		wantD   time.Duration
+		wantOK  bool
+	}{
+		{marker: "1h.extend-2h", wantTag: "1h", wantD: 2 * time.Hour, wantOK: true},
+		{marker: "v1.2.extend-1d", wantTag: "v1.2", wantD: 24 * time.Hour, wantOK: true},
+		{marker: "a.extend-1h.extend-30m", wantTag: "a.extend-1h", wantD: 30 * time.Minute, wantOK: true},
+		{marker: "1h"},
+		{marker: ".extend-2h"},
+		{marker: "1h.extend-soon"},
+		{marker: "1h.extend-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.marker, func(t *testing.T) {
+			tag, d, ok := h.parseExtendTag(tt.marker)
+			if tag != tt.wantTag || d != tt.wantD || ok != tt.wantOK {
+				t.Errorf("parseExtendTag(%q) = %q, %v, %v; want %q, %v, %v",
+					tt.marker, tag, d, ok, tt.wantTag, tt.wantD, tt.wantOK)
+			}
+		})
+	}
+
+	if _, _, ok := NewHandler(nil, nil, "tok", 0, 0, nil, slog.Default()).parseExtendTag("1h.extend-2h"); ok {
+		t.Error("extension tags should be disabled without WithExtendTags")
+	}
+}
+
+func TestHandler_ExtendTag(t *testing.T) {
+	tests := []struct {
+		name        string
+		tracked     bool
+		remaining   time.Duration
+		marker      string
+		wantExpires time.Duration
+	}{
+		{name: "extends remaining ttl", tracked: true, remaining: time.Hour, marker: "1h.extend-2h",
+			wantExpires: 3 * time.Hour},
+		{name: "extends past expiry from now", tracked: true, remaining: -time.Hour, marker: "1h.extend-2h",
+			wantExpires: 2 * time.Hour},
+		{name: "caps at max ttl", tracked: true, remaining: 20 * time.Hour, marker: "1h.extend-1d",
+			wantExpires: 24 * time.Hour},
+		{name: "untracked target", marker: "1h.extend-2h"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMockStore()
+			if tt.tracked {
+				store.images[testAppTTL] = time.Now().Add(tt.remaining)
+				store.digests[testAppTTL] = "sha256:abc"
+			}
+			reg := &mockRegistry{}
+			handler := NewHandler(store, reg, "tok", time.Hour, 24*time.Hour, nil, slog.Default(),
+				WithExtendTags(DefaultExtendSeparator))
+
+			body, _ := json.Marshal(EventEnvelope{Events: []RegistryEvent{
+				{Action: testPush, Target: EventTarget{Repository: testApp, Tag: tt.marker}},
+			}})
+			req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader(body))
+			req.Header.Set("Authorization", "Token tok")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", rr.Code)
+			}
+			if _, ok := store.images[testApp+":"+tt.marker]; ok {
+				t.Error("marker tag should not be tracked")
+			}
+			if want := []string{testApp + ":" + tt.marker}; !slices.Equal(reg.deletedTags, want) {
+				t.Errorf("deleted tags = %v, want %v", reg.deletedTags, want)
+			}
+			if !tt.tracked {
+				if len(store.images) != 0 {
+					t.Errorf("tracked images = %v, want none", store.images)
+				}
+				return
+			}
+			got := time.Until(store.images[testAppTTL])
+			if got < tt.wantExpires-time.Minute || got > tt.wantExpires {
+				t.Errorf("remaining ttl = %v, want about %v", got, tt.wantExpires)
+			}
+			if store.digests[testAppTTL] != "sha256:abc" {
+				t.Error("extension should keep the image's metadata")
+			}
+		})
+	}
+}
+
+func TestHandler_ExtendTagUndeletableMarker(t *testing.T) {
+	const marker = "1h.extend-2h"
+	tests := []struct {
+		name        string
+		deleteErr   error
+		wantTracked bool
+	}{
+		{name: "tags not deletable", deleteErr: &registry.StatusError{Op: "DELETE", StatusCode: 405},
+			wantTracked: true},
+		{name: "transient failure", deleteErr: &registry.StatusError{Op: "DELETE", StatusCode: 503}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMockStore()
+			store.images[testAppTTL] = time.Now().Add(time.Hour)
+			store.digests[testAppTTL] = "sha256:abc"
+			reg := &mockRegistry{
+				digests:   map[string]string{testApp + ":" + marker: "sha256:abc"},
+				deleteErr: tt.deleteErr,
+			}
+			handler := NewHandler(store, reg, "tok", time.Hour, 24*time.Hour, nil, slog.Default(),
+				WithExtendTags(DefaultExtendSeparator))
+
+			if _, err := handler.handleExtend(t.Context(), testApp, "1h", marker, 2*time.Hour); err != nil {
+				t.Fatal(err)
+			}
+			expiresAt, tracked := store.images[testApp+":"+marker]
+			if tracked != tt.wantTracked {
+				t.Fatalf("marker tracked = %v, want %v", tracked, tt.wantTracked)
+			}
+			if !tracked {
+				return
+			}
+			if got := time.Until(expiresAt); got <= 0 || got > extendMarkerTTL {
+				t.Errorf("marker expires in %v, want within %v", got, extendMarkerTTL)
+			}
+			if store.digests[testApp+":"+marker] != "sha256:abc" {
+				t.Error("marker should be tracked with its manifest digest")
+			}
+		})
+	}
+}
@@ -3,30 +3,73 @@ package hooks
 import (
 	"context"
 	"crypto/subtle"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/tamcore/ephemeron/internal/journal"
 	"github.com/tamcore/ephemeron/internal/metrics"
+	"github.com/tamcore/ephemeron/internal/policy"
 	redisclient "github.com/tamcore/ephemeron/internal/redis"
 	"github.com/tamcore/ephemeron/internal/registry"
 )
 
-const actionPush = "push"
+const (
+	actionPush   = "push"
+	actionDelete = "delete"
+
+	// sourceHeader names the registry instance sending a webhook that
+	// authenticates with the shared hook token.
+	sourceHeader  = "X-Registry-Source"
+	maxSourceLen  = 64
+	unknownSource = "unknown"
+)
 
 // RegistryEvent represents a single event from the Docker Registry webhook.
 type RegistryEvent struct {
-	Action string      `json:"action"`
-	Target EventTarget `json:"target"`
+	Action  string       `json:"action"`
+	Target  EventTarget  `json:"target"`
+	Actor   EventActor   `json:"actor"`
+	Request EventRequest `json:"request"`
+	// Timestamp is when the registry says the event happened, in RFC 3339.
+	Timestamp string `json:"timestamp"`
+}
+
+// EventActor identifies the authenticated user that triggered an event.
+type EventActor struct {
+	Name string `json:"name"`
 }
 
-// EventTarget contains the repository and tag from a registry event.
+// EventRequest describes the HTTP request that triggered an event.
+type EventRequest struct {
+	Addr      string `json:"addr"`
+	UserAgent string `json:"useragent"`
+}
+
+// meta returns the pusher metadata stored alongside a tracked image.
+func (e RegistryEvent) meta() redisclient.ImageMeta {
+	return redisclient.ImageMeta{
+		Actor:      e.Actor.Name,
+		SourceAddr: e.Request.Addr,
+		UserAgent:  e.Request.UserAgent,
+	}
+}
+
+// EventTarget contains the repository, tag and digest from a registry event.
+// Manifest deletions carry a digest and, on newer registries, the tag. Blob
+// mounts name the repository the blob was mounted from.
 type EventTarget struct {
-	Repository string `json:"repository"`
-	Tag        string `json:"tag"`
+	Repository     string `json:"repository"`
+	Tag            string `json:"tag"`
+	Digest         string `json:"digest"`
+	MediaType      string `json:"mediaType"`
+	Size           int64  `json:"size"`
+	FromRepository string `json:"fromRepository"`
 }
 
 // EventEnvelope is the top-level structure sent by the Docker Registry.
@@ -38,17 +81,224 @@ type EventEnvelope struct {
 type registryClient interface {
 	GetImageSize(ctx context.Context, repo, tag string) (int64, error)
 	GetImageManifestInfo(ctx context.Context, repo, tag string) (*registry.ManifestInfo, error)
+	DeleteTag(ctx context.Context, repo, tag string) error
+}
+
+// policyChecker decides whether a pushed image may stay and for how long.
+type policyChecker interface {
+	Check(ctx context.Context, req policy.Request) policy.Decision
+}
+
+// projectProvisioner creates the registry project of a pushed repository.
+type projectProvisioner interface {
+	EnsureProject(ctx context.Context, repo string) error
+}
+
+// ruleSet supplies retention rules managed at runtime.
+type ruleSet interface {
+	ImmutableTag(tag string) bool
+	RepoPolicy(repo string) (defaultTTL, maxTTL time.Duration, ok bool)
 }
 
 // Handler handles incoming registry webhook events.
 type Handler struct {
 	redis                redisclient.Store
 	registry             registryClient
-	hookToken            string
+	hookToken            func() string
 	defaultTTL           time.Duration
 	maxTTL               time.Duration
 	logger               *slog.Logger
 	immutableTagPatterns []string
+	artifactTTLs         map[string]time.Duration
+	journal              *journal.Journal
+	failOpen             bool
+	writeAhead           bool
+	maxEvents            int
+	extendSeparator      string
+	backpressure         *backpressure
+	policy               policyChecker
+	rules                ruleSet
+	sources              map[string]string
+	sourceNames          []string
+	tracer               *Tracer
+	checkTTLTags         bool
+	rejectTTLTags        bool
+	nonTTLTags           func(repo string) string
+	projects             projectProvisioner
+	eventFailures        string
+	skewTolerance        time.Duration
+	skewWarn             time.Duration
+	cacheRepos           []string
+	cacheTTL             time.Duration
+}
+
+// HandlerOption configures a Handler.
+type HandlerOption func(*Handler)
+
+// WithArtifactTTLs sets per-artifact-type default TTLs (keyed by
+// registry.ArtifactImage, registry.ArtifactHelm, ...) applied when a tag has
+// no parseable duration.
+func WithArtifactTTLs(ttls map[string]time.Duration) HandlerOption {
+	return func(h *Handler) {
+		h.artifactTTLs = ttls
+	}
+}
+
+// WithFailOpen accepts push events while the store is unavailable, writing
+// them to j for later replay instead of failing the webhook.
+func WithFailOpen(j *journal.Journal) HandlerOption {
+	return func(h *Handler) {
+		h.journal = j
+		h.failOpen = true
+	}
+}
+
+// WithWriteAhead journals every push event to j before writing it to the
+// store, so an event acknowledged to the registry survives a crash before
+// the store write completes.
+func WithWriteAhead(j *journal.Journal) HandlerOption {
+	return func(h *Handler) {
+		h.journal = j
+		h.writeAhead = true
+	}
+}
+
+// WithMaxEvents limits how many events a single request may carry. Zero
+// removes the limit; the default is DefaultMaxEvents.
+func WithMaxEvents(n int) HandlerOption {
+	return func(h *Handler) {
+		h.maxEvents = n
+	}
+}
+
+// WithEventFailures sets what a request does when one of its events fails,
+// one of EventFailureModes. The default is EventFailuresAbort.
+func WithEventFailures(mode string) HandlerOption {
+	return func(h *Handler) {
+		h.eventFailures = mode
+	}
+}
+
+// WithExtendTags treats a push of "<tag><separator><duration>", e.g.
+// "1h.extend-2h", as a request to extend the tracked expiry of repo:<tag> by
+// duration. The marker tag is deleted afterwards. An empty separator
+// disables extension tags.
+func WithExtendTags(separator string) HandlerOption {
+	return func(h *Handler) {
+		h.extendSeparator = separator
+	}
+}
+
+// WithTTLTagValidation flags pushes whose tag is meant as a TTL but does
+// not parse, like "2hours", instead of silently giving them the default
+// TTL. With reject, such pushes fail with 422 Unprocessable Entity and an
+// error naming the tag to use, which shows in the registry's notification
+// logs; without, they are logged and tracked with the default TTL.
+func WithTTLTagValidation(reject bool) HandlerOption {
+	return func(h *Handler) {
+		h.checkTTLTags = true
+		h.rejectTTLTags = reject
+	}
+}
+
+// WithNonTTLTags decides what happens to pushes whose tag is not a TTL by
+// the action returned for their repository: NonTTLTrack tracks them with
+// the default TTL, as without this option, NonTTLIgnore leaves them
+// untracked so they are never reaped, and NonTTLReject fails them with 422
+// Unprocessable Entity.
+func WithNonTTLTags(action func(repo string) string) HandlerOption {
+	return func(h *Handler) {
+		h.nonTTLTags = action
+	}
+}
+
+// WithProjectProvisioning makes sure the project of every pushed repository
+// exists through p before tracking the push. Failures are logged and do not
+// fail the push.
+func WithProjectProvisioning(p projectProvisioner) HandlerOption {
+	return func(h *Handler) {
+		h.projects = p
+	}
+}
+
+// WithPolicy consults p on every push. Denied images are tracked as already
+// expired so the next reap cycle deletes them, and a TTL returned by the
+// policy replaces the one parsed from the tag, still capped at the max TTL.
+func WithPolicy(p policyChecker) HandlerOption {
+	return func(h *Handler) {
+		h.policy = p
+	}
+}
+
+// WithRules takes immutable tag patterns and per-repository TTLs from rules
+// instead of only the patterns passed to NewHandler. rules is expected to
+// include those static patterns.
+func WithRules(rules ruleSet) HandlerOption {
+	return func(h *Handler) {
+		h.rules = rules
+	}
+}
+
+// WithSourceTokens accepts the per-registry tokens in sources (token to
+// source name) besides the shared hook token. Events authenticated by one
+// are labelled with its source, whatever their X-Registry-Source header says.
+func WithSourceTokens(sources map[string]string) HandlerOption {
+	return func(h *Handler) {
+		h.sources = sources
+	}
+}
+
+// WithSourceNames lists the names, besides those of WithSourceTokens, that
+// events sent with the shared hook token may claim in their
+// X-Registry-Source header. Any other name is labelled unknown, so callers
+// cannot create metric series at will.
+func WithSourceNames(names []string) HandlerOption {
+	return func(h *Handler) {
+		h.sourceNames = names
+	}
+}
+
+// WithHookTokenFunc makes the handler check webhooks against the token
+// returned by token, read on every request, instead of the fixed hook
+// token, so that a rotated secret takes effect without a restart.
+func WithHookTokenFunc(token func() string) HandlerOption {
+	return func(h *Handler) {
+		h.hookToken = token
+	}
+}
+
+// ParseSourceTokens builds the token-to-source map of WithSourceTokens from
+// "name=token" entries.
+func ParseSourceTokens(entries []string) (map[string]string, error) {
+	sources := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		name, token, ok := strings.Cut(entry, "=")
+		name, token = strings.TrimSpace(name), strings.TrimSpace(token)
+		if !ok || name == "" || token == "" {
+			return nil, fmt.Errorf("invalid entry %q (want name=token)", entry)
+		}
+		if sanitizeSource(name) != name {
+			return nil, fmt.Errorf("invalid source name %q", name)
+		}
+		if other, dup := sources[token]; dup {
+			return nil, fmt.Errorf("%s and %s share a token", other, name)
+		}
+		sources[token] = name
+	}
+	return sources, nil
+}
+
+// ParseSourceNames checks the names of WithSourceNames, trimming blanks.
+func ParseSourceNames(entries []string) ([]string, error) {
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := strings.TrimSpace(entry)
+		if name == "" || sanitizeSource(name) != name {
+			return nil, fmt.Errorf("invalid source name %q", entry)
+		}
+		names = append(names, name)
+	}
+	return names, nil
 }
 
 // NewHandler creates a new webhook handler.
@@ -59,78 +309,262 @@ func NewHandler(
 	defaultTTL, maxTTL time.Duration,
 	immutableTagPatterns []string,
 	logger *slog.Logger,
+	opts ...HandlerOption,
 ) *Handler {
-	return &Handler{
+	h := &Handler{
 		redis:                redis,
 		registry:             registry,
-		hookToken:            hookToken,
+		hookToken:            func() string { return hookToken },
 		defaultTTL:           defaultTTL,
 		maxTTL:               maxTTL,
 		immutableTagPatterns: immutableTagPatterns,
 		logger:               logger,
+		maxEvents:            DefaultMaxEvents,
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
+	return h
 }
 
 // ServeHTTP handles POST /v1/hook/registry-event.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 
-	auth := r.Header.Get("Authorization")
-	expected := "Token " + h.hookToken
-	if subtle.ConstantTimeCompare([]byte(auth), []byte(expected)) != 1 {
+	source, ok := h.authenticate(r)
+	if !ok {
 		h.logger.Warn("unauthorized webhook request")
-		w.WriteHeader(http.StatusUnauthorized)
-		_, _ = w.Write([]byte("{}"))
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "invalid or missing token")
 		return
 	}
 
-	var envelope EventEnvelope
-	if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+	if h.backpressure != nil {
+		release, reason := h.backpressure.admit()
+		if release == nil {
+			h.logger.Warn("rejecting webhook under load", "reason", reason)
+			h.backpressure.reject(w, reason)
+			return
+		}
+		defer release()
+	}
+
+	outcome := outcomeOK
+	body := &countingReader{r: r.Body}
+	defer func() { metrics.WebhookRequestBytes.WithLabelValues(outcome).Observe(float64(body.n)) }()
+
+	decodeStart := time.Now()
+	events, err := decodeEvents(body, h.maxEvents)
+	switch {
+	case errors.Is(err, ErrTooManyEvents):
+		outcome = codeTooManyEvents
+	case err != nil:
+		outcome = codeBadRequest
+	}
+	metrics.WebhookDecodeDuration.WithLabelValues(outcome).Observe(time.Since(decodeStart).Seconds())
+	if errors.Is(err, ErrTooManyEvents) {
+		h.logger.Warn("rejecting oversized webhook batch", "max_events", h.maxEvents)
+		writeError(w, http.StatusRequestEntityTooLarge, codeTooManyEvents, err.Error())
+		return
+	}
+	if err != nil {
 		h.logger.Error("failed to decode webhook body", "error", err)
-		http.Error(w, "bad request", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeBadRequest, "invalid webhook body")
 		return
 	}
 
 	ctx := r.Context()
-	for _, event := range envelope.Events {
+	sum := newSummary(len(events))
+	for _, event := range events {
 		metrics.WebhookEventsTotal.WithLabelValues(event.Action).Inc()
+		metrics.WebhookSourceEventsTotal.WithLabelValues(sourceLabel(source), event.Action).Inc()
 
-		if event.Action != actionPush {
-			continue
+		result := eventResult{
+			Action:     event.Action,
+			Repository: event.Target.Repository,
+			Tag:        event.Target.Tag,
+			Digest:     event.Target.Digest,
 		}
-		if event.Target.Repository == "" || event.Target.Tag == "" {
+		var err error
+		eventStart := time.Now()
+		switch {
+		case event.Target.Repository == "":
+			result.Result = resultSkipped
+			sum.add(result)
+			continue
+		case (event.Action == actionPush || event.Action == actionMount) && event.Target.isBlob():
+			h.countBlob(event, source)
+			result.Result = resultCounted
+			sum.add(result)
+			continue
+		case event.Action == actionPush && event.Target.Tag != "":
+			if tag, d, ok := h.parseExtendTag(event.Target.Tag); ok {
+				result.Result, err = h.handleExtend(ctx, event.Target.Repository, tag, event.Target.Tag, d)
+				break
+			}
+			meta := event.meta()
+			meta.Source = source
+			pushedAt := h.pushedAt(event, source, eventStart)
+			result.Result, err = h.handlePush(ctx, event.Target.Repository, event.Target.Tag, meta, pushedAt, eventStart)
+		case event.Action == actionDelete:
+			result.Images, err = h.handleDelete(ctx, event.Target)
+			result.Result = resultIgnored
+			if len(result.Images) > 0 {
+				result.Result = resultUntracked
+			}
+		default:
+			result.Result = resultSkipped
+			sum.add(result)
 			continue
 		}
-		if err := h.handlePush(ctx, event.Target.Repository, event.Target.Tag); err != nil {
-			h.logger.Error("failed to handle push event",
+		eventOutcome := outcomeOK
+		if err != nil {
+			_, eventOutcome = classify(err)
+		}
+		metrics.WebhookEventDuration.WithLabelValues(event.Action, eventOutcome).Observe(time.Since(eventStart).Seconds())
+		if err != nil {
+			h.logger.Error("failed to handle "+event.Action+" event",
 				"image", event.Target.Repository,
 				"tag", event.Target.Tag,
+				"digest", event.Target.Digest,
+				"source", source,
 				"error", err,
 			)
-			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
-			return
+			status, code := classify(err)
+			if outcome == outcomeOK {
+				outcome = code
+			}
+			sum.fail(result, status, code, err)
+			if h.eventFailures == EventFailuresAbort || h.eventFailures == "" {
+				sum.write(w, r, sum.abort())
+				return
+			}
+			continue
+		}
+		sum.add(result)
+	}
+
+	sum.write(w, r, sum.status(h.eventFailures))
+}
+
+// authenticate checks the webhook token of r and returns the registry
+// instance that sent it: the name of a matching per-source token, or else
+// the X-Registry-Source header of a request carrying the shared token if it
+// names a known source, see WithSourceNames.
+func (h *Handler) authenticate(r *http.Request) (string, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Token "); ok && token != "" {
+		for t, name := range h.sources {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+				return name, true
+			}
+		}
+	}
+	if !validToken(r, h.hookToken()) {
+		return "", false
+	}
+	source := r.Header.Get(sourceHeader)
+	if !h.knownSource(source) {
+		return "", true
+	}
+	return source, true
+}
+
+// knownSource reports whether source is the name of a per-source token or
+// of WithSourceNames.
+func (h *Handler) knownSource(source string) bool {
+	if source == "" {
+		return false
+	}
+	for _, name := range h.sources {
+		if name == source {
+			return true
 		}
 	}
+	return slices.Contains(h.sourceNames, source)
+}
 
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte("{}"))
+// sanitizeSource limits a client-supplied source name to a short run of
+// letters, digits, '.', '_' and '-', so it is safe as a log field and
+// metric label. Anything else yields the empty source.
+func sanitizeSource(source string) string {
+	if len(source) > maxSourceLen {
+		return ""
+	}
+	for _, c := range source {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return ""
+		}
+	}
+	return source
+}
+
+// sourceLabel returns the metric label of source.
+func sourceLabel(source string) string {
+	if source == "" {
+		return unknownSource
+	}
+	return source
 }
 
-func (h *Handler) handlePush(ctx context.Context, repo, tag string) error {
+// validToken reports whether r carries "Authorization: Token <token>".
+func validToken(r *http.Request, token string) bool {
+	auth := r.Header.Get("Authorization")
+	expected := "Token " + token
+	return subtle.ConstantTimeCompare([]byte(auth), []byte(expected)) == 1
+}
+
+// handlePush tracks repo:tag and returns the result of the event:
+// resultTracked, resultIgnored for non-TTL tags ignored by configuration, or
+// resultJournaled when a failed store write was journaled for replay. The
+// expiry is anchored at pushedAt but never later than received plus the
+// maximum TTL.
+func (h *Handler) handlePush(
+	ctx context.Context,
+	repo, tag string,
+	meta redisclient.ImageMeta,
+	pushedAt, received time.Time,
+) (result string, err error) {
 	imageWithTag := fmt.Sprintf("%s:%s", repo, tag)
 
-	ttl := ClampTTL(ParseTTL(tag), h.defaultTTL, h.maxTTL)
-	expiresAt := time.Now().Add(ttl)
+	var trace *pushTrace
+	if h.tracer.Enabled() {
+		trace = &pushTrace{image: imageWithTag}
+		defer func() { trace.log(ctx, h.logger, err) }()
+	}
+
+	if h.projects != nil {
+		if err := h.projects.EnsureProject(ctx, repo); err != nil {
+			h.logger.Warn("failed to provision project", "image", imageWithTag, "error", err)
+		}
+	}
+
+	if err := h.checkTTLTag(repo, tag); err != nil {
+		return "", err
+	}
+	switch h.nonTTLAction(repo, tag) {
+	case NonTTLIgnore:
+		h.logger.Info("ignoring push of non-TTL tag", "image", imageWithTag)
+		metrics.NonTTLTagPushes.WithLabelValues(NonTTLIgnore).Inc()
+		return resultIgnored, nil
+	case NonTTLReject:
+		metrics.NonTTLTagPushes.WithLabelValues(NonTTLReject).Inc()
+		return "", fmt.Errorf("%w: tag %q is not a TTL, and repository %s only accepts TTL tags like \"2h\"",
+			ErrInvalidTTL, tag, repo)
+	}
 
 	// Fetch manifest info (digest + size) - best effort
 	var manifestInfo *registry.ManifestInfo
 	var sizeBytes int64
 	var digest string
+	artifactType := registry.ArtifactImage
 
-	manifestInfo, err := h.registry.GetImageManifestInfo(ctx, repo, tag)
+	fetchStart := time.Now()
+	manifestInfo, err = h.registry.GetImageManifestInfo(ctx, repo, tag)
+	metrics.ObserveWebhookStage(metrics.StageManifestFetch, fetchStart, err)
 	if err != nil {
 		h.logger.Warn("failed to fetch manifest info, tracking without digest",
 			"image", imageWithTag,
@@ -142,41 +576,263 @@ func (h *Handler) handlePush(ctx context.Context, repo, tag string) error {
 	} else {
 		sizeBytes = manifestInfo.SizeBytes
 		digest = manifestInfo.Digest
+		if manifestInfo.ArtifactType != "" {
+			artifactType = manifestInfo.ArtifactType
+		}
+		if manifestInfo.Unsupported {
+			h.logger.Info("unsupported manifest format, tracking estimated size",
+				"image", imageWithTag,
+				"media_type", manifestInfo.MediaType,
+			)
+		}
+	}
+
+	limits := h.ttls(repo, artifactType)
+	maxTTL := limits.maxTTL
+	parsed := ParseTTL(tag)
+	ttl := ClampTTL(parsed, limits.defaultTTL, maxTTL)
+	requested := max(parsed, 0)
+	var decision policy.Decision
+	if h.policy != nil {
+		decision = h.policy.Check(ctx, policy.Request{
+			Repository:   repo,
+			Tag:          tag,
+			Digest:       digest,
+			SizeBytes:    sizeBytes,
+			ArtifactType: artifactType,
+			Actor:        meta.Actor,
+			TTLSeconds:   int64(ttl.Seconds()),
+		})
+		switch {
+		case !decision.Allow:
+			h.logger.Warn("push denied by policy, expiring image",
+				"image", imageWithTag,
+				"reason", decision.Reason,
+				"fallback", decision.Fallback,
+			)
+			ttl = 0
+		case decision.TTL > 0:
+			requested = decision.TTL
+			ttl = min(decision.TTL, maxTTL)
+		}
+		if trace != nil {
+			trace.policy = &decision
+		}
+	}
+	if trace != nil {
+		trace.resolve(artifactType, digest, limits, parsed, ttl)
+		trace.immutable = immutableReason(h.isImmutableTag(tag), decision.Immutable)
 	}
 
 	// Detect tag overwrite (may block webhook in enforcement mode)
 	if digest != "" {
-		if err := h.detectOverwrite(ctx, imageWithTag, repo, tag, digest); err != nil {
+		immutable := h.isImmutableTag(tag) || decision.Immutable
+		if err := h.detectOverwrite(ctx, imageWithTag, repo, tag, digest, immutable); err != nil {
 			// Error means overwrite blocked (enforcement mode)
-			return err
+			return "", err
 		}
 	}
+	expiresAt := pushedAt.Add(ttl)
+	// An event stamped ahead of its receipt must not outlive the maximum.
+	if limit := received.Add(maxTTL); expiresAt.After(limit) {
+		expiresAt = limit
+	}
+	previous := h.superseded(ctx, repo, imageWithTag, digest)
 
 	sizeMB := float64(sizeBytes) / (1024 * 1024)
 
 	h.logger.Info("tracking image",
 		"image", imageWithTag,
+		"artifact_type", artifactType,
 		"ttl", ttl.String(),
 		"expires_at", expiresAt.Format(time.RFC3339),
 		"size_bytes", sizeBytes,
 		"size_mb", fmt.Sprintf("%.2f", sizeMB),
 		"digest", digest,
+		"actor", meta.Actor,
+		"source_addr", meta.SourceAddr,
+		"user_agent", meta.UserAgent,
+		"source", meta.Source,
 	)
 
-	if err := h.redis.TrackImage(ctx, imageWithTag, expiresAt, sizeBytes, digest); err != nil {
-		return err
+	entry := journal.Entry{
+		Image:      imageWithTag,
+		ExpiresAt:  expiresAt,
+		SizeBytes:  sizeBytes,
+		Digest:     digest,
+		ReceivedAt: time.Now(),
+		Meta:       meta,
+	}
+	var walID uint64
+	if h.writeAhead {
+		if walID, err = h.journal.Append(entry); err != nil {
+			return "", fmt.Errorf("%w: journaling %s: %w", ErrStoreUnavailable, imageWithTag, err)
+		}
 	}
 
-	metrics.ImagesTracked.Inc()
-	metrics.TrackedBytesTotal.Add(float64(sizeBytes))
+	writeStart := time.Now()
+	err = h.redis.TrackImage(ctx, imageWithTag, expiresAt, sizeBytes, digest, meta)
+	metrics.ObserveWebhookStage(metrics.StageStoreWrite, writeStart, err)
+	if h.backpressure != nil {
+		h.backpressure.observeStore(time.Since(writeStart))
+	}
+	if err != nil {
+		return resultJournaled, h.handleTrackFailure(entry, walID, err)
+	}
+	h.supersede(ctx, imageWithTag, previous)
+	if h.writeAhead {
+		if err := h.journal.Complete(walID); err != nil {
+			h.logger.Warn("failed to complete journal entry", "image", imageWithTag, "error", err)
+		}
+	}
+	if decision.Protected {
+		if err := h.redis.SetProtected(ctx, imageWithTag, true); err != nil {
+			h.logger.Warn("failed to protect image", "image", imageWithTag, "error", err)
+		}
+	}
+	if decision.Priority != 0 {
+		if err := h.redis.SetPriority(ctx, imageWithTag, decision.Priority); err != nil {
+			h.logger.Warn("failed to set deletion priority", "image", imageWithTag, "error", err)
+		}
+	}
+	if err := h.redis.RecordPush(ctx, repo, sizeBytes, time.Now()); err != nil {
+		h.logger.Warn("failed to record push statistics", "repository", repo, "error", err)
+	}
+	if digest != "" {
+		if aliases, err := h.redis.Aliases(ctx, imageWithTag); err == nil && len(aliases) > 0 {
+			h.logger.Info("tag shares manifest with tracked images", "image", imageWithTag, "aliases", aliases)
+		}
+	}
+
+	metrics.ImagesTracked.WithLabelValues(artifactType).Inc()
 	metrics.ImageSizeBytes.Observe(float64(sizeBytes))
+	if h.policy == nil || decision.Allow {
+		observeTTL(repo, requested, ttl)
+	}
+
+	return resultTracked, nil
+}
+
+// nonTTLAction returns what happens to a push of repo:tag: NonTTLTrack
+// unless the tag is not a TTL and an action is configured for repo.
+func (h *Handler) nonTTLAction(repo, tag string) string {
+	if h.nonTTLTags == nil || ParseTTL(tag) > 0 {
+		return NonTTLTrack
+	}
+	if action := h.nonTTLTags(repo); action != "" {
+		return action
+	}
+	return NonTTLTrack
+}
 
+// checkTTLTag flags a push of repo:tag whose tag is a malformed TTL, and
+// returns an ErrInvalidTTL error if such pushes are rejected.
+func (h *Handler) checkTTLTag(repo, tag string) error {
+	if !h.checkTTLTags {
+		return nil
+	}
+	suggestion, malformed := MalformedTTL(tag)
+	if !malformed {
+		return nil
+	}
+	hint := `a positive duration such as "2h"`
+	if suggestion != "" {
+		hint = fmt.Sprintf("%q", suggestion)
+	}
+	msg := fmt.Sprintf("tag %q looks like a TTL but is not one; use %s (units w, d, h, m, s)", tag, hint)
+	if h.rejectTTLTags {
+		metrics.MalformedTTLTags.WithLabelValues(repo, "rejected").Inc()
+		return fmt.Errorf("%w: %s", ErrInvalidTTL, msg)
+	}
+	h.logger.Warn("push with malformed TTL tag gets the default TTL", "image", repo+":"+tag,
+		"suggestion", suggestion, "hint", msg)
+	metrics.MalformedTTLTags.WithLabelValues(repo, "flagged").Inc()
+	return nil
+}
+
+// observeTTL records the TTL a push of repo asked for, through its tag or
+// the push policy, and the TTL it was tracked with. requested is zero when
+// the push asked for none and got the default.
+func observeTTL(repo string, requested, ttl time.Duration) {
+	metrics.EffectiveTTL.Observe(ttl.Seconds())
+	if requested <= 0 {
+		return
+	}
+	metrics.RequestedTTL.Observe(requested.Seconds())
+	if requested > ttl {
+		metrics.TTLClamped.WithLabelValues(repo).Inc()
+	}
+}
+
+// handleDelete stops tracking images deleted from the registry outside
+// ephemeron, so the reaper does not later chase them. Tag deletions remove
+// that tag; manifest deletions remove every tracked tag of the repository
+// that points at the digest. It returns the images it stopped tracking.
+func (h *Handler) handleDelete(ctx context.Context, target EventTarget) ([]string, error) {
+	var images []string
+	switch {
+	case target.Tag != "":
+		images = []string{target.Repository + ":" + target.Tag}
+	case target.Digest != "":
+		tagged, err := h.redis.TaggedImages(ctx, target.Repository, target.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: listing tags of %s: %w", ErrStoreUnavailable, target.Digest, err)
+		}
+		images = tagged
+	default:
+		return nil, nil
+	}
+
+	var removed []string
+	for _, image := range images {
+		if _, err := h.redis.GetExpiry(ctx, image); err != nil {
+			continue // Not tracked.
+		}
+		if err := h.redis.RemoveImage(ctx, image); err != nil {
+			return removed, fmt.Errorf("%w: removing %s: %w", ErrStoreUnavailable, image, err)
+		}
+		removed = append(removed, image)
+		h.logger.Info("stopped tracking image deleted from registry", "image", image, "digest", target.Digest)
+		metrics.ExternalDeletes.Inc()
+		metrics.TrackedImagesGauge.Dec()
+	}
+	return removed, nil
+}
+
+// handleTrackFailure decides what happens to an event whose store write
+// failed. In fail-open mode the event is left in the journal for replay and
+// the webhook succeeds; otherwise the registry is told to retry and any
+// write-ahead entry is dropped.
+func (h *Handler) handleTrackFailure(entry journal.Entry, walID uint64, err error) error {
+	if !h.failOpen {
+		if h.writeAhead {
+			_ = h.journal.Complete(walID)
+		}
+		return fmt.Errorf("%w: tracking %s: %w", ErrStoreUnavailable, entry.Image, err)
+	}
+
+	id := walID
+	if !h.writeAhead {
+		var jerr error
+		if id, jerr = h.journal.Append(entry); jerr != nil {
+			return fmt.Errorf("%w: tracking %s: %w", ErrStoreUnavailable, entry.Image, errors.Join(err, jerr))
+		}
+	}
+	h.journal.Release(id)
+	h.logger.Warn("store unavailable, journaled event for replay", "image", entry.Image, "error", err)
+	metrics.JournaledEvents.Inc()
 	return nil
 }
 
 // detectOverwrite checks if tag push overwrites existing content with different digest.
 // Returns error if overwrite should be blocked (enforcement mode), nil otherwise.
-func (h *Handler) detectOverwrite(ctx context.Context, imageWithTag, repo, tag, newDigest string) error {
+// immutable enforces the rejection, either because the tag matches an
+// immutable pattern or because the push policy marked it immutable.
+func (h *Handler) detectOverwrite(
+	ctx context.Context,
+	imageWithTag, repo, tag, newDigest string,
+	immutable bool,
+) error {
 	existingDigest, err := h.redis.GetImageDigest(ctx, imageWithTag)
 	if err != nil {
 		h.logger.Warn("failed to check existing digest (non-critical)",
@@ -211,8 +867,8 @@ func (h *Handler) detectOverwrite(ctx context.Context, imageWithTag, repo, tag,
 		metrics.OverwrittenImageAge.Observe(ageSeconds)
 	}
 
-	// Check if tag matches immutable patterns (enforcement mode)
-	if h.isImmutableTag(tag) {
+	// Check if tag is immutable (enforcement mode)
+	if immutable {
 		h.logger.Error("immutable tag overwrite rejected",
 			"image", imageWithTag,
 			"tag", tag,
@@ -220,14 +876,113 @@ func (h *Handler) detectOverwrite(ctx context.Context, imageWithTag, repo, tag,
 			"new_digest", newDigest,
 		)
 		metrics.ImmutableTagViolations.WithLabelValues(repo, tag).Inc()
-		return fmt.Errorf("tag %s is immutable, overwrite rejected", tag)
+		return fmt.Errorf("%w: tag %s", ErrImmutableTag, tag)
 	}
 
 	return nil // Observability mode: log but allow
 }
 
+// ttlLimits are the default and maximum TTL of a push, with the origin of
+// each: originGlobal, originArtifactType, originCacheRepo or originRepoPolicy.
+type ttlLimits struct {
+	defaultTTL, maxTTL   time.Duration
+	defaultFrom, maxFrom string
+}
+
+// ttls returns the default and maximum TTL for a push to repo, taking the
+// artifact type, cache repositories and any repository policy into account.
+// A policy cannot raise the maximum above MAX_TTL, and the default never
+// exceeds the maximum.
+func (h *Handler) ttls(repo, artifactType string) ttlLimits {
+	l := ttlLimits{h.defaultTTL, h.maxTTL, originGlobal, originGlobal}
+	if d, ok := h.artifactTTLs[artifactType]; ok {
+		l.defaultTTL, l.defaultFrom = d, originArtifactType
+	}
+	if h.isCacheRepo(repo) {
+		l = ttlLimits{h.cacheTTL, h.cacheTTL, originCacheRepo, originCacheRepo}
+	}
+	if h.rules != nil {
+		if d, m, ok := h.rules.RepoPolicy(repo); ok {
+			if d > 0 {
+				l.defaultTTL, l.defaultFrom = d, originRepoPolicy
+			}
+			if m > 0 && m < h.maxTTL {
+				l.maxTTL, l.maxFrom = m, originRepoPolicy
+			}
+		}
+	}
+	l.defaultTTL = min(l.defaultTTL, l.maxTTL)
+	return l
+}
+
+// MaxTTL returns the longest TTL a push of artifactType to repo is tracked
+// with, for code outside the webhook that must keep to the same limit.
+func (h *Handler) MaxTTL(repo, artifactType string) time.Duration {
+	return h.ttls(repo, artifactType).maxTTL
+}
+
+// TTLStep is one step in resolving the TTL of a push, as reported by
+// ExplainTTL.
+type TTLStep struct {
+	Step   string `json:"step"`
+	Detail string `json:"detail"`
+}
+
+// ExplainTTL reports how the TTL of a push of repo:tag with artifactType is
+// resolved under the current configuration and rules, and the TTL it
+// resolves to. A push policy is not consulted, as checking it may have side
+// effects; a step notes when one could have replaced the TTL.
+func (h *Handler) ExplainTTL(repo, tag, artifactType string) ([]TTLStep, time.Duration) {
+	steps := []TTLStep{{Step: "global", Detail: fmt.Sprintf("DEFAULT_TTL %s, MAX_TTL %s", h.defaultTTL, h.maxTTL)}}
+	if d, ok := h.artifactTTLs[artifactType]; ok {
+		steps = append(steps, TTLStep{Step: "artifact_type", Detail: fmt.Sprintf("%s default TTL %s", artifactType, d)})
+	}
+	if h.isCacheRepo(repo) {
+		steps = append(steps, TTLStep{Step: "cache_repo", Detail: fmt.Sprintf("build cache, default and max TTL %s",
+			h.cacheTTL)})
+	}
+	if h.rules != nil {
+		if d, m, ok := h.rules.RepoPolicy(repo); ok {
+			detail := fmt.Sprintf("default TTL %s, max TTL %s (zero keeps the previous value)", d, m)
+			if m > h.maxTTL {
+				detail += fmt.Sprintf(", max TTL capped at MAX_TTL %s", h.maxTTL)
+			}
+			steps = append(steps, TTLStep{Step: "repo_policy", Detail: detail})
+		}
+	}
+	limits := h.ttls(repo, artifactType)
+	parsed := ParseTTL(tag)
+	ttl := ClampTTL(parsed, limits.defaultTTL, limits.maxTTL)
+	_, malformed := MalformedTTL(tag)
+	switch {
+	case h.nonTTLAction(repo, tag) == NonTTLIgnore:
+		steps = append(steps, TTLStep{Step: "tag", Detail: fmt.Sprintf("tag %q is not a TTL, "+
+			"pushes of such tags to %s are not tracked", tag, repo)})
+	case h.nonTTLAction(repo, tag) == NonTTLReject:
+		steps = append(steps, TTLStep{Step: "tag", Detail: fmt.Sprintf("tag %q is not a TTL, "+
+			"pushes of such tags to %s are rejected", tag, repo)})
+	case malformed:
+		steps = append(steps, TTLStep{Step: "tag", Detail: fmt.Sprintf("tag %q looks like a TTL but does not parse, "+
+			"default TTL %s", tag, ttl)})
+	case parsed <= 0:
+		steps = append(steps, TTLStep{Step: "tag", Detail: fmt.Sprintf("no duration in tag %q, default TTL %s", tag, ttl)})
+	case parsed > limits.maxTTL:
+		steps = append(steps, TTLStep{Step: "tag", Detail: fmt.Sprintf("tag %q capped at max TTL %s", tag, ttl)})
+	default:
+		steps = append(steps, TTLStep{Step: "tag", Detail: fmt.Sprintf("tag %q parsed as %s", tag, ttl)})
+	}
+	if h.policy != nil {
+		steps = append(steps, TTLStep{Step: "policy", Detail: "a push policy may have replaced this TTL, " +
+			"up to the max TTL, or denied the push"})
+	}
+	return steps, ttl
+}
+
 // isImmutableTag checks if tag matches any immutable patterns.
 func (h *Handler) isImmutableTag(tag string) bool {
+	if h.rules != nil {
+		return h.rules.ImmutableTag(tag)
+	}
 	for _, pattern := range h.immutableTagPatterns {
 		matched, err := filepath.Match(pattern, tag)
 		if err != nil {
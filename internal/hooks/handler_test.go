@@ -4,12 +4,23 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+	"github.com/tamcore/ephemeron/internal/policy"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
 	"github.com/tamcore/ephemeron/internal/registry"
 )
 
@@ -60,6 +71,30 @@ func TestHandler_Auth(t *testing.T) {
 	})
 }
 
+func TestHandler_HookTokenFunc(t *testing.T) {
+	token := "old-token"
+	handler := NewHandler(nil, nil, "", 0, 0, nil, slog.Default(),
+		WithHookTokenFunc(func() string { return token }))
+
+	send := func(auth string) int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader([]byte("{}")))
+		req.Header.Set("Authorization", auth)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if code := send("Token old-token"); code != http.StatusOK {
+		t.Errorf("old token before rotation: expected 200, got %d", code)
+	}
+	token = "new-token"
+	if code := send("Token old-token"); code != http.StatusUnauthorized {
+		t.Errorf("old token after rotation: expected 401, got %d", code)
+	}
+	if code := send("Token new-token"); code != http.StatusOK {
+		t.Errorf("new token after rotation: expected 200, got %d", code)
+	}
+}
+
 func TestHandler_EventParsing(t *testing.T) {
 	// We can't test Redis interaction without a real Redis,
 	// but we can test that the handler parses events correctly
@@ -125,14 +160,28 @@ type mockStore struct {
 	sizes   map[string]int64
 	digests map[string]string
 	created map[string]int64
+	metas   map[string]redisclient.ImageMeta
+	// protected records images marked with SetProtected.
+	protected map[string]bool
+	// priorities records priorities set with SetPriority.
+	priorities map[string]int
+	// trackErr, if set, is returned by TrackImage.
+	trackErr error
+	// pushes records RecordPush calls as "repo:size".
+	pushes []string
+	// superseded records AddSuperseded calls as "repo@digest".
+	superseded []string
 }
 
 func newMockStore() *mockStore {
 	return &mockStore{
-		images:  make(map[string]time.Time),
-		sizes:   make(map[string]int64),
-		digests: make(map[string]string),
-		created: make(map[string]int64),
+		images:     make(map[string]time.Time),
+		sizes:      make(map[string]int64),
+		digests:    make(map[string]string),
+		created:    make(map[string]int64),
+		metas:      make(map[string]redisclient.ImageMeta),
+		protected:  make(map[string]bool),
+		priorities: make(map[string]int),
 	}
 }
 
@@ -142,14 +191,95 @@ func (m *mockStore) TrackImage(
 	expiresAt time.Time,
 	sizeBytes int64,
 	digest string,
+	meta redisclient.ImageMeta,
 ) error {
+	if m.trackErr != nil {
+		return m.trackErr
+	}
+	m.metas[imageWithTag] = meta
 	m.images[imageWithTag] = expiresAt
 	m.sizes[imageWithTag] = sizeBytes
 	m.digests[imageWithTag] = digest
 	m.created[imageWithTag] = time.Now().UnixMilli()
+	delete(m.protected, imageWithTag)
+	delete(m.priorities, imageWithTag)
+	return nil
+}
+
+func (m *mockStore) ListImages(context.Context) ([]string, error) {
+	images := make([]string, 0, len(m.images))
+	for image := range m.images {
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+func (m *mockStore) GetExpiry(_ context.Context, imageWithTag string) (int64, error) {
+	exp, ok := m.images[imageWithTag]
+	if !ok {
+		return 0, errors.New("not tracked")
+	}
+	return exp.UnixMilli(), nil
+}
+
+func (m *mockStore) Aliases(_ context.Context, imageWithTag string) ([]string, error) {
+	var out []string
+	for image := range m.images {
+		if image != imageWithTag && m.digests[image] != "" && m.digests[image] == m.digests[imageWithTag] {
+			out = append(out, image)
+		}
+	}
+	return out, nil
+}
+
+func (m *mockStore) TaggedImages(_ context.Context, repo, digest string) ([]string, error) {
+	var out []string
+	for image := range m.images {
+		if strings.HasPrefix(image, repo+":") && m.digests[image] == digest {
+			out = append(out, image)
+		}
+	}
+	return out, nil
+}
+
+func (m *mockStore) SetExpiry(_ context.Context, imageWithTag string, expiresAt time.Time) (bool, error) {
+	if _, ok := m.images[imageWithTag]; !ok {
+		return false, nil
+	}
+	m.images[imageWithTag] = expiresAt
+	return true, nil
+}
+
+func (m *mockStore) SetExpiries(ctx context.Context, expiries map[string]time.Time) ([]string, error) {
+	var updated []string
+	for image, expiresAt := range expiries {
+		if ok, _ := m.SetExpiry(ctx, image, expiresAt); ok {
+			updated = append(updated, image)
+		}
+	}
+	return updated, nil
+}
+
+func (m *mockStore) RemoveImage(_ context.Context, imageWithTag string) error {
+	delete(m.images, imageWithTag)
+	delete(m.sizes, imageWithTag)
+	delete(m.digests, imageWithTag)
+	delete(m.created, imageWithTag)
+	delete(m.metas, imageWithTag)
 	return nil
 }
 
+func (m *mockStore) GetImageMeta(_ context.Context, imageWithTag string) (redisclient.ImageMeta, error) {
+	return m.metas[imageWithTag], nil
+}
+
+func (m *mockStore) GetImageRecord(_ context.Context, imageWithTag string) (map[string]string, error) {
+	if _, ok := m.images[imageWithTag]; !ok {
+		return map[string]string{}, nil
+	}
+	return map[string]string{"digest": m.digests[imageWithTag], "actor": m.metas[imageWithTag].Actor}, nil
+}
+
 func (m *mockStore) GetImageDigest(_ context.Context, imageWithTag string) (string, error) {
 	return m.digests[imageWithTag], nil
 }
@@ -158,23 +288,131 @@ func (m *mockStore) GetCreatedTimestamp(_ context.Context, imageWithTag string)
 	return m.created[imageWithTag], nil
 }
 
-func (m *mockStore) Ping(context.Context) error                                     { return nil }
-func (m *mockStore) Close() error                                                   { return nil }
-func (m *mockStore) ListImages(context.Context) ([]string, error)                   { return nil, nil }
-func (m *mockStore) GetExpiry(context.Context, string) (int64, error)               { return 0, nil }
-func (m *mockStore) GetImageSize(context.Context, string) (int64, error)            { return 0, nil }
-func (m *mockStore) RemoveImage(context.Context, string) error                      { return nil }
-func (m *mockStore) AcquireReaperLock(context.Context, time.Duration) (bool, error) { return true, nil }
-func (m *mockStore) ReleaseReaperLock(context.Context) error                        { return nil }
-func (m *mockStore) IsInitialized(context.Context) (bool, error)                    { return false, nil }
-func (m *mockStore) SetInitialized(context.Context) error                           { return nil }
-func (m *mockStore) ImageCount(context.Context) (int64, error)                      { return 0, nil }
+func (m *mockStore) SetManifestInfo(context.Context, string, int64, string) (bool, error) {
+	return false, nil
+}
+
+func (m *mockStore) SetImageSize(context.Context, string, string, int64) (bool, error) {
+	return false, nil
+}
+
+func (m *mockStore) GetManifestRecords(context.Context, []string) (map[string]redisclient.ManifestRecord, error) {
+	return nil, nil
+}
+
+func (m *mockStore) Ping(context.Context) error                                      { return nil }
+func (m *mockStore) Degraded(context.Context) (string, error)                        { return "", nil }
+func (m *mockStore) Close() error                                                    { return nil }
+func (m *mockStore) GetImageSize(context.Context, string) (int64, error)             { return 0, nil }
+func (m *mockStore) AcquireReaperLock(context.Context, time.Duration) (int64, error) { return 1, nil }
+func (m *mockStore) RenewReaperLock(context.Context, int64, time.Duration) (bool, error) {
+	return true, nil
+}
+func (m *mockStore) ReleaseReaperLock(context.Context, int64) error { return nil }
+func (m *mockStore) IsInitialized(context.Context) (bool, error)    { return false, nil }
+func (m *mockStore) SetInitialized(context.Context) error           { return nil }
+func (m *mockStore) SchemaVersion(context.Context) (int, error)     { return 0, nil }
+func (m *mockStore) SetSchemaVersion(context.Context, int) error    { return nil }
+func (m *mockStore) ImageCount(context.Context) (int64, error)      { return 0, nil }
+func (m *mockStore) TrackedBytes(context.Context) (int64, error)    { return 0, nil }
+func (m *mockStore) RecordReap(context.Context, int64) error        { return nil }
+func (m *mockStore) ReapTotals(context.Context) (int64, int64, error) {
+	return 0, 0, nil
+}
+func (m *mockStore) RecordPush(_ context.Context, repo string, sizeBytes int64, _ time.Time) error {
+	m.pushes = append(m.pushes, repo+":"+strconv.FormatInt(sizeBytes, 10))
+	return nil
+}
+func (m *mockStore) ListRepoStats(context.Context) ([]redisclient.RepoStats, error) { return nil, nil }
+func (m *mockStore) RecordDailyUsage(context.Context, redisclient.DailyUsage, time.Time) error {
+	return nil
+}
+func (m *mockStore) AddSuperseded(_ context.Context, s redisclient.SupersededManifest) error {
+	m.superseded = append(m.superseded, s.Repository+"@"+s.Digest)
+	return nil
+}
+func (m *mockStore) ExpiredSuperseded(context.Context, time.Time) ([]redisclient.SupersededManifest, error) {
+	return nil, nil
+}
+func (m *mockStore) RemoveSuperseded(context.Context, string, string) error { return nil }
+func (m *mockStore) ListDailyUsage(context.Context, time.Time) ([]redisclient.DailyUsage, error) {
+	return nil, nil
+}
+func (m *mockStore) SetProtected(_ context.Context, imageWithTag string, protected bool) error {
+	if protected {
+		m.protected[imageWithTag] = true
+	} else {
+		delete(m.protected, imageWithTag)
+	}
+	return nil
+}
+func (m *mockStore) IsProtected(_ context.Context, imageWithTag string) (bool, error) {
+	return m.protected[imageWithTag], nil
+}
+func (m *mockStore) SetPriority(_ context.Context, imageWithTag string, priority int) error {
+	m.priorities[imageWithTag] = priority
+	return nil
+}
+func (m *mockStore) GetPriority(_ context.Context, imageWithTag string) (int, error) {
+	return m.priorities[imageWithTag], nil
+}
+func (m *mockStore) RecordDeleteFailure(context.Context, string) (int64, error) { return 0, nil }
+func (m *mockStore) QuarantineImage(context.Context, redisclient.QuarantinedImage) error {
+	return nil
+}
+func (m *mockStore) ListQuarantined(context.Context) ([]redisclient.QuarantinedImage, error) {
+	return nil, nil
+}
+func (m *mockStore) ReleaseQuarantined(context.Context, string) error { return nil }
+
+func (m *mockStore) SetFreeze(context.Context, redisclient.Freeze) error { return nil }
+func (m *mockStore) ListFreezes(context.Context) ([]redisclient.Freeze, error) {
+	return nil, nil
+}
+func (m *mockStore) Unfreeze(context.Context, string) error { return nil }
+
+func (m *mockStore) PutRule(context.Context, string, redisclient.Rule) error { return nil }
+func (m *mockStore) ListRules(context.Context, string) ([]redisclient.Rule, error) {
+	return nil, nil
+}
+func (m *mockStore) DeleteRule(context.Context, string, string) (bool, error) { return false, nil }
+
+func (m *mockStore) PutDeletion(context.Context, redisclient.DeletionRequest) error { return nil }
+func (m *mockStore) ListDeletions(context.Context) ([]redisclient.DeletionRequest, error) {
+	return nil, nil
+}
+
+func (m *mockStore) PutAPIToken(context.Context, redisclient.APIToken) error { return nil }
+func (m *mockStore) ListAPITokens(context.Context) ([]redisclient.APIToken, error) {
+	return nil, nil
+}
+func (m *mockStore) DeleteAPIToken(context.Context, string) (bool, error) { return false, nil }
+func (m *mockStore) AddTombstone(context.Context, redisclient.Tombstone, time.Time) error {
+	return nil
+}
+func (m *mockStore) ListTombstones(context.Context, time.Time) ([]redisclient.Tombstone, error) {
+	return nil, nil
+}
+func (m *mockStore) AddReconcileProgress(context.Context, time.Time, string, []string) error {
+	return nil
+}
+func (m *mockStore) ReconcileProgress(context.Context) (redisclient.ReconcileCheckpoint, error) {
+	return redisclient.ReconcileCheckpoint{}, nil
+}
+func (m *mockStore) ClearReconcileProgress(context.Context) error     { return nil }
+func (m *mockStore) ReconcileCursor(context.Context) (string, error)  { return "", nil }
+func (m *mockStore) SetReconcileCursor(context.Context, string) error { return nil }
 
 // mockRegistry is a minimal mock for testing size fetching
 type mockRegistry struct {
-	sizes   map[string]int64
-	digests map[string]string
-	err     error
+	sizes     map[string]int64
+	digests   map[string]string
+	artifacts map[string]string
+	err       error
+	// deletedTags records DeleteTag calls as repo:tag.
+	deletedTags []string
+	// deleteErr, if set, is returned by DeleteTag.
+	deleteErr error
 }
 
 func (m *mockRegistry) GetImageSize(_ context.Context, repo, tag string) (int64, error) {
@@ -191,11 +429,17 @@ func (m *mockRegistry) GetImageManifestInfo(_ context.Context, repo, tag string)
 	}
 	key := repo + ":" + tag
 	return &registry.ManifestInfo{
-		Digest:    m.digests[key],
-		SizeBytes: m.sizes[key],
+		Digest:       m.digests[key],
+		SizeBytes:    m.sizes[key],
+		ArtifactType: m.artifacts[key],
 	}, nil
 }
 
+func (m *mockRegistry) DeleteTag(_ context.Context, repo, tag string) error {
+	m.deletedTags = append(m.deletedTags, repo+":"+tag)
+	return m.deleteErr
+}
+
 func TestHandler_SizeTracking_Success(t *testing.T) {
 	store := newMockStore()
 	registry := &mockRegistry{
@@ -230,6 +474,9 @@ func TestHandler_SizeTracking_Success(t *testing.T) {
 	if store.sizes[testAppTTL] != 12345678 {
 		t.Fatalf("expected size 12345678, got %d", store.sizes[testAppTTL])
 	}
+	if want := []string{testApp + ":12345678"}; !slices.Equal(store.pushes, want) {
+		t.Errorf("expected push statistics %v, got %v", want, store.pushes)
+	}
 }
 
 func TestHandler_SizeTracking_FetchError(t *testing.T) {
@@ -383,8 +630,11 @@ func TestDetectOverwrite_DifferentDigest_Enforcement(t *testing.T) {
 	handler.ServeHTTP(rr, req)
 
 	// Should fail - enforcement mode blocks overwrite
-	if rr.Code != http.StatusServiceUnavailable {
-		t.Fatalf("expected 503 for immutable tag overwrite, got %d", rr.Code)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for immutable tag overwrite, got %d", rr.Code)
+	}
+	if code := errorCode(t, rr); code != codeImmutableTag {
+		t.Fatalf("expected error code %q, got %q", codeImmutableTag, code)
 	}
 
 	// Old digest should still be in store (webhook failed before tracking)
@@ -443,3 +693,648 @@ func TestIsImmutableTag_InvalidPattern(t *testing.T) {
 		t.Error("expected false for invalid pattern")
 	}
 }
+
+func TestHandler_ArtifactTTLs(t *testing.T) {
+	store := newMockStore()
+	reg := &mockRegistry{
+		artifacts: map[string]string{
+			"charts/app:0.1.0": registry.ArtifactHelm,
+			"charts/app:2h":    registry.ArtifactHelm,
+			"app:0.1.0":        registry.ArtifactImage,
+		},
+	}
+
+	handler := NewHandler(store, reg, "tok", time.Hour, 48*time.Hour, nil, slog.Default(),
+		WithArtifactTTLs(map[string]time.Duration{registry.ArtifactHelm: 24 * time.Hour}),
+	)
+
+	body, _ := json.Marshal(EventEnvelope{Events: []RegistryEvent{
+		{Action: testPush, Target: EventTarget{Repository: "charts/app", Tag: "0.1.0"}},
+		{Action: testPush, Target: EventTarget{Repository: "charts/app", Tag: "2h"}},
+		{Action: testPush, Target: EventTarget{Repository: "app", Tag: "0.1.0"}},
+	}})
+	req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Token tok")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+
+	tests := []struct {
+		image string
+		want  time.Duration
+	}{
+		{"charts/app:0.1.0", 24 * time.Hour},
+		{"charts/app:2h", 2 * time.Hour},
+		{"app:0.1.0", time.Hour},
+	}
+	for _, tt := range tests {
+		got := time.Until(store.images[tt.image])
+		if got < tt.want-time.Minute || got > tt.want {
+			t.Errorf("%s: expected TTL ~%s, got %s", tt.image, tt.want, got.Round(time.Second))
+		}
+	}
+}
+
+func TestHandler_DeleteEvents(t *testing.T) {
+	seed := func() *mockStore {
+		store := newMockStore()
+		for image, digest := range map[string]string{
+			"myapp:1h": "sha256:aaa",
+			"myapp:2h": "sha256:aaa",
+			"myapp:3h": "sha256:bbb",
+			"other:1h": "sha256:aaa",
+		} {
+			_ = store.TrackImage(context.Background(), image, time.Now().Add(time.Hour), 1, digest, redisclient.ImageMeta{})
+		}
+		return store
+	}
+	send := func(t *testing.T, store *mockStore, target EventTarget) {
+		t.Helper()
+		handler := NewHandler(store, &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default())
+		body, _ := json.Marshal(EventEnvelope{Events: []RegistryEvent{{Action: "delete", Target: target}}})
+		req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Token tok")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", rr.Code)
+		}
+	}
+
+	t.Run("tag delete removes that tag", func(t *testing.T) {
+		store := seed()
+		send(t, store, EventTarget{Repository: testApp, Tag: "1h", Digest: "sha256:aaa"})
+		if _, ok := store.images[testAppTTL]; ok {
+			t.Error("expected myapp:1h to be removed")
+		}
+		if _, ok := store.images["myapp:2h"]; !ok {
+			t.Error("expected myapp:2h to remain tracked")
+		}
+	})
+
+	t.Run("manifest delete removes tags with that digest", func(t *testing.T) {
+		store := seed()
+		send(t, store, EventTarget{Repository: testApp, Digest: "sha256:aaa"})
+		for _, image := range []string{"myapp:1h", "myapp:2h"} {
+			if _, ok := store.images[image]; ok {
+				t.Errorf("expected %s to be removed", image)
+			}
+		}
+		for _, image := range []string{"myapp:3h", "other:1h"} {
+			if _, ok := store.images[image]; !ok {
+				t.Errorf("expected %s to remain tracked", image)
+			}
+		}
+	})
+
+	t.Run("untracked image is ignored", func(t *testing.T) {
+		store := seed()
+		send(t, store, EventTarget{Repository: "unknown", Tag: "1h"})
+		if len(store.images) != 4 {
+			t.Errorf("expected 4 tracked images, got %d", len(store.images))
+		}
+	})
+}
+
+func TestHandler_StoresPusherMetadata(t *testing.T) {
+	store := newMockStore()
+	handler := NewHandler(store, &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default())
+
+	body := []byte(`{"events":[{
+		"action":"push",
+		"target":{"repository":"myapp","tag":"1h"},
+		"actor":{"name":"ci-bot"},
+		"request":{"addr":"10.0.0.7:43122","useragent":"buildkit/v0.15"}
+	}]}`)
+	req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Token tok")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	want := redisclient.ImageMeta{Actor: "ci-bot", SourceAddr: "10.0.0.7:43122", UserAgent: "buildkit/v0.15"}
+	if got := store.metas[testAppTTL]; got != want {
+		t.Fatalf("expected metadata %+v, got %+v", want, got)
+	}
+}
+
+func TestHandler_Source(t *testing.T) {
+	sources := map[string]string{"mirror-tok": "mirror-eu"}
+	tests := []struct {
+		name       string
+		auth       string
+		header     string
+		wantStatus int
+		wantSource string
+	}{
+		{name: "shared token without header", auth: "Token tok", wantStatus: http.StatusOK},
+		{name: "shared token with header", auth: "Token tok", header: "primary-0", wantStatus: http.StatusOK,
+			wantSource: "primary-0"},
+		{name: "unlisted header ignored", auth: "Token tok", header: "primary-1", wantStatus: http.StatusOK},
+		{name: "token source name in header", auth: "Token tok", header: "mirror-eu", wantStatus: http.StatusOK,
+			wantSource: "mirror-eu"},
+		{name: "invalid header ignored", auth: "Token tok", header: "bad source!", wantStatus: http.StatusOK},
+		{name: "source token", auth: "Token mirror-tok", wantStatus: http.StatusOK, wantSource: "mirror-eu"},
+		{name: "source token overrides header", auth: "Token mirror-tok", header: "primary-0",
+			wantStatus: http.StatusOK, wantSource: "mirror-eu"},
+		{name: "unknown token", auth: "Token other", header: "primary-0", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMockStore()
+			handler := NewHandler(store, &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default(),
+				WithSourceTokens(sources), WithSourceNames([]string{"primary-0"}))
+			counter := metrics.WebhookSourceEventsTotal.WithLabelValues(sourceLabel(tt.wantSource), "push")
+			before := testutil.ToFloat64(counter)
+
+			body := []byte(`{"events":[{"action":"push","target":{"repository":"myapp","tag":"1h"}}]}`)
+			req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader(body))
+			req.Header.Set("Authorization", tt.auth)
+			if tt.header != "" {
+				req.Header.Set("X-Registry-Source", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d", tt.wantStatus, rr.Code)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if got := store.metas[testAppTTL].Source; got != tt.wantSource {
+				t.Errorf("expected source %q, got %q", tt.wantSource, got)
+			}
+			after := testutil.ToFloat64(counter)
+			if after != before+1 {
+				t.Errorf("expected source event counter to increase by 1, got %v -> %v", before, after)
+			}
+		})
+	}
+}
+
+func TestParseSourceTokens(t *testing.T) {
+	got, err := ParseSourceTokens([]string{"primary=a", " mirror-eu = b "})
+	if err != nil {
+		t.Fatalf("ParseSourceTokens: %v", err)
+	}
+	if got["a"] != "primary" || got["b"] != "mirror-eu" || len(got) != 2 {
+		t.Errorf("unexpected sources %v", got)
+	}
+	for _, entries := range [][]string{{"primary"}, {"=a"}, {"bad name=a"}, {"one=a", "two=a"}} {
+		if _, err := ParseSourceTokens(entries); err == nil {
+			t.Errorf("ParseSourceTokens(%q): expected error", entries)
+		}
+	}
+}
+
+func TestParseSourceNames(t *testing.T) {
+	got, err := ParseSourceNames([]string{"primary", " mirror-eu "})
+	if err != nil {
+		t.Fatalf("ParseSourceNames: %v", err)
+	}
+	if !slices.Equal(got, []string{"primary", "mirror-eu"}) {
+		t.Errorf("unexpected names %v", got)
+	}
+	for _, entries := range [][]string{{""}, {"bad name"}} {
+		if _, err := ParseSourceNames(entries); err == nil {
+			t.Errorf("ParseSourceNames(%q): expected error", entries)
+		}
+	}
+}
+
+// sampleCount returns the number of observations recorded by a histogram.
+func sampleCount(t *testing.T, o prometheus.Observer) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := o.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("reading histogram: %v", err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestHandler_IngestionMetrics(t *testing.T) {
+	store := newMockStore()
+	store.trackErr = errors.New("connection refused")
+	handler := NewHandler(store, &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default())
+
+	observers := map[string]prometheus.Observer{
+		"request bytes":      metrics.WebhookRequestBytes.WithLabelValues(codeStoreUnavailable),
+		"decode duration":    metrics.WebhookDecodeDuration.WithLabelValues(outcomeOK),
+		"event duration":     metrics.WebhookEventDuration.WithLabelValues(testPush, codeStoreUnavailable),
+		"manifest fetch":     metrics.WebhookStageDuration.WithLabelValues(metrics.StageManifestFetch, "ok"),
+		"failed store write": metrics.WebhookStageDuration.WithLabelValues(metrics.StageStoreWrite, "error"),
+	}
+	before := make(map[string]uint64, len(observers))
+	for name, o := range observers {
+		before[name] = sampleCount(t, o)
+	}
+
+	body, _ := json.Marshal(EventEnvelope{Events: []RegistryEvent{
+		{Action: testPush, Target: EventTarget{Repository: testApp, Tag: "1h"}},
+	}})
+	req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Token tok")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rr.Code)
+	}
+	for name, o := range observers {
+		if got := sampleCount(t, o) - before[name]; got != 1 {
+			t.Errorf("%s: %d new observations, want 1", name, got)
+		}
+	}
+}
+
+func TestHandler_TTLMetrics(t *testing.T) {
+	tests := []struct {
+		name                 string
+		tag                  string
+		decision             *policy.Decision
+		requested, effective uint64
+		clamped              float64
+	}{
+		{name: "default", tag: "latest", effective: 1},
+		{name: "within max", tag: "1h", requested: 1, effective: 1},
+		{name: "tag clamped", tag: "48h", requested: 1, effective: 1, clamped: 1},
+		{name: "policy clamped", tag: "1h", decision: &policy.Decision{Allow: true, TTL: 72 * time.Hour},
+			requested: 1, effective: 1, clamped: 1},
+		{name: "policy denied", tag: "48h", decision: &policy.Decision{Reason: "no"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []HandlerOption
+			if tt.decision != nil {
+				opts = append(opts, WithPolicy(policyFunc(func(policy.Request) policy.Decision { return *tt.decision })))
+			}
+			handler := NewHandler(newMockStore(), &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil,
+				slog.Default(), opts...)
+			repo := "ttl-metrics/" + strings.ReplaceAll(tt.name, " ", "-")
+			requested, effective := sampleCount(t, metrics.RequestedTTL), sampleCount(t, metrics.EffectiveTTL)
+
+			body, _ := json.Marshal(EventEnvelope{Events: []RegistryEvent{
+				{Action: testPush, Target: EventTarget{Repository: repo, Tag: tt.tag}},
+			}})
+			req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader(body))
+			req.Header.Set("Authorization", "Token tok")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", rr.Code)
+			}
+
+			if got := sampleCount(t, metrics.RequestedTTL) - requested; got != tt.requested {
+				t.Errorf("requested TTL: %d new observations, want %d", got, tt.requested)
+			}
+			if got := sampleCount(t, metrics.EffectiveTTL) - effective; got != tt.effective {
+				t.Errorf("effective TTL: %d new observations, want %d", got, tt.effective)
+			}
+			if got := testutil.ToFloat64(metrics.TTLClamped.WithLabelValues(repo)); got != tt.clamped {
+				t.Errorf("clamped = %v, want %v", got, tt.clamped)
+			}
+		})
+	}
+}
+
+func TestHandler_TTLTagValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []HandlerOption
+		tag        string
+		wantStatus int
+		wantTTL    time.Duration
+		outcome    string
+	}{
+		{name: "off", tag: "2hours", wantStatus: http.StatusOK, wantTTL: time.Hour},
+		{name: "observe", opts: []HandlerOption{WithTTLTagValidation(false)}, tag: "2hours",
+			wantStatus: http.StatusOK, wantTTL: time.Hour, outcome: "flagged"},
+		{name: "enforce", opts: []HandlerOption{WithTTLTagValidation(true)}, tag: "2hours",
+			wantStatus: http.StatusUnprocessableEntity, outcome: "rejected"},
+		{name: "enforce valid tag", opts: []HandlerOption{WithTTLTagValidation(true)}, tag: "2h",
+			wantStatus: http.StatusOK, wantTTL: 2 * time.Hour},
+		{name: "enforce plain tag", opts: []HandlerOption{WithTTLTagValidation(true)}, tag: "latest",
+			wantStatus: http.StatusOK, wantTTL: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMockStore()
+			handler := NewHandler(store, &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default(),
+				tt.opts...)
+			repo := "ttl-tags/" + strings.ReplaceAll(tt.name, " ", "-")
+
+			body, _ := json.Marshal(EventEnvelope{Events: []RegistryEvent{
+				{Action: testPush, Target: EventTarget{Repository: repo, Tag: tt.tag}},
+			}})
+			req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader(body))
+			req.Header.Set("Authorization", "Token tok")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d", tt.wantStatus, rr.Code)
+			}
+			expiry, tracked := store.images[repo+":"+tt.tag]
+			if tt.wantStatus != http.StatusOK {
+				if !strings.Contains(rr.Body.String(), `\"2h\"`) {
+					t.Errorf("expected the error to suggest 2h, got %s", rr.Body)
+				}
+				if code := errorCode(t, rr); code != codeInvalidTTL {
+					t.Errorf("expected error code %q, got %q", codeInvalidTTL, code)
+				}
+				if tracked {
+					t.Error("rejected push was tracked")
+				}
+			} else if ttl := time.Until(expiry); !tracked || ttl > tt.wantTTL || ttl < tt.wantTTL-time.Minute {
+				t.Errorf("expected a TTL of %s, got %s", tt.wantTTL, ttl)
+			}
+			for _, outcome := range []string{"flagged", "rejected"} {
+				want := 0.0
+				if outcome == tt.outcome {
+					want = 1
+				}
+				if got := testutil.ToFloat64(metrics.MalformedTTLTags.WithLabelValues(repo, outcome)); got != want {
+					t.Errorf("%s = %v, want %v", outcome, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_NonTTLTags(t *testing.T) {
+	actions := map[string]string{"release": NonTTLIgnore, "strict": NonTTLReject}
+	store := newMockStore()
+	handler := NewHandler(store, &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default(),
+		WithNonTTLTags(func(repo string) string { return actions[repo] }))
+
+	tests := []struct {
+		repo, tag   string
+		wantStatus  int
+		wantTracked bool
+	}{
+		{repo: testApp, tag: "latest", wantStatus: http.StatusOK, wantTracked: true},
+		{repo: "release", tag: "v1.0.0", wantStatus: http.StatusOK},
+		{repo: "release", tag: "2h", wantStatus: http.StatusOK, wantTracked: true},
+		{repo: "strict", tag: "latest", wantStatus: http.StatusUnprocessableEntity},
+		{repo: "strict", tag: "2h", wantStatus: http.StatusOK, wantTracked: true},
+	}
+	for _, tt := range tests {
+		body, _ := json.Marshal(EventEnvelope{Events: []RegistryEvent{
+			{Action: testPush, Target: EventTarget{Repository: tt.repo, Tag: tt.tag}},
+		}})
+		req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Token tok")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		image := tt.repo + ":" + tt.tag
+		if rr.Code != tt.wantStatus {
+			t.Errorf("%s: expected %d, got %d", image, tt.wantStatus, rr.Code)
+		}
+		if _, tracked := store.images[image]; tracked != tt.wantTracked {
+			t.Errorf("%s: tracked = %v, want %v", image, tracked, tt.wantTracked)
+		}
+	}
+}
+
+// projectsFunc adapts a function to projectProvisioner.
+type projectsFunc func(repo string) error
+
+func (f projectsFunc) EnsureProject(_ context.Context, repo string) error { return f(repo) }
+
+func TestHandler_ProjectProvisioning(t *testing.T) {
+	var provisioned []string
+	store := newMockStore()
+	handler := NewHandler(store, &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default(),
+		WithProjectProvisioning(projectsFunc(func(repo string) error {
+			provisioned = append(provisioned, repo)
+			return errors.New("harbor unreachable")
+		})))
+
+	body, _ := json.Marshal(EventEnvelope{Events: []RegistryEvent{
+		{Action: testPush, Target: EventTarget{Repository: "team/app", Tag: "1h"}},
+	}})
+	req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Token tok")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 despite the provisioning failure, got %d", rr.Code)
+	}
+	if !slices.Equal(provisioned, []string{"team/app"}) {
+		t.Errorf("provisioned = %v, want [team/app]", provisioned)
+	}
+	if _, ok := store.images["team/app:1h"]; !ok {
+		t.Error("push was not tracked")
+	}
+}
+
+// policyFunc adapts a function to policyChecker.
+type policyFunc func(policy.Request) policy.Decision
+
+func (f policyFunc) Check(_ context.Context, req policy.Request) policy.Decision { return f(req) }
+
+func TestHandler_Policy(t *testing.T) {
+	tests := []struct {
+		name     string
+		decision policy.Decision
+		wantTTL  time.Duration
+	}{
+		{name: "allow keeps tag ttl", decision: policy.Decision{Allow: true}, wantTTL: time.Hour},
+		{name: "ttl override", decision: policy.Decision{Allow: true, TTL: 3 * time.Hour}, wantTTL: 3 * time.Hour},
+		{name: "override capped at max ttl", decision: policy.Decision{Allow: true, TTL: 48 * time.Hour},
+			wantTTL: 24 * time.Hour},
+		{name: "deny expires immediately", decision: policy.Decision{Reason: "too big"}, wantTTL: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMockStore()
+			var got policy.Request
+			check := policyFunc(func(req policy.Request) policy.Decision {
+				got = req
+				return tt.decision
+			})
+			reg := &mockRegistry{
+				sizes:   map[string]int64{testAppTTL: 42},
+				digests: map[string]string{testAppTTL: "sha256:abc"},
+			}
+			handler := NewHandler(store, reg, "tok", time.Hour, 24*time.Hour, nil, slog.Default(), WithPolicy(check))
+
+			body, _ := json.Marshal(EventEnvelope{Events: []RegistryEvent{
+				{Action: testPush, Target: EventTarget{Repository: testApp, Tag: "1h"}, Actor: EventActor{Name: "ci"}},
+			}})
+			req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader(body))
+			req.Header.Set("Authorization", "Token tok")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", rr.Code)
+			}
+			want := policy.Request{Repository: testApp, Tag: "1h", Digest: "sha256:abc", SizeBytes: 42,
+				ArtifactType: registry.ArtifactImage, Actor: "ci", TTLSeconds: 3600}
+			if got != want {
+				t.Errorf("policy request = %+v, want %+v", got, want)
+			}
+			remaining := time.Until(store.images[testAppTTL])
+			if remaining > tt.wantTTL || remaining < tt.wantTTL-time.Minute {
+				t.Errorf("remaining ttl = %v, want about %v", remaining, tt.wantTTL)
+			}
+		})
+	}
+}
+
+func TestHandler_PolicyImmutableAndProtected(t *testing.T) {
+	store := newMockStore()
+	store.digests[testAppTTL] = "sha256:old"
+	reg := &mockRegistry{digests: map[string]string{testAppTTL: "sha256:new"}}
+	decision := policy.Decision{Allow: true, Immutable: true}
+	check := policyFunc(func(policy.Request) policy.Decision { return decision })
+	handler := NewHandler(store, reg, "tok", time.Hour, 24*time.Hour, nil, slog.Default(), WithPolicy(check))
+
+	push := func() int {
+		body, _ := json.Marshal(EventEnvelope{Events: []RegistryEvent{
+			{Action: testPush, Target: EventTarget{Repository: testApp, Tag: "1h"}},
+		}})
+		req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Token tok")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := push(); code != http.StatusConflict {
+		t.Fatalf("overwrite of a tag the policy made immutable: got %d, want 409", code)
+	}
+
+	decision = policy.Decision{Allow: true, Protected: true, Priority: 7}
+	if code := push(); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if !store.protected[testAppTTL] {
+		t.Error("expected image protected by policy")
+	}
+	if got := store.priorities[testAppTTL]; got != 7 {
+		t.Errorf("expected priority 7 from policy, got %d", got)
+	}
+
+	decision = policy.Decision{Allow: true}
+	if code := push(); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if store.protected[testAppTTL] {
+		t.Error("re-push without protection should clear it")
+	}
+	if got := store.priorities[testAppTTL]; got != 0 {
+		t.Errorf("re-push without priority should clear it, got %d", got)
+	}
+}
+
+// fakeRules is a ruleSet with fixed rules.
+type fakeRules struct {
+	immutable          string
+	defaultTTL, maxTTL time.Duration
+	policyRepo         string
+}
+
+func (f fakeRules) ImmutableTag(tag string) bool { return tag == f.immutable }
+
+func (f fakeRules) RepoPolicy(repo string) (time.Duration, time.Duration, bool) {
+	return f.defaultTTL, f.maxTTL, repo == f.policyRepo
+}
+
+func TestHandler_Rules(t *testing.T) {
+	store := newMockStore()
+	store.digests["other:stable"] = "sha256:old"
+	reg := &mockRegistry{digests: map[string]string{"other:stable": "sha256:new"}}
+	rules := fakeRules{immutable: "stable", defaultTTL: 2 * time.Hour, maxTTL: 3 * time.Hour, policyRepo: testApp}
+	handler := NewHandler(store, reg, "tok", time.Hour, 24*time.Hour, []string{"v*"}, slog.Default(),
+		WithRules(rules))
+
+	push := func(repo, tag string) int {
+		body, _ := json.Marshal(EventEnvelope{Events: []RegistryEvent{
+			{Action: testPush, Target: EventTarget{Repository: repo, Tag: tag}},
+		}})
+		req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Token tok")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	before := time.Now()
+	for _, tag := range []string{"latest", "1w"} {
+		if code := push(testApp, tag); code != http.StatusOK {
+			t.Fatalf("push %s: expected 200, got %d", tag, code)
+		}
+	}
+	if got := store.images[testApp+":latest"].Sub(before); got < 2*time.Hour || got > 2*time.Hour+time.Minute {
+		t.Errorf("default TTL = %v, want the repository policy's 2h", got)
+	}
+	if got := store.images[testApp+":1w"].Sub(before); got < 3*time.Hour || got > 3*time.Hour+time.Minute {
+		t.Errorf("capped TTL = %v, want the repository policy's 3h", got)
+	}
+	if code := push("other", "stable"); code != http.StatusConflict {
+		t.Errorf("overwrite of a tag made immutable at runtime: got %d, want 409", code)
+	}
+}
+
+func TestHandler_PolicyMaxCappedAtGlobal(t *testing.T) {
+	rules := fakeRules{defaultTTL: 72 * time.Hour, maxTTL: 30 * 24 * time.Hour, policyRepo: testApp}
+	handler := NewHandler(newMockStore(), &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default(),
+		WithRules(rules))
+
+	if got := handler.MaxTTL(testApp, registry.ArtifactImage); got != 24*time.Hour {
+		t.Errorf("MaxTTL = %v, want MAX_TTL 24h", got)
+	}
+	for tag, want := range map[string]time.Duration{"4w": 24 * time.Hour, "latest": 24 * time.Hour} {
+		steps, ttl := handler.ExplainTTL(testApp, tag, registry.ArtifactImage)
+		if ttl != want {
+			t.Errorf("ExplainTTL(%s) TTL = %v, want %v", tag, ttl, want)
+		}
+		if !strings.Contains(steps[1].Detail, "capped at MAX_TTL") {
+			t.Errorf("ExplainTTL(%s) policy step = %q, want it to note the cap", tag, steps[1].Detail)
+		}
+	}
+}
+
+func TestHandler_ExplainTTL(t *testing.T) {
+	rules := fakeRules{defaultTTL: 2 * time.Hour, maxTTL: 3 * time.Hour, policyRepo: testApp}
+	handler := NewHandler(newMockStore(), &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default(),
+		WithRules(rules), WithArtifactTTLs(map[string]time.Duration{registry.ArtifactHelm: 12 * time.Hour}))
+
+	tests := []struct {
+		repo, tag, artifactType string
+		want                    time.Duration
+		steps                   []string
+	}{
+		{repo: "other", tag: "30m", artifactType: registry.ArtifactImage, want: 30 * time.Minute,
+			steps: []string{"global", "tag"}},
+		{repo: "other", tag: "latest", artifactType: registry.ArtifactHelm, want: 12 * time.Hour,
+			steps: []string{"global", "artifact_type", "tag"}},
+		{repo: testApp, tag: "1w", artifactType: registry.ArtifactImage, want: 3 * time.Hour,
+			steps: []string{"global", "repo_policy", "tag"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.repo+":"+tt.tag, func(t *testing.T) {
+			steps, ttl := handler.ExplainTTL(tt.repo, tt.tag, tt.artifactType)
+			if ttl != tt.want {
+				t.Errorf("ExplainTTL() TTL = %v, want %v", ttl, tt.want)
+			}
+			var names []string
+			for _, s := range steps {
+				names = append(names, s.Step)
+			}
+			if !slices.Equal(names, tt.steps) {
+				t.Errorf("ExplainTTL() steps = %v, want %v", names, tt.steps)
+			}
+		})
+	}
+}
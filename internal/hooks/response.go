@@ -0,0 +1,187 @@
+package hooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Results of webhook events, as reported in response summaries.
+const (
+	resultTracked   = "tracked"
+	resultJournaled = "journaled"
+	resultIgnored   = "ignored"
+	resultExtended  = "extended"
+	resultUntracked = "untracked"
+	resultCounted   = "counted"
+	resultSkipped   = "skipped"
+	resultFailed    = "failed"
+)
+
+// What a webhook request does when one of its events fails.
+const (
+	// EventFailuresAbort stops at the failed event and answers with its
+	// error status, so the registry redelivers the whole request.
+	EventFailuresAbort = "abort"
+	// EventFailuresReport handles every event and answers 207 Multi-Status
+	// with the outcome of each, so nothing is redelivered.
+	EventFailuresReport = "report"
+	// EventFailuresRetry handles every event like EventFailuresReport, but
+	// answers with the status of the first failure the registry should retry
+	// (5xx), if any.
+	EventFailuresRetry = "retry"
+)
+
+// EventFailureModes lists the supported event failure modes.
+var EventFailureModes = []string{EventFailuresAbort, EventFailuresReport, EventFailuresRetry}
+
+// summary is the body written once the events of a webhook request have been
+// handled, so the registry's notification logs show what became of each of
+// them. With EventFailuresAbort a failed event stops the request; events
+// after it are counted in Received but not listed.
+type summary struct {
+	Received int           `json:"received"`
+	Accepted int           `json:"accepted"`
+	Skipped  int           `json:"skipped"`
+	Failed   int           `json:"failed"`
+	Tracked  []string      `json:"tracked"`
+	Events   []eventResult `json:"events"`
+	Error    *errorDetail  `json:"error,omitempty"`
+}
+
+// eventResult is what happened to one event.
+type eventResult struct {
+	Action     string `json:"action"`
+	Repository string `json:"repository"`
+	Tag        string `json:"tag,omitempty"`
+	Digest     string `json:"digest,omitempty"`
+	Result     string `json:"result"`
+	// Images lists the images a delete event stopped tracking.
+	Images []string `json:"images,omitempty"`
+	// Status and Code classify a failure like a request error.
+	Status int    `json:"status,omitempty"`
+	Code   string `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func newSummary(received int) *summary {
+	return &summary{Received: received, Tracked: []string{}, Events: []eventResult{}}
+}
+
+// add records the result of an event.
+func (s *summary) add(e eventResult) {
+	s.Events = append(s.Events, e)
+	switch e.Result {
+	case resultSkipped:
+		s.Skipped++
+	case resultFailed:
+		s.Failed++
+	default:
+		s.Accepted++
+	}
+	if e.Result == resultTracked || e.Result == resultJournaled {
+		s.Tracked = append(s.Tracked, e.Repository+":"+e.Tag)
+	}
+}
+
+// fail records an event that failed with err, classified by status and code.
+func (s *summary) fail(e eventResult, status int, code string, err error) {
+	e.Result = resultFailed
+	e.Status = status
+	e.Code = code
+	e.Error = err.Error()
+	s.add(e)
+}
+
+// abort makes the last event, a failed one, the error of the request and
+// returns its status.
+func (s *summary) abort() int {
+	e := s.Events[len(s.Events)-1]
+	s.Error = &errorDetail{Code: e.Code, Message: e.Error}
+	return e.Status
+}
+
+// status returns the status of a request whose events have all been handled:
+// 200 if none failed, with EventFailuresRetry the status of the first
+// failure to retry, and otherwise 207 Multi-Status.
+func (s *summary) status(mode string) int {
+	if s.Failed == 0 {
+		return http.StatusOK
+	}
+	if mode == EventFailuresRetry {
+		for _, e := range s.Events {
+			if e.Result == resultFailed && e.Status >= http.StatusInternalServerError {
+				s.Error = &errorDetail{Code: e.Code, Message: e.Error}
+				return e.Status
+			}
+		}
+	}
+	return http.StatusMultiStatus
+}
+
+// write writes the summary with status as JSON, or as plain text lines if
+// the request's Accept header prefers text/plain.
+func (s *summary) write(w http.ResponseWriter, r *http.Request, status int) {
+	if !prefersText(r.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(s)
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d events: %d accepted, %d skipped, %d failed\n", s.Received, s.Accepted, s.Skipped, s.Failed)
+	for _, e := range s.Events {
+		ref := e.Repository
+		if e.Tag != "" {
+			ref += ":" + e.Tag
+		}
+		if e.Digest != "" {
+			ref += "@" + e.Digest
+		}
+		fmt.Fprintf(&b, "%s %s: %s", e.Action, ref, e.Result)
+		if len(e.Images) > 0 {
+			fmt.Fprintf(&b, " %s", strings.Join(e.Images, ", "))
+		}
+		if e.Error != "" {
+			fmt.Fprintf(&b, ": %s", e.Error)
+		}
+		b.WriteByte('\n')
+	}
+	if s.Error != nil {
+		fmt.Fprintf(&b, "error: %s\n", s.Error.Code)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// prefersText reports whether accept ranks text/plain above application/json.
+// Wildcards count for both, so JSON wins ties and requests without Accept.
+func prefersText(accept string) bool {
+	var textQ, jsonQ float64
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "text/plain", "text/*":
+			textQ = max(textQ, q)
+		case "application/json", "application/*":
+			jsonQ = max(jsonQ, q)
+		case "*/*":
+			textQ, jsonQ = max(textQ, q), max(jsonQ, q)
+		}
+	}
+	return textQ > jsonQ
+}
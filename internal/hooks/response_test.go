@@ -0,0 +1,181 @@
+package hooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postSummary sends events to handler with the given Accept header.
+func postSummary(t *testing.T, handler *Handler, accept string, events ...RegistryEvent) *httptest.ResponseRecorder {
+	t.Helper()
+	body, _ := json.Marshal(EventEnvelope{Events: events})
+	req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Token tok")
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestHandler_ResponseSummary(t *testing.T) {
+	store := newMockStore()
+	store.images["gone:1h"] = time.Now().Add(time.Hour)
+	handler := NewHandler(store, &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default())
+
+	rr := postSummary(t, handler, "",
+		RegistryEvent{Action: testPush, Target: EventTarget{Repository: testApp, Tag: "1h"}},
+		RegistryEvent{Action: "pull", Target: EventTarget{Repository: testApp, Tag: "1h"}},
+		RegistryEvent{Action: actionDelete, Target: EventTarget{Repository: "gone", Tag: "1h"}},
+	)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var got summary
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Received != 3 || got.Accepted != 2 || got.Skipped != 1 || got.Error != nil {
+		t.Errorf("summary = %+v, want 3 received, 2 accepted, 1 skipped", got)
+	}
+	if !slices.Equal(got.Tracked, []string{testAppTTL}) {
+		t.Errorf("tracked = %v, want [%s]", got.Tracked, testAppTTL)
+	}
+	results := make([]string, len(got.Events))
+	for i, e := range got.Events {
+		results[i] = e.Result
+	}
+	if want := []string{resultTracked, resultSkipped, resultUntracked}; !slices.Equal(results, want) {
+		t.Errorf("results = %v, want %v", results, want)
+	}
+	if images := got.Events[2].Images; !slices.Equal(images, []string{"gone:1h"}) {
+		t.Errorf("delete images = %v, want [gone:1h]", images)
+	}
+}
+
+func TestHandler_ResponseSummary_Text(t *testing.T) {
+	handler := NewHandler(newMockStore(), &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default())
+
+	rr := postSummary(t, handler, "text/plain, application/json;q=0.5",
+		RegistryEvent{Action: testPush, Target: EventTarget{Repository: testApp, Tag: "1h"}},
+	)
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("Content-Type = %q, want text/plain", ct)
+	}
+	want := "1 events: 1 accepted, 0 skipped, 0 failed\npush myapp:1h: tracked\n"
+	if rr.Body.String() != want {
+		t.Errorf("body = %q, want %q", rr.Body.String(), want)
+	}
+}
+
+func TestHandler_ResponseSummary_Failure(t *testing.T) {
+	store := newMockStore()
+	store.trackErr = errors.New("connection refused")
+	handler := NewHandler(store, &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default())
+
+	rr := postSummary(t, handler, "",
+		RegistryEvent{Action: "pull", Target: EventTarget{Repository: testApp, Tag: "1h"}},
+		RegistryEvent{Action: testPush, Target: EventTarget{Repository: testApp, Tag: "1h"}},
+		RegistryEvent{Action: testPush, Target: EventTarget{Repository: testApp, Tag: "2h"}},
+	)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", rr.Code)
+	}
+	var got summary
+	if err := json.NewDecoder(bytes.NewReader(rr.Body.Bytes())).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Received != 3 || len(got.Events) != 2 || got.Events[1].Result != resultFailed {
+		t.Errorf("summary = %+v, want the second of 3 events failed and the third not handled", got)
+	}
+	if code := errorCode(t, rr); code != codeStoreUnavailable {
+		t.Errorf("error code = %q, want %q", code, codeStoreUnavailable)
+	}
+}
+
+func TestPrefersText(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"text/plain", true},
+		{"text/*, */*;q=0.1", true},
+		{"application/json;q=0.9, text/plain", true},
+		{"text/plain;q=0.5, application/json", false},
+		{"text/html", false},
+	}
+	for _, tt := range tests {
+		if got := prefersText(tt.accept); got != tt.want {
+			t.Errorf("prefersText(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestHandler_EventFailures(t *testing.T) {
+	rejectNonTTL := WithNonTTLTags(func(string) string { return NonTTLReject })
+	tests := []struct {
+		name       string
+		mode       string
+		trackErr   error
+		wantStatus int
+	}{
+		{"report", EventFailuresReport, errors.New("connection refused"), http.StatusMultiStatus},
+		{"retry store failure", EventFailuresRetry, errors.New("connection refused"), http.StatusServiceUnavailable},
+		{"retry rejection only", EventFailuresRetry, nil, http.StatusMultiStatus},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMockStore()
+			store.trackErr = tt.trackErr
+			store.images["gone:1h"] = time.Now().Add(time.Hour)
+			handler := NewHandler(store, &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default(),
+				WithEventFailures(tt.mode), rejectNonTTL,
+			)
+
+			rr := postSummary(t, handler, "",
+				RegistryEvent{Action: testPush, Target: EventTarget{Repository: testApp, Tag: "1h"}},
+				RegistryEvent{Action: testPush, Target: EventTarget{Repository: testApp, Tag: "latest"}},
+				RegistryEvent{Action: actionDelete, Target: EventTarget{Repository: "gone", Tag: "1h"}},
+			)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+			var got summary
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Events) != 3 || got.Events[2].Result != resultUntracked {
+				t.Fatalf("events = %+v, want every event handled", got.Events)
+			}
+			if rejected := got.Events[1]; rejected.Status != http.StatusUnprocessableEntity ||
+				rejected.Code != codeInvalidTTL {
+				t.Errorf("rejected event = %+v, want 422 %s", rejected, codeInvalidTTL)
+			}
+			wantFailed := 1
+			if tt.trackErr != nil {
+				wantFailed = 2
+			}
+			if got.Failed != wantFailed {
+				t.Errorf("failed = %d, want %d", got.Failed, wantFailed)
+			}
+			if (got.Error != nil) != (tt.wantStatus != http.StatusMultiStatus) {
+				t.Errorf("error = %+v, want one only for a retryable status", got.Error)
+			}
+		})
+	}
+}
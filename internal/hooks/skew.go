@@ -0,0 +1,56 @@
+package hooks
+
+import (
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+)
+
+// WithClockSkew anchors the expiry of a push at its event timestamp instead
+// of the time the event is received, so an event delivered late by a
+// registry queue or retry expires as long after the push as its TTL says.
+// Only timestamps within tolerance of the receipt time are trusted; one
+// further off is taken for a registry clock that is wrong, and the receipt
+// time is used, so an event redelivered after an outage longer than
+// tolerance still expires late. Either way, the expiry is capped at the
+// receipt time plus the maximum TTL. Zero tolerance keeps anchoring at the
+// receipt time. Skews beyond warn are logged; zero disables the warning.
+func WithClockSkew(tolerance, warn time.Duration) HandlerOption {
+	return func(h *Handler) {
+		h.skewTolerance = tolerance
+		h.skewWarn = warn
+	}
+}
+
+// timestamp returns the time the registry stamped on the event, if it
+// carries one that parses.
+func (e RegistryEvent) timestamp() (time.Time, bool) {
+	if e.Timestamp == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, e.Timestamp)
+	return t, err == nil
+}
+
+// pushedAt returns the time the expiry of a push event received at
+// received is anchored at, recording how far the event timestamp is off.
+func (h *Handler) pushedAt(event RegistryEvent, source string, received time.Time) time.Time {
+	stamped, ok := event.timestamp()
+	if !ok {
+		return received
+	}
+	skew := stamped.Sub(received)
+	metrics.WebhookClockSkew.WithLabelValues(sourceLabel(source)).Set(skew.Seconds())
+	if h.skewWarn > 0 && skew.Abs() > h.skewWarn {
+		h.logger.Warn("event timestamp is off from the receipt time, check the registry clock",
+			"image", event.Target.Repository+":"+event.Target.Tag,
+			"source", source,
+			"timestamp", event.Timestamp,
+			"skew", skew.String(),
+		)
+	}
+	if h.skewTolerance > 0 && skew.Abs() <= h.skewTolerance {
+		return stamped
+	}
+	return received
+}
@@ -0,0 +1,105 @@
+package hooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+)
+
+func TestHandler_ClockSkew(t *testing.T) {
+	tests := []struct {
+		name      string
+		tolerance time.Duration
+		offset    time.Duration
+		timestamp string
+		anchored  bool
+	}{
+		{"anchored within tolerance", 5 * time.Minute, -3 * time.Minute, "", true},
+		{"registry clock ahead", 5 * time.Minute, 10 * time.Minute, "", false},
+		{"registry clock behind", 5 * time.Minute, -10 * time.Minute, "", false},
+		{"tolerance disabled", 0, -3 * time.Minute, "", false},
+		{"unparsable timestamp", 5 * time.Minute, 0, "yesterday", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMockStore()
+			handler := NewHandler(store, &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default(),
+				WithClockSkew(tt.tolerance, time.Minute))
+
+			stamped := time.Now().Add(tt.offset)
+			timestamp := tt.timestamp
+			if timestamp == "" {
+				timestamp = stamped.Format(time.RFC3339Nano)
+			}
+			start := time.Now()
+			rr := postSummary(t, handler, "", RegistryEvent{
+				Action:    testPush,
+				Timestamp: timestamp,
+				Target:    EventTarget{Repository: testApp, Tag: "1h"},
+			})
+			if rr.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rr.Code)
+			}
+
+			expiresAt := store.images[testAppTTL]
+			if tt.anchored {
+				if !expiresAt.Equal(stamped.Add(time.Hour)) {
+					t.Errorf("expires at %v, want an hour after the event timestamp %v", expiresAt, stamped)
+				}
+				return
+			}
+			if expiresAt.Before(start.Add(time.Hour)) || expiresAt.After(time.Now().Add(time.Hour)) {
+				t.Errorf("expires at %v, want an hour after receipt at %v", expiresAt, start)
+			}
+		})
+	}
+}
+
+func TestHandler_ClockSkewCappedAtMaxTTL(t *testing.T) {
+	store := newMockStore()
+	handler := NewHandler(store, &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default(),
+		WithClockSkew(5*time.Minute, time.Minute))
+
+	rr := postSummary(t, handler, "", RegistryEvent{
+		Action:    testPush,
+		Timestamp: time.Now().Add(3 * time.Minute).Format(time.RFC3339Nano),
+		Target:    EventTarget{Repository: testApp, Tag: "24h"},
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rr.Code)
+	}
+	if expiresAt := store.images[testApp+":24h"]; expiresAt.After(time.Now().Add(24 * time.Hour)) {
+		t.Errorf("expires at %v, want no later than MAX_TTL after receipt", expiresAt)
+	}
+}
+
+func TestHandler_ClockSkewMetric(t *testing.T) {
+	handler := NewHandler(newMockStore(), &mockRegistry{}, "tok", time.Hour, 24*time.Hour, nil, slog.Default(),
+		WithSourceNames([]string{"skewed"}))
+
+	body, _ := json.Marshal(EventEnvelope{Events: []RegistryEvent{{
+		Action:    testPush,
+		Timestamp: time.Now().Add(-2 * time.Minute).Format(time.RFC3339),
+		Target:    EventTarget{Repository: testApp, Tag: "1h"},
+	}}})
+	req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Token tok")
+	req.Header.Set("X-Registry-Source", "skewed")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rr.Code)
+	}
+	skew := testutil.ToFloat64(metrics.WebhookClockSkew.WithLabelValues("skewed"))
+	if skew > -119 || skew < -125 {
+		t.Errorf("clock skew = %vs, want about -120s", skew)
+	}
+}
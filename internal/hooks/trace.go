@@ -0,0 +1,175 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/policy"
+)
+
+// LevelTrace is the level of decision traces. It sits above Info so that
+// handlers at the default level keep traces once tracing is switched on,
+// yet can still be told apart from, and filtered out of, regular logs.
+const LevelTrace = slog.LevelInfo + 2
+
+// Origins of the TTL limits and of the TTL of a push, as traced.
+const (
+	originGlobal       = "global"
+	originArtifactType = "artifact_type"
+	originCacheRepo    = "cache_repo"
+	originRepoPolicy   = "repo_policy"
+	originTag          = "tag"
+	originDefault      = "default"
+	originPolicy       = "policy"
+	originPolicyDenied = "policy_denied"
+)
+
+// Tracer switches decision tracing on and off at runtime.
+type Tracer struct {
+	enabled atomic.Bool
+	logger  *slog.Logger
+}
+
+// NewTracer returns a Tracer, initially enabled or not.
+func NewTracer(enabled bool, logger *slog.Logger) *Tracer {
+	t := &Tracer{logger: logger}
+	t.enabled.Store(enabled)
+	return t
+}
+
+// Enabled reports whether decisions are traced.
+func (t *Tracer) Enabled() bool {
+	return t != nil && t.enabled.Load()
+}
+
+// Set switches tracing on or off.
+func (t *Tracer) Set(enabled bool) {
+	if t.enabled.Swap(enabled) != enabled {
+		t.logger.Info("decision tracing switched", "enabled", enabled)
+	}
+}
+
+// Handler reports whether tracing is on as {"enabled": bool} on GET and
+// switches it on PUT with the same document.
+func (t *Tracer) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var state struct {
+			Enabled *bool `json:"enabled"`
+		}
+		switch req.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			if err := json.NewDecoder(req.Body).Decode(&state); err != nil || state.Enabled == nil {
+				http.Error(w, `want {"enabled": true|false}`, http.StatusBadRequest)
+				return
+			}
+			t.Set(*state.Enabled)
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		enabled := t.Enabled()
+		state.Enabled = &enabled
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(state)
+	})
+}
+
+// WithDecisionTrace logs the full evaluation of every push at LevelTrace
+// while t is enabled.
+func WithDecisionTrace(t *Tracer) HandlerOption {
+	return func(h *Handler) {
+		h.tracer = t
+	}
+}
+
+// pushTrace collects the evaluation of a push for decision tracing.
+type pushTrace struct {
+	image        string
+	artifactType string
+	digest       string
+	limits       ttlLimits
+	parsedTTL    time.Duration
+	ttl          time.Duration
+	// ttlOrigin is where the TTL came from: originTag, originDefault,
+	// originPolicy or originPolicyDenied.
+	ttlOrigin string
+	clamped   bool
+	// policy is the push policy's decision, nil without a policy.
+	policy *policy.Decision
+	// immutable is why the tag is immutable: "pattern", "policy" or "no".
+	immutable string
+}
+
+// resolve records how the TTL ttl of the push was resolved from the TTL
+// parsed from the tag, the limits and the policy decision.
+func (t *pushTrace) resolve(artifactType, digest string, limits ttlLimits, parsed, ttl time.Duration) {
+	t.artifactType, t.digest, t.limits, t.parsedTTL, t.ttl = artifactType, digest, limits, parsed, ttl
+	switch {
+	case t.policy != nil && !t.policy.Allow:
+		t.ttlOrigin = originPolicyDenied
+	case t.policy != nil && t.policy.TTL > 0:
+		t.ttlOrigin, t.clamped = originPolicy, t.policy.TTL > limits.maxTTL
+	case parsed <= 0:
+		t.ttlOrigin = originDefault
+	default:
+		t.ttlOrigin, t.clamped = originTag, parsed > limits.maxTTL
+	}
+}
+
+// log writes the trace, with err being the outcome of the push.
+func (t *pushTrace) log(ctx context.Context, logger *slog.Logger, err error) {
+	tagTTL := "none"
+	if t.parsedTTL > 0 {
+		tagTTL = t.parsedTTL.String()
+	}
+	attrs := []slog.Attr{
+		slog.String("image", t.image),
+		slog.String("artifact_type", t.artifactType),
+		slog.String("digest", t.digest),
+		slog.Group("ttl",
+			slog.String("tag", tagTTL),
+			slog.String("default", t.limits.defaultTTL.String()),
+			slog.String("default_from", t.limits.defaultFrom),
+			slog.String("max", t.limits.maxTTL.String()),
+			slog.String("max_from", t.limits.maxFrom),
+			slog.String("resolved", t.ttl.String()),
+			slog.String("source", t.ttlOrigin),
+			slog.Bool("clamped", t.clamped),
+		),
+		slog.String("immutable", t.immutable),
+	}
+	if t.policy != nil {
+		attrs = append(attrs, slog.Group("policy",
+			slog.Bool("allow", t.policy.Allow),
+			slog.String("reason", t.policy.Reason),
+			slog.String("ttl", t.policy.TTL.String()),
+			slog.Bool("immutable", t.policy.Immutable),
+			slog.Bool("protected", t.policy.Protected),
+			slog.Int("priority", t.policy.Priority),
+			slog.Bool("fallback", t.policy.Fallback),
+		))
+	}
+	outcome := "tracked"
+	if err != nil {
+		outcome = err.Error()
+	}
+	attrs = append(attrs, slog.String("outcome", outcome))
+	logger.LogAttrs(ctx, LevelTrace, "push decision trace", attrs...)
+}
+
+// immutableReason returns the immutable field of a trace.
+func immutableReason(pattern, policy bool) string {
+	switch {
+	case pattern:
+		return "pattern"
+	case policy:
+		return originPolicy
+	}
+	return "no"
+}
@@ -0,0 +1,113 @@
+package hooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/policy"
+)
+
+func TestHandler_DecisionTrace(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	tracer := NewTracer(false, logger)
+	check := policyFunc(func(policy.Request) policy.Decision {
+		return policy.Decision{Allow: true, TTL: 48 * time.Hour, Immutable: true}
+	})
+	reg := &mockRegistry{digests: map[string]string{testAppTTL: "sha256:abc"}}
+	handler := NewHandler(newMockStore(), reg, "tok", time.Hour, 24*time.Hour, nil, logger,
+		WithPolicy(check), WithDecisionTrace(tracer))
+
+	push := func() {
+		t.Helper()
+		body, _ := json.Marshal(EventEnvelope{Events: []RegistryEvent{
+			{Action: testPush, Target: EventTarget{Repository: testApp, Tag: "1h"}},
+		}})
+		req := httptest.NewRequest(http.MethodPost, "/v1/hook/registry-event", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Token tok")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("push: status %d", rr.Code)
+		}
+	}
+
+	push()
+	if strings.Contains(logs.String(), "push decision trace") {
+		t.Fatal("traced a push while tracing is off")
+	}
+
+	tracer.Set(true)
+	logs.Reset()
+	push()
+	var trace struct {
+		Level   string `json:"level"`
+		Msg     string `json:"msg"`
+		Digest  string `json:"digest"`
+		Outcome string `json:"outcome"`
+		TTL     struct {
+			Tag, Max, Resolved, Source string
+			Clamped                    bool
+		} `json:"ttl"`
+		Policy struct {
+			Allow bool
+			TTL   string
+		} `json:"policy"`
+		Immutable string `json:"immutable"`
+	}
+	for line := range strings.Lines(logs.String()) {
+		if strings.Contains(line, "push decision trace") {
+			if err := json.Unmarshal([]byte(line), &trace); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if trace.Msg == "" {
+		t.Fatalf("no trace logged: %s", logs.String())
+	}
+	if trace.Level != "INFO+2" || trace.Digest != "sha256:abc" || trace.Outcome != "tracked" ||
+		trace.Immutable != "policy" || !trace.Policy.Allow || trace.Policy.TTL != "48h0m0s" {
+		t.Errorf("trace = %+v", trace)
+	}
+	if trace.TTL.Tag != "1h0m0s" || trace.TTL.Max != "24h0m0s" || trace.TTL.Resolved != "24h0m0s" ||
+		trace.TTL.Source != originPolicy || !trace.TTL.Clamped {
+		t.Errorf("trace TTL = %+v, want the policy TTL clamped to the max", trace.TTL)
+	}
+}
+
+func TestTracer_Handler(t *testing.T) {
+	tracer := NewTracer(false, slog.New(slog.DiscardHandler))
+	do := func(method, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		tracer.Handler().ServeHTTP(rr, httptest.NewRequest(method, "/v1/api/debug/decision-trace",
+			strings.NewReader(body)))
+		return rr
+	}
+
+	if rr := do(http.MethodPut, `{"enabled":true}`); rr.Code != http.StatusOK ||
+		strings.TrimSpace(rr.Body.String()) != `{"enabled":true}` || !tracer.Enabled() {
+		t.Errorf("PUT: %d %s, enabled %v", rr.Code, rr.Body, tracer.Enabled())
+	}
+	if rr := do(http.MethodGet, ""); strings.TrimSpace(rr.Body.String()) != `{"enabled":true}` {
+		t.Errorf("GET: %s", rr.Body)
+	}
+	if rr := do(http.MethodPut, `{}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("PUT without enabled: status %d, want 400", rr.Code)
+	}
+	if rr := do(http.MethodPost, `{"enabled":false}`); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, want 405", rr.Code)
+	}
+	if !tracer.Enabled() {
+		t.Error("rejected requests changed the state")
+	}
+	var off *Tracer
+	if off.Enabled() {
+		t.Error("nil Tracer is enabled")
+	}
+}
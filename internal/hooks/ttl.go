@@ -3,9 +3,20 @@ package hooks
 import (
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// Actions for pushes whose tag is not a TTL; see WithNonTTLTags.
+const (
+	NonTTLTrack  = "track"
+	NonTTLIgnore = "ignore"
+	NonTTLReject = "reject"
+)
+
+// NonTTLActions lists the actions for pushes whose tag is not a TTL.
+var NonTTLActions = []string{NonTTLTrack, NonTTLIgnore, NonTTLReject}
+
 // durationPattern matches tags like "5m", "1h", "24h", "1d", "1w", "30m", "1h30m".
 var durationPattern = regexp.MustCompile(
 	`^(?:(\d+)w)?(?:(\d+)d)?(?:(\d+)h)?(?:(\d+)m)?(?:(\d+)s)?$`,
@@ -70,3 +81,65 @@ func ClampTTL(d, defaultTTL, maxTTL time.Duration) time.Duration {
 	}
 	return d
 }
+
+// ttlUnits is the lexicon of time units in tags meant as TTLs, mapped to
+// their length.
+var ttlUnits = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "secs": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "wk": 7 * 24 * time.Hour, "wks": 7 * 24 * time.Hour,
+	"week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+}
+
+// ttlLikePattern matches tags made only of number and word pairs, like
+// "2hours" or "1Hour30Mins".
+var ttlLikePattern = regexp.MustCompile(`^(?:\d+[a-zA-Z]+)+$`)
+
+// ttlTermPattern matches one number and word pair of such a tag.
+var ttlTermPattern = regexp.MustCompile(`(\d+)([a-zA-Z]+)`)
+
+// MalformedTTL reports whether tag is meant as a TTL, being made only of
+// numbers and time units from the lexicon, yet ParseTTL does not accept it
+// or reads it as zero. suggestion is the same duration as ParseTTL accepts
+// it, or empty if there is none.
+func MalformedTTL(tag string) (suggestion string, malformed bool) {
+	if ParseTTL(tag) > 0 || !ttlLikePattern.MatchString(tag) {
+		return "", false
+	}
+	var d time.Duration
+	for _, term := range ttlTermPattern.FindAllStringSubmatch(tag, -1) {
+		unit, ok := ttlUnits[strings.ToLower(term[2])]
+		if !ok {
+			return "", false
+		}
+		n, err := strconv.Atoi(term[1])
+		if err != nil {
+			return "", true
+		}
+		d += time.Duration(n) * unit
+	}
+	if d <= 0 {
+		return "", true
+	}
+	return formatTTL(d), true
+}
+
+// formatTTL formats d as a tag ParseTTL reads back, like "1h30m". It
+// drops fractions of a second.
+func formatTTL(d time.Duration) string {
+	var b strings.Builder
+	for _, u := range []struct {
+		unit string
+		d    time.Duration
+	}{
+		{"w", 7 * 24 * time.Hour}, {"d", 24 * time.Hour}, {"h", time.Hour}, {"m", time.Minute}, {"s", time.Second},
+	} {
+		if n := d / u.d; n > 0 {
+			b.WriteString(strconv.FormatInt(int64(n), 10) + u.unit)
+			d -= n * u.d
+		}
+	}
+	return b.String()
+}
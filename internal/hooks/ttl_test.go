@@ -39,6 +39,43 @@ func TestParseTTL(t *testing.T) {
 	}
 }
 
+func TestMalformedTTL(t *testing.T) {
+	tests := []struct {
+		tag        string
+		suggestion string
+		malformed  bool
+	}{
+		{"2hours", "2h", true},
+		{"90minutes", "1h30m", true},
+		{"1H", "1h", true},
+		{"1Hour30Mins", "1h30m", true},
+		{"30m1h", "1h30m", true},
+		{"14days", "2w", true},
+		{"0h", "", true},
+		// Valid TTLs and tags not meant as TTLs
+		{"2h", "", false},
+		{"1h30m", "", false},
+		{"latest", "", false},
+		{"v1.0.0", "", false},
+		{"20240101build", "", false},
+		{"1alpha", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			suggestion, malformed := MalformedTTL(tt.tag)
+			if suggestion != tt.suggestion || malformed != tt.malformed {
+				t.Errorf("MalformedTTL(%q) = %q, %v; want %q, %v",
+					tt.tag, suggestion, malformed, tt.suggestion, tt.malformed)
+			}
+			if suggestion != "" && ParseTTL(suggestion) <= 0 {
+				t.Errorf("suggestion %q does not parse", suggestion)
+			}
+		})
+	}
+}
+
 func TestClampTTL(t *testing.T) {
 	defaultTTL := time.Hour
 	maxTTL := 24 * time.Hour
@@ -0,0 +1,240 @@
+// Package imagedebug dumps everything ephemeron knows about a single image,
+// for answering "why wasn't this reaped?" without reading Redis by hand.
+package imagedebug
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/hooks"
+	"github.com/tamcore/ephemeron/internal/reaper"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+// DefaultRateLimit is the default number of dumps served per minute.
+const DefaultRateLimit = 10
+
+// Dump is the document served by Handler. Parts that could not be read
+// carry an error instead of failing the whole dump.
+type Dump struct {
+	Image string `json:"image"`
+	// Record holds the fields of the image's store record as stored, with
+	// encrypted fields decrypted. It is empty for untracked images.
+	Record      map[string]string `json:"record"`
+	RecordError string            `json:"record_error,omitempty"`
+	Registry    RegistryHead      `json:"registry"`
+	Decision    Decision          `json:"decision"`
+	TTL         TTLResolution     `json:"ttl"`
+}
+
+// RegistryHead is what the registry reports for the image's tag.
+type RegistryHead struct {
+	Found        bool   `json:"found"`
+	Digest       string `json:"digest,omitempty"`
+	ArtifactType string `json:"artifact_type,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// Decision is the reaper's decision trace for the image.
+type Decision struct {
+	reaper.Explanation
+	Error string `json:"error,omitempty"`
+}
+
+// TTLResolution is how a push of the image would resolve its TTL now,
+// next to the TTL it was tracked with.
+type TTLResolution struct {
+	Steps    []hooks.TTLStep `json:"steps"`
+	Resolved string          `json:"resolved"`
+	// Tracked is the time between tracking and expiry in the record.
+	Tracked string `json:"tracked,omitempty"`
+	Note    string `json:"note,omitempty"`
+}
+
+// Registry resolves tags in the registry.
+type Registry interface {
+	ManifestDigest(ctx context.Context, repo, ref string) (digest string, found bool, err error)
+	GetImageManifestInfo(ctx context.Context, repo, tag string) (*registry.ManifestInfo, error)
+}
+
+// Explainer traces the reaper's decision about an image.
+type Explainer interface {
+	Explain(ctx context.Context, image string) (reaper.Explanation, error)
+}
+
+// TTLExplainer traces the TTL resolution of a push.
+type TTLExplainer interface {
+	ExplainTTL(repo, tag, artifactType string) ([]hooks.TTLStep, time.Duration)
+}
+
+// Service builds image dumps.
+type Service struct {
+	store    redisclient.Store
+	registry Registry
+	reaper   Explainer
+	ttls     TTLExplainer
+	logger   *slog.Logger
+	limiter  *limiter
+}
+
+// Option configures a Service.
+type Option func(*Service)
+
+// WithRateLimit serves at most perMinute dumps per minute, in bursts of up
+// to perMinute. The default is DefaultRateLimit.
+func WithRateLimit(perMinute int) Option {
+	return func(s *Service) {
+		s.limiter = newLimiter(perMinute, time.Now)
+	}
+}
+
+// New returns a Service dumping images from store and registry, with the
+// decisions of reaper and the TTL resolution of ttls.
+func New(
+	store redisclient.Store,
+	registry Registry,
+	reaper Explainer,
+	ttls TTLExplainer,
+	logger *slog.Logger,
+	opts ...Option,
+) *Service {
+	s := &Service{
+		store:    store,
+		registry: registry,
+		reaper:   reaper,
+		ttls:     ttls,
+		logger:   logger,
+		limiter:  newLimiter(DefaultRateLimit, time.Now),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Dump collects everything known about repo:tag.
+func (s *Service) Dump(ctx context.Context, repo, tag string) Dump {
+	image := repo + ":" + tag
+	d := Dump{Image: image, Record: map[string]string{}}
+
+	if record, err := s.store.GetImageRecord(ctx, image); err != nil {
+		d.RecordError = err.Error()
+	} else {
+		d.Record = record
+	}
+
+	artifactType := registry.ArtifactImage
+	digest, found, err := s.registry.ManifestDigest(ctx, repo, tag)
+	d.Registry = RegistryHead{Found: found, Digest: digest}
+	if err != nil {
+		d.Registry.Error = err.Error()
+	} else if found {
+		// The artifact type picks the default TTL; it is not in the HEAD
+		// response.
+		if info, err := s.registry.GetImageManifestInfo(ctx, repo, tag); err != nil {
+			d.Registry.Error = err.Error()
+		} else if info.ArtifactType != "" {
+			artifactType = info.ArtifactType
+		}
+		d.Registry.ArtifactType = artifactType
+	}
+
+	if e, err := s.reaper.Explain(ctx, image); err != nil {
+		d.Decision.Error = err.Error()
+	} else {
+		d.Decision.Explanation = e
+	}
+
+	steps, resolved := s.ttls.ExplainTTL(repo, tag, artifactType)
+	d.TTL = TTLResolution{Steps: steps, Resolved: resolved.String()}
+	if tracked, ok := trackedTTL(d.Record); ok {
+		d.TTL.Tracked = tracked.String()
+		if (tracked - resolved).Abs() > time.Second {
+			d.TTL.Note = "the tracked TTL differs from the resolved one: the expiry was changed since, " +
+				"a push policy set it, or the configuration or rules changed"
+		}
+	}
+	return d
+}
+
+// trackedTTL returns the time between the created and expires fields of
+// record.
+func trackedTTL(record map[string]string) (time.Duration, bool) {
+	created, err := strconv.ParseInt(record["created"], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	expires, err := strconv.ParseInt(record["expires"], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(expires-created) * time.Millisecond, true
+}
+
+// Handler serves the Dump of the image named by the path wildcard "image",
+// "<repo>/<tag>/debug", as JSON. Requests beyond the rate limit are
+// answered with 429 Too Many Requests, as every dump queries the registry.
+func (s *Service) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path, ok := strings.CutSuffix(req.PathValue("image"), "/debug")
+		i := strings.LastIndex(path, "/")
+		if !ok || i <= 0 || i == len(path)-1 {
+			http.NotFound(w, req)
+			return
+		}
+		repo, tag := path[:i], path[i+1:]
+
+		if wait, ok := s.limiter.allow(); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "rate limit exceeded, retry later", http.StatusTooManyRequests)
+			return
+		}
+		s.logger.Info("dumping image record", "image", repo+":"+tag)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s.Dump(req.Context(), repo, tag))
+	})
+}
+
+// limiter is a token bucket refilled at a fixed rate.
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration // time to earn one token
+	burst    float64
+	tokens   float64
+	last     time.Time
+	now      func() time.Time
+}
+
+func newLimiter(perMinute int, now func() time.Time) *limiter {
+	n := max(perMinute, 1)
+	return &limiter{
+		interval: time.Minute / time.Duration(n),
+		burst:    float64(n),
+		tokens:   float64(n),
+		last:     now(),
+		now:      now,
+	}
+}
+
+// allow takes a token if one is available, or reports how long until one
+// is.
+func (l *limiter) allow() (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.tokens = min(l.burst, l.tokens+float64(now.Sub(l.last))/float64(l.interval))
+	l.last = now
+	if l.tokens < 1 {
+		return time.Duration((1 - l.tokens) * float64(l.interval)), false
+	}
+	l.tokens--
+	return 0, true
+}
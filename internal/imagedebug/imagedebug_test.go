@@ -0,0 +1,109 @@
+package imagedebug
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/hooks"
+	"github.com/tamcore/ephemeron/internal/memregistry"
+	"github.com/tamcore/ephemeron/internal/memstore"
+	"github.com/tamcore/ephemeron/internal/reaper"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+func TestHandler(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	memReg := memregistry.New(nil, logger)
+	srv := httptest.NewServer(memReg)
+	defer srv.Close()
+	digest := memReg.PushImage("team/app", "1h", []byte("layer"))
+
+	store := memstore.New()
+	meta := redisclient.ImageMeta{Actor: "ci-bot"}
+	if err := store.TrackImage(t.Context(), "team/app:1h", time.Now().Add(time.Hour), 5, digest, meta); err != nil {
+		t.Fatal(err)
+	}
+	reg := registry.New(srv.URL)
+	r := reaper.New(store, srv.URL, logger)
+	h := hooks.NewHandler(store, reg, "tok", 24*time.Hour, 7*24*time.Hour, nil, logger)
+	mux := http.NewServeMux()
+	mux.Handle("GET /v1/api/images/{image...}", New(store, reg, r, h, logger, WithRateLimit(2)).Handler())
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+
+	rr := get("/v1/api/images/team/app/1h/debug")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rr.Code, rr.Body)
+	}
+	var d Dump
+	if err := json.Unmarshal(rr.Body.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Image != "team/app:1h" || d.Record["digest"] != digest || d.Record["actor"] != "ci-bot" {
+		t.Errorf("record = %v, want the tracked fields", d.Record)
+	}
+	if !d.Registry.Found || d.Registry.Digest != digest || d.Registry.ArtifactType != registry.ArtifactImage {
+		t.Errorf("registry = %+v, want the pushed manifest", d.Registry)
+	}
+	if d.Decision.Verdict != reaper.VerdictNotExpired {
+		t.Errorf("decision = %+v, want not expired", d.Decision)
+	}
+	if d.TTL.Resolved != "1h0m0s" || d.TTL.Tracked != "1h0m0s" || d.TTL.Note != "" || len(d.TTL.Steps) != 2 {
+		t.Errorf("ttl = %+v, want 1h resolved and tracked", d.TTL)
+	}
+
+	var untracked Dump
+	rr = get("/v1/api/images/team/app/latest/debug")
+	if err := json.Unmarshal(rr.Body.Bytes(), &untracked); err != nil {
+		t.Fatal(err)
+	}
+	if len(untracked.Record) != 0 || untracked.Registry.Found || untracked.Decision.Verdict != reaper.VerdictUntracked {
+		t.Errorf("untracked dump = %+v", untracked)
+	}
+
+	if rr := get("/v1/api/images/team/app/1h/debug"); rr.Code != http.StatusTooManyRequests ||
+		rr.Header().Get("Retry-After") == "" {
+		t.Errorf("third request: status = %d, Retry-After %q; want 429 with Retry-After",
+			rr.Code, rr.Header().Get("Retry-After"))
+	}
+	for _, path := range []string{"/v1/api/images/app/debug", "/v1/api/images/team/app/1h"} {
+		if rr := get(path); rr.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status = %d, want 404", path, rr.Code)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newLimiter(6, func() time.Time { return now })
+	for i := range 6 {
+		if _, ok := l.allow(); !ok {
+			t.Fatalf("request %d refused within the burst", i)
+		}
+	}
+	if wait, ok := l.allow(); ok || wait != 10*time.Second {
+		t.Errorf("allow() = %v, %v; want a 10s wait", wait, ok)
+	}
+	now = now.Add(10 * time.Second)
+	if _, ok := l.allow(); !ok {
+		t.Error("request refused after a token was earned")
+	}
+	now = now.Add(time.Hour)
+	for i := range 6 {
+		if _, ok := l.allow(); !ok {
+			t.Fatalf("request %d refused after idling", i)
+		}
+	}
+	if _, ok := l.allow(); ok {
+		t.Error("burst exceeded the limit after idling")
+	}
+}
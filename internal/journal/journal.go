@@ -0,0 +1,339 @@
+// Package journal provides an append-only, on-disk log of webhook events.
+// It backs two features: fail-open mode, where events that could not be
+// written to the store are kept for later replay, and write-ahead mode,
+// where every accepted event is journaled before the store write so a
+// crash in between cannot lose it.
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// compactEvery is the number of completed entries after which the journal
+// file is rewritten to hold only pending entries.
+const compactEvery = 1000
+
+// Entry is a single journaled TrackImage call.
+type Entry struct {
+	ID         uint64    `json:"id"`
+	Image      string    `json:"image"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	SizeBytes  int64     `json:"size_bytes"`
+	Digest     string    `json:"digest"`
+	ReceivedAt time.Time `json:"received_at"`
+
+	Meta redisclient.ImageMeta `json:"meta"`
+}
+
+// Store is the subset of the image store needed to replay entries.
+type Store interface {
+	Ping(ctx context.Context) error
+	TrackImage(
+		ctx context.Context,
+		imageWithTag string,
+		expiresAt time.Time,
+		sizeBytes int64,
+		digest string,
+		meta redisclient.ImageMeta,
+	) error
+}
+
+// record is a single journal line: an Entry, the completion of an earlier
+// entry, or the highest ID issued before the journal was last compacted.
+type record struct {
+	*Entry
+	Complete uint64 `json:"complete,omitempty"`
+	LastID   uint64 `json:"last_id,omitempty"`
+}
+
+// Journal is a JSON-lines file of entries and completion markers. It is
+// safe for concurrent use within a single process.
+type Journal struct {
+	mu        sync.Mutex
+	path      string
+	lastID    uint64
+	inFlight  map[uint64]bool
+	completed int
+}
+
+// Open returns a journal backed by path, creating the file if needed. A
+// torn final line left by a crash is truncated so the next record starts
+// on a line of its own.
+func Open(path string) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening journal: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("opening journal: %w", err)
+	}
+	if err := truncateTorn(path); err != nil {
+		return nil, err
+	}
+
+	j := &Journal{path: path, inFlight: make(map[uint64]bool)}
+	if _, err := j.read(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Append durably writes e to the end of the journal and returns its ID.
+// The entry is considered in flight, and skipped by Replay, until
+// Complete or Release is called.
+func (j *Journal) Append(e Entry) (uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.lastID++
+	e.ID = j.lastID
+	if err := j.appendRecord(record{Entry: &e}); err != nil {
+		return 0, err
+	}
+	j.inFlight[e.ID] = true
+	return e.ID, nil
+}
+
+// Complete marks an entry as persisted (or deliberately dropped) so it is
+// never replayed.
+func (j *Journal) Complete(id uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	delete(j.inFlight, id)
+	if err := j.appendRecord(record{Complete: id}); err != nil {
+		return err
+	}
+	j.completed++
+	if j.completed < compactEvery {
+		return nil
+	}
+	entries, err := j.read()
+	if err != nil {
+		return err
+	}
+	return j.write(entries)
+}
+
+// Release hands an in-flight entry over to Replay without completing it.
+func (j *Journal) Release(id uint64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.inFlight, id)
+}
+
+// Pending returns the entries that have not been completed, including
+// entries still in flight.
+func (j *Journal) Pending() ([]Entry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.read()
+}
+
+// Replay writes all pending entries that are not in flight to store in
+// order. Entries written successfully are removed from the journal; on the
+// first failure the remaining entries are kept for the next attempt. It
+// returns the number of entries replayed.
+func (j *Journal) Replay(ctx context.Context, store Store) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entries, err := j.read()
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+
+	var n int
+	var replayErr error
+	remaining := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		if j.inFlight[e.ID] || replayErr != nil {
+			remaining = append(remaining, e)
+			continue
+		}
+		if err := store.TrackImage(ctx, e.Image, e.ExpiresAt, e.SizeBytes, e.Digest, e.Meta); err != nil {
+			replayErr = fmt.Errorf("replaying %s: %w", e.Image, err)
+			remaining = append(remaining, e)
+			continue
+		}
+		n++
+	}
+	metrics.JournalReplayed.Add(float64(n))
+	metrics.JournalPendingEvents.Set(float64(len(remaining)))
+
+	if err := j.write(remaining); err != nil {
+		return n, err
+	}
+	return n, replayErr
+}
+
+// Start replays the entries journaled before a restart to store and then,
+// with a positive interval, keeps replaying new ones every interval in the
+// background until ctx is cancelled.
+func (j *Journal) Start(ctx context.Context, store Store, interval time.Duration, logger *slog.Logger) {
+	if n, err := j.Replay(ctx, store); err != nil {
+		logger.Error("journal replay incomplete", "replayed", n, "error", err)
+	} else if n > 0 {
+		logger.Info("replayed journaled events", "replayed", n)
+	}
+	if interval > 0 {
+		go j.ReplayLoop(ctx, store, interval, logger)
+	}
+}
+
+// ReplayLoop replays pending entries at the given interval whenever the
+// store is reachable. It blocks until ctx is cancelled.
+func (j *Journal) ReplayLoop(ctx context.Context, store Store, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		j.replayIfReachable(ctx, store, logger)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *Journal) replayIfReachable(ctx context.Context, store Store, logger *slog.Logger) {
+	pending, err := j.Pending()
+	if err != nil {
+		logger.Error("failed to read journal", "error", err)
+		return
+	}
+	metrics.JournalPendingEvents.Set(float64(len(pending)))
+	if len(pending) == 0 || store.Ping(ctx) != nil {
+		return
+	}
+	n, err := j.Replay(ctx, store)
+	if err != nil {
+		logger.Error("journal replay incomplete", "replayed", n, "error", err)
+		return
+	}
+	if n > 0 {
+		logger.Info("replayed journaled events", "replayed", n)
+	}
+}
+
+// appendRecord durably writes r to the end of the journal. Callers must
+// hold j.mu.
+func (j *Journal) appendRecord(r record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening journal: %w", err)
+	}
+	if _, err := f.Write(line); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("writing journal: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("syncing journal: %w", err)
+	}
+	return f.Close()
+}
+
+// read parses the journal file and returns the entries that have not been
+// completed. It raises j.lastID to the highest ID on any record, so IDs
+// are never reused while a completion marker for them may remain. Callers
+// must hold j.mu.
+func (j *Journal) read() ([]Entry, error) {
+	data, err := os.ReadFile(j.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading journal: %w", err)
+	}
+
+	var entries []Entry
+	done := make(map[uint64]bool)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var r record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			// A torn write from a crash; the records around it are intact.
+			continue
+		}
+		if r.Entry == nil {
+			j.lastID = max(j.lastID, r.Complete, r.LastID)
+			if r.Complete != 0 {
+				done[r.Complete] = true
+			}
+			continue
+		}
+		j.lastID = max(j.lastID, r.ID)
+		entries = append(entries, *r.Entry)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(entries, func(e Entry) bool { return done[e.ID] }), nil
+}
+
+// truncateTorn cuts the journal at path back to its last complete line.
+func truncateTorn(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading journal: %w", err)
+	}
+	if len(data) == 0 || data[len(data)-1] == '\n' {
+		return nil
+	}
+	if err := os.Truncate(path, int64(bytes.LastIndexByte(data, '\n')+1)); err != nil {
+		return fmt.Errorf("truncating torn journal write: %w", err)
+	}
+	return nil
+}
+
+// write atomically replaces the journal with entries, dropping completion
+// markers in favour of a single high-water mark. Callers must hold j.mu.
+func (j *Journal) write(entries []Entry) error {
+	j.completed = 0
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if j.lastID > 0 {
+		if err := enc.Encode(record{LastID: j.lastID}); err != nil {
+			return err
+		}
+	}
+	for _, e := range entries {
+		if err := enc.Encode(record{Entry: &e}); err != nil {
+			return err
+		}
+	}
+
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("rewriting journal: %w", err)
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return fmt.Errorf("rewriting journal: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package journal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// failingStore rejects TrackImage for images in fail.
+type failingStore struct {
+	*memstore.Store
+	fail map[string]bool
+}
+
+func (s failingStore) TrackImage(
+	ctx context.Context,
+	image string,
+	exp time.Time,
+	size int64,
+	digest string,
+	meta redisclient.ImageMeta,
+) error {
+	if s.fail[image] {
+		return errors.New("store down")
+	}
+	return s.Store.TrackImage(ctx, image, exp, size, digest, meta)
+}
+
+func open(t *testing.T) *Journal {
+	t.Helper()
+	j, err := Open(filepath.Join(t.TempDir(), "journal.jsonl"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return j
+}
+
+func appendAll(t *testing.T, j *Journal, images ...string) {
+	t.Helper()
+	for _, image := range images {
+		id, err := j.Append(Entry{Image: image, ExpiresAt: time.Now().Add(time.Hour), SizeBytes: 10})
+		if err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		j.Release(id)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	j := open(t)
+	appendAll(t, j, "a:1h", "b:1h")
+
+	store := memstore.New()
+	n, err := j.Replay(t.Context(), store)
+	if err != nil || n != 2 {
+		t.Fatalf("Replay = %d, %v; want 2, nil", n, err)
+	}
+	if size, _ := store.GetImageSize(t.Context(), "b:1h"); size != 10 {
+		t.Errorf("expected replayed size 10, got %d", size)
+	}
+	if pending, _ := j.Pending(); len(pending) != 0 {
+		t.Errorf("expected empty journal after replay, got %d entries", len(pending))
+	}
+}
+
+func TestReplay_KeepsRemainingOnFailure(t *testing.T) {
+	j := open(t)
+	appendAll(t, j, "a:1h", "b:1h", "c:1h")
+
+	store := failingStore{Store: memstore.New(), fail: map[string]bool{"b:1h": true}}
+	n, err := j.Replay(t.Context(), store)
+	if err == nil || n != 1 {
+		t.Fatalf("Replay = %d, %v; want 1, error", n, err)
+	}
+	pending, _ := j.Pending()
+	if len(pending) != 2 || pending[0].Image != "b:1h" {
+		t.Fatalf("expected b:1h and c:1h to remain, got %+v", pending)
+	}
+}
+
+func TestPending_IgnoresTornWrite(t *testing.T) {
+	j := open(t)
+	appendAll(t, j, "a:1h")
+
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"image":"b:1`)
+	_ = f.Close()
+
+	pending, err := j.Pending()
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("Pending = %d entries, %v; want 1, nil", len(pending), err)
+	}
+}
+
+func TestOpen_KeepsRecordsAfterTornWrite(t *testing.T) {
+	j := open(t)
+	appendAll(t, j, "a:1h")
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"image":"b:1`)
+	_ = f.Close()
+
+	reopened, err := Open(j.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendAll(t, reopened, "c:1h")
+	pending, err := reopened.Pending()
+	if err != nil || len(pending) != 2 || pending[1].Image != "c:1h" {
+		t.Fatalf("Pending = %+v, %v; want a:1h and c:1h", pending, err)
+	}
+}
+
+func TestPending_SkipsCorruptLine(t *testing.T) {
+	j := open(t)
+	appendAll(t, j, "a:1h")
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("{\"image\":\"b:1\n")
+	_ = f.Close()
+	appendAll(t, j, "c:1h")
+
+	pending, err := j.Pending()
+	if err != nil || len(pending) != 2 || pending[1].Image != "c:1h" {
+		t.Fatalf("Pending = %+v, %v; want a:1h and c:1h", pending, err)
+	}
+}
+
+func TestReplayLoop_StopsOnCancel(t *testing.T) {
+	j := open(t)
+	appendAll(t, j, "a:1h")
+	store := memstore.New()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		j.ReplayLoop(ctx, store, time.Hour, slog.Default())
+		close(done)
+	}()
+
+	deadline := time.After(time.Second)
+	for {
+		if n, _ := store.ImageCount(t.Context()); n == 1 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("journal was not replayed")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	cancel()
+	<-done
+}
+
+func TestReplay_SkipsInFlight(t *testing.T) {
+	j := open(t)
+	id, err := j.Append(Entry{Image: "a:1h", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	store := memstore.New()
+	if n, err := j.Replay(t.Context(), store); err != nil || n != 0 {
+		t.Fatalf("Replay = %d, %v; want in-flight entry skipped", n, err)
+	}
+	if err := j.Complete(id); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if pending, _ := j.Pending(); len(pending) != 0 {
+		t.Fatalf("expected no pending entries after Complete, got %+v", pending)
+	}
+}
+
+func TestOpen_RecoversUncompletedEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := j.Append(Entry{Image: "a:1h"})
+	_, _ = j.Append(Entry{Image: "b:1h"})
+	if err := j.Complete(first); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash: reopen without completing b:1h.
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, _ := reopened.Pending()
+	if len(pending) != 1 || pending[0].Image != "b:1h" {
+		t.Fatalf("expected b:1h to be pending after restart, got %+v", pending)
+	}
+	if id, _ := reopened.Append(Entry{Image: "c:1h"}); id != 3 {
+		t.Errorf("expected IDs to continue at 3, got %d", id)
+	}
+}
+
+func TestComplete_Compacts(t *testing.T) {
+	j := open(t)
+	for range compactEvery {
+		id, err := j.Append(Entry{Image: "a:1h"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := j.Complete(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(j.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("{\"last_id\":%d}\n", compactEvery); string(data) != want {
+		t.Errorf("expected compacted journal to hold only the last ID, got %q", data)
+	}
+}
+
+func TestOpen_ContinuesIDsAfterCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range compactEvery {
+		id, _ := j.Append(Entry{Image: "a:1h"})
+		if err := j.Complete(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A completion marker for an ID the reopened journal must not reissue.
+	id, _ := j.Append(Entry{Image: "b:1h"})
+	if err := j.Complete(id); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, _ := reopened.Append(Entry{Image: "c:1h"})
+	if next <= id {
+		t.Fatalf("expected an ID above %d, got %d", id, next)
+	}
+	pending, _ := reopened.Pending()
+	if len(pending) != 1 || pending[0].Image != "c:1h" {
+		t.Errorf("expected c:1h to be pending, got %+v", pending)
+	}
+}
+
+func TestStart_ReplaysPending(t *testing.T) {
+	j := open(t)
+	appendAll(t, j, "a:1h")
+
+	store := memstore.New()
+	j.Start(t.Context(), store, 0, slog.New(slog.DiscardHandler))
+	if size, _ := store.GetImageSize(t.Context(), "a:1h"); size != 10 {
+		t.Error("expected the pending entry to be replayed on start")
+	}
+}
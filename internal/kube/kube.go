@@ -0,0 +1,354 @@
+// Package kube finds the registry images still referenced by workloads in a
+// Kubernetes cluster, so the reaper can leave them in place while a Pod,
+// Deployment or CronJob would fail to pull them again. It talks to the API
+// server with the Pod's service account instead of pulling in client-go.
+package kube
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Paths of the in-cluster service account credentials.
+const (
+	tokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	caPath    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// pageSize is the number of objects requested per list call.
+const pageSize = 500
+
+// ErrStale is returned by InUse when no scan has succeeded recently enough
+// to trust its result.
+var ErrStale = errors.New("workload scan is stale")
+
+// kinds maps the supported workload kinds to their API group path.
+var kinds = map[string]string{
+	"pods":         "/api/v1",
+	"replicasets":  "/apis/apps/v1",
+	"deployments":  "/apis/apps/v1",
+	"statefulsets": "/apis/apps/v1",
+	"daemonsets":   "/apis/apps/v1",
+	"jobs":         "/apis/batch/v1",
+	"cronjobs":     "/apis/batch/v1",
+}
+
+// DefaultKinds are the workload kinds scanned unless configured otherwise.
+var DefaultKinds = []string{"pods", "deployments", "statefulsets", "daemonsets", "cronjobs"}
+
+// ValidKind reports whether kind is a supported workload kind.
+func ValidKind(kind string) bool {
+	_, ok := kinds[kind]
+	return ok
+}
+
+// Config selects the workloads to scan.
+type Config struct {
+	// Kinds are the workload kinds to list, e.g. "pods" or "deployments".
+	Kinds []string
+	// Namespaces limits the scan to these namespaces; empty scans all.
+	Namespaces []string
+	// LabelSelector filters the listed workloads, e.g. "env!=prod".
+	LabelSelector string
+	// RegistryHosts are the host names workloads pull this registry's
+	// images by. References to other registries are ignored.
+	RegistryHosts []string
+}
+
+// Scanner periodically lists workloads and remembers the images they
+// reference.
+type Scanner struct {
+	apiURL string
+	client *http.Client
+	token  func() (string, error)
+	cfg    Config
+	logger *slog.Logger
+	now    func() time.Time
+
+	// maxAge is how old the last successful scan may be before InUse
+	// refuses to answer.
+	maxAge time.Duration
+
+	mu        sync.RWMutex
+	refs      map[string]struct{}
+	scannedAt time.Time
+}
+
+// NewInCluster returns a Scanner using the service account the process runs
+// under. maxAge bounds how long the result of a scan is trusted.
+func NewInCluster(cfg Config, maxAge time.Duration, logger *slog.Logger) (*Scanner, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a cluster: KUBERNETES_SERVICE_HOST/PORT not set")
+	}
+	ca, err := os.ReadFile(caPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading cluster CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in %s", caPath)
+	}
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		},
+	}
+	// The token is re-read on every scan since the kubelet rotates it.
+	token := func() (string, error) {
+		b, err := os.ReadFile(tokenPath)
+		return strings.TrimSpace(string(b)), err
+	}
+	return New("https://"+net.JoinHostPort(host, port), client, token, cfg, maxAge, logger), nil
+}
+
+// New returns a Scanner listing workloads from the API server at apiURL,
+// authenticating with the bearer token returned by token.
+func New(
+	apiURL string,
+	client *http.Client,
+	token func() (string, error),
+	cfg Config,
+	maxAge time.Duration,
+	logger *slog.Logger,
+) *Scanner {
+	if len(cfg.Kinds) == 0 {
+		cfg.Kinds = DefaultKinds
+	}
+	return &Scanner{
+		apiURL: strings.TrimRight(apiURL, "/"),
+		client: client,
+		token:  token,
+		cfg:    cfg,
+		logger: logger,
+		now:    time.Now,
+		maxAge: maxAge,
+	}
+}
+
+// RunLoop scans immediately and then at the given interval until ctx is
+// cancelled.
+func (s *Scanner) RunLoop(ctx context.Context, interval time.Duration) {
+	s.logger.Info("starting workload scanner", "interval", interval.String(), "kinds", s.cfg.Kinds)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Scan(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Error("workload scan failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan lists the configured workloads and replaces the set of referenced
+// images. On error the previous set is kept until it goes stale.
+func (s *Scanner) Scan(ctx context.Context) error {
+	refs := make(map[string]struct{})
+	namespaces := s.cfg.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+	for _, kind := range s.cfg.Kinds {
+		for _, ns := range namespaces {
+			if err := s.list(ctx, kind, ns, refs); err != nil {
+				return fmt.Errorf("listing %s: %w", kind, err)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.refs, s.scannedAt = refs, s.now()
+	s.mu.Unlock()
+	s.logger.Debug("workload scan finished", "references", len(refs))
+	return nil
+}
+
+// InUse reports whether a scanned workload references image, given as
+// "repo:tag", or its manifest digest. It returns ErrStale when the last
+// successful scan is older than the configured maximum age.
+func (s *Scanner) InUse(image, digest string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.scannedAt.IsZero() || s.now().Sub(s.scannedAt) > s.maxAge {
+		return false, ErrStale
+	}
+	if _, ok := s.refs[image]; ok {
+		return true, nil
+	}
+	if digest != "" {
+		repo, _, _ := strings.Cut(image, ":")
+		if _, ok := s.refs[repo+"@"+digest]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// podSpec is the subset of a Pod spec holding image references.
+type podSpec struct {
+	Containers          []container `json:"containers"`
+	InitContainers      []container `json:"initContainers"`
+	EphemeralContainers []container `json:"ephemeralContainers"`
+}
+
+type container struct {
+	Image string `json:"image"`
+}
+
+type containerStatus struct {
+	// ImageID is the digest reference the container was started from.
+	ImageID string `json:"imageID"`
+}
+
+// object decodes any supported workload kind: Pods carry the spec directly,
+// controllers a Pod template, and CronJobs a Job template.
+type object struct {
+	Spec struct {
+		podSpec
+		Template struct {
+			Spec podSpec `json:"spec"`
+		} `json:"template"`
+		JobTemplate struct {
+			Spec struct {
+				Template struct {
+					Spec podSpec `json:"spec"`
+				} `json:"template"`
+			} `json:"spec"`
+		} `json:"jobTemplate"`
+	} `json:"spec"`
+	Status struct {
+		ContainerStatuses     []containerStatus `json:"containerStatuses"`
+		InitContainerStatuses []containerStatus `json:"initContainerStatuses"`
+	} `json:"status"`
+}
+
+type objectList struct {
+	Metadata struct {
+		Continue string `json:"continue"`
+	} `json:"metadata"`
+	Items []object `json:"items"`
+}
+
+// list adds the images referenced by all objects of kind in namespace, or
+// in all namespaces if it is empty, to refs.
+func (s *Scanner) list(ctx context.Context, kind, namespace string, refs map[string]struct{}) error {
+	group, ok := kinds[kind]
+	if !ok {
+		return fmt.Errorf("unsupported kind %q", kind)
+	}
+	path := group + "/" + kind
+	if namespace != "" {
+		path = group + "/namespaces/" + url.PathEscape(namespace) + "/" + kind
+	}
+
+	next := ""
+	for {
+		q := url.Values{"limit": {fmt.Sprint(pageSize)}}
+		if s.cfg.LabelSelector != "" {
+			q.Set("labelSelector", s.cfg.LabelSelector)
+		}
+		if next != "" {
+			q.Set("continue", next)
+		}
+		var page objectList
+		if err := s.get(ctx, path+"?"+q.Encode(), &page); err != nil {
+			return err
+		}
+		for _, obj := range page.Items {
+			s.collect(obj, refs)
+		}
+		if next = page.Metadata.Continue; next == "" {
+			return nil
+		}
+	}
+}
+
+func (s *Scanner) get(ctx context.Context, path string, v any) error {
+	token, err := s.token()
+	if err != nil {
+		return fmt.Errorf("reading service account token: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.apiURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// collect adds the references of obj that point at this registry to refs.
+func (s *Scanner) collect(obj object, refs map[string]struct{}) {
+	for _, spec := range []podSpec{obj.Spec.podSpec, obj.Spec.Template.Spec, obj.Spec.JobTemplate.Spec.Template.Spec} {
+		for _, list := range [][]container{spec.Containers, spec.InitContainers, spec.EphemeralContainers} {
+			for _, c := range list {
+				s.add(c.Image, refs)
+			}
+		}
+	}
+	for _, list := range [][]containerStatus{obj.Status.ContainerStatuses, obj.Status.InitContainerStatuses} {
+		for _, st := range list {
+			s.add(st.ImageID, refs)
+		}
+	}
+}
+
+// add records ref as "repo:tag" and "repo@digest" entries if it names an
+// image on one of the registry hosts.
+func (s *Scanner) add(ref string, refs map[string]struct{}) {
+	if _, rest, ok := strings.Cut(ref, "://"); ok {
+		ref = rest // container runtimes prefix image IDs, e.g. "docker-pullable://"
+	}
+	host, name, ok := strings.Cut(ref, "/")
+	if !ok || !s.registryHost(host) {
+		return
+	}
+	name, digest, _ := strings.Cut(name, "@")
+	repo, tag := name, ""
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		repo, tag = name[:i], name[i+1:]
+	}
+	if tag == "" && digest == "" {
+		tag = "latest"
+	}
+	if tag != "" {
+		refs[repo+":"+tag] = struct{}{}
+	}
+	if digest != "" {
+		refs[repo+"@"+digest] = struct{}{}
+	}
+}
+
+func (s *Scanner) registryHost(host string) bool {
+	for _, h := range s.cfg.RegistryHosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package kube
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const podList = `{"metadata":{},"items":[{
+	"spec":{"containers":[{"image":"reg.example.com/team/app:pr-1"},{"image":"docker.io/library/nginx:1"}],
+		"initContainers":[{"image":"REG.example.com/team/init"}]},
+	"status":{"containerStatuses":[{"imageID":"docker-pullable://reg.example.com/team/app@sha256:aaa"}]}}]}`
+
+const cronJobList = `{"metadata":{},"items":[{"spec":{"jobTemplate":{"spec":{"template":{"spec":{
+	"containers":[{"image":"registry.svc:5000/team/job:nightly@sha256:bbb"}]}}}}}}]}`
+
+func newScanner(t *testing.T, cfg Config) (*Scanner, *int) {
+	t.Helper()
+	var requests int
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/pods", "/api/v1/namespaces/ci/pods":
+			_, _ = fmt.Fprint(w, podList)
+		case "/apis/batch/v1/cronjobs":
+			_, _ = fmt.Fprint(w, cronJobList)
+		case "/apis/apps/v1/deployments":
+			// Two pages, the second one empty.
+			if r.URL.Query().Get("continue") == "" {
+				_, _ = fmt.Fprint(w, `{"metadata":{"continue":"next"},"items":[]}`)
+				return
+			}
+			_, _ = fmt.Fprint(w, `{"metadata":{},"items":[]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(api.Close)
+	token := func() (string, error) { return "token", nil }
+	return New(api.URL, api.Client(), token, cfg, time.Minute, slog.New(slog.DiscardHandler)), &requests
+}
+
+func TestScanner_InUse(t *testing.T) {
+	s, requests := newScanner(t, Config{
+		Kinds:         []string{"pods", "deployments", "cronjobs"},
+		RegistryHosts: []string{"reg.example.com", "registry.svc:5000"},
+	})
+	if _, err := s.InUse("team/app:pr-1", ""); !errors.Is(err, ErrStale) {
+		t.Errorf("expected ErrStale before the first scan, got %v", err)
+	}
+	if err := s.Scan(t.Context()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *requests != 4 {
+		t.Errorf("expected 4 list requests, got %d", *requests)
+	}
+
+	for _, tc := range []struct {
+		image, digest string
+		want          bool
+	}{
+		{"team/app:pr-1", "", true},
+		{"team/init:latest", "", true},
+		{"team/app:pr-2", "sha256:aaa", true},
+		{"team/job:nightly", "", true},
+		{"team/job:other", "sha256:bbb", true},
+		{"team/app:pr-2", "sha256:ccc", false},
+		{"library/nginx:1", "", false},
+	} {
+		got, err := s.InUse(tc.image, tc.digest)
+		if err != nil || got != tc.want {
+			t.Errorf("InUse(%q, %q) = %v, %v; want %v", tc.image, tc.digest, got, err, tc.want)
+		}
+	}
+
+	s.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if _, err := s.InUse("team/app:pr-1", ""); !errors.Is(err, ErrStale) {
+		t.Errorf("expected ErrStale after maxAge, got %v", err)
+	}
+}
+
+func TestScanner_Namespaces(t *testing.T) {
+	s, _ := newScanner(t, Config{
+		Kinds:         []string{"pods"},
+		Namespaces:    []string{"ci"},
+		RegistryHosts: []string{"reg.example.com"},
+	})
+	if err := s.Scan(t.Context()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok, _ := s.InUse("team/app:pr-1", ""); !ok {
+		t.Error("expected image referenced in namespace ci to be in use")
+	}
+}
+
+func TestScanner_FailedScanKeepsPrevious(t *testing.T) {
+	s, _ := newScanner(t, Config{Kinds: []string{"pods"}, RegistryHosts: []string{"reg.example.com"}})
+	if err := s.Scan(t.Context()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.cfg.Kinds = []string{"jobs"}
+	if err := s.Scan(t.Context()); err == nil {
+		t.Fatal("expected error for failing list")
+	}
+	if ok, err := s.InUse("team/app:pr-1", ""); !ok || err != nil {
+		t.Errorf("expected previous scan to be kept, got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,418 @@
+// Package memregistry is a minimal in-memory OCI distribution registry for
+// demo mode. It implements the parts of the distribution API that ephemeron
+// and "docker push" use — the catalog, tag lists, manifests, blobs and
+// monolithic or chunked uploads — and notifies a webhook of pushes and
+// deletions as the reference registry does. Content is lost on exit.
+package memregistry
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/hooks"
+)
+
+// maxBlobBytes bounds a single blob, so a demo cannot exhaust memory with
+// one push.
+const maxBlobBytes = 256 << 20
+
+// Notifier receives the events of pushes and deletions.
+type Notifier func(hooks.RegistryEvent)
+
+type manifest struct {
+	mediaType string
+	body      []byte
+}
+
+type repository struct {
+	tags      map[string]string // tag -> digest
+	manifests map[string]manifest
+}
+
+// Registry is an in-memory registry. Its zero value is not usable; call New.
+type Registry struct {
+	notify Notifier
+	logger *slog.Logger
+
+	mu      sync.RWMutex
+	repos   map[string]*repository
+	blobs   map[string][]byte
+	uploads map[string]*bytes.Buffer
+}
+
+// New returns an empty Registry passing events to notify, if not nil.
+func New(notify Notifier, logger *slog.Logger) *Registry {
+	return &Registry{
+		notify:  notify,
+		logger:  logger,
+		repos:   make(map[string]*repository),
+		blobs:   make(map[string][]byte),
+		uploads: make(map[string]*bytes.Buffer),
+	}
+}
+
+// WebhookNotifier returns a Notifier posting each event to url as the
+// reference registry would, authenticated with token if not empty.
+func WebhookNotifier(url, token string, logger *slog.Logger) Notifier {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return func(e hooks.RegistryEvent) {
+		body, _ := json.Marshal(hooks.EventEnvelope{Events: []hooks.RegistryEvent{e}})
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			logger.Warn("failed to create notification", "error", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/vnd.docker.distribution.events.v1+json")
+		if token != "" {
+			req.Header.Set("Authorization", "Token "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			logger.Warn("failed to send notification", "action", e.Action, "error", err)
+			return
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			logger.Warn("notification rejected", "action", e.Action, "status", resp.StatusCode)
+		}
+	}
+}
+
+// PushImage stores a single-layer image as repo:tag, built from layer, as
+// if it had been pushed, without notifying anyone. It returns the manifest
+// digest.
+func (r *Registry) PushImage(repo, tag string, layer []byte) string {
+	config := []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":[]}}`)
+	body, _ := json.Marshal(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"config": map[string]any{
+			"mediaType": "application/vnd.oci.image.config.v1+json",
+			"digest":    digestOf(config),
+			"size":      len(config),
+		},
+		"layers": []map[string]any{{
+			"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip",
+			"digest":    digestOf(layer),
+			"size":      len(layer),
+		}},
+	})
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blobs[digestOf(config)] = config
+	r.blobs[digestOf(layer)] = layer
+	return r.putManifest(repo, tag, manifest{mediaType: "application/vnd.oci.image.manifest.v1+json", body: body})
+}
+
+// putManifest stores m under ref and returns its digest. The caller holds mu.
+func (r *Registry) putManifest(name, ref string, m manifest) string {
+	repo, ok := r.repos[name]
+	if !ok {
+		repo = &repository{tags: make(map[string]string), manifests: make(map[string]manifest)}
+		r.repos[name] = repo
+	}
+	digest := digestOf(m.body)
+	repo.manifests[digest] = m
+	if ref != digest {
+		repo.tags[ref] = digest
+	}
+	return digest
+}
+
+// ServeHTTP implements the distribution API below /v2/.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+	path, ok := strings.CutPrefix(req.URL.Path, "/v2/")
+	switch {
+	case !ok:
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "not found")
+	case path == "":
+		w.WriteHeader(http.StatusOK)
+	case path == "_catalog" && req.Method == http.MethodGet:
+		r.catalog(w, req)
+	case strings.HasSuffix(path, "/tags/list") && req.Method == http.MethodGet:
+		r.tags(w, req, strings.TrimSuffix(path, "/tags/list"))
+	case strings.Contains(path, "/manifests/"):
+		i := strings.LastIndex(path, "/manifests/")
+		r.manifests(w, req, path[:i], path[i+len("/manifests/"):])
+	case strings.Contains(path, "/blobs/uploads/"):
+		i := strings.LastIndex(path, "/blobs/uploads/")
+		r.upload(w, req, path[:i], path[i+len("/blobs/uploads/"):])
+	case strings.Contains(path, "/blobs/"):
+		i := strings.LastIndex(path, "/blobs/")
+		r.blob(w, req, path[i+len("/blobs/"):])
+	default:
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "not found")
+	}
+}
+
+func (r *Registry) catalog(w http.ResponseWriter, req *http.Request) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.repos))
+	for name, repo := range r.repos {
+		if len(repo.manifests) > 0 {
+			names = append(names, name)
+		}
+	}
+	r.mu.RUnlock()
+	slices.Sort(names)
+	page, next := paginate(names, req.URL.Query())
+	if next != "" {
+		w.Header().Set("Link", fmt.Sprintf(`</v2/_catalog?%s>; rel="next"`, next))
+	}
+	writeJSON(w, map[string][]string{"repositories": page})
+}
+
+func (r *Registry) tags(w http.ResponseWriter, req *http.Request, name string) {
+	r.mu.RLock()
+	repo, ok := r.repos[name]
+	var tags []string
+	if ok {
+		for tag := range repo.tags {
+			tags = append(tags, tag)
+		}
+	}
+	r.mu.RUnlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+		return
+	}
+	slices.Sort(tags)
+	page, next := paginate(tags, req.URL.Query())
+	if next != "" {
+		w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?%s>; rel="next"`, name, next))
+	}
+	writeJSON(w, map[string]any{"name": name, "tags": page})
+}
+
+// paginate applies the n and last query parameters to sorted, returning the
+// page and the query of the next one, if any.
+func paginate(sorted []string, q url.Values) ([]string, string) {
+	if last := q.Get("last"); last != "" {
+		i, _ := slices.BinarySearch(sorted, last)
+		for i < len(sorted) && sorted[i] <= last {
+			i++
+		}
+		sorted = sorted[i:]
+	}
+	n, err := strconv.Atoi(q.Get("n"))
+	if err != nil || n <= 0 || n >= len(sorted) {
+		return sorted, ""
+	}
+	next := url.Values{"n": {strconv.Itoa(n)}, "last": {sorted[n-1]}}
+	return sorted[:n], next.Encode()
+}
+
+func (r *Registry) manifests(w http.ResponseWriter, req *http.Request, name, ref string) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		r.mu.RLock()
+		m, digest, ok := r.lookup(name, ref)
+		r.mu.RUnlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.Header().Set("Content-Length", strconv.Itoa(len(m.body)))
+		if req.Method == http.MethodGet {
+			_, _ = w.Write(m.body)
+		}
+	case http.MethodPut:
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxBlobBytes))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+			return
+		}
+		mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		r.mu.Lock()
+		digest := r.putManifest(name, ref, manifest{mediaType: mediaType, body: body})
+		r.mu.Unlock()
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", name, digest))
+		w.WriteHeader(http.StatusCreated)
+		tag := ref
+		if ref == digest {
+			tag = ""
+		}
+		r.emit("push", name, tag, digest, req)
+	case http.MethodDelete:
+		r.mu.Lock()
+		digest, ok := r.remove(name, ref)
+		r.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		tag := ref
+		if ref == digest {
+			tag = ""
+		}
+		r.emit("delete", name, tag, digest, req)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// lookup resolves ref, a tag or digest, in repository name. The caller
+// holds mu.
+func (r *Registry) lookup(name, ref string) (manifest, string, bool) {
+	repo, ok := r.repos[name]
+	if !ok {
+		return manifest{}, "", false
+	}
+	digest := ref
+	if d, isTag := repo.tags[ref]; isTag {
+		digest = d
+	}
+	m, ok := repo.manifests[digest]
+	return m, digest, ok
+}
+
+// remove deletes a tag, or a manifest with every tag pointing at it, and
+// returns the digest it referred to. The caller holds mu.
+func (r *Registry) remove(name, ref string) (string, bool) {
+	repo, ok := r.repos[name]
+	if !ok {
+		return "", false
+	}
+	if digest, isTag := repo.tags[ref]; isTag {
+		delete(repo.tags, ref)
+		return digest, true
+	}
+	if _, ok := repo.manifests[ref]; !ok {
+		return "", false
+	}
+	delete(repo.manifests, ref)
+	for tag, digest := range repo.tags {
+		if digest == ref {
+			delete(repo.tags, tag)
+		}
+	}
+	return ref, true
+}
+
+func (r *Registry) blob(w http.ResponseWriter, req *http.Request, digest string) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	r.mu.RLock()
+	b, ok := r.blobs[digest]
+	r.mu.RUnlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	if req.Method == http.MethodGet {
+		_, _ = w.Write(b)
+	}
+}
+
+// upload handles blob upload sessions: POST starts one, PATCH appends a
+// chunk and PUT appends the last one and commits the blob under the digest
+// given as a query parameter. Cross-repository mounts are not supported,
+// so mount requests start an ordinary upload.
+func (r *Registry) upload(w http.ResponseWriter, req *http.Request, name, id string) {
+	switch req.Method {
+	case http.MethodPost:
+		b := make([]byte, 16)
+		_, _ = rand.Read(b)
+		id = hex.EncodeToString(b)
+		r.mu.Lock()
+		r.uploads[id] = new(bytes.Buffer)
+		r.mu.Unlock()
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", name, id))
+		w.Header().Set("Range", "0-0")
+		w.Header().Set("Docker-Upload-UUID", id)
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPatch, http.MethodPut:
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		buf, ok := r.uploads[id]
+		if !ok {
+			writeError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "blob upload unknown to registry")
+			return
+		}
+		if _, err := io.Copy(buf, io.LimitReader(req.Body, int64(maxBlobBytes-buf.Len()+1))); err != nil {
+			writeError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", err.Error())
+			return
+		}
+		if buf.Len() > maxBlobBytes {
+			delete(r.uploads, id)
+			writeError(w, http.StatusRequestEntityTooLarge, "SIZE_INVALID", "blob too large for demo registry")
+			return
+		}
+		if req.Method == http.MethodPatch {
+			w.Header().Set("Location", req.URL.Path)
+			w.Header().Set("Range", fmt.Sprintf("0-%d", max(buf.Len()-1, 0)))
+			w.Header().Set("Docker-Upload-UUID", id)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		digest := req.URL.Query().Get("digest")
+		if digest != digestOf(buf.Bytes()) {
+			writeError(w, http.StatusBadRequest, "DIGEST_INVALID", "provided digest did not match uploaded content")
+			return
+		}
+		delete(r.uploads, id)
+		r.blobs[digest] = buf.Bytes()
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", name, digest))
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// emit notifies of an event in the background, so a slow webhook does not
+// hold up the push.
+func (r *Registry) emit(action, repo, tag, digest string, req *http.Request) {
+	if r.notify == nil {
+		return
+	}
+	e := hooks.RegistryEvent{
+		Action:  action,
+		Target:  hooks.EventTarget{Repository: repo, Tag: tag, Digest: digest},
+		Request: hooks.EventRequest{Addr: req.RemoteAddr, UserAgent: req.UserAgent()},
+	}
+	go r.notify(e)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers with a distribution API error body.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
+
+func digestOf(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
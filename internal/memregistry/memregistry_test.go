@@ -0,0 +1,93 @@
+package memregistry
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/hooks"
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+func TestRegistry_WithClient(t *testing.T) {
+	var mu sync.Mutex
+	var events []hooks.RegistryEvent
+	reg := New(func(e hooks.RegistryEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e)
+	}, slog.New(slog.DiscardHandler))
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+	client := registry.New(srv.URL)
+	ctx := t.Context()
+
+	for i := range 3 {
+		reg.PushImage(fmt.Sprintf("demo/app%d", i), "1h", []byte{byte(i)})
+	}
+	repos, err := client.ListRepositories(ctx)
+	if err != nil || !slices.Equal(repos, []string{"demo/app0", "demo/app1", "demo/app2"}) {
+		t.Fatalf("ListRepositories = %v, %v", repos, err)
+	}
+	page, last, err := client.ListRepositoriesAfter(ctx, "demo/app0", 1)
+	if err != nil || !slices.Equal(page, []string{"demo/app1"}) || last != "demo/app1" {
+		t.Errorf("ListRepositoriesAfter = %v, %q, %v; want [demo/app1]", page, last, err)
+	}
+
+	layer := []byte("layer")
+	if err := client.PutBlob(ctx, "demo/app0", digestOf(layer), layer); err != nil {
+		t.Fatalf("PutBlob: %v", err)
+	}
+	if err := client.PutBlob(ctx, "demo/app0", digestOf([]byte("other")), layer); err == nil {
+		t.Error("expected error for a mismatched digest")
+	}
+	m, err := client.GetManifest(ctx, "demo/app0", "1h")
+	if err != nil {
+		t.Fatalf("GetManifest: %v", err)
+	}
+	digest, err := client.PutManifest(ctx, "demo/app0", "2h", m)
+	if err != nil || digest != m.Digest() {
+		t.Fatalf("PutManifest = %q, %v; want %q", digest, err, m.Digest())
+	}
+	tags, err := client.ListTags(ctx, "demo/app0")
+	if err != nil || !slices.Equal(tags, []string{"1h", "2h"}) {
+		t.Errorf("ListTags = %v, %v", tags, err)
+	}
+	if size, err := client.GetImageSize(ctx, "demo/app0", "2h"); err != nil || size == 0 {
+		t.Errorf("GetImageSize = %d, %v", size, err)
+	}
+
+	if err := client.DeleteManifest(ctx, "demo/app0", digest); err != nil {
+		t.Fatalf("DeleteManifest: %v", err)
+	}
+	if _, found, err := client.ManifestDigest(ctx, "demo/app0", "1h"); err != nil || found {
+		t.Errorf("ManifestDigest after delete = %v, %v; want not found", found, err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		n := len(events)
+		mu.Unlock()
+		if n == 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// Notifications are sent concurrently, so their order is not fixed.
+	pushed := slices.ContainsFunc(events, func(e hooks.RegistryEvent) bool {
+		return e.Action == "push" && e.Target.Tag == "2h"
+	})
+	deleted := slices.ContainsFunc(events, func(e hooks.RegistryEvent) bool {
+		return e.Action == "delete" && e.Target.Digest == digest
+	})
+	if len(events) != 2 || !pushed || !deleted {
+		t.Errorf("events = %+v; want the push of 2h and the delete", events)
+	}
+}
@@ -0,0 +1,795 @@
+// Package memstore provides an in-memory implementation of the image
+// tracking store. It is intended for tests and single-binary demos; all
+// state is lost when the process exits.
+package memstore
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// ErrNotFound is returned when reading the expiry of an untracked image.
+var ErrNotFound = errors.New("image not tracked")
+
+var _ redisclient.Store = (*Store)(nil)
+
+type record struct {
+	created   int64
+	expires   int64
+	sizeBytes int64
+	digest    string
+	meta      redisclient.ImageMeta
+	failures  int64
+	protected bool
+	priority  int
+}
+
+// Store is a thread-safe in-memory Store.
+type Store struct {
+	mu          sync.RWMutex
+	images      map[string]record
+	lockToken   int64
+	lockFence   int64
+	lockExpires time.Time
+	initialized bool
+	schema      int
+	reaped      int64
+	reclaimed   int64
+	quarantine  map[string]redisclient.QuarantinedImage
+	freezes     map[string]redisclient.Freeze
+	rules       map[string]map[string]redisclient.Rule
+	deletions   map[string]redisclient.DeletionRequest
+	apiTokens   map[string]redisclient.APIToken
+	tombstones  []redisclient.Tombstone
+	reconcile   redisclient.ReconcileCheckpoint
+	cursor      string
+	pushStats   map[string]redisclient.RepoStats
+	usage       []redisclient.DailyUsage
+	superseded  map[string]redisclient.SupersededManifest
+}
+
+// New creates an empty in-memory store.
+func New() *Store {
+	return &Store{
+		images:     make(map[string]record),
+		quarantine: make(map[string]redisclient.QuarantinedImage),
+		freezes:    make(map[string]redisclient.Freeze),
+		rules:      make(map[string]map[string]redisclient.Rule),
+		deletions:  make(map[string]redisclient.DeletionRequest),
+		apiTokens:  make(map[string]redisclient.APIToken),
+		pushStats:  make(map[string]redisclient.RepoStats),
+		superseded: make(map[string]redisclient.SupersededManifest),
+	}
+}
+
+// Ping always succeeds.
+func (s *Store) Ping(context.Context) error { return nil }
+
+// Degraded always reports a healthy store.
+func (s *Store) Degraded(context.Context) (string, error) { return "", nil }
+
+// Close is a no-op.
+func (s *Store) Close() error { return nil }
+
+// TrackImage adds or replaces an image's expiry metadata.
+func (s *Store) TrackImage(
+	_ context.Context,
+	imageWithTag string,
+	expiresAt time.Time,
+	sizeBytes int64,
+	digest string,
+	meta redisclient.ImageMeta,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.images[imageWithTag] = record{
+		created:   time.Now().UnixMilli(),
+		expires:   expiresAt.UnixMilli(),
+		sizeBytes: sizeBytes,
+		digest:    digest,
+		meta:      meta,
+	}
+	delete(s.quarantine, imageWithTag)
+	return nil
+}
+
+// ListImages returns all tracked images.
+func (s *Store) ListImages(context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]string, 0, len(s.images))
+	for k := range s.images {
+		out = append(out, k)
+	}
+	return out, nil
+}
+
+// GetExpiry returns the expiry timestamp (epoch milliseconds) for an image.
+func (s *Store) GetExpiry(_ context.Context, imageWithTag string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rec, ok := s.images[imageWithTag]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return rec.expires, nil
+}
+
+// SetExpiry changes the expiry of a tracked image. It reports false if the
+// image is not tracked.
+func (s *Store) SetExpiry(_ context.Context, imageWithTag string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.images[imageWithTag]
+	if !ok {
+		return false, nil
+	}
+	rec.expires = expiresAt.UnixMilli()
+	s.images[imageWithTag] = rec
+	return true, nil
+}
+
+// SetExpiries changes the expiry of several tracked images atomically and
+// returns the images that were still tracked and thus updated.
+func (s *Store) SetExpiries(_ context.Context, expiries map[string]time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var updated []string
+	for image, expiresAt := range expiries {
+		rec, ok := s.images[image]
+		if !ok {
+			continue
+		}
+		rec.expires = expiresAt.UnixMilli()
+		s.images[image] = rec
+		updated = append(updated, image)
+	}
+	return updated, nil
+}
+
+// SetManifestInfo changes the size and digest of a tracked image, keeping
+// the rest of its metadata. It reports false if the image is not tracked.
+func (s *Store) SetManifestInfo(_ context.Context, imageWithTag string, sizeBytes int64, digest string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.images[imageWithTag]
+	if !ok {
+		return false, nil
+	}
+	rec.sizeBytes = sizeBytes
+	rec.digest = digest
+	s.images[imageWithTag] = rec
+	return true, nil
+}
+
+// SetImageSize changes the size of an image only while it is still tracked
+// with digest, reporting whether it did.
+func (s *Store) SetImageSize(_ context.Context, imageWithTag, digest string, sizeBytes int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.images[imageWithTag]
+	if !ok || rec.digest != digest {
+		return false, nil
+	}
+	rec.sizeBytes = sizeBytes
+	s.images[imageWithTag] = rec
+	return true, nil
+}
+
+// GetManifestRecords returns the size and digest of each tracked image of
+// images.
+func (s *Store) GetManifestRecords(_ context.Context, images []string) (map[string]redisclient.ManifestRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]redisclient.ManifestRecord, len(images))
+	for _, image := range images {
+		if rec, ok := s.images[image]; ok {
+			out[image] = redisclient.ManifestRecord{SizeBytes: rec.sizeBytes, Digest: rec.digest}
+		}
+	}
+	return out, nil
+}
+
+// GetImageSize returns the size in bytes for an image, or 0 if untracked.
+func (s *Store) GetImageSize(_ context.Context, imageWithTag string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.images[imageWithTag].sizeBytes, nil
+}
+
+// GetImageDigest returns the stored digest, or "" if untracked.
+func (s *Store) GetImageDigest(_ context.Context, imageWithTag string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.images[imageWithTag].digest, nil
+}
+
+// GetCreatedTimestamp returns the created timestamp (epoch milliseconds), or 0 if untracked.
+func (s *Store) GetCreatedTimestamp(_ context.Context, imageWithTag string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.images[imageWithTag].created, nil
+}
+
+// GetImageMeta returns who pushed an image, or the zero value if untracked.
+func (s *Store) GetImageMeta(_ context.Context, imageWithTag string) (redisclient.ImageMeta, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.images[imageWithTag].meta, nil
+}
+
+// GetImageRecord returns the fields of an image's record under the names
+// the Redis store uses, or an empty map if untracked. Unset fields are
+// omitted.
+func (s *Store) GetImageRecord(_ context.Context, imageWithTag string) (map[string]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fields := make(map[string]string)
+	rec, ok := s.images[imageWithTag]
+	if !ok {
+		return fields, nil
+	}
+	fields["created"] = strconv.FormatInt(rec.created, 10)
+	fields["expires"] = strconv.FormatInt(rec.expires, 10)
+	fields["size_bytes"] = strconv.FormatInt(rec.sizeBytes, 10)
+	for name, v := range map[string]string{
+		"digest":      rec.digest,
+		"actor":       rec.meta.Actor,
+		"source_addr": rec.meta.SourceAddr,
+		"user_agent":  rec.meta.UserAgent,
+		"source":      rec.meta.Source,
+	} {
+		if v != "" {
+			fields[name] = v
+		}
+	}
+	if rec.failures > 0 {
+		fields["delete_failures"] = strconv.FormatInt(rec.failures, 10)
+	}
+	if rec.protected {
+		fields["protected"] = "1"
+	}
+	if rec.priority != 0 {
+		fields["priority"] = strconv.Itoa(rec.priority)
+	}
+	return fields, nil
+}
+
+// Aliases returns the other tracked tags of an image's repository that
+// point at the same manifest digest. Images without a digest have none.
+func (s *Store) Aliases(_ context.Context, imageWithTag string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	digest := s.images[imageWithTag].digest
+	if digest == "" {
+		return nil, nil
+	}
+	var out []string
+	for image, rec := range s.images {
+		if image != imageWithTag && rec.digest == digest && repository(image) == repository(imageWithTag) {
+			out = append(out, image)
+		}
+	}
+	return out, nil
+}
+
+// TaggedImages returns the tracked images of repo that point at digest.
+func (s *Store) TaggedImages(_ context.Context, repo, digest string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []string
+	for image, rec := range s.images {
+		if digest != "" && rec.digest == digest && repository(image) == repo {
+			out = append(out, image)
+		}
+	}
+	return out, nil
+}
+
+// repository returns the repository part of repo:tag.
+func repository(imageWithTag string) string {
+	repo, _, _ := strings.Cut(imageWithTag, ":")
+	return repo
+}
+
+// RemoveImage stops tracking an image.
+func (s *Store) RemoveImage(_ context.Context, imageWithTag string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.images, imageWithTag)
+	delete(s.quarantine, imageWithTag)
+	return nil
+}
+
+// AcquireReaperLock acquires the reaper lock unless it is held and unexpired.
+// It returns a positive fencing token on success and 0 otherwise.
+func (s *Store) AcquireReaperLock(_ context.Context, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lockFence++
+	now := time.Now()
+	if now.Before(s.lockExpires) {
+		return 0, nil
+	}
+	s.lockToken = s.lockFence
+	s.lockExpires = now.Add(ttl)
+	return s.lockToken, nil
+}
+
+// RenewReaperLock extends the lock if token still holds it.
+func (s *Store) RenewReaperLock(_ context.Context, token int64, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.lockToken != token || !now.Before(s.lockExpires) {
+		return false, nil
+	}
+	s.lockExpires = now.Add(ttl)
+	return true, nil
+}
+
+// ReleaseReaperLock releases the reaper lock if token still holds it.
+func (s *Store) ReleaseReaperLock(_ context.Context, token int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lockToken == token {
+		s.lockExpires = time.Time{}
+	}
+	return nil
+}
+
+// IsInitialized reports whether SetInitialized has been called.
+func (s *Store) IsInitialized(context.Context) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.initialized, nil
+}
+
+// SetInitialized marks the store as initialized.
+func (s *Store) SetInitialized(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.initialized = true
+	return nil
+}
+
+// SchemaVersion returns the recorded store schema version, 0 if none.
+func (s *Store) SchemaVersion(context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.schema, nil
+}
+
+// SetSchemaVersion records the store schema version.
+func (s *Store) SetSchemaVersion(_ context.Context, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schema = version
+	return nil
+}
+
+// ImageCount returns the number of tracked images.
+func (s *Store) ImageCount(context.Context) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(len(s.images)), nil
+}
+
+// TrackedBytes returns the summed size of all tracked images. Tags of a
+// repository that share a manifest digest are counted once.
+func (s *Store) TrackedBytes(context.Context) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var total int64
+	counted := make(map[string]struct{})
+	for image, rec := range s.images {
+		if rec.digest != "" {
+			key := repository(image) + "@" + rec.digest
+			if _, ok := counted[key]; ok {
+				continue
+			}
+			counted[key] = struct{}{}
+		}
+		total += rec.sizeBytes
+	}
+	return total, nil
+}
+
+// RecordReap adds a reaped image and its size to the lifetime totals.
+func (s *Store) RecordReap(_ context.Context, sizeBytes int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reaped++
+	s.reclaimed += sizeBytes
+	return nil
+}
+
+// ReapTotals returns the lifetime number of reaped images and reclaimed bytes.
+func (s *Store) ReapTotals(context.Context) (images, bytes int64, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.reaped, s.reclaimed, nil
+}
+
+// RecordPush adds a push to repo's statistics.
+func (s *Store) RecordPush(_ context.Context, repo string, sizeBytes int64, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.pushStats[repo]
+	stats.Repository = repo
+	stats.Pushes++
+	if sizeBytes > 0 {
+		stats.SizedPushes++
+		stats.TotalBytes += sizeBytes
+	}
+	stats.LastPush = time.UnixMilli(at.UnixMilli()).UTC()
+	s.pushStats[repo] = stats
+	return nil
+}
+
+// ListRepoStats returns the push statistics of every repository pushed to.
+func (s *Store) ListRepoStats(context.Context) ([]redisclient.RepoStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]redisclient.RepoStats, 0, len(s.pushStats))
+	for _, stats := range s.pushStats {
+		out = append(out, stats)
+	}
+	slices.SortFunc(out, func(a, b redisclient.RepoStats) int { return strings.Compare(a.Repository, b.Repository) })
+	return out, nil
+}
+
+// SetProtected marks an image as exempt from reaping, or clears the mark.
+// Untracked images are ignored. Re-tracking an image clears the mark.
+func (s *Store) SetProtected(_ context.Context, imageWithTag string, protected bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rec, ok := s.images[imageWithTag]; ok {
+		rec.protected = protected
+		s.images[imageWithTag] = rec
+	}
+	return nil
+}
+
+// IsProtected reports whether an image is exempt from reaping.
+func (s *Store) IsProtected(_ context.Context, imageWithTag string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.images[imageWithTag].protected, nil
+}
+
+// SetPriority sets the deletion priority of an image; zero clears it.
+// Untracked images are ignored. Re-tracking an image clears the priority.
+func (s *Store) SetPriority(_ context.Context, imageWithTag string, priority int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rec, ok := s.images[imageWithTag]; ok {
+		rec.priority = priority
+		s.images[imageWithTag] = rec
+	}
+	return nil
+}
+
+// GetPriority returns the deletion priority of an image, zero if unset.
+func (s *Store) GetPriority(_ context.Context, imageWithTag string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.images[imageWithTag].priority, nil
+}
+
+// RecordDeleteFailure increments and returns the number of failed deletion
+// attempts for an image. Untracked images report 0.
+func (s *Store) RecordDeleteFailure(_ context.Context, imageWithTag string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.images[imageWithTag]
+	if !ok {
+		return 0, nil
+	}
+	rec.failures++
+	s.images[imageWithTag] = rec
+	return rec.failures, nil
+}
+
+// QuarantineImage excludes an image from reaping until it is released or
+// re-pushed.
+func (s *Store) QuarantineImage(_ context.Context, q redisclient.QuarantinedImage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.quarantine[q.Image] = q
+	return nil
+}
+
+// ListQuarantined returns all quarantined images.
+func (s *Store) ListQuarantined(context.Context) ([]redisclient.QuarantinedImage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]redisclient.QuarantinedImage, 0, len(s.quarantine))
+	for _, q := range s.quarantine {
+		out = append(out, q)
+	}
+	return out, nil
+}
+
+// ReleaseQuarantined returns an image to normal reaping with its failure
+// count reset.
+func (s *Store) ReleaseQuarantined(_ context.Context, imageWithTag string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.quarantine, imageWithTag)
+	if rec, ok := s.images[imageWithTag]; ok {
+		rec.failures = 0
+		s.images[imageWithTag] = rec
+	}
+	return nil
+}
+
+// SetFreeze stores f, replacing any freeze with the same pattern.
+func (s *Store) SetFreeze(_ context.Context, f redisclient.Freeze) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.freezes[f.Pattern] = f
+	return nil
+}
+
+// ListFreezes returns the freezes still in effect, dropping lapsed ones.
+func (s *Store) ListFreezes(context.Context) ([]redisclient.Freeze, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]redisclient.Freeze, 0, len(s.freezes))
+	for pattern, f := range s.freezes {
+		if !time.Now().Before(f.Until) {
+			delete(s.freezes, pattern)
+			continue
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// Unfreeze lifts the freeze with the given pattern.
+func (s *Store) Unfreeze(_ context.Context, pattern string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.freezes, pattern)
+	return nil
+}
+
+// PutRule stores r under kind, replacing any rule with the same pattern.
+func (s *Store) PutRule(_ context.Context, kind string, r redisclient.Rule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rules[kind] == nil {
+		s.rules[kind] = make(map[string]redisclient.Rule)
+	}
+	s.rules[kind][r.Pattern] = r
+	return nil
+}
+
+// ListRules returns all rules of kind.
+func (s *Store) ListRules(_ context.Context, kind string) ([]redisclient.Rule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]redisclient.Rule, 0, len(s.rules[kind]))
+	for _, r := range s.rules[kind] {
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// DeleteRule removes the rule of kind with the given pattern and reports
+// whether it existed.
+func (s *Store) DeleteRule(_ context.Context, kind, pattern string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.rules[kind][pattern]
+	delete(s.rules[kind], pattern)
+	return ok, nil
+}
+
+// PutDeletion stores d, replacing any deletion request with the same ID.
+func (s *Store) PutDeletion(_ context.Context, d redisclient.DeletionRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d.Events = slices.Clone(d.Events)
+	s.deletions[d.ID] = d
+	return nil
+}
+
+// ListDeletions returns all deletion requests, including finished ones.
+func (s *Store) ListDeletions(context.Context) ([]redisclient.DeletionRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]redisclient.DeletionRequest, 0, len(s.deletions))
+	for _, d := range s.deletions {
+		d.Events = slices.Clone(d.Events)
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// PutAPIToken stores t, replacing any token with the same name.
+func (s *Store) PutAPIToken(_ context.Context, t redisclient.APIToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t.Repositories = slices.Clone(t.Repositories)
+	s.apiTokens[t.Name] = t
+	return nil
+}
+
+// ListAPITokens returns all API tokens.
+func (s *Store) ListAPITokens(context.Context) ([]redisclient.APIToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]redisclient.APIToken, 0, len(s.apiTokens))
+	for _, t := range s.apiTokens {
+		t.Repositories = slices.Clone(t.Repositories)
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// DeleteAPIToken removes the API token with the given name and reports
+// whether it existed.
+func (s *Store) DeleteAPIToken(_ context.Context, name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.apiTokens[name]
+	delete(s.apiTokens, name)
+	return ok, nil
+}
+
+// AddTombstone records t and drops the tombstones of images reaped before
+// cutoff.
+func (s *Store) AddTombstone(_ context.Context, t redisclient.Tombstone, cutoff time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tombstones = slices.DeleteFunc(s.tombstones, func(old redisclient.Tombstone) bool {
+		return old.ReapedAt.Before(cutoff)
+	})
+	i, _ := slices.BinarySearchFunc(s.tombstones, t.ReapedAt, func(old redisclient.Tombstone, at time.Time) int {
+		return old.ReapedAt.Compare(at)
+	})
+	s.tombstones = slices.Insert(s.tombstones, i, t)
+	return nil
+}
+
+// RecordDailyUsage stores u, replacing an earlier sample of the same day,
+// and drops the days before cutoff.
+func (s *Store) RecordDailyUsage(_ context.Context, u redisclient.DailyUsage, cutoff time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u.Repositories = maps.Clone(u.Repositories)
+	s.usage = slices.DeleteFunc(s.usage, func(old redisclient.DailyUsage) bool {
+		return old.Day.Equal(u.Day) || old.Day.Before(cutoff)
+	})
+	i, _ := slices.BinarySearchFunc(s.usage, u.Day, func(old redisclient.DailyUsage, day time.Time) int {
+		return old.Day.Compare(day)
+	})
+	s.usage = slices.Insert(s.usage, i, u)
+	return nil
+}
+
+// ListDailyUsage returns the days recorded since since, oldest first.
+func (s *Store) ListDailyUsage(_ context.Context, since time.Time) ([]redisclient.DailyUsage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []redisclient.DailyUsage
+	for _, u := range s.usage {
+		if !u.Day.Before(since) {
+			u.Repositories = maps.Clone(u.Repositories)
+			out = append(out, u)
+		}
+	}
+	return out, nil
+}
+
+// AddSuperseded schedules the deletion of a superseded manifest, keeping
+// the later expiry if it is already scheduled.
+func (s *Store) AddSuperseded(_ context.Context, m redisclient.SupersededManifest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := m.Repository + "@" + m.Digest
+	if old, ok := s.superseded[key]; !ok || m.ExpiresAt.After(old.ExpiresAt) {
+		s.superseded[key] = m
+	}
+	return nil
+}
+
+// ExpiredSuperseded returns the superseded manifests expired at now,
+// soonest expired first.
+func (s *Store) ExpiredSuperseded(_ context.Context, now time.Time) ([]redisclient.SupersededManifest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []redisclient.SupersededManifest
+	for _, m := range s.superseded {
+		if !m.ExpiresAt.After(now) {
+			out = append(out, m)
+		}
+	}
+	slices.SortFunc(out, func(a, b redisclient.SupersededManifest) int {
+		return a.ExpiresAt.Compare(b.ExpiresAt)
+	})
+	return out, nil
+}
+
+// RemoveSuperseded forgets the superseded manifest repo@digest.
+func (s *Store) RemoveSuperseded(_ context.Context, repo, digest string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.superseded, repo+"@"+digest)
+	return nil
+}
+
+// ListTombstones returns the tombstones of images reaped since since,
+// oldest first.
+func (s *Store) ListTombstones(_ context.Context, since time.Time) ([]redisclient.Tombstone, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []redisclient.Tombstone
+	for _, t := range s.tombstones {
+		if !t.ReapedAt.Before(since) {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+// AddReconcileProgress records the tags of repo in the checkpoint of the
+// reconcile run started at startedAt, starting a checkpoint if there is none.
+func (s *Store) AddReconcileProgress(_ context.Context, startedAt time.Time, repo string, tags []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reconcile.StartedAt.IsZero() {
+		s.reconcile = redisclient.ReconcileCheckpoint{StartedAt: startedAt, Tags: make(map[string][]string)}
+	}
+	s.reconcile.Tags[repo] = slices.Clone(tags)
+	return nil
+}
+
+// ReconcileProgress returns the checkpoint of the current reconcile run.
+func (s *Store) ReconcileProgress(context.Context) (redisclient.ReconcileCheckpoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cp := redisclient.ReconcileCheckpoint{StartedAt: s.reconcile.StartedAt}
+	if s.reconcile.Tags != nil {
+		cp.Tags = make(map[string][]string, len(s.reconcile.Tags))
+		for repo, tags := range s.reconcile.Tags {
+			cp.Tags[repo] = slices.Clone(tags)
+		}
+	}
+	return cp, nil
+}
+
+// ClearReconcileProgress drops the reconcile checkpoint.
+func (s *Store) ClearReconcileProgress(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reconcile = redisclient.ReconcileCheckpoint{}
+	return nil
+}
+
+// ReconcileCursor returns the repository the next reconcile batch starts
+// after, empty to start at the beginning of the catalog.
+func (s *Store) ReconcileCursor(context.Context) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cursor, nil
+}
+
+// SetReconcileCursor stores the repository the next reconcile batch starts
+// after.
+func (s *Store) SetReconcileCursor(_ context.Context, cursor string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cursor = cursor
+	return nil
+}
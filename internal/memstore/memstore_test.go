@@ -0,0 +1,14 @@
+package memstore
+
+import (
+	"testing"
+
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+	"github.com/tamcore/ephemeron/internal/storetest"
+)
+
+func TestStoreContract(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) redisclient.Store {
+		return New()
+	})
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// bytesPerGiB converts bytes to the GiB storage is usually priced in.
+const bytesPerGiB = 1 << 30
+
+// costRefresh bounds how often per-repository usage is recomputed, since
+// doing so reads every tracked image.
+const costRefresh = time.Minute
+
+// CostReader is the subset of the image store read for cost estimates.
+type CostReader interface {
+	ListImages(ctx context.Context) ([]string, error)
+	GetImageSize(ctx context.Context, imageWithTag string) (int64, error)
+	ReapTotals(ctx context.Context) (images, bytes int64, err error)
+}
+
+// MonthlyCost returns the monthly cost in dollars of storing bytes at
+// pricePerGiB dollars per GiB-month.
+func MonthlyCost(bytes int64, pricePerGiB float64) float64 {
+	return float64(bytes) / bytesPerGiB * pricePerGiB
+}
+
+// CostCollector exports storage cost estimates at a configured price: the
+// monthly cost of each repository's tracked images and the monthly cost of
+// the storage reclaimed by reaping so far.
+type CostCollector struct {
+	store       CostReader
+	pricePerGiB float64
+	now         func() time.Time
+	cost        *prometheus.Desc
+	saved       *prometheus.Desc
+
+	mu         sync.Mutex
+	repoBytes  map[string]int64
+	computedAt time.Time
+}
+
+// NewCostCollector returns a collector pricing storage at pricePerGiB
+// dollars per GiB-month.
+func NewCostCollector(store CostReader, pricePerGiB float64) *CostCollector {
+	return &CostCollector{
+		store:       store,
+		pricePerGiB: pricePerGiB,
+		now:         time.Now,
+		cost: prometheus.NewDesc(
+			prometheus.BuildFQName(nsEphemeron, subsStorage, "estimated_cost_dollars"),
+			"Estimated monthly cost in dollars of the images tracked per repository.",
+			[]string{"repository"}, nil,
+		),
+		saved: prometheus.NewDesc(
+			prometheus.BuildFQName(nsEphemeron, subsStorage, "saved_dollars_total"),
+			"Monthly storage cost in dollars avoided by deleting expired images.",
+			nil, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *CostCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.cost
+	ch <- c.saved
+}
+
+// Collect implements prometheus.Collector. Per-repository usage is reused
+// for up to a minute; samples that cannot be read are omitted.
+func (c *CostCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	if repoBytes, err := c.usage(ctx); err != nil {
+		StoreCollectErrors.WithLabelValues("repository_bytes").Inc()
+	} else {
+		for repo, bytes := range repoBytes {
+			ch <- prometheus.MustNewConstMetric(c.cost, prometheus.GaugeValue,
+				MonthlyCost(bytes, c.pricePerGiB), repo)
+		}
+	}
+
+	if _, bytes, err := c.store.ReapTotals(ctx); err != nil {
+		StoreCollectErrors.WithLabelValues("reap_totals").Inc()
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.saved, prometheus.CounterValue, MonthlyCost(bytes, c.pricePerGiB))
+	}
+}
+
+// usage returns the tracked bytes per repository, recomputing them when
+// the cached values are older than costRefresh.
+func (c *CostCollector) usage(ctx context.Context) (map[string]int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.repoBytes != nil && c.now().Sub(c.computedAt) < costRefresh {
+		return c.repoBytes, nil
+	}
+	images, err := c.store.ListImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	repoBytes := make(map[string]int64)
+	for _, image := range images {
+		size, err := c.store.GetImageSize(ctx, image)
+		if err != nil {
+			return nil, err
+		}
+		repo, _, _ := strings.Cut(image, ":")
+		repoBytes[repo] += size
+	}
+	c.repoBytes, c.computedAt = repoBytes, c.now()
+	return repoBytes, nil
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/tamcore/ephemeron/internal/diskusage"
+)
+
+// FilesystemCollector exports the actual size and usage of the filesystem
+// holding the registry's storage, complementing the tracked-bytes estimate
+// summed from manifests.
+type FilesystemCollector struct {
+	path string
+	stat func(path string) (diskusage.Usage, error)
+	size *prometheus.Desc
+	free *prometheus.Desc
+	used *prometheus.Desc
+}
+
+// NewFilesystemCollector returns a collector that stats path on every scrape.
+func NewFilesystemCollector(path string) *FilesystemCollector {
+	return &FilesystemCollector{
+		path: path,
+		stat: diskusage.Stat,
+		size: prometheus.NewDesc(
+			prometheus.BuildFQName(nsEphemeron, subsStorage, "filesystem_size_bytes"),
+			"Size in bytes of the filesystem holding registry storage.",
+			nil, nil,
+		),
+		free: prometheus.NewDesc(
+			prometheus.BuildFQName(nsEphemeron, subsStorage, "filesystem_free_bytes"),
+			"Free bytes on the filesystem holding registry storage.",
+			nil, nil,
+		),
+		used: prometheus.NewDesc(
+			prometheus.BuildFQName(nsEphemeron, subsStorage, "filesystem_used_bytes"),
+			"Used bytes on the filesystem holding registry storage.",
+			nil, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *FilesystemCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.size
+	ch <- c.free
+	ch <- c.used
+}
+
+// Collect implements prometheus.Collector. No samples are emitted when the
+// filesystem cannot be stat'ed.
+func (c *FilesystemCollector) Collect(ch chan<- prometheus.Metric) {
+	u, err := c.stat(c.path)
+	if err != nil {
+		FilesystemStatErrors.Inc()
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, float64(u.TotalBytes))
+	ch <- prometheus.MustNewConstMetric(c.free, prometheus.GaugeValue, float64(u.FreeBytes))
+	ch <- prometheus.MustNewConstMetric(c.used, prometheus.GaugeValue, float64(u.UsedBytes))
+}
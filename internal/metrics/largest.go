@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// largestRefresh bounds how often the largest images are recomputed, since
+// doing so reads every tracked image.
+const largestRefresh = time.Minute
+
+// LargestImagesFunc returns the sizes in bytes of up to limit of the largest
+// tracked images, keyed by image.
+type LargestImagesFunc func(ctx context.Context, limit int) (map[string]int64, error)
+
+// LargestImagesCollector exports the size of the largest tracked images,
+// bounded to a fixed number of series so storage hogs show up on dashboards
+// without an inventory export.
+type LargestImagesCollector struct {
+	largest LargestImagesFunc
+	limit   int
+	now     func() time.Time
+	size    *prometheus.Desc
+
+	mu         sync.Mutex
+	sizes      map[string]int64
+	computedAt time.Time
+}
+
+// NewLargestImagesCollector returns a collector exporting the limit largest
+// images found by largest.
+func NewLargestImagesCollector(largest LargestImagesFunc, limit int) *LargestImagesCollector {
+	return &LargestImagesCollector{
+		largest: largest,
+		limit:   limit,
+		now:     time.Now,
+		size: prometheus.NewDesc(
+			prometheus.BuildFQName(nsEphemeron, subsStorage, "largest_image_bytes"),
+			"Size in bytes of the largest tracked images.",
+			[]string{"image"}, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *LargestImagesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.size
+}
+
+// Collect implements prometheus.Collector. The images are reused for up to
+// a minute and omitted if they cannot be read.
+func (c *LargestImagesCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	sizes, err := c.images(ctx)
+	if err != nil {
+		StoreCollectErrors.WithLabelValues("largest_images").Inc()
+		return
+	}
+	for image, size := range sizes {
+		ch <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, float64(size), image)
+	}
+}
+
+// images returns the largest images, recomputing them when the cached ones
+// are older than largestRefresh.
+func (c *LargestImagesCollector) images(ctx context.Context) (map[string]int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sizes != nil && c.now().Sub(c.computedAt) < largestRefresh {
+		return c.sizes, nil
+	}
+	sizes, err := c.largest(ctx, c.limit)
+	if err != nil {
+		return nil, err
+	}
+	c.sizes, c.computedAt = sizes, c.now()
+	return sizes, nil
+}
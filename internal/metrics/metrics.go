@@ -1,6 +1,10 @@
 package metrics
 
 import (
+	"net/http"
+	"strconv"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -11,8 +15,18 @@ const (
 	subsReaper    = "reaper"
 	subsStorage   = "storage"
 	subsImmutable = "immutability"
+	subsRegistry  = "registry"
+	subsReconcile = "reconcile"
+	subsPolicy    = "policy"
 )
 
+// ttlBuckets spans the TTLs pushes ask for, from 5m to 90d.
+var ttlBuckets = []float64{
+	300, 900, 3600, 21600, 43200, // 5m, 15m, 1h, 6h, 12h
+	86400, 259200, 604800, 1209600, // 1d, 3d, 7d, 14d
+	2592000, 7776000, // 30d, 90d
+}
+
 var (
 	// WebhookEventsTotal counts registry webhook events received.
 	WebhookEventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
@@ -22,21 +36,49 @@ var (
 		Help:      "Total number of registry webhook events received.",
 	}, []string{"action"})
 
+	// WebhookSourceEventsTotal counts registry webhook events by the registry
+	// instance that sent them.
+	WebhookSourceEventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "source_events_total",
+		Help:      "Total number of registry webhook events received, by source registry instance.",
+	}, []string{"source", "action"})
+
+	// WebhookClockSkew is how far the timestamp of the last push event from
+	// each registry instance was off from its receipt time.
+	WebhookClockSkew = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "event_clock_skew_seconds",
+		Help:      "Event timestamp minus receipt time of the last push event, by source registry instance.",
+	}, []string{"source"})
+
+	// WebhookBlobEvents counts blob push and mount events, which are
+	// counted but not tracked, by action.
+	WebhookBlobEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "blob_events_total",
+		Help:      "Total blob push and mount webhook events, by action.",
+	}, []string{"action"})
+
+	// WebhookBlobBytes sums the sizes of the blobs of blob push and mount
+	// events, by action.
+	WebhookBlobBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "blob_bytes_total",
+		Help:      "Total bytes of blobs pushed or mounted across repositories, by action.",
+	}, []string{"action"})
+
 	// ImagesTracked counts images added to TTL tracking.
-	ImagesTracked = promauto.NewCounter(prometheus.CounterOpts{
+	ImagesTracked = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: nsEphemeron,
 		Subsystem: subsHooks,
 		Name:      "images_tracked_total",
 		Help:      "Total number of images added to TTL tracking.",
-	})
-
-	// ImagesReaped counts images deleted by the reaper.
-	ImagesReaped = promauto.NewCounter(prometheus.CounterOpts{
-		Namespace: nsEphemeron,
-		Subsystem: subsReaper,
-		Name:      "images_reaped_total",
-		Help:      "Total number of expired images deleted.",
-	})
+	}, []string{"artifact_type"})
 
 	// ReaperCycleDuration observes the duration of each reap cycle.
 	ReaperCycleDuration = promauto.NewHistogram(prometheus.HistogramOpts{
@@ -55,28 +97,116 @@ var (
 		Help:      "Total number of failed reaper cycles.",
 	})
 
-	// TrackedImagesGauge shows the current number of tracked images.
-	TrackedImagesGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	// ReaperPacingPauses counts deletions delayed because of high registry latency.
+	ReaperPacingPauses = promauto.NewCounter(prometheus.CounterOpts{
 		Namespace: nsEphemeron,
 		Subsystem: subsReaper,
-		Name:      "tracked_images",
-		Help:      "Current number of images being tracked for expiry.",
+		Name:      "pacing_pauses_total",
+		Help:      "Total number of deletions delayed due to high registry latency.",
 	})
 
-	// TrackedBytesTotal shows the total storage currently tracked.
-	TrackedBytesTotal = promauto.NewGauge(prometheus.GaugeOpts{
+	// ReaperLockContention counts lock acquisitions that found the lock held.
+	ReaperLockContention = promauto.NewCounter(prometheus.CounterOpts{
 		Namespace: nsEphemeron,
-		Subsystem: subsStorage,
-		Name:      "tracked_bytes_total",
-		Help:      "Total storage in bytes currently tracked for expiry.",
+		Subsystem: subsReaper,
+		Name:      "lock_contention_total",
+		Help:      "Total number of reaper lock acquisitions that found the lock held by another replica.",
 	})
 
-	// BytesReclaimed counts total storage reclaimed by deletion.
-	BytesReclaimed = promauto.NewCounter(prometheus.CounterOpts{
+	// ReaperLockLost counts cycles aborted because the lock could not be renewed.
+	ReaperLockLost = promauto.NewCounter(prometheus.CounterOpts{
 		Namespace: nsEphemeron,
-		Subsystem: subsStorage,
-		Name:      "bytes_reclaimed_total",
-		Help:      "Total storage in bytes reclaimed by deleting expired images.",
+		Subsystem: subsReaper,
+		Name:      "lock_lost_total",
+		Help:      "Total number of reap cycles aborted after losing the reaper lock.",
+	})
+
+	// ReaperCyclesTruncated counts reap cycles that left expired images for
+	// the next cycle, by the budget that ran out.
+	ReaperCyclesTruncated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "cycles_truncated_total",
+		Help:      "Total number of reap cycles that stopped deleting at their deletion or time budget.",
+	}, []string{"reason"})
+
+	// ReaperBacklog reports the expired images the last reap cycle left for
+	// the next one.
+	ReaperBacklog = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "backlog_images",
+		Help:      "Number of expired images the last reap cycle postponed to the next one.",
+	})
+
+	// StoreDegraded reports whether the reaper found the store unsafe to
+	// delete by at its last check.
+	StoreDegraded = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "store_degraded",
+		Help:      "Whether the store was degraded at the last check (1) or healthy (0).",
+	})
+
+	// DegradedCycles counts reap cycles skipped because the store was
+	// degraded, by reason.
+	DegradedCycles = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "degraded_cycles_total",
+		Help:      "Total number of reap cycles skipped because the store was degraded.",
+	}, []string{"reason"})
+
+	// EmergencyEvictions counts emergency eviction runs.
+	EmergencyEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "emergency_evictions_total",
+		Help:      "Total emergency eviction runs triggered by alerts.",
+	})
+
+	// EvictedImages counts images deleted before their expiry by emergency
+	// eviction.
+	EvictedImages = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "evicted_images_total",
+		Help:      "Total images deleted ahead of their expiry by emergency eviction.",
+	})
+
+	// SizeRepairs counts manifest fetches retried for images tracked with
+	// size 0, by outcome: repaired or failed.
+	SizeRepairs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "size_repairs_total",
+		Help:      "Total manifest fetches retried for images tracked without a size, by outcome.",
+	}, []string{"outcome"})
+
+	// UnsizedImages shows the number of tracked images without a size, as of
+	// the last size repair run.
+	UnsizedImages = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "unsized_images",
+		Help:      "Number of tracked images recorded with size 0, as of the last size repair run.",
+	})
+
+	// GhostRecordsRemoved counts tracked images dropped because their
+	// manifest no longer exists in the registry.
+	GhostRecordsRemoved = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "ghost_records_removed_total",
+		Help:      "Total tracked images removed because their manifest was missing from the registry.",
+	})
+
+	// TrackedImagesGauge shows the current number of tracked images.
+	TrackedImagesGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "tracked_images",
+		Help:      "Current number of images being tracked for expiry.",
 	})
 
 	// ImageSizeBytes observes the size distribution of tracked images.
@@ -99,6 +229,15 @@ var (
 		Help:      "Total number of failures fetching image size from registry.",
 	})
 
+	// SupersededManifests counts manifests of cache repository tags pushed
+	// again, kept for deletion by digest.
+	SupersededManifests = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "superseded_manifests_total",
+		Help:      "Total manifests of cache repository tags pushed again, scheduled for deletion by digest.",
+	})
+
 	// TagOverwritesTotal counts detected tag overwrites.
 	TagOverwritesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: nsEphemeron,
@@ -135,4 +274,494 @@ var (
 		Name:      "immutable_tag_violations_total",
 		Help:      "Total overwrite attempts blocked by immutability enforcement.",
 	}, []string{"repository", "tag"})
+
+	// UnsupportedManifests counts manifests whose format could not be sized.
+	UnsupportedManifests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsRegistry,
+		Name:      "unsupported_manifests_total",
+		Help:      "Total manifests fetched in a format that could not be sized (e.g. schema1).",
+	}, []string{"media_type"})
+
+	// RegistryRequestDuration observes the latency of every registry API call.
+	RegistryRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsRegistry,
+		Name:      "request_duration_seconds",
+		Help:      "Duration of registry API requests in seconds.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "code"})
+
+	// RegistryRetries counts registry requests sent again after a transient
+	// failure, by operation.
+	RegistryRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsRegistry,
+		Name:      "request_retries_total",
+		Help:      "Total registry requests retried after a network error, 429 or 502/503/504.",
+	}, []string{"operation"})
+
+	// RegistryDeleteEnabled reports whether the last delete probe found the
+	// registry accepting deletions.
+	RegistryDeleteEnabled = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsRegistry,
+		Name:      "delete_enabled",
+		Help:      "1 if the last delete probe found the registry accepting deletions, 0 if it refuses them.",
+	})
+
+	// DigestMismatches counts expired tags whose digest recorded at push
+	// differed from the one the registry reported, by the digest deleted.
+	DigestMismatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "digest_mismatches_total",
+		Help:      "Total expired tags whose pushed digest differed from the registry's, by the digest deleted.",
+	}, []string{"deleted"})
+
+	// SupersededDeletions counts expired superseded manifests, by result:
+	// deleted, tagged (a tag points at it again) or failed.
+	SupersededDeletions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "superseded_manifests_total",
+		Help:      "Total expired superseded cache manifests handled, by result.",
+	}, []string{"result"})
+
+	// ReaperTagOverwrites counts expired tags found overwritten since they
+	// were tracked, by the overwrite policy applied.
+	ReaperTagOverwrites = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "tag_overwrites_total",
+		Help:      "Total expired tags found overwritten since they were tracked, by the policy applied.",
+	}, []string{"policy"})
+
+	// DeleteVerificationFailures counts manifests still present after a
+	// DELETE and one retry.
+	DeleteVerificationFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "delete_verification_failures_total",
+		Help:      "Total manifests still present in the registry after a DELETE and one retry.",
+	})
+
+	// RepositoriesDeleted counts repositories removed after their last tag
+	// was reaped.
+	RepositoriesDeleted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "repositories_deleted_total",
+		Help:      "Total repositories deleted after their last tag was reaped.",
+	})
+
+	// ImagesQuarantined counts images moved to quarantine after repeatedly
+	// failing deletion.
+	ImagesQuarantined = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "images_quarantined_total",
+		Help:      "Total images quarantined after repeatedly failing deletion.",
+	})
+
+	// ImagesRestored counts deleted images restored from their tombstone.
+	ImagesRestored = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "images_restored_total",
+		Help:      "Total deleted images restored from their tombstone.",
+	})
+
+	// ArchiveErrors counts manifests and config blobs that could not be
+	// archived before their image was deleted.
+	ArchiveErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "archive_errors_total",
+		Help:      "Total manifests and config blobs that failed to be archived before deletion.",
+	})
+
+	// ActiveFreezes reports the number of freezes currently suspending
+	// deletions.
+	ActiveFreezes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "active_freezes",
+		Help:      "Number of freezes currently suspending deletions.",
+	})
+
+	// ApprovedDeletions counts deletions of protected images carried out
+	// after a second person approved them, by outcome.
+	ApprovedDeletions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "approved_deletions_total",
+		Help:      "Total approved deletions of protected images, by outcome (executed or failed).",
+	}, []string{"status"})
+
+	// DeleteHookFailures counts delete hooks that failed, by phase. A failed
+	// pre-delete hook aborts the deletion.
+	DeleteHookFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "delete_hook_failures_total",
+		Help:      "Total failed delete hooks, by phase (pre_delete or post_delete).",
+	}, []string{"phase"})
+
+	// DeferredDeletions counts expired images left in place because a
+	// cluster workload still references them, by reason.
+	DeferredDeletions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "deferred_deletions_total",
+		Help:      "Total deletions deferred because a workload references the image, by reason (in_use or unknown).",
+	}, []string{"reason"})
+
+	// QuarantinedImages reports the number of images currently excluded from
+	// reaping.
+	QuarantinedImages = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReaper,
+		Name:      "quarantined_images",
+		Help:      "Number of images currently quarantined from reaping.",
+	})
+
+	// ExpiryExtensions counts images whose expiry was extended by pushing an
+	// extension marker tag.
+	ExpiryExtensions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "expiry_extensions_total",
+		Help:      "Total image expiries extended through marker tags.",
+	})
+
+	// PullRequestExpirations counts images expired early because their pull
+	// request was closed or merged.
+	PullRequestExpirations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "pull_request_expirations_total",
+		Help:      "Total images expired because their pull request was closed or merged.",
+	}, []string{"provider"})
+
+	// RequestedTTL observes the TTLs pushes ask for, through a duration tag
+	// or the push policy, before MAX_TTL caps them.
+	RequestedTTL = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "requested_ttl_seconds",
+		Help:      "TTLs requested by tag or push policy, before capping at the max TTL.",
+		Buckets:   ttlBuckets,
+	})
+
+	// EffectiveTTL observes the TTLs pushes are tracked with, after
+	// defaults and capping. Pushes denied by a policy are not observed.
+	EffectiveTTL = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "effective_ttl_seconds",
+		Help:      "TTLs images are tracked with, after defaults and capping at the max TTL.",
+		Buckets:   ttlBuckets,
+	})
+
+	// TTLClamped counts pushes whose requested TTL was cut to the max TTL.
+	TTLClamped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "ttl_clamped_total",
+		Help:      "Total pushes whose requested TTL was capped at the max TTL.",
+	}, []string{"repository"})
+
+	// MalformedTTLTags counts pushes whose tag is meant as a TTL but does
+	// not parse, by whether the push was flagged or rejected.
+	MalformedTTLTags = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "malformed_ttl_tags_total",
+		Help:      "Total pushes with a tag meant as a TTL that does not parse.",
+	}, []string{"repository", "outcome"})
+
+	// NonTTLTagPushes counts pushes whose tag is not a TTL that were
+	// ignored or rejected instead of tracked with the default TTL.
+	NonTTLTagPushes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "non_ttl_tag_pushes_total",
+		Help:      "Total pushes of tags that are not TTLs left untracked, by action (ignore, reject).",
+	}, []string{"action"})
+
+	// HarborProjects counts Harbor projects provisioned for pushed
+	// repositories, by outcome: created, exists or failed.
+	HarborProjects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "harbor_projects_total",
+		Help:      "Total Harbor project provisioning attempts, by outcome (created, exists, failed).",
+	}, []string{"outcome"})
+
+	// ExternalDeletes counts tracked images removed because the registry
+	// reported them deleted outside ephemeron.
+	ExternalDeletes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "external_deletes_total",
+		Help:      "Total tracked images removed after a registry delete event.",
+	})
+
+	// JournaledEvents counts push events journaled to disk while the store
+	// was unavailable.
+	JournaledEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "journaled_events_total",
+		Help:      "Total push events written to the local journal because the store was unavailable.",
+	})
+
+	// JournalReplayed counts journaled events replayed into the store.
+	JournalReplayed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "journal_replayed_total",
+		Help:      "Total journaled events replayed into the store.",
+	})
+
+	// JournalPendingEvents is the number of journaled events waiting to be
+	// written to the store, as of the last replay attempt.
+	JournalPendingEvents = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "journal_pending_events",
+		Help:      "Journaled events not yet written to the store, as of the last replay attempt.",
+	})
+
+	// WebhookInFlight is the number of webhook requests being handled.
+	WebhookInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "webhook_requests_in_flight",
+		Help:      "Number of webhook requests currently being handled (with back-pressure enabled).",
+	})
+
+	// WebhookRejections counts webhook requests answered with 429 because
+	// ephemeron was overloaded.
+	WebhookRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "webhook_rejections_total",
+		Help:      "Total webhook requests rejected with 429 under load, by reason.",
+	}, []string{"reason"})
+
+	// WebhookRequestBytes observes the size of webhook request bodies.
+	WebhookRequestBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "webhook_request_bytes",
+		Help:      "Size of webhook request bodies read, by outcome.",
+		Buckets:   prometheus.ExponentialBuckets(256, 4, 9), // 256B to 16MB
+	}, []string{"outcome"})
+
+	// WebhookDecodeDuration observes how long decoding a webhook body takes.
+	WebhookDecodeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "webhook_decode_duration_seconds",
+		Help:      "Time spent decoding webhook bodies, by outcome.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8), // 100µs to 1.6s
+	}, []string{"outcome"})
+
+	// WebhookEventDuration observes the handling latency of single webhook
+	// events.
+	WebhookEventDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "webhook_event_duration_seconds",
+		Help:      "Time spent handling a single webhook event, by action and outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"action", "outcome"})
+
+	// WebhookStageDuration splits push event handling into its manifest
+	// fetch and store write.
+	WebhookStageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsHooks,
+		Name:      "webhook_stage_duration_seconds",
+		Help:      "Time spent in each stage of push event handling, by stage and outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"stage", "outcome"})
+
+	// PolicyDecisions counts policy webhook decisions, including defaults
+	// used when the webhook failed.
+	PolicyDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsPolicy,
+		Name:      "decisions_total",
+		Help:      "Total policy decisions for pushed images, by decision.",
+	}, []string{"decision"})
+
+	// PolicyErrors counts policy webhook calls that failed or timed out.
+	PolicyErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsPolicy,
+		Name:      "webhook_errors_total",
+		Help:      "Total policy webhook calls that failed and fell back to the default decision.",
+	})
+
+	// PolicyEvalErrors counts embedded Rego policy evaluations that failed.
+	PolicyEvalErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsPolicy,
+		Name:      "rego_errors_total",
+		Help:      "Total Rego policy evaluations that failed and fell back to the default decision.",
+	})
+
+	// PolicyCELErrors counts CEL expression evaluations that failed.
+	PolicyCELErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsPolicy,
+		Name:      "cel_errors_total",
+		Help:      "Total CEL expression evaluations that failed and kept the default.",
+	})
+
+	// PolicyReloadErrors counts Rego policy files that changed but could not
+	// be loaded; the previous policy stays in effect.
+	PolicyReloadErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsPolicy,
+		Name:      "rego_reload_errors_total",
+		Help:      "Total Rego policy reloads that failed and kept the previous policy.",
+	})
+
+	// StoreCollectErrors counts scrapes where store-backed metrics could not
+	// be read.
+	StoreCollectErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsStorage,
+		Name:      "collect_errors_total",
+		Help:      "Total scrapes that failed to read a store-backed metric.",
+	}, []string{"metric"})
+
+	// BucketUsageBytes is the measured size of the registry's storage bucket
+	// prefix.
+	BucketUsageBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsStorage,
+		Name:      "bucket_usage_bytes",
+		Help:      "Bytes stored under the registry prefix of the storage bucket, extrapolated when sampled.",
+	})
+
+	// BucketObjects is the number of objects under the registry's storage
+	// bucket prefix.
+	BucketObjects = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsStorage,
+		Name:      "bucket_objects",
+		Help:      "Objects under the registry prefix of the storage bucket, extrapolated when sampled.",
+	})
+
+	// BucketProbeErrors counts failed storage bucket listings.
+	BucketProbeErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsStorage,
+		Name:      "bucket_probe_errors_total",
+		Help:      "Total storage bucket usage probes that failed.",
+	})
+
+	// ExportUploadErrors counts failed scheduled inventory exports.
+	ExportUploadErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsStorage,
+		Name:      "export_upload_errors_total",
+		Help:      "Total scheduled inventory exports that failed to upload.",
+	})
+
+	// ReconcileUntrackedTags is the number of registry tags without a
+	// tracking record, as of the last reconcile diff.
+	ReconcileUntrackedTags = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReconcile,
+		Name:      "untracked_tags",
+		Help:      "Registry tags that are not tracked for expiry, as of the last reconcile.",
+	})
+
+	// ReconcileGhostRecords is the number of tracking records whose tag is
+	// missing from the registry, as of the last reconcile diff.
+	ReconcileGhostRecords = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsReconcile,
+		Name:      "ghost_records",
+		Help:      "Tracked images whose tag is missing from the registry, as of the last reconcile.",
+	})
+
+	// FilesystemStatErrors counts scrapes where the registry filesystem could
+	// not be stat'ed.
+	FilesystemStatErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Subsystem: subsStorage,
+		Name:      "filesystem_stat_errors_total",
+		Help:      "Total scrapes that failed to read registry filesystem usage.",
+	})
+
+	// FaultsInjectedTotal counts the delays and failures added by fault
+	// injection, by target ("store" or "registry") and kind.
+	FaultsInjectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Name:      "faults_injected_total",
+		Help:      "Total delays and failures added to store and registry calls by fault injection.",
+	}, []string{"target", "kind"})
+
+	// SecretRefreshFailuresTotal counts failed fetches of secrets from
+	// files and secret managers, by secret name.
+	SecretRefreshFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Name:      "secret_refresh_failures_total",
+		Help:      "Total failed fetches of secrets from files and secret managers.",
+	}, []string{"secret"})
+
+	// StoreNewerRecordsTotal counts store records left alone because a
+	// newer version of ephemeron wrote them with an incompatible encoding,
+	// by kind of record.
+	StoreNewerRecordsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsEphemeron,
+		Name:      "store_newer_records_total",
+		Help:      "Total store records skipped because a newer version wrote them in an incompatible encoding.",
+	}, []string{"kind"})
 )
+
+// Registry operations used as the "operation" label.
+const (
+	OpCatalog        = "catalog"
+	OpTags           = "tags"
+	OpManifestGet    = "manifest_get"
+	OpManifestHead   = "manifest_head"
+	OpManifestDelete = "manifest_delete"
+	OpManifestPut    = "manifest_put"
+	OpBlobHead       = "blob_head"
+	OpBlobGet        = "blob_get"
+	OpBlobUpload     = "blob_upload"
+)
+
+// Webhook handling stages used as the "stage" label.
+const (
+	StageManifestFetch = "manifest_fetch"
+	StageStoreWrite    = "store_write"
+)
+
+// ObserveWebhookStage records the duration of a webhook handling stage
+// started at start. The outcome label is "ok" or "error".
+func ObserveWebhookStage(stage string, start time.Time, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	WebhookStageDuration.WithLabelValues(stage, outcome).Observe(time.Since(start).Seconds())
+}
+
+// ObserveRegistryRequest records the duration of a registry call started at
+// start. The code label is the HTTP status, or "error" if no response arrived.
+func ObserveRegistryRequest(op string, start time.Time, resp *http.Response, err error) {
+	code := "error"
+	if err == nil && resp != nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	RegistryRequestDuration.WithLabelValues(op, code).Observe(time.Since(start).Seconds())
+}
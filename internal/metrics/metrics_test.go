@@ -0,0 +1,170 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tamcore/ephemeron/internal/diskusage"
+)
+
+type fakeStore struct {
+	bytes, reaped, reclaimed int64
+	err                      error
+}
+
+func (f fakeStore) TrackedBytes(context.Context) (int64, error) { return f.bytes, f.err }
+
+func (f fakeStore) ReapTotals(context.Context) (int64, int64, error) {
+	return f.reaped, f.reclaimed, f.err
+}
+
+func TestStoreCollector(t *testing.T) {
+	c := NewStoreCollector(fakeStore{bytes: 4096, reaped: 3, reclaimed: 1024})
+	want := `
+# HELP ephemeron_reaper_images_reaped_total Total number of expired images deleted.
+# TYPE ephemeron_reaper_images_reaped_total counter
+ephemeron_reaper_images_reaped_total 3
+# HELP ephemeron_storage_bytes_reclaimed_total Total storage in bytes reclaimed by deleting expired images.
+# TYPE ephemeron_storage_bytes_reclaimed_total counter
+ephemeron_storage_bytes_reclaimed_total 1024
+# HELP ephemeron_storage_tracked_bytes_total Total storage in bytes currently tracked for expiry.
+# TYPE ephemeron_storage_tracked_bytes_total gauge
+ephemeron_storage_tracked_bytes_total 4096
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreCollector_StoreError(t *testing.T) {
+	c := NewStoreCollector(fakeStore{err: errors.New("down")})
+	before := testutil.ToFloat64(StoreCollectErrors.WithLabelValues("tracked_bytes"))
+	if n := testutil.CollectAndCount(c); n != 0 {
+		t.Fatalf("expected no samples on store error, got %d", n)
+	}
+	if got := testutil.ToFloat64(StoreCollectErrors.WithLabelValues("tracked_bytes")); got != before+1 {
+		t.Fatalf("errors counter = %v, want %v", got, before+1)
+	}
+}
+
+func TestFilesystemCollector(t *testing.T) {
+	c := NewFilesystemCollector("/data")
+	c.stat = func(path string) (diskusage.Usage, error) {
+		if path != "/data" {
+			t.Errorf("stat path = %q, want /data", path)
+		}
+		return diskusage.Usage{TotalBytes: 1000, FreeBytes: 300, UsedBytes: 650}, nil
+	}
+	want := `
+# HELP ephemeron_storage_filesystem_free_bytes Free bytes on the filesystem holding registry storage.
+# TYPE ephemeron_storage_filesystem_free_bytes gauge
+ephemeron_storage_filesystem_free_bytes 300
+# HELP ephemeron_storage_filesystem_size_bytes Size in bytes of the filesystem holding registry storage.
+# TYPE ephemeron_storage_filesystem_size_bytes gauge
+ephemeron_storage_filesystem_size_bytes 1000
+# HELP ephemeron_storage_filesystem_used_bytes Used bytes on the filesystem holding registry storage.
+# TYPE ephemeron_storage_filesystem_used_bytes gauge
+ephemeron_storage_filesystem_used_bytes 650
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFilesystemCollector_StatError(t *testing.T) {
+	c := NewFilesystemCollector("/data")
+	c.stat = func(string) (diskusage.Usage, error) { return diskusage.Usage{}, errors.New("gone") }
+	before := testutil.ToFloat64(FilesystemStatErrors)
+	if n := testutil.CollectAndCount(c); n != 0 {
+		t.Fatalf("expected no samples on stat error, got %d", n)
+	}
+	if got := testutil.ToFloat64(FilesystemStatErrors); got != before+1 {
+		t.Fatalf("errors counter = %v, want %v", got, before+1)
+	}
+}
+
+type fakeCostStore struct {
+	fakeStore
+	sizes map[string]int64
+	lists int
+}
+
+func (f *fakeCostStore) ListImages(context.Context) ([]string, error) {
+	f.lists++
+	images := make([]string, 0, len(f.sizes))
+	for image := range f.sizes {
+		images = append(images, image)
+	}
+	return images, f.err
+}
+
+func (f *fakeCostStore) GetImageSize(_ context.Context, image string) (int64, error) {
+	return f.sizes[image], f.err
+}
+
+func TestCostCollector(t *testing.T) {
+	store := &fakeCostStore{
+		fakeStore: fakeStore{reclaimed: 4 << 30},
+		sizes:     map[string]int64{"team-a/app:v1": 1 << 30, "team-a/app:v2": 1 << 30, "other:1h": 1 << 29},
+	}
+	c := NewCostCollector(store, 0.5)
+	want := `
+# HELP ephemeron_storage_estimated_cost_dollars Estimated monthly cost in dollars of the images tracked per repository.
+# TYPE ephemeron_storage_estimated_cost_dollars gauge
+ephemeron_storage_estimated_cost_dollars{repository="other"} 0.25
+ephemeron_storage_estimated_cost_dollars{repository="team-a/app"} 1
+# HELP ephemeron_storage_saved_dollars_total Monthly storage cost in dollars avoided by deleting expired images.
+# TYPE ephemeron_storage_saved_dollars_total counter
+ephemeron_storage_saved_dollars_total 2
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Usage is cached between scrapes.
+	testutil.CollectAndCount(c)
+	if store.lists != 1 {
+		t.Errorf("expected images listed once, got %d", store.lists)
+	}
+	c.now = func() time.Time { return time.Now().Add(costRefresh) }
+	testutil.CollectAndCount(c)
+	if store.lists != 2 {
+		t.Errorf("expected images listed again after %v, got %d lists", costRefresh, store.lists)
+	}
+}
+
+func TestLargestImagesCollector(t *testing.T) {
+	var calls, gotLimit int
+	c := NewLargestImagesCollector(func(_ context.Context, limit int) (map[string]int64, error) {
+		calls++
+		gotLimit = limit
+		return map[string]int64{"app:1h": 2048, "db:6h": 1024}, nil
+	}, 2)
+	want := `
+# HELP ephemeron_storage_largest_image_bytes Size in bytes of the largest tracked images.
+# TYPE ephemeron_storage_largest_image_bytes gauge
+ephemeron_storage_largest_image_bytes{image="app:1h"} 2048
+ephemeron_storage_largest_image_bytes{image="db:6h"} 1024
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+	if gotLimit != 2 {
+		t.Errorf("limit = %d, want 2", gotLimit)
+	}
+
+	testutil.CollectAndCount(c)
+	if calls != 1 {
+		t.Errorf("expected images computed once, got %d", calls)
+	}
+	c.now = func() time.Time { return time.Now().Add(largestRefresh) }
+	testutil.CollectAndCount(c)
+	if calls != 2 {
+		t.Errorf("expected images computed again after %v, got %d", largestRefresh, calls)
+	}
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// storeTimeout bounds the store reads performed on each scrape.
+const storeTimeout = 5 * time.Second
+
+// StoreReader is the subset of the image store read at scrape time.
+type StoreReader interface {
+	TrackedBytes(ctx context.Context) (int64, error)
+	ReapTotals(ctx context.Context) (images, bytes int64, err error)
+}
+
+// StoreCollector exports metrics whose source of truth is the store rather
+// than process memory, so values survive restarts and are identical on
+// every replica.
+type StoreCollector struct {
+	store          StoreReader
+	trackedBytes   *prometheus.Desc
+	imagesReaped   *prometheus.Desc
+	bytesReclaimed *prometheus.Desc
+}
+
+// NewStoreCollector returns a collector that reads store on every scrape.
+func NewStoreCollector(store StoreReader) *StoreCollector {
+	return &StoreCollector{
+		store: store,
+		trackedBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(nsEphemeron, subsStorage, "tracked_bytes_total"),
+			"Total storage in bytes currently tracked for expiry.",
+			nil, nil,
+		),
+		imagesReaped: prometheus.NewDesc(
+			prometheus.BuildFQName(nsEphemeron, subsReaper, "images_reaped_total"),
+			"Total number of expired images deleted.",
+			nil, nil,
+		),
+		bytesReclaimed: prometheus.NewDesc(
+			prometheus.BuildFQName(nsEphemeron, subsStorage, "bytes_reclaimed_total"),
+			"Total storage in bytes reclaimed by deleting expired images.",
+			nil, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *StoreCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.trackedBytes
+	ch <- c.imagesReaped
+	ch <- c.bytesReclaimed
+}
+
+// Collect implements prometheus.Collector. Samples that cannot be read are
+// omitted rather than failing the whole scrape.
+func (c *StoreCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	if total, err := c.store.TrackedBytes(ctx); err != nil {
+		StoreCollectErrors.WithLabelValues("tracked_bytes").Inc()
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.trackedBytes, prometheus.GaugeValue, float64(total))
+	}
+
+	if images, bytes, err := c.store.ReapTotals(ctx); err != nil {
+		StoreCollectErrors.WithLabelValues("reap_totals").Inc()
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.imagesReaped, prometheus.CounterValue, float64(images))
+		ch <- prometheus.MustNewConstMetric(c.bytesReclaimed, prometheus.CounterValue, float64(bytes))
+	}
+}
@@ -0,0 +1,53 @@
+// Package owners maps repositories to the team responsible for them, so
+// listings and expiry-related output can point at a contact.
+package owners
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Rule assigns Owner to repositories matching Pattern.
+type Rule struct {
+	// Pattern is a path.Match glob, e.g. "team-a/*".
+	Pattern string
+	// Owner is a free-form contact such as "#team-a" or "team-a@example.com".
+	Owner string
+}
+
+// Resolver looks up repository owners. The first matching rule wins.
+type Resolver struct {
+	rules []Rule
+}
+
+// Parse builds a Resolver from "pattern=owner" entries.
+func Parse(entries []string) (*Resolver, error) {
+	rules := make([]Rule, 0, len(entries))
+	for _, entry := range entries {
+		pattern, owner, ok := strings.Cut(entry, "=")
+		pattern, owner = strings.TrimSpace(pattern), strings.TrimSpace(owner)
+		if !ok || pattern == "" || owner == "" {
+			return nil, fmt.Errorf("invalid owner entry %q (want pattern=owner)", entry)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid owner pattern %q: %w", pattern, err)
+		}
+		rules = append(rules, Rule{Pattern: pattern, Owner: owner})
+	}
+	return &Resolver{rules: rules}, nil
+}
+
+// Owner returns the owner of repo, or "" if no rule matches. A nil
+// Resolver has no owners.
+func (r *Resolver) Owner(repo string) string {
+	if r == nil {
+		return ""
+	}
+	for _, rule := range r.rules {
+		if ok, _ := path.Match(rule.Pattern, repo); ok {
+			return rule.Owner
+		}
+	}
+	return ""
+}
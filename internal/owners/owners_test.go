@@ -0,0 +1,45 @@
+package owners
+
+import "testing"
+
+func TestResolver_Owner(t *testing.T) {
+	r, err := Parse([]string{"team-a/*=#team-a", "billing-*=billing@example.com", "*=#platform"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		repo string
+		want string
+	}{
+		{"team-a/api", "#team-a"},
+		{"billing-worker", "billing@example.com"},
+		{"misc", "#platform"},
+		{"team-b/api", ""}, // "*" does not cross path separators
+	}
+	for _, tt := range tests {
+		if got := r.Owner(tt.repo); got != tt.want {
+			t.Errorf("Owner(%q) = %q, want %q", tt.repo, got, tt.want)
+		}
+	}
+}
+
+func TestResolver_Nil(t *testing.T) {
+	var r *Resolver
+	if got := r.Owner("anything"); got != "" {
+		t.Errorf("nil resolver returned %q", got)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, entries := range [][]string{
+		{"no-separator"},
+		{"=owner"},
+		{"pattern="},
+		{"[=owner"},
+	} {
+		if _, err := Parse(entries); err == nil {
+			t.Errorf("Parse(%q) expected error", entries)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+)
+
+// CEL evaluates Common Expression Language expressions on every push, for
+// rules that outgrow tag patterns but do not need a full Rego policy.
+//
+// Expressions see the push as the variables repo, tag, digest, size (bytes),
+// artifact_type, actor and ttl (the TTL ephemeron would apply, a duration),
+// plus the size constants KB, MB and GB.
+type CEL struct {
+	ttl      cel.Program
+	protect  cel.Program
+	priority cel.Program
+	logger   *slog.Logger
+}
+
+// NewCEL compiles ttlExpr, which must yield a duration, protectExpr, which
+// must yield a bool, and priorityExpr, which must yield an int. Any may be
+// empty. A non-positive TTL keeps the one ephemeron computed.
+func NewCEL(ttlExpr, protectExpr, priorityExpr string, logger *slog.Logger) (*CEL, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("repo", cel.StringType),
+		cel.Variable("tag", cel.StringType),
+		cel.Variable("digest", cel.StringType),
+		cel.Variable("size", cel.IntType),
+		cel.Variable("artifact_type", cel.StringType),
+		cel.Variable("actor", cel.StringType),
+		cel.Variable("ttl", cel.DurationType),
+		cel.Constant("KB", cel.IntType, types.Int(1<<10)),
+		cel.Constant("MB", cel.IntType, types.Int(1<<20)),
+		cel.Constant("GB", cel.IntType, types.Int(1<<30)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	c := &CEL{logger: logger}
+	if c.ttl, err = compile(env, ttlExpr, cel.DurationType); err != nil {
+		return nil, fmt.Errorf("TTL expression: %w", err)
+	}
+	if c.protect, err = compile(env, protectExpr, cel.BoolType); err != nil {
+		return nil, fmt.Errorf("protection expression: %w", err)
+	}
+	if c.priority, err = compile(env, priorityExpr, cel.IntType); err != nil {
+		return nil, fmt.Errorf("priority expression: %w", err)
+	}
+	return c, nil
+}
+
+// compile type-checks expr against want. An empty expr yields a nil program.
+func compile(env *cel.Env, expr string, want *cel.Type) (cel.Program, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	ast, iss := env.Compile(expr)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	if !ast.OutputType().IsExactType(want) {
+		return nil, fmt.Errorf("must yield %s, not %s", want, ast.OutputType())
+	}
+	return env.Program(ast)
+}
+
+// Check evaluates the expressions for req. CEL expressions never deny a
+// push; an expression that fails to evaluate leaves its part of the
+// decision at the default.
+func (c *CEL) Check(ctx context.Context, req Request) Decision {
+	vars := map[string]any{
+		"repo":          req.Repository,
+		"tag":           req.Tag,
+		"digest":        req.Digest,
+		"size":          req.SizeBytes,
+		"artifact_type": req.ArtifactType,
+		"actor":         req.Actor,
+		"ttl":           time.Duration(req.TTLSeconds) * time.Second,
+	}
+	d := Decision{Allow: true}
+	if c.ttl != nil {
+		if out, _, err := c.ttl.ContextEval(ctx, vars); err != nil {
+			c.fail(req, "ttl", err)
+			d.Fallback = true
+		} else if ttl := out.(types.Duration).Duration; ttl > 0 {
+			d.TTL = ttl
+		}
+	}
+	if c.protect != nil {
+		if out, _, err := c.protect.ContextEval(ctx, vars); err != nil {
+			c.fail(req, "protect", err)
+			d.Fallback = true
+		} else {
+			d.Protected = bool(out.(types.Bool))
+		}
+	}
+	if c.priority != nil {
+		if out, _, err := c.priority.ContextEval(ctx, vars); err != nil {
+			c.fail(req, "priority", err)
+			d.Fallback = true
+		} else {
+			d.Priority = int(out.(types.Int))
+		}
+	}
+	record(d)
+	return d
+}
+
+func (c *CEL) fail(req Request, expr string, err error) {
+	c.logger.Warn("CEL expression failed, using default",
+		"image", req.Repository+":"+req.Tag,
+		"expression", expr,
+		"error", err,
+	)
+	metrics.PolicyCELErrors.Inc()
+}
@@ -0,0 +1,66 @@
+package policy
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestCEL_Check(t *testing.T) {
+	c, err := NewCEL(
+		`repo.startsWith("ci/") && size < 500 * MB ? duration("2h") : ttl`,
+		`actor == "release-bot"`,
+		`size >= 1 * GB ? 10 : 0`,
+		slog.Default(),
+	)
+	if err != nil {
+		t.Fatalf("NewCEL: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		req  Request
+		want Decision
+	}{
+		{name: "small ci image", req: Request{Repository: "ci/app", SizeBytes: 10 << 20, TTLSeconds: 86400},
+			want: Decision{Allow: true, TTL: 2 * time.Hour}},
+		{name: "large ci image keeps ttl", req: Request{Repository: "ci/app", SizeBytes: 1 << 30, TTLSeconds: 86400},
+			want: Decision{Allow: true, TTL: 24 * time.Hour, Priority: 10}},
+		{name: "protected", req: Request{Repository: "app", Actor: "release-bot", TTLSeconds: 3600},
+			want: Decision{Allow: true, TTL: time.Hour, Protected: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.Check(t.Context(), tt.req); got != tt.want {
+				t.Errorf("Check = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCEL_EvalErrorKeepsDefault(t *testing.T) {
+	c, err := NewCEL(`duration(tag)`, "", "", slog.Default())
+	if err != nil {
+		t.Fatalf("NewCEL: %v", err)
+	}
+	want := Decision{Allow: true, Fallback: true}
+	if got := c.Check(t.Context(), Request{Repository: "app", Tag: "latest"}); got != want {
+		t.Errorf("Check = %+v, want %+v", got, want)
+	}
+}
+
+func TestNewCEL_Invalid(t *testing.T) {
+	for _, tt := range []struct{ name, ttl, protect, priority string }{
+		{name: "syntax error", ttl: `duration("1h"`},
+		{name: "unknown variable", protect: `owner == "me"`},
+		{name: "wrong ttl type", ttl: `size > 0`},
+		{name: "wrong protect type", protect: `duration("1h")`},
+		{name: "wrong priority type", priority: `size > 0`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewCEL(tt.ttl, tt.protect, tt.priority, slog.Default()); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+// Package policy asks an external policy service whether a pushed image may
+// stay in the registry and for how long, so retention rules can be managed
+// centrally instead of in each ephemeron deployment.
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+)
+
+// Request describes a push sent to the policy service.
+type Request struct {
+	Repository   string `json:"repository"`
+	Tag          string `json:"tag"`
+	Digest       string `json:"digest,omitempty"`
+	SizeBytes    int64  `json:"size_bytes"`
+	ArtifactType string `json:"artifact_type"`
+	Actor        string `json:"actor,omitempty"`
+	// TTLSeconds is the TTL ephemeron would apply on its own.
+	TTLSeconds int64 `json:"ttl_seconds"`
+}
+
+// response is the body returned by the policy service.
+type response struct {
+	Allow *bool `json:"allow"`
+	// TTLSeconds overrides the TTL when positive.
+	TTLSeconds int64  `json:"ttl_seconds"`
+	Reason     string `json:"reason"`
+	Immutable  bool   `json:"immutable"`
+	Protected  bool   `json:"protected"`
+	Priority   int    `json:"priority"`
+}
+
+// Decision is the outcome of a policy check.
+type Decision struct {
+	Allow bool
+	// TTL overrides the TTL ephemeron computed; zero keeps it.
+	TTL    time.Duration
+	Reason string
+	// Immutable rejects later overwrites of the tag with different content.
+	Immutable bool
+	// Protected keeps the reaper from deleting the image once it expires.
+	Protected bool
+	// Priority orders deletions once the image expires: the reaper deletes
+	// expired images with higher priorities first.
+	Priority int
+	// Fallback is true when the service could not be asked and the default
+	// decision was used.
+	Fallback bool
+}
+
+// Client calls the policy webhook.
+type Client struct {
+	url            string
+	token          string
+	allowByDefault bool
+	httpClient     *http.Client
+	logger         *slog.Logger
+}
+
+// New returns a Client that POSTs to url, sending token as a bearer token
+// if set. Requests taking longer than timeout, and any other failure, fall
+// back to allowing the push when allowByDefault is true and to denying it
+// otherwise.
+func New(url, token string, timeout time.Duration, allowByDefault bool, logger *slog.Logger) *Client {
+	return &Client{
+		url:            url,
+		token:          token,
+		allowByDefault: allowByDefault,
+		httpClient:     &http.Client{Timeout: timeout},
+		logger:         logger,
+	}
+}
+
+// Check asks the policy service about req. It never fails; errors yield the
+// default decision.
+func (c *Client) Check(ctx context.Context, req Request) Decision {
+	d, err := c.check(ctx, req)
+	if err != nil {
+		c.logger.Warn("policy webhook failed, using default decision",
+			"image", req.Repository+":"+req.Tag,
+			"allow", c.allowByDefault,
+			"error", err,
+		)
+		metrics.PolicyErrors.Inc()
+		d = Decision{Allow: c.allowByDefault, Reason: "policy webhook unavailable", Fallback: true}
+	}
+	record(d)
+	return d
+}
+
+func (c *Client) check(ctx context.Context, req Request) (Decision, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return Decision{}, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return Decision{}, fmt.Errorf("creating policy request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return Decision{}, fmt.Errorf("calling policy webhook: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return Decision{}, fmt.Errorf("policy webhook returned %d", resp.StatusCode)
+	}
+
+	var r response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return Decision{}, fmt.Errorf("decoding policy response: %w", err)
+	}
+	if r.Allow == nil {
+		return Decision{}, fmt.Errorf("policy response without allow")
+	}
+	return r.decision(), nil
+}
+
+// decision converts a response with allow set into a Decision.
+func (r response) decision() Decision {
+	d := Decision{
+		Allow:     *r.Allow,
+		Reason:    r.Reason,
+		Immutable: r.Immutable,
+		Protected: r.Protected,
+		Priority:  r.Priority,
+	}
+	if r.TTLSeconds > 0 {
+		d.TTL = time.Duration(r.TTLSeconds) * time.Second
+	}
+	return d
+}
+
+// record counts a decision in the policy metrics.
+func record(d Decision) {
+	decision := "deny"
+	if d.Allow {
+		decision = "allow"
+	}
+	metrics.PolicyDecisions.WithLabelValues(decision).Inc()
+}
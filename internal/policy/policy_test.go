@@ -0,0 +1,107 @@
+package policy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name           string
+		handler        http.HandlerFunc
+		allowByDefault bool
+		want           Decision
+	}{
+		{
+			name: "allow with ttl override",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"allow": true, "ttl_seconds": 7200}`))
+			},
+			want: Decision{Allow: true, TTL: 2 * time.Hour},
+		},
+		{
+			name: "priority",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"allow": true, "priority": 10}`))
+			},
+			want: Decision{Allow: true, Priority: 10},
+		},
+		{
+			name: "deny with reason",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"allow": false, "reason": "no images over 1GB"}`))
+			},
+			allowByDefault: true,
+			want:           Decision{Reason: "no images over 1GB"},
+		},
+		{
+			name: "server error falls back to allow",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			allowByDefault: true,
+			want:           Decision{Allow: true, Reason: "policy webhook unavailable", Fallback: true},
+		},
+		{
+			name: "timeout falls back to deny",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(200 * time.Millisecond)
+			},
+			want: Decision{Reason: "policy webhook unavailable", Fallback: true},
+		},
+		{
+			name: "missing allow falls back",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"ttl_seconds": 60}`))
+			},
+			allowByDefault: true,
+			want:           Decision{Allow: true, Reason: "policy webhook unavailable", Fallback: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			c := New(srv.URL, "", 50*time.Millisecond, tt.allowByDefault, slog.Default())
+			if got := c.Check(t.Context(), Request{Repository: "app", Tag: "1h"}); got != tt.want {
+				t.Errorf("Check = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck_SendsRequest(t *testing.T) {
+	var got Request
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_, _ = w.Write([]byte(`{"allow": true}`))
+	}))
+	defer srv.Close()
+
+	want := Request{
+		Repository:   "team/app",
+		Tag:          "1h",
+		Digest:       "sha256:abc",
+		SizeBytes:    1024,
+		ArtifactType: "image",
+		Actor:        "ci-bot",
+		TTLSeconds:   3600,
+	}
+	c := New(srv.URL, "s3cret", time.Second, false, slog.Default())
+	if d := c.Check(t.Context(), want); !d.Allow || d.TTL != 0 {
+		t.Fatalf("Check = %+v, want allow without override", d)
+	}
+	if got != want {
+		t.Errorf("policy service received %+v, want %+v", got, want)
+	}
+	if auth != "Bearer s3cret" {
+		t.Errorf("Authorization = %q, want bearer token", auth)
+	}
+}
@@ -0,0 +1,147 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/v1/rego"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+)
+
+// regoQuery is the document a Rego policy file must define. Its rules use
+// the same names as the webhook response: allow, ttl_seconds, reason,
+// immutable, protected and priority. The push is available as input, with the fields
+// of Request.
+const regoQuery = "data.ephemeron"
+
+// Rego evaluates an embedded Rego policy file on every push, as an
+// alternative to the policy webhook.
+type Rego struct {
+	path           string
+	allowByDefault bool
+	logger         *slog.Logger
+
+	mu      sync.RWMutex
+	query   rego.PreparedEvalQuery
+	modTime time.Time
+}
+
+// NewRego compiles the policy in path. Evaluation failures fall back to
+// allowing the push when allowByDefault is true and to denying it
+// otherwise.
+func NewRego(ctx context.Context, path string, allowByDefault bool, logger *slog.Logger) (*Rego, error) {
+	r := &Rego{path: path, allowByDefault: allowByDefault, logger: logger}
+	if _, err := r.load(ctx); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load compiles the policy file if it changed since the last load and
+// reports whether it did.
+func (r *Rego) load(ctx context.Context) (bool, error) {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false, fmt.Errorf("reading policy file: %w", err)
+	}
+	r.mu.RLock()
+	unchanged := info.ModTime().Equal(r.modTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	src, err := os.ReadFile(r.path)
+	if err != nil {
+		return false, fmt.Errorf("reading policy file: %w", err)
+	}
+	query, err := rego.New(
+		rego.Query(regoQuery),
+		rego.Module(r.path, string(src)),
+	).PrepareForEval(ctx)
+	if err != nil {
+		return false, fmt.Errorf("compiling policy %s: %w", r.path, err)
+	}
+
+	r.mu.Lock()
+	r.query = query
+	r.modTime = info.ModTime()
+	r.mu.Unlock()
+	return true, nil
+}
+
+// ReloadLoop recompiles the policy whenever the file changes, checking
+// every interval until ctx is cancelled. A policy that fails to compile is
+// logged and the previous one stays in effect.
+func (r *Rego) ReloadLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := r.load(ctx)
+			if err != nil {
+				r.logger.Error("failed to reload policy, keeping previous one", "path", r.path, "error", err)
+				metrics.PolicyReloadErrors.Inc()
+				continue
+			}
+			if reloaded {
+				r.logger.Info("policy reloaded", "path", r.path)
+			}
+		}
+	}
+}
+
+// Check evaluates the policy for req. It never fails; errors yield the
+// default decision.
+func (r *Rego) Check(ctx context.Context, req Request) Decision {
+	d, err := r.check(ctx, req)
+	if err != nil {
+		r.logger.Warn("policy evaluation failed, using default decision",
+			"image", req.Repository+":"+req.Tag,
+			"allow", r.allowByDefault,
+			"error", err,
+		)
+		metrics.PolicyEvalErrors.Inc()
+		d = Decision{Allow: r.allowByDefault, Reason: "policy evaluation failed", Fallback: true}
+	}
+	record(d)
+	return d
+}
+
+func (r *Rego) check(ctx context.Context, req Request) (Decision, error) {
+	r.mu.RLock()
+	query := r.query
+	r.mu.RUnlock()
+
+	rs, err := query.Eval(ctx, rego.EvalInput(req))
+	if err != nil {
+		return Decision{}, fmt.Errorf("evaluating policy: %w", err)
+	}
+	// An undefined document means the policy has no opinion.
+	var doc any = map[string]any{}
+	if len(rs) > 0 && len(rs[0].Expressions) > 0 {
+		doc = rs[0].Expressions[0].Value
+	}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return Decision{}, err
+	}
+	var resp response
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return Decision{}, fmt.Errorf("decoding policy result: %w", err)
+	}
+	if resp.Allow == nil {
+		allow := true
+		resp.Allow = &allow
+	}
+	return resp.decision(), nil
+}
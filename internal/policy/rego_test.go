@@ -0,0 +1,115 @@
+package policy
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testPolicy = `package ephemeron
+
+default allow := true
+
+allow := false if input.size_bytes > 1000
+
+reason := "too big" if not allow
+
+ttl_seconds := 86400 if startswith(input.repository, "release/")
+
+immutable if startswith(input.tag, "v")
+
+protected if input.actor == "release-bot"
+
+priority := 5 if startswith(input.repository, "ci/")
+`
+
+func writePolicy(t *testing.T, path, src string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRego_Check(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.rego")
+	writePolicy(t, path, testPolicy, time.Now())
+	r, err := NewRego(t.Context(), path, false, slog.Default())
+	if err != nil {
+		t.Fatalf("NewRego: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		req  Request
+		want Decision
+	}{
+		{name: "defaults", req: Request{Repository: "app", Tag: "1h"}, want: Decision{Allow: true}},
+		{name: "deny", req: Request{Repository: "app", Tag: "1h", SizeBytes: 2000},
+			want: Decision{Reason: "too big"}},
+		{name: "ttl override", req: Request{Repository: "release/app", Tag: "1h"},
+			want: Decision{Allow: true, TTL: 24 * time.Hour}},
+		{name: "immutable and protected", req: Request{Repository: "app", Tag: "v1.2.0", Actor: "release-bot"},
+			want: Decision{Allow: true, Immutable: true, Protected: true}},
+		{name: "priority", req: Request{Repository: "ci/app", Tag: "1h"}, want: Decision{Allow: true, Priority: 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.Check(t.Context(), tt.req); got != tt.want {
+				t.Errorf("Check = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRego_EvalErrorFallsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.rego")
+	// Conflicting values for a complete rule only fail at evaluation.
+	src := "package ephemeron\n\nttl_seconds := 1 if input.tag\n\nttl_seconds := 2 if input.repository\n"
+	writePolicy(t, path, src, time.Now())
+	r, err := NewRego(t.Context(), path, true, slog.Default())
+	if err != nil {
+		t.Fatalf("NewRego: %v", err)
+	}
+	want := Decision{Allow: true, Reason: "policy evaluation failed", Fallback: true}
+	if got := r.Check(t.Context(), Request{Repository: "app", Tag: "1h"}); got != want {
+		t.Errorf("Check = %+v, want %+v", got, want)
+	}
+}
+
+func TestRego_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.rego")
+	start := time.Now().Add(-time.Hour)
+	writePolicy(t, path, "package ephemeron\n\nallow := true\n", start)
+	if _, err := NewRego(t.Context(), filepath.Join(t.TempDir(), "missing.rego"), true, slog.Default()); err == nil {
+		t.Fatal("expected error for missing policy file")
+	}
+	r, err := NewRego(t.Context(), path, true, slog.Default())
+	if err != nil {
+		t.Fatalf("NewRego: %v", err)
+	}
+	req := Request{Repository: "app", Tag: "1h"}
+
+	writePolicy(t, path, "package ephemeron\n\nallow := \n", start.Add(time.Minute))
+	if _, err := r.load(t.Context()); err == nil {
+		t.Fatal("expected compile error")
+	}
+	if d := r.Check(t.Context(), req); !d.Allow || d.Fallback {
+		t.Errorf("Check = %+v, want previous policy kept", d)
+	}
+
+	writePolicy(t, path, "package ephemeron\n\nallow := false\n", start.Add(2*time.Minute))
+	if reloaded, err := r.load(t.Context()); err != nil || !reloaded {
+		t.Fatalf("load = %v, %v; want reloaded", reloaded, err)
+	}
+	if d := r.Check(t.Context(), req); d.Allow {
+		t.Errorf("Check = %+v, want reloaded policy to deny", d)
+	}
+	if reloaded, _ := r.load(t.Context()); reloaded {
+		t.Error("unchanged file should not be reloaded")
+	}
+}
@@ -0,0 +1,271 @@
+// Package pullrequest expires the images built for a pull request as soon
+// as it is closed or merged, instead of waiting out their TTL. It receives
+// GitHub pull_request and GitLab merge request webhooks and matches the
+// pull request number against tags such as "pr-123".
+package pullrequest
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// Providers used as the "provider" metric label.
+const (
+	ProviderGitHub = "github"
+	ProviderGitLab = "gitlab"
+)
+
+// maxBody bounds the size of a webhook payload.
+const maxBody = 5 << 20
+
+// Close is a closed or merged pull request.
+type Close struct {
+	Provider string
+	// Repository is the source repository, e.g. "org/app".
+	Repository string
+	Number     int
+}
+
+// githubEvent is the subset of a GitHub pull_request event used.
+type githubEvent struct {
+	Action      string `json:"action"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		Number int `json:"number"`
+	} `json:"pull_request"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// gitlabEvent is the subset of a GitLab merge request event used.
+type gitlabEvent struct {
+	ObjectKind       string `json:"object_kind"`
+	ObjectAttributes struct {
+		IID    int    `json:"iid"`
+		Action string `json:"action"`
+	} `json:"object_attributes"`
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"project"`
+}
+
+// ParseRepositories builds the source-to-registry repository map from
+// "source=pattern" entries, where pattern is a registry repository glob.
+func ParseRepositories(entries []string) (map[string][]string, error) {
+	repos := make(map[string][]string)
+	for _, entry := range entries {
+		source, pattern, ok := strings.Cut(entry, "=")
+		source, pattern = strings.TrimSpace(source), strings.TrimSpace(pattern)
+		if !ok || source == "" || pattern == "" {
+			return nil, fmt.Errorf("invalid entry %q (want source=pattern)", entry)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		source = strings.ToLower(source)
+		repos[source] = append(repos[source], pattern)
+	}
+	return repos, nil
+}
+
+// Handler receives pull request webhooks and expires matching images.
+type Handler struct {
+	store  redisclient.Store
+	secret []byte
+	tagRe  *regexp.Regexp
+	repos  map[string][]string
+	logger *slog.Logger
+	now    func() time.Time
+}
+
+// NewHandler returns a Handler authenticating webhooks with secret. tagRe
+// must have one capture group matching the pull request number in a tag.
+// repos maps lowercase source repositories to the registry repository
+// globs holding their images; sources without an entry map to the registry
+// repository of the same name.
+func NewHandler(
+	store redisclient.Store,
+	secret string,
+	tagRe *regexp.Regexp,
+	repos map[string][]string,
+	logger *slog.Logger,
+) *Handler {
+	return &Handler{
+		store:  store,
+		secret: []byte(secret),
+		tagRe:  tagRe,
+		repos:  repos,
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	if err != nil {
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var (
+		pr *Close
+		ok bool
+	)
+	switch {
+	case r.Header.Get("X-GitHub-Event") != "":
+		if !h.verifyGitHub(r.Header.Get("X-Hub-Signature-256"), body) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		pr, ok = parseGitHub(r.Header.Get("X-GitHub-Event"), body)
+	case r.Header.Get("X-Gitlab-Event") != "":
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), h.secret) != 1 {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		pr, ok = parseGitLab(body)
+	default:
+		http.Error(w, "unknown webhook provider", http.StatusBadRequest)
+		return
+	}
+	if !ok {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	expired := []string{}
+	if pr != nil {
+		expired, err = h.Expire(r.Context(), *pr)
+		if err != nil {
+			h.logger.Error("failed to expire pull request images", "repository", pr.Repository,
+				"number", pr.Number, "error", err)
+			http.Error(w, "store unavailable", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string][]string{"expired": expired})
+}
+
+// verifyGitHub checks the X-Hub-Signature-256 HMAC of body.
+func (h *Handler) verifyGitHub(signature string, body []byte) bool {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write(body)
+	return hmac.Equal([]byte(signature), []byte("sha256="+hex.EncodeToString(mac.Sum(nil))))
+}
+
+// parseGitHub returns the pull request closed by a GitHub event, or nil
+// for other events. ok is false for undecodable payloads.
+func parseGitHub(event string, body []byte) (pr *Close, ok bool) {
+	if event != "pull_request" {
+		return nil, true
+	}
+	var ev githubEvent
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return nil, false
+	}
+	if ev.Action != "closed" {
+		return nil, true
+	}
+	number := ev.PullRequest.Number
+	if number == 0 {
+		number = ev.Number
+	}
+	return &Close{Provider: ProviderGitHub, Repository: ev.Repository.FullName, Number: number}, true
+}
+
+// parseGitLab returns the merge request closed or merged by a GitLab event,
+// or nil for other events. ok is false for undecodable payloads.
+func parseGitLab(body []byte) (pr *Close, ok bool) {
+	var ev gitlabEvent
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return nil, false
+	}
+	if ev.ObjectKind != "merge_request" ||
+		ev.ObjectAttributes.Action != "close" && ev.ObjectAttributes.Action != "merge" {
+		return nil, true
+	}
+	return &Close{
+		Provider:   ProviderGitLab,
+		Repository: ev.Project.PathWithNamespace,
+		Number:     ev.ObjectAttributes.IID,
+	}, true
+}
+
+// Expire sets the expiry of every unexpired image of pr to now, so the next
+// reap cycle deletes it, and returns the expired images.
+func (h *Handler) Expire(ctx context.Context, pr Close) ([]string, error) {
+	source := strings.ToLower(pr.Repository)
+	patterns, ok := h.repos[source]
+	if !ok {
+		patterns = []string{source}
+	}
+
+	images, err := h.store.ListImages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing images: %w", err)
+	}
+	now := h.now()
+	expiries := make(map[string]time.Time)
+	for _, image := range images {
+		repo, tag, _ := strings.Cut(image, ":")
+		if !matchAny(patterns, repo) || !h.matchesNumber(tag, pr.Number) {
+			continue
+		}
+		current, err := h.store.GetExpiry(ctx, image)
+		if err != nil || current <= now.UnixMilli() {
+			continue // removed since it was listed, or already expired
+		}
+		expiries[image] = now
+	}
+	expired, err := h.store.SetExpiries(ctx, expiries)
+	if err != nil {
+		return nil, fmt.Errorf("updating expiries: %w", err)
+	}
+	sort.Strings(expired)
+	metrics.PullRequestExpirations.WithLabelValues(pr.Provider).Add(float64(len(expired)))
+	h.logger.Info("expired images of closed pull request",
+		"provider", pr.Provider,
+		"repository", pr.Repository,
+		"number", pr.Number,
+		"images", expired,
+	)
+	return expired, nil
+}
+
+// matchesNumber reports whether tag encodes pull request number.
+func (h *Handler) matchesNumber(tag string, number int) bool {
+	m := h.tagRe.FindStringSubmatch(tag)
+	if len(m) < 2 {
+		return false
+	}
+	n, err := strconv.Atoi(m[1])
+	return err == nil && n == number
+}
+
+func matchAny(patterns []string, repo string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, repo); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package pullrequest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+const secret = "s3cret"
+
+var now = time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func newHandler(t *testing.T, repos map[string][]string) (*Handler, *memstore.Store) {
+	t.Helper()
+	store := memstore.New()
+	for _, image := range []string{"org/app:pr-42", "org/app:pr-42-abc123", "org/app:pr-420", "org/app:main",
+		"org/app-worker:pr-42", "other/app:pr-42"} {
+		_ = store.TrackImage(t.Context(), image, now.Add(24*time.Hour), 1, "", redisclient.ImageMeta{})
+	}
+	h := NewHandler(store, secret, regexp.MustCompile(`^pr-(\d+)`), repos, slog.New(slog.DiscardHandler))
+	h.now = func() time.Time { return now }
+	return h, store
+}
+
+func serve(t *testing.T, h *Handler, header map[string]string, body string) (int, []string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/v1/hook/pull-request", strings.NewReader(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var resp struct {
+		Expired []string `json:"expired"`
+	}
+	_ = json.NewDecoder(rec.Body).Decode(&resp)
+	return rec.Code, resp.Expired
+}
+
+func githubHeaders(event, body string) map[string]string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return map[string]string{
+		"X-GitHub-Event":      event,
+		"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(mac.Sum(nil)),
+	}
+}
+
+func TestHandler_GitHub(t *testing.T) {
+	h, store := newHandler(t, nil)
+	body := `{"action":"closed","number":42,"pull_request":{"number":42,"merged":true},` +
+		`"repository":{"full_name":"Org/App"}}`
+
+	code, expired := serve(t, h, githubHeaders("pull_request", body), body)
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	want := []string{"org/app:pr-42", "org/app:pr-42-abc123"}
+	if !slices.Equal(expired, want) {
+		t.Errorf("expired %v, want %v", expired, want)
+	}
+	if got, _ := store.GetExpiry(t.Context(), "org/app:pr-42"); got != now.UnixMilli() {
+		t.Errorf("expiry = %v, want now", time.UnixMilli(got))
+	}
+	if got, _ := store.GetExpiry(t.Context(), "org/app:pr-420"); got == now.UnixMilli() {
+		t.Error("image of another pull request was expired")
+	}
+}
+
+func TestHandler_GitHubIgnoresOtherEvents(t *testing.T) {
+	h, _ := newHandler(t, nil)
+	for event, body := range map[string]string{
+		"pull_request": `{"action":"opened","number":42,"repository":{"full_name":"org/app"}}`,
+		"push":         `{"ref":"refs/heads/main"}`,
+	} {
+		code, expired := serve(t, h, githubHeaders(event, body), body)
+		if code != http.StatusOK || len(expired) != 0 {
+			t.Errorf("%s: expected 200 without expirations, got %d %v", event, code, expired)
+		}
+	}
+}
+
+func TestHandler_GitHubBadSignature(t *testing.T) {
+	h, _ := newHandler(t, nil)
+	body := `{"action":"closed","number":42,"repository":{"full_name":"org/app"}}`
+	headers := githubHeaders("pull_request", body)
+	headers["X-Hub-Signature-256"] = "sha256=00"
+	if code, _ := serve(t, h, headers, body); code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", code)
+	}
+}
+
+func TestHandler_GitLab(t *testing.T) {
+	h, _ := newHandler(t, map[string][]string{"group/app": {"org/app", "org/app-*"}})
+	body := `{"object_kind":"merge_request","object_attributes":{"iid":42,"action":"merge"},` +
+		`"project":{"path_with_namespace":"group/app"}}`
+
+	headers := map[string]string{"X-Gitlab-Event": "Merge Request Hook", "X-Gitlab-Token": secret}
+	code, expired := serve(t, h, headers, body)
+	want := []string{"org/app-worker:pr-42", "org/app:pr-42", "org/app:pr-42-abc123"}
+	if code != http.StatusOK || !slices.Equal(expired, want) {
+		t.Errorf("expected 200 expiring %v, got %d %v", want, code, expired)
+	}
+
+	headers["X-Gitlab-Token"] = "wrong"
+	if code, _ := serve(t, h, headers, body); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for wrong token, got %d", code)
+	}
+}
+
+func TestHandler_UnknownProvider(t *testing.T) {
+	h, _ := newHandler(t, nil)
+	if code, _ := serve(t, h, nil, "{}"); code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", code)
+	}
+}
+
+func TestParseRepositories(t *testing.T) {
+	repos, err := ParseRepositories([]string{"Org/App=registry/app", "org/app=registry/app-*"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := repos["org/app"]; !slices.Equal(got, []string{"registry/app", "registry/app-*"}) {
+		t.Errorf("unexpected patterns %v", got)
+	}
+	for _, entry := range []string{"org/app", "=registry/app", "org/app=["} {
+		if _, err := ParseRepositories([]string{entry}); err == nil {
+			t.Errorf("%q: expected error", entry)
+		}
+	}
+}
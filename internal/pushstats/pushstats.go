@@ -0,0 +1,109 @@
+// Package pushstats serves the lifetime push statistics of each repository,
+// showing which pipelines push the most and the largest images.
+package pushstats
+
+import (
+	"cmp"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// Orders the statistics can be sorted by, most first except for
+// SortRepository.
+const (
+	SortPushes     = "pushes"
+	SortBytes      = "bytes"
+	SortAverage    = "average"
+	SortLastPush   = "last_push"
+	SortRepository = "repository"
+)
+
+// Sorts lists the supported orders.
+var Sorts = []string{SortPushes, SortBytes, SortAverage, SortLastPush, SortRepository}
+
+// Repository is a repository's statistics as served by Handler.
+type Repository struct {
+	Repository   string    `json:"repository"`
+	Pushes       int64     `json:"pushes"`
+	TotalBytes   int64     `json:"total_bytes"`
+	AverageBytes int64     `json:"average_bytes"`
+	LastPush     time.Time `json:"last_push"`
+}
+
+// Handler serves the push statistics of the repositories in store as JSON,
+// ordered by the sort query parameter (pushes by default) and cut to the
+// limit parameter, if given.
+func Handler(store redisclient.Store, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		order := q.Get("sort")
+		if order == "" {
+			order = SortPushes
+		}
+		if !slices.Contains(Sorts, order) {
+			http.Error(w, "unsupported sort, want one of "+strings.Join(Sorts, ", "), http.StatusBadRequest)
+			return
+		}
+		limit := 0
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		stats, err := store.ListRepoStats(r.Context())
+		if err != nil {
+			logger.Error("failed to list push statistics", "error", err)
+			http.Error(w, "push statistics unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		repos := make([]Repository, len(stats))
+		for i, s := range stats {
+			repos[i] = Repository{
+				Repository:   s.Repository,
+				Pushes:       s.Pushes,
+				TotalBytes:   s.TotalBytes,
+				AverageBytes: s.AverageBytes(),
+				LastPush:     s.LastPush,
+			}
+		}
+		sortRepositories(repos, order)
+		if limit > 0 && len(repos) > limit {
+			repos = repos[:limit]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string][]Repository{"repositories": repos})
+	})
+}
+
+// sortRepositories orders repos by order, falling back to the repository
+// name for ties.
+func sortRepositories(repos []Repository, order string) {
+	slices.SortStableFunc(repos, func(a, b Repository) int {
+		var c int
+		switch order {
+		case SortPushes:
+			c = cmp.Compare(b.Pushes, a.Pushes)
+		case SortBytes:
+			c = cmp.Compare(b.TotalBytes, a.TotalBytes)
+		case SortAverage:
+			c = cmp.Compare(b.AverageBytes, a.AverageBytes)
+		case SortLastPush:
+			c = b.LastPush.Compare(a.LastPush)
+		}
+		if c != 0 {
+			return c
+		}
+		return strings.Compare(a.Repository, b.Repository)
+	})
+}
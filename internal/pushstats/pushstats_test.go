@@ -0,0 +1,82 @@
+package pushstats
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+)
+
+func TestHandler(t *testing.T) {
+	store := memstore.New()
+	now := time.Now()
+	pushes := []struct {
+		repo string
+		size int64
+		at   time.Time
+	}{
+		{"ci/nightly", 1000, now.Add(-2 * time.Hour)},
+		{"team/web", 100, now.Add(-time.Hour)},
+		{"team/web", 300, now.Add(-time.Minute)},
+		{"team/web", 0, now},
+		{"api", 50, now.Add(-time.Hour)},
+		{"api", 50, now.Add(-time.Hour)},
+	}
+	for _, p := range pushes {
+		if err := store.RecordPush(t.Context(), p.repo, p.size, p.at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := Handler(store, slog.New(slog.DiscardHandler))
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"team/web", "api", "ci/nightly"}},
+		{"?sort=average", []string{"ci/nightly", "team/web", "api"}},
+		{"?sort=last_push", []string{"team/web", "api", "ci/nightly"}},
+		{"?sort=repository&limit=2", []string{"api", "ci/nightly"}},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/api/stats/repositories"+tt.query, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%q: status = %d, want 200", tt.query, rr.Code)
+		}
+		var body struct {
+			Repositories []Repository `json:"repositories"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, len(body.Repositories))
+		for i, r := range body.Repositories {
+			got[i] = r.Repository
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: repositories = %v, want %v", tt.query, got, tt.want)
+		}
+		if tt.query == "" {
+			if web := body.Repositories[0]; web.Pushes != 3 || web.TotalBytes != 400 || web.AverageBytes != 200 {
+				t.Errorf("team/web = %+v, want 3 pushes of 400 bytes, 200 on average", web)
+			}
+		}
+	}
+}
+
+func TestHandler_BadRequest(t *testing.T) {
+	h := Handler(memstore.New(), slog.New(slog.DiscardHandler))
+	for _, query := range []string{"?sort=size", "?limit=0", "?limit=x"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/api/stats/repositories"+query, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%q: status = %d, want 400", query, rr.Code)
+		}
+	}
+}
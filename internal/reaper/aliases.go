@@ -0,0 +1,80 @@
+package reaper
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// AliasGroup is a manifest that more than one tracked tag points at, such as
+// "latest" pushed alongside a TTL tag. Its manifest is only deleted when the
+// last of the tags is reaped.
+type AliasGroup struct {
+	Repository string   `json:"repository"`
+	Digest     string   `json:"digest"`
+	Tags       []string `json:"tags"`
+	SizeBytes  int64    `json:"size_bytes"`
+}
+
+// AliasGroups returns every manifest shared by more than one tracked tag,
+// sorted by repository and digest.
+func (r *Reaper) AliasGroups(ctx context.Context) ([]AliasGroup, error) {
+	images, err := r.redis.ListImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]*AliasGroup)
+	for _, image := range images {
+		digest, err := r.redis.GetImageDigest(ctx, image)
+		if err != nil {
+			return nil, err
+		}
+		if digest == "" {
+			continue
+		}
+		repo, tag, _ := strings.Cut(image, ":")
+		g, ok := groups[repo+"@"+digest]
+		if !ok {
+			size, err := r.redis.GetImageSize(ctx, image)
+			if err != nil {
+				return nil, err
+			}
+			g = &AliasGroup{Repository: repo, Digest: digest, SizeBytes: size}
+			groups[repo+"@"+digest] = g
+		}
+		g.Tags = append(g.Tags, tag)
+	}
+
+	out := []AliasGroup{}
+	for _, g := range groups {
+		if len(g.Tags) < 2 {
+			continue
+		}
+		sort.Strings(g.Tags)
+		out = append(out, *g)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Repository != out[j].Repository {
+			return out[i].Repository < out[j].Repository
+		}
+		return out[i].Digest < out[j].Digest
+	})
+	return out, nil
+}
+
+// AliasesHandler serves AliasGroups as JSON.
+func (r *Reaper) AliasesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		groups, err := r.AliasGroups(req.Context())
+		if err != nil {
+			r.logger.Error("failed to list tag aliases", "error", err)
+			http.Error(w, "aliases unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(groups)
+	})
+}
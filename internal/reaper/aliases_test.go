@@ -0,0 +1,115 @@
+package reaper
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+func TestReap_SharedManifest(t *testing.T) {
+	tests := []struct {
+		name            string
+		tagDeleteStatus int
+	}{
+		{name: "registry deletes tags", tagDeleteStatus: http.StatusAccepted},
+		{name: "registry cannot delete tags", tagDeleteStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var deleted []string
+			registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodHead:
+					w.Header().Set("Docker-Content-Digest", "sha256:abc")
+					w.WriteHeader(http.StatusOK)
+				case http.MethodDelete:
+					mu.Lock()
+					deleted = append(deleted, r.URL.Path)
+					mu.Unlock()
+					if r.URL.Path == "/v2/app/manifests/1h" {
+						w.WriteHeader(tt.tagDeleteStatus)
+						return
+					}
+					w.WriteHeader(http.StatusAccepted)
+				}
+			}))
+			defer registry.Close()
+
+			store := memstore.New()
+			for image, expires := range map[string]time.Time{
+				"app:1h":     time.Now().Add(-time.Minute),
+				"app:latest": time.Now().Add(time.Hour),
+			} {
+				if err := store.TrackImage(t.Context(), image, expires, 100, "sha256:abc", redisclient.ImageMeta{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r := New(store, registry.URL, slog.Default())
+
+			if _, err := r.Reap(t.Context()); err != nil {
+				t.Fatalf("Reap: %v", err)
+			}
+			if want := []string{"/v2/app/manifests/1h"}; !slices.Equal(deleted, want) {
+				t.Fatalf("deletes = %v, want only the tag %v", deleted, want)
+			}
+			if tracked(store, "app:1h") || !tracked(store, "app:latest") {
+				t.Fatal("expected app:1h untracked and app:latest still tracked")
+			}
+			if _, bytes, _ := store.ReapTotals(t.Context()); bytes != 0 {
+				t.Errorf("reclaimed bytes = %d, want 0 while the manifest is shared", bytes)
+			}
+
+			if _, err := store.SetExpiry(t.Context(), "app:latest", time.Now().Add(-time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+			deleted = nil
+			if _, err := r.Reap(t.Context()); err != nil {
+				t.Fatalf("Reap: %v", err)
+			}
+			if want := []string{"/v2/app/manifests/sha256:abc"}; !slices.Equal(deleted, want) {
+				t.Errorf("deletes = %v, want the manifest %v", deleted, want)
+			}
+			if _, bytes, _ := store.ReapTotals(t.Context()); bytes != 100 {
+				t.Errorf("reclaimed bytes = %d, want 100 once the last alias is gone", bytes)
+			}
+		})
+	}
+}
+
+func TestAliasGroups(t *testing.T) {
+	store := memstore.New()
+	expires := time.Now().Add(time.Hour)
+	for _, img := range []struct{ image, digest string }{
+		{"app:latest", "sha256:abc"},
+		{"app:1h", "sha256:abc"},
+		{"app:2h", "sha256:def"},
+		{"other:latest", "sha256:abc"},
+		{"app:old", ""},
+	} {
+		if err := store.TrackImage(t.Context(), img.image, expires, 100, img.digest, redisclient.ImageMeta{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := New(store, "http://registry.invalid", slog.Default())
+
+	groups, err := r.AliasGroups(t.Context())
+	if err != nil {
+		t.Fatalf("AliasGroups: %v", err)
+	}
+	if len(groups) != 1 {
+		t.Fatalf("groups = %+v, want one", groups)
+	}
+	g := groups[0]
+	if g.Repository != "app" || g.Digest != "sha256:abc" || !slices.Equal(g.Tags, []string{"1h", "latest"}) ||
+		g.SizeBytes != 100 {
+		t.Errorf("group = %+v", g)
+	}
+}
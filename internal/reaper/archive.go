@@ -0,0 +1,79 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/archive"
+	"github.com/tamcore/ephemeron/internal/metrics"
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+// WithArchive stores the manifest of every image, and the config blob of
+// image manifests, in a before it is deleted. Restore falls back to the
+// archived content once a tombstone no longer carries the manifest or the
+// registry collected the config. A nil archive disables archiving.
+func WithArchive(a archive.Archive) Option {
+	return func(r *Reaper) {
+		r.archive = a
+	}
+}
+
+// archiveContent archives m, the manifest of image about to be deleted,
+// and its config blob. Failures are logged and counted; the deletion goes
+// ahead either way.
+func (r *Reaper) archiveContent(ctx context.Context, image string, m *registry.RawManifest) {
+	digest := m.Digest()
+	if err := r.archive.Put(ctx, archive.ManifestKey(digest), m.Body); err != nil {
+		metrics.ArchiveErrors.Inc()
+		r.logger.Warn("failed to archive manifest", "image", image, "digest", digest, "error", err)
+		return
+	}
+	refs, err := registry.ParseReferences(m.Body)
+	if err != nil || refs.Config == "" {
+		return
+	}
+	repo, _, _ := strings.Cut(image, ":")
+	start := time.Now()
+	config, err := r.registry.GetBlob(ctx, repo, refs.Config)
+	r.observe(start)
+	if err == nil {
+		err = r.archive.Put(ctx, archive.BlobKey(refs.Config), config)
+	}
+	if err != nil {
+		metrics.ArchiveErrors.Inc()
+		r.logger.Warn("failed to archive config blob", "image", image, "digest", refs.Config, "error", err)
+	}
+}
+
+// archivedManifest returns the archived manifest with the given digest,
+// ErrNoManifest if there is none.
+func (r *Reaper) archivedManifest(ctx context.Context, digest string) ([]byte, error) {
+	if r.archive == nil || digest == "" {
+		return nil, ErrNoManifest
+	}
+	body, err := r.archive.Get(ctx, archive.ManifestKey(digest))
+	if errors.Is(err, archive.ErrNotFound) {
+		return nil, ErrNoManifest
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading archived manifest: %w", err)
+	}
+	return body, nil
+}
+
+// unarchiveBlob uploads the archived blob digest to repo again, failing
+// with ErrContentMissing if it was not archived.
+func (r *Reaper) unarchiveBlob(ctx context.Context, repo, digest string) error {
+	body, err := r.archive.Get(ctx, archive.BlobKey(digest))
+	if errors.Is(err, archive.ErrNotFound) {
+		return fmt.Errorf("%w: blob %s", ErrContentMissing, digest)
+	}
+	if err != nil {
+		return fmt.Errorf("reading archived blob: %w", err)
+	}
+	return r.registry.PutBlob(ctx, repo, digest, body)
+}
@@ -0,0 +1,147 @@
+package reaper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/hooks"
+)
+
+// BulkExtendRequest selects tracked images by glob and either extends their
+// expiry or sets a new TTL. Durations use the tag syntax, such as "1w" or
+// "36h".
+type BulkExtendRequest struct {
+	// Repository is a path.Match pattern for the repository; required.
+	Repository string `json:"repository"`
+	// Tag is a path.Match pattern for the tag; empty matches every tag.
+	Tag string `json:"tag"`
+	// Extend moves each expiry back by this duration, counting from now for
+	// images that have already expired.
+	Extend string `json:"extend,omitempty"`
+	// TTL sets each expiry to now plus this duration.
+	TTL string `json:"ttl,omitempty"`
+}
+
+// ExpiryChange is the expiry of one image before and after a bulk update.
+type ExpiryChange struct {
+	Image     string    `json:"image"`
+	OldExpiry time.Time `json:"old_expires_at"`
+	NewExpiry time.Time `json:"new_expires_at"`
+}
+
+// BulkExtendResult summarizes a bulk expiry update.
+type BulkExtendResult struct {
+	Matched int            `json:"matched"`
+	Updated int            `json:"updated"`
+	Changes []ExpiryChange `json:"changes"`
+}
+
+// errInvalidBulkRequest marks requests rejected before touching the store.
+var errInvalidBulkRequest = errors.New("invalid bulk request")
+
+// BulkExtend applies req to every matching tracked image in one atomic
+// store update. It is an operator override and not capped by MAX_TTL.
+func (r *Reaper) BulkExtend(ctx context.Context, req BulkExtendRequest) (BulkExtendResult, error) {
+	res := BulkExtendResult{Changes: []ExpiryChange{}}
+	if req.Repository == "" {
+		return res, fmt.Errorf("%w: repository pattern is required", errInvalidBulkRequest)
+	}
+	if req.Tag == "" {
+		req.Tag = "*"
+	}
+	if (req.Extend == "") == (req.TTL == "") {
+		return res, fmt.Errorf("%w: exactly one of extend and ttl is required", errInvalidBulkRequest)
+	}
+	spec := req.Extend
+	if req.TTL != "" {
+		spec = req.TTL
+	}
+	d := hooks.ParseTTL(spec)
+	if d <= 0 {
+		return res, fmt.Errorf("%w: invalid duration %q", errInvalidBulkRequest, spec)
+	}
+	for _, pattern := range []string{req.Repository, req.Tag} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return res, fmt.Errorf("%w: pattern %q: %w", errInvalidBulkRequest, pattern, err)
+		}
+	}
+
+	images, err := r.redis.ListImages(ctx)
+	if err != nil {
+		return res, fmt.Errorf("listing images: %w", err)
+	}
+	now := time.Now()
+	expiries := make(map[string]time.Time)
+	old := make(map[string]time.Time)
+	for _, image := range images {
+		repo, tag, _ := strings.Cut(image, ":")
+		repoMatch, _ := path.Match(req.Repository, repo)
+		tagMatch, _ := path.Match(req.Tag, tag)
+		if !repoMatch || !tagMatch {
+			continue
+		}
+		current, err := r.redis.GetExpiry(ctx, image)
+		if err != nil {
+			// Removed since it was listed.
+			continue
+		}
+		old[image] = time.UnixMilli(current)
+		if req.TTL != "" {
+			expiries[image] = now.Add(d)
+		} else {
+			expiries[image] = time.UnixMilli(max(current, now.UnixMilli())).Add(d)
+		}
+	}
+	res.Matched = len(expiries)
+
+	updated, err := r.redis.SetExpiries(ctx, expiries)
+	if err != nil {
+		return res, fmt.Errorf("updating expiries: %w", err)
+	}
+	for _, image := range updated {
+		res.Changes = append(res.Changes, ExpiryChange{Image: image, OldExpiry: old[image], NewExpiry: expiries[image]})
+	}
+	sort.Slice(res.Changes, func(i, j int) bool { return res.Changes[i].Image < res.Changes[j].Image })
+	res.Updated = len(res.Changes)
+
+	r.logger.Info("bulk expiry update",
+		"repository", req.Repository,
+		"tag", req.Tag,
+		"extend", req.Extend,
+		"ttl", req.TTL,
+		"matched", res.Matched,
+		"updated", res.Updated,
+	)
+	return res, nil
+}
+
+// BulkExtendHandler serves BulkExtend, decoding a BulkExtendRequest from
+// the body and answering with the BulkExtendResult.
+func (r *Reaper) BulkExtendHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body BulkExtendRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		res, err := r.BulkExtend(req.Context(), body)
+		if errors.Is(err, errInvalidBulkRequest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			r.logger.Error("bulk expiry update failed", "error", err)
+			http.Error(w, "bulk update failed", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(res)
+	})
+}
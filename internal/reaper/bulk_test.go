@@ -0,0 +1,84 @@
+package reaper
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+)
+
+func TestBulkExtendHandler(t *testing.T) {
+	store := memstore.New()
+	inHour := time.Now().Add(time.Hour)
+	track(t, store, "team/app:pr-1", inHour)
+	track(t, store, "team/app:pr-2", time.Now().Add(-time.Minute))
+	track(t, store, "team/app:main", inHour)
+	track(t, store, "other/app:pr-1", inHour)
+	r := New(store, "http://registry.invalid", slog.Default())
+
+	post := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/v1/api/images/bulk-extend", bytes.NewReader([]byte(body)))
+		r.BulkExtendHandler().ServeHTTP(rr, req)
+		return rr
+	}
+	expiry := func(image string) time.Duration {
+		t.Helper()
+		ms, err := store.GetExpiry(t.Context(), image)
+		if err != nil {
+			t.Fatalf("GetExpiry(%s): %v", image, err)
+		}
+		return time.Until(time.UnixMilli(ms))
+	}
+	about := func(got, want time.Duration) bool { return got > want-time.Minute && got <= want }
+
+	rr := post(`{"repository": "team/*", "tag": "pr-*", "extend": "1w"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	var res BulkExtendResult
+	if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Matched != 2 || res.Updated != 2 || res.Changes[0].Image != "team/app:pr-1" {
+		t.Errorf("result = %+v, want both team/app pr tags", res)
+	}
+	week := 7 * 24 * time.Hour
+	if got := expiry("team/app:pr-1"); !about(got, week+time.Hour) {
+		t.Errorf("pr-1 expires in %v, want a week after its old expiry", got)
+	}
+	if got := expiry("team/app:pr-2"); !about(got, week) {
+		t.Errorf("expired pr-2 expires in %v, want a week from now", got)
+	}
+	for _, image := range []string{"team/app:main", "other/app:pr-1"} {
+		if got := expiry(image); !about(got, time.Hour) {
+			t.Errorf("%s expires in %v, want it untouched", image, got)
+		}
+	}
+
+	if rr := post(`{"repository": "other/app", "ttl": "2h"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if got := expiry("other/app:pr-1"); !about(got, 2*time.Hour) {
+		t.Errorf("other/app:pr-1 expires in %v, want 2h", got)
+	}
+
+	for _, body := range []string{
+		`{"tag": "pr-*", "extend": "1w"}`,
+		`{"repository": "team/app", "extend": "1w", "ttl": "1h"}`,
+		`{"repository": "team/app"}`,
+		`{"repository": "team/app", "ttl": "soon"}`,
+		`{"repository": "team/[", "ttl": "1h"}`,
+		`not json`,
+	} {
+		if rr := post(body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, rr.Code)
+		}
+	}
+}
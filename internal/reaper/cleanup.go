@@ -0,0 +1,217 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+// RepoCleaner removes a repository that no longer holds any tags, so it
+// stops showing up in the registry catalog.
+type RepoCleaner interface {
+	DeleteRepository(ctx context.Context, repo string) error
+}
+
+// ErrRepositoryBusy is returned by a RepoCleaner that keeps a repository
+// because it was written to recently, such as by an upload in progress.
+var ErrRepositoryBusy = errors.New("repository has recent activity")
+
+// WithRepoCleanup makes the reaper delete a repository through c once its
+// last tag has been reaped and the registry lists no tags for it.
+func WithRepoCleanup(c RepoCleaner) Option {
+	return func(r *Reaper) {
+		r.cleaner = c
+	}
+}
+
+// cleanupRepos deletes each of repos, and of the repositories earlier
+// cycles found busy, that has no tracked images left and no tags in the
+// registry.
+func (r *Reaper) cleanupRepos(ctx context.Context, repos map[string]struct{}) {
+	if r.cleaner == nil {
+		return
+	}
+	maps.Copy(repos, r.busyRepos)
+	r.busyRepos = nil
+	if len(repos) == 0 {
+		return
+	}
+
+	images, err := r.redis.ListImages(ctx)
+	if err != nil {
+		r.logger.Warn("skipping repository cleanup, cannot list images", "error", err)
+		r.busyRepos = repos
+		return
+	}
+	for _, image := range images {
+		repo, _, _ := strings.Cut(image, ":")
+		delete(repos, repo)
+	}
+
+	for repo := range repos {
+		tags, err := r.listTags(ctx, repo)
+		if err != nil {
+			r.logger.Warn("skipping repository cleanup, cannot list tags", "repo", repo, "error", err)
+			continue
+		}
+		if tags > 0 {
+			r.logger.Debug("repository still has untracked tags, keeping it", "repo", repo, "tags", tags)
+			continue
+		}
+		err = r.cleaner.DeleteRepository(ctx, repo)
+		if errors.Is(err, ErrRepositoryBusy) {
+			r.logger.Debug("repository has recent activity, retrying next cycle", "repo", repo)
+			if r.busyRepos == nil {
+				r.busyRepos = make(map[string]struct{})
+			}
+			r.busyRepos[repo] = struct{}{}
+			continue
+		}
+		if err != nil {
+			r.logger.Error("failed to delete empty repository", "repo", repo, "error", err)
+			continue
+		}
+		metrics.RepositoriesDeleted.Inc()
+		r.logger.Info("deleted empty repository", "repo", repo)
+	}
+}
+
+// listTags returns the number of tags the registry lists for repo. A
+// repository unknown to the registry has none.
+func (r *Reaper) listTags(ctx context.Context, repo string) (int, error) {
+	start := time.Now()
+	tags, err := r.registry.ListTags(ctx, repo)
+	r.observe(start)
+	if errors.Is(err, registry.ErrNotFound) {
+		return 0, nil
+	}
+	return len(tags), err
+}
+
+// HarborCleaner deletes repositories through the Harbor v2 API.
+type HarborCleaner struct {
+	baseURL    string
+	username   string
+	password   string
+	httpClient *http.Client
+}
+
+// NewHarborCleaner creates a HarborCleaner authenticating with basic auth.
+// A nil httpClient uses one with a 10 second timeout.
+func NewHarborCleaner(baseURL, username, password string, httpClient *http.Client) *HarborCleaner {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &HarborCleaner{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		username:   username,
+		password:   password,
+		httpClient: httpClient,
+	}
+}
+
+// DeleteRepository deletes repo, given as "<project>/<name>".
+func (h *HarborCleaner) DeleteRepository(ctx context.Context, repo string) error {
+	project, name, ok := strings.Cut(repo, "/")
+	if !ok {
+		return fmt.Errorf("repository %q has no Harbor project", repo)
+	}
+	// Harbor expects slashes in the repository name to be encoded twice.
+	u := fmt.Sprintf("%s/api/v2.0/projects/%s/repositories/%s",
+		h.baseURL, url.PathEscape(project), url.PathEscape(url.PathEscape(name)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u, nil)
+	if err != nil {
+		return fmt.Errorf("creating DELETE request: %w", err)
+	}
+	req.SetBasicAuth(h.username, h.password)
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("DELETE repository: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("DELETE repository returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// repoIdle is how long nothing below a repository directory must have
+// changed before FilesystemCleaner removes it. It spans the pauses between
+// the chunks of an upload.
+const repoIdle = time.Hour
+
+// FilesystemCleaner deletes repositories from the storage directory of a
+// distribution registry, which has no API for it. It only removes the
+// repository's links; blobs are freed by the registry's garbage collector.
+type FilesystemCleaner struct {
+	reposDir string
+	idle     time.Duration
+}
+
+// NewFilesystemCleaner creates a FilesystemCleaner for a registry whose
+// storage root is mounted at dataPath.
+func NewFilesystemCleaner(dataPath string) *FilesystemCleaner {
+	return &FilesystemCleaner{
+		reposDir: filepath.Join(dataPath, "docker", "registry", "v2", "repositories"),
+		idle:     repoIdle,
+	}
+}
+
+// DeleteRepository removes the repository directory of repo. The registry
+// writes uploads into that directory without ephemeron knowing, so a
+// repository with anything modified in the last idle period, an upload in
+// progress or a push not yet notified, is kept with ErrRepositoryBusy.
+func (f *FilesystemCleaner) DeleteRepository(_ context.Context, repo string) error {
+	if !filepath.IsLocal(repo) {
+		return fmt.Errorf("refusing to delete repository %q outside the registry", repo)
+	}
+	dir := filepath.Join(f.reposDir, filepath.FromSlash(repo))
+	if _, err := os.Stat(filepath.Join(dir, "_manifests")); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	busy, err := modifiedSince(dir, time.Now().Add(-f.idle))
+	if err != nil {
+		return err
+	}
+	if busy {
+		return ErrRepositoryBusy
+	}
+	return os.RemoveAll(dir)
+}
+
+// modifiedSince reports whether dir or anything below it was modified
+// after t. Entries vanishing during the walk count as modified.
+func modifiedSince(dir string, t time.Time) (bool, error) {
+	var modified bool
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil {
+			var info fs.FileInfo
+			if info, err = d.Info(); err == nil && info.ModTime().After(t) {
+				modified = true
+				return fs.SkipAll
+			}
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			modified = true
+			return fs.SkipAll
+		}
+		return err
+	})
+	return modified, err
+}
@@ -0,0 +1,160 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+)
+
+type recordingCleaner struct {
+	mu      sync.Mutex
+	deleted []string
+	// busy is how many calls report ErrRepositoryBusy before deleting.
+	busy int
+}
+
+func (c *recordingCleaner) DeleteRepository(_ context.Context, repo string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.busy > 0 {
+		c.busy--
+		return ErrRepositoryBusy
+	}
+	c.deleted = append(c.deleted, repo)
+	return nil
+}
+
+func TestReap_CleansUpEmptyRepositories(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/gone/tags/list":
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/v2/untracked/tags/list":
+			_, _ = w.Write([]byte(`{"name":"untracked","tags":["manual"]}`))
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.Header().Set("Docker-Content-Digest", "sha256:abc")
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer registry.Close()
+
+	store := memstore.New()
+	past := time.Now().Add(-time.Minute)
+	track(t, store, "gone:1h", past)
+	track(t, store, "untracked:1h", past)
+	track(t, store, "shared:1h", past)
+	track(t, store, "shared:2h", time.Now().Add(time.Hour))
+
+	cleaner := &recordingCleaner{}
+	r := New(store, registry.URL, slog.Default(), WithRepoCleanup(cleaner))
+	if _, err := r.Reap(t.Context()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(cleaner.deleted, []string{"gone"}) {
+		t.Errorf("expected only gone to be deleted, got %v", cleaner.deleted)
+	}
+}
+
+func TestReap_RetriesBusyRepositories(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/gone/tags/list":
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.Header().Set("Docker-Content-Digest", "sha256:abc")
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer registry.Close()
+
+	store := memstore.New()
+	track(t, store, "gone:1h", time.Now().Add(-time.Minute))
+
+	cleaner := &recordingCleaner{busy: 1}
+	r := New(store, registry.URL, slog.Default(), WithRepoCleanup(cleaner))
+	for range 2 {
+		if _, err := r.Reap(t.Context()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if !slices.Equal(cleaner.deleted, []string{"gone"}) {
+		t.Errorf("expected the busy repository to be deleted by the next cycle, got %v", cleaner.deleted)
+	}
+}
+
+func TestHarborCleaner(t *testing.T) {
+	var gotPath, gotUser string
+	harbor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.EscapedPath()
+		gotUser, _, _ = r.BasicAuth()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer harbor.Close()
+
+	c := NewHarborCleaner(harbor.URL, "robot$ephemeron", "secret", nil)
+	if err := c.DeleteRepository(t.Context(), "library/team/app"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "/api/v2.0/projects/library/repositories/team%252Fapp"; gotPath != want {
+		t.Errorf("path = %q, want %q", gotPath, want)
+	}
+	if gotUser != "robot$ephemeron" {
+		t.Errorf("user = %q, want robot$ephemeron", gotUser)
+	}
+	if err := c.DeleteRepository(t.Context(), "app"); err == nil {
+		t.Error("expected error for repository without a project")
+	}
+}
+
+func TestFilesystemCleaner(t *testing.T) {
+	root := t.TempDir()
+	repoDir := filepath.Join(root, "docker", "registry", "v2", "repositories", "team", "app")
+	if err := os.MkdirAll(filepath.Join(repoDir, "_manifests"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewFilesystemCleaner(root)
+	if err := c.DeleteRepository(t.Context(), "team/app"); !errors.Is(err, ErrRepositoryBusy) {
+		t.Fatalf("recently written repository: err = %v, want ErrRepositoryBusy", err)
+	}
+	idle := time.Now().Add(-2 * repoIdle)
+	if err := filepath.WalkDir(repoDir, func(path string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(path, idle, idle)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.DeleteRepository(t.Context(), "../../etc"); err == nil {
+		t.Error("expected error for path outside the registry")
+	}
+	if err := c.DeleteRepository(t.Context(), "team"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(repoDir); err != nil {
+		t.Error("expected parent namespace without _manifests to be kept")
+	}
+	if err := c.DeleteRepository(t.Context(), "team/app"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(repoDir); !os.IsNotExist(err) {
+		t.Errorf("expected repository directory to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// cycle is the state a deletion run reads once and applies to every image,
+// so reap cycles, expiry notifications, evictions and previews select
+// images the same way.
+type cycle struct {
+	// now is when the run started, in epoch milliseconds.
+	now         int64
+	quarantined map[string]struct{}
+	freezes     []redisclient.Freeze
+	// maxTTL, if positive, also makes images tracked longer ago than that
+	// due, to preview a lower MAX_TTL.
+	maxTTL time.Duration
+	// dryRun changes nothing and records no metrics, for Preview.
+	dryRun bool
+}
+
+// dueImages returns the images a run may delete, in deletion order, and
+// tallies in res the due images it must skip. Outside a dry run, records
+// whose expiry cannot be read are dropped.
+func (r *Reaper) dueImages(ctx context.Context, images []string, c cycle, res *Result) ([]expiredImage, error) {
+	var expired []expiredImage
+	for _, image := range images {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		expiresAt, err := r.redis.GetExpiry(ctx, image)
+		if errors.Is(err, redisclient.ErrNewerRecord) {
+			// Tracked by a newer replica during a rolling upgrade; it
+			// reaps the image.
+			r.logger.Debug("skipping image tracked by a newer version", "image", image)
+			continue
+		}
+		if err != nil {
+			if !c.dryRun {
+				r.logger.Warn("failed to get expiry, cleaning up", "image", image, "error", err)
+				_ = r.redis.RemoveImage(ctx, image)
+			}
+			continue
+		}
+		if c.maxTTL > 0 {
+			created, err := r.redis.GetCreatedTimestamp(ctx, image)
+			if err != nil {
+				return nil, err
+			}
+			if created > 0 {
+				expiresAt = min(expiresAt, created+c.maxTTL.Milliseconds())
+			}
+		}
+
+		if expiresAt > c.now {
+			remaining := time.Duration(expiresAt-c.now) * time.Millisecond
+			r.logger.Debug("image not expired yet",
+				"image", image,
+				"remaining", remaining.Round(time.Second).String(),
+			)
+			continue
+		}
+
+		// Fail closed: an image whose protection cannot be read is left
+		// for the next cycle.
+		verdict, err := r.spared(ctx, c, image)
+		if err != nil {
+			r.logger.Warn("failed to check image protection, skipping", "image", image, "error", err)
+		}
+		switch verdict {
+		case VerdictQuarantined:
+			res.Quarantined++
+			continue
+		case VerdictFrozen:
+			res.Frozen++
+			continue
+		case VerdictProtected:
+			res.Protected++
+			continue
+		case VerdictDeferred:
+			res.Deferred++
+			continue
+		}
+
+		expired = append(expired, expiredImage{image: image, expiresAt: expiresAt, priority: r.priority(ctx, image)})
+	}
+	sortExpired(expired)
+	return expired, nil
+}
+
+// spared reports why image must not be deleted even when it is due, as
+// VerdictQuarantined, VerdictFrozen, VerdictProtected or VerdictDeferred,
+// or "" when nothing spares it. Every path that deletes tracked images
+// asks it, so they all honour the same exclusions. An image whose
+// protection cannot be read is reported protected along with the error.
+func (r *Reaper) spared(ctx context.Context, c cycle, image string) (string, error) {
+	if _, ok := c.quarantined[image]; ok {
+		return VerdictQuarantined, nil
+	}
+	if frozen(c.freezes, image) {
+		return VerdictFrozen, nil
+	}
+	if protected, err := r.protected(ctx, image); err != nil || protected {
+		return VerdictProtected, err
+	}
+	if r.deferred(ctx, image, c.dryRun) {
+		return VerdictDeferred, nil
+	}
+	return "", nil
+}
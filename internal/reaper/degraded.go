@@ -0,0 +1,58 @@
+package reaper
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+)
+
+// storeHealth remembers whether the store was degraded at the last check.
+type storeHealth struct {
+	mu     sync.Mutex
+	reason string
+	since  time.Time
+}
+
+// set records the outcome of a check and reports whether it changed.
+func (h *storeHealth) set(reason string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if reason == h.reason {
+		return false
+	}
+	h.reason = reason
+	h.since = time.Now()
+	return true
+}
+
+func (h *storeHealth) get() (string, time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.reason, h.since
+}
+
+// degraded asks the store whether it is safe to delete based on its data.
+// Deleting while a replica is promoted or a lagging replica serves reads
+// could act on expiries that were since extended, so the reaper waits for
+// the store to recover instead.
+func (r *Reaper) degraded(ctx context.Context) (string, error) {
+	reason, err := r.redis.Degraded(ctx)
+	if err != nil {
+		return "", err
+	}
+	if r.storeHealth.set(reason) {
+		if reason != "" {
+			r.logger.Warn("store degraded, suspending deletions", "reason", reason)
+		} else {
+			r.logger.Info("store recovered, resuming deletions")
+		}
+	}
+	if reason != "" {
+		metrics.StoreDegraded.Set(1)
+	} else {
+		metrics.StoreDegraded.Set(0)
+	}
+	return reason, nil
+}
@@ -0,0 +1,97 @@
+package reaper
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// degradedStore is a memstore reporting a fixed degradation reason.
+type degradedStore struct {
+	*memstore.Store
+	reason string
+}
+
+func (s *degradedStore) Degraded(context.Context) (string, error) { return s.reason, nil }
+
+func TestReap_SkipsWhileStoreDegraded(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodHead:
+			w.Header().Set("Docker-Content-Digest", "sha256:abc")
+			w.WriteHeader(http.StatusOK)
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer registry.Close()
+
+	store := &degradedStore{Store: memstore.New(), reason: redisclient.DegradedReplicaLinkDown}
+	track(t, store.Store, "app:1h", time.Now().Add(-time.Hour))
+	r := New(store, registry.URL, slog.Default())
+
+	res, err := r.Reap(t.Context())
+	if err != nil {
+		t.Fatalf("Reap: %v", err)
+	}
+	if res.Degraded != redisclient.DegradedReplicaLinkDown || res.Attempted != 0 {
+		t.Errorf("result = %+v, want a degraded cycle without deletions", res)
+	}
+	if !tracked(store.Store, "app:1h") {
+		t.Fatal("expected no deletion while the store is degraded")
+	}
+	if err := r.ReapImage(t.Context(), "app:1h"); err != nil || !tracked(store.Store, "app:1h") {
+		t.Fatalf("ReapImage deleted while the store is degraded (err %v)", err)
+	}
+
+	status := func() Status {
+		rr := httptest.NewRecorder()
+		r.StatusHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/api/reap/status", nil))
+		var s Status
+		if err := json.NewDecoder(rr.Body).Decode(&s); err != nil {
+			t.Fatalf("decoding status: %v", err)
+		}
+		return s
+	}
+	if s := status(); s.State != StateDegraded || s.Degraded != redisclient.DegradedReplicaLinkDown ||
+		s.DegradedSince == nil {
+		t.Errorf("status = %+v, want the degradation reason", s)
+	}
+
+	store.reason = ""
+	res, err = r.Reap(t.Context())
+	if err != nil {
+		t.Fatalf("Reap: %v", err)
+	}
+	if res.Degraded != "" || res.Reaped != 1 {
+		t.Errorf("result = %+v, want the image reaped after recovery", res)
+	}
+	if s := status(); s.State != StateIdle || s.Degraded != "" || s.LastCycle == nil || s.LastCycle.Reaped != 1 {
+		t.Errorf("status = %+v, want healthy with the last cycle", s)
+	}
+}
+
+func TestStatus_Frozen(t *testing.T) {
+	store := memstore.New()
+	r := New(store, "http://registry.invalid", slog.Default())
+	until := time.Now().Add(time.Hour)
+	if err := store.SetFreeze(t.Context(), redisclient.Freeze{Pattern: "team/*", Until: until}); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := r.Status(t.Context()); err != nil || s.State != StateIdle || s.ActiveFreezes != 1 {
+		t.Fatalf("Status = %+v, %v; want idle with one freeze", s, err)
+	}
+	if err := store.SetFreeze(t.Context(), redisclient.Freeze{Until: until}); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := r.Status(t.Context()); err != nil || s.State != StateFrozen || s.ActiveFreezes != 2 {
+		t.Fatalf("Status = %+v, %v; want frozen with two freezes", s, err)
+	}
+}
@@ -0,0 +1,53 @@
+package reaper
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/deletehook"
+	"github.com/tamcore/ephemeron/internal/metrics"
+)
+
+// DeleteHook is an action run before or after an image is deleted.
+type DeleteHook interface {
+	Run(ctx context.Context, ev deletehook.Event) error
+}
+
+// WithDeleteHooks runs pre before and post after each deletion, in order.
+// A failing pre-delete hook aborts the deletion, which then counts as
+// failed and is retried in the next cycle; post-delete failures are only
+// logged.
+func WithDeleteHooks(pre, post []DeleteHook) Option {
+	return func(r *Reaper) {
+		r.preDelete = pre
+		r.postDelete = post
+	}
+}
+
+// deleteEvent describes image for the delete hooks. Metadata that cannot
+// be read is left empty rather than holding up the deletion.
+func (r *Reaper) deleteEvent(ctx context.Context, image string, sizeBytes int64) deletehook.Event {
+	repo, tag, _ := strings.Cut(image, ":")
+	ev := deletehook.Event{Image: image, Repository: repo, Tag: tag, SizeBytes: sizeBytes}
+	ev.Digest, _ = r.redis.GetImageDigest(ctx, image)
+	if ms, err := r.redis.GetExpiry(ctx, image); err == nil {
+		ev.ExpiresAt = time.UnixMilli(ms).UTC()
+	}
+	if meta, err := r.redis.GetImageMeta(ctx, image); err == nil {
+		ev.Actor = meta.Actor
+	}
+	return ev
+}
+
+// runDeleteHooks runs hooks for ev in phase, stopping at the first error.
+func (r *Reaper) runDeleteHooks(ctx context.Context, hooks []DeleteHook, phase string, ev deletehook.Event) error {
+	ev.Phase = phase
+	for _, h := range hooks {
+		if err := h.Run(ctx, ev); err != nil {
+			metrics.DeleteHookFailures.WithLabelValues(phase).Inc()
+			return err
+		}
+	}
+	return nil
+}
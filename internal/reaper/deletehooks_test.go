@@ -0,0 +1,81 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/deletehook"
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// hookFunc adapts a function to DeleteHook.
+type hookFunc func(deletehook.Event) error
+
+func (f hookFunc) Run(_ context.Context, ev deletehook.Event) error { return f(ev) }
+
+func TestReap_DeleteHooks(t *testing.T) {
+	var deletes int
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodHead:
+			w.Header().Set("Docker-Content-Digest", "sha256:abc123")
+			w.WriteHeader(http.StatusOK)
+		case http.MethodDelete:
+			deletes++
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer reg.Close()
+
+	store := memstore.New()
+	expires := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	meta := redisclient.ImageMeta{Actor: "ci-bot"}
+	if err := store.TrackImage(t.Context(), "app:1h", expires, 42, "sha256:abc123", meta); err != nil {
+		t.Fatal(err)
+	}
+	track(t, store, "archive-fails:1h", expires)
+
+	var events []deletehook.Event
+	pre := hookFunc(func(ev deletehook.Event) error {
+		events = append(events, ev)
+		if ev.Repository == "archive-fails" {
+			return errors.New("archive unavailable")
+		}
+		return nil
+	})
+	post := hookFunc(func(ev deletehook.Event) error {
+		events = append(events, ev)
+		return errors.New("post-delete failures are only logged")
+	})
+	r := New(store, reg.URL, slog.Default(), WithDeleteHooks([]DeleteHook{pre}, []DeleteHook{post}))
+
+	res, err := r.Reap(t.Context())
+	if err != nil {
+		t.Fatalf("Reap: %v", err)
+	}
+	if res.Reaped != 1 || res.Failed != 1 || deletes != 1 || !tracked(store, "archive-fails:1h") {
+		t.Errorf("result = %+v, DELETEs = %d; want the image whose pre-delete hook failed kept", res, deletes)
+	}
+
+	var phases []string
+	for _, ev := range events {
+		if ev.Image != "app:1h" {
+			continue
+		}
+		phases = append(phases, ev.Phase)
+		want := deletehook.Event{Phase: ev.Phase, Image: "app:1h", Repository: "app", Tag: "1h",
+			Digest: "sha256:abc123", SizeBytes: 42, ExpiresAt: expires.UTC(), Actor: "ci-bot"}
+		if ev != want {
+			t.Errorf("event = %+v, want %+v", ev, want)
+		}
+	}
+	if len(phases) != 2 || phases[0] != deletehook.PhasePreDelete || phases[1] != deletehook.PhasePostDelete {
+		t.Errorf("phases = %v, want pre_delete then post_delete", phases)
+	}
+}
@@ -0,0 +1,278 @@
+package reaper
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// Errors returned by the deletion request operations, mapped to HTTP
+// statuses by DeletionHandler.
+var (
+	errDeletionNotFound = errors.New("no such deletion request")
+	errNotProtected     = errors.New("image is not protected")
+	errDeletionState    = errors.New("deletion request is not open")
+	errSelfApproval     = errors.New("deletion requests must be approved by someone other than the requester")
+)
+
+// ParseApprovers builds the token-to-identity map of the deletion API from
+// "name=token" entries.
+func ParseApprovers(entries []string) (map[string]string, error) {
+	approvers := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		name, token, ok := strings.Cut(entry, "=")
+		name, token = strings.TrimSpace(name), strings.TrimSpace(token)
+		if !ok || name == "" || token == "" {
+			return nil, fmt.Errorf("invalid entry %q (want name=token)", entry)
+		}
+		if other, dup := approvers[token]; dup {
+			return nil, fmt.Errorf("%s and %s share a token", other, name)
+		}
+		approvers[token] = name
+	}
+	return approvers, nil
+}
+
+// approver returns the identity whose bearer token is in the Authorization
+// header auth, comparing tokens in constant time.
+func approver(approvers map[string]string, auth string) (string, bool) {
+	token, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	for t, name := range approvers {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// Deletions returns all deletion requests, oldest first.
+func (r *Reaper) Deletions(ctx context.Context) ([]redisclient.DeletionRequest, error) {
+	list, err := r.redis.ListDeletions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Events[0].At.Before(list[j].Events[0].At)
+	})
+	return list, nil
+}
+
+// deletion returns the deletion request with the given ID.
+func (r *Reaper) deletion(ctx context.Context, id string) (redisclient.DeletionRequest, error) {
+	list, err := r.redis.ListDeletions(ctx)
+	if err != nil {
+		return redisclient.DeletionRequest{}, err
+	}
+	for _, d := range list {
+		if d.ID == id {
+			return d, nil
+		}
+	}
+	return redisclient.DeletionRequest{}, errDeletionNotFound
+}
+
+// RequestDeletion opens a request by actor to delete the protected image.
+// Images that are not protected are reaped when they expire and cannot be
+// requested.
+func (r *Reaper) RequestDeletion(
+	ctx context.Context,
+	image, reason, actor string,
+) (redisclient.DeletionRequest, error) {
+	protected, err := r.protected(ctx, image)
+	if err != nil {
+		return redisclient.DeletionRequest{}, fmt.Errorf("checking protection: %w", err)
+	}
+	if !protected {
+		return redisclient.DeletionRequest{}, fmt.Errorf("%w: %s", errNotProtected, image)
+	}
+	list, err := r.redis.ListDeletions(ctx)
+	if err != nil {
+		return redisclient.DeletionRequest{}, err
+	}
+	for _, d := range list {
+		if d.Image == image && (d.Status == redisclient.DeletionPending || d.Status == redisclient.DeletionApproved) {
+			return redisclient.DeletionRequest{}, fmt.Errorf("%w: %s already has request %s", errDeletionState, image, d.ID)
+		}
+	}
+
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	d := redisclient.DeletionRequest{
+		ID:          hex.EncodeToString(id),
+		Image:       image,
+		Reason:      reason,
+		RequestedBy: actor,
+		Status:      redisclient.DeletionPending,
+		Events:      []redisclient.DeletionEvent{{Action: "requested", Actor: actor, At: time.Now().UTC(), Detail: reason}},
+	}
+	if err := r.redis.PutDeletion(ctx, d); err != nil {
+		return d, err
+	}
+	r.logger.Warn("deletion of protected image requested", "id", d.ID, "image", image, "actor", actor, "reason", reason)
+	return d, nil
+}
+
+// ApproveDeletion approves the pending request id on behalf of actor, who
+// must not be its requester. The next reap cycle deletes the image.
+func (r *Reaper) ApproveDeletion(ctx context.Context, id, actor string) (redisclient.DeletionRequest, error) {
+	d, err := r.deletion(ctx, id)
+	if err != nil {
+		return d, err
+	}
+	if d.Status != redisclient.DeletionPending {
+		return d, fmt.Errorf("%w: %s is %s", errDeletionState, id, d.Status)
+	}
+	if actor == d.RequestedBy {
+		return d, errSelfApproval
+	}
+	d.Status = redisclient.DeletionApproved
+	d.Events = append(d.Events, redisclient.DeletionEvent{Action: "approved", Actor: actor, At: time.Now().UTC()})
+	if err := r.redis.PutDeletion(ctx, d); err != nil {
+		return d, err
+	}
+	r.logger.Warn("deletion of protected image approved", "id", id, "image", d.Image, "actor", actor,
+		"requested_by", d.RequestedBy)
+	return d, nil
+}
+
+// CancelDeletion withdraws the request id on behalf of actor before the
+// reaper has acted on it.
+func (r *Reaper) CancelDeletion(ctx context.Context, id, actor string) (redisclient.DeletionRequest, error) {
+	d, err := r.deletion(ctx, id)
+	if err != nil {
+		return d, err
+	}
+	if d.Status != redisclient.DeletionPending && d.Status != redisclient.DeletionApproved {
+		return d, fmt.Errorf("%w: %s is %s", errDeletionState, id, d.Status)
+	}
+	d.Status = redisclient.DeletionCancelled
+	d.Events = append(d.Events, redisclient.DeletionEvent{Action: "cancelled", Actor: actor, At: time.Now().UTC()})
+	if err := r.redis.PutDeletion(ctx, d); err != nil {
+		return d, err
+	}
+	r.logger.Info("deletion of protected image cancelled", "id", id, "image", d.Image, "actor", actor)
+	return d, nil
+}
+
+// approvedDeletions returns the approved deletion requests due now. Those
+// of frozen images wait for the freeze to end.
+func (r *Reaper) approvedDeletions(ctx context.Context, freezes []redisclient.Freeze) []redisclient.DeletionRequest {
+	list, err := r.redis.ListDeletions(ctx)
+	if err != nil {
+		r.logger.Warn("failed to list deletion requests", "error", err)
+		return nil
+	}
+	var due []redisclient.DeletionRequest
+	for _, d := range list {
+		if d.Status == redisclient.DeletionApproved && !frozen(freezes, d.Image) {
+			due = append(due, d)
+		}
+	}
+	return due
+}
+
+// executeDeletions deletes the images of the approved deletion requests
+// list and returns the number of images deleted.
+func (r *Reaper) executeDeletions(ctx context.Context, list []redisclient.DeletionRequest) int {
+	deleted := 0
+	for _, d := range list {
+		event := redisclient.DeletionEvent{Action: "executed", At: time.Now().UTC()}
+		d.Status = redisclient.DeletionExecuted
+		if _, err := r.remove(ctx, d.Image, ReasonApproved); err != nil {
+			r.logger.Error("failed to delete approved image", "id", d.ID, "image", d.Image, "error", err)
+			event.Action, event.Detail = "failed", err.Error()
+			d.Status = redisclient.DeletionFailed
+		} else {
+			r.logger.Warn("deleted protected image on approval", "id", d.ID, "image", d.Image)
+			deleted++
+		}
+		metrics.ApprovedDeletions.WithLabelValues(d.Status).Inc()
+		d.Events = append(d.Events, event)
+		if err := r.redis.PutDeletion(ctx, d); err != nil {
+			r.logger.Error("failed to record deletion outcome", "id", d.ID, "status", d.Status, "error", err)
+		}
+	}
+	return deleted
+}
+
+// DeletionHandler serves deletion requests for protected images. Callers
+// authenticate with a bearer token from approvers, which maps tokens to
+// identities recorded in the audit trail. GET lists all requests; POST
+// opens one from {"image", "reason"}; POST to {id}/approve and {id}/cancel,
+// with the ID and action in the "id" and "action" path values, approves or
+// withdraws one.
+func (r *Reaper) DeletionHandler(approvers map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		actor, ok := approver(approvers, req.Header.Get("Authorization"))
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var (
+			d   redisclient.DeletionRequest
+			err error
+		)
+		status := http.StatusOK
+		switch id, action := req.PathValue("id"), req.PathValue("action"); {
+		case req.Method == http.MethodGet && id == "":
+			list, err := r.Deletions(req.Context())
+			if err != nil {
+				r.logger.Error("failed to list deletion requests", "error", err)
+				http.Error(w, "deletion requests unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(list)
+			return
+		case req.Method == http.MethodPost && id == "":
+			var body struct {
+				Image  string `json:"image"`
+				Reason string `json:"reason"`
+			}
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Image == "" {
+				http.Error(w, "invalid JSON body, want {\"image\": \"repo:tag\"}", http.StatusBadRequest)
+				return
+			}
+			d, err = r.RequestDeletion(req.Context(), body.Image, body.Reason, actor)
+			status = http.StatusCreated
+		case req.Method == http.MethodPost && action == "approve":
+			d, err = r.ApproveDeletion(req.Context(), id, actor)
+		case req.Method == http.MethodPost && action == "cancel":
+			d, err = r.CancelDeletion(req.Context(), id, actor)
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		switch {
+		case errors.Is(err, errDeletionNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, errNotProtected), errors.Is(err, errDeletionState):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, errSelfApproval):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case err != nil:
+			r.logger.Error("deletion request update failed", "actor", actor, "error", err)
+			http.Error(w, "deletion request update failed", http.StatusServiceUnavailable)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			_ = json.NewEncoder(w).Encode(d)
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package reaper
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+func TestParseApprovers(t *testing.T) {
+	got, err := ParseApprovers([]string{"alice=a-token", " bob = b-token "})
+	if err != nil {
+		t.Fatalf("ParseApprovers: %v", err)
+	}
+	if got["a-token"] != "alice" || got["b-token"] != "bob" {
+		t.Errorf("ParseApprovers = %v", got)
+	}
+	for _, entries := range [][]string{{"alice"}, {"=tok"}, {"alice="}, {"alice=tok", "bob=tok"}} {
+		if _, err := ParseApprovers(entries); err == nil {
+			t.Errorf("ParseApprovers(%q): expected error", entries)
+		}
+	}
+}
+
+func TestDeletionHandler(t *testing.T) {
+	var deletes atomic.Int32
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodHead:
+			w.Header().Set("Docker-Content-Digest", "sha256:abc123")
+			w.WriteHeader(http.StatusOK)
+		case http.MethodDelete:
+			deletes.Add(1)
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer reg.Close()
+
+	store := memstore.New()
+	track(t, store, "app:stable", time.Now().Add(time.Hour))
+	track(t, store, "app:1h", time.Now().Add(time.Hour))
+	if err := store.SetProtected(t.Context(), "app:stable", true); err != nil {
+		t.Fatal(err)
+	}
+	r := New(store, reg.URL, slog.Default())
+
+	mux := http.NewServeMux()
+	h := r.DeletionHandler(map[string]string{"a-token": "alice", "b-token": "bob"})
+	mux.Handle("GET /v1/api/deletions", h)
+	mux.Handle("POST /v1/api/deletions", h)
+	mux.Handle("POST /v1/api/deletions/{id}/{action}", h)
+	do := func(token, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewReader([]byte(body)))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		if rr := do(token, http.MethodGet, "/v1/api/deletions", ""); rr.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: expected 401, got %d", token, rr.Code)
+		}
+	}
+	if rr := do("a-token", http.MethodPost, "/v1/api/deletions", `{"image": "app:1h"}`); rr.Code != http.StatusConflict {
+		t.Errorf("request for an unprotected image: expected 409, got %d", rr.Code)
+	}
+
+	rr := do("a-token", http.MethodPost, "/v1/api/deletions", `{"image": "app:stable", "reason": "leaked secret"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("POST: expected 201, got %d: %s", rr.Code, rr.Body)
+	}
+	var d redisclient.DeletionRequest
+	if err := json.NewDecoder(rr.Body).Decode(&d); err != nil {
+		t.Fatal(err)
+	}
+	if d.RequestedBy != "alice" || d.Status != redisclient.DeletionPending {
+		t.Errorf("request = %+v, want pending by alice", d)
+	}
+	rr = do("b-token", http.MethodPost, "/v1/api/deletions", `{"image": "app:stable"}`)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("second request for the same image: expected 409, got %d", rr.Code)
+	}
+
+	if res, _ := r.Reap(t.Context()); res.Approved != 0 || deletes.Load() != 0 {
+		t.Fatalf("result = %+v, want nothing deleted before approval", res)
+	}
+	if rr := do("a-token", http.MethodPost, "/v1/api/deletions/"+d.ID+"/approve", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("self-approval: expected 403, got %d", rr.Code)
+	}
+	if rr := do("b-token", http.MethodPost, "/v1/api/deletions/nope/approve", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown request: expected 404, got %d", rr.Code)
+	}
+	if rr := do("b-token", http.MethodPost, "/v1/api/deletions/"+d.ID+"/approve", ""); rr.Code != http.StatusOK {
+		t.Fatalf("approve: expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+
+	res, err := r.Reap(t.Context())
+	if err != nil {
+		t.Fatalf("Reap: %v", err)
+	}
+	if res.Approved != 1 || deletes.Load() != 1 || tracked(store, "app:stable") {
+		t.Errorf("result = %+v, DELETEs = %d, want the approved image deleted", res, deletes.Load())
+	}
+	if rr := do("b-token", http.MethodPost, "/v1/api/deletions/"+d.ID+"/cancel", ""); rr.Code != http.StatusConflict {
+		t.Errorf("cancel after execution: expected 409, got %d", rr.Code)
+	}
+
+	var list []redisclient.DeletionRequest
+	if err := json.NewDecoder(do("b-token", http.MethodGet, "/v1/api/deletions", "").Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Status != redisclient.DeletionExecuted {
+		t.Fatalf("list = %+v, want the executed request", list)
+	}
+	var trail []string
+	for _, e := range list[0].Events {
+		trail = append(trail, e.Action+":"+e.Actor)
+	}
+	if got := trail; len(got) != 3 || got[0] != "requested:alice" || got[1] != "approved:bob" || got[2] != "executed:" {
+		t.Errorf("audit trail = %v", got)
+	}
+}
+
+func TestCancelDeletion(t *testing.T) {
+	store := memstore.New()
+	track(t, store, "app:stable", time.Now().Add(-time.Minute))
+	r := New(store, "http://registry.invalid", slog.Default(), WithProtection(protectionFunc(func(string) bool {
+		return true
+	})))
+
+	d, err := r.RequestDeletion(t.Context(), "app:stable", "", "alice")
+	if err != nil {
+		t.Fatalf("RequestDeletion: %v", err)
+	}
+	if _, err := r.ApproveDeletion(t.Context(), d.ID, "bob"); err != nil {
+		t.Fatalf("ApproveDeletion: %v", err)
+	}
+	if d, err = r.CancelDeletion(t.Context(), d.ID, "carol"); err != nil || d.Status != redisclient.DeletionCancelled {
+		t.Fatalf("CancelDeletion = %+v, %v", d, err)
+	}
+	if res, _ := r.Reap(t.Context()); res.Approved != 0 || !tracked(store, "app:stable") {
+		t.Errorf("result = %+v, want the cancelled deletion not executed", res)
+	}
+}
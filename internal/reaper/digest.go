@@ -0,0 +1,102 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+// Digest strategies decide which manifest an expired tag is deleted by.
+const (
+	// DigestPushed deletes the manifest recorded at push without asking the
+	// registry, saving a request per image and never deleting an overwrite
+	// the webhook missed. Tags tracked without a digest are resolved.
+	DigestPushed = "pushed"
+	// DigestIndex resolves the tag with every manifest type accepted, which
+	// yields the index of a multi-arch image, and also prefers the pushed
+	// manifest if it is an index listing the reported one, so the image is
+	// deleted as a whole rather than one platform.
+	DigestIndex = "index"
+	// DigestRegistry resolves the tag and deletes the reported manifest.
+	DigestRegistry = "registry"
+)
+
+// DigestStrategies lists the valid digest strategies.
+var DigestStrategies = []string{DigestPushed, DigestIndex, DigestRegistry}
+
+// Digests a deletion used, as the "deleted" label of the mismatch metric.
+const (
+	deletedPushed   = "pushed"
+	deletedReported = "reported"
+)
+
+// WithDigestStrategy sets the digest strategy, DigestPushed by default.
+func WithDigestStrategy(strategy string) Option {
+	return func(r *Reaper) {
+		r.digestStrategy = strategy
+	}
+}
+
+// digestToDelete returns the digest to delete repo:tag, tracked as image,
+// by. found is false when the tag had to be resolved and the registry no
+// longer has it.
+func (r *Reaper) digestToDelete(ctx context.Context, image, repo, tag string) (digest string, found bool, err error) {
+	pushed, err := r.redis.GetImageDigest(ctx, image)
+	if err != nil {
+		pushed = ""
+	}
+	if pushed != "" && (r.digestStrategy == DigestPushed || r.digestStrategy == "") {
+		return pushed, true, nil
+	}
+
+	reported, found, err := r.manifestDigest(ctx, repo, tag)
+	if err != nil || !found {
+		return "", found, err
+	}
+	if reported == "" {
+		return "", true, fmt.Errorf("no digest found for %s", image)
+	}
+	if pushed == "" || pushed == reported {
+		return reported, true, nil
+	}
+
+	// Unless the pushed manifest is an index of the reported one, the
+	// reported manifest is the tag's current one or the index itself.
+	usePushed := false
+	if r.digestStrategy == DigestIndex {
+		if usePushed, err = r.indexOf(ctx, repo, pushed, reported); err != nil {
+			return "", true, err
+		}
+	}
+
+	deleted := deletedReported
+	if usePushed {
+		deleted = deletedPushed
+	}
+	metrics.DigestMismatches.WithLabelValues(deleted).Inc()
+	r.logger.Warn("registry reports a different digest than pushed", "image", image,
+		"pushed", pushed, "reported", reported, "deleting", deleted)
+	if usePushed {
+		return pushed, true, nil
+	}
+	return reported, true, nil
+}
+
+// indexOf reports whether the manifest index repo@index lists child.
+func (r *Reaper) indexOf(ctx context.Context, repo, index, child string) (bool, error) {
+	start := time.Now()
+	refs, err := r.registry.ManifestReferences(ctx, repo, index)
+	r.observe(start)
+	if errors.Is(err, registry.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(refs.Manifests, func(d registry.Descriptor) bool { return d.Digest == child }), nil
+}
@@ -0,0 +1,102 @@
+package reaper
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	"github.com/tamcore/ephemeron/internal/metrics"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+func TestDeleteImage_DigestStrategy(t *testing.T) {
+	const (
+		pushed   = "/v2/myimage/manifests/sha256:pushed"
+		reported = "/v2/myimage/manifests/sha256:reported"
+	)
+	tests := []struct {
+		name       string
+		strategy   string
+		pushed     string
+		pushedGone bool
+		index      bool // the pushed manifest is an index listing the reported one
+		want       string
+		mismatch   string
+		heads      int
+	}{
+		{"pushed without HEAD", DigestPushed, "sha256:pushed", false, false, pushed, "", 0},
+		{"overwrite kept", DigestPushed, "sha256:pushed", true, false, pushed, "", 0},
+		{"no recorded digest", DigestPushed, "", false, false, reported, "", 1},
+		{"index of reported", DigestIndex, "sha256:pushed", false, true, pushed, deletedPushed, 1},
+		{"not an index", DigestIndex, "sha256:pushed", false, false, reported, deletedReported, 1},
+		{"registry", DigestRegistry, "sha256:pushed", false, true, reported, deletedReported, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deleted string
+			var heads int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead {
+					heads++
+				}
+				switch {
+				case r.Method == http.MethodDelete:
+					deleted = r.URL.Path
+					w.WriteHeader(http.StatusAccepted)
+				case strings.HasSuffix(r.URL.Path, "/sha256:pushed") && tt.pushedGone:
+					w.WriteHeader(http.StatusNotFound)
+				case strings.HasSuffix(r.URL.Path, "/sha256:pushed"):
+					w.Header().Set("Docker-Content-Digest", "sha256:pushed")
+					var manifests []registry.Descriptor
+					if tt.index {
+						manifests = append(manifests, registry.Descriptor{Digest: "sha256:reported"})
+					}
+					_ = json.NewEncoder(w).Encode(map[string]any{"mediaType": registry.MediaTypeOCIIndex,
+						"manifests": manifests})
+				default:
+					w.Header().Set("Docker-Content-Digest", "sha256:reported")
+				}
+			}))
+			defer srv.Close()
+
+			store := memstore.New()
+			err := store.TrackImage(t.Context(), "myimage:1h", time.Now().Add(-time.Hour), 0, tt.pushed,
+				redisclient.ImageMeta{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			before := map[string]float64{}
+			for _, label := range []string{deletedPushed, deletedReported} {
+				before[label] = testutil.ToFloat64(metrics.DigestMismatches.WithLabelValues(label))
+			}
+			r := New(store, srv.URL, slog.New(slog.DiscardHandler), WithDigestStrategy(tt.strategy))
+			if err := r.deleteImage(t.Context(), "myimage:1h"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if deleted != tt.want {
+				t.Errorf("expected DELETE %s, got %q", tt.want, deleted)
+			}
+			if heads != tt.heads {
+				t.Errorf("expected %d HEAD requests, got %d", tt.heads, heads)
+			}
+			for label, n := range before {
+				want := 0.0
+				if label == tt.mismatch {
+					want = 1
+				}
+				if got := testutil.ToFloat64(metrics.DigestMismatches.WithLabelValues(label)) - n; got != want {
+					t.Errorf("expected %v mismatches deleting %s, got %v", want, label, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package reaper
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+)
+
+// EvictResult summarizes an emergency eviction run.
+type EvictResult struct {
+	// Skipped is true when another replica held the reaper lock.
+	Skipped bool `json:"skipped" yaml:"skipped"`
+	// Evicted is the number of images deleted.
+	Evicted int `json:"evicted" yaml:"evicted"`
+	// ReclaimedBytes is the tracked size of the deleted images.
+	ReclaimedBytes int64 `json:"reclaimed_bytes" yaml:"reclaimed_bytes"`
+	// Failed is the number of deletions that failed.
+	Failed int `json:"failed" yaml:"failed"`
+	// Quarantined, Frozen, Protected and Deferred count the images spared
+	// for the same reasons a reap cycle skips them.
+	Quarantined int `json:"quarantined,omitempty" yaml:"quarantined,omitempty"`
+	Frozen      int `json:"frozen,omitempty" yaml:"frozen,omitempty"`
+	Protected   int `json:"protected,omitempty" yaml:"protected,omitempty"`
+	Deferred    int `json:"deferred,omitempty" yaml:"deferred,omitempty"`
+}
+
+// Evict deletes tracked images in order of expiry, soonest first and
+// regardless of whether they have expired, until targetBytes of tracked
+// storage has been reclaimed or no images remain. Images a reap cycle would
+// skip — quarantined, frozen, protected or still in use — are spared even
+// then.
+func (r *Reaper) Evict(ctx context.Context, targetBytes int64) (EvictResult, error) {
+	var res EvictResult
+
+	ctx, release, acquired, err := r.holdLock(ctx)
+	if err != nil {
+		return res, err
+	}
+	if !acquired {
+		r.logger.Warn("another replica holds the reaper lock, skipping eviction")
+		res.Skipped = true
+		return res, nil
+	}
+	defer release()
+
+	freezes, err := r.freezes(ctx)
+	if err != nil {
+		return res, fmt.Errorf("listing freezes: %w", err)
+	}
+
+	quarantined, err := r.quarantined(ctx)
+	if err != nil {
+		r.logger.Warn("failed to list quarantined images", "error", err)
+	}
+	c := cycle{quarantined: quarantined, freezes: freezes}
+
+	metrics.EmergencyEvictions.Inc()
+
+	images, err := r.redis.ListImages(ctx)
+	if err != nil {
+		return res, fmt.Errorf("listing images: %w", err)
+	}
+
+	type candidate struct {
+		image   string
+		expires int64
+	}
+	candidates := make([]candidate, 0, len(images))
+	for _, image := range images {
+		verdict, err := r.spared(ctx, c, image)
+		if err != nil {
+			r.logger.Warn("failed to check image protection, sparing", "image", image, "error", err)
+		}
+		switch verdict {
+		case VerdictQuarantined:
+			res.Quarantined++
+			continue
+		case VerdictFrozen:
+			res.Frozen++
+			continue
+		case VerdictProtected:
+			res.Protected++
+			continue
+		case VerdictDeferred:
+			res.Deferred++
+			continue
+		}
+		expires, err := r.redis.GetExpiry(ctx, image)
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, candidate{image: image, expires: expires})
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].expires < candidates[j].expires })
+
+	r.logger.Warn("emergency eviction starting", "target_bytes", targetBytes, "candidates", len(candidates))
+	for _, c := range candidates {
+		if res.ReclaimedBytes >= targetBytes {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		size, err := r.remove(ctx, c.image, ReasonEvicted)
+		if err != nil {
+			r.logger.Error("failed to evict image", "image", c.image, "error", err)
+			res.Failed++
+			continue
+		}
+		res.Evicted++
+		res.ReclaimedBytes += size
+		metrics.EvictedImages.Inc()
+		r.logger.Warn("evicted image", "image", c.image, "size_bytes", size)
+	}
+
+	r.logger.Warn("emergency eviction finished",
+		"evicted", res.Evicted,
+		"reclaimed_bytes", res.ReclaimedBytes,
+		"target_bytes", targetBytes,
+		"failed", res.Failed,
+	)
+	return res, nil
+}
+
+// checkDisk evicts images when the registry filesystem has less than
+// minFreeBytes available, aiming to free the shortfall. Evicted images only
+// free space once the registry garbage collects them, so bytes evicted by
+// earlier checks and not yet freed count towards the shortfall.
+func (r *Reaper) checkDisk(ctx context.Context) error {
+	if r.minFreeBytes <= 0 {
+		return nil
+	}
+	u, err := r.statFS(r.dataPath)
+	if err != nil {
+		return err
+	}
+	free := int64(min(u.FreeBytes, math.MaxInt64))
+	if r.awaitingGC > 0 && free > r.lastFree {
+		r.awaitingGC = max(0, r.awaitingGC-(free-r.lastFree))
+	}
+	r.lastFree = free
+	if free >= r.minFreeBytes {
+		r.awaitingGC = 0
+		return nil
+	}
+	shortfall := r.minFreeBytes - free - r.awaitingGC
+	if shortfall <= 0 {
+		r.logger.Info("registry filesystem low on space, waiting for garbage collection",
+			"free_bytes", free,
+			"min_free_bytes", r.minFreeBytes,
+			"awaiting_gc_bytes", r.awaitingGC,
+		)
+		return nil
+	}
+	r.logger.Warn("registry filesystem low on space",
+		"free_bytes", free,
+		"min_free_bytes", r.minFreeBytes,
+		"awaiting_gc_bytes", r.awaitingGC,
+	)
+	res, err := r.Evict(ctx, shortfall)
+	r.awaitingGC += res.ReclaimedBytes
+	return err
+}
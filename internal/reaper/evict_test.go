@@ -0,0 +1,207 @@
+package reaper
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/diskusage"
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+func TestEvict_SoonestExpiringFirst(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Content-Digest", "sha256:abc")
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer registry.Close()
+
+	store := memstore.New()
+	now := time.Now()
+	for _, img := range []struct {
+		name    string
+		expires time.Duration
+		size    int64
+	}{
+		{"late:1h", 3 * time.Hour, 100},
+		{"soon:1h", time.Hour, 100},
+		{"next:1h", 2 * time.Hour, 100},
+	} {
+		err := store.TrackImage(t.Context(), img.name, now.Add(img.expires), img.size, "", redisclient.ImageMeta{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := New(store, registry.URL, slog.Default())
+	res, err := r.Evict(t.Context(), 150)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Evicted != 2 || res.ReclaimedBytes != 200 {
+		t.Errorf("expected 2 images and 200 bytes evicted, got %+v", res)
+	}
+	if tracked(store, "soon:1h") || tracked(store, "next:1h") {
+		t.Error("expected the two soonest-expiring images to be evicted")
+	}
+	if !tracked(store, "late:1h") {
+		t.Error("expected late:1h to remain tracked")
+	}
+	_, reclaimed, _ := store.ReapTotals(t.Context())
+	if reclaimed != 200 {
+		t.Errorf("expected 200 reclaimed bytes recorded, got %d", reclaimed)
+	}
+}
+
+func TestEvict_SparesWhatReapSkips(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Content-Digest", "sha256:abc")
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer registry.Close()
+
+	store := memstore.New()
+	for _, image := range []string{"pinned:1h", "stuck:1h", "app:1h"} {
+		track(t, store, image, time.Now().Add(time.Hour))
+	}
+	if err := store.QuarantineImage(t.Context(), redisclient.QuarantinedImage{Image: "stuck:1h", Failures: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(store, registry.URL, slog.Default(),
+		WithQuarantine(3),
+		WithProtection(protectionFunc(func(image string) bool { return image == "pinned:1h" })),
+	)
+	res, err := r.Evict(t.Context(), 1000)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Evicted != 1 || res.Protected != 1 || res.Quarantined != 1 {
+		t.Errorf("expected 1 evicted, 1 protected and 1 quarantined, got %+v", res)
+	}
+	if !tracked(store, "pinned:1h") || !tracked(store, "stuck:1h") {
+		t.Error("expected protected and quarantined images to survive eviction")
+	}
+}
+
+func TestEvict_SkipsWhenLockHeld(t *testing.T) {
+	store := memstore.New()
+	if _, err := store.AcquireReaperLock(t.Context(), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(store, "http://unused", slog.Default())
+	res, err := r.Evict(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !res.Skipped {
+		t.Error("expected eviction to be skipped")
+	}
+}
+
+func TestCheckDisk_EvictsShortfall(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Content-Digest", "sha256:abc")
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer registry.Close()
+
+	store := memstore.New()
+	now := time.Now()
+	for i, name := range []string{"a:1h", "b:1h", "c:1h"} {
+		expires := now.Add(time.Duration(i+1) * time.Hour)
+		if err := store.TrackImage(t.Context(), name, expires, 100, "", redisclient.ImageMeta{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := New(store, registry.URL, slog.Default(), WithDiskProbe("/data", 1000))
+	r.statFS = func(string) (diskusage.Usage, error) {
+		return diskusage.Usage{TotalBytes: 10000, FreeBytes: 850}, nil
+	}
+	if err := r.checkDisk(t.Context()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tracked(store, "a:1h") || tracked(store, "b:1h") {
+		t.Error("expected the shortfall of 150 bytes to evict two images")
+	}
+	if !tracked(store, "c:1h") {
+		t.Error("expected c:1h to remain tracked")
+	}
+}
+
+func TestCheckDisk_CountsEvictionsAwaitingGC(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Content-Digest", "sha256:abc")
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer registry.Close()
+
+	store := memstore.New()
+	now := time.Now()
+	for i, name := range []string{"a:1h", "b:1h", "c:1h", "d:1h"} {
+		expires := now.Add(time.Duration(i+1) * time.Hour)
+		if err := store.TrackImage(t.Context(), name, expires, 100, "", redisclient.ImageMeta{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	free := uint64(850)
+	r := New(store, registry.URL, slog.Default(), WithDiskProbe("/data", 1000))
+	r.statFS = func(string) (diskusage.Usage, error) {
+		return diskusage.Usage{TotalBytes: 10000, FreeBytes: free}, nil
+	}
+	for range 2 {
+		if err := r.checkDisk(t.Context()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if !tracked(store, "c:1h") || !tracked(store, "d:1h") {
+		t.Fatal("expected an unchanged shortfall not to be evicted twice before garbage collection")
+	}
+
+	// GC frees 100 of the 200 evicted bytes; 50 are still short of the 1000.
+	free = 950
+	if err := r.checkDisk(t.Context()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !tracked(store, "c:1h") {
+		t.Error("expected the bytes still awaiting GC to cover the remaining shortfall")
+	}
+}
+
+func TestCheckDisk_EnoughSpace(t *testing.T) {
+	store := memstore.New()
+	track(t, store, "a:1h", time.Now().Add(time.Hour))
+
+	r := New(store, "http://unused", slog.Default(), WithDiskProbe("/data", 1000))
+	r.statFS = func(string) (diskusage.Usage, error) {
+		return diskusage.Usage{TotalBytes: 10000, FreeBytes: 5000}, nil
+	}
+	if err := r.checkDisk(t.Context()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !tracked(store, "a:1h") {
+		t.Error("expected no eviction with enough free space")
+	}
+}
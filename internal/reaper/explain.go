@@ -0,0 +1,158 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// Verdicts of an Explanation.
+const (
+	VerdictDegraded    = "degraded"
+	VerdictUntracked   = "untracked"
+	VerdictNewerRecord = "newer_record"
+	VerdictNotExpired  = "not_expired"
+	VerdictQuarantined = "quarantined"
+	VerdictFrozen      = "frozen"
+	VerdictProtected   = "protected"
+	VerdictDeferred    = "deferred"
+	VerdictDelete      = "delete"
+)
+
+// Check is one step of the reaper's decision about an image. Passed is
+// false for the check that keeps the image.
+type Check struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Explanation is what a reap cycle starting now would do with an image,
+// with the checks leading to it in the order the cycle makes them.
+type Explanation struct {
+	Verdict string  `json:"verdict"`
+	Checks  []Check `json:"checks"`
+}
+
+// pass records a passed check.
+func (e *Explanation) pass(name, detail string) {
+	e.Checks = append(e.Checks, Check{Name: name, Passed: true, Detail: detail})
+}
+
+// stop records the failed check that decides verdict.
+func (e *Explanation) stop(verdict, name, detail string) Explanation {
+	e.Checks = append(e.Checks, Check{Name: name, Detail: detail})
+	e.Verdict = verdict
+	return *e
+}
+
+// Explain reports what a reap cycle starting now would do with image and
+// why, making the same checks as Reap without changing anything.
+func (r *Reaper) Explain(ctx context.Context, image string) (Explanation, error) {
+	e := Explanation{Checks: []Check{}}
+
+	degraded, err := r.redis.Degraded(ctx)
+	if err != nil {
+		return e, fmt.Errorf("checking store health: %w", err)
+	}
+	if degraded != "" {
+		return e.stop(VerdictDegraded, "store", "cycles are skipped while the store is degraded: "+degraded), nil
+	}
+	e.pass("store", "healthy")
+
+	expiresAt, err := r.redis.GetExpiry(ctx, image)
+	switch {
+	case errors.Is(err, redisclient.ErrNewerRecord):
+		return e.stop(VerdictNewerRecord, "tracked", err.Error()), nil
+	case err != nil:
+		tracked, listErr := r.tracked(ctx, image)
+		if listErr != nil {
+			return e, listErr
+		}
+		if !tracked {
+			return e.stop(VerdictUntracked, "tracked", "not in the tracked images"), nil
+		}
+		return e.stop(VerdictUntracked, "tracked",
+			"unreadable expiry, the next cycle drops the record: "+err.Error()), nil
+	}
+	e.pass("tracked", "")
+
+	expires := time.UnixMilli(expiresAt)
+	if remaining := time.Until(expires); remaining > 0 {
+		return e.stop(VerdictNotExpired, "expired", fmt.Sprintf("expires at %s, in %s",
+			expires.UTC().Format(time.RFC3339), remaining.Round(time.Second))), nil
+	}
+	e.pass("expired", "expired at "+expires.UTC().Format(time.RFC3339))
+
+	if r.quarantineAfter > 0 {
+		list, err := r.redis.ListQuarantined(ctx)
+		if err != nil {
+			return e, fmt.Errorf("listing quarantined images: %w", err)
+		}
+		for _, q := range list {
+			if q.Image == image {
+				return e.stop(VerdictQuarantined, "quarantine",
+					fmt.Sprintf("quarantined after %d failed deletions: %s", q.Failures, q.Reason)), nil
+			}
+		}
+	}
+	e.pass("quarantine", "")
+
+	freezes, err := r.redis.ListFreezes(ctx)
+	if err != nil {
+		return e, fmt.Errorf("listing freezes: %w", err)
+	}
+	repo, _, _ := strings.Cut(image, ":")
+	for _, f := range freezes {
+		if f.Covers(repo) {
+			return e.stop(VerdictFrozen, "freeze", fmt.Sprintf("frozen by %q until %s: %s",
+				f.Pattern, f.Until.UTC().Format(time.RFC3339), f.Reason)), nil
+		}
+	}
+	e.pass("freeze", "")
+
+	if r.protection != nil && r.protection.Protected(image) {
+		return e.stop(VerdictProtected, "protection", "matches a protected pattern"), nil
+	}
+	switch protected, err := r.redis.IsProtected(ctx, image); {
+	case err != nil:
+		return e.stop(VerdictProtected, "protection", "protection unreadable, skipped: "+err.Error()), nil
+	case protected:
+		return e.stop(VerdictProtected, "protection", "protected by a push policy"), nil
+	}
+	e.pass("protection", "")
+
+	if r.workloads != nil {
+		digest, err := r.redis.GetImageDigest(ctx, image)
+		if err != nil {
+			digest = ""
+		}
+		switch inUse, err := r.workloads.InUse(image, digest); {
+		case err != nil:
+			return e.stop(VerdictDeferred, "workloads", "workload references unknown, deferred: "+err.Error()), nil
+		case inUse:
+			return e.stop(VerdictDeferred, "workloads", "still referenced by a workload"), nil
+		}
+	}
+	e.pass("workloads", "")
+
+	e.Verdict = VerdictDelete
+	if p, err := r.redis.GetPriority(ctx, image); err == nil && p != 0 {
+		e.pass("priority", fmt.Sprintf("deleted with priority %d", p))
+	}
+	return e, nil
+}
+
+// tracked reports whether image is in the set of tracked images.
+func (r *Reaper) tracked(ctx context.Context, image string) (bool, error) {
+	images, err := r.redis.ListImages(ctx)
+	if err != nil {
+		return false, fmt.Errorf("listing images: %w", err)
+	}
+	return slices.Contains(images, image), nil
+}
@@ -0,0 +1,73 @@
+package reaper
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+func TestExplain(t *testing.T) {
+	store := memstore.New()
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	track(t, store, "app:expired", past)
+	track(t, store, "app:fresh", future)
+	track(t, store, "team/app:frozen", past)
+	track(t, store, "app:pinned", past)
+	track(t, store, "app:policy", past)
+	track(t, store, "app:running", past)
+	track(t, store, "app:flaky", past)
+	now := time.Now()
+	_ = store.SetFreeze(t.Context(), redisclient.Freeze{Pattern: "team/*", Since: now, Until: now.Add(time.Hour)})
+	_ = store.SetProtected(t.Context(), "app:policy", true)
+	_ = store.SetPriority(t.Context(), "app:expired", 5)
+	_ = store.QuarantineImage(t.Context(), redisclient.QuarantinedImage{Image: "app:flaky", Failures: 3})
+
+	r := New(store, "http://registry.invalid", slog.Default(),
+		WithQuarantine(3),
+		WithProtection(protectionFunc(func(image string) bool { return image == "app:pinned" })),
+		WithWorkloadReferences(workloadsFunc(func(image, _ string) (bool, error) {
+			if image == "app:running" {
+				return false, errors.New("api server unreachable")
+			}
+			return false, nil
+		})),
+	)
+
+	tests := []struct {
+		image, want string
+		checks      int
+	}{
+		{image: "app:expired", want: VerdictDelete, checks: 8},
+		{image: "app:missing", want: VerdictUntracked, checks: 2},
+		{image: "app:fresh", want: VerdictNotExpired, checks: 3},
+		{image: "app:flaky", want: VerdictQuarantined, checks: 4},
+		{image: "team/app:frozen", want: VerdictFrozen, checks: 5},
+		{image: "app:pinned", want: VerdictProtected, checks: 6},
+		{image: "app:policy", want: VerdictProtected, checks: 6},
+		{image: "app:running", want: VerdictDeferred, checks: 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			e, err := r.Explain(t.Context(), tt.image)
+			if err != nil {
+				t.Fatalf("Explain: %v", err)
+			}
+			if e.Verdict != tt.want || len(e.Checks) != tt.checks {
+				t.Fatalf("Explain() = %+v, want verdict %s after %d checks", e, tt.want, tt.checks)
+			}
+			last := e.Checks[len(e.Checks)-1]
+			if last.Passed != (tt.want == VerdictDelete) {
+				t.Errorf("last check = %+v", last)
+			}
+		})
+	}
+
+	// Explaining changes nothing.
+	if !tracked(store, "app:expired") {
+		t.Error("Explain removed the image")
+	}
+}
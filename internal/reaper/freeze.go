@@ -0,0 +1,108 @@
+package reaper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/hooks"
+	"github.com/tamcore/ephemeron/internal/metrics"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// NewFreeze returns a freeze of the repositories matching pattern, or of
+// all repositories if pattern is empty, lasting d from now. d uses the tag
+// syntax, such as "48h" or "1w".
+func NewFreeze(pattern, reason, d string) (redisclient.Freeze, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return redisclient.Freeze{}, fmt.Errorf("pattern %q: %w", pattern, err)
+	}
+	dur := hooks.ParseTTL(d)
+	if dur <= 0 {
+		return redisclient.Freeze{}, fmt.Errorf("invalid duration %q", d)
+	}
+	now := time.Now().UTC()
+	return redisclient.Freeze{Pattern: pattern, Reason: reason, Since: now, Until: now.Add(dur)}, nil
+}
+
+// freezes returns the freezes in effect and updates the freeze gauge.
+func (r *Reaper) freezes(ctx context.Context) ([]redisclient.Freeze, error) {
+	list, err := r.redis.ListFreezes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	metrics.ActiveFreezes.Set(float64(len(list)))
+	return list, nil
+}
+
+// frozen reports whether any of freezes covers image.
+func frozen(freezes []redisclient.Freeze, image string) bool {
+	repo, _, _ := strings.Cut(image, ":")
+	for _, f := range freezes {
+		if f.Covers(repo) {
+			return true
+		}
+	}
+	return false
+}
+
+// FreezeHandler lists freezes on GET, adds one on POST and lifts one on
+// DELETE. POST takes {"pattern", "for", "reason"} as JSON; DELETE takes the
+// pattern as a query parameter, where an empty pattern is the global freeze.
+func (r *Reaper) FreezeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			list, err := r.freezes(req.Context())
+			if err != nil {
+				r.logger.Error("failed to list freezes", "error", err)
+				http.Error(w, "freezes unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			sort.Slice(list, func(i, j int) bool { return list[i].Pattern < list[j].Pattern })
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(list)
+		case http.MethodPost:
+			var body struct {
+				Pattern string `json:"pattern"`
+				For     string `json:"for"`
+				Reason  string `json:"reason"`
+			}
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				http.Error(w, "invalid JSON body", http.StatusBadRequest)
+				return
+			}
+			f, err := NewFreeze(body.Pattern, body.Reason, body.For)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := r.redis.SetFreeze(req.Context(), f); err != nil {
+				r.logger.Error("failed to store freeze", "pattern", f.Pattern, "error", err)
+				http.Error(w, "freeze failed", http.StatusServiceUnavailable)
+				return
+			}
+			r.logger.Warn("deletions frozen", "pattern", f.Pattern, "until", f.Until, "reason", f.Reason)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(f)
+		case http.MethodDelete:
+			pattern := req.URL.Query().Get("pattern")
+			if err := r.redis.Unfreeze(req.Context(), pattern); err != nil {
+				r.logger.Error("failed to lift freeze", "pattern", pattern, "error", err)
+				http.Error(w, "unfreeze failed", http.StatusServiceUnavailable)
+				return
+			}
+			r.logger.Info("freeze lifted", "pattern", pattern)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
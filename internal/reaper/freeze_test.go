@@ -0,0 +1,95 @@
+package reaper
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+func TestReap_Freeze(t *testing.T) {
+	var deletes atomic.Int32
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodHead:
+			w.Header().Set("Docker-Content-Digest", "sha256:abc123")
+			w.WriteHeader(http.StatusOK)
+		case http.MethodDelete:
+			deletes.Add(1)
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer reg.Close()
+
+	store := memstore.New()
+	track(t, store, "team/app:1h", time.Now().Add(-time.Minute))
+	track(t, store, "other:1h", time.Now().Add(-time.Minute))
+	r := New(store, reg.URL, slog.Default())
+
+	now := time.Now()
+	_ = store.SetFreeze(t.Context(), redisclient.Freeze{Pattern: "team/*", Since: now, Until: now.Add(time.Hour)})
+	res, err := r.Reap(t.Context())
+	if err != nil {
+		t.Fatalf("Reap: %v", err)
+	}
+	if res.Frozen != 1 || res.Reaped != 1 || !tracked(store, "team/app:1h") {
+		t.Errorf("result = %+v, want team/app frozen and other reaped", res)
+	}
+
+	_ = store.SetFreeze(t.Context(), redisclient.Freeze{Since: now, Until: now.Add(time.Hour)})
+	track(t, store, "other:1h", time.Now().Add(-time.Minute))
+	if err := r.ReapImage(t.Context(), "other:1h"); err != nil {
+		t.Fatalf("ReapImage: %v", err)
+	}
+	if ev, _ := r.Evict(t.Context(), 1<<30); ev.Evicted != 0 || ev.Frozen != 2 {
+		t.Errorf("evict = %+v, want everything spared by the global freeze", ev)
+	}
+	if deletes.Load() != 1 {
+		t.Errorf("DELETEs = %d, want only the one before the global freeze", deletes.Load())
+	}
+
+	_ = store.SetFreeze(t.Context(), redisclient.Freeze{Since: now, Until: now})
+	_ = store.Unfreeze(t.Context(), "team/*")
+	if res, _ := r.Reap(t.Context()); res.Reaped != 2 {
+		t.Errorf("result = %+v, want both reaped once freezes lapse", res)
+	}
+}
+
+func TestFreezeHandler(t *testing.T) {
+	store := memstore.New()
+	r := New(store, "http://registry.invalid", slog.Default())
+	h := r.FreezeHandler()
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(method, target, bytes.NewReader([]byte(body))))
+		return rr
+	}
+
+	rr := do(http.MethodPost, "/v1/api/freeze", `{"pattern": "team/*", "for": "48h", "reason": "audit"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("POST: expected 201, got %d: %s", rr.Code, rr.Body)
+	}
+	for _, body := range []string{`{"for": "never"}`, `{"pattern": "[", "for": "1h"}`, `nope`} {
+		if rr := do(http.MethodPost, "/v1/api/freeze", body); rr.Code != http.StatusBadRequest {
+			t.Errorf("POST %s: expected 400, got %d", body, rr.Code)
+		}
+	}
+	if rr := do(http.MethodGet, "/v1/api/freeze", ""); !bytes.Contains(rr.Body.Bytes(), []byte(`"reason":"audit"`)) {
+		t.Errorf("GET = %s, want the audit freeze", rr.Body)
+	}
+	if rr := do(http.MethodDelete, "/v1/api/freeze?pattern=team/*", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: expected 204, got %d", rr.Code)
+	}
+	if list, _ := store.ListFreezes(t.Context()); len(list) != 0 {
+		t.Errorf("freezes after DELETE = %+v", list)
+	}
+	if rr := do(http.MethodPut, "/v1/api/freeze", ""); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT: expected 405, got %d", rr.Code)
+	}
+}
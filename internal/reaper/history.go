@@ -0,0 +1,74 @@
+package reaper
+
+import (
+	"sync"
+	"time"
+)
+
+// maxHistory bounds the number of reap cycles kept for reporting; at the
+// default one-minute interval it covers a little over a week.
+const maxHistory = 11000
+
+// Cycle describes a completed reap cycle on this replica.
+type Cycle struct {
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Result
+}
+
+// history is a fixed-size ring of recent cycles.
+type history struct {
+	mu     sync.Mutex
+	cycles []Cycle
+	next   int
+	full   bool
+}
+
+func newHistory(size int) *history {
+	return &history{cycles: make([]Cycle, size)}
+}
+
+func (h *history) add(c Cycle) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cycles[h.next] = c
+	h.next = (h.next + 1) % len(h.cycles)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// last returns the most recently recorded cycle.
+func (h *history) last() (Cycle, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.next == 0 && !h.full {
+		return Cycle{}, false
+	}
+	return h.cycles[(h.next+len(h.cycles)-1)%len(h.cycles)], true
+}
+
+// since returns the recorded cycles that started at or after t, oldest first.
+func (h *history) since(t time.Time) []Cycle {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ordered := h.cycles[:h.next]
+	if h.full {
+		ordered = append(append([]Cycle(nil), h.cycles[h.next:]...), h.cycles[:h.next]...)
+	}
+	var out []Cycle
+	for _, c := range ordered {
+		if !c.Start.Before(t) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// CyclesSince returns the reap cycles this replica completed since t,
+// oldest first. Cycles skipped because another replica held the lock are
+// not recorded.
+func (r *Reaper) CyclesSince(t time.Time) []Cycle {
+	return r.history.since(t)
+}
@@ -0,0 +1,22 @@
+package reaper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistory_Since(t *testing.T) {
+	h := newHistory(3)
+	base := time.Now()
+	for i := range 5 {
+		h.add(Cycle{Start: base.Add(time.Duration(i) * time.Minute), Result: Result{Reaped: i}})
+	}
+
+	got := h.since(time.Time{})
+	if len(got) != 3 || got[0].Reaped != 2 || got[2].Reaped != 4 {
+		t.Fatalf("expected the 3 newest cycles oldest first, got %+v", got)
+	}
+	if got := h.since(base.Add(4 * time.Minute)); len(got) != 1 || got[0].Reaped != 4 {
+		t.Fatalf("expected only the last cycle, got %+v", got)
+	}
+}
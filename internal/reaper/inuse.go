@@ -0,0 +1,59 @@
+package reaper
+
+import (
+	"context"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+)
+
+// Reasons an expired image's deletion was deferred, used as the "reason"
+// metric label.
+const (
+	deferInUse   = "in_use"
+	deferUnknown = "unknown"
+)
+
+// WorkloadReferences reports whether running workloads still reference an
+// image, by "repo:tag" or by manifest digest.
+type WorkloadReferences interface {
+	InUse(image, digest string) (bool, error)
+}
+
+// WithWorkloadReferences defers the deletion of expired images that refs
+// reports as still referenced by a workload, so it can be pulled again
+// when a Pod restarts or reschedules.
+func WithWorkloadReferences(refs WorkloadReferences) Option {
+	return func(r *Reaper) {
+		r.workloads = refs
+	}
+}
+
+// deferred reports whether the deletion of image must wait because a
+// workload references it. Like protection, it fails closed: an image is
+// also deferred while the references cannot be determined. A dry run does
+// not count the deferral.
+func (r *Reaper) deferred(ctx context.Context, image string, dryRun bool) bool {
+	if r.workloads == nil {
+		return false
+	}
+	digest, err := r.redis.GetImageDigest(ctx, image)
+	if err != nil {
+		digest = ""
+	}
+	inUse, err := r.workloads.InUse(image, digest)
+	switch {
+	case err != nil:
+		r.logger.Warn("failed to check workload references, deferring deletion", "image", image, "error", err)
+		if !dryRun {
+			metrics.DeferredDeletions.WithLabelValues(deferUnknown).Inc()
+		}
+		return true
+	case inUse:
+		r.logger.Debug("expired image still referenced by a workload, deferring deletion", "image", image)
+		if !dryRun {
+			metrics.DeferredDeletions.WithLabelValues(deferInUse).Inc()
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package reaper
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// workloadsFunc adapts a function to WorkloadReferences.
+type workloadsFunc func(image, digest string) (bool, error)
+
+func (f workloadsFunc) InUse(image, digest string) (bool, error) { return f(image, digest) }
+
+func TestReap_DefersImagesInUse(t *testing.T) {
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodHead:
+			w.Header().Set("Docker-Content-Digest", "sha256:abc123")
+			w.WriteHeader(http.StatusOK)
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer reg.Close()
+
+	store := memstore.New()
+	track(t, store, "running:1h", time.Now().Add(-time.Minute))
+	track(t, store, "idle:1h", time.Now().Add(-time.Minute))
+	if err := store.TrackImage(t.Context(), "pinned:1h", time.Now().Add(-time.Minute), 0, "sha256:pinned",
+		redisclient.ImageMeta{}); err != nil {
+		t.Fatal(err)
+	}
+
+	inUse := workloadsFunc(func(image, digest string) (bool, error) {
+		return image == "running:1h" || digest == "sha256:pinned", nil
+	})
+	r := New(store, reg.URL, slog.Default(), WithWorkloadReferences(inUse))
+	res, err := r.Reap(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Deferred != 2 || res.Reaped != 1 {
+		t.Errorf("expected 2 deferred and 1 reaped, got %+v", res)
+	}
+	if !tracked(store, "running:1h") || !tracked(store, "pinned:1h") || tracked(store, "idle:1h") {
+		t.Error("expected only the unreferenced image to be reaped")
+	}
+}
+
+func TestReap_DefersWhenReferencesUnknown(t *testing.T) {
+	store := memstore.New()
+	track(t, store, "app:1h", time.Now().Add(-time.Minute))
+
+	r := New(store, "http://127.0.0.1:0", slog.Default(), WithWorkloadReferences(workloadsFunc(
+		func(string, string) (bool, error) { return false, errors.New("scan is stale") },
+	)))
+	res, err := r.Reap(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Deferred != 1 || res.Attempted != 0 || !tracked(store, "app:1h") {
+		t.Errorf("expected deletion to be deferred, got %+v", res)
+	}
+}
@@ -0,0 +1,135 @@
+package reaper
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTopImages is how many images TopImagesHandler lists without a
+// limit, and MaxTopImages the most it lists.
+const (
+	DefaultTopImages = 20
+	MaxTopImages     = 1000
+)
+
+// LargeImage is a tracked manifest with its size. Tags of a repository
+// sharing the manifest are listed together, since it is only deleted with
+// the last of them.
+type LargeImage struct {
+	Image      string   `json:"image"`
+	Repository string   `json:"repository"`
+	Tags       []string `json:"tags"`
+	Digest     string   `json:"digest,omitempty"`
+	SizeBytes  int64    `json:"size_bytes"`
+	// ExpiresAt is when the last of the tags expires.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// LargestImages returns up to limit tracked manifests, largest first.
+// Images of unknown size are left out.
+func (r *Reaper) LargestImages(ctx context.Context, limit int) ([]LargeImage, error) {
+	images, err := r.redis.ListImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]*LargeImage)
+	for _, image := range images {
+		size, err := r.redis.GetImageSize(ctx, image)
+		if err != nil {
+			return nil, err
+		}
+		if size <= 0 {
+			continue
+		}
+		digest, err := r.redis.GetImageDigest(ctx, image)
+		if err != nil {
+			return nil, err
+		}
+		expiry, err := r.redis.GetExpiry(ctx, image)
+		if err != nil {
+			return nil, err
+		}
+		repo, tag, _ := strings.Cut(image, ":")
+		key := image
+		if digest != "" {
+			key = repo + "@" + digest
+		}
+		g, ok := groups[key]
+		if !ok {
+			g = &LargeImage{Repository: repo, Digest: digest, SizeBytes: size}
+			groups[key] = g
+		}
+		g.Tags = append(g.Tags, tag)
+		if expiresAt := time.UnixMilli(expiry).UTC(); expiresAt.After(g.ExpiresAt) {
+			g.ExpiresAt = expiresAt
+		}
+	}
+
+	out := make([]LargeImage, 0, len(groups))
+	for _, g := range groups {
+		slices.Sort(g.Tags)
+		g.Image = g.Repository + ":" + g.Tags[0]
+		out = append(out, *g)
+	}
+	slices.SortFunc(out, func(a, b LargeImage) int {
+		if c := cmp.Compare(b.SizeBytes, a.SizeBytes); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Image, b.Image)
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// LargestImageSizes returns the sizes of the limit largest manifests keyed
+// by image, for metrics.NewLargestImagesCollector.
+func (r *Reaper) LargestImageSizes(ctx context.Context, limit int) (map[string]int64, error) {
+	images, err := r.LargestImages(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]int64, len(images))
+	for _, image := range images {
+		sizes[image.Image] = image.SizeBytes
+	}
+	return sizes, nil
+}
+
+// TopImagesHandler serves LargestImages as JSON. The by query parameter
+// names the order, of which only "size" is supported, and limit how many
+// images to list.
+func (r *Reaper) TopImagesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		if by := q.Get("by"); by != "" && by != "size" {
+			http.Error(w, "unsupported order, want size", http.StatusBadRequest)
+			return
+		}
+		limit := DefaultTopImages
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > MaxTopImages {
+				http.Error(w, "limit must be between 1 and "+strconv.Itoa(MaxTopImages), http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		images, err := r.LargestImages(req.Context(), limit)
+		if err != nil {
+			r.logger.Error("failed to list largest images", "error", err)
+			http.Error(w, "images unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(images)
+	})
+}
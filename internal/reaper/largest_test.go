@@ -0,0 +1,90 @@
+package reaper
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+func TestLargestImages(t *testing.T) {
+	store := memstore.New()
+	now := time.Now().Truncate(time.Millisecond)
+	images := []struct {
+		image   string
+		size    int64
+		digest  string
+		expires time.Time
+	}{
+		{"app:1h", 500, "sha256:app", now.Add(time.Hour)},
+		{"app:latest", 500, "sha256:app", now.Add(24 * time.Hour)},
+		{"db:6h", 900, "sha256:db", now.Add(6 * time.Hour)},
+		{"tiny:1h", 10, "", now.Add(time.Hour)},
+		{"unsized:1h", 0, "", now.Add(time.Hour)},
+	}
+	for _, i := range images {
+		if err := store.TrackImage(t.Context(), i.image, i.expires, i.size, i.digest,
+			redisclient.ImageMeta{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := New(store, "http://registry.invalid", slog.Default())
+
+	got, err := r.LargestImages(t.Context(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(got))
+	for i, g := range got {
+		names[i] = g.Image
+	}
+	if want := []string{"db:6h", "app:1h", "tiny:1h"}; !slices.Equal(names, want) {
+		t.Fatalf("images = %v, want %v", names, want)
+	}
+	app := got[1]
+	if !slices.Equal(app.Tags, []string{"1h", "latest"}) || !app.ExpiresAt.Equal(now.Add(24*time.Hour)) {
+		t.Errorf("app = %+v, want both tags, expiring with latest", app)
+	}
+
+	if got, err := r.LargestImages(t.Context(), 1); err != nil || len(got) != 1 || got[0].Image != "db:6h" {
+		t.Errorf("LargestImages(1) = %+v, %v; want db:6h", got, err)
+	}
+}
+
+func TestTopImagesHandler(t *testing.T) {
+	store := memstore.New()
+	for image, size := range map[string]int64{"a:1h": 1, "b:1h": 3, "c:1h": 2} {
+		if err := store.TrackImage(t.Context(), image, time.Now().Add(time.Hour), size, "",
+			redisclient.ImageMeta{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := New(store, "http://registry.invalid", slog.Default()).TopImagesHandler()
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/api/images/top?by=size&limit=2", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rr.Code)
+	}
+	var got []LargeImage
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Image != "b:1h" || got[1].Image != "c:1h" {
+		t.Errorf("images = %+v, want b:1h and c:1h", got)
+	}
+
+	for _, query := range []string{"?by=age", "?limit=0", "?limit=1001"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/api/images/top"+query, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package reaper
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+)
+
+// defaultLockTTL is how long the reaper lock survives without a heartbeat.
+// It bounds how long a crashed replica can block reaping.
+const defaultLockTTL = 30 * time.Second
+
+// holdLock acquires the reaper lock and renews it in the background until
+// release is called. The returned context is cancelled if the lock is lost,
+// e.g. because a heartbeat was missed and another replica took over.
+// ok is false if another replica currently holds the lock.
+func (r *Reaper) holdLock(ctx context.Context) (lockCtx context.Context, release func(), ok bool, err error) {
+	token, err := r.redis.AcquireReaperLock(ctx, r.lockTTL)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("acquiring reaper lock: %w", err)
+	}
+	if token == 0 {
+		metrics.ReaperLockContention.Inc()
+		return nil, nil, false, nil
+	}
+
+	lockCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.heartbeat(lockCtx, cancel, token)
+	}()
+
+	release = func() {
+		cancel()
+		<-done
+		// The cycle context may already be cancelled; release regardless.
+		_ = r.redis.ReleaseReaperLock(context.WithoutCancel(ctx), token)
+	}
+	return lockCtx, release, true, nil
+}
+
+// heartbeat renews the lock every third of its TTL and cancels the cycle
+// when renewal fails.
+func (r *Reaper) heartbeat(ctx context.Context, cancel context.CancelFunc, token int64) {
+	ticker := time.NewTicker(r.lockTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			renewed, err := r.redis.RenewReaperLock(ctx, token, r.lockTTL)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				// A transient error is tolerated; the TTL still covers
+				// the next two attempts.
+				r.logger.Warn("failed to renew reaper lock", "error", err)
+				continue
+			}
+			if !renewed {
+				r.logger.Error("reaper lock lost, aborting cycle", "token", token)
+				metrics.ReaperLockLost.Inc()
+				cancel()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+)
+
+// lostLockStore simulates a replica whose lock was taken over.
+type lostLockStore struct {
+	*memstore.Store
+}
+
+func (s lostLockStore) RenewReaperLock(context.Context, int64, time.Duration) (bool, error) {
+	return false, nil
+}
+
+func TestReap_SkipsWhenLockHeld(t *testing.T) {
+	store := memstore.New()
+	track(t, store, "img:1h", time.Now().Add(-time.Minute))
+	if token, _ := store.AcquireReaperLock(t.Context(), time.Minute); token == 0 {
+		t.Fatal("expected to acquire lock")
+	}
+
+	r := New(store, "http://unused", slog.Default())
+	res, err := r.Reap(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !res.Skipped {
+		t.Error("expected cycle to be skipped")
+	}
+	if !tracked(store, "img:1h") {
+		t.Error("expected image to remain tracked")
+	}
+}
+
+func TestReap_HeartbeatKeepsLock(t *testing.T) {
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(60 * time.Millisecond)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer reg.Close()
+
+	store := memstore.New()
+	track(t, store, "img:1h", time.Now().Add(-time.Minute))
+
+	r := New(store, reg.URL, slog.Default(), WithLockTTL(30*time.Millisecond))
+	res, err := r.Reap(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Reaped != 1 {
+		t.Errorf("expected 1 reaped image, got %d", res.Reaped)
+	}
+
+	if token, _ := store.AcquireReaperLock(t.Context(), time.Minute); token == 0 {
+		t.Error("expected lock to be released after the cycle")
+	}
+}
+
+func TestReap_AbortsWhenLockLost(t *testing.T) {
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer reg.Close()
+
+	store := lostLockStore{memstore.New()}
+	track(t, store.Store, "img:1h", time.Now().Add(-time.Minute))
+	track(t, store.Store, "img:2h", time.Now().Add(-time.Minute))
+
+	r := New(store, reg.URL, slog.Default(), WithLockTTL(30*time.Millisecond))
+	_, err := r.Reap(t.Context())
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled after losing the lock, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package reaper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// Overwrite policies decide what happens to an expired tag whose manifest
+// in the registry is no longer the one recorded at push, because the tag
+// was overwritten without the webhook tracking it.
+const (
+	// OverwriteDelete deletes the tag as usual, by the digest strategy.
+	OverwriteDelete = "delete"
+	// OverwriteSkip leaves the new manifest alone and stops tracking the tag.
+	OverwriteSkip = "skip"
+	// OverwriteRetrack tracks the new manifest in place of the old one,
+	// with a fresh TTL of the length the tag was tracked with, within the
+	// limits a push of it would get.
+	OverwriteRetrack = "retrack"
+)
+
+// OverwritePolicies lists the valid overwrite policies.
+var OverwritePolicies = []string{OverwriteDelete, OverwriteSkip, OverwriteRetrack}
+
+// WithOverwritePolicy sets what happens to expired tags overwritten since
+// they were tracked, OverwriteDelete by default. Any other policy resolves
+// every expired tag with a HEAD request before deleting it. defaultTTL is
+// the TTL of images retracked without a known TTL, and maxTTL, if not nil,
+// returns the longest TTL a push of the manifest may get, as the webhook
+// handler limits it.
+func WithOverwritePolicy(
+	policy string,
+	defaultTTL time.Duration,
+	maxTTL func(repo, artifactType string) time.Duration,
+) Option {
+	return func(r *Reaper) {
+		r.overwritePolicy = policy
+		r.overwriteTTL = defaultTTL
+		r.overwriteMaxTTL = maxTTL
+	}
+}
+
+// overwrittenDigests returns the digest recorded for the expired image and
+// the one its tag points at now, if the overwrite policy applies to it
+// because the tag was overwritten since it was tracked. Otherwise reported
+// is empty. It changes nothing.
+func (r *Reaper) overwrittenDigests(ctx context.Context, image string) (pushed, reported string, err error) {
+	if r.overwritePolicy == OverwriteDelete || r.overwritePolicy == "" {
+		return "", "", nil
+	}
+	pushed, err = r.redis.GetImageDigest(ctx, image)
+	if err != nil || pushed == "" {
+		return "", "", err
+	}
+	repo, tag, ok := strings.Cut(image, ":")
+	if !ok {
+		return "", "", nil
+	}
+	reported, found, err := r.manifestDigest(ctx, repo, tag)
+	if err != nil {
+		return "", "", fmt.Errorf("resolving %s: %w", image, err)
+	}
+	if !found || reported == "" || reported == pushed {
+		return "", "", nil
+	}
+	// A multi-arch image may resolve to one of the platforms it indexes.
+	if isIndex, err := r.indexOf(ctx, repo, pushed, reported); err != nil || isIndex {
+		return "", "", err
+	}
+	return pushed, reported, nil
+}
+
+// overwritten applies the overwrite policy to the expired image and
+// reports whether it did, in which case the image must not be deleted.
+func (r *Reaper) overwritten(ctx context.Context, image string) (bool, error) {
+	pushed, reported, err := r.overwrittenDigests(ctx, image)
+	if err != nil || reported == "" {
+		return false, err
+	}
+	repo, tag, _ := strings.Cut(image, ":")
+
+	metrics.ReaperTagOverwrites.WithLabelValues(r.overwritePolicy).Inc()
+	if r.overwritePolicy == OverwriteSkip {
+		r.logger.Warn("expired tag was overwritten since it was tracked, keeping it untracked",
+			"image", image, "pushed", pushed, "reported", reported)
+		return true, r.redis.RemoveImage(ctx, image)
+	}
+	return true, r.retrack(ctx, image, repo, tag)
+}
+
+// retrack tracks the manifest repo:tag now points at as image, anew and in
+// one step, for the TTL the image was tracked with. As a fresh record it
+// starts a new creation time, so retracking again keeps the TTL instead of
+// adding up the time the previous manifest was tracked.
+func (r *Reaper) retrack(ctx context.Context, image, repo, tag string) error {
+	start := time.Now()
+	info, err := r.registry.GetImageManifestInfo(ctx, repo, tag)
+	r.observe(start)
+	if err != nil {
+		return fmt.Errorf("fetching manifest of %s: %w", image, err)
+	}
+
+	ttl := r.overwriteTTL
+	expiresAt, err := r.redis.GetExpiry(ctx, image)
+	if err != nil {
+		return fmt.Errorf("getting expiry: %w", err)
+	}
+	if created, err := r.redis.GetCreatedTimestamp(ctx, image); err == nil && created > 0 && expiresAt > created {
+		ttl = time.Duration(expiresAt-created) * time.Millisecond
+	}
+	if r.overwriteMaxTTL != nil {
+		if maxTTL := r.overwriteMaxTTL(repo, info.ArtifactType); maxTTL > 0 {
+			ttl = min(ttl, maxTTL)
+		}
+	}
+
+	// Who pushed the new manifest is unknown, so the record starts without
+	// pusher metadata, as does one recovered from the registry.
+	err = r.redis.TrackImage(ctx, image, time.Now().Add(ttl), info.SizeBytes, info.Digest, redisclient.ImageMeta{})
+	if err != nil {
+		return fmt.Errorf("updating %s: %w", image, err)
+	}
+	r.logger.Warn("expired tag was overwritten since it was tracked, tracking the new manifest",
+		"image", image, "digest", info.Digest, "size_bytes", info.SizeBytes, "ttl", ttl.String())
+	return nil
+}
@@ -0,0 +1,156 @@
+package reaper
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// overwriteRegistry serves myimage:1h as sha256:new, overwriting the
+// sha256:pushed manifest it was tracked with, and records DELETEs.
+func overwriteRegistry(t *testing.T, deleted *[]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodDelete:
+			*deleted = append(*deleted, r.URL.Path)
+			w.WriteHeader(http.StatusAccepted)
+		case r.URL.Path == "/v2/myimage/manifests/1h":
+			w.Header().Set("Docker-Content-Digest", "sha256:new")
+			_, _ = w.Write([]byte(sizedManifest))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestReap_OverwritePolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		deleted int
+		tracked bool
+	}{
+		{OverwriteDelete, 1, false},
+		{OverwriteSkip, 0, false},
+		{OverwriteRetrack, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			var deleted []string
+			srv := overwriteRegistry(t, &deleted)
+			store := memstore.New()
+			if err := store.TrackImage(t.Context(), "myimage:1h", time.Now().Add(-time.Minute), 5, "sha256:pushed",
+				redisclient.ImageMeta{}); err != nil {
+				t.Fatal(err)
+			}
+
+			r := New(store, srv.URL, slog.New(slog.DiscardHandler), WithOverwritePolicy(tt.policy, 3*time.Hour, nil))
+			res, err := r.Reap(t.Context())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(deleted) != tt.deleted {
+				t.Errorf("deleted %v, want %d manifests", deleted, tt.deleted)
+			}
+			if tracked(store, "myimage:1h") != tt.tracked {
+				t.Errorf("tracked = %v, want %v", !tt.tracked, tt.tracked)
+			}
+			if want := 1 - tt.deleted; res.Overwritten != want || res.Attempted != tt.deleted || res.Failed != 0 {
+				t.Errorf("result = %+v, want %d overwritten, %d attempted and none failed", res, want, tt.deleted)
+			}
+			if !tt.tracked {
+				return
+			}
+			if digest, _ := store.GetImageDigest(t.Context(), "myimage:1h"); digest != "sha256:new" {
+				t.Errorf("digest = %q, want sha256:new", digest)
+			}
+			if size, _ := store.GetImageSize(t.Context(), "myimage:1h"); size != 1000 {
+				t.Errorf("size = %d, want 1000", size)
+			}
+			expiresAt, _ := store.GetExpiry(t.Context(), "myimage:1h")
+			if remaining := time.Until(time.UnixMilli(expiresAt)); remaining < 2*time.Hour || remaining > 3*time.Hour {
+				t.Errorf("expires in %v, want the default TTL of 3h", remaining)
+			}
+		})
+	}
+}
+
+func TestOverwritten_KeepsTrackedTTL(t *testing.T) {
+	var deleted []string
+	srv := overwriteRegistry(t, &deleted)
+	store := memstore.New()
+	if err := store.TrackImage(t.Context(), "myimage:1h", time.Now().Add(time.Hour), 5, "sha256:pushed",
+		redisclient.ImageMeta{}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(store, srv.URL, slog.New(slog.DiscardHandler), WithOverwritePolicy(OverwriteRetrack, 3*time.Hour, nil))
+	start := time.Now()
+	overwritten, err := r.overwritten(t.Context(), "myimage:1h")
+	if err != nil || !overwritten {
+		t.Fatalf("overwritten = %v, %v; want true, nil", overwritten, err)
+	}
+	expiresAt, _ := store.GetExpiry(t.Context(), "myimage:1h")
+	if got := time.UnixMilli(expiresAt); got.Before(start.Add(59*time.Minute)) || got.After(time.Now().Add(time.Hour)) {
+		t.Errorf("expires at %v, want an hour from now, the TTL it was tracked with", got)
+	}
+}
+
+func TestOverwritten_RetrackWithinPushLimits(t *testing.T) {
+	var deleted []string
+	srv := overwriteRegistry(t, &deleted)
+	store := memstore.New()
+	if err := store.TrackImage(t.Context(), "myimage:1h", time.Now().Add(time.Hour), 5, "sha256:pushed",
+		redisclient.ImageMeta{}); err != nil {
+		t.Fatal(err)
+	}
+
+	maxTTL := func(repo, _ string) time.Duration {
+		if repo == "myimage" {
+			return 30 * time.Minute
+		}
+		return 0
+	}
+	r := New(store, srv.URL, slog.New(slog.DiscardHandler), WithOverwritePolicy(OverwriteRetrack, 3*time.Hour, maxTTL))
+	start := time.Now()
+	if overwritten, err := r.overwritten(t.Context(), "myimage:1h"); err != nil || !overwritten {
+		t.Fatalf("overwritten = %v, %v; want true, nil", overwritten, err)
+	}
+	expiresAt, _ := store.GetExpiry(t.Context(), "myimage:1h")
+	if got := time.UnixMilli(expiresAt); got.After(time.Now().Add(30 * time.Minute)) {
+		t.Errorf("expires at %v, want at most the repository's max TTL of 30m from now", got)
+	}
+	// The new record starts anew, so the next retrack does not count the
+	// time the previous manifest was tracked towards its TTL.
+	if created, _ := store.GetCreatedTimestamp(t.Context(), "myimage:1h"); created < start.UnixMilli() {
+		t.Errorf("created = %v, want the time of the retrack", time.UnixMilli(created))
+	}
+}
+
+func TestReap_AllOverwritten_NoHealthReport(t *testing.T) {
+	var deleted []string
+	srv := overwriteRegistry(t, &deleted)
+	store := memstore.New()
+	if err := store.TrackImage(t.Context(), "myimage:1h", time.Now().Add(-time.Minute), 5, "sha256:pushed",
+		redisclient.ImageMeta{}); err != nil {
+		t.Fatal(err)
+	}
+
+	hr := &mockHealthReporter{}
+	r := New(store, srv.URL, slog.New(slog.DiscardHandler), WithOverwritePolicy(OverwriteSkip, 3*time.Hour, nil),
+		WithHealthReporter(hr))
+	if err := r.ReapOnce(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if hr.successes != 0 || hr.failures != 0 {
+		t.Errorf("got %d successes, %d failures; a cycle with only overwritten images should not report health",
+			hr.successes, hr.failures)
+	}
+}
@@ -0,0 +1,83 @@
+package reaper
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+)
+
+// pacerWindow is the number of recent registry calls considered when
+// computing the p95 latency.
+const pacerWindow = 20
+
+// pacer tracks registry response latency during a reap cycle and slows
+// deletions down while the registry is under load.
+type pacer struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	delay     time.Duration
+	samples   []time.Duration
+}
+
+func newPacer(threshold, delay time.Duration) *pacer {
+	return &pacer{
+		threshold: threshold,
+		delay:     delay,
+	}
+}
+
+// observe records the latency of a single registry call.
+func (p *pacer) observe(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.samples = append(p.samples, d)
+	if len(p.samples) > pacerWindow {
+		p.samples = p.samples[len(p.samples)-pacerWindow:]
+	}
+}
+
+// reset clears recorded samples so each cycle starts without history.
+func (p *pacer) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.samples = nil
+}
+
+// p95 returns the 95th percentile of the recorded latencies.
+func (p *pacer) p95() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.samples) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(p.samples))
+	copy(sorted, p.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := (len(sorted)*95+99)/100 - 1
+	return sorted[idx]
+}
+
+// overloaded reports whether the p95 latency has crossed the threshold.
+func (p *pacer) overloaded() bool {
+	return p.p95() > p.threshold
+}
+
+// wait blocks for the pacing delay while the registry is overloaded.
+// It returns early with the context error if ctx is cancelled.
+func (p *pacer) wait(ctx context.Context) error {
+	if !p.overloaded() {
+		return nil
+	}
+	metrics.ReaperPacingPauses.Inc()
+	timer := time.NewTimer(p.delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
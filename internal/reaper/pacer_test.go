@@ -0,0 +1,82 @@
+package reaper
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+)
+
+func TestPacer_P95(t *testing.T) {
+	p := newPacer(100*time.Millisecond, time.Millisecond)
+	if p.overloaded() {
+		t.Fatal("expected empty pacer not to be overloaded")
+	}
+
+	for range 19 {
+		p.observe(10 * time.Millisecond)
+	}
+	p.observe(time.Second)
+	if got := p.p95(); got != 10*time.Millisecond {
+		t.Errorf("expected p95 10ms with a single outlier, got %s", got)
+	}
+
+	for range 5 {
+		p.observe(time.Second)
+	}
+	if !p.overloaded() {
+		t.Errorf("expected pacer to be overloaded, p95=%s", p.p95())
+	}
+
+	p.reset()
+	if p.overloaded() {
+		t.Error("expected reset pacer not to be overloaded")
+	}
+}
+
+func TestPacer_WaitRespectsContext(t *testing.T) {
+	p := newPacer(time.Millisecond, time.Hour)
+	p.observe(time.Second)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if err := p.wait(ctx); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestReapOnce_AdaptivePacing(t *testing.T) {
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		switch r.Method {
+		case http.MethodHead:
+			w.Header().Set("Docker-Content-Digest", "sha256:abc123")
+			w.WriteHeader(http.StatusOK)
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer reg.Close()
+
+	store := memstore.New()
+	track(t, store, "img1:1h", time.Now().Add(-time.Minute))
+	track(t, store, "img2:1h", time.Now().Add(-time.Minute))
+
+	delay := 50 * time.Millisecond
+	r := New(store, reg.URL, slog.Default(), WithAdaptivePacing(time.Millisecond, delay))
+
+	start := time.Now()
+	if err := r.ReapOnce(t.Context()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Errorf("expected reaper to pause for at least %s, took %s", delay, elapsed)
+	}
+	if imageCount(store) != 0 {
+		t.Errorf("expected all images to be reaped, got %d remaining", imageCount(store))
+	}
+}
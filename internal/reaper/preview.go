@@ -0,0 +1,175 @@
+package reaper
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// PreviewImage is an image the next reap cycle would delete.
+type PreviewImage struct {
+	Image     string    `json:"image"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Reason is why it would be deleted: ReasonExpired, or ReasonApproved
+	// for a protected image whose deletion was approved.
+	Reason string `json:"reason"`
+	// SizeBytes is the storage its deletion would reclaim, zero while
+	// another tracked tag still shares the manifest.
+	SizeBytes int64 `json:"size_bytes"`
+}
+
+// Preview is the outcome of a simulated reap cycle.
+type Preview struct {
+	// Images are in the order the cycle would delete them.
+	Images         []PreviewImage `json:"images"`
+	ReclaimedBytes int64          `json:"reclaimed_bytes"`
+	// Superseded are the superseded cache manifests the cycle would delete
+	// by digest.
+	Superseded []redisclient.SupersededManifest `json:"superseded"`
+	// Quarantined, Protected, Frozen and Deferred count expired images the
+	// cycle would skip.
+	Quarantined int `json:"quarantined"`
+	Protected   int `json:"protected"`
+	Frozen      int `json:"frozen"`
+	Deferred    int `json:"deferred"`
+	// Overwritten counts expired tags the overwrite policy would handle
+	// instead of deleting them.
+	Overwritten int `json:"overwritten"`
+	// Postponed counts expired images beyond the cycle's deletion budget.
+	Postponed int `json:"postponed"`
+}
+
+// Preview reports what a reap cycle starting now would delete and how many
+// bytes it would reclaim, without changing anything. It selects images with
+// the same code as Reap: approved deletions, then expired images by
+// priority up to the deletion budget, less those the overwrite policy would
+// keep, then superseded manifests. A time budget cannot be previewed. A
+// positive maxTTL additionally expires images tracked longer than maxTTL
+// ago, to preview the effect of lowering MAX_TTL.
+func (r *Reaper) Preview(ctx context.Context, maxTTL time.Duration) (Preview, error) {
+	p := Preview{Images: []PreviewImage{}, Superseded: []redisclient.SupersededManifest{}}
+	images, err := r.redis.ListImages(ctx)
+	if err != nil {
+		return p, err
+	}
+	quarantined, err := r.quarantined(ctx)
+	if err != nil {
+		return p, err
+	}
+	freezes, err := r.freezes(ctx)
+	if err != nil {
+		return p, err
+	}
+	c := cycle{now: time.Now().UnixMilli(), quarantined: quarantined, freezes: freezes, maxTTL: maxTTL, dryRun: true}
+
+	gone := make(map[string]struct{})
+	add := func(image, reason string, expiresAt int64) error {
+		size, err := r.redis.GetImageSize(ctx, image)
+		if err != nil {
+			return err
+		}
+		// Like remove, only the last tag of a shared manifest frees it.
+		aliases, err := r.redis.Aliases(ctx, image)
+		if err != nil {
+			return err
+		}
+		for _, alias := range aliases {
+			if _, ok := gone[alias]; !ok {
+				size = 0
+				break
+			}
+		}
+		gone[image] = struct{}{}
+		p.Images = append(p.Images, PreviewImage{
+			Image: image, ExpiresAt: time.UnixMilli(expiresAt), Reason: reason, SizeBytes: size,
+		})
+		p.ReclaimedBytes += size
+		return nil
+	}
+
+	for _, d := range r.approvedDeletions(ctx, freezes) {
+		expiresAt, err := r.redis.GetExpiry(ctx, d.Image)
+		if err != nil {
+			continue
+		}
+		if err := add(d.Image, ReasonApproved, expiresAt); err != nil {
+			return p, err
+		}
+	}
+
+	// The cycle lists images after executing approved deletions.
+	images = slices.DeleteFunc(images, func(image string) bool {
+		_, ok := gone[image]
+		return ok
+	})
+	var res Result
+	expired, err := r.dueImages(ctx, images, c, &res)
+	if err != nil {
+		return p, err
+	}
+	p.Quarantined, p.Protected, p.Frozen, p.Deferred = res.Quarantined, res.Protected, res.Frozen, res.Deferred
+	start := time.Now()
+	for i, e := range expired {
+		if r.budgetSpent(res.Attempted, start) != "" {
+			p.Postponed = len(expired) - i
+			break
+		}
+		_, reported, err := r.overwrittenDigests(ctx, e.image)
+		if err == nil && reported != "" {
+			p.Overwritten++
+			continue
+		}
+		res.Attempted++
+		if err != nil {
+			// The cycle would count a failed deletion and retry.
+			continue
+		}
+		if err := add(e.image, ReasonExpired, e.expiresAt); err != nil {
+			return p, err
+		}
+	}
+
+	superseded, err := r.dueSuperseded(ctx, c)
+	if err != nil {
+		return p, err
+	}
+	known := make(tagDigests)
+	for i, m := range superseded {
+		if r.budgetSpent(res.Attempted+i, start) != "" {
+			p.Postponed += len(superseded) - i
+			break
+		}
+		if tagged, err := r.tagged(ctx, known, m.Repository, m.Digest); err == nil && !tagged {
+			p.Superseded = append(p.Superseded, m)
+		}
+	}
+	return p, nil
+}
+
+// PreviewHandler serves Preview as JSON. The optional max_ttl query
+// parameter previews a lower MAX_TTL.
+func (r *Reaper) PreviewHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var maxTTL time.Duration
+		if v := req.URL.Query().Get("max_ttl"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				http.Error(w, "invalid max_ttl", http.StatusBadRequest)
+				return
+			}
+			maxTTL = d
+		}
+		p, err := r.Preview(req.Context(), maxTTL)
+		if err != nil {
+			r.logger.Error("failed to preview reap cycle", "error", err)
+			http.Error(w, "preview unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(p)
+	})
+}
@@ -0,0 +1,138 @@
+package reaper
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+func TestPreviewHandler(t *testing.T) {
+	store := memstore.New()
+	expired := time.Now().Add(-time.Minute)
+	for _, img := range []struct {
+		image   string
+		expires time.Time
+		size    int64
+		digest  string
+	}{
+		{"app:1h", expired, 100, "sha256:abc"},
+		{"app:2h", expired, 100, "sha256:abc"},
+		{"other:1h", expired, 50, "sha256:def"},
+		{"other:latest", time.Now().Add(time.Hour), 50, "sha256:def"},
+		{"pinned:1h", expired, 10, ""},
+		{"fresh:24h", time.Now().Add(24 * time.Hour), 30, ""},
+	} {
+		if err := store.TrackImage(t.Context(), img.image, img.expires, img.size, img.digest,
+			redisclient.ImageMeta{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.SetProtected(t.Context(), "pinned:1h", true); err != nil {
+		t.Fatal(err)
+	}
+	r := New(store, "http://registry.invalid", slog.Default())
+
+	preview := func(query string) (Preview, int) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		r.PreviewHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/api/reap/preview"+query, nil))
+		var p Preview
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return p, rr.Code
+	}
+	names := func(p Preview) []string {
+		var images []string
+		for _, img := range p.Images {
+			images = append(images, img.Image)
+		}
+		sort.Strings(images)
+		return images
+	}
+
+	p, code := preview("")
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if want := []string{"app:1h", "app:2h", "other:1h"}; !slices.Equal(names(p), want) {
+		t.Errorf("images = %v, want %v", names(p), want)
+	}
+	if p.ReclaimedBytes != 100 || p.Protected != 1 {
+		t.Errorf("reclaimed = %d, protected = %d; want 100 and 1", p.ReclaimedBytes, p.Protected)
+	}
+	for _, image := range []string{"app:1h", "app:2h", "other:1h", "pinned:1h"} {
+		if !tracked(store, image) {
+			t.Errorf("preview removed %s", image)
+		}
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	p, _ = preview("?max_ttl=1ms")
+	if want := []string{"app:1h", "app:2h", "fresh:24h", "other:1h", "other:latest"}; !slices.Equal(names(p), want) {
+		t.Errorf("images with max_ttl = %v, want %v", names(p), want)
+	}
+	if p.ReclaimedBytes != 180 {
+		t.Errorf("reclaimed with max_ttl = %d, want 180", p.ReclaimedBytes)
+	}
+
+	if _, code := preview("?max_ttl=soon"); code != http.StatusBadRequest {
+		t.Errorf("invalid max_ttl: expected 400, got %d", code)
+	}
+}
+
+func TestPreview_MatchesReapSelection(t *testing.T) {
+	store := memstore.New()
+	expired := time.Now().Add(-time.Minute)
+	for _, image := range []string{"low:1h", "high:1h", "mid:1h", "busy:1h", "pinned:stable"} {
+		track(t, store, image, expired)
+	}
+	for image, p := range map[string]int{"high:1h": 10, "mid:1h": 5} {
+		if err := store.SetPriority(t.Context(), image, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := New(store, "http://registry.invalid", slog.Default(),
+		WithCycleBudget(2, 0),
+		WithProtection(protectionFunc(func(image string) bool { return image == "pinned:stable" })),
+		WithWorkloadReferences(workloadsFunc(func(image, _ string) (bool, error) { return image == "busy:1h", nil })),
+	)
+	d, err := r.RequestDeletion(t.Context(), "pinned:stable", "", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ApproveDeletion(t.Context(), d.ID, "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.Preview(t.Context(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, img := range p.Images {
+		got = append(got, img.Image+"/"+img.Reason)
+	}
+	want := []string{"pinned:stable/" + ReasonApproved, "high:1h/" + ReasonExpired, "mid:1h/" + ReasonExpired}
+	if !slices.Equal(got, want) {
+		t.Errorf("images = %v, want %v", got, want)
+	}
+	if p.Deferred != 1 || p.Postponed != 1 || p.Protected != 0 {
+		t.Errorf("preview = %+v, want 1 deferred, 1 postponed and none protected", p)
+	}
+	for _, image := range []string{"low:1h", "high:1h", "pinned:stable"} {
+		if !tracked(store, image) {
+			t.Errorf("preview removed %s", image)
+		}
+	}
+}
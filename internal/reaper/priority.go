@@ -0,0 +1,56 @@
+package reaper
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Reasons a cycle stops deleting before its backlog is done.
+const (
+	budgetDeletes  = "deletes"
+	budgetDuration = "duration"
+)
+
+// expiredImage is an image a reap cycle is about to delete.
+type expiredImage struct {
+	image     string
+	expiresAt int64
+	priority  int
+}
+
+// sortExpired orders images for deletion: higher priorities first, and
+// within a priority the longest expired first.
+func sortExpired(images []expiredImage) {
+	sort.SliceStable(images, func(i, j int) bool {
+		if images[i].priority != images[j].priority {
+			return images[i].priority > images[j].priority
+		}
+		return images[i].expiresAt < images[j].expiresAt
+	})
+}
+
+// priority returns the deletion priority a push policy assigned to image.
+// An unreadable priority counts as zero.
+func (r *Reaper) priority(ctx context.Context, image string) int {
+	p, err := r.redis.GetPriority(ctx, image)
+	if err != nil {
+		r.logger.Warn("failed to get deletion priority", "image", image, "error", err)
+		return 0
+	}
+	return p
+}
+
+// budgetSpent returns why a cycle that started at start and attempted that
+// many deletions must not start another one, or "" if it may. Every cycle
+// gets to attempt one deletion, so a slow filter pass cannot stall the
+// backlog.
+func (r *Reaper) budgetSpent(attempted int, start time.Time) string {
+	switch {
+	case r.maxDeletes > 0 && attempted >= r.maxDeletes:
+		return budgetDeletes
+	case r.maxDuration > 0 && attempted > 0 && time.Since(start) >= r.maxDuration:
+		return budgetDuration
+	}
+	return ""
+}
@@ -0,0 +1,116 @@
+package reaper
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	"github.com/tamcore/ephemeron/internal/metrics"
+)
+
+func TestReap_Priority(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		deleted []string
+	)
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repo, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v2/"), "/")
+		switch r.Method {
+		case http.MethodHead:
+			w.Header().Set("Docker-Content-Digest", "sha256:"+repo)
+			w.WriteHeader(http.StatusOK)
+		case http.MethodDelete:
+			mu.Lock()
+			deleted = append(deleted, repo)
+			mu.Unlock()
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer registry.Close()
+
+	store := memstore.New()
+	now := time.Now()
+	track(t, store, "low:1h", now.Add(-4*time.Hour))
+	track(t, store, "recent:1h", now.Add(-time.Hour))
+	track(t, store, "older:1h", now.Add(-3*time.Hour))
+	track(t, store, "huge:1h", now.Add(-time.Minute))
+	track(t, store, "pending:1h", now.Add(time.Hour))
+	priorities := map[string]int{"low:1h": -1, "huge:1h": 10, "pending:1h": 20}
+	for image, p := range priorities {
+		if err := store.SetPriority(t.Context(), image, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := New(store, registry.URL, slog.Default(), WithCycleBudget(3, 0))
+	res, err := r.Reap(t.Context())
+	if err != nil {
+		t.Fatalf("Reap: %v", err)
+	}
+
+	if want := []string{"huge", "older", "recent"}; !slices.Equal(deleted, want) {
+		t.Errorf("deleted %v, want %v", deleted, want)
+	}
+	if res.Reaped != 3 || res.Postponed != 1 {
+		t.Errorf("result = %+v, want 3 reaped and 1 postponed", res)
+	}
+	if !tracked(store, "low:1h") {
+		t.Error("expected the lowest priority image to wait for the next cycle")
+	}
+
+	res, err = r.Reap(t.Context())
+	if err != nil {
+		t.Fatalf("Reap: %v", err)
+	}
+	if res.Reaped != 1 || res.Postponed != 0 || tracked(store, "low:1h") {
+		t.Errorf("second cycle result = %+v, want the postponed image reaped", res)
+	}
+}
+
+func TestReap_DurationBudget(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodHead:
+			w.Header().Set("Docker-Content-Digest", "sha256:abc")
+			w.WriteHeader(http.StatusOK)
+		case http.MethodDelete:
+			time.Sleep(50 * time.Millisecond)
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer registry.Close()
+
+	store := memstore.New()
+	for _, image := range []string{"a:1h", "b:1h", "c:1h"} {
+		track(t, store, image, time.Now().Add(-time.Hour))
+	}
+	truncated := metrics.ReaperCyclesTruncated.WithLabelValues(budgetDuration)
+	before := testutil.ToFloat64(truncated)
+
+	r := New(store, registry.URL, slog.Default(), WithCycleBudget(0, 10*time.Millisecond))
+	res, err := r.Reap(t.Context())
+	if err != nil {
+		t.Fatalf("Reap: %v", err)
+	}
+
+	if res.Attempted != 1 || res.Postponed != 2 {
+		t.Errorf("result = %+v, want 1 attempted and 2 postponed", res)
+	}
+	if got := imageCount(store); got != 2 {
+		t.Errorf("tracked images = %d, want 2", got)
+	}
+	if got := testutil.ToFloat64(metrics.ReaperBacklog); got != 2 {
+		t.Errorf("backlog gauge = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(truncated); got != before+1 {
+		t.Errorf("truncated cycles = %v, want %v", got, before+1)
+	}
+}
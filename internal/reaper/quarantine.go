@@ -0,0 +1,117 @@
+package reaper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+// WithQuarantine moves an image out of normal reaping once its deletion has
+// been refused maxFailures times in a row, so a manifest the registry will
+// never delete does not produce the same error every cycle. Quarantined
+// images are listed and released through QuarantineHandler. Zero disables
+// quarantine.
+func WithQuarantine(maxFailures int) Option {
+	return func(r *Reaper) {
+		r.quarantineAfter = int64(maxFailures)
+	}
+}
+
+// quarantined returns the set of images currently excluded from reaping and
+// updates the quarantine gauge.
+func (r *Reaper) quarantined(ctx context.Context) (map[string]struct{}, error) {
+	if r.quarantineAfter <= 0 {
+		return nil, nil
+	}
+	list, err := r.redis.ListQuarantined(ctx)
+	if err != nil {
+		return nil, err
+	}
+	metrics.QuarantinedImages.Set(float64(len(list)))
+	set := make(map[string]struct{}, len(list))
+	for _, q := range list {
+		set[q.Image] = struct{}{}
+	}
+	return set, nil
+}
+
+// recordFailure counts a failed deletion against image and quarantines it
+// once the threshold is reached. Transient failures such as network errors,
+// timeouts and 5xx responses are not counted; only refusals the registry
+// would repeat are.
+func (r *Reaper) recordFailure(ctx context.Context, image string, cause error) {
+	if r.quarantineAfter <= 0 {
+		return
+	}
+	var statusErr *registry.StatusError
+	if !errors.As(cause, &statusErr) || !statusErr.Permanent() {
+		return
+	}
+	failures, err := r.redis.RecordDeleteFailure(ctx, image)
+	if err != nil {
+		r.logger.Warn("failed to record delete failure", "image", image, "error", err)
+		return
+	}
+	if failures < r.quarantineAfter {
+		return
+	}
+	q := redisclient.QuarantinedImage{
+		Image:    image,
+		Reason:   cause.Error(),
+		Failures: failures,
+		Since:    time.Now().UTC(),
+	}
+	if err := r.redis.QuarantineImage(ctx, q); err != nil {
+		r.logger.Warn("failed to quarantine image", "image", image, "error", err)
+		return
+	}
+	metrics.ImagesQuarantined.Inc()
+	r.logger.Warn("quarantined image after repeated deletion failures",
+		"image", image,
+		"failures", failures,
+		"reason", q.Reason,
+	)
+}
+
+// QuarantineHandler serves the quarantine list on GET and releases an image
+// back into normal reaping on DELETE. The image is taken from the "image"
+// path value.
+func (r *Reaper) QuarantineHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			list, err := r.redis.ListQuarantined(req.Context())
+			if err != nil {
+				r.logger.Error("failed to list quarantined images", "error", err)
+				http.Error(w, "quarantine unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			sort.Slice(list, func(i, j int) bool { return list[i].Image < list[j].Image })
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(list)
+		case http.MethodDelete:
+			image := req.PathValue("image")
+			if image == "" {
+				http.Error(w, "missing image", http.StatusBadRequest)
+				return
+			}
+			if err := r.redis.ReleaseQuarantined(req.Context(), image); err != nil {
+				r.logger.Error("failed to release quarantined image", "image", image, "error", err)
+				http.Error(w, "release failed", http.StatusServiceUnavailable)
+				return
+			}
+			r.logger.Info("released image from quarantine", "image", image)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
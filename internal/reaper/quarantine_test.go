@@ -0,0 +1,113 @@
+package reaper
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+func TestReap_Quarantine(t *testing.T) {
+	tests := []struct {
+		name            string
+		deleteStatus    int
+		wantQuarantined bool
+	}{
+		{name: "refused deletion", deleteStatus: http.StatusMethodNotAllowed, wantQuarantined: true},
+		{name: "rate limited", deleteStatus: http.StatusTooManyRequests},
+		{name: "server error", deleteStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deletes atomic.Int32
+			registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodHead:
+					w.Header().Set("Docker-Content-Digest", "sha256:abc")
+					w.WriteHeader(http.StatusOK)
+				case http.MethodDelete:
+					deletes.Add(1)
+					w.WriteHeader(tt.deleteStatus)
+				}
+			}))
+			defer registry.Close()
+
+			store := memstore.New()
+			track(t, store, "app:1h", time.Now().Add(-time.Hour))
+			r := New(store, registry.URL, slog.Default(), WithQuarantine(2))
+
+			for range 3 {
+				if _, err := r.Reap(t.Context()); err != nil {
+					t.Fatalf("Reap: %v", err)
+				}
+			}
+			res, err := r.Reap(t.Context())
+			if err != nil {
+				t.Fatalf("Reap: %v", err)
+			}
+
+			list, _ := store.ListQuarantined(t.Context())
+			if got := len(list) == 1; got != tt.wantQuarantined {
+				t.Fatalf("quarantined = %v, want %v (%+v)", got, tt.wantQuarantined, list)
+			}
+			if !tt.wantQuarantined {
+				if deletes.Load() != 4 {
+					t.Errorf("deletes = %d, want 4", deletes.Load())
+				}
+				return
+			}
+			if deletes.Load() != 2 {
+				t.Errorf("deletes = %d, want 2 (no attempts after quarantine)", deletes.Load())
+			}
+			if res.Quarantined != 1 || res.Attempted != 0 {
+				t.Errorf("result = %+v, want 1 quarantined and 0 attempted", res)
+			}
+			if list[0].Image != "app:1h" || list[0].Failures != 2 || list[0].Reason != "DELETE manifest returned 405" {
+				t.Errorf("quarantine entry = %+v", list[0])
+			}
+			if !tracked(store, "app:1h") {
+				t.Error("quarantined image should stay tracked")
+			}
+		})
+	}
+}
+
+func TestQuarantineHandler(t *testing.T) {
+	store := memstore.New()
+	track(t, store, "app:1h", time.Now().Add(-time.Hour))
+	if err := store.QuarantineImage(t.Context(), redisclient.QuarantinedImage{
+		Image: "app:1h", Reason: "DELETE manifest returned 405", Failures: 3,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	r := New(store, "http://registry.invalid", slog.Default(), WithQuarantine(3))
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /v1/api/quarantine", r.QuarantineHandler())
+	mux.Handle("DELETE /v1/api/quarantine/{image...}", r.QuarantineHandler())
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/api/quarantine", nil))
+	var list []redisclient.QuarantinedImage
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatalf("decoding list: %v", err)
+	}
+	if len(list) != 1 || list[0].Image != "app:1h" {
+		t.Fatalf("list = %+v, want app:1h", list)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/v1/api/quarantine/app:1h", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if list, _ := store.ListQuarantined(t.Context()); len(list) != 0 {
+		t.Errorf("quarantine after release = %+v, want empty", list)
+	}
+}
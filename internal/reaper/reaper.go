@@ -2,14 +2,20 @@ package reaper
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
-	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/tamcore/ephemeron/internal/archive"
+	"github.com/tamcore/ephemeron/internal/deletehook"
+	"github.com/tamcore/ephemeron/internal/diskusage"
 	"github.com/tamcore/ephemeron/internal/metrics"
 	redisclient "github.com/tamcore/ephemeron/internal/redis"
+	"github.com/tamcore/ephemeron/internal/registry"
+	"github.com/tamcore/ephemeron/internal/schedule"
 )
 
 // HealthReporter is called by the reaper to report registry interaction outcomes.
@@ -20,11 +26,86 @@ type HealthReporter interface {
 
 // Reaper periodically checks for and deletes expired images.
 type Reaper struct {
-	redis       redisclient.Store
-	registryURL string
-	logger      *slog.Logger
-	httpClient  *http.Client
-	health      HealthReporter
+	redis    redisclient.Store
+	registry *registry.Client
+	logger   *slog.Logger
+	health   HealthReporter
+	pacer    *pacer
+	lockTTL  time.Duration
+
+	// quarantineAfter is the number of refused deletions after which an
+	// image is quarantined; see WithQuarantine.
+	quarantineAfter int64
+
+	// verifyDeletes re-checks each deleted manifest; see WithDeleteVerification.
+	verifyDeletes bool
+
+	// digestStrategy picks the digest to delete by; see WithDigestStrategy.
+	digestStrategy string
+
+	// overwritePolicy, overwriteTTL and overwriteMaxTTL handle expired tags
+	// overwritten since they were tracked; see WithOverwritePolicy.
+	overwritePolicy string
+	overwriteTTL    time.Duration
+	overwriteMaxTTL func(repo, artifactType string) time.Duration
+
+	// tombstoneRetention is how long deleted images are remembered; see
+	// WithTombstones.
+	tombstoneRetention time.Duration
+
+	// restore keeps deleted manifests in tombstones; see WithRestore.
+	restore bool
+
+	// archive keeps deleted content beyond garbage collection; see
+	// WithArchive.
+	archive archive.Archive
+
+	// dataPath and minFreeBytes configure the disk probe; see WithDiskProbe.
+	dataPath     string
+	minFreeBytes int64
+	statFS       func(path string) (diskusage.Usage, error)
+	// awaitingGC is the size of images evicted by the probe whose space has
+	// not shown up as free yet, and lastFree the free space it last saw.
+	// Both are only touched by RunLoop.
+	awaitingGC int64
+	lastFree   int64
+
+	// cleaner deletes repositories left empty by reaping; see WithRepoCleanup.
+	cleaner RepoCleaner
+	// busyRepos are emptied repositories the cleaner kept for recent
+	// activity, retried by later cycles. Only touched under the reaper lock.
+	busyRepos map[string]struct{}
+
+	// protection matches images protected by pattern; see WithProtection.
+	protection ProtectionRules
+
+	// workloads reports images still referenced in-cluster; see
+	// WithWorkloadReferences.
+	workloads WorkloadReferences
+
+	// preDelete and postDelete run around each deletion; see WithDeleteHooks.
+	preDelete, postDelete []DeleteHook
+
+	// maxDeletes and maxDuration bound a single cycle; see WithCycleBudget.
+	maxDeletes  int
+	maxDuration time.Duration
+
+	// storeHealth is the outcome of the last store health check.
+	storeHealth storeHealth
+
+	// running is set while this replica runs a reap cycle.
+	running atomic.Bool
+
+	// history holds recently completed reap cycles for reporting.
+	history *history
+
+	// sweepCursor is the position of the next ghost sweep batch. It is only
+	// touched by Sweep, which must not run concurrently with itself.
+	sweepCursor int
+	// sizeRepairAfter is the last image of the previous size repair batch.
+	// It is only touched by RepairSizes, which must not run concurrently with
+	// itself.
+	sizeRepairAfter string
 }
 
 // Option configures a Reaper.
@@ -37,13 +118,86 @@ func WithHealthReporter(h HealthReporter) Option {
 	}
 }
 
+// WithAdaptivePacing slows deletions down by delay whenever the p95 latency
+// of recent registry calls exceeds threshold. A zero threshold disables pacing.
+func WithAdaptivePacing(threshold, delay time.Duration) Option {
+	return func(r *Reaper) {
+		if threshold > 0 {
+			r.pacer = newPacer(threshold, delay)
+		}
+	}
+}
+
+// WithLockTTL overrides how long the reaper lock survives without renewal.
+func WithLockTTL(ttl time.Duration) Option {
+	return func(r *Reaper) {
+		r.lockTTL = ttl
+	}
+}
+
+// WithDiskProbe makes the reaper stat the registry filesystem at dataPath
+// after each cycle and evict images ahead of expiry whenever free space
+// drops below minFreeBytes. A zero minFreeBytes disables the probe.
+func WithDiskProbe(dataPath string, minFreeBytes int64) Option {
+	return func(r *Reaper) {
+		r.dataPath = dataPath
+		r.minFreeBytes = minFreeBytes
+	}
+}
+
+// WithDeleteVerification makes the reaper check that a manifest is gone
+// after deleting it, retrying the DELETE once, before it stops tracking the
+// image.
+func WithDeleteVerification(enabled bool) Option {
+	return func(r *Reaper) {
+		r.verifyDeletes = enabled
+	}
+}
+
+// WithRegistryClient replaces the registry client built from the registry
+// URL, e.g. with one carrying credentials.
+func WithRegistryClient(c *registry.Client) Option {
+	return func(r *Reaper) {
+		r.registry = c
+	}
+}
+
+// ProtectionRules reports whether an image is protected by pattern.
+type ProtectionRules interface {
+	Protected(image string) bool
+}
+
+// WithProtection skips expired images matching rules, in addition to those
+// protected individually by a push policy.
+func WithProtection(rules ProtectionRules) Option {
+	return func(r *Reaper) {
+		r.protection = rules
+	}
+}
+
+// WithCycleBudget limits a reap cycle to maxDeletes deletion attempts and
+// stops starting new ones once it has run for maxDuration, leaving the
+// remaining expired images for the next cycle. Expired images are deleted
+// by descending priority, so the budget postpones the least valuable ones.
+// Zero removes the respective limit.
+func WithCycleBudget(maxDeletes int, maxDuration time.Duration) Option {
+	return func(r *Reaper) {
+		r.maxDeletes = maxDeletes
+		r.maxDuration = maxDuration
+	}
+}
+
 // New creates a new Reaper.
 func New(redis redisclient.Store, registryURL string, logger *slog.Logger, opts ...Option) *Reaper {
 	r := &Reaper{
-		redis:       redis,
-		registryURL: strings.TrimRight(registryURL, "/"),
-		logger:      logger,
-		httpClient:  &http.Client{Timeout: 10 * time.Second},
+		redis:    redis,
+		registry: registry.New(registryURL),
+		logger:   logger,
+		lockTTL:  defaultLockTTL,
+		history:  newHistory(maxHistory),
+		statFS:   diskusage.Stat,
+
+		digestStrategy: DigestPushed,
 	}
 	for _, opt := range opts {
 		opt(r)
@@ -51,51 +205,173 @@ func New(redis redisclient.Store, registryURL string, logger *slog.Logger, opts
 	return r
 }
 
-// RunLoop starts the reaper loop, ticking at the given interval.
+// Loops configures the background loops Start runs. Zero intervals disable
+// the sweep and the size repair.
+type Loops struct {
+	Schedule            schedule.Schedule
+	SweepInterval       time.Duration
+	SweepBatchSize      int
+	SizeRepairInterval  time.Duration
+	SizeRepairBatchSize int
+}
+
+// Start runs the reaper loop on l.Schedule, and the sweep and size repair
+// loops if enabled, in the background until ctx is cancelled.
+func (r *Reaper) Start(ctx context.Context, l Loops) {
+	go r.RunLoop(ctx, l.Schedule)
+	if l.SweepInterval > 0 {
+		go r.SweepLoop(ctx, l.SweepInterval, l.SweepBatchSize)
+	}
+	if l.SizeRepairInterval > 0 {
+		go r.SizeRepairLoop(ctx, l.SizeRepairInterval, l.SizeRepairBatchSize)
+	}
+}
+
+// RunLoop starts the reaper loop, running a cycle at every activation of s.
 // It blocks until the context is cancelled.
-func (r *Reaper) RunLoop(ctx context.Context, interval time.Duration) {
-	r.logger.Info("starting reaper loop", "interval", interval.String())
+func (r *Reaper) RunLoop(ctx context.Context, s schedule.Schedule) {
+	r.logger.Info("starting reaper loop", "schedule", s.String())
 
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	if !r.arm(timer, s) {
+		return
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			r.logger.Info("reaper loop stopped")
 			return
-		case <-ticker.C:
+		case <-timer.C:
 			if err := r.ReapOnce(ctx); err != nil {
 				r.logger.Error("reap cycle failed", "error", err)
 			}
+			if err := r.checkDisk(ctx); err != nil {
+				r.logger.Error("disk probe failed", "error", err)
+			}
+			if !r.arm(timer, s) {
+				return
+			}
 		}
 	}
 }
 
+// arm resets timer to the next activation of s, reporting false if s has
+// none.
+func (r *Reaper) arm(timer *time.Timer, s schedule.Schedule) bool {
+	next := s.Next(time.Now())
+	if next.IsZero() {
+		r.logger.Error("reap schedule has no upcoming activation, stopping reaper loop", "schedule", s.String())
+		return false
+	}
+	r.logger.Debug("next reap cycle scheduled", "at", next.Format(time.RFC3339))
+	timer.Reset(time.Until(next))
+	return true
+}
+
+// Result summarizes the outcome of a single reap cycle.
+type Result struct {
+	// Skipped is true when another replica held the reaper lock.
+	Skipped bool `json:"skipped" yaml:"skipped"`
+	// Total is the number of tracked images inspected.
+	Total int `json:"total" yaml:"total"`
+	// Attempted is the number of expired images a deletion was attempted for,
+	// not counting overwritten ones.
+	Attempted int `json:"attempted" yaml:"attempted"`
+	// Reaped is the number of images successfully deleted.
+	Reaped int `json:"reaped" yaml:"reaped"`
+	// Failed is the number of deletions that failed.
+	Failed int `json:"failed" yaml:"failed"`
+	// Quarantined is the number of expired images skipped because they are
+	// quarantined.
+	Quarantined int `json:"quarantined,omitempty" yaml:"quarantined,omitempty"`
+	// Protected is the number of expired images skipped because a push
+	// policy protected them.
+	Protected int `json:"protected,omitempty" yaml:"protected,omitempty"`
+	// Frozen is the number of expired images skipped because a freeze
+	// covers their repository.
+	Frozen int `json:"frozen,omitempty" yaml:"frozen,omitempty"`
+	// Approved is the number of protected images deleted because their
+	// deletion was approved.
+	Approved int `json:"approved,omitempty" yaml:"approved,omitempty"`
+	// Deferred is the number of expired images skipped because a workload
+	// still references them.
+	Deferred int `json:"deferred,omitempty" yaml:"deferred,omitempty"`
+	// Degraded is why the cycle deleted nothing because the store was
+	// unsafe to delete by, e.g. during a failover.
+	Degraded string `json:"degraded,omitempty" yaml:"degraded,omitempty"`
+	// Overwritten is the number of expired images not deleted because their
+	// tag was overwritten since it was tracked; see WithOverwritePolicy.
+	Overwritten int `json:"overwritten,omitempty" yaml:"overwritten,omitempty"`
+	// Superseded is the number of superseded cache manifests deleted by
+	// digest.
+	Superseded int `json:"superseded,omitempty" yaml:"superseded,omitempty"`
+	// Postponed is the number of expired images and superseded manifests
+	// left for the next cycle because the cycle reached its deletion or
+	// time budget.
+	Postponed int `json:"postponed,omitempty" yaml:"postponed,omitempty"`
+}
+
 // ReapOnce performs a single reap pass — checking all tracked images and
 // deleting those that have expired. Uses a Redis lock to ensure only one
 // replica runs the reaper at a time.
 func (r *Reaper) ReapOnce(ctx context.Context) error {
-	acquired, err := r.redis.AcquireReaperLock(ctx, 5*time.Minute)
+	_, err := r.Reap(ctx)
+	return err
+}
+
+// Reap behaves like ReapOnce but also returns a summary of the cycle so
+// callers can account for partial failures.
+func (r *Reaper) Reap(ctx context.Context) (Result, error) {
+	var res Result
+
+	ctx, release, acquired, err := r.holdLock(ctx)
 	if err != nil {
-		return fmt.Errorf("acquiring reaper lock: %w", err)
+		return res, err
 	}
 	if !acquired {
 		r.logger.Debug("another replica holds the reaper lock, skipping")
-		return nil
+		res.Skipped = true
+		return res, nil
 	}
-	defer func() { _ = r.redis.ReleaseReaperLock(ctx) }()
+	defer release()
+
+	r.running.Store(true)
+	defer r.running.Store(false)
 
 	start := time.Now()
 	defer func() {
 		metrics.ReaperCycleDuration.Observe(time.Since(start).Seconds())
+		r.history.add(Cycle{Start: start, Duration: time.Since(start), Result: res})
 	}()
 
+	degraded, err := r.degraded(ctx)
+	if err != nil {
+		metrics.ReaperCycleErrors.Inc()
+		return res, fmt.Errorf("checking store health: %w", err)
+	}
+	if degraded != "" {
+		res.Degraded = degraded
+		metrics.DegradedCycles.WithLabelValues(degraded).Inc()
+		return res, nil
+	}
+
+	// Unlike quarantine, a freeze that cannot be read must not be ignored.
+	freezes, err := r.freezes(ctx)
+	if err != nil {
+		metrics.ReaperCycleErrors.Inc()
+		return res, fmt.Errorf("listing freezes: %w", err)
+	}
+
+	res.Approved = r.executeDeletions(ctx, r.approvedDeletions(ctx, freezes))
+
 	images, err := r.redis.ListImages(ctx)
 	if err != nil {
 		metrics.ReaperCycleErrors.Inc()
-		return fmt.Errorf("listing images: %w", err)
+		return res, fmt.Errorf("listing images: %w", err)
 	}
+	res.Total = len(images)
 
 	// An empty registry produces one of these per interval — keep that at
 	// debug so steady-state logs stay quiet.
@@ -106,131 +382,385 @@ func (r *Reaper) ReapOnce(ctx context.Context) error {
 	cycleLog("reap cycle starting", "total_images", len(images))
 	metrics.TrackedImagesGauge.Set(float64(len(images)))
 
-	now := time.Now().UnixMilli()
+	quarantined, err := r.quarantined(ctx)
+	if err != nil {
+		r.logger.Warn("failed to list quarantined images", "error", err)
+	}
+	c := cycle{now: time.Now().UnixMilli(), quarantined: quarantined, freezes: freezes}
+
+	if r.pacer != nil {
+		r.pacer.reset()
+	}
 
-	var attempted, failed int
+	reapedRepos := make(map[string]struct{})
+	defer func() { r.cleanupRepos(ctx, reapedRepos) }()
 
-	for _, image := range images {
+	// Collect the deletable images first so they can be deleted by
+	// priority.
+	expired, err := r.dueImages(ctx, images, c, &res)
+	if err != nil {
+		return res, err
+	}
+	for i, e := range expired {
 		if err := ctx.Err(); err != nil {
-			return err
+			return res, err
 		}
-
-		expiresAt, err := r.redis.GetExpiry(ctx, image)
-		if err != nil {
-			r.logger.Warn("failed to get expiry, cleaning up", "image", image, "error", err)
-			_ = r.redis.RemoveImage(ctx, image)
-			continue
+		if reason := r.budgetSpent(res.Attempted, start); reason != "" {
+			res.Postponed = len(expired) - i
+			r.logger.Info("cycle budget spent, postponing the rest to the next cycle",
+				"reason", reason,
+				"attempted", res.Attempted,
+				"elapsed", time.Since(start).Round(time.Millisecond).String(),
+				"postponed", res.Postponed,
+			)
+			metrics.ReaperCyclesTruncated.WithLabelValues(reason).Inc()
+			break
 		}
+		image := e.image
 
-		if expiresAt > now {
-			remaining := time.Duration(expiresAt-now) * time.Millisecond
-			r.logger.Debug("image not expired yet",
-				"image", image,
-				"remaining", remaining.Round(time.Second).String(),
+		if r.pacer != nil && r.pacer.overloaded() {
+			r.logger.Warn("registry latency above threshold, pausing deletions",
+				"p95", r.pacer.p95().String(),
+				"threshold", r.pacer.threshold.String(),
+				"delay", r.pacer.delay.String(),
 			)
-			continue
+			if err := r.pacer.wait(ctx); err != nil {
+				return res, err
+			}
 		}
 
-		// Get image size before deletion for metrics
-		sizeBytes, err := r.redis.GetImageSize(ctx, image)
-		if err != nil {
-			r.logger.Warn("failed to get image size for metrics", "image", image, "error", err)
-			sizeBytes = 0
+		// Overwritten images are not deletions, so they must not count
+		// towards the failure ratio the health report is based on.
+		overwritten, err := r.overwritten(ctx, image)
+		if err == nil && overwritten {
+			res.Overwritten++
+			continue
 		}
-
-		attempted++
-		if err := r.deleteImage(ctx, image); err != nil {
+		res.Attempted++
+		if err == nil {
+			err = r.reapExpired(ctx, image)
+		}
+		if err != nil {
 			r.logger.Error("failed to delete image", "image", image, "error", err)
-			failed++
+			res.Failed++
+			r.recordFailure(ctx, image, err)
 			continue
 		}
-
-		// Update storage metrics
-		metrics.ImagesReaped.Inc()
-		metrics.BytesReclaimed.Add(float64(sizeBytes))
-		metrics.TrackedBytesTotal.Sub(float64(sizeBytes))
-
-		sizeMB := float64(sizeBytes) / (1024 * 1024)
-		r.logger.Info("reaped expired image",
-			"image", image,
-			"size_bytes", sizeBytes,
-			"size_mb", fmt.Sprintf("%.2f", sizeMB),
-		)
+		res.Reaped++
+		repo, _, _ := strings.Cut(image, ":")
+		reapedRepos[repo] = struct{}{}
 	}
 
+	r.reapSuperseded(ctx, c, start, &res)
+	metrics.ReaperBacklog.Set(float64(res.Postponed))
+
 	// Report registry health based on deletion outcomes.
 	// Only report when we actually attempted deletions — cycles with
 	// no expired images are neutral and should not affect health state.
-	if r.health != nil && attempted > 0 {
-		if failed == attempted {
+	if r.health != nil && res.Attempted > 0 {
+		if res.Failed == res.Attempted {
 			r.health.ReportFailure()
 		} else {
 			r.health.ReportSuccess()
 		}
 	}
 
+	return res, nil
+}
+
+// protected reports whether image is protected, by pattern or by a push
+// policy.
+func (r *Reaper) protected(ctx context.Context, image string) (bool, error) {
+	if r.protection != nil && r.protection.Protected(image) {
+		return true, nil
+	}
+	return r.redis.IsProtected(ctx, image)
+}
+
+// ReapImage deletes a single image immediately if it has expired. It is
+// used to react to store expiry notifications; when another replica holds
+// the reaper lock the image is left for the next regular cycle.
+func (r *Reaper) ReapImage(ctx context.Context, image string) error {
+	ctx, release, acquired, err := r.holdLock(ctx)
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		r.logger.Debug("another replica holds the reaper lock, deferring to next cycle", "image", image)
+		return nil
+	}
+	defer release()
+
+	if degraded, err := r.degraded(ctx); err != nil || degraded != "" {
+		if err != nil {
+			return fmt.Errorf("checking store health: %w", err)
+		}
+		r.logger.Debug("store degraded, deferring to next cycle", "image", image, "reason", degraded)
+		return nil
+	}
+
+	expiresAt, err := r.redis.GetExpiry(ctx, image)
+	if err != nil {
+		return fmt.Errorf("getting expiry: %w", err)
+	}
+	if expiresAt > time.Now().UnixMilli() {
+		// Re-pushed with a later expiry since the notification was scheduled.
+		return nil
+	}
+	quarantined, err := r.quarantined(ctx)
+	if err != nil {
+		return fmt.Errorf("listing quarantined images: %w", err)
+	}
+	freezes, err := r.freezes(ctx)
+	if err != nil {
+		return fmt.Errorf("listing freezes: %w", err)
+	}
+	verdict, err := r.spared(ctx, cycle{quarantined: quarantined, freezes: freezes}, image)
+	if err != nil {
+		return fmt.Errorf("checking protection: %w", err)
+	}
+	if verdict != "" {
+		return nil
+	}
+
+	overwritten, err := r.overwritten(ctx, image)
+	if err == nil && overwritten {
+		return nil
+	}
+	if err == nil {
+		err = r.reapExpired(ctx, image)
+	}
+	if err != nil {
+		r.recordFailure(ctx, image, err)
+		return err
+	}
+	repo, _, _ := strings.Cut(image, ":")
+	r.cleanupRepos(ctx, map[string]struct{}{repo: {}})
 	return nil
 }
 
-func (r *Reaper) deleteImage(ctx context.Context, imageWithTag string) error {
-	parts := strings.SplitN(imageWithTag, ":", 2)
-	if len(parts) != 2 {
-		_ = r.redis.RemoveImage(ctx, imageWithTag)
-		return fmt.Errorf("invalid image format: %s", imageWithTag)
+// ExpiryNotifier is a store that publishes the images whose expiry it
+// enforces natively as they expire.
+type ExpiryNotifier interface {
+	EnableExpiryNotifications(ctx context.Context) error
+	SubscribeExpirations(ctx context.Context) (<-chan string, error)
+}
+
+// StartExpiryWatch subscribes to the expirations of n and reaps the expired
+// images in the background until ctx is cancelled. Failing to enable the
+// notifications is only logged, as the server may have them configured.
+func (r *Reaper) StartExpiryWatch(ctx context.Context, n ExpiryNotifier) error {
+	if err := n.EnableExpiryNotifications(ctx); err != nil {
+		r.logger.Warn("could not enable keyspace notifications, relying on server config", "error", err)
 	}
-	repo, tag := parts[0], parts[1]
+	expired, err := n.SubscribeExpirations(ctx)
+	if err != nil {
+		return fmt.Errorf("subscribing to expiry notifications: %w", err)
+	}
+	go r.WatchExpirations(ctx, expired)
+	return nil
+}
+
+// WatchExpirations reaps images as their names arrive on expired, until the
+// channel is closed or ctx is cancelled.
+func (r *Reaper) WatchExpirations(ctx context.Context, expired <-chan string) {
+	r.logger.Info("watching store expiry notifications")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case image, ok := <-expired:
+			if !ok {
+				r.logger.Warn("expiry notification channel closed")
+				return
+			}
+			if err := r.ReapImage(ctx, image); err != nil {
+				r.logger.Error("failed to reap image on expiry", "image", image, "error", err)
+			}
+		}
+	}
+}
+
+// reapExpired deletes an expired image and records storage metrics.
+func (r *Reaper) reapExpired(ctx context.Context, image string) error {
+	sizeBytes, err := r.remove(ctx, image, ReasonExpired)
+	if err != nil {
+		return err
+	}
+
+	sizeMB := float64(sizeBytes) / (1024 * 1024)
+	r.logger.Info("reaped expired image",
+		"image", image,
+		"size_bytes", sizeBytes,
+		"size_mb", fmt.Sprintf("%.2f", sizeMB),
+	)
+	return nil
+}
 
-	// Get the manifest digest via HEAD request.
-	headURL := fmt.Sprintf("%s/v2/%s/manifests/%s", r.registryURL, repo, tag)
-	headReq, err := http.NewRequestWithContext(ctx, http.MethodHead, headURL, nil)
+// remove deletes an image from the registry and the store for reason, adds
+// it to the reap totals and returns its tracked size.
+func (r *Reaper) remove(ctx context.Context, image, reason string) (int64, error) {
+	// Get image size before deletion for metrics
+	sizeBytes, err := r.redis.GetImageSize(ctx, image)
 	if err != nil {
-		return fmt.Errorf("creating HEAD request: %w", err)
+		r.logger.Warn("failed to get image size for metrics", "image", image, "error", err)
+		sizeBytes = 0
+	}
+	trackedBytes := sizeBytes
+
+	hooked := len(r.preDelete) > 0 || len(r.postDelete) > 0
+	var ev deletehook.Event
+	var digest string
+	switch {
+	case hooked:
+		ev = r.deleteEvent(ctx, image, sizeBytes)
+		digest = ev.Digest
+	case r.tombstoneRetention > 0:
+		digest, _ = r.redis.GetImageDigest(ctx, image)
+	}
+	var manifest *registry.RawManifest
+	if r.archive != nil || (r.restore && r.tombstoneRetention > 0) {
+		manifest = r.keepManifest(ctx, image, digest)
+	}
+	switch {
+	case r.archive == nil:
+	case manifest == nil:
+		metrics.ArchiveErrors.Inc()
+	default:
+		r.archiveContent(ctx, image, manifest)
+		if digest == "" {
+			digest = manifest.Digest()
+		}
 	}
-	headReq.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
 
-	headResp, err := r.httpClient.Do(headReq)
+	// Storage is only freed once the last tag of a shared manifest goes.
+	aliases, err := r.redis.Aliases(ctx, image)
 	if err != nil {
-		return fmt.Errorf("HEAD manifest: %w", err)
+		return 0, fmt.Errorf("listing aliases: %w", err)
+	}
+	if len(aliases) > 0 {
+		sizeBytes = 0
 	}
-	defer func() { _ = headResp.Body.Close() }()
 
-	if headResp.StatusCode == http.StatusNotFound {
-		// Image already gone from registry, just clean up Redis.
-		return r.redis.RemoveImage(ctx, imageWithTag)
+	if err := r.runDeleteHooks(ctx, r.preDelete, deletehook.PhasePreDelete, ev); err != nil {
+		return 0, err
+	}
+	if err := r.deleteImage(ctx, image); err != nil {
+		return 0, err
 	}
-	if headResp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HEAD manifest returned %d", headResp.StatusCode)
+	if err := r.runDeleteHooks(ctx, r.postDelete, deletehook.PhasePostDelete, ev); err != nil {
+		r.logger.Warn("post-delete hook failed", "image", image, "error", err)
 	}
+	r.bury(ctx, redisclient.Tombstone{Image: image, Digest: digest, SizeBytes: trackedBytes, Reason: reason}, manifest)
 
-	digest := headResp.Header.Get("Docker-Content-Digest")
-	if digest == "" {
-		// Fall back to ETag like the upstream implementation.
-		digest = strings.Trim(headResp.Header.Get("ETag"), `"`)
+	// Update storage metrics
+	if err := r.redis.RecordReap(ctx, sizeBytes); err != nil {
+		r.logger.Warn("failed to record reap totals", "image", image, "error", err)
 	}
-	if digest == "" {
-		return fmt.Errorf("no digest found for %s", imageWithTag)
+	return sizeBytes, nil
+}
+
+func (r *Reaper) deleteImage(ctx context.Context, imageWithTag string) error {
+	parts := strings.SplitN(imageWithTag, ":", 2)
+	if len(parts) != 2 {
+		_ = r.redis.RemoveImage(ctx, imageWithTag)
+		return fmt.Errorf("invalid image format: %s", imageWithTag)
 	}
+	repo, tag := parts[0], parts[1]
 
-	// Delete the manifest by digest.
-	deleteURL := fmt.Sprintf("%s/v2/%s/manifests/%s", r.registryURL, repo, digest)
-	delReq, err := http.NewRequestWithContext(ctx, http.MethodDelete, deleteURL, nil)
+	digest, found, err := r.digestToDelete(ctx, imageWithTag, repo, tag)
 	if err != nil {
-		return fmt.Errorf("creating DELETE request: %w", err)
+		return err
+	}
+	if !found {
+		// Image already gone from registry, just clean up Redis.
+		return r.redis.RemoveImage(ctx, imageWithTag)
 	}
-	delReq.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
 
-	delResp, err := r.httpClient.Do(delReq)
+	aliases, err := r.redis.Aliases(ctx, imageWithTag)
 	if err != nil {
-		return fmt.Errorf("DELETE manifest: %w", err)
+		return fmt.Errorf("listing aliases: %w", err)
+	}
+	if len(aliases) > 0 {
+		return r.untag(ctx, repo, tag, aliases)
 	}
-	defer func() { _ = delResp.Body.Close() }()
 
-	validStatus := delResp.StatusCode == http.StatusAccepted ||
-		delResp.StatusCode == http.StatusOK ||
-		delResp.StatusCode == http.StatusNotFound
-	if !validStatus {
-		return fmt.Errorf("DELETE manifest returned %d", delResp.StatusCode)
+	if err := r.deleteManifest(ctx, repo, digest); err != nil {
+		return err
+	}
+	if r.verifyDeletes {
+		if err := r.verifyDeleted(ctx, repo, digest); err != nil {
+			return err
+		}
 	}
 
 	return r.redis.RemoveImage(ctx, imageWithTag)
 }
+
+// verifyDeleted checks that a deleted manifest is really gone, issuing the
+// DELETE once more if it is not. Proxies in front of some registries accept
+// deletions that never reach the backend.
+func (r *Reaper) verifyDeleted(ctx context.Context, repo, digest string) error {
+	for attempt := 0; ; attempt++ {
+		_, found, err := r.manifestDigest(ctx, repo, digest)
+		if err != nil {
+			return fmt.Errorf("verifying deletion: %w", err)
+		}
+		if !found {
+			return nil
+		}
+		if attempt > 0 {
+			metrics.DeleteVerificationFailures.Inc()
+			return fmt.Errorf("manifest %s@%s still present after DELETE", repo, digest)
+		}
+		r.logger.Warn("manifest still present after DELETE, retrying", "repo", repo, "digest", digest)
+		if err := r.deleteManifest(ctx, repo, digest); err != nil {
+			return err
+		}
+	}
+}
+
+// untag removes a tag whose manifest is still referenced by other tracked
+// tags. Deleting the manifest by digest would take those tags with it, so
+// only the tag itself is deleted. Registries that cannot delete tags keep
+// it until the last alias's manifest is deleted, which removes it as well.
+func (r *Reaper) untag(ctx context.Context, repo, tag string, aliases []string) error {
+	imageWithTag := repo + ":" + tag
+	start := time.Now()
+	err := r.registry.DeleteTag(ctx, repo, tag)
+	r.observe(start)
+
+	var statusErr *registry.StatusError
+	if errors.As(err, &statusErr) && statusErr.Permanent() {
+		r.logger.Debug("registry cannot delete tags, leaving tag to its aliases",
+			"image", imageWithTag, "error", err)
+	} else if err != nil {
+		return err
+	}
+	r.logger.Info("kept manifest shared with tracked aliases", "image", imageWithTag, "aliases", aliases)
+	return r.redis.RemoveImage(ctx, imageWithTag)
+}
+
+func (r *Reaper) deleteManifest(ctx context.Context, repo, digest string) error {
+	start := time.Now()
+	err := r.registry.DeleteManifest(ctx, repo, digest)
+	r.observe(start)
+	return err
+}
+
+// manifestDigest resolves repo:tag to its manifest digest. found is false
+// when the registry reports the manifest missing.
+func (r *Reaper) manifestDigest(ctx context.Context, repo, tag string) (digest string, found bool, err error) {
+	start := time.Now()
+	digest, found, err = r.registry.ManifestDigest(ctx, repo, tag)
+	r.observe(start)
+	return digest, found, err
+}
+
+// observe feeds the latency of a registry call started at start into the
+// pacer.
+func (r *Reaper) observe(start time.Time) {
+	if r.pacer != nil {
+		r.pacer.observe(time.Since(start))
+	}
+}
@@ -2,89 +2,39 @@ package reaper
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
-)
-
-// mockStore is an in-memory implementation of redis.Store for testing.
-type mockStore struct {
-	images  map[string]int64 // imageWithTag -> expiresAt (epoch millis)
-	sizes   map[string]int64 // imageWithTag -> sizeBytes
-	digests map[string]string
-	created map[string]int64
-	removed []string
-}
 
-func newMockStore() *mockStore {
-	return &mockStore{
-		images:  make(map[string]int64),
-		sizes:   make(map[string]int64),
-		digests: make(map[string]string),
-		created: make(map[string]int64),
-	}
-}
+	"github.com/prometheus/client_golang/prometheus/testutil"
 
-func (m *mockStore) Ping(context.Context) error { return nil }
-func (m *mockStore) Close() error               { return nil }
-
-func (m *mockStore) TrackImage(
-	_ context.Context,
-	imageWithTag string,
-	expiresAt time.Time,
-	sizeBytes int64,
-	digest string,
-) error {
-	m.images[imageWithTag] = expiresAt.UnixMilli()
-	m.sizes[imageWithTag] = sizeBytes
-	m.digests[imageWithTag] = digest
-	m.created[imageWithTag] = time.Now().UnixMilli()
-	return nil
-}
+	"github.com/tamcore/ephemeron/internal/memstore"
+	"github.com/tamcore/ephemeron/internal/metrics"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
 
-func (m *mockStore) ListImages(context.Context) ([]string, error) {
-	out := make([]string, 0, len(m.images))
-	for k := range m.images {
-		out = append(out, k)
+// track adds image to store with the given expiry.
+func track(t *testing.T, store *memstore.Store, image string, expiresAt time.Time) {
+	t.Helper()
+	if err := store.TrackImage(t.Context(), image, expiresAt, 0, "", redisclient.ImageMeta{}); err != nil {
+		t.Fatalf("tracking %s: %v", image, err)
 	}
-	return out, nil
-}
-
-func (m *mockStore) GetExpiry(_ context.Context, imageWithTag string) (int64, error) {
-	return m.images[imageWithTag], nil
-}
-
-func (m *mockStore) GetImageSize(_ context.Context, imageWithTag string) (int64, error) {
-	return m.sizes[imageWithTag], nil
-}
-
-func (m *mockStore) GetImageDigest(_ context.Context, imageWithTag string) (string, error) {
-	return m.digests[imageWithTag], nil
-}
-
-func (m *mockStore) GetCreatedTimestamp(_ context.Context, imageWithTag string) (int64, error) {
-	return m.created[imageWithTag], nil
 }
 
-func (m *mockStore) RemoveImage(_ context.Context, imageWithTag string) error {
-	delete(m.images, imageWithTag)
-	m.removed = append(m.removed, imageWithTag)
-	return nil
+// tracked reports whether image is still tracked in store.
+func tracked(store *memstore.Store, image string) bool {
+	_, err := store.GetExpiry(context.Background(), image)
+	return err == nil
 }
 
-func (m *mockStore) AcquireReaperLock(context.Context, time.Duration) (bool, error) {
-	return true, nil
-}
-
-func (m *mockStore) ReleaseReaperLock(context.Context) error { return nil }
-
-func (m *mockStore) IsInitialized(context.Context) (bool, error) { return false, nil }
-func (m *mockStore) SetInitialized(context.Context) error        { return nil }
-
-func (m *mockStore) ImageCount(context.Context) (int64, error) {
-	return int64(len(m.images)), nil
+// imageCount returns the number of images tracked in store.
+func imageCount(store *memstore.Store) int {
+	n, _ := store.ImageCount(context.Background())
+	return int(n)
 }
 
 func TestDeleteImage_404FromRegistry(t *testing.T) {
@@ -93,15 +43,15 @@ func TestDeleteImage_404FromRegistry(t *testing.T) {
 	}))
 	defer registry.Close()
 
-	store := newMockStore()
-	store.images["myimage:1h"] = time.Now().Add(-time.Hour).UnixMilli()
+	store := memstore.New()
+	track(t, store, "myimage:1h", time.Now().Add(-time.Hour))
 
 	r := New(store, registry.URL, slog.Default())
 	err := r.deleteImage(t.Context(), "myimage:1h")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if _, exists := store.images["myimage:1h"]; exists {
+	if tracked(store, "myimage:1h") {
 		t.Error("expected image to be removed from store")
 	}
 }
@@ -121,8 +71,8 @@ func TestDeleteImage_SuccessfulDelete(t *testing.T) {
 	}))
 	defer registry.Close()
 
-	store := newMockStore()
-	store.images["myimage:1h"] = time.Now().Add(-time.Hour).UnixMilli()
+	store := memstore.New()
+	track(t, store, "myimage:1h", time.Now().Add(-time.Hour))
 
 	r := New(store, registry.URL, slog.Default())
 	err := r.deleteImage(t.Context(), "myimage:1h")
@@ -132,13 +82,72 @@ func TestDeleteImage_SuccessfulDelete(t *testing.T) {
 	if !deleteCalled {
 		t.Error("expected DELETE to be called on registry")
 	}
-	if _, exists := store.images["myimage:1h"]; exists {
+	if tracked(store, "myimage:1h") {
 		t.Error("expected image to be removed from store")
 	}
 }
 
+func TestDeleteImage_Verification(t *testing.T) {
+	tests := []struct {
+		name        string
+		lies        int32 // DELETEs acknowledged without effect
+		wantErr     bool
+		wantDeletes int32
+	}{
+		{"deleted", 0, false, 1},
+		{"deleted on retry", 1, false, 2},
+		{"never deleted", 2, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deletes atomic.Int32
+			var gone atomic.Bool
+			registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodHead:
+					if gone.Load() {
+						w.WriteHeader(http.StatusNotFound)
+						return
+					}
+					w.Header().Set("Docker-Content-Digest", "sha256:abc123")
+					w.WriteHeader(http.StatusOK)
+				case http.MethodDelete:
+					if deletes.Add(1) > tt.lies {
+						gone.Store(true)
+					}
+					w.WriteHeader(http.StatusAccepted)
+				}
+			}))
+			defer registry.Close()
+
+			store := memstore.New()
+			track(t, store, "myimage:1h", time.Now().Add(-time.Hour))
+
+			r := New(store, registry.URL, slog.Default(), WithDeleteVerification(true))
+			before := testutil.ToFloat64(metrics.DeleteVerificationFailures)
+			err := r.deleteImage(t.Context(), "myimage:1h")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("deleteImage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if n := deletes.Load(); n != tt.wantDeletes {
+				t.Errorf("expected %d DELETEs, got %d", tt.wantDeletes, n)
+			}
+			if tracked(store, "myimage:1h") != tt.wantErr {
+				t.Errorf("expected image tracked = %v", tt.wantErr)
+			}
+			wantFailures := 0.0
+			if tt.wantErr {
+				wantFailures = 1
+			}
+			if got := testutil.ToFloat64(metrics.DeleteVerificationFailures) - before; got != wantFailures {
+				t.Errorf("expected %v verification failures, got %v", wantFailures, got)
+			}
+		})
+	}
+}
+
 func TestDeleteImage_InvalidFormat(t *testing.T) {
-	store := newMockStore()
+	store := memstore.New()
 	r := New(store, "http://localhost", slog.Default())
 	err := r.deleteImage(t.Context(), "no-colon-here")
 	if err == nil {
@@ -161,9 +170,9 @@ func TestReapOnce_ExpiredImage(t *testing.T) {
 	}))
 	defer registry.Close()
 
-	store := newMockStore()
+	store := memstore.New()
 	// Image expired 1 minute ago.
-	store.images["myapp:5m"] = time.Now().Add(-time.Minute).UnixMilli()
+	track(t, store, "myapp:5m", time.Now().Add(-time.Minute))
 
 	r := New(store, registry.URL, slog.Default())
 	if err := r.ReapOnce(t.Context()); err != nil {
@@ -172,8 +181,11 @@ func TestReapOnce_ExpiredImage(t *testing.T) {
 	if !deleteCalled {
 		t.Error("expected DELETE to be called")
 	}
-	if len(store.images) != 0 {
-		t.Errorf("expected store to be empty, got %d images", len(store.images))
+	if imageCount(store) != 0 {
+		t.Errorf("expected store to be empty, got %d images", imageCount(store))
+	}
+	if images, _, _ := store.ReapTotals(t.Context()); images != 1 {
+		t.Errorf("expected 1 reaped image recorded, got %d", images)
 	}
 }
 
@@ -183,15 +195,15 @@ func TestReapOnce_NotExpired(t *testing.T) {
 	}))
 	defer registry.Close()
 
-	store := newMockStore()
+	store := memstore.New()
 	// Image expires in 1 hour.
-	store.images["myapp:1h"] = time.Now().Add(time.Hour).UnixMilli()
+	track(t, store, "myapp:1h", time.Now().Add(time.Hour))
 
 	r := New(store, registry.URL, slog.Default())
 	if err := r.ReapOnce(t.Context()); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if len(store.images) != 1 {
+	if imageCount(store) != 1 {
 		t.Error("non-expired image should not be removed")
 	}
 }
@@ -211,9 +223,9 @@ func TestReapOnce_AllDeletesFail_ReportsFailure(t *testing.T) {
 	}))
 	defer reg.Close()
 
-	store := newMockStore()
-	store.images["img1:1h"] = time.Now().Add(-time.Minute).UnixMilli()
-	store.images["img2:1h"] = time.Now().Add(-time.Minute).UnixMilli()
+	store := memstore.New()
+	track(t, store, "img1:1h", time.Now().Add(-time.Minute))
+	track(t, store, "img2:1h", time.Now().Add(-time.Minute))
 
 	hr := &mockHealthReporter{}
 	r := New(store, reg.URL, slog.Default(), WithHealthReporter(hr))
@@ -241,8 +253,8 @@ func TestReapOnce_AllDeletesSucceed_ReportsSuccess(t *testing.T) {
 	}))
 	defer reg.Close()
 
-	store := newMockStore()
-	store.images["img1:1h"] = time.Now().Add(-time.Minute).UnixMilli()
+	store := memstore.New()
+	track(t, store, "img1:1h", time.Now().Add(-time.Minute))
 
 	hr := &mockHealthReporter{}
 	r := New(store, reg.URL, slog.Default(), WithHealthReporter(hr))
@@ -264,8 +276,8 @@ func TestReapOnce_NoExpiredImages_NoHealthReport(t *testing.T) {
 	}))
 	defer reg.Close()
 
-	store := newMockStore()
-	store.images["img1:1h"] = time.Now().Add(time.Hour).UnixMilli()
+	store := memstore.New()
+	track(t, store, "img1:1h", time.Now().Add(time.Hour))
 
 	hr := &mockHealthReporter{}
 	r := New(store, reg.URL, slog.Default(), WithHealthReporter(hr))
@@ -298,9 +310,9 @@ func TestReapOnce_PartialFailure_ReportsSuccess(t *testing.T) {
 	}))
 	defer reg.Close()
 
-	store := newMockStore()
-	store.images["aaa:1h"] = time.Now().Add(-time.Minute).UnixMilli()
-	store.images["zzz:1h"] = time.Now().Add(-time.Minute).UnixMilli()
+	store := memstore.New()
+	track(t, store, "aaa:1h", time.Now().Add(-time.Minute))
+	track(t, store, "zzz:1h", time.Now().Add(-time.Minute))
 
 	hr := &mockHealthReporter{}
 	r := New(store, reg.URL, slog.Default(), WithHealthReporter(hr))
@@ -315,3 +327,192 @@ func TestReapOnce_PartialFailure_ReportsSuccess(t *testing.T) {
 		t.Errorf("expected 0 failure reports for partial failure, got %d", hr.failures)
 	}
 }
+
+// protectionFunc adapts a function to ProtectionRules.
+type protectionFunc func(image string) bool
+
+func (f protectionFunc) Protected(image string) bool { return f(image) }
+
+func TestReap_ReturnsResult(t *testing.T) {
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead && r.URL.Path == "/v2/broken/manifests/1h" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		switch r.Method {
+		case http.MethodHead:
+			w.Header().Set("Docker-Content-Digest", "sha256:abc123")
+			w.WriteHeader(http.StatusOK)
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer reg.Close()
+
+	store := memstore.New()
+	track(t, store, "broken:1h", time.Now().Add(-time.Minute))
+	track(t, store, "ok:1h", time.Now().Add(-time.Minute))
+	track(t, store, "fresh:1h", time.Now().Add(time.Hour))
+	track(t, store, "protected:1h", time.Now().Add(-time.Minute))
+	track(t, store, "release:stable", time.Now().Add(-time.Minute))
+	if err := store.SetProtected(t.Context(), "protected:1h", true); err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(store, reg.URL, slog.Default(), WithProtection(protectionFunc(func(image string) bool {
+		return image == "release:stable"
+	})))
+	res, err := r.Reap(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := Result{Total: 5, Attempted: 2, Reaped: 1, Failed: 1, Protected: 2}
+	if res != want {
+		t.Errorf("expected %+v, got %+v", want, res)
+	}
+	if !tracked(store, "protected:1h") || !tracked(store, "release:stable") {
+		t.Error("expected protected images to be kept")
+	}
+}
+
+func TestReapImage(t *testing.T) {
+	var deletes int
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodHead:
+			w.Header().Set("Docker-Content-Digest", "sha256:abc123")
+			w.WriteHeader(http.StatusOK)
+		case http.MethodDelete:
+			deletes++
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer reg.Close()
+
+	store := memstore.New()
+	track(t, store, "expired:1h", time.Now().Add(-time.Second))
+	track(t, store, "extended:1h", time.Now().Add(time.Hour))
+
+	r := New(store, reg.URL, slog.Default())
+	if err := r.ReapImage(t.Context(), "expired:1h"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.ReapImage(t.Context(), "extended:1h"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if deletes != 1 {
+		t.Errorf("expected 1 DELETE, got %d", deletes)
+	}
+	if tracked(store, "expired:1h") {
+		t.Error("expected expired image to be removed")
+	}
+	if !tracked(store, "extended:1h") {
+		t.Error("expected image with later expiry to be kept")
+	}
+}
+
+func TestWatchExpirations_StopsWhenChannelCloses(t *testing.T) {
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer reg.Close()
+
+	store := memstore.New()
+	track(t, store, "gone:1h", time.Now().Add(-time.Second))
+
+	expired := make(chan string, 1)
+	expired <- "gone:1h"
+	close(expired)
+
+	r := New(store, reg.URL, slog.Default())
+	done := make(chan struct{})
+	go func() {
+		r.WatchExpirations(t.Context(), expired)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("WatchExpirations did not return after channel closed")
+	}
+	if tracked(store, "gone:1h") {
+		t.Error("expected image to be removed")
+	}
+}
+
+// newerStore is a memstore whose image newer was tracked by a newer
+// version of ephemeron.
+type newerStore struct {
+	*memstore.Store
+	newer string
+}
+
+func (s *newerStore) GetExpiry(ctx context.Context, image string) (int64, error) {
+	if image == s.newer {
+		return 0, redisclient.ErrNewerRecord
+	}
+	return s.Store.GetExpiry(ctx, image)
+}
+
+func TestReap_LeavesNewerRecordsAlone(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer registry.Close()
+
+	store := &newerStore{Store: memstore.New(), newer: "app:next"}
+	track(t, store.Store, "app:next", time.Now().Add(-time.Hour))
+	track(t, store.Store, "app:old", time.Now().Add(-time.Hour))
+
+	r := New(store, registry.URL, slog.Default())
+	if _, err := r.Reap(t.Context()); err != nil {
+		t.Fatalf("Reap: %v", err)
+	}
+	if !tracked(store.Store, "app:next") {
+		t.Error("image tracked by a newer version was removed")
+	}
+	if tracked(store.Store, "app:old") {
+		t.Error("expected the expired image to be reaped")
+	}
+}
+
+// fakeNotifier delivers the images on expired once subscribed.
+type fakeNotifier struct {
+	enableErr error
+	expired   chan string
+}
+
+func (n *fakeNotifier) EnableExpiryNotifications(context.Context) error { return n.enableErr }
+
+func (n *fakeNotifier) SubscribeExpirations(context.Context) (<-chan string, error) {
+	return n.expired, nil
+}
+
+func TestStartExpiryWatch(t *testing.T) {
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer reg.Close()
+
+	store := memstore.New()
+	track(t, store, "gone:1h", time.Now().Add(-time.Second))
+
+	// Notifications the server already has configured still arrive.
+	n := &fakeNotifier{enableErr: errors.New("CONFIG disabled"), expired: make(chan string, 1)}
+	n.expired <- "gone:1h"
+	close(n.expired)
+
+	r := New(store, reg.URL, slog.New(slog.DiscardHandler))
+	if err := r.StartExpiryWatch(t.Context(), n); err != nil {
+		t.Fatalf("StartExpiryWatch: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for tracked(store, "gone:1h") {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the expired image to be removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
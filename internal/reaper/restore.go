@@ -0,0 +1,164 @@
+package reaper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+// Reasons an image cannot be restored.
+var (
+	ErrNoTombstone    = errors.New("no tombstone for image")
+	ErrNoManifest     = errors.New("manifest was not kept")
+	ErrTagExists      = errors.New("tag exists in the registry")
+	ErrContentMissing = errors.New("content already garbage collected")
+)
+
+// WithRestore keeps each deleted manifest in its tombstone, so Restore can
+// upload it again until the registry's garbage collection removes the
+// content it references. It costs one manifest request per deletion and
+// requires tombstones.
+func WithRestore(enabled bool) Option {
+	return func(r *Reaper) {
+		r.restore = enabled
+	}
+}
+
+// keepManifest fetches the manifest of image about to be deleted, by digest
+// if known, to restore or archive it. Failures are logged.
+func (r *Reaper) keepManifest(ctx context.Context, image, digest string) *registry.RawManifest {
+	repo, ref, _ := strings.Cut(image, ":")
+	if digest != "" {
+		ref = digest
+	}
+	start := time.Now()
+	m, err := r.registry.GetManifest(ctx, repo, ref)
+	r.observe(start)
+	if err != nil {
+		r.logger.Warn("failed to fetch manifest before deletion", "image", image, "error", err)
+		return nil
+	}
+	return m
+}
+
+// Restore uploads the manifest kept in the latest tombstone of image, or
+// archived under its digest, under its tag again. It fails if the tag exists
+// again or the registry no longer has the blobs or child manifests the
+// manifest references, except for a config blob that was archived, which is
+// uploaded again. The registry's push notification tracks the image anew.
+func (r *Reaper) Restore(ctx context.Context, image string) (redisclient.Tombstone, error) {
+	list, err := r.redis.ListTombstones(ctx, time.Now().Add(-r.tombstoneRetention))
+	if err != nil {
+		return redisclient.Tombstone{}, fmt.Errorf("listing tombstones: %w", err)
+	}
+	var t redisclient.Tombstone
+	for i := len(list) - 1; i >= 0; i-- {
+		if list[i].Image == image {
+			t = list[i]
+			break
+		}
+	}
+	if t.Image == "" {
+		return t, ErrNoTombstone
+	}
+	// Superseded manifests are deleted untagged and without being kept.
+	if t.Tag == "" {
+		return t, ErrNoManifest
+	}
+	if len(t.Manifest) == 0 {
+		if t.Manifest, err = r.archivedManifest(ctx, t.Digest); err != nil {
+			return t, err
+		}
+	}
+
+	if _, found, err := r.registry.ManifestDigest(ctx, t.Repository, t.Tag); err != nil {
+		return t, err
+	} else if found {
+		return t, ErrTagExists
+	}
+	if err := r.checkContent(ctx, t); err != nil {
+		return t, err
+	}
+	digest, err := r.registry.PutManifest(ctx, t.Repository, t.Tag,
+		&registry.RawManifest{MediaType: t.MediaType, Body: t.Manifest})
+	if err != nil {
+		return t, err
+	}
+	metrics.ImagesRestored.Inc()
+	r.logger.Info("restored deleted image", "image", image, "digest", digest, "reaped_at", t.ReapedAt)
+	return t, nil
+}
+
+// checkContent verifies that the registry still has everything the kept
+// manifest of t references: the child manifests of an index, or the config
+// and layers of an image. A collected config blob is uploaded from the
+// archive once everything else was found.
+func (r *Reaper) checkContent(ctx context.Context, t redisclient.Tombstone) error {
+	refs, err := registry.ParseReferences(t.Manifest)
+	if err != nil {
+		return fmt.Errorf("decoding kept manifest: %w", err)
+	}
+	for _, m := range refs.Manifests {
+		_, found, err := r.registry.ManifestDigest(ctx, t.Repository, m.Digest)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("%w: manifest %s", ErrContentMissing, m.Digest)
+		}
+	}
+	missingConfig := false
+	for _, b := range refs.Blobs {
+		found, err := r.registry.BlobExists(ctx, t.Repository, b.Digest)
+		if err != nil {
+			return err
+		}
+		switch {
+		case found:
+		case b.Digest == refs.Config && r.archive != nil:
+			missingConfig = true
+		default:
+			return fmt.Errorf("%w: blob %s", ErrContentMissing, b.Digest)
+		}
+	}
+	if missingConfig {
+		return r.unarchiveBlob(ctx, t.Repository, refs.Config)
+	}
+	return nil
+}
+
+// RestoreHandler restores the image in the "image" path value from its
+// tombstone on POST and answers with the tombstone.
+func (r *Reaper) RestoreHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		image := req.PathValue("image")
+		if image == "" {
+			http.Error(w, "missing image", http.StatusBadRequest)
+			return
+		}
+		t, err := r.Restore(req.Context(), image)
+		switch {
+		case errors.Is(err, ErrNoTombstone):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, ErrNoManifest), errors.Is(err, ErrTagExists), errors.Is(err, ErrContentMissing):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			r.logger.Error("failed to restore image", "image", image, "error", err)
+			http.Error(w, "restore failed: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		t.Manifest = nil
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(t)
+	})
+}
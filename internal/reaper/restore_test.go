@@ -0,0 +1,190 @@
+package reaper
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/archive"
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+// fakeRegistry serves manifests by "repo:ref" and blobs by digest.
+type fakeRegistry struct {
+	mu        sync.Mutex
+	manifests map[string][]byte
+	blobs     map[string]bool
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := strings.TrimPrefix(r.URL.Path, "/v2/")
+	if repo, digest, ok := strings.Cut(p, "/blobs/"); ok && repo != "" {
+		switch {
+		case r.Method == http.MethodPost:
+			w.Header().Set("Location", "/v2/"+repo+"/blobs/uploads/u1")
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodPut:
+			f.blobs[r.URL.Query().Get("digest")] = true
+			w.WriteHeader(http.StatusCreated)
+		case !f.blobs[digest]:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodGet:
+			_, _ = w.Write([]byte("blob " + digest))
+		}
+		return
+	}
+	repo, ref, _ := strings.Cut(p, "/manifests/")
+	key := repo + ":" + ref
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		body, ok := f.manifests[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", registry.MediaTypeOCIManifest)
+		w.Header().Set("Docker-Content-Digest", "sha256:img")
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(body)
+		}
+	case http.MethodPut:
+		f.manifests[key], _ = io.ReadAll(r.Body)
+		w.Header().Set("Docker-Content-Digest", "sha256:img")
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		delete(f.manifests, key)
+		delete(f.manifests, repo+":1h")
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	manifest := `{"config":{"digest":"sha256:cfg"},"layers":[{"digest":"sha256:layer"}]}`
+	tests := []struct {
+		name    string
+		blobs   map[string]bool
+		retag   bool // the tag was pushed again after deletion
+		restore bool
+		want    int
+	}{
+		{"restored", map[string]bool{"sha256:cfg": true, "sha256:layer": true}, false, true, http.StatusOK},
+		{"garbage collected", map[string]bool{"sha256:cfg": true}, false, true, http.StatusConflict},
+		{"tag pushed again", map[string]bool{"sha256:cfg": true, "sha256:layer": true}, true, true,
+			http.StatusConflict},
+		{"manifest not kept", map[string]bool{"sha256:cfg": true, "sha256:layer": true}, false, false,
+			http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeRegistry{
+				manifests: map[string][]byte{"app:sha256:img": []byte(manifest), "app:1h": []byte(manifest)},
+				blobs:     tt.blobs,
+			}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			store := memstore.New()
+			err := store.TrackImage(t.Context(), "app:1h", time.Now().Add(-time.Hour), 100, "sha256:img",
+				redisclient.ImageMeta{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := New(store, srv.URL, slog.New(slog.DiscardHandler), WithTombstones(time.Hour), WithRestore(tt.restore))
+			if err := r.ReapOnce(t.Context()); err != nil {
+				t.Fatalf("ReapOnce: %v", err)
+			}
+			if _, ok := fake.manifests["app:1h"]; ok {
+				t.Fatal("expected image to be deleted")
+			}
+			if tt.retag {
+				fake.manifests["app:1h"] = []byte(manifest)
+			}
+
+			mux := http.NewServeMux()
+			mux.Handle("POST /v1/api/tombstones/{image...}", r.RestoreHandler())
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/api/tombstones/app:1h", nil))
+			if rec.Code != tt.want {
+				t.Fatalf("expected %d, got %d: %s", tt.want, rec.Code, rec.Body)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var tomb redisclient.Tombstone
+			if err := json.NewDecoder(rec.Body).Decode(&tomb); err != nil || tomb.Image != "app:1h" {
+				t.Errorf("expected tombstone of app:1h, got %+v, %v", tomb, err)
+			}
+			if string(fake.manifests["app:1h"]) != manifest {
+				t.Errorf("expected manifest to be uploaded again, got %q", fake.manifests["app:1h"])
+			}
+		})
+	}
+}
+
+func TestRestore_NoTombstone(t *testing.T) {
+	r := New(memstore.New(), "http://registry.invalid", slog.New(slog.DiscardHandler), WithTombstones(time.Hour),
+		WithRestore(true))
+	mux := http.NewServeMux()
+	mux.Handle("POST /v1/api/tombstones/{image...}", r.RestoreHandler())
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/api/tombstones/app:1h", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+}
+
+func TestRestore_FromArchive(t *testing.T) {
+	manifest := `{"config":{"digest":"sha256:cfg"},"layers":[{"digest":"sha256:layer"}]}`
+	digest := (&registry.RawManifest{Body: []byte(manifest)}).Digest()
+	fake := &fakeRegistry{
+		manifests: map[string][]byte{"app:" + digest: []byte(manifest), "app:1h": []byte(manifest)},
+		blobs:     map[string]bool{"sha256:cfg": true, "sha256:layer": true},
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	store := memstore.New()
+	err := store.TrackImage(t.Context(), "app:1h", time.Now().Add(-time.Hour), 100, digest,
+		redisclient.ImageMeta{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := archive.Dir(t.TempDir())
+	r := New(store, srv.URL, slog.New(slog.DiscardHandler), WithTombstones(time.Hour), WithArchive(dir))
+	if err := r.ReapOnce(t.Context()); err != nil {
+		t.Fatalf("ReapOnce: %v", err)
+	}
+	if body, err := dir.Get(t.Context(), archive.ManifestKey(digest)); err != nil || string(body) != manifest {
+		t.Fatalf("expected manifest to be archived, got %q, %v", body, err)
+	}
+	if body, err := dir.Get(t.Context(), archive.BlobKey("sha256:cfg")); err != nil || string(body) != "blob sha256:cfg" {
+		t.Fatalf("expected config blob to be archived, got %q, %v", body, err)
+	}
+	list, err := store.ListTombstones(t.Context(), time.Time{})
+	if err != nil || len(list) != 1 || len(list[0].Manifest) != 0 {
+		t.Fatalf("expected a tombstone without manifest, got %+v, %v", list, err)
+	}
+
+	// The registry's garbage collection removed the config; the layer is
+	// still shared with another image.
+	delete(fake.blobs, "sha256:cfg")
+	if _, err := r.Restore(t.Context(), "app:1h"); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if !fake.blobs["sha256:cfg"] {
+		t.Error("expected config blob to be uploaded from the archive")
+	}
+	if string(fake.manifests["app:1h"]) != manifest {
+		t.Errorf("expected manifest to be uploaded again, got %q", fake.manifests["app:1h"])
+	}
+}
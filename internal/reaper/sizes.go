@@ -0,0 +1,127 @@
+package reaper
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+)
+
+// SizeRepairLoop retries the manifest fetch of a batch of images tracked
+// with size 0 every interval, so images whose manifest could not be read at
+// push time are eventually counted in storage metrics. It blocks until ctx
+// is cancelled.
+func (r *Reaper) SizeRepairLoop(ctx context.Context, interval time.Duration, batch int) {
+	r.logger.Info("starting size repair worker", "interval", interval.String(), "batch", batch)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.RepairSizes(ctx, batch); err != nil {
+				r.logger.Error("size repair failed", "error", err)
+			}
+		}
+	}
+}
+
+// RepairSizes fetches the manifests of up to batch images tracked with size
+// 0, continuing after the image the previous call stopped at, and records
+// the size found. An image tracked with a digest is fetched by that digest
+// and keeps it, since its tag may point elsewhere by now; one tracked
+// without is fetched by tag and records the digest found too. It returns
+// the number of records repaired.
+// Images whose manifest still cannot be read keep their record and are
+// retried once the batches come round again.
+func (r *Reaper) RepairSizes(ctx context.Context, batch int) (int, error) {
+	ctx, release, acquired, err := r.holdLock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if !acquired {
+		r.logger.Debug("another replica holds the reaper lock, skipping size repair")
+		return 0, nil
+	}
+	defer release()
+
+	images, err := r.redis.ListImages(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("listing images: %w", err)
+	}
+	records, err := r.redis.GetManifestRecords(ctx, images)
+	if err != nil {
+		return 0, fmt.Errorf("reading sizes: %w", err)
+	}
+	var unsized []string
+	for image, rec := range records {
+		if rec.SizeBytes == 0 {
+			unsized = append(unsized, image)
+		}
+	}
+	metrics.UnsizedImages.Set(float64(len(unsized)))
+	if len(unsized) == 0 {
+		return 0, nil
+	}
+	slices.Sort(unsized)
+
+	start, _ := slices.BinarySearch(unsized, r.sizeRepairAfter)
+	if start < len(unsized) && unsized[start] == r.sizeRepairAfter {
+		start++
+	}
+	if start >= len(unsized) {
+		start = 0
+	}
+	chunk := unsized[start:min(start+batch, len(unsized))]
+	r.sizeRepairAfter = chunk[len(chunk)-1]
+
+	var repaired int
+	for _, image := range chunk {
+		if err := ctx.Err(); err != nil {
+			return repaired, err
+		}
+		repo, ref, ok := strings.Cut(image, ":")
+		if !ok {
+			continue
+		}
+		digest := records[image].Digest
+		if digest != "" {
+			ref = digest
+		}
+		fetchStart := time.Now()
+		info, err := r.registry.GetImageManifestInfo(ctx, repo, ref)
+		r.observe(fetchStart)
+		if err != nil {
+			r.logger.Debug("could not fetch manifest, keeping size 0", "image", image, "error", err)
+			metrics.SizeRepairs.WithLabelValues("failed").Inc()
+			continue
+		}
+		if info.SizeBytes == 0 {
+			continue
+		}
+		var updated bool
+		if digest != "" {
+			updated, err = r.redis.SetImageSize(ctx, image, digest, info.SizeBytes)
+		} else {
+			digest = info.Digest
+			updated, err = r.redis.SetManifestInfo(ctx, image, info.SizeBytes, digest)
+		}
+		if err != nil {
+			return repaired, fmt.Errorf("updating %s: %w", image, err)
+		}
+		if !updated {
+			continue
+		}
+		r.logger.Info("repaired image size", "image", image, "size_bytes", info.SizeBytes, "digest", digest)
+		metrics.SizeRepairs.WithLabelValues("repaired").Inc()
+		metrics.UnsizedImages.Dec()
+		repaired++
+	}
+	return repaired, nil
+}
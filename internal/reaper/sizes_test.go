@@ -0,0 +1,119 @@
+package reaper
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	"github.com/tamcore/ephemeron/internal/metrics"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+const sizedManifest = `{"schemaVersion":2,` +
+	`"mediaType":"application/vnd.docker.distribution.manifest.v2+json",` +
+	`"config":{"digest":"sha256:cfg","size":100},"layers":[{"digest":"sha256:layer","size":900}]}`
+
+func TestRepairSizes(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/broken/manifests/1h" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", "sha256:abc")
+		_, _ = w.Write([]byte(sizedManifest))
+	}))
+	defer registry.Close()
+
+	store := memstore.New()
+	track(t, store, "app:1h", time.Now().Add(time.Hour))
+	track(t, store, "broken:1h", time.Now().Add(time.Hour))
+	if err := store.TrackImage(t.Context(), "sized:1h", time.Now().Add(time.Hour), 5, "sha256:old",
+		redisclient.ImageMeta{}); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := testutil.ToFloat64(metrics.SizeRepairs.WithLabelValues("failed"))
+	r := New(store, registry.URL, slog.Default())
+	repaired, err := r.RepairSizes(t.Context(), 10)
+	if err != nil || repaired != 1 {
+		t.Fatalf("RepairSizes = %d, %v; want 1, nil", repaired, err)
+	}
+	if size, _ := store.GetImageSize(t.Context(), "app:1h"); size != 1000 {
+		t.Errorf("app:1h size = %d, want 1000", size)
+	}
+	if digest, _ := store.GetImageDigest(t.Context(), "app:1h"); digest != "sha256:abc" {
+		t.Errorf("app:1h digest = %q, want sha256:abc", digest)
+	}
+	if size, _ := store.GetImageSize(t.Context(), "broken:1h"); size != 0 {
+		t.Errorf("broken:1h size = %d, want 0 until the registry answers", size)
+	}
+	if digest, _ := store.GetImageDigest(t.Context(), "sized:1h"); digest != "sha256:old" {
+		t.Errorf("sized:1h digest = %q, want images with a size left alone", digest)
+	}
+	if got := testutil.ToFloat64(metrics.SizeRepairs.WithLabelValues("failed")) - failed; got != 1 {
+		t.Errorf("failed repairs = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.UnsizedImages); got != 1 {
+		t.Errorf("unsized images = %v, want 1", got)
+	}
+}
+
+func TestRepairSizes_FetchesRecordedDigest(t *testing.T) {
+	var paths []string
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Header().Set("Docker-Content-Digest", "sha256:pushed")
+		_, _ = w.Write([]byte(sizedManifest))
+	}))
+	defer registry.Close()
+
+	store := memstore.New()
+	if err := store.TrackImage(t.Context(), "app:main", time.Now().Add(time.Hour), 0, "sha256:pushed",
+		redisclient.ImageMeta{}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(store, registry.URL, slog.Default())
+	if repaired, err := r.RepairSizes(t.Context(), 10); err != nil || repaired != 1 {
+		t.Fatalf("RepairSizes = %d, %v; want 1, nil", repaired, err)
+	}
+	if len(paths) != 1 || paths[0] != "/v2/app/manifests/sha256:pushed" {
+		t.Errorf("expected the manifest to be fetched by its recorded digest, got %v", paths)
+	}
+	if size, _ := store.GetImageSize(t.Context(), "app:main"); size != 1000 {
+		t.Errorf("app:main size = %d, want 1000", size)
+	}
+	if digest, _ := store.GetImageDigest(t.Context(), "app:main"); digest != "sha256:pushed" {
+		t.Errorf("app:main digest = %q, want the recorded one kept", digest)
+	}
+}
+
+func TestRepairSizes_BatchesWithCursor(t *testing.T) {
+	var gets atomic.Int32
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gets.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer registry.Close()
+
+	store := memstore.New()
+	for _, image := range []string{"a:1h", "b:1h", "c:1h"} {
+		track(t, store, image, time.Now().Add(time.Hour))
+	}
+
+	r := New(store, registry.URL, slog.Default())
+	for i, want := range []int{2, 3, 5} {
+		if _, err := r.RepairSizes(t.Context(), 2); err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+		if got := int(gets.Load()); got != want {
+			t.Fatalf("after run %d: expected %d manifest requests, got %d", i, want, got)
+		}
+	}
+}
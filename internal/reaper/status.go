@@ -0,0 +1,83 @@
+package reaper
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// States of the reaper reported by Status.
+const (
+	StateIdle     = "idle"
+	StateRunning  = "running"
+	StateFrozen   = "frozen"
+	StateDegraded = "degraded"
+)
+
+// Status describes the reaper on this replica.
+type Status struct {
+	// State is StateRunning during a cycle, StateDegraded while the store
+	// is unsafe to delete by, StateFrozen while a global freeze suspends
+	// all deletions, and StateIdle otherwise.
+	State string `json:"state"`
+	// Degraded is why the store is unsafe to delete by, empty if it is not.
+	Degraded string `json:"degraded,omitempty"`
+	// DegradedSince is when the store entered or left its current state.
+	DegradedSince *time.Time `json:"degraded_since,omitempty"`
+	// ActiveFreezes is the number of freezes in effect.
+	ActiveFreezes int `json:"active_freezes"`
+	// LastCycle is the most recent cycle this replica ran, if any.
+	LastCycle *Cycle `json:"last_cycle,omitempty"`
+}
+
+// Status reports the state of the reaper on this replica.
+func (r *Reaper) Status(ctx context.Context) (Status, error) {
+	s := Status{State: StateIdle}
+	freezes, err := r.freezes(ctx)
+	if err != nil {
+		return s, err
+	}
+	s.ActiveFreezes = len(freezes)
+	if reason, since := r.storeHealth.get(); reason != "" {
+		s.Degraded, s.DegradedSince = reason, &since
+	}
+	if c, ok := r.history.last(); ok {
+		s.LastCycle = &c
+	}
+	switch {
+	case r.running.Load():
+		s.State = StateRunning
+	case s.Degraded != "":
+		s.State = StateDegraded
+	case globallyFrozen(freezes):
+		s.State = StateFrozen
+	}
+	return s, nil
+}
+
+// globallyFrozen reports whether a freeze covers every repository.
+func globallyFrozen(freezes []redisclient.Freeze) bool {
+	for _, f := range freezes {
+		if f.Pattern == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusHandler serves the reaper Status as JSON.
+func (r *Reaper) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s, err := r.Status(req.Context())
+		if err != nil {
+			r.logger.Error("failed to read reaper status", "error", err)
+			http.Error(w, "status unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s)
+	})
+}
@@ -0,0 +1,155 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+// Results of handling an expired superseded manifest, as the "result" label
+// of the superseded manifests metric.
+const (
+	supersededDeleted = "deleted"
+	supersededTagged  = "tagged"
+	supersededFailed  = "failed"
+)
+
+// dueSuperseded returns the expired superseded cache manifests a run may
+// delete. Those of quarantined, frozen or protected repositories are left.
+func (r *Reaper) dueSuperseded(ctx context.Context, c cycle) ([]redisclient.SupersededManifest, error) {
+	expired, err := r.redis.ExpiredSuperseded(ctx, time.UnixMilli(c.now))
+	if err != nil {
+		return nil, err
+	}
+	var due []redisclient.SupersededManifest
+	for _, m := range expired {
+		if verdict := r.sparedSuperseded(c, m); verdict != "" {
+			r.logger.Debug("skipping superseded manifest",
+				"repository", m.Repository, "digest", m.Digest, "verdict", verdict)
+			continue
+		}
+		due = append(due, m)
+	}
+	return due, nil
+}
+
+// sparedSuperseded reports why m must not be deleted, like spared does
+// for tracked images: VerdictQuarantined, VerdictFrozen, VerdictProtected
+// or "". Untagged, m is matched as "<repo>@<digest>"; a protected pattern
+// covering every tag of its repository, such as "team/*" or
+// "team/app:*", protects it as well.
+func (r *Reaper) sparedSuperseded(c cycle, m redisclient.SupersededManifest) string {
+	image := m.Repository + "@" + m.Digest
+	if _, ok := c.quarantined[image]; ok {
+		return VerdictQuarantined
+	}
+	if frozen(c.freezes, m.Repository) {
+		return VerdictFrozen
+	}
+	if r.protection != nil && (r.protection.Protected(image) || r.protection.Protected(m.Repository+":*")) {
+		return VerdictProtected
+	}
+	return ""
+}
+
+// reapSuperseded deletes by digest the superseded cache manifests that have
+// expired, counting them against the cycle budget after the expired images
+// of res, and tallies them in res. A manifest a tag of its repository
+// points at again is left to that tag; one that cannot be checked or
+// deleted is retried next cycle.
+func (r *Reaper) reapSuperseded(ctx context.Context, c cycle, start time.Time, res *Result) {
+	due, err := r.dueSuperseded(ctx, c)
+	if err != nil {
+		r.logger.Warn("failed to list superseded manifests", "error", err)
+		return
+	}
+	known := make(tagDigests)
+	var attempted int
+	for i, m := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		if reason := r.budgetSpent(res.Attempted+attempted, start); reason != "" {
+			res.Postponed += len(due) - i
+			r.logger.Info("cycle budget spent, postponing superseded manifests to the next cycle",
+				"reason", reason, "postponed", len(due)-i)
+			break
+		}
+		attempted++
+		tagged, err := r.tagged(ctx, known, m.Repository, m.Digest)
+		if err == nil && !tagged {
+			err = r.deleteManifest(ctx, m.Repository, m.Digest)
+		}
+		if err != nil {
+			r.logger.Warn("failed to delete superseded manifest",
+				"repository", m.Repository, "digest", m.Digest, "error", err)
+			metrics.SupersededDeletions.WithLabelValues(supersededFailed).Inc()
+			continue
+		}
+		if err := r.redis.RemoveSuperseded(ctx, m.Repository, m.Digest); err != nil {
+			r.logger.Warn("failed to forget superseded manifest",
+				"repository", m.Repository, "digest", m.Digest, "error", err)
+		}
+		if tagged {
+			r.logger.Debug("superseded manifest is tagged again, keeping it",
+				"repository", m.Repository, "digest", m.Digest)
+			metrics.SupersededDeletions.WithLabelValues(supersededTagged).Inc()
+			continue
+		}
+		r.bury(ctx, redisclient.Tombstone{
+			Image: m.Repository + "@" + m.Digest, Repository: m.Repository, Digest: m.Digest, Reason: ReasonSuperseded,
+		}, nil)
+		r.logger.Info("deleted superseded manifest", "repository", m.Repository, "digest", m.Digest)
+		metrics.SupersededDeletions.WithLabelValues(supersededDeleted).Inc()
+		res.Superseded++
+	}
+}
+
+// tagDigests holds, per repository, the digests its tags resolve to, so a
+// run lists the tags of a repository once however many of its superseded
+// manifests are due.
+type tagDigests map[string]map[string]struct{}
+
+// tagged reports whether a tag of repo resolves to digest. Deleting the
+// manifest by digest would delete that tag too. The tags of repo are
+// resolved on first use and kept in known.
+func (r *Reaper) tagged(ctx context.Context, known tagDigests, repo, digest string) (bool, error) {
+	digests, ok := known[repo]
+	if !ok {
+		var err error
+		if digests, err = r.tagDigests(ctx, repo); err != nil {
+			return false, err
+		}
+		known[repo] = digests
+	}
+	_, ok = digests[digest]
+	return ok, nil
+}
+
+// tagDigests resolves every tag of repo to its manifest digest.
+func (r *Reaper) tagDigests(ctx context.Context, repo string) (map[string]struct{}, error) {
+	start := time.Now()
+	tags, err := r.registry.ListTags(ctx, repo)
+	r.observe(start)
+	digests := make(map[string]struct{})
+	if errors.Is(err, registry.ErrNotFound) {
+		return digests, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		reported, found, err := r.manifestDigest(ctx, repo, tag)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			digests[reported] = struct{}{}
+		}
+	}
+	return digests, nil
+}
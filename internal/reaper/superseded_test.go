@@ -0,0 +1,141 @@
+package reaper
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+func TestReap_Superseded(t *testing.T) {
+	var deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusAccepted)
+		case r.URL.Path == "/v2/ci/cache/tags/list":
+			_, _ = w.Write([]byte(`{"name":"ci/cache","tags":["main"]}`))
+		case r.URL.Path == "/v2/ci/cache/manifests/main":
+			w.Header().Set("Docker-Content-Digest", "sha256:tagged")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	store := memstore.New()
+	for _, m := range []redisclient.SupersededManifest{
+		{Repository: "ci/cache", Digest: "sha256:old", ExpiresAt: time.Now().Add(-time.Minute)},
+		{Repository: "ci/cache", Digest: "sha256:tagged", ExpiresAt: time.Now().Add(-time.Minute)},
+		{Repository: "ci/cache", Digest: "sha256:fresh", ExpiresAt: time.Now().Add(time.Hour)},
+		{Repository: "frozen/cache", Digest: "sha256:old", ExpiresAt: time.Now().Add(-time.Minute)},
+	} {
+		if err := store.AddSuperseded(t.Context(), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	freeze := redisclient.Freeze{Pattern: "frozen/*", Until: time.Now().Add(time.Hour)}
+	if err := store.SetFreeze(t.Context(), freeze); err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(store, srv.URL, slog.New(slog.DiscardHandler))
+	res, err := r.Reap(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/v2/ci/cache/manifests/sha256:old"}; !slices.Equal(deleted, want) {
+		t.Errorf("deleted %v, want %v", deleted, want)
+	}
+	if res.Superseded != 1 {
+		t.Errorf("superseded = %d, want 1", res.Superseded)
+	}
+	left, _ := store.ExpiredSuperseded(t.Context(), time.Now().Add(2*time.Hour))
+	var digests []string
+	for _, m := range left {
+		digests = append(digests, m.Repository+"@"+m.Digest)
+	}
+	slices.Sort(digests)
+	if want := []string{"ci/cache@sha256:fresh", "frozen/cache@sha256:old"}; !slices.Equal(digests, want) {
+		t.Errorf("left %v, want the unexpired and the frozen manifests", digests)
+	}
+}
+
+func TestReap_SupersededWithinBudget(t *testing.T) {
+	var deleted []string
+	var listings int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusAccepted)
+		case r.URL.Path == "/v2/ci/cache/tags/list":
+			listings++
+			_, _ = w.Write([]byte(`{"name":"ci/cache","tags":["main"]}`))
+		case r.URL.Path == "/v2/ci/cache/manifests/main":
+			w.Header().Set("Docker-Content-Digest", "sha256:tagged")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	store := memstore.New()
+	for i, m := range []redisclient.SupersededManifest{
+		{Repository: "ci/cache", Digest: "sha256:a"},
+		{Repository: "ci/cache", Digest: "sha256:b"},
+		{Repository: "ci/cache", Digest: "sha256:c"},
+		{Repository: "prod/cache", Digest: "sha256:a"},
+	} {
+		m.ExpiresAt = time.Now().Add(-time.Duration(i+1) * time.Minute)
+		if err := store.AddSuperseded(t.Context(), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := New(store, srv.URL, slog.New(slog.DiscardHandler),
+		WithCycleBudget(2, 0),
+		WithTombstones(time.Hour),
+		WithProtection(protectionFunc(func(image string) bool {
+			ok, _ := path.Match("prod/*", image)
+			return ok
+		})),
+	)
+	res, err := r.Reap(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 2 || res.Superseded != 2 || res.Postponed != 1 {
+		t.Errorf("deleted %v, superseded %d, postponed %d; want 2 deleted, 1 postponed",
+			deleted, res.Superseded, res.Postponed)
+	}
+	if listings != 1 {
+		t.Errorf("listed tags %d times, want once per repository", listings)
+	}
+	for _, p := range deleted {
+		if strings.HasPrefix(p, "/v2/prod/") {
+			t.Errorf("deleted %s of a protected repository", p)
+		}
+	}
+
+	tombstones, err := store.ListTombstones(t.Context(), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tombstones) != 2 {
+		t.Fatalf("tombstones = %+v, want 2", tombstones)
+	}
+	for _, ts := range tombstones {
+		if ts.Reason != ReasonSuperseded || ts.Repository != "ci/cache" || ts.Tag != "" || ts.Digest == "" {
+			t.Errorf("tombstone %+v, want a superseded ci/cache digest", ts)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package reaper
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+)
+
+// SweepLoop verifies a batch of tracked images against the registry every
+// interval, dropping ghost records whose manifests were removed outside
+// ephemeron (e.g. by registry garbage collection). It blocks until ctx is
+// cancelled.
+func (r *Reaper) SweepLoop(ctx context.Context, interval time.Duration, batch int) {
+	r.logger.Info("starting ghost record sweeper", "interval", interval.String(), "batch", batch)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.Sweep(ctx, batch); err != nil {
+				r.logger.Error("ghost sweep failed", "error", err)
+			}
+		}
+	}
+}
+
+// Sweep HEADs up to batch tracked manifests, continuing where the previous
+// call stopped, and stops tracking those the registry no longer has. It
+// returns the number of records removed. Unreachable registries and other
+// errors leave records untouched.
+func (r *Reaper) Sweep(ctx context.Context, batch int) (int, error) {
+	ctx, release, acquired, err := r.holdLock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if !acquired {
+		r.logger.Debug("another replica holds the reaper lock, skipping sweep")
+		return 0, nil
+	}
+	defer release()
+
+	images, err := r.redis.ListImages(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("listing images: %w", err)
+	}
+	if len(images) == 0 {
+		return 0, nil
+	}
+	slices.Sort(images)
+
+	if r.sweepCursor >= len(images) {
+		r.sweepCursor = 0
+	}
+	end := min(r.sweepCursor+batch, len(images))
+	chunk := images[r.sweepCursor:end]
+	r.sweepCursor = end
+
+	var removed int
+	for _, image := range chunk {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		repo, tag, ok := strings.Cut(image, ":")
+		if !ok {
+			continue
+		}
+		_, found, err := r.manifestDigest(ctx, repo, tag)
+		if err != nil {
+			r.logger.Debug("could not verify image, keeping record", "image", image, "error", err)
+			continue
+		}
+		if found {
+			continue
+		}
+		if err := r.redis.RemoveImage(ctx, image); err != nil {
+			return removed, fmt.Errorf("removing %s: %w", image, err)
+		}
+		r.logger.Info("removed ghost record, manifest missing from registry", "image", image)
+		metrics.GhostRecordsRemoved.Inc()
+		metrics.TrackedImagesGauge.Dec()
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,85 @@
+package reaper
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+)
+
+func TestSweep_RemovesGhostRecords(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/gone/manifests/1h" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", "sha256:abc")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer registry.Close()
+
+	store := memstore.New()
+	track(t, store, "gone:1h", time.Now().Add(time.Hour))
+	track(t, store, "kept:1h", time.Now().Add(time.Hour))
+
+	r := New(store, registry.URL, slog.Default())
+	removed, err := r.Sweep(t.Context(), 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 ghost record removed, got %d", removed)
+	}
+	if tracked(store, "gone:1h") {
+		t.Error("expected gone:1h to be removed")
+	}
+	if !tracked(store, "kept:1h") {
+		t.Error("expected kept:1h to remain tracked")
+	}
+}
+
+func TestSweep_BatchesWithCursor(t *testing.T) {
+	var heads atomic.Int32
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		heads.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer registry.Close()
+
+	store := memstore.New()
+	for _, image := range []string{"a:1h", "b:1h", "c:1h"} {
+		track(t, store, image, time.Now().Add(time.Hour))
+	}
+
+	r := New(store, registry.URL, slog.Default())
+	for i, want := range []int{2, 3, 5} {
+		if _, err := r.Sweep(t.Context(), 2); err != nil {
+			t.Fatalf("sweep %d: %v", i, err)
+		}
+		if got := int(heads.Load()); got != want {
+			t.Fatalf("after sweep %d: expected %d HEAD requests, got %d", i, want, got)
+		}
+	}
+}
+
+func TestSweep_KeepsRecordsOnRegistryError(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer registry.Close()
+
+	store := memstore.New()
+	track(t, store, "myapp:1h", time.Now().Add(time.Hour))
+
+	r := New(store, registry.URL, slog.Default())
+	if removed, err := r.Sweep(t.Context(), 10); err != nil || removed != 0 {
+		t.Fatalf("Sweep = %d, %v; want 0, nil", removed, err)
+	}
+	if !tracked(store, "myapp:1h") {
+		t.Error("expected record to be kept when the registry errors")
+	}
+}
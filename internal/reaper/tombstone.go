@@ -0,0 +1,78 @@
+package reaper
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+// Reasons recorded in tombstones for why an image was deleted.
+const (
+	ReasonExpired  = "expired"
+	ReasonEvicted  = "evicted"
+	ReasonApproved = "approved"
+	// ReasonSuperseded marks a superseded cache manifest deleted by
+	// digest, recorded as "<repo>@<digest>" without a tag.
+	ReasonSuperseded = "superseded"
+)
+
+// WithTombstones keeps a tombstone of every deleted image for retention,
+// listed by TombstonesHandler. Zero disables tombstones.
+func WithTombstones(retention time.Duration) Option {
+	return func(r *Reaper) {
+		r.tombstoneRetention = retention
+	}
+}
+
+// bury records the tombstone t of a deleted image, with manifest if it was
+// kept for restore. Failures are logged; the image is gone either way.
+func (r *Reaper) bury(ctx context.Context, t redisclient.Tombstone, manifest *registry.RawManifest) {
+	if r.tombstoneRetention <= 0 {
+		return
+	}
+	if t.Repository == "" {
+		t.Repository, t.Tag, _ = strings.Cut(t.Image, ":")
+	}
+	t.ReapedAt = time.Now().UTC()
+	if manifest != nil {
+		t.MediaType = manifest.MediaType
+		if r.restore {
+			t.Manifest = manifest.Body
+		}
+	}
+	if err := r.redis.AddTombstone(ctx, t, t.ReapedAt.Add(-r.tombstoneRetention)); err != nil {
+		r.logger.Warn("failed to record tombstone", "image", t.Image, "error", err)
+	}
+}
+
+// TombstonesHandler lists the tombstones within the retention window, most
+// recent first. The "repository" and "tag" query parameters filter them.
+func (r *Reaper) TombstonesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		list, err := r.redis.ListTombstones(req.Context(), time.Now().Add(-r.tombstoneRetention))
+		if err != nil {
+			r.logger.Error("failed to list tombstones", "error", err)
+			http.Error(w, "tombstones unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		repo, tag := req.URL.Query().Get("repository"), req.URL.Query().Get("tag")
+		list = slices.DeleteFunc(list, func(t redisclient.Tombstone) bool {
+			return (repo != "" && t.Repository != repo) || (tag != "" && t.Tag != tag)
+		})
+		slices.Reverse(list)
+		if list == nil {
+			list = []redisclient.Tombstone{}
+		}
+		for i := range list {
+			list[i].Manifest = nil
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(list)
+	})
+}
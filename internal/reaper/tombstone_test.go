@@ -0,0 +1,65 @@
+package reaper
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+func TestTombstones(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer registry.Close()
+
+	store := memstore.New()
+	err := store.TrackImage(t.Context(), "app:1h", time.Now().Add(-time.Hour), 2048, "sha256:abc",
+		redisclient.ImageMeta{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	track(t, store, "other:1h", time.Now().Add(-time.Hour))
+
+	r := New(store, registry.URL, slog.New(slog.DiscardHandler), WithTombstones(time.Hour))
+	if err := r.ReapOnce(t.Context()); err != nil {
+		t.Fatalf("ReapOnce: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	r.TombstonesHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/api/tombstones?repository=app", nil))
+	var list []redisclient.Tombstone
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatalf("decoding list: %v", err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("list = %+v, want app:1h", list)
+	}
+	got := list[0]
+	if got.Image != "app:1h" || got.Tag != "1h" || got.Digest != "sha256:abc" || got.SizeBytes != 2048 ||
+		got.Reason != ReasonExpired || got.ReapedAt.IsZero() {
+		t.Errorf("tombstone = %+v", got)
+	}
+}
+
+func TestTombstones_Disabled(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer registry.Close()
+
+	store := memstore.New()
+	track(t, store, "app:1h", time.Now().Add(-time.Hour))
+	r := New(store, registry.URL, slog.New(slog.DiscardHandler))
+	if err := r.ReapOnce(t.Context()); err != nil {
+		t.Fatalf("ReapOnce: %v", err)
+	}
+	if list, _ := store.ListTombstones(t.Context(), time.Time{}); len(list) != 0 {
+		t.Errorf("expected no tombstones, got %+v", list)
+	}
+}
@@ -0,0 +1,340 @@
+package recover
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+)
+
+// Diff compares the tags in the registry with the images being tracked.
+type Diff struct {
+	// At is when the comparison was made.
+	At time.Time `json:"at" yaml:"at"`
+	// Untracked lists registry tags that have no tracking record and will
+	// never expire.
+	Untracked []string `json:"untracked" yaml:"untracked"`
+	// Ghosts lists tracking records whose tag is missing from the registry.
+	Ghosts []string `json:"ghosts" yaml:"ghosts"`
+	// SkippedRepositories lists repositories whose tags could not be listed
+	// and were left out of the comparison.
+	SkippedRepositories []string `json:"skipped_repositories,omitempty" yaml:"skipped_repositories,omitempty"`
+	// After and Through bound the repositories the latest run compared when
+	// reconciling in batches: those after After up to and including Through,
+	// or to the end of the catalog if Through is empty. Entries of other
+	// repositories are carried over from earlier runs.
+	After   string `json:"after,omitempty" yaml:"after,omitempty"`
+	Through string `json:"through,omitempty" yaml:"through,omitempty"`
+}
+
+// covers reports whether the latest run compared repo.
+func (d *Diff) covers(repo string) bool {
+	return repo > d.After && (d.Through == "" || repo <= d.Through)
+}
+
+// merge carries over the entries of prev for repositories d did not cover.
+func (d *Diff) merge(prev *Diff) {
+	keep := func(list []string, repoOf func(string) string) []string {
+		var out []string
+		for _, entry := range list {
+			if !d.covers(repoOf(entry)) {
+				out = append(out, entry)
+			}
+		}
+		return out
+	}
+	image := func(image string) string {
+		repo, _, _ := strings.Cut(image, ":")
+		return repo
+	}
+	repository := func(repo string) string { return repo }
+
+	d.Untracked = append(d.Untracked, keep(prev.Untracked, image)...)
+	d.Ghosts = append(d.Ghosts, keep(prev.Ghosts, image)...)
+	d.SkippedRepositories = append(d.SkippedRepositories, keep(prev.SkippedRepositories, repository)...)
+	sort.Strings(d.Untracked)
+	sort.Strings(d.Ghosts)
+	sort.Strings(d.SkippedRepositories)
+}
+
+// ErrDiffRunning is returned by Diff while another reconcile run is in
+// progress.
+var ErrDiffRunning = errors.New("reconcile already running")
+
+// Diff walks the registry catalog, or its next batch of repositories, and
+// the store and returns the tags present in only one of them. Tags are listed
+// by up to the configured number of repositories at once. It also updates the
+// reconcile gauges and becomes the diff served by DiffHandler. An interrupted
+// run leaves a checkpoint the next one resumes from.
+func (r *Runner) Diff(ctx context.Context) (*Diff, error) {
+	if !r.diffing.TryLock() {
+		return nil, ErrDiffRunning
+	}
+	defer r.diffing.Unlock()
+
+	now := time.Now()
+	r.updateProgress(func(p *Progress) { *p = Progress{Running: true, StartedAt: &now} })
+	d, err := r.diff(ctx, now)
+	finished := time.Now()
+	r.updateProgress(func(p *Progress) {
+		p.Running, p.FinishedAt = false, &finished
+		if err != nil {
+			p.Error = err.Error()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	if r.batchSize > 0 && r.latest != nil {
+		d.merge(r.latest)
+	}
+	r.latest = d
+	r.mu.Unlock()
+
+	metrics.ReconcileUntrackedTags.Set(float64(len(d.Untracked)))
+	metrics.ReconcileGhostRecords.Set(float64(len(d.Ghosts)))
+	return d, nil
+}
+
+func (r *Runner) diff(ctx context.Context, now time.Time) (*Diff, error) {
+	d := &Diff{At: now, Untracked: []string{}, Ghosts: []string{}}
+	repos, err := r.batch(ctx, d)
+	if err != nil {
+		return nil, fmt.Errorf("listing repositories: %w", err)
+	}
+	images, err := r.redis.ListImages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing images: %w", err)
+	}
+
+	tracked := make(map[string]struct{}, len(images))
+	for _, image := range images {
+		tracked[image] = struct{}{}
+	}
+
+	resumed, startedAt := r.resume(ctx, now)
+	listed := make(map[string][]string, len(repos))
+	var pending []string
+	for _, repo := range repos {
+		if tags, ok := resumed[repo]; ok {
+			listed[repo] = tags
+		} else {
+			pending = append(pending, repo)
+		}
+	}
+	r.updateProgress(func(p *Progress) {
+		p.Repositories, p.Listed, p.Resumed = len(repos), len(listed), len(listed)
+	})
+
+	skipped := r.listTags(ctx, pending, startedAt, listed)
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("reconcile interrupted: %w", err)
+	}
+	if r.resumeWindow > 0 {
+		if err := r.redis.ClearReconcileProgress(ctx); err != nil {
+			r.logger.Warn("failed to clear reconcile checkpoint", "error", err)
+		}
+	}
+	if r.batchSize > 0 {
+		if err := r.redis.SetReconcileCursor(ctx, d.Through); err != nil {
+			r.logger.Warn("failed to store reconcile cursor", "error", err)
+		}
+	}
+
+	inRegistry := make(map[string]struct{})
+	for repo, tags := range listed {
+		for _, tag := range tags {
+			image := repo + ":" + tag
+			inRegistry[image] = struct{}{}
+			if _, ok := tracked[image]; !ok && !r.untracked(repo, tag) {
+				d.Untracked = append(d.Untracked, image)
+			}
+		}
+	}
+	for _, image := range images {
+		if _, ok := inRegistry[image]; ok {
+			continue
+		}
+		repo, _, _ := strings.Cut(image, ":")
+		if _, ok := skipped[repo]; ok || !d.covers(repo) {
+			continue
+		}
+		d.Ghosts = append(d.Ghosts, image)
+	}
+	for repo := range skipped {
+		d.SkippedRepositories = append(d.SkippedRepositories, repo)
+	}
+	sort.Strings(d.Untracked)
+	sort.Strings(d.Ghosts)
+	sort.Strings(d.SkippedRepositories)
+	return d, nil
+}
+
+// batch returns the repositories to compare: the whole catalog, or with a
+// batch size the next batch after the stored cursor, whose bounds it records
+// in d.
+func (r *Runner) batch(ctx context.Context, d *Diff) ([]string, error) {
+	if r.batchSize <= 0 {
+		return r.registry.ListRepositories(ctx)
+	}
+	after, err := r.redis.ReconcileCursor(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("loading reconcile cursor: %w", err)
+	}
+	repos, through, err := r.registry.ListRepositoriesAfter(ctx, after, r.batchSize)
+	if err != nil {
+		return nil, err
+	}
+	d.After, d.Through = after, through
+	return repos, nil
+}
+
+// listTags lists the tags of repos concurrently into listed, checkpointing
+// each repository, and returns the repositories that failed. It stops early
+// when ctx is cancelled.
+func (r *Runner) listTags(
+	ctx context.Context,
+	repos []string,
+	startedAt time.Time,
+	listed map[string][]string,
+) map[string]struct{} {
+	var mu sync.Mutex
+	skipped := make(map[string]struct{})
+	r.eachTagList(ctx, repos, func(repo string, tags []string) {
+		if r.resumeWindow > 0 {
+			if err := r.redis.AddReconcileProgress(ctx, startedAt, repo, tags); err != nil {
+				r.logger.Warn("failed to checkpoint reconcile progress", "repo", repo, "error", err)
+			}
+		}
+		mu.Lock()
+		listed[repo] = tags
+		mu.Unlock()
+		r.updateProgress(func(p *Progress) { p.Listed++ })
+	}, func(repo string, err error) {
+		r.logger.Warn("failed to list tags, leaving repo out of diff", "repo", repo, "error", err)
+		mu.Lock()
+		skipped[repo] = struct{}{}
+		mu.Unlock()
+		r.updateProgress(func(p *Progress) { p.Skipped++ })
+	})
+	return skipped
+}
+
+// eachTagList lists the tags of repos with up to r.concurrency workers and at
+// most r.tagRate listings per second, calling listed with the tags of each
+// repository or failed with the error. Both are called concurrently. It
+// stops early when ctx is cancelled, without calling failed for the
+// repositories left.
+func (r *Runner) eachTagList(
+	ctx context.Context,
+	repos []string,
+	listed func(repo string, tags []string),
+	failed func(repo string, err error),
+) {
+	pace := newPacer(r.tagRate)
+	defer pace.stop()
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for range min(r.concurrency, len(repos)) {
+		wg.Go(func() {
+			for repo := range jobs {
+				if pace.wait(ctx) != nil {
+					continue
+				}
+				tags, err := r.registry.ListTags(ctx, repo)
+				switch {
+				case err == nil:
+					listed(repo, tags)
+				case ctx.Err() == nil:
+					failed(repo, err)
+				}
+			}
+		})
+	}
+feed:
+	for _, repo := range repos {
+		select {
+		case jobs <- repo:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// Start recovers the store if it was never initialized, see RunIfNeeded,
+// and then, with a positive interval, reconciles every interval in the
+// background until ctx is cancelled.
+func (r *Runner) Start(ctx context.Context, interval time.Duration) {
+	if err := r.RunIfNeeded(ctx); err != nil {
+		r.logger.Error("auto-recovery failed", "error", err)
+	}
+	if interval > 0 {
+		go r.ReconcileLoop(ctx, interval)
+	}
+}
+
+// ReconcileLoop compares the registry and the store immediately and then at
+// the given interval. It blocks until the context is cancelled.
+func (r *Runner) ReconcileLoop(ctx context.Context, interval time.Duration) {
+	r.logger.Info("starting reconcile loop", "interval", interval.String())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if d, err := r.Diff(ctx); err != nil {
+			if ctx.Err() == nil {
+				r.logger.Error("reconcile diff failed", "error", err)
+			}
+		} else {
+			r.logger.Info("reconcile diff complete",
+				"untracked", len(d.Untracked),
+				"ghosts", len(d.Ghosts),
+				"skipped_repositories", len(d.SkippedRepositories),
+			)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DiffHandler serves the latest diff as JSON, computing one if none exists
+// yet and no run is in progress.
+func (r *Runner) DiffHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		d := r.latest
+		r.mu.Unlock()
+		if d == nil {
+			var err error
+			d, err = r.Diff(req.Context())
+			switch {
+			case errors.Is(err, ErrDiffRunning):
+				http.Error(w, "first reconcile still running, see /v1/api/reconcile/status",
+					http.StatusServiceUnavailable)
+				return
+			case err != nil:
+				r.logger.Error("failed to compute reconcile diff", "error", err)
+				http.Error(w, "diff unavailable", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(d)
+	})
+}
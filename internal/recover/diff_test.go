@@ -0,0 +1,256 @@
+package recover
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+	"github.com/tamcore/ephemeron/internal/registry"
+)
+
+func TestDiff(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/_catalog":
+			_, _ = w.Write([]byte(`{"repositories":["app","broken"]}`))
+		case "/v2/app/tags/list":
+			_, _ = w.Write([]byte(`{"name":"app","tags":["1h","manual"]}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	store := memstore.New()
+	expires := time.Now().Add(time.Hour)
+	for _, image := range []string{"app:1h", "app:2h", "broken:1h", "gone:1h"} {
+		if err := store.TrackImage(t.Context(), image, expires, 0, "", redisclient.ImageMeta{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := New(store, registry.New(srv.URL), time.Hour, 24*time.Hour, slog.Default())
+	d, err := r.Diff(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(d.Untracked, []string{"app:manual"}) {
+		t.Errorf("untracked = %v, want [app:manual]", d.Untracked)
+	}
+	if !slices.Equal(d.Ghosts, []string{"app:2h", "gone:1h"}) {
+		t.Errorf("ghosts = %v, want [app:2h gone:1h]", d.Ghosts)
+	}
+	if !slices.Equal(d.SkippedRepositories, []string{"broken"}) {
+		t.Errorf("skipped = %v, want [broken]", d.SkippedRepositories)
+	}
+
+	rr := httptest.NewRecorder()
+	r.DiffHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/api/reconcile/diff", nil))
+	var served Diff
+	if err := json.NewDecoder(rr.Body).Decode(&served); err != nil {
+		t.Fatalf("decoding diff: %v", err)
+	}
+	if !slices.Equal(served.Ghosts, d.Ghosts) {
+		t.Errorf("served ghosts = %v, want %v", served.Ghosts, d.Ghosts)
+	}
+}
+
+func TestDiff_Concurrent(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/_catalog" {
+			repos := make([]string, 20)
+			for i := range repos {
+				repos[i] = fmt.Sprintf("repo%02d", i)
+			}
+			_ = json.NewEncoder(w).Encode(map[string][]string{"repositories": repos})
+			return
+		}
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(5 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"tags":["1h"]}`))
+	}))
+	defer srv.Close()
+
+	r := New(memstore.New(), registry.New(srv.URL), time.Hour, 24*time.Hour, slog.New(slog.DiscardHandler),
+		WithConcurrency(4))
+	d, err := r.Diff(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(d.Untracked) != 20 || d.Untracked[0] != "repo00:1h" {
+		t.Errorf("expected 20 sorted untracked tags, got %v", d.Untracked)
+	}
+	if p := peak.Load(); p > 4 || p < 2 {
+		t.Errorf("expected up to 4 concurrent tag listings, got %d", p)
+	}
+	p := r.Progress()
+	if p.Running || p.Repositories != 20 || p.Listed != 20 || p.FinishedAt == nil {
+		t.Errorf("unexpected progress %+v", p)
+	}
+}
+
+func TestDiff_Resume(t *testing.T) {
+	listed := make(map[string]int)
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/_catalog":
+			_, _ = w.Write([]byte(`{"repositories":["app","web"]}`))
+		default:
+			mu.Lock()
+			listed[r.URL.Path]++
+			mu.Unlock()
+			_, _ = w.Write([]byte(`{"tags":["now"]}`))
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		startedAt time.Duration // before now
+		want      []string
+		resumed   int
+	}{
+		{"recent checkpoint", 10 * time.Minute, []string{"app:then", "web:now"}, 1},
+		{"stale checkpoint", 2 * time.Hour, []string{"app:now", "web:now"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clear(listed)
+			store := memstore.New()
+			err := store.AddReconcileProgress(t.Context(), time.Now().Add(-tt.startedAt), "app", []string{"then"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := New(store, registry.New(srv.URL), time.Hour, 24*time.Hour, slog.New(slog.DiscardHandler),
+				WithResumeWindow(time.Hour))
+			d, err := r.Diff(t.Context())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(d.Untracked, tt.want) {
+				t.Errorf("untracked = %v, want %v", d.Untracked, tt.want)
+			}
+			if got := listed["/v2/app/tags/list"]; got != 1-tt.resumed {
+				t.Errorf("expected app to be listed %d times, got %d", 1-tt.resumed, got)
+			}
+			if p := r.Progress(); p.Resumed != tt.resumed || p.Listed != 2 {
+				t.Errorf("unexpected progress %+v", p)
+			}
+			if cp, _ := store.ReconcileProgress(t.Context()); !cp.StartedAt.IsZero() {
+				t.Errorf("expected checkpoint to be cleared, got %+v", cp)
+			}
+		})
+	}
+}
+
+func TestDiff_Interrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/_catalog":
+			_, _ = w.Write([]byte(`{"repositories":["app","web"]}`))
+		case "/v2/app/tags/list":
+			_, _ = w.Write([]byte(`{"tags":["1h"]}`))
+		default:
+			cancel()
+			<-r.Context().Done()
+		}
+	}))
+	defer srv.Close()
+
+	store := memstore.New()
+	r := New(store, registry.New(srv.URL), time.Hour, 24*time.Hour, slog.New(slog.DiscardHandler),
+		WithResumeWindow(time.Hour))
+	if _, err := r.Diff(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+	cp, err := store.ReconcileProgress(t.Context())
+	if err != nil || !slices.Equal(cp.Tags["app"], []string{"1h"}) || len(cp.Tags) != 1 {
+		t.Errorf("expected checkpoint of app, got %+v, %v", cp, err)
+	}
+
+	rr := httptest.NewRecorder()
+	r.StatusHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/api/reconcile/status", nil))
+	var p Progress
+	if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+		t.Fatalf("decoding status: %v", err)
+	}
+	if p.Running || p.Listed != 1 || p.Error == "" {
+		t.Errorf("unexpected status %+v", p)
+	}
+}
+
+func TestDiff_Batches(t *testing.T) {
+	catalog := []string{"a", "b", "c"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/_catalog":
+			n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+			i := 0
+			if last := r.URL.Query().Get("last"); last != "" {
+				i = slices.Index(catalog, last) + 1
+			}
+			page := catalog[i:min(i+n, len(catalog))]
+			if i+n < len(catalog) {
+				w.Header().Set("Link", fmt.Sprintf(`</v2/_catalog?n=%d&last=%s>; rel="next"`, n, page[len(page)-1]))
+			}
+			_ = json.NewEncoder(w).Encode(map[string][]string{"repositories": page})
+		case "/v2/b/tags/list":
+			_, _ = w.Write([]byte(`{"tags":["manual"]}`))
+		default:
+			_, _ = w.Write([]byte(`{"tags":["1h"]}`))
+		}
+	}))
+	defer srv.Close()
+
+	store := memstore.New()
+	expires := time.Now().Add(time.Hour)
+	for _, image := range []string{"a:1h", "b:1h", "gone:1h"} {
+		if err := store.TrackImage(t.Context(), image, expires, 0, "", redisclient.ImageMeta{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := New(store, registry.New(srv.URL), time.Hour, 24*time.Hour, slog.New(slog.DiscardHandler),
+		WithBatchSize(2))
+
+	runs := []struct {
+		after, through string
+		untracked      []string
+		ghosts         []string
+	}{
+		// "gone" sorts after "c", so only the last batch covers it.
+		{"", "b", []string{"b:manual"}, []string{"b:1h"}},
+		{"b", "", []string{"b:manual", "c:1h"}, []string{"b:1h", "gone:1h"}},
+		{"", "b", []string{"b:manual", "c:1h"}, []string{"b:1h", "gone:1h"}},
+	}
+	for i, want := range runs {
+		d, err := r.Diff(t.Context())
+		if err != nil {
+			t.Fatalf("run %d: unexpected error: %v", i, err)
+		}
+		if d.After != want.after || d.Through != want.through {
+			t.Errorf("run %d: covered (%q, %q], want (%q, %q]", i, d.After, d.Through, want.after, want.through)
+		}
+		if !slices.Equal(d.Untracked, want.untracked) || !slices.Equal(d.Ghosts, want.ghosts) {
+			t.Errorf("run %d: untracked %v, ghosts %v; want %v, %v", i, d.Untracked, d.Ghosts,
+				want.untracked, want.ghosts)
+		}
+	}
+}
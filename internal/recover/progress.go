@@ -0,0 +1,141 @@
+package recover
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// WithConcurrency lists the tags of up to n repositories at once while
+// recovering or reconciling. Values below one list them one at a time.
+func WithConcurrency(n int) Option {
+	return func(r *Runner) {
+		r.concurrency = max(n, 1)
+	}
+}
+
+// WithTagListRate spaces out tag listings to at most perSecond across all
+// workers, to spare the registry. Zero does not limit them.
+func WithTagListRate(perSecond float64) Option {
+	return func(r *Runner) {
+		r.tagRate = perSecond
+	}
+}
+
+// WithResumeWindow checkpoints each listed repository in the store, so a
+// reconcile run that was interrupted less than window ago resumes where it
+// stopped. Zero disables checkpoints.
+func WithResumeWindow(window time.Duration) Option {
+	return func(r *Runner) {
+		r.resumeWindow = window
+	}
+}
+
+// WithBatchSize makes each reconcile run compare only the next n
+// repositories of the catalog, continuing after the last one of the previous
+// run and starting over at the end, so full coverage is spread over several
+// runs. The served diff carries over the entries of the other repositories.
+// It relies on the registry listing its catalog in lexical order, as the
+// registry API specifies. Zero compares the whole catalog every run.
+func WithBatchSize(n int) Option {
+	return func(r *Runner) {
+		r.batchSize = n
+	}
+}
+
+// Progress reports how far the current or last reconcile run got.
+type Progress struct {
+	Running    bool       `json:"running"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Repositories is the number of repositories the run compares: the
+	// catalog or the current batch of it.
+	Repositories int `json:"repositories"`
+	// Listed counts the repositories whose tags were listed, including
+	// those Resumed from the checkpoint of an interrupted run.
+	Listed  int `json:"listed"`
+	Resumed int `json:"resumed"`
+	// Skipped counts the repositories whose tags could not be listed.
+	Skipped int `json:"skipped"`
+	// Error is why the last run failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Progress returns the progress of the current or last reconcile run.
+func (r *Runner) Progress() Progress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.progress
+}
+
+// StatusHandler serves the progress of the current or last reconcile run
+// as JSON.
+func (r *Runner) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(r.Progress())
+	})
+}
+
+func (r *Runner) updateProgress(update func(p *Progress)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	update(&r.progress)
+}
+
+// resume returns the tags a run interrupted within the resume window
+// already listed, and when that run started. Older checkpoints are dropped.
+func (r *Runner) resume(ctx context.Context, now time.Time) (map[string][]string, time.Time) {
+	if r.resumeWindow <= 0 {
+		return nil, now
+	}
+	cp, err := r.redis.ReconcileProgress(ctx)
+	if err != nil {
+		r.logger.Warn("failed to load reconcile checkpoint, starting over", "error", err)
+		return nil, now
+	}
+	if cp.StartedAt.IsZero() {
+		return nil, now
+	}
+	if now.Sub(cp.StartedAt) > r.resumeWindow {
+		if err := r.redis.ClearReconcileProgress(ctx); err != nil {
+			r.logger.Warn("failed to clear stale reconcile checkpoint", "error", err)
+		}
+		return nil, now
+	}
+	r.logger.Info("resuming interrupted reconcile", "started_at", cp.StartedAt, "repositories", len(cp.Tags))
+	return cp.Tags, cp.StartedAt
+}
+
+// pacer spaces out calls to at most rate per second. A zero rate does not
+// limit them.
+type pacer struct {
+	ticker *time.Ticker
+}
+
+func newPacer(rate float64) *pacer {
+	if rate <= 0 {
+		return &pacer{}
+	}
+	return &pacer{ticker: time.NewTicker(time.Duration(float64(time.Second) / rate))}
+}
+
+// wait blocks until the next call may start or ctx is cancelled.
+func (p *pacer) wait(ctx context.Context) error {
+	if p.ticker == nil {
+		return ctx.Err()
+	}
+	select {
+	case <-p.ticker.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *pacer) stop() {
+	if p.ticker != nil {
+		p.ticker.Stop()
+	}
+}
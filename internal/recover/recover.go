@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/tamcore/ephemeron/internal/hooks"
@@ -18,6 +19,37 @@ type Runner struct {
 	defaultTTL time.Duration
 	maxTTL     time.Duration
 	logger     *slog.Logger
+
+	// concurrency and tagRate bound tag listings of recovery and reconcile
+	// runs, resumeWindow and batchSize tune reconcile runs; see
+	// WithConcurrency, WithTagListRate, WithResumeWindow and WithBatchSize.
+	concurrency  int
+	tagRate      float64
+	resumeWindow time.Duration
+	batchSize    int
+
+	// nonTTLTags is the action for tags that are not TTLs; see
+	// WithNonTTLTags.
+	nonTTLTags func(repo string) string
+
+	// diffing is held by the reconcile run in progress.
+	diffing sync.Mutex
+
+	mu       sync.Mutex
+	latest   *Diff
+	progress Progress
+}
+
+// Option configures a Runner.
+type Option func(*Runner)
+
+// WithNonTTLTags leaves tags that are not TTLs untracked, and out of the
+// reconcile diff, in repositories for which action returns
+// hooks.NonTTLIgnore or hooks.NonTTLReject, as the webhook does.
+func WithNonTTLTags(action func(repo string) string) Option {
+	return func(r *Runner) {
+		r.nonTTLTags = action
+	}
 }
 
 // New creates a new recovery runner.
@@ -26,81 +58,114 @@ func New(
 	registry *registry.Client,
 	defaultTTL, maxTTL time.Duration,
 	logger *slog.Logger,
+	opts ...Option,
 ) *Runner {
-	return &Runner{
-		redis:      redis,
-		registry:   registry,
-		defaultTTL: defaultTTL,
-		maxTTL:     maxTTL,
-		logger:     logger,
+	r := &Runner{
+		redis:       redis,
+		registry:    registry,
+		defaultTTL:  defaultTTL,
+		maxTTL:      maxTTL,
+		logger:      logger,
+		concurrency: 1,
+	}
+	for _, opt := range opts {
+		opt(r)
 	}
+	return r
+}
+
+// Result summarizes a recovery run.
+type Result struct {
+	Repositories int   `json:"repositories" yaml:"repositories"`
+	Recovered    int   `json:"recovered" yaml:"recovered"`
+	TotalBytes   int64 `json:"total_bytes" yaml:"total_bytes"`
 }
 
 // Run scans the registry catalog, parses TTLs from tags, and re-populates
 // Redis with tracking data. It is idempotent — re-tracking an already-tracked
 // image simply overwrites its metadata.
 func (r *Runner) Run(ctx context.Context) error {
+	_, err := r.Recover(ctx)
+	return err
+}
+
+// Recover behaves like Run but also returns a summary of the recovery.
+func (r *Runner) Recover(ctx context.Context) (Result, error) {
+	var res Result
+
 	repos, err := r.registry.ListRepositories(ctx)
 	if err != nil {
-		return fmt.Errorf("listing repositories: %w", err)
+		return res, fmt.Errorf("listing repositories: %w", err)
 	}
+	res.Repositories = len(repos)
 
 	r.logger.Info("starting recovery", "repositories", len(repos))
 
-	var recovered int
-	var totalBytes int64
-	for _, repo := range repos {
-		tags, err := r.registry.ListTags(ctx, repo)
-		if err != nil {
-			r.logger.Warn("failed to list tags, skipping repo", "repo", repo, "error", err)
-			continue
-		}
-
+	var mu sync.Mutex
+	r.eachTagList(ctx, repos, func(repo string, tags []string) {
 		for _, tag := range tags {
-			ttl := hooks.ClampTTL(hooks.ParseTTL(tag), r.defaultTTL, r.maxTTL)
-			expiresAt := time.Now().Add(ttl)
-			imageWithTag := fmt.Sprintf("%s:%s", repo, tag)
-
-			// Fetch manifest info - best effort
-			var sizeBytes int64
-			var digest string
-
-			manifestInfo, err := r.registry.GetImageManifestInfo(ctx, repo, tag)
-			if err != nil {
-				r.logger.Warn("failed to fetch manifest info during recovery",
-					"image", imageWithTag,
-					"error", err,
-				)
-				sizeBytes = 0
-				digest = ""
-			} else {
-				sizeBytes = manifestInfo.SizeBytes
-				digest = manifestInfo.Digest
+			if r.untracked(repo, tag) {
+				continue
 			}
-
-			if err := r.redis.TrackImage(ctx, imageWithTag, expiresAt, sizeBytes, digest); err != nil {
-				r.logger.Error("failed to track image", "image", imageWithTag, "error", err)
+			sizeBytes, ok := r.recoverTag(ctx, repo, tag)
+			if !ok {
 				continue
 			}
-
-			r.logger.Debug("recovered image",
-				"image", imageWithTag,
-				"ttl", ttl.String(),
-				"size_bytes", sizeBytes,
-				"digest", digest,
-			)
-			recovered++
-			totalBytes += sizeBytes
+			mu.Lock()
+			res.Recovered++
+			res.TotalBytes += sizeBytes
+			mu.Unlock()
 		}
+	}, func(repo string, err error) {
+		r.logger.Warn("failed to list tags, skipping repo", "repo", repo, "error", err)
+	})
+	if err := ctx.Err(); err != nil {
+		return res, fmt.Errorf("recovery interrupted: %w", err)
 	}
 
-	totalMB := float64(totalBytes) / (1024 * 1024)
+	totalMB := float64(res.TotalBytes) / (1024 * 1024)
 	r.logger.Info("recovery complete",
-		"images_recovered", recovered,
-		"total_bytes", totalBytes,
+		"images_recovered", res.Recovered,
+		"total_bytes", res.TotalBytes,
 		"total_mb", fmt.Sprintf("%.2f", totalMB),
 	)
-	return nil
+	return res, nil
+}
+
+// recoverTag tracks repo:tag with the TTL its tag names, and returns its size
+// and whether it was tracked.
+func (r *Runner) recoverTag(ctx context.Context, repo, tag string) (int64, bool) {
+	ttl := hooks.ClampTTL(hooks.ParseTTL(tag), r.defaultTTL, r.maxTTL)
+	expiresAt := time.Now().Add(ttl)
+	imageWithTag := fmt.Sprintf("%s:%s", repo, tag)
+
+	// Fetch manifest info - best effort
+	var sizeBytes int64
+	var digest string
+
+	manifestInfo, err := r.registry.GetImageManifestInfo(ctx, repo, tag)
+	if err != nil {
+		r.logger.Warn("failed to fetch manifest info during recovery",
+			"image", imageWithTag,
+			"error", err,
+		)
+	} else {
+		sizeBytes = manifestInfo.SizeBytes
+		digest = manifestInfo.Digest
+	}
+
+	if err := r.redis.TrackImage(ctx, imageWithTag, expiresAt, sizeBytes, digest, redisclient.ImageMeta{}); err != nil {
+		r.logger.Error("failed to track image", "image", imageWithTag, "error", err)
+		return 0, false
+	}
+
+	r.logger.Debug("recovered image",
+		"image", imageWithTag,
+		"ttl", ttl.String(),
+		"size_bytes", sizeBytes,
+		"digest", digest,
+	)
+	return sizeBytes, true
 }
 
 // RunIfNeeded checks whether Redis has been initialized. If not, it runs
@@ -128,3 +193,13 @@ func (r *Runner) RunIfNeeded(ctx context.Context) error {
 
 	return nil
 }
+
+// untracked reports whether pushes of repo:tag are left untracked because
+// the tag is not a TTL.
+func (r *Runner) untracked(repo, tag string) bool {
+	if r.nonTTLTags == nil || hooks.ParseTTL(tag) > 0 {
+		return false
+	}
+	action := r.nonTTLTags(repo)
+	return action == hooks.NonTTLIgnore || action == hooks.NonTTLReject
+}
@@ -2,106 +2,114 @@ package recover
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/tamcore/ephemeron/internal/hooks"
+	"github.com/tamcore/ephemeron/internal/memstore"
 	"github.com/tamcore/ephemeron/internal/registry"
 )
 
-type mockStore struct {
-	images      map[string]time.Time
-	sizes       map[string]int64
-	digests     map[string]string
-	created     map[string]int64
-	initialized bool
-}
-
-func newMockStore() *mockStore {
-	return &mockStore{
-		images:  make(map[string]time.Time),
-		sizes:   make(map[string]int64),
-		digests: make(map[string]string),
-		created: make(map[string]int64),
+func TestRunIfNeeded_AlreadyInitialized(t *testing.T) {
+	store := memstore.New()
+	if err := store.SetInitialized(t.Context()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
-}
 
-func (m *mockStore) Ping(_ context.Context) error { return nil }
-func (m *mockStore) Close() error                 { return nil }
-
-func (m *mockStore) TrackImage(
-	_ context.Context,
-	imageWithTag string,
-	expiresAt time.Time,
-	sizeBytes int64,
-	digest string,
-) error {
-	m.images[imageWithTag] = expiresAt
-	m.sizes[imageWithTag] = sizeBytes
-	m.digests[imageWithTag] = digest
-	m.created[imageWithTag] = time.Now().UnixMilli()
-	return nil
-}
+	r := New(store, registry.New("http://unused"), time.Hour, 24*time.Hour, slog.Default())
 
-func (m *mockStore) ListImages(_ context.Context) ([]string, error) {
-	keys := make([]string, 0, len(m.images))
-	for k := range m.images {
-		keys = append(keys, k)
+	if err := r.RunIfNeeded(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
-	return keys, nil
-}
-
-func (m *mockStore) GetExpiry(_ context.Context, imageWithTag string) (int64, error) {
-	return m.images[imageWithTag].UnixMilli(), nil
-}
-
-func (m *mockStore) GetImageSize(_ context.Context, imageWithTag string) (int64, error) {
-	return m.sizes[imageWithTag], nil
-}
 
-func (m *mockStore) GetImageDigest(_ context.Context, imageWithTag string) (string, error) {
-	return m.digests[imageWithTag], nil
-}
-
-func (m *mockStore) GetCreatedTimestamp(_ context.Context, imageWithTag string) (int64, error) {
-	return m.created[imageWithTag], nil
-}
-
-func (m *mockStore) RemoveImage(_ context.Context, imageWithTag string) error {
-	delete(m.images, imageWithTag)
-	return nil
-}
-
-func (m *mockStore) AcquireReaperLock(_ context.Context, _ time.Duration) (bool, error) {
-	return true, nil
-}
-
-func (m *mockStore) ReleaseReaperLock(_ context.Context) error { return nil }
-
-func (m *mockStore) IsInitialized(_ context.Context) (bool, error) {
-	return m.initialized, nil
+	if n, _ := store.ImageCount(t.Context()); n != 0 {
+		t.Fatalf("expected no images tracked, got %d", n)
+	}
 }
 
-func (m *mockStore) SetInitialized(_ context.Context) error {
-	m.initialized = true
-	return nil
-}
+func TestRecover_NonTTLTags(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/_catalog":
+			_, _ = w.Write([]byte(`{"repositories":["app","release"]}`))
+		case "/v2/app/tags/list":
+			_, _ = w.Write([]byte(`{"name":"app","tags":["1h","latest"]}`))
+		case "/v2/release/tags/list":
+			_, _ = w.Write([]byte(`{"name":"release","tags":["1h","v1"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	store := memstore.New()
+	r := New(store, registry.New(srv.URL), time.Hour, 24*time.Hour, slog.Default(),
+		WithNonTTLTags(func(repo string) string {
+			if repo == "release" {
+				return hooks.NonTTLIgnore
+			}
+			return hooks.NonTTLTrack
+		}))
+	if _, err := r.Recover(t.Context()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	images, _ := store.ListImages(t.Context())
+	slices.Sort(images)
+	if want := []string{"app:1h", "app:latest", "release:1h"}; !slices.Equal(images, want) {
+		t.Errorf("tracked = %v, want %v", images, want)
+	}
 
-func (m *mockStore) ImageCount(_ context.Context) (int64, error) {
-	return int64(len(m.images)), nil
+	d, err := r.Diff(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(d.Untracked) != 0 {
+		t.Errorf("untracked = %v, want ignored tags left out", d.Untracked)
+	}
 }
 
-func TestRunIfNeeded_AlreadyInitialized(t *testing.T) {
-	store := newMockStore()
-	store.initialized = true
-
-	r := New(store, registry.New("http://unused"), time.Hour, 24*time.Hour, slog.Default())
-
-	if err := r.RunIfNeeded(context.Background()); err != nil {
+func TestRecover_Concurrent(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/_catalog":
+			repos := make([]string, 20)
+			for i := range repos {
+				repos[i] = fmt.Sprintf("repo%02d", i)
+			}
+			_ = json.NewEncoder(w).Encode(map[string][]string{"repositories": repos})
+		case strings.HasSuffix(r.URL.Path, "/tags/list"):
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			time.Sleep(5 * time.Millisecond)
+			_, _ = w.Write([]byte(`{"tags":["1h"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	store := memstore.New()
+	r := New(store, registry.New(srv.URL), time.Hour, 24*time.Hour, slog.New(slog.DiscardHandler),
+		WithConcurrency(4))
+	res, err := r.Recover(t.Context())
+	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-
-	if len(store.images) != 0 {
-		t.Fatalf("expected no images tracked, got %d", len(store.images))
+	if res.Repositories != 20 || res.Recovered != 20 {
+		t.Errorf("result = %+v, want 20 repositories and 20 recovered images", res)
+	}
+	if p := peak.Load(); p > 4 || p < 2 {
+		t.Errorf("expected up to 4 concurrent tag listings, got %d", p)
 	}
 }
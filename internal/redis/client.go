@@ -2,31 +2,150 @@ package redis
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+
+	"github.com/tamcore/ephemeron/internal/fieldcrypt"
 )
 
 const (
-	imagesKey      = "current.images"
-	reaperLockKey  = "reaper.lock"
-	initializedKey = "ephemeron:initialized"
+	imagesKey       = "current.images"
+	reaperLockKey   = "reaper.lock"
+	reaperFenceKey  = "reaper.lock.fence"
+	initializedKey  = "ephemeron:initialized"
+	schemaKey       = "ephemeron:schema"
+	reapStatsKey    = "reaper.stats"
+	quarantineKey   = "reaper.quarantine"
+	freezesKey      = "reaper.freezes"
+	deletionsKey    = "reaper.deletions"
+	apiTokensKey    = "api.tokens"
+	tombstonesKey   = "reaper.tombstones"
+	reconcileKey    = "reconcile.checkpoint"
+	reconcileAtKey  = "reconcile.checkpoint.started"
+	reconcileCurKey = "reconcile.cursor"
+	pushStatsKey    = "push.stats"
+	dailyUsageKey   = "usage.daily"
+	supersededKey   = "superseded.manifests"
+	aliasKeyPrefix  = "aliases:"
+	expiryKeyPrefix = "expiry:"
+	rulesKeyPrefix  = "rules:"
+	pushStatsPrefix = "push.stats:"
 )
 
 // Client wraps the Redis client with ephemeron-specific operations.
 type Client struct {
-	rdb *redis.Client
+	rdb          *redis.Client
+	prefix       string
+	db           int
+	nativeExpiry bool
+	maxLag       time.Duration
+	hooks        []redis.Hook
+	password     func() string
+	keyring      *fieldcrypt.Keyring
+}
+
+// encryptedMetaFields are the image hash fields identifying the pusher,
+// encrypted with WithFieldEncryption.
+var encryptedMetaFields = []string{"actor", "source_addr", "user_agent"}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithKeyPrefix prepends prefix to every key the client reads or writes,
+// so multiple deployments can share one Redis instance.
+func WithKeyPrefix(prefix string) Option {
+	return func(c *Client) {
+		c.prefix = prefix
+	}
+}
+
+// WithDB selects the Redis logical database, overriding the one in the URL.
+// A negative index keeps the database from the URL.
+func WithDB(db int) Option {
+	return func(c *Client) {
+		c.db = db
+	}
+}
+
+// WithNativeExpiry makes TrackImage also write a marker key that Redis
+// expires at the image's expiry time. Combined with SubscribeExpirations,
+// this lets the reaper delete images as soon as they expire.
+func WithNativeExpiry(enabled bool) Option {
+	return func(c *Client) {
+		c.nativeExpiry = enabled
+	}
+}
+
+// WithMaxReplicationLag makes Degraded report a primary whose replicas lag
+// behind by more than lag. Zero disables the check.
+func WithMaxReplicationLag(lag time.Duration) Option {
+	return func(c *Client) {
+		c.maxLag = lag
+	}
+}
+
+// WithHooks adds go-redis hooks around every command, such as fault
+// injection for resilience testing.
+func WithHooks(hooks ...redis.Hook) Option {
+	return func(c *Client) {
+		c.hooks = append(c.hooks, hooks...)
+	}
+}
+
+// WithPassword authenticates new connections with the password returned by
+// password, overriding the one in the URL. It is called for every new
+// connection, so a rotated password is used without recreating the client.
+func WithPassword(password func() string) Option {
+	return func(c *Client) {
+		c.password = password
+	}
+}
+
+// WithFieldEncryption encrypts who pushed each image and the audit trail
+// of deletion requests with keyring. Values written without encryption
+// stay readable.
+func WithFieldEncryption(keyring *fieldcrypt.Keyring) Option {
+	return func(c *Client) {
+		c.keyring = keyring
+	}
 }
 
 // New creates a new Redis client from the given URL.
-func New(redisURL string) (*Client, error) {
-	opts, err := redis.ParseURL(redisURL)
+func New(redisURL string, opts ...Option) (*Client, error) {
+	redisOpts, err := redis.ParseURL(redisURL)
 	if err != nil {
 		return nil, fmt.Errorf("parsing redis URL: %w", err)
 	}
-	return &Client{rdb: redis.NewClient(opts)}, nil
+	c := &Client{db: -1}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.db >= 0 {
+		redisOpts.DB = c.db
+	}
+	if c.password != nil {
+		username := redisOpts.Username
+		redisOpts.CredentialsProvider = func() (string, string) {
+			return username, c.password()
+		}
+	}
+	c.rdb = redis.NewClient(redisOpts)
+	for _, h := range c.hooks {
+		c.rdb.AddHook(h)
+	}
+	return c, nil
+}
+
+// key returns the fully qualified Redis key for name.
+func (c *Client) key(name string) string {
+	return c.prefix + name
 }
 
 // Ping checks the connection to Redis.
@@ -34,6 +153,54 @@ func (c *Client) Ping(ctx context.Context) error {
 	return c.rdb.Ping(ctx).Err()
 }
 
+// Degraded inspects the replication and persistence state of the server
+// and returns why deleting based on its data is unsafe, or "" if it is not.
+func (c *Client) Degraded(ctx context.Context) (string, error) {
+	info, err := c.rdb.Info(ctx, "replication", "persistence").Result()
+	if err != nil {
+		return "", err
+	}
+	return degradedReason(info, c.maxLag), nil
+}
+
+// degradedReason evaluates the output of INFO replication and persistence.
+func degradedReason(info string, maxLag time.Duration) string {
+	fields := make(map[string]string)
+	var replicaLags []string
+	for line := range strings.Lines(info) {
+		key, val, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		fields[key] = val
+		// Replicas of a primary are listed as
+		// slave<n>:ip=...,port=...,state=online,offset=...,lag=<seconds>.
+		if strings.HasPrefix(key, "slave") && strings.Contains(val, "lag=") {
+			for kv := range strings.SplitSeq(val, ",") {
+				if lag, ok := strings.CutPrefix(kv, "lag="); ok {
+					replicaLags = append(replicaLags, lag)
+				}
+			}
+		}
+	}
+	switch {
+	case fields["loading"] == "1":
+		return DegradedLoading
+	case fields["role"] == "slave" && fields["master_link_status"] != "up":
+		return DegradedReplicaLinkDown
+	case fields["role"] == "slave" && fields["master_sync_in_progress"] == "1":
+		return DegradedReplicaSyncing
+	}
+	if maxLag > 0 {
+		for _, lag := range replicaLags {
+			if secs, err := strconv.Atoi(lag); err == nil && time.Duration(secs)*time.Second > maxLag {
+				return DegradedReplicationLag
+			}
+		}
+	}
+	return ""
+}
+
 // Close closes the Redis connection.
 func (c *Client) Close() error {
 	return c.rdb.Close()
@@ -46,37 +213,262 @@ func (c *Client) TrackImage(
 	expiresAt time.Time,
 	sizeBytes int64,
 	digest string,
+	meta ImageMeta,
 ) error {
-	pipe := c.rdb.Pipeline()
-	pipe.SAdd(ctx, imagesKey, imageWithTag)
-	pipe.HSet(ctx, imageWithTag,
+	oldDigest, err := c.GetImageDigest(ctx, imageWithTag)
+	if err != nil {
+		return err
+	}
+
+	actor, err := c.keyring.Encrypt(meta.Actor)
+	if err != nil {
+		return err
+	}
+	sourceAddr, err := c.keyring.Encrypt(meta.SourceAddr)
+	if err != nil {
+		return err
+	}
+	userAgent, err := c.keyring.Encrypt(meta.UserAgent)
+	if err != nil {
+		return err
+	}
+
+	pipe := c.rdb.TxPipeline()
+	pipe.SAdd(ctx, c.key(imagesKey), imageWithTag)
+	if oldDigest != "" && oldDigest != digest {
+		pipe.SRem(ctx, c.aliasKey(imageWithTag, oldDigest), imageWithTag)
+	}
+	if digest != "" {
+		pipe.SAdd(ctx, c.aliasKey(imageWithTag, digest), imageWithTag)
+	}
+	pipe.HSet(ctx, c.key(imageWithTag),
 		"created", strconv.FormatInt(time.Now().UnixMilli(), 10),
 		"expires", strconv.FormatInt(expiresAt.UnixMilli(), 10),
 		"size_bytes", strconv.FormatInt(sizeBytes, 10),
 		"digest", digest,
+		"actor", actor,
+		"source_addr", sourceAddr,
+		"user_agent", userAgent,
+		"source", meta.Source,
+		versionField, RecordVersion,
 	)
-	_, err := pipe.Exec(ctx)
+	// A re-push is new content, so earlier deletion failures and protection
+	// no longer apply.
+	pipe.HDel(ctx, c.key(imageWithTag), deleteFailuresField, protectedField, priorityField)
+	pipe.HDel(ctx, c.key(quarantineKey), imageWithTag)
+	if c.nativeExpiry {
+		// A zero TTL would persist the marker, so fire past expiries right away.
+		pipe.Set(ctx, c.key(expiryKeyPrefix+imageWithTag), "", max(time.Until(expiresAt), time.Millisecond))
+	}
+	_, err = pipe.Exec(ctx)
 	return err
 }
 
+// aliasKey returns the key of the set holding every tracked tag of
+// imageWithTag's repository that points at digest.
+func (c *Client) aliasKey(imageWithTag, digest string) string {
+	repo, _, _ := strings.Cut(imageWithTag, ":")
+	return c.key(aliasKeyPrefix + repo + "@" + digest)
+}
+
+// Aliases returns the other tracked tags of an image's repository that
+// point at the same manifest digest. Images without a digest have none.
+func (c *Client) Aliases(ctx context.Context, imageWithTag string) ([]string, error) {
+	digest, err := c.GetImageDigest(ctx, imageWithTag)
+	if err != nil || digest == "" {
+		return nil, err
+	}
+	members, err := c.rdb.SMembers(ctx, c.aliasKey(imageWithTag, digest)).Result()
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(members, func(m string) bool { return m == imageWithTag }), nil
+}
+
+// TaggedImages returns the tracked images of repo that point at digest,
+// read from the alias set TrackImage and RemoveImage maintain.
+func (c *Client) TaggedImages(ctx context.Context, repo, digest string) ([]string, error) {
+	return c.rdb.SMembers(ctx, c.aliasKey(repo+":", digest)).Result()
+}
+
 // ListImages returns all tracked images.
 func (c *Client) ListImages(ctx context.Context) ([]string, error) {
-	return c.rdb.SMembers(ctx, imagesKey).Result()
+	return c.rdb.SMembers(ctx, c.key(imagesKey)).Result()
 }
 
 // GetExpiry returns the expiry timestamp (in epoch milliseconds) for an image.
+// It returns ErrNewerRecord for images tracked by a newer version of
+// ephemeron with an incompatible encoding.
 func (c *Client) GetExpiry(ctx context.Context, imageWithTag string) (int64, error) {
-	val, err := c.rdb.HGet(ctx, imageWithTag, "expires").Result()
+	vals, err := c.rdb.HMGet(ctx, c.key(imageWithTag), "expires", versionField).Result()
 	if err != nil {
 		return 0, err
 	}
+	val, ok := vals[0].(string)
+	if !ok {
+		return 0, redis.Nil
+	}
+	if err := checkHashVersion(imageWithTag, vals[1]); err != nil {
+		return 0, err
+	}
 	return strconv.ParseInt(val, 10, 64)
 }
 
+// setExpiryScript updates the expiry of a still-tracked image without
+// recreating the hash of one removed in the meantime.
+var setExpiryScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	redis.call("HSET", KEYS[1], "expires", ARGV[1])
+	return 1
+end
+return 0`)
+
+// SetExpiry changes the expiry of a tracked image, keeping the rest of its
+// metadata. It reports false if the image is not tracked.
+func (c *Client) SetExpiry(ctx context.Context, imageWithTag string, expiresAt time.Time) (bool, error) {
+	ok, err := setExpiryScript.Run(ctx, c.rdb, []string{c.key(imageWithTag)}, expiresAt.UnixMilli()).Bool()
+	if err != nil || !ok {
+		return false, err
+	}
+	if c.nativeExpiry {
+		ttl := max(time.Until(expiresAt), time.Millisecond)
+		if err := c.rdb.Set(ctx, c.key(expiryKeyPrefix+imageWithTag), "", ttl).Err(); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// setExpiriesScript updates the expiry of every still-tracked image in
+// KEYS to the matching ARGV in one step, returning which ones it updated.
+var setExpiriesScript = redis.NewScript(`
+local updated = {}
+for i, key in ipairs(KEYS) do
+	if redis.call("EXISTS", key) == 1 then
+		redis.call("HSET", key, "expires", ARGV[i])
+		updated[#updated + 1] = i
+	end
+end
+return updated`)
+
+// SetExpiries changes the expiry of several tracked images atomically and
+// returns the images that were still tracked and thus updated.
+func (c *Client) SetExpiries(ctx context.Context, expiries map[string]time.Time) ([]string, error) {
+	if len(expiries) == 0 {
+		return nil, nil
+	}
+	images := make([]string, 0, len(expiries))
+	keys := make([]string, 0, len(expiries))
+	args := make([]any, 0, len(expiries))
+	for image, expiresAt := range expiries {
+		images = append(images, image)
+		keys = append(keys, c.key(image))
+		args = append(args, expiresAt.UnixMilli())
+	}
+	indices, err := setExpiriesScript.Run(ctx, c.rdb, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	updated := make([]string, 0, len(indices))
+	for _, i := range indices {
+		updated = append(updated, images[i-1]) // Lua indices start at 1
+	}
+	if c.nativeExpiry {
+		pipe := c.rdb.Pipeline()
+		for _, image := range updated {
+			pipe.Set(ctx, c.key(expiryKeyPrefix+image), "", max(time.Until(expiries[image]), time.Millisecond))
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return updated, err
+		}
+	}
+	return updated, nil
+}
+
+// setManifestInfoScript updates the size and digest of a still-tracked
+// image and moves it between alias sets, without recreating the hash of one
+// removed in the meantime.
+var setManifestInfoScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("HSET", KEYS[1], "size_bytes", ARGV[1], "digest", ARGV[2])
+if ARGV[4] ~= "" and ARGV[4] ~= ARGV[2] then
+	redis.call("SREM", KEYS[3], ARGV[3])
+end
+if ARGV[2] ~= "" then
+	redis.call("SADD", KEYS[2], ARGV[3])
+end
+return 1`)
+
+// SetManifestInfo changes the size and digest of a tracked image, keeping
+// the rest of its metadata. It reports false if the image is not tracked.
+func (c *Client) SetManifestInfo(
+	ctx context.Context,
+	imageWithTag string,
+	sizeBytes int64,
+	digest string,
+) (bool, error) {
+	oldDigest, err := c.GetImageDigest(ctx, imageWithTag)
+	if err != nil {
+		return false, err
+	}
+	keys := []string{c.key(imageWithTag), c.aliasKey(imageWithTag, digest), c.aliasKey(imageWithTag, oldDigest)}
+	return setManifestInfoScript.Run(ctx, c.rdb, keys, sizeBytes, digest, imageWithTag, oldDigest).Bool()
+}
+
+// setImageSizeScript updates the size of an image only while it is tracked
+// with the digest the size was read for.
+var setImageSizeScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+if (redis.call("HGET", KEYS[1], "digest") or "") ~= ARGV[1] then
+	return 0
+end
+redis.call("HSET", KEYS[1], "size_bytes", ARGV[2])
+return 1`)
+
+// SetImageSize changes the size of an image only while it is still tracked
+// with digest, reporting whether it did.
+func (c *Client) SetImageSize(ctx context.Context, imageWithTag, digest string, sizeBytes int64) (bool, error) {
+	return setImageSizeScript.Run(ctx, c.rdb, []string{c.key(imageWithTag)}, digest, sizeBytes).Bool()
+}
+
+// GetManifestRecords returns the size and digest of each of images in one
+// round trip, omitting images that are not tracked.
+func (c *Client) GetManifestRecords(ctx context.Context, images []string) (map[string]ManifestRecord, error) {
+	out := make(map[string]ManifestRecord, len(images))
+	if len(images) == 0 {
+		return out, nil
+	}
+	pipe := c.rdb.Pipeline()
+	cmds := make([]*redis.SliceCmd, len(images))
+	for i, image := range images {
+		cmds[i] = pipe.HMGet(ctx, c.key(image), "expires", "size_bytes", "digest")
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	for i, image := range images {
+		vals := cmds[i].Val()
+		if vals[0] == nil {
+			continue
+		}
+		size, err := parseStat(vals[1])
+		if err != nil {
+			return nil, fmt.Errorf("decoding size of %s: %w", image, err)
+		}
+		digest, _ := vals[2].(string)
+		out[image] = ManifestRecord{SizeBytes: size, Digest: digest}
+	}
+	return out, nil
+}
+
 // GetImageSize returns the size in bytes for an image.
 // Returns 0 for missing field (backward compatibility with old records).
 func (c *Client) GetImageSize(ctx context.Context, imageWithTag string) (int64, error) {
-	val, err := c.rdb.HGet(ctx, imageWithTag, "size_bytes").Result()
+	val, err := c.rdb.HGet(ctx, c.key(imageWithTag), "size_bytes").Result()
 	if err == redis.Nil {
 		// Field doesn't exist (old record without size tracking)
 		return 0, nil
@@ -90,7 +482,7 @@ func (c *Client) GetImageSize(ctx context.Context, imageWithTag string) (int64,
 // GetImageDigest returns the stored digest for an image.
 // Returns empty string for missing field (backward compatibility).
 func (c *Client) GetImageDigest(ctx context.Context, imageWithTag string) (string, error) {
-	val, err := c.rdb.HGet(ctx, imageWithTag, "digest").Result()
+	val, err := c.rdb.HGet(ctx, c.key(imageWithTag), "digest").Result()
 	if err == redis.Nil {
 		return "", nil // Old record without digest
 	}
@@ -100,7 +492,7 @@ func (c *Client) GetImageDigest(ctx context.Context, imageWithTag string) (strin
 // GetCreatedTimestamp returns the created timestamp (epoch milliseconds).
 // Returns 0 for missing field (backward compatibility).
 func (c *Client) GetCreatedTimestamp(ctx context.Context, imageWithTag string) (int64, error) {
-	val, err := c.rdb.HGet(ctx, imageWithTag, "created").Result()
+	val, err := c.rdb.HGet(ctx, c.key(imageWithTag), "created").Result()
 	if err == redis.Nil {
 		return 0, nil
 	}
@@ -110,29 +502,121 @@ func (c *Client) GetCreatedTimestamp(ctx context.Context, imageWithTag string) (
 	return strconv.ParseInt(val, 10, 64)
 }
 
+// GetImageMeta returns who pushed an image. Missing fields are empty
+// (backward compatibility).
+func (c *Client) GetImageMeta(ctx context.Context, imageWithTag string) (ImageMeta, error) {
+	vals, err := c.rdb.HMGet(ctx, c.key(imageWithTag), "actor", "source_addr", "user_agent", "source").Result()
+	if err != nil {
+		return ImageMeta{}, err
+	}
+	strs := make([]string, len(vals))
+	for i, v := range vals {
+		s, _ := v.(string)
+		if i < len(encryptedMetaFields) {
+			if s, err = c.keyring.Decrypt(s); err != nil {
+				return ImageMeta{}, fmt.Errorf("decrypting %s of %s: %w", encryptedMetaFields[i], imageWithTag, err)
+			}
+		}
+		strs[i] = s
+	}
+	return ImageMeta{
+		Actor:      strs[0],
+		SourceAddr: strs[1],
+		UserAgent:  strs[2],
+		Source:     strs[3],
+	}, nil
+}
+
+// GetImageRecord returns every field of an image's hash, with the pusher
+// fields decrypted, or an empty map if it is untracked.
+func (c *Client) GetImageRecord(ctx context.Context, imageWithTag string) (map[string]string, error) {
+	fields, err := c.rdb.HGetAll(ctx, c.key(imageWithTag)).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range encryptedMetaFields {
+		v, ok := fields[name]
+		if !ok {
+			continue
+		}
+		if fields[name], err = c.keyring.Decrypt(v); err != nil {
+			return nil, fmt.Errorf("decrypting %s of %s: %w", name, imageWithTag, err)
+		}
+	}
+	return fields, nil
+}
+
 // RemoveImage removes an image from the tracking set and deletes its metadata.
 func (c *Client) RemoveImage(ctx context.Context, imageWithTag string) error {
+	digest, err := c.GetImageDigest(ctx, imageWithTag)
+	if err != nil {
+		return err
+	}
+
 	pipe := c.rdb.Pipeline()
-	pipe.SRem(ctx, imagesKey, imageWithTag)
-	pipe.Del(ctx, imageWithTag)
-	_, err := pipe.Exec(ctx)
+	pipe.SRem(ctx, c.key(imagesKey), imageWithTag)
+	if digest != "" {
+		pipe.SRem(ctx, c.aliasKey(imageWithTag, digest), imageWithTag)
+	}
+	pipe.Del(ctx, c.key(imageWithTag))
+	pipe.HDel(ctx, c.key(quarantineKey), imageWithTag)
+	if c.nativeExpiry {
+		pipe.Del(ctx, c.key(expiryKeyPrefix+imageWithTag))
+	}
+	_, err = pipe.Exec(ctx)
 	return err
 }
 
+// renewLockScript extends the lock TTL only if it still holds our token.
+var renewLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// releaseLockScript deletes the lock only if it still holds our token.
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
 // AcquireReaperLock attempts to acquire a distributed lock for the reaper.
-// Returns true if the lock was acquired. The lock auto-expires after the given TTL.
-func (c *Client) AcquireReaperLock(ctx context.Context, ttl time.Duration) (bool, error) {
-	return c.rdb.SetNX(ctx, reaperLockKey, "locked", ttl).Result()
+// On success it returns a positive fencing token identifying this holder;
+// it returns 0 if another holder has the lock. The lock auto-expires after
+// the given TTL unless renewed.
+func (c *Client) AcquireReaperLock(ctx context.Context, ttl time.Duration) (int64, error) {
+	token, err := c.rdb.Incr(ctx, c.key(reaperFenceKey)).Result()
+	if err != nil {
+		return 0, err
+	}
+	ok, err := c.rdb.SetNX(ctx, c.key(reaperLockKey), strconv.FormatInt(token, 10), ttl).Result()
+	if err != nil || !ok {
+		return 0, err
+	}
+	return token, nil
 }
 
-// ReleaseReaperLock releases the distributed reaper lock.
-func (c *Client) ReleaseReaperLock(ctx context.Context) error {
-	return c.rdb.Del(ctx, reaperLockKey).Err()
+// RenewReaperLock extends the lock TTL. It returns false if the lock has
+// expired or was taken over by another holder.
+func (c *Client) RenewReaperLock(ctx context.Context, token int64, ttl time.Duration) (bool, error) {
+	n, err := renewLockScript.Run(ctx, c.rdb,
+		[]string{c.key(reaperLockKey)}, strconv.FormatInt(token, 10), ttl.Milliseconds(),
+	).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// ReleaseReaperLock releases the distributed reaper lock if token still holds it.
+func (c *Client) ReleaseReaperLock(ctx context.Context, token int64) error {
+	return releaseLockScript.Run(ctx, c.rdb, []string{c.key(reaperLockKey)}, strconv.FormatInt(token, 10)).Err()
 }
 
 // IsInitialized checks if ephemeron has been initialized (i.e. Redis has been populated).
 func (c *Client) IsInitialized(ctx context.Context) (bool, error) {
-	val, err := c.rdb.Exists(ctx, initializedKey).Result()
+	val, err := c.rdb.Exists(ctx, c.key(initializedKey)).Result()
 	if err != nil {
 		return false, err
 	}
@@ -141,10 +625,753 @@ func (c *Client) IsInitialized(ctx context.Context) (bool, error) {
 
 // SetInitialized marks Redis as initialized.
 func (c *Client) SetInitialized(ctx context.Context) error {
-	return c.rdb.Set(ctx, initializedKey, "true", 0).Err()
+	return c.rdb.Set(ctx, c.key(initializedKey), "true", 0).Err()
+}
+
+// SchemaVersion returns the recorded store schema version, 0 if none.
+func (c *Client) SchemaVersion(ctx context.Context) (int, error) {
+	v, err := c.rdb.Get(ctx, c.key(schemaKey)).Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return v, err
+}
+
+// SetSchemaVersion records the store schema version.
+func (c *Client) SetSchemaVersion(ctx context.Context, version int) error {
+	return c.rdb.Set(ctx, c.key(schemaKey), version, 0).Err()
 }
 
 // ImageCount returns the number of tracked images.
 func (c *Client) ImageCount(ctx context.Context) (int64, error) {
-	return c.rdb.SCard(ctx, imagesKey).Result()
+	return c.rdb.SCard(ctx, c.key(imagesKey)).Result()
+}
+
+// TrackedBytes returns the summed size of all tracked images. Tags of a
+// repository that share a manifest digest are counted once.
+func (c *Client) TrackedBytes(ctx context.Context) (int64, error) {
+	images, err := c.ListImages(ctx)
+	if err != nil || len(images) == 0 {
+		return 0, err
+	}
+	pipe := c.rdb.Pipeline()
+	cmds := make([]*redis.SliceCmd, len(images))
+	for i, image := range images {
+		cmds[i] = pipe.HMGet(ctx, c.key(image), "size_bytes", "digest")
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	var total int64
+	counted := make(map[string]struct{})
+	for i, cmd := range cmds {
+		vals := cmd.Val()
+		// Old records without size tracking count as 0.
+		size, _ := vals[0].(string)
+		if size == "" {
+			continue
+		}
+		if digest, _ := vals[1].(string); digest != "" {
+			key := c.aliasKey(images[i], digest)
+			if _, ok := counted[key]; ok {
+				continue
+			}
+			counted[key] = struct{}{}
+		}
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// RecordReap adds a reaped image and its size to the lifetime totals.
+func (c *Client) RecordReap(ctx context.Context, sizeBytes int64) error {
+	pipe := c.rdb.Pipeline()
+	pipe.HIncrBy(ctx, c.key(reapStatsKey), "images", 1)
+	pipe.HIncrBy(ctx, c.key(reapStatsKey), "bytes", sizeBytes)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ReapTotals returns the lifetime number of reaped images and reclaimed bytes.
+func (c *Client) ReapTotals(ctx context.Context) (images, bytes int64, err error) {
+	vals, err := c.rdb.HMGet(ctx, c.key(reapStatsKey), "images", "bytes").Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	if images, err = parseStat(vals[0]); err != nil {
+		return 0, 0, err
+	}
+	if bytes, err = parseStat(vals[1]); err != nil {
+		return 0, 0, err
+	}
+	return images, bytes, nil
+}
+
+// RecordPush adds a push to repo's statistics, kept in a hash per
+// repository with the repositories listed in a set.
+func (c *Client) RecordPush(ctx context.Context, repo string, sizeBytes int64, at time.Time) error {
+	key := c.key(pushStatsPrefix + repo)
+	pipe := c.rdb.Pipeline()
+	pipe.SAdd(ctx, c.key(pushStatsKey), repo)
+	pipe.HIncrBy(ctx, key, "pushes", 1)
+	if sizeBytes > 0 {
+		pipe.HIncrBy(ctx, key, "sized_pushes", 1)
+		pipe.HIncrBy(ctx, key, "bytes", sizeBytes)
+	}
+	pipe.HSet(ctx, key, "last_push", at.UnixMilli())
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ListRepoStats returns the push statistics of every repository pushed to.
+func (c *Client) ListRepoStats(ctx context.Context) ([]RepoStats, error) {
+	repos, err := c.rdb.SMembers(ctx, c.key(pushStatsKey)).Result()
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(repos)
+	pipe := c.rdb.Pipeline()
+	cmds := make([]*redis.SliceCmd, len(repos))
+	for i, repo := range repos {
+		cmds[i] = pipe.HMGet(ctx, c.key(pushStatsPrefix+repo), "pushes", "sized_pushes", "bytes", "last_push")
+	}
+	if len(repos) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+	}
+	out := make([]RepoStats, 0, len(repos))
+	for i, repo := range repos {
+		var vals [4]int64
+		for j, v := range cmds[i].Val() {
+			if vals[j], err = parseStat(v); err != nil {
+				return nil, fmt.Errorf("decoding push statistics of %s: %w", repo, err)
+			}
+		}
+		out = append(out, RepoStats{
+			Repository:  repo,
+			Pushes:      vals[0],
+			SizedPushes: vals[1],
+			TotalBytes:  vals[2],
+			LastPush:    time.UnixMilli(vals[3]).UTC(),
+		})
+	}
+	return out, nil
+}
+
+// parseStat converts an HMGET value to an int64, treating missing fields as 0.
+func parseStat(v any) (int64, error) {
+	s, ok := v.(string)
+	if !ok {
+		return 0, nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// protectedField marks an image the reaper must not delete.
+const protectedField = "protected"
+
+// setProtectedScript sets or clears the protected flag of a still-tracked
+// image without recreating the hash of one removed in the meantime.
+var setProtectedScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+if ARGV[2] == "1" then
+	return redis.call("HSET", KEYS[1], ARGV[1], "1")
+end
+return redis.call("HDEL", KEYS[1], ARGV[1])`)
+
+// SetProtected marks an image as exempt from reaping, or clears the mark.
+// Untracked images are ignored. Re-tracking an image clears the mark.
+func (c *Client) SetProtected(ctx context.Context, imageWithTag string, protected bool) error {
+	flag := "0"
+	if protected {
+		flag = "1"
+	}
+	return setProtectedScript.Run(ctx, c.rdb, []string{c.key(imageWithTag)}, protectedField, flag).Err()
+}
+
+// IsProtected reports whether an image is exempt from reaping.
+func (c *Client) IsProtected(ctx context.Context, imageWithTag string) (bool, error) {
+	return c.rdb.HExists(ctx, c.key(imageWithTag), protectedField).Result()
+}
+
+// priorityField orders the deletion of an expired image.
+const priorityField = "priority"
+
+// setPriorityScript sets or clears the priority of a still-tracked image
+// without recreating the hash of one removed in the meantime.
+var setPriorityScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+if ARGV[2] == "0" then
+	return redis.call("HDEL", KEYS[1], ARGV[1])
+end
+return redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])`)
+
+// SetPriority sets the deletion priority of an image; zero clears it.
+// Untracked images are ignored. Re-tracking an image clears the priority.
+func (c *Client) SetPriority(ctx context.Context, imageWithTag string, priority int) error {
+	return setPriorityScript.Run(ctx, c.rdb, []string{c.key(imageWithTag)},
+		priorityField, strconv.Itoa(priority)).Err()
+}
+
+// GetPriority returns the deletion priority of an image, zero if unset.
+func (c *Client) GetPriority(ctx context.Context, imageWithTag string) (int, error) {
+	val, err := c.rdb.HGet(ctx, c.key(imageWithTag), priorityField).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(val)
+}
+
+// deleteFailuresField counts failed deletions in an image's hash.
+const deleteFailuresField = "delete_failures"
+
+// recordFailureScript increments the failure count of a still-tracked image
+// without recreating the hash of one removed in the meantime.
+var recordFailureScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return redis.call("HINCRBY", KEYS[1], ARGV[1], 1)
+end
+return 0`)
+
+// RecordDeleteFailure increments and returns the number of failed deletion
+// attempts for an image. Untracked images report 0.
+func (c *Client) RecordDeleteFailure(ctx context.Context, imageWithTag string) (int64, error) {
+	return recordFailureScript.Run(ctx, c.rdb, []string{c.key(imageWithTag)}, deleteFailuresField).Int64()
+}
+
+// QuarantineImage excludes an image from reaping until it is released or
+// re-pushed.
+func (c *Client) QuarantineImage(ctx context.Context, q QuarantinedImage) error {
+	data, err := encodeRecord(q)
+	if err != nil {
+		return err
+	}
+	return c.rdb.HSet(ctx, c.key(quarantineKey), q.Image, data).Err()
+}
+
+// ListQuarantined returns all quarantined images.
+func (c *Client) ListQuarantined(ctx context.Context) ([]QuarantinedImage, error) {
+	vals, err := c.rdb.HGetAll(ctx, c.key(quarantineKey)).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]QuarantinedImage, 0, len(vals))
+	for image, data := range vals {
+		var q QuarantinedImage
+		if err := decodeRecord("quarantine", []byte(data), &q); errors.Is(err, ErrNewerRecord) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("decoding quarantine entry for %s: %w", image, err)
+		}
+		out = append(out, q)
+	}
+	return out, nil
+}
+
+// ReleaseQuarantined returns an image to normal reaping with its failure
+// count reset.
+func (c *Client) ReleaseQuarantined(ctx context.Context, imageWithTag string) error {
+	pipe := c.rdb.Pipeline()
+	pipe.HDel(ctx, c.key(quarantineKey), imageWithTag)
+	pipe.HDel(ctx, c.key(imageWithTag), deleteFailuresField)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// SetFreeze stores f, replacing any freeze with the same pattern.
+func (c *Client) SetFreeze(ctx context.Context, f Freeze) error {
+	data, err := encodeRecord(f)
+	if err != nil {
+		return err
+	}
+	return c.rdb.HSet(ctx, c.key(freezesKey), f.Pattern, data).Err()
+}
+
+// ListFreezes returns the freezes still in effect, dropping lapsed ones.
+func (c *Client) ListFreezes(ctx context.Context) ([]Freeze, error) {
+	vals, err := c.rdb.HGetAll(ctx, c.key(freezesKey)).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Freeze, 0, len(vals))
+	var lapsed []string
+	for pattern, data := range vals {
+		var f Freeze
+		// A freeze this version cannot read fails the listing, so no
+		// deletions run while it might be in effect.
+		if err := decodeRecord("freeze", []byte(data), &f); err != nil {
+			return nil, fmt.Errorf("decoding freeze %q: %w", pattern, err)
+		}
+		if !time.Now().Before(f.Until) {
+			lapsed = append(lapsed, pattern)
+			continue
+		}
+		out = append(out, f)
+	}
+	if len(lapsed) > 0 {
+		// Best effort: a lapsed freeze is ignored whether or not it is gone.
+		_ = c.rdb.HDel(ctx, c.key(freezesKey), lapsed...).Err()
+	}
+	return out, nil
+}
+
+// Unfreeze lifts the freeze with the given pattern.
+func (c *Client) Unfreeze(ctx context.Context, pattern string) error {
+	return c.rdb.HDel(ctx, c.key(freezesKey), pattern).Err()
+}
+
+// PutRule stores r under kind, replacing any rule with the same pattern.
+func (c *Client) PutRule(ctx context.Context, kind string, r Rule) error {
+	data, err := encodeRecord(r)
+	if err != nil {
+		return err
+	}
+	return c.rdb.HSet(ctx, c.key(rulesKeyPrefix+kind), r.Pattern, data).Err()
+}
+
+// ListRules returns all rules of kind.
+func (c *Client) ListRules(ctx context.Context, kind string) ([]Rule, error) {
+	vals, err := c.rdb.HGetAll(ctx, c.key(rulesKeyPrefix+kind)).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Rule, 0, len(vals))
+	for pattern, data := range vals {
+		var r Rule
+		// Like freezes, unreadable rules fail the listing: they may protect
+		// images.
+		if err := decodeRecord("rule", []byte(data), &r); err != nil {
+			return nil, fmt.Errorf("decoding %s rule %q: %w", kind, pattern, err)
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// DeleteRule removes the rule of kind with the given pattern and reports
+// whether it existed.
+func (c *Client) DeleteRule(ctx context.Context, kind, pattern string) (bool, error) {
+	n, err := c.rdb.HDel(ctx, c.key(rulesKeyPrefix+kind), pattern).Result()
+	return n > 0, err
+}
+
+// PutDeletion stores d, replacing any deletion request with the same ID.
+func (c *Client) PutDeletion(ctx context.Context, d DeletionRequest) error {
+	data, err := encodeRecord(d)
+	if err != nil {
+		return err
+	}
+	enc, err := c.keyring.Encrypt(string(data))
+	if err != nil {
+		return err
+	}
+	return c.rdb.HSet(ctx, c.key(deletionsKey), d.ID, enc).Err()
+}
+
+// ListDeletions returns all deletion requests, including finished ones.
+func (c *Client) ListDeletions(ctx context.Context) ([]DeletionRequest, error) {
+	vals, err := c.rdb.HGetAll(ctx, c.key(deletionsKey)).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]DeletionRequest, 0, len(vals))
+	for id, data := range vals {
+		data, err := c.keyring.Decrypt(data)
+		if err != nil {
+			return nil, fmt.Errorf("decrypting deletion request %q: %w", id, err)
+		}
+		var d DeletionRequest
+		if err := decodeRecord("deletion", []byte(data), &d); errors.Is(err, ErrNewerRecord) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("decoding deletion request %q: %w", id, err)
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// reencryptFieldScript replaces field ARGV[1] of hash KEYS[1] with ARGV[3]
+// only while it still holds ARGV[2], so a concurrent write made between
+// reading and re-encrypting a field is never overwritten, and a removed
+// hash or field is never recreated.
+var reencryptFieldScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+local current = redis.call("HGET", KEYS[1], ARGV[1]) or ""
+if current ~= ARGV[2] then
+	return 0
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[3])
+return 1`)
+
+// Reencrypt rewrites every encrypted field not encrypted with the primary
+// key of the WithFieldEncryption keyring, including fields written before
+// encryption was enabled, and returns how many it rewrote. A field changed
+// concurrently is left as written, already with the primary key. Run it
+// after rotating keys, before removing the old key from the keyring.
+func (c *Client) Reencrypt(ctx context.Context) (int, error) {
+	if c.keyring == nil {
+		return 0, errors.New("field encryption is not enabled")
+	}
+	images, err := c.ListImages(ctx)
+	if err != nil {
+		return 0, err
+	}
+	rewritten := 0
+	for _, image := range images {
+		vals, err := c.rdb.HMGet(ctx, c.key(image), encryptedMetaFields...).Result()
+		if err != nil {
+			return rewritten, err
+		}
+		for i, v := range vals {
+			s, _ := v.(string)
+			if c.keyring.Current(s) {
+				continue
+			}
+			enc, err := c.reencrypt(s)
+			if err != nil {
+				return rewritten, fmt.Errorf("%s of %s: %w", encryptedMetaFields[i], image, err)
+			}
+			ok, err := reencryptFieldScript.Run(ctx, c.rdb, []string{c.key(image)},
+				encryptedMetaFields[i], s, enc).Bool()
+			if err != nil {
+				return rewritten, err
+			}
+			if ok {
+				rewritten++
+			}
+		}
+	}
+
+	deletions, err := c.rdb.HGetAll(ctx, c.key(deletionsKey)).Result()
+	if err != nil {
+		return rewritten, err
+	}
+	for id, data := range deletions {
+		if c.keyring.Current(data) {
+			continue
+		}
+		enc, err := c.reencrypt(data)
+		if err != nil {
+			return rewritten, fmt.Errorf("deletion request %q: %w", id, err)
+		}
+		ok, err := reencryptFieldScript.Run(ctx, c.rdb, []string{c.key(deletionsKey)}, id, data, enc).Bool()
+		if err != nil {
+			return rewritten, err
+		}
+		if ok {
+			rewritten++
+		}
+	}
+	return rewritten, nil
+}
+
+// reencrypt decrypts value with any key and encrypts it with the primary.
+func (c *Client) reencrypt(value string) (string, error) {
+	plain, err := c.keyring.Decrypt(value)
+	if err != nil {
+		return "", err
+	}
+	return c.keyring.Encrypt(plain)
+}
+
+// PutAPIToken stores t, replacing any token with the same name.
+func (c *Client) PutAPIToken(ctx context.Context, t APIToken) error {
+	data, err := encodeRecord(t)
+	if err != nil {
+		return err
+	}
+	return c.rdb.HSet(ctx, c.key(apiTokensKey), t.Name, data).Err()
+}
+
+// ListAPITokens returns all API tokens.
+func (c *Client) ListAPITokens(ctx context.Context) ([]APIToken, error) {
+	vals, err := c.rdb.HGetAll(ctx, c.key(apiTokensKey)).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]APIToken, 0, len(vals))
+	for name, data := range vals {
+		var t APIToken
+		if err := decodeRecord("api_token", []byte(data), &t); errors.Is(err, ErrNewerRecord) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("decoding API token %q: %w", name, err)
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// DeleteAPIToken removes the API token with the given name and reports
+// whether it existed.
+func (c *Client) DeleteAPIToken(ctx context.Context, name string) (bool, error) {
+	n, err := c.rdb.HDel(ctx, c.key(apiTokensKey), name).Result()
+	return n > 0, err
+}
+
+// AddTombstone records t and drops the tombstones of images reaped before
+// cutoff.
+func (c *Client) AddTombstone(ctx context.Context, t Tombstone, cutoff time.Time) error {
+	data, err := encodeRecord(t)
+	if err != nil {
+		return err
+	}
+	pipe := c.rdb.TxPipeline()
+	pipe.ZAdd(ctx, c.key(tombstonesKey), redis.Z{Score: float64(t.ReapedAt.UnixMilli()), Member: data})
+	pipe.ZRemRangeByScore(ctx, c.key(tombstonesKey), "-inf", fmt.Sprintf("(%d", cutoff.UnixMilli()))
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// ListTombstones returns the tombstones of images reaped since since,
+// oldest first.
+func (c *Client) ListTombstones(ctx context.Context, since time.Time) ([]Tombstone, error) {
+	vals, err := c.rdb.ZRangeByScore(ctx, c.key(tombstonesKey), &redis.ZRangeBy{
+		Min: strconv.FormatInt(since.UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Tombstone, 0, len(vals))
+	for _, data := range vals {
+		var t Tombstone
+		if err := decodeRecord("tombstone", []byte(data), &t); errors.Is(err, ErrNewerRecord) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("decoding tombstone: %w", err)
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// RecordDailyUsage stores u in a sorted set scored by day, replacing an
+// earlier sample of the same day, and drops the days before cutoff.
+func (c *Client) RecordDailyUsage(ctx context.Context, u DailyUsage, cutoff time.Time) error {
+	data, err := encodeRecord(u)
+	if err != nil {
+		return err
+	}
+	day := strconv.FormatInt(u.Day.UnixMilli(), 10)
+	pipe := c.rdb.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, c.key(dailyUsageKey), day, day)
+	pipe.ZAdd(ctx, c.key(dailyUsageKey), redis.Z{Score: float64(u.Day.UnixMilli()), Member: data})
+	pipe.ZRemRangeByScore(ctx, c.key(dailyUsageKey), "-inf", fmt.Sprintf("(%d", cutoff.UnixMilli()))
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// ListDailyUsage returns the days recorded since since, oldest first.
+func (c *Client) ListDailyUsage(ctx context.Context, since time.Time) ([]DailyUsage, error) {
+	vals, err := c.rdb.ZRangeByScore(ctx, c.key(dailyUsageKey), &redis.ZRangeBy{
+		Min: strconv.FormatInt(since.UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]DailyUsage, 0, len(vals))
+	for _, data := range vals {
+		var u DailyUsage
+		if err := decodeRecord("daily_usage", []byte(data), &u); errors.Is(err, ErrNewerRecord) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("decoding daily usage: %w", err)
+		}
+		out = append(out, u)
+	}
+	return out, nil
+}
+
+// AddSuperseded adds repo@digest to a sorted set scored by expiry, keeping
+// the later expiry of a manifest superseded twice.
+func (c *Client) AddSuperseded(ctx context.Context, m SupersededManifest) error {
+	return c.rdb.ZAddArgs(ctx, c.key(supersededKey), redis.ZAddArgs{
+		GT:      true,
+		Members: []redis.Z{{Score: float64(m.ExpiresAt.UnixMilli()), Member: m.Repository + "@" + m.Digest}},
+	}).Err()
+}
+
+// ExpiredSuperseded returns the superseded manifests expired at now,
+// soonest expired first.
+func (c *Client) ExpiredSuperseded(ctx context.Context, now time.Time) ([]SupersededManifest, error) {
+	vals, err := c.rdb.ZRangeByScoreWithScores(ctx, c.key(supersededKey), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]SupersededManifest, 0, len(vals))
+	for _, z := range vals {
+		member, _ := z.Member.(string)
+		repo, digest, ok := strings.Cut(member, "@")
+		if !ok {
+			continue
+		}
+		out = append(out, SupersededManifest{
+			Repository: repo,
+			Digest:     digest,
+			ExpiresAt:  time.UnixMilli(int64(z.Score)),
+		})
+	}
+	return out, nil
+}
+
+// RemoveSuperseded forgets the superseded manifest repo@digest.
+func (c *Client) RemoveSuperseded(ctx context.Context, repo, digest string) error {
+	return c.rdb.ZRem(ctx, c.key(supersededKey), repo+"@"+digest).Err()
+}
+
+// AddReconcileProgress records the tags of repo in the checkpoint of the
+// reconcile run started at startedAt, starting a checkpoint if there is none.
+func (c *Client) AddReconcileProgress(ctx context.Context, startedAt time.Time, repo string, tags []string) error {
+	data, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	pipe := c.rdb.TxPipeline()
+	pipe.SetNX(ctx, c.key(reconcileAtKey), startedAt.UnixMilli(), 0)
+	pipe.HSet(ctx, c.key(reconcileKey), repo, data)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// ReconcileProgress returns the checkpoint of the current reconcile run.
+func (c *Client) ReconcileProgress(ctx context.Context) (ReconcileCheckpoint, error) {
+	var cp ReconcileCheckpoint
+	ms, err := c.rdb.Get(ctx, c.key(reconcileAtKey)).Int64()
+	if err == redis.Nil {
+		return cp, nil
+	}
+	if err != nil {
+		return cp, err
+	}
+	vals, err := c.rdb.HGetAll(ctx, c.key(reconcileKey)).Result()
+	if err != nil {
+		return cp, err
+	}
+	cp.StartedAt = time.UnixMilli(ms)
+	cp.Tags = make(map[string][]string, len(vals))
+	for repo, data := range vals {
+		var tags []string
+		if err := json.Unmarshal([]byte(data), &tags); err != nil {
+			return cp, fmt.Errorf("decoding reconcile progress of %s: %w", repo, err)
+		}
+		cp.Tags[repo] = tags
+	}
+	return cp, nil
+}
+
+// ClearReconcileProgress drops the reconcile checkpoint.
+func (c *Client) ClearReconcileProgress(ctx context.Context) error {
+	return c.rdb.Del(ctx, c.key(reconcileAtKey), c.key(reconcileKey)).Err()
+}
+
+// ReconcileCursor returns the repository the next reconcile batch starts
+// after, empty to start at the beginning of the catalog.
+func (c *Client) ReconcileCursor(ctx context.Context) (string, error) {
+	cursor, err := c.rdb.Get(ctx, c.key(reconcileCurKey)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return cursor, err
+}
+
+// SetReconcileCursor stores the repository the next reconcile batch starts
+// after. An empty cursor starts over at the beginning of the catalog.
+func (c *Client) SetReconcileCursor(ctx context.Context, cursor string) error {
+	if cursor == "" {
+		return c.rdb.Del(ctx, c.key(reconcileCurKey)).Err()
+	}
+	return c.rdb.Set(ctx, c.key(reconcileCurKey), cursor, 0).Err()
+}
+
+// notifyConfig is the Redis setting selecting keyspace notifications.
+const notifyConfig = "notify-keyspace-events"
+
+// EnableExpiryNotifications turns on keyevent notifications for expired keys,
+// keeping the notifications other clients of a shared server rely on.
+// Managed Redis offerings often forbid CONFIG, in which case notifications
+// must be enabled server-side instead.
+func (c *Client) EnableExpiryNotifications(ctx context.Context) error {
+	current, err := c.rdb.ConfigGet(ctx, notifyConfig).Result()
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", notifyConfig, err)
+	}
+	flags := current[notifyConfig]
+	merged := withExpiryEvents(flags)
+	if merged == flags {
+		return nil
+	}
+	return c.rdb.ConfigSet(ctx, notifyConfig, merged).Err()
+}
+
+// withExpiryEvents adds keyevent notifications ("E") of expired keys ("x",
+// also part of "A") to the notify-keyspace-events flags.
+func withExpiryEvents(flags string) string {
+	if !strings.Contains(flags, "E") {
+		flags += "E"
+	}
+	if !strings.ContainsAny(flags, "xA") {
+		flags += "x"
+	}
+	return flags
+}
+
+// SubscribeExpirations returns a channel that receives the name of each image
+// whose expiry marker Redis has expired. The channel is closed when ctx is
+// cancelled.
+func (c *Client) SubscribeExpirations(ctx context.Context) (<-chan string, error) {
+	channel := fmt.Sprintf("__keyevent@%d__:expired", c.rdb.Options().DB)
+	pubsub := c.rdb.Subscribe(ctx, channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, fmt.Errorf("subscribing to %s: %w", channel, err)
+	}
+
+	markerPrefix := c.key(expiryKeyPrefix)
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		defer func() { _ = pubsub.Close() }()
+		msgs := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+				image, found := strings.CutPrefix(msg.Payload, markerPrefix)
+				if !found {
+					continue
+				}
+				select {
+				case out <- image:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
 }
@@ -0,0 +1,119 @@
+package redis
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+)
+
+// RecordVersion is the version of the encoding of stored records: the JSON
+// records (quarantine entries, freezes, rules, deletion requests, API tokens
+// and tombstones) and the hash of each tracked image. Replicas of different
+// versions share the store during rolling upgrades and downgrades, so:
+//
+//   - Adding a field does not change the version. Older readers ignore it.
+//   - Renaming or removing a field, or changing its meaning or unit, bumps
+//     the version, and a shim in recordShims upgrades records of the
+//     previous version so they are still read correctly.
+//
+// Records of a newer version than the reader knows are skipped rather than
+// misread; see ErrNewerRecord.
+const RecordVersion = 1
+
+// versionField is the field holding the record version, in JSON records
+// and image hashes alike.
+const versionField = "v"
+
+// ErrNewerRecord is returned for a record written by a newer version of
+// ephemeron with an incompatible encoding. Callers must leave such records
+// alone: neither act on them nor remove them.
+var ErrNewerRecord = errors.New("record written by a newer version")
+
+// recordShim upgrades the fields of a JSON record by one version in place.
+type recordShim func(fields map[string]json.RawMessage) error
+
+// recordShims upgrade JSON records from the version they are keyed by to
+// the next one. Version 0 records were written before records were
+// versioned and are encoded like version 1.
+var recordShims = map[int]recordShim{
+	0: func(map[string]json.RawMessage) error { return nil },
+}
+
+// encodeRecord marshals v, a struct, as a JSON record of RecordVersion.
+func encodeRecord(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 2 || data[0] != '{' {
+		return nil, fmt.Errorf("record %T does not encode as a JSON object", v)
+	}
+	head := `{"` + versionField + `":` + strconv.Itoa(RecordVersion)
+	if bytes.Equal(data, []byte("{}")) {
+		return []byte(head + "}"), nil
+	}
+	return append([]byte(head+","), data[1:]...), nil
+}
+
+// decodeRecord unmarshals the JSON record data of kind into v, upgrading
+// records of older versions with recordShims. It returns ErrNewerRecord
+// for records of a newer version.
+func decodeRecord(kind string, data []byte, v any) error {
+	var header struct {
+		Version int `json:"v"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return err
+	}
+	switch {
+	case header.Version > RecordVersion:
+		metrics.StoreNewerRecordsTotal.WithLabelValues(kind).Inc()
+		return fmt.Errorf("%w: %s record version %d, this version reads up to %d",
+			ErrNewerRecord, kind, header.Version, RecordVersion)
+	case header.Version == RecordVersion:
+		return json.Unmarshal(data, v)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for version := header.Version; version < RecordVersion; version++ {
+		shim, ok := recordShims[version]
+		if !ok {
+			return fmt.Errorf("no upgrade for %s records of version %d", kind, version)
+		}
+		if err := shim(fields); err != nil {
+			return fmt.Errorf("upgrading %s record from version %d: %w", kind, version, err)
+		}
+	}
+	upgraded, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(upgraded, v)
+}
+
+// checkHashVersion returns ErrNewerRecord if the version field of an image
+// hash, as returned by HGET or HMGET, is newer than RecordVersion. Hashes
+// without one were written before records were versioned.
+func checkHashVersion(image string, v any) error {
+	s, _ := v.(string)
+	if s == "" {
+		return nil
+	}
+	version, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("invalid record version %q of %s", s, image)
+	}
+	if version > RecordVersion {
+		metrics.StoreNewerRecordsTotal.WithLabelValues("image").Inc()
+		return fmt.Errorf("%w: %s has version %d, this version reads up to %d",
+			ErrNewerRecord, image, version, RecordVersion)
+	}
+	return nil
+}
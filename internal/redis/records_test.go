@@ -0,0 +1,92 @@
+package redis
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeRecord(t *testing.T) {
+	data, err := encodeRecord(Freeze{Pattern: "team-a/*", Reason: "release"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), `{"v":1,"pattern":"team-a/*"`) {
+		t.Errorf("encodeRecord() = %s, want the version first", data)
+	}
+	if data, _ := encodeRecord(struct{}{}); string(data) != `{"v":1}` {
+		t.Errorf("encodeRecord(empty) = %s", data)
+	}
+	if _, err := encodeRecord([]string{"a"}); err == nil {
+		t.Error("expected error for a record that is not an object")
+	}
+}
+
+func TestDecodeRecord(t *testing.T) {
+	until := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		data    string
+		wantErr error
+	}{
+		{name: "current", data: `{"v":1,"pattern":"app","until":"2026-01-02T03:04:05Z"}`},
+		{name: "before versioning", data: `{"pattern":"app","until":"2026-01-02T03:04:05Z"}`},
+		{name: "unknown fields", data: `{"v":1,"pattern":"app","until":"2026-01-02T03:04:05Z","scope":"x"}`},
+		{name: "newer", data: `{"v":2,"pattern":"app","until":"2026-01-02T03:04:05Z"}`, wantErr: ErrNewerRecord},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var f Freeze
+			err := decodeRecord("freeze", []byte(tt.data), &f)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("decodeRecord() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || f.Pattern != "app" || !f.Until.Equal(until) {
+				t.Errorf("decodeRecord() = %+v, %v", f, err)
+			}
+		})
+	}
+}
+
+func TestDecodeRecord_Shims(t *testing.T) {
+	saved := recordShims
+	t.Cleanup(func() { recordShims = saved })
+	// Pretend version 1 renamed "reason" from "why".
+	recordShims = map[int]recordShim{0: func(fields map[string]json.RawMessage) error {
+		fields["reason"] = fields["why"]
+		delete(fields, "why")
+		return nil
+	}}
+
+	var f Freeze
+	if err := decodeRecord("freeze", []byte(`{"pattern":"app","why":"release"}`), &f); err != nil {
+		t.Fatal(err)
+	}
+	if f.Reason != "release" {
+		t.Errorf("Reason = %q, want the upgraded field", f.Reason)
+	}
+
+	recordShims = map[int]recordShim{}
+	if err := decodeRecord("freeze", []byte(`{"pattern":"app"}`), &f); err == nil {
+		t.Error("expected error for a version without an upgrade")
+	}
+}
+
+func TestCheckHashVersion(t *testing.T) {
+	for _, v := range []any{nil, "", "1"} {
+		if err := checkHashVersion("app:1h", v); err != nil {
+			t.Errorf("checkHashVersion(%v) = %v", v, err)
+		}
+	}
+	if err := checkHashVersion("app:1h", "2"); !errors.Is(err, ErrNewerRecord) {
+		t.Errorf("checkHashVersion(2) = %v, want ErrNewerRecord", err)
+	}
+	if err := checkHashVersion("app:1h", "x"); err == nil || errors.Is(err, ErrNewerRecord) {
+		t.Errorf("checkHashVersion(x) = %v, want an invalid version error", err)
+	}
+}
@@ -2,23 +2,301 @@ package redis
 
 import (
 	"context"
+	"path"
 	"time"
 )
 
+// ImageMeta records who pushed an image, as reported by the registry's
+// webhook event. Source names the registry instance that sent the event.
+type ImageMeta struct {
+	Actor      string `json:"actor,omitempty" yaml:"actor,omitempty"`
+	SourceAddr string `json:"source_addr,omitempty" yaml:"source_addr,omitempty"`
+	UserAgent  string `json:"user_agent,omitempty" yaml:"user_agent,omitempty"`
+	Source     string `json:"source,omitempty" yaml:"source,omitempty"`
+}
+
+// QuarantinedImage is an expired image the reaper stopped trying to delete
+// after repeated failures.
+type QuarantinedImage struct {
+	Image    string    `json:"image" yaml:"image"`
+	Reason   string    `json:"reason" yaml:"reason"`
+	Failures int64     `json:"failures" yaml:"failures"`
+	Since    time.Time `json:"since" yaml:"since"`
+}
+
+// Freeze suspends deletions of the repositories matching Pattern until
+// Until.
+type Freeze struct {
+	// Pattern is a path.Match pattern for repositories; empty freezes all.
+	Pattern string    `json:"pattern" yaml:"pattern"`
+	Reason  string    `json:"reason,omitempty" yaml:"reason,omitempty"`
+	Since   time.Time `json:"since" yaml:"since"`
+	Until   time.Time `json:"until" yaml:"until"`
+}
+
+// Covers reports whether the freeze applies to repo.
+func (f Freeze) Covers(repo string) bool {
+	if f.Pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(f.Pattern, repo)
+	return ok
+}
+
+// CurrentSchema is the version of the store layout this build reads and
+// writes, recorded by the init command.
+const CurrentSchema = 1
+
+// Kinds of runtime-managed rules.
+const (
+	// RuleImmutableTag patterns match tags that must not be overwritten
+	// with different content.
+	RuleImmutableTag = "immutable_tag"
+	// RuleProtected patterns match "repo:tag" images the reaper must not
+	// delete.
+	RuleProtected = "protected"
+	// RuleRepoPolicy patterns match repositories with their own TTLs.
+	RuleRepoPolicy = "repo_policy"
+)
+
+// Rule is a retention rule managed at runtime rather than in config.
+type Rule struct {
+	Pattern string `json:"pattern" yaml:"pattern"`
+	// DefaultTTL and MaxTTL override the global TTLs for repository
+	// policies; zero keeps the global value.
+	DefaultTTL time.Duration `json:"default_ttl,omitempty" yaml:"default_ttl,omitempty"`
+	MaxTTL     time.Duration `json:"max_ttl,omitempty" yaml:"max_ttl,omitempty"`
+	// NonTTLTags is what happens to pushes whose tag is not a TTL under a
+	// repository policy: "track", "ignore" or "reject". Empty keeps the
+	// global action.
+	NonTTLTags string    `json:"non_ttl_tags,omitempty" yaml:"non_ttl_tags,omitempty"`
+	CreatedAt  time.Time `json:"created_at" yaml:"created_at"`
+}
+
+// Statuses of a DeletionRequest.
+const (
+	DeletionPending   = "pending"
+	DeletionApproved  = "approved"
+	DeletionExecuted  = "executed"
+	DeletionFailed    = "failed"
+	DeletionCancelled = "cancelled"
+)
+
+// DeletionEvent is an entry in a deletion request's audit trail.
+type DeletionEvent struct {
+	// Action is "requested", "approved", "cancelled", "executed" or "failed".
+	Action string    `json:"action" yaml:"action"`
+	Actor  string    `json:"actor,omitempty" yaml:"actor,omitempty"`
+	At     time.Time `json:"at" yaml:"at"`
+	Detail string    `json:"detail,omitempty" yaml:"detail,omitempty"`
+}
+
+// DeletionRequest asks for a protected image to be deleted. The reaper
+// only deletes it once someone other than the requester has approved it.
+type DeletionRequest struct {
+	ID          string          `json:"id" yaml:"id"`
+	Image       string          `json:"image" yaml:"image"`
+	Reason      string          `json:"reason,omitempty" yaml:"reason,omitempty"`
+	RequestedBy string          `json:"requested_by" yaml:"requested_by"`
+	Status      string          `json:"status" yaml:"status"`
+	Events      []DeletionEvent `json:"events" yaml:"events"`
+}
+
+// Roles of an APIToken, each allowed everything the previous one is.
+const (
+	// RoleReadOnly may only read from the internal API.
+	RoleReadOnly = "read-only"
+	// RoleOperator may also change expiries, freezes and quarantine.
+	RoleOperator = "operator"
+	// RoleAdmin may also manage rules.
+	RoleAdmin = "admin"
+)
+
+// APIToken grants access to the internal API. Only the SHA-256 hash of the
+// token itself is stored.
+type APIToken struct {
+	Name string `json:"name" yaml:"name"`
+	Role string `json:"role" yaml:"role"`
+	// Repositories are path.Match patterns for the repositories the token
+	// may change; empty allows all of them.
+	Repositories []string  `json:"repositories,omitempty" yaml:"repositories,omitempty"`
+	Hash         string    `json:"hash,omitempty" yaml:"-"`
+	CreatedAt    time.Time `json:"created_at" yaml:"created_at"`
+}
+
+// Tombstone records an image the reaper deleted, kept for a while so it can
+// be told where an image went.
+type Tombstone struct {
+	Image      string    `json:"image" yaml:"image"`
+	Repository string    `json:"repository" yaml:"repository"`
+	Tag        string    `json:"tag" yaml:"tag"`
+	Digest     string    `json:"digest,omitempty" yaml:"digest,omitempty"`
+	SizeBytes  int64     `json:"size_bytes" yaml:"size_bytes"`
+	ReapedAt   time.Time `json:"reaped_at" yaml:"reaped_at"`
+	// Reason is why the image was deleted, e.g. "expired" or "evicted".
+	Reason string `json:"reason" yaml:"reason"`
+	// MediaType and Manifest hold the deleted manifest as the registry
+	// served it, kept to restore the image.
+	MediaType string `json:"media_type,omitempty" yaml:"media_type,omitempty"`
+	Manifest  []byte `json:"manifest,omitempty" yaml:"-"`
+}
+
+// RepoStats are the lifetime push statistics of a repository.
+type RepoStats struct {
+	Repository string `json:"repository" yaml:"repository"`
+	Pushes     int64  `json:"pushes" yaml:"pushes"`
+	// SizedPushes counts the pushes whose image size was known, the ones
+	// TotalBytes adds up.
+	SizedPushes int64     `json:"sized_pushes" yaml:"sized_pushes"`
+	TotalBytes  int64     `json:"total_bytes" yaml:"total_bytes"`
+	LastPush    time.Time `json:"last_push" yaml:"last_push"`
+}
+
+// AverageBytes returns the average size of the repository's pushed images,
+// or 0 if none had a known size.
+func (s RepoStats) AverageBytes() int64 {
+	if s.SizedPushes == 0 {
+		return 0
+	}
+	return s.TotalBytes / s.SizedPushes
+}
+
+// DailyUsage is the storage tracked per repository on a day, as last
+// sampled that day.
+type DailyUsage struct {
+	// Day is midnight UTC of the day.
+	Day time.Time `json:"day" yaml:"day"`
+	// Repositories maps repositories to their tracked bytes.
+	Repositories map[string]int64 `json:"repositories" yaml:"repositories"`
+}
+
+// ManifestRecord is the size and digest an image is tracked with.
+type ManifestRecord struct {
+	SizeBytes int64
+	Digest    string
+}
+
+// SupersededManifest is a manifest a tag of a cache repository pointed at
+// before the tag was pushed again, deleted by digest once it expires.
+type SupersededManifest struct {
+	Repository string    `json:"repository" yaml:"repository"`
+	Digest     string    `json:"digest" yaml:"digest"`
+	ExpiresAt  time.Time `json:"expires_at" yaml:"expires_at"`
+}
+
+// ReconcileCheckpoint is the progress of a reconcile run, so an interrupted
+// one resumes without listing every repository again.
+type ReconcileCheckpoint struct {
+	// StartedAt is when the run began; zero if there is no checkpoint.
+	StartedAt time.Time
+	// Tags maps each repository listed so far to its tags.
+	Tags map[string][]string
+}
+
+// Reasons Degraded reports a store as unsafe to delete by.
+const (
+	// DegradedLoading means the server is still loading its dataset.
+	DegradedLoading = "loading"
+	// DegradedReplicaLinkDown means the server is a replica that lost its
+	// primary, e.g. during a failover.
+	DegradedReplicaLinkDown = "replica_link_down"
+	// DegradedReplicaSyncing means the server is a replica still
+	// synchronizing with its primary.
+	DegradedReplicaSyncing = "replica_syncing"
+	// DegradedReplicationLag means a replica of the primary lags behind by
+	// more than the configured maximum.
+	DegradedReplicationLag = "replication_lag"
+)
+
 // Store defines the interface for image TTL tracking operations.
 type Store interface {
 	Ping(ctx context.Context) error
+	// Degraded returns why the store may serve stale or soon-to-be-lost
+	// data, or "" if it is healthy.
+	Degraded(ctx context.Context) (string, error)
 	Close() error
-	TrackImage(ctx context.Context, imageWithTag string, expiresAt time.Time, sizeBytes int64, digest string) error
+	TrackImage(
+		ctx context.Context,
+		imageWithTag string,
+		expiresAt time.Time,
+		sizeBytes int64,
+		digest string,
+		meta ImageMeta,
+	) error
 	ListImages(ctx context.Context) ([]string, error)
 	GetExpiry(ctx context.Context, imageWithTag string) (int64, error)
+	SetExpiry(ctx context.Context, imageWithTag string, expiresAt time.Time) (bool, error)
+	SetExpiries(ctx context.Context, expiries map[string]time.Time) ([]string, error)
+	SetManifestInfo(ctx context.Context, imageWithTag string, sizeBytes int64, digest string) (bool, error)
+	// SetImageSize changes the size of an image only while it is still
+	// tracked with digest, reporting whether it did.
+	SetImageSize(ctx context.Context, imageWithTag, digest string, sizeBytes int64) (bool, error)
+	// GetManifestRecords returns the size and digest of each of images in
+	// one round trip, omitting images that are not tracked.
+	GetManifestRecords(ctx context.Context, images []string) (map[string]ManifestRecord, error)
 	GetImageSize(ctx context.Context, imageWithTag string) (int64, error)
 	GetImageDigest(ctx context.Context, imageWithTag string) (string, error)
 	GetCreatedTimestamp(ctx context.Context, imageWithTag string) (int64, error)
+	GetImageMeta(ctx context.Context, imageWithTag string) (ImageMeta, error)
+	// GetImageRecord returns every field of an image's record as stored,
+	// with encrypted fields decrypted, or an empty map if it is untracked.
+	GetImageRecord(ctx context.Context, imageWithTag string) (map[string]string, error)
+	Aliases(ctx context.Context, imageWithTag string) ([]string, error)
+	// TaggedImages returns the tracked images of repo that point at digest.
+	TaggedImages(ctx context.Context, repo, digest string) ([]string, error)
+	SetProtected(ctx context.Context, imageWithTag string, protected bool) error
+	IsProtected(ctx context.Context, imageWithTag string) (bool, error)
+	SetPriority(ctx context.Context, imageWithTag string, priority int) error
+	GetPriority(ctx context.Context, imageWithTag string) (int, error)
 	RemoveImage(ctx context.Context, imageWithTag string) error
-	AcquireReaperLock(ctx context.Context, ttl time.Duration) (bool, error)
-	ReleaseReaperLock(ctx context.Context) error
+	AcquireReaperLock(ctx context.Context, ttl time.Duration) (int64, error)
+	RenewReaperLock(ctx context.Context, token int64, ttl time.Duration) (bool, error)
+	ReleaseReaperLock(ctx context.Context, token int64) error
 	IsInitialized(ctx context.Context) (bool, error)
 	SetInitialized(ctx context.Context) error
+	SchemaVersion(ctx context.Context) (int, error)
+	SetSchemaVersion(ctx context.Context, version int) error
 	ImageCount(ctx context.Context) (int64, error)
+	TrackedBytes(ctx context.Context) (int64, error)
+	RecordReap(ctx context.Context, sizeBytes int64) error
+	ReapTotals(ctx context.Context) (images, bytes int64, err error)
+	// RecordPush adds a push of an image of sizeBytes, 0 if unknown, to
+	// repo's push statistics.
+	RecordPush(ctx context.Context, repo string, sizeBytes int64, at time.Time) error
+	// ListRepoStats returns the push statistics of every repository pushed
+	// to, sorted by repository.
+	ListRepoStats(ctx context.Context) ([]RepoStats, error)
+	// RecordDailyUsage stores u, replacing an earlier sample of the same
+	// day, and drops the days before cutoff.
+	RecordDailyUsage(ctx context.Context, u DailyUsage, cutoff time.Time) error
+	// ListDailyUsage returns the days recorded since since, oldest first.
+	ListDailyUsage(ctx context.Context, since time.Time) ([]DailyUsage, error)
+	// AddSuperseded schedules the deletion of a superseded manifest, keeping
+	// the later expiry if it is already scheduled.
+	AddSuperseded(ctx context.Context, m SupersededManifest) error
+	// ExpiredSuperseded returns the superseded manifests expired at now.
+	ExpiredSuperseded(ctx context.Context, now time.Time) ([]SupersededManifest, error)
+	RemoveSuperseded(ctx context.Context, repo, digest string) error
+	RecordDeleteFailure(ctx context.Context, imageWithTag string) (int64, error)
+	QuarantineImage(ctx context.Context, q QuarantinedImage) error
+	ListQuarantined(ctx context.Context) ([]QuarantinedImage, error)
+	ReleaseQuarantined(ctx context.Context, imageWithTag string) error
+	SetFreeze(ctx context.Context, f Freeze) error
+	ListFreezes(ctx context.Context) ([]Freeze, error)
+	Unfreeze(ctx context.Context, pattern string) error
+	PutRule(ctx context.Context, kind string, r Rule) error
+	ListRules(ctx context.Context, kind string) ([]Rule, error)
+	DeleteRule(ctx context.Context, kind, pattern string) (bool, error)
+	PutDeletion(ctx context.Context, d DeletionRequest) error
+	ListDeletions(ctx context.Context) ([]DeletionRequest, error)
+	PutAPIToken(ctx context.Context, t APIToken) error
+	ListAPITokens(ctx context.Context) ([]APIToken, error)
+	DeleteAPIToken(ctx context.Context, name string) (bool, error)
+	AddTombstone(ctx context.Context, t Tombstone, cutoff time.Time) error
+	ListTombstones(ctx context.Context, since time.Time) ([]Tombstone, error)
+	AddReconcileProgress(ctx context.Context, startedAt time.Time, repo string, tags []string) error
+	ReconcileProgress(ctx context.Context) (ReconcileCheckpoint, error)
+	ClearReconcileProgress(ctx context.Context) error
+	ReconcileCursor(ctx context.Context) (string, error)
+	SetReconcileCursor(ctx context.Context, cursor string) error
 }
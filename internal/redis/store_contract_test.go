@@ -0,0 +1,133 @@
+package redis_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/tamcore/ephemeron/internal/fieldcrypt"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+	"github.com/tamcore/ephemeron/internal/storetest"
+)
+
+// TestStoreContract runs the store conformance suite against the Redis at
+// EPHEMERON_TEST_REDIS_URL, or an in-process miniredis if it is unset.
+func TestStoreContract(t *testing.T) {
+	url := testRedisURL(t)
+
+	t.Run("Plain", func(t *testing.T) { storetest.Run(t, newTestClient(url)) })
+	keyring, err := fieldcrypt.New(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("Encrypted", func(t *testing.T) {
+		storetest.Run(t, newTestClient(url, redisclient.WithFieldEncryption(keyring)))
+	})
+}
+
+// TestReencrypt checks that rotating the field encryption key keeps
+// fields readable.
+func TestReencrypt(t *testing.T) {
+	url := testRedisURL(t)
+	ctx := t.Context()
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	before, _ := fieldcrypt.New(oldKey)
+	after, _ := fieldcrypt.New(newKey, oldKey)
+	retired, _ := fieldcrypt.New(newKey)
+	prefix := fmt.Sprintf("reencrypt:%d:", time.Now().UnixNano())
+	meta := redisclient.ImageMeta{Actor: "ci-bot", SourceAddr: "10.0.0.7:4312", UserAgent: "docker/27.0"}
+
+	plain := newTestClientAt(t, url, redisclient.WithKeyPrefix(prefix))
+	if err := plain.TrackImage(ctx, "legacy:1h", time.Now().Add(time.Hour), 1, "sha256:a", meta); err != nil {
+		t.Fatal(err)
+	}
+	old := newTestClientAt(t, url, redisclient.WithKeyPrefix(prefix), redisclient.WithFieldEncryption(before))
+	if err := old.TrackImage(ctx, "app:1h", time.Now().Add(time.Hour), 1, "sha256:b", meta); err != nil {
+		t.Fatal(err)
+	}
+	d := redisclient.DeletionRequest{ID: "d1", Image: "app:1h", RequestedBy: "alice"}
+	if err := old.PutDeletion(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := plain.GetImageMeta(ctx, "app:1h"); got.Actor != "" {
+		t.Errorf("actor readable without the key: %q", got.Actor)
+	}
+
+	rotated := newTestClientAt(t, url, redisclient.WithKeyPrefix(prefix), redisclient.WithFieldEncryption(after))
+	n, err := rotated.Reencrypt(ctx)
+	if err != nil || n != 7 {
+		t.Fatalf("Reencrypt = %d, %v; want 7 fields rewritten", n, err)
+	}
+	if n, err := rotated.Reencrypt(ctx); err != nil || n != 0 {
+		t.Errorf("second Reencrypt = %d, %v; want nothing left to rewrite", n, err)
+	}
+
+	final := newTestClientAt(t, url, redisclient.WithKeyPrefix(prefix), redisclient.WithFieldEncryption(retired))
+	for _, image := range []string{"legacy:1h", "app:1h"} {
+		if got, err := final.GetImageMeta(ctx, image); err != nil || got != meta {
+			t.Errorf("GetImageMeta(%s) = %+v, %v; want %+v", image, got, err, meta)
+		}
+	}
+	if list, err := final.ListDeletions(ctx); err != nil || len(list) != 1 || list[0].RequestedBy != "alice" {
+		t.Errorf("ListDeletions = %+v, %v", list, err)
+	}
+}
+
+// testRedisURL returns EPHEMERON_TEST_REDIS_URL, or the URL of a miniredis
+// running until the test ends.
+func testRedisURL(t *testing.T) string {
+	if url := os.Getenv("EPHEMERON_TEST_REDIS_URL"); url != "" {
+		return url
+	}
+	m := miniredis.RunT(t)
+	// miniredis only expires keys when told time has passed; the suite,
+	// written for a real server, sleeps past TTLs instead.
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	go func() {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				m.FastForward(10 * time.Millisecond)
+			}
+		}
+	}()
+	return "redis://" + m.Addr()
+}
+
+// newTestClient returns a storetest.Factory creating clients with a fresh
+// key prefix.
+func newTestClient(url string, opts ...redisclient.Option) storetest.Factory {
+	return func(t *testing.T) redisclient.Store {
+		prefix := fmt.Sprintf("storetest:%d:%s:", time.Now().UnixNano(), t.Name())
+		return newTestClientAt(t, url, append([]redisclient.Option{redisclient.WithKeyPrefix(prefix)}, opts...)...)
+	}
+}
+
+// newTestClientAt connects to url and removes the images it tracked when
+// the test ends.
+func newTestClientAt(t *testing.T, url string, opts ...redisclient.Option) *redisclient.Client {
+	t.Helper()
+	c, err := redisclient.New(url, opts...)
+	if err != nil {
+		t.Fatalf("connecting to redis: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		images, _ := c.ListImages(ctx)
+		for _, image := range images {
+			_ = c.RemoveImage(ctx, image)
+		}
+		_ = c.Close()
+	})
+	return c
+}
@@ -0,0 +1,238 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultTokenTTL is used when a token response carries no expires_in, as
+// the token authentication spec prescribes.
+const defaultTokenTTL = 60 * time.Second
+
+// tokenExpiryMargin renews cached tokens slightly before they expire.
+const tokenExpiryMargin = 5 * time.Second
+
+// WithBasicAuth authenticates against the registry with username and
+// password. Registries answering with a Bearer challenge are sent the
+// credentials only at their token endpoint.
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		if username != "" {
+			c.auth = &authenticator{
+				username: username,
+				password: password,
+				tokens:   make(map[string]cachedToken),
+			}
+		}
+	}
+}
+
+// challenge is a parsed WWW-Authenticate header.
+type challenge struct {
+	scheme string
+	params map[string]string
+}
+
+type cachedToken struct {
+	token   string
+	expires time.Time
+}
+
+// authenticator answers registry auth challenges. It remembers the last
+// challenge so later requests carry credentials up front, and caches bearer
+// tokens per scope so a run over many images negotiates each scope once.
+type authenticator struct {
+	username string
+	password string
+
+	mu     sync.Mutex
+	scheme string                 // "basic" or "bearer" once challenged
+	realm  string                 // token endpoint from the last bearer challenge
+	svc    string                 // service from the last bearer challenge
+	tokens map[string]cachedToken // keyed by requestScope
+}
+
+// authorize adds credentials to req once a previous challenge showed which
+// kind the registry expects. For bearer auth it uses the cached token for
+// the request's scope, fetching one from the remembered realm if needed, so
+// only the first request of a client pays for a 401 round trip. Failures
+// are left to the challenge on the response.
+func (a *authenticator) authorize(c *Client, req *http.Request) {
+	scope := requestScope(req)
+	a.mu.Lock()
+	scheme, realm, svc := a.scheme, a.realm, a.svc
+	t, cached := a.tokens[scope]
+	a.mu.Unlock()
+
+	switch scheme {
+	case "basic":
+		req.SetBasicAuth(a.username, a.password)
+	case "bearer":
+		if !cached || !time.Now().Before(t.expires) {
+			token, ttl, err := a.fetchToken(c, req, realm, svc, scope)
+			if err != nil {
+				return
+			}
+			t = a.store(scope, token, ttl)
+		}
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+}
+
+// store caches token for scope and returns the cache entry.
+func (a *authenticator) store(scope, token string, ttl time.Duration) cachedToken {
+	t := cachedToken{token: token, expires: time.Now().Add(ttl - tokenExpiryMargin)}
+	a.mu.Lock()
+	a.tokens[scope] = t
+	a.mu.Unlock()
+	return t
+}
+
+// handleChallenge records the challenge in resp and prepares credentials
+// for retrying req. It reports whether a retry is worthwhile.
+func (a *authenticator) handleChallenge(c *Client, req *http.Request, resp *http.Response) (bool, error) {
+	ch, ok := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+	if !ok {
+		return false, nil
+	}
+	switch ch.scheme {
+	case "basic":
+		a.mu.Lock()
+		a.scheme = "basic"
+		a.mu.Unlock()
+		return true, nil
+	case "bearer":
+		scope := ch.params["scope"]
+		if scope == "" {
+			scope = requestScope(req)
+		}
+		token, ttl, err := a.fetchToken(c, req, ch.params["realm"], ch.params["service"], scope)
+		if err != nil {
+			return false, err
+		}
+		a.mu.Lock()
+		a.scheme = "bearer"
+		a.realm = ch.params["realm"]
+		a.svc = ch.params["service"]
+		a.mu.Unlock()
+		a.store(requestScope(req), token, ttl)
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// fetchToken requests a bearer token for scope from realm.
+func (a *authenticator) fetchToken(
+	c *Client,
+	req *http.Request,
+	realm, service, scope string,
+) (string, time.Duration, error) {
+	if realm == "" {
+		return "", 0, fmt.Errorf("bearer challenge without realm")
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return "", 0, fmt.Errorf("parsing token realm: %w", err)
+	}
+	q := u.Query()
+	if service != "" {
+		q.Set("service", service)
+	}
+	q.Set("scope", scope)
+	u.RawQuery = q.Encode()
+
+	treq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", 0, fmt.Errorf("creating token request: %w", err)
+	}
+	treq.SetBasicAuth(a.username, a.password)
+
+	resp, err := c.httpClient.Do(treq)
+	if err != nil {
+		return "", 0, fmt.Errorf("fetching token: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token request failed: status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", 0, fmt.Errorf("decoding token response: %w", err)
+	}
+	token := body.Token
+	if token == "" {
+		token = body.AccessToken
+	}
+	if token == "" {
+		return "", 0, fmt.Errorf("token response without token")
+	}
+	ttl := defaultTokenTTL
+	if body.ExpiresIn > 0 {
+		ttl = time.Duration(body.ExpiresIn) * time.Second
+	}
+	return token, ttl, nil
+}
+
+// requestScope returns the token scope a request needs, used as the token
+// cache key: "repository:<name>:<actions>" for repository endpoints and
+// "registry:catalog:*" for the catalog.
+func requestScope(req *http.Request) string {
+	p := strings.TrimPrefix(req.URL.Path, "/v2/")
+	if p == "_catalog" {
+		return "registry:catalog:*"
+	}
+	actions := "pull"
+	switch req.Method {
+	case http.MethodDelete:
+		actions = "delete"
+	case http.MethodPost, http.MethodPut:
+		actions = "pull,push"
+	}
+	for _, sep := range []string{"/manifests/", "/tags/", "/blobs/"} {
+		if i := strings.LastIndex(p, sep); i >= 0 {
+			return "repository:" + p[:i] + ":" + actions
+		}
+	}
+	return "registry:" + p + ":" + actions
+}
+
+// parseChallenge parses a WWW-Authenticate header such as
+// `Bearer realm="https://auth",service="registry",scope="repository:a:pull"`.
+func parseChallenge(header string) (challenge, bool) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	if scheme == "" {
+		return challenge{}, false
+	}
+	ch := challenge{scheme: strings.ToLower(scheme), params: make(map[string]string)}
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		key, after, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		var val string
+		if strings.HasPrefix(after, `"`) {
+			end := strings.Index(after[1:], `"`)
+			if end < 0 {
+				break
+			}
+			val, rest = after[1:end+1], after[end+2:]
+		} else {
+			val, rest, _ = strings.Cut(after, ",")
+		}
+		ch.params[key] = val
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return ch, true
+}
@@ -0,0 +1,157 @@
+package registry
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseChallenge(t *testing.T) {
+	header := `Bearer realm="https://auth.example.com/token",service="registry",scope="repository:a/b:pull,push"`
+	ch, ok := parseChallenge(header)
+	if !ok || ch.scheme != "bearer" {
+		t.Fatalf("unexpected challenge %+v", ch)
+	}
+	want := map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry",
+		"scope":   "repository:a/b:pull,push",
+	}
+	for k, v := range want {
+		if ch.params[k] != v {
+			t.Errorf("%s = %q, want %q", k, ch.params[k], v)
+		}
+	}
+}
+
+func TestRequestScope(t *testing.T) {
+	tests := []struct {
+		method, path, want string
+	}{
+		{http.MethodHead, "/v2/team/app/manifests/1h", "repository:team/app:pull"},
+		{http.MethodDelete, "/v2/app/manifests/sha256:abc", "repository:app:delete"},
+		{http.MethodPut, "/v2/app/manifests/1h", "repository:app:pull,push"},
+		{http.MethodGet, "/v2/app/tags/list", "repository:app:pull"},
+		{http.MethodGet, "/v2/_catalog", "registry:catalog:*"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := requestScope(req); got != tt.want {
+			t.Errorf("requestScope(%s %s) = %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestBearerAuth_CachesTokens(t *testing.T) {
+	var tokenFetches, unauthorized atomic.Int32
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			user, pass, _ := r.BasicAuth()
+			if user != "robot" || pass != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			tokenFetches.Add(1)
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"token":      "tok-" + r.URL.Query().Get("scope"),
+				"expires_in": 300,
+			})
+			return
+		}
+		want := "Bearer tok-" + requestScope(r)
+		if r.Header.Get("Authorization") != want {
+			unauthorized.Add(1)
+			w.Header().Set("WWW-Authenticate",
+				`Bearer realm="`+srv.URL+`/token",service="registry",scope="`+requestScope(r)+`"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", "sha256:abc")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithBasicAuth("robot", "secret"))
+	for _, repo := range []string{"app", "app", "app", "other"} {
+		digest, found, err := c.ManifestDigest(t.Context(), repo, "1h")
+		if err != nil || !found || digest != "sha256:abc" {
+			t.Fatalf("ManifestDigest(%s) = %q, %v, %v", repo, digest, found, err)
+		}
+	}
+	if n := tokenFetches.Load(); n != 2 {
+		t.Errorf("expected one token fetch per scope (2), got %d", n)
+	}
+	// Only the first request is challenged; the realm is then reused.
+	if n := unauthorized.Load(); n != 1 {
+		t.Errorf("expected 1 challenge, got %d", n)
+	}
+}
+
+func TestBearerAuth_ReplaysBody(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			_ = json.NewEncoder(w).Encode(map[string]any{"token": "tok"})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "{}" {
+			t.Errorf("expected body to be replayed, got %q", body)
+		}
+		w.Header().Set("Docker-Content-Digest", "sha256:abc")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithBasicAuth("robot", "secret"))
+	m := &RawManifest{MediaType: MediaTypeOCIManifest, Body: []byte("{}")}
+	digest, err := c.PutManifest(t.Context(), "app", "1h", m)
+	if err != nil || digest != "sha256:abc" {
+		t.Fatalf("PutManifest = %q, %v", digest, err)
+	}
+}
+
+func TestBasicAuth_Challenge(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if user, pass, ok := r.BasicAuth(); !ok || user != "u" || pass != "p" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithBasicAuth("u", "p"))
+	for range 2 {
+		if err := c.DeleteManifest(t.Context(), "app", "sha256:abc"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("expected 3 requests (one challenged), got %d", n)
+	}
+}
+
+func TestNoAuth_Unauthorized(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	if _, _, err := New(srv.URL).ManifestDigest(t.Context(), "app", "1h"); err == nil {
+		t.Fatal("expected error without credentials")
+	}
+}
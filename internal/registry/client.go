@@ -1,30 +1,149 @@
 package registry
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
 	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
 )
 
+// ErrNotFound is wrapped by errors for repositories or manifests the
+// registry does not know.
+var ErrNotFound = errors.New("not found")
+
+// ErrDeleteRefused is wrapped by ProbeDelete errors when the registry would
+// reject every deletion.
+var ErrDeleteRefused = errors.New("registry refuses deletions")
+
+// probeDigest names a manifest no repository contains.
+const probeDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+// StatusError reports an unexpected HTTP status from a manifest request.
+type StatusError struct {
+	Op         string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s manifest returned %d", e.Op, e.StatusCode)
+}
+
+// Permanent reports whether retrying the request is pointless: the registry
+// refused it with a client error other than a timeout or rate limit.
+func (e *StatusError) Permanent() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return e.StatusCode >= 400 && e.StatusCode < 500
+}
+
 // maxPages bounds pagination loops so a registry returning broken
 // Link headers cannot keep the client looping forever.
 const maxPages = 100
 
+// Manifest media types understood by the client.
+const (
+	MediaTypeOCIManifest      = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeDockerManifest   = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerSchema1    = "application/vnd.docker.distribution.manifest.v1+json"
+	MediaTypeDockerSchema1JWS = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	MediaTypeOCIIndex         = "application/vnd.oci.image.index.v1+json"
+	MediaTypeDockerList       = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// Artifact types reported in ManifestInfo.ArtifactType.
+const (
+	ArtifactImage = "image"
+	ArtifactHelm  = "helm"
+	ArtifactWASM  = "wasm"
+	ArtifactOther = "artifact"
+)
+
+// Config media types that identify container images.
+const (
+	mediaTypeOCIImageConfig    = "application/vnd.oci.image.config.v1+json"
+	mediaTypeDockerImageConfig = "application/vnd.docker.container.image.v1+json"
+	mediaTypeHelmConfig        = "application/vnd.cncf.helm.config.v1+json"
+)
+
+// DefaultTimeout bounds each registry request, including reading the
+// response body, unless WithTimeout says otherwise.
+const DefaultTimeout = 30 * time.Second
+
+// DefaultAcceptTypes is the manifest Accept list sent when none is configured.
+var DefaultAcceptTypes = []string{MediaTypeOCIManifest, MediaTypeDockerManifest}
+
 // Client talks to the OCI distribution registry HTTP API.
 type Client struct {
-	baseURL    string
-	httpClient *http.Client
+	baseURL      string
+	httpClient   *http.Client
+	acceptTypes  []string
+	auth         *authenticator
+	retries      int
+	retryBackoff time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithAcceptTypes overrides the media types sent in the Accept header when
+// fetching manifests. An empty list keeps DefaultAcceptTypes.
+func WithAcceptTypes(types []string) Option {
+	return func(c *Client) {
+		if len(types) > 0 {
+			c.acceptTypes = types
+		}
+	}
+}
+
+// WithTransport sends requests through rt instead of the default transport.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Client) {
+		c.httpClient.Transport = rt
+	}
+}
+
+// WithTimeout bounds each request, including reading the response body,
+// instead of DefaultTimeout. Zero removes the bound.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.httpClient.Timeout = d
+	}
+}
+
+// WithRetries sends requests that failed with a network error, 429 Too Many
+// Requests or a 502, 503 or 504 again, up to n times, waiting backoff before
+// the first retry and twice as long before each further one.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = n
+		c.retryBackoff = backoff
+	}
 }
 
 // New creates a new registry client.
-func New(registryURL string) *Client {
-	return &Client{
-		baseURL:    strings.TrimRight(registryURL, "/"),
-		httpClient: &http.Client{Timeout: 30 * time.Second},
+func New(registryURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:     strings.TrimRight(registryURL, "/"),
+		httpClient:  &http.Client{Timeout: DefaultTimeout},
+		acceptTypes: DefaultAcceptTypes,
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	return c
 }
 
 type catalogResponse struct {
@@ -38,13 +157,16 @@ type tagsResponse struct {
 // ManifestV2 represents an OCI/Docker image manifest v2.
 type ManifestV2 struct {
 	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	ArtifactType  string          `json:"artifactType,omitempty"`
 	Config        ManifestConfig  `json:"config"`
 	Layers        []ManifestLayer `json:"layers"`
 }
 
 // ManifestConfig contains the image configuration descriptor.
 type ManifestConfig struct {
-	Size int64 `json:"size"`
+	MediaType string `json:"mediaType,omitempty"`
+	Size      int64  `json:"size"`
 }
 
 // ManifestLayer represents a single layer in the image.
@@ -52,10 +174,121 @@ type ManifestLayer struct {
 	Size int64 `json:"size"`
 }
 
+// maxManifestBytes bounds how much of a manifest body is read.
+const maxManifestBytes = 4 << 20
+
+// genericManifest captures the descriptor lists used by OCI artifacts and
+// other non-image manifests, so their sizes can be summed without knowing
+// the exact media type.
+type genericManifest struct {
+	Config *ManifestConfig `json:"config"`
+	Layers []ManifestLayer `json:"layers"`
+	Blobs  []ManifestLayer `json:"blobs"`
+}
+
 // ManifestInfo contains metadata about an image manifest.
 type ManifestInfo struct {
 	Digest    string
 	SizeBytes int64
+	// MediaType is the manifest media type, when the registry reported one.
+	MediaType string
+	// ArtifactType classifies the content, e.g. ArtifactImage or ArtifactHelm.
+	ArtifactType string
+	// Unsupported is true when the manifest format is not understood.
+	// SizeBytes is then a best-effort estimate: the sum of any descriptor
+	// sizes found, or the size of the manifest itself.
+	Unsupported bool
+}
+
+// Descriptor identifies content referenced by a manifest.
+type Descriptor struct {
+	MediaType string `json:"mediaType,omitempty"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// References lists what a manifest points at.
+type References struct {
+	// Digest is the digest of the manifest itself.
+	Digest string
+	// Config is the digest of an image manifest's config blob, empty for
+	// indexes and manifest lists.
+	Config string
+	// Blobs are the config, layers and artifact blobs of an image manifest.
+	Blobs []Descriptor
+	// Manifests are the child manifests of an index or manifest list.
+	Manifests []Descriptor
+}
+
+// referencesManifest captures the descriptor lists of any manifest type.
+type referencesManifest struct {
+	Config    *Descriptor  `json:"config"`
+	Layers    []Descriptor `json:"layers"`
+	Blobs     []Descriptor `json:"blobs"`
+	Manifests []Descriptor `json:"manifests"`
+}
+
+// ManifestReferences fetches the manifest repo:ref, accepting indexes and
+// manifest lists as well, and returns the blobs and child manifests it
+// references. It wraps ErrNotFound if the manifest does not exist.
+func (c *Client) ManifestReferences(ctx context.Context, repo, ref string) (*References, error) {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", c.baseURL, repo, ref)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating manifest request: %w", err)
+	}
+	accept := append(slices.Clone(c.acceptTypes), MediaTypeOCIIndex, MediaTypeDockerList)
+	req.Header.Set("Accept", strings.Join(accept, ","))
+
+	resp, err := c.do(metrics.OpManifestGet, req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching manifest for %s:%s: %w", repo, ref, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("manifest %s:%s: %w", repo, ref, ErrNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("manifest request failed for %s:%s: status %d", repo, ref, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestBytes))
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest for %s:%s: %w", repo, ref, err)
+	}
+	refs, err := ParseReferences(body)
+	if err != nil {
+		return nil, fmt.Errorf("decoding manifest for %s:%s: %w", repo, ref, err)
+	}
+	refs.Digest = responseDigest(resp)
+	return refs, nil
+}
+
+// ParseReferences returns the blobs and child manifests a manifest of any
+// type references. The Digest of the result is left empty.
+func ParseReferences(body []byte) (*References, error) {
+	var m referencesManifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, err
+	}
+	refs := &References{Manifests: m.Manifests}
+	if m.Config != nil {
+		refs.Config = m.Config.Digest
+		refs.Blobs = append(refs.Blobs, *m.Config)
+	}
+	refs.Blobs = append(refs.Blobs, m.Layers...)
+	refs.Blobs = append(refs.Blobs, m.Blobs...)
+	return refs, nil
+}
+
+// responseDigest returns the manifest digest reported by a registry
+// response, falling back to the ETag.
+func responseDigest(resp *http.Response) string {
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest
+	}
+	return strings.Trim(resp.Header.Get("ETag"), `"`)
 }
 
 // ListRepositories returns all repository names from the registry catalog.
@@ -67,34 +300,71 @@ func (c *Client) ListRepositories(ctx context.Context) ([]string, error) {
 		if page >= maxPages {
 			return nil, fmt.Errorf("catalog pagination exceeded %d pages", maxPages)
 		}
-
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		repos, next, err := c.catalogPage(ctx, url)
 		if err != nil {
-			return nil, fmt.Errorf("creating catalog request: %w", err)
+			return nil, err
 		}
+		all = append(all, repos...)
+		url = next
+	}
 
-		resp, err := c.httpClient.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("listing catalog: %w", err)
-		}
+	return all, nil
+}
 
-		if resp.StatusCode != http.StatusOK {
-			_ = resp.Body.Close()
-			return nil, fmt.Errorf("catalog request failed: status %d", resp.StatusCode)
-		}
+// ListRepositoriesAfter returns up to n repositories that follow last in
+// the catalog, or the first ones if last is empty. The returned cursor is
+// the last repository to continue after, empty once the end of the catalog
+// is reached.
+func (c *Client) ListRepositoriesAfter(ctx context.Context, last string, n int) ([]string, string, error) {
+	var all []string
+	link := fmt.Sprintf("%s/v2/_catalog?n=%d", c.baseURL, min(n, 1000))
+	if last != "" {
+		link += "&last=" + url.QueryEscape(last)
+	}
 
-		var catalog catalogResponse
-		err = json.NewDecoder(resp.Body).Decode(&catalog)
-		_ = resp.Body.Close()
+	for page := 0; link != "" && len(all) < n; page++ {
+		if page >= maxPages {
+			return nil, "", fmt.Errorf("catalog pagination exceeded %d pages", maxPages)
+		}
+		repos, next, err := c.catalogPage(ctx, link)
 		if err != nil {
-			return nil, fmt.Errorf("decoding catalog response: %w", err)
+			return nil, "", err
 		}
+		all = append(all, repos...)
+		link = next
+	}
 
-		all = append(all, catalog.Repositories...)
-		url = nextLink(resp, c.baseURL)
+	if len(all) > n {
+		all = all[:n]
+	} else if link == "" || len(all) == 0 {
+		return all, "", nil
 	}
+	return all, all[len(all)-1], nil
+}
 
-	return all, nil
+// catalogPage fetches one page of the catalog and returns its repositories
+// and the URL of the next page, if any.
+func (c *Client) catalogPage(ctx context.Context, url string) ([]string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("creating catalog request: %w", err)
+	}
+
+	resp, err := c.do(metrics.OpCatalog, req)
+	if err != nil {
+		return nil, "", fmt.Errorf("listing catalog: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("catalog request failed: status %d", resp.StatusCode)
+	}
+
+	var catalog catalogResponse
+	if err := json.NewDecoder(resp.Body).Decode(&catalog); err != nil {
+		return nil, "", fmt.Errorf("decoding catalog response: %w", err)
+	}
+	return catalog.Repositories, nextLink(resp, c.baseURL), nil
 }
 
 // ListTags returns all tags for a given repository.
@@ -112,11 +382,15 @@ func (c *Client) ListTags(ctx context.Context, repo string) ([]string, error) {
 			return nil, fmt.Errorf("creating tags request: %w", err)
 		}
 
-		resp, err := c.httpClient.Do(req)
+		resp, err := c.do(metrics.OpTags, req)
 		if err != nil {
 			return nil, fmt.Errorf("listing tags for %s: %w", repo, err)
 		}
 
+		if resp.StatusCode == http.StatusNotFound {
+			_ = resp.Body.Close()
+			return nil, fmt.Errorf("tags request for %s: %w", repo, ErrNotFound)
+		}
 		if resp.StatusCode != http.StatusOK {
 			_ = resp.Body.Close()
 			return nil, fmt.Errorf("tags request for %s failed: status %d", repo, resp.StatusCode)
@@ -139,86 +413,496 @@ func (c *Client) ListTags(ctx context.Context, repo string) ([]string, error) {
 // GetImageSize fetches the total size of an image by fetching its manifest
 // and summing the config size and all layer sizes.
 func (c *Client) GetImageSize(ctx context.Context, repo, tag string) (int64, error) {
+	info, err := c.GetImageManifestInfo(ctx, repo, tag)
+	if err != nil {
+		return 0, err
+	}
+	return info.SizeBytes, nil
+}
+
+// GetImageManifestInfo fetches both the digest and size of an image manifest.
+// This is more efficient than separate method calls since it uses a single HTTP request.
+// Manifests in formats the client does not understand (e.g. schema1) are
+// returned with Unsupported set and an estimated size instead of an error.
+func (c *Client) GetImageManifestInfo(ctx context.Context, repo, tag string) (*ManifestInfo, error) {
 	url := fmt.Sprintf("%s/v2/%s/manifests/%s", c.baseURL, repo, tag)
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return 0, fmt.Errorf("creating manifest request: %w", err)
+		return nil, fmt.Errorf("creating manifest request: %w", err)
 	}
 
-	// Accept both OCI and Docker manifest formats
-	req.Header.Set("Accept",
-		"application/vnd.oci.image.manifest.v1+json,"+
-			"application/vnd.docker.distribution.manifest.v2+json")
+	req.Header.Set("Accept", strings.Join(c.acceptTypes, ","))
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(metrics.OpManifestGet, req)
 	if err != nil {
-		return 0, fmt.Errorf("fetching manifest for %s:%s: %w", repo, tag, err)
+		return nil, fmt.Errorf("fetching manifest for %s:%s: %w", repo, tag, err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("manifest request failed for %s:%s: status %d", repo, tag, resp.StatusCode)
+		return nil, fmt.Errorf("manifest request failed for %s:%s: status %d", repo, tag, resp.StatusCode)
+	}
+
+	// Extract digest from header
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		digest = strings.Trim(resp.Header.Get("ETag"), `"`)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestBytes))
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest for %s:%s: %w", repo, tag, err)
 	}
 
 	var manifest ManifestV2
-	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
-		return 0, fmt.Errorf("decoding manifest for %s:%s: %w", repo, tag, err)
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("decoding manifest for %s:%s: %w", repo, tag, err)
+	}
+
+	info := &ManifestInfo{
+		Digest:       digest,
+		MediaType:    manifestMediaType(resp, &manifest),
+		ArtifactType: classifyArtifact(&manifest),
 	}
 
-	// Sum config size + all layer sizes
-	totalSize := manifest.Config.Size
+	if !isSupportedManifest(info.MediaType, manifest.SchemaVersion) {
+		info.Unsupported = true
+		label := info.MediaType
+		if label == "" {
+			label = fmt.Sprintf("schemaVersion=%d", manifest.SchemaVersion)
+		}
+		metrics.UnsupportedManifests.WithLabelValues(label).Inc()
+		info.SizeBytes = estimateSize(body)
+		return info, nil
+	}
+
+	info.SizeBytes = manifest.Config.Size
 	for _, layer := range manifest.Layers {
-		totalSize += layer.Size
+		info.SizeBytes += layer.Size
 	}
+	return info, nil
+}
 
-	return totalSize, nil
+// ManifestDigest resolves repo:ref to its manifest digest with a HEAD
+// request. found is false when the registry reports the manifest missing.
+func (c *Client) ManifestDigest(ctx context.Context, repo, ref string) (digest string, found bool, err error) {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", c.baseURL, repo, ref)
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return "", false, fmt.Errorf("creating HEAD request: %w", err)
+	}
+	req.Header.Set("Accept", c.digestAccept())
+
+	resp, err := c.do(metrics.OpManifestHead, req)
+	if err != nil {
+		return "", false, fmt.Errorf("HEAD manifest: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", false, &StatusError{Op: http.MethodHead, StatusCode: resp.StatusCode}
+	}
+
+	digest = resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		digest = strings.Trim(resp.Header.Get("ETag"), `"`)
+	}
+	return digest, true, nil
 }
 
-// GetImageManifestInfo fetches both the digest and size of an image manifest.
-// This is more efficient than separate method calls since it uses a single HTTP request.
-func (c *Client) GetImageManifestInfo(ctx context.Context, repo, tag string) (*ManifestInfo, error) {
-	url := fmt.Sprintf("%s/v2/%s/manifests/%s", c.baseURL, repo, tag)
+// digestAccept lists the configured types plus every manifest and index
+// type, so the registry resolves a tag to the manifest as pushed instead of
+// converting it or picking one platform of an index. The digest of such a
+// substitute names a different manifest than the tag's.
+func (c *Client) digestAccept() string {
+	accept := slices.Clone(c.acceptTypes)
+	for _, t := range []string{MediaTypeOCIManifest, MediaTypeDockerManifest, MediaTypeOCIIndex, MediaTypeDockerList} {
+		if !slices.Contains(accept, t) {
+			accept = append(accept, t)
+		}
+	}
+	return strings.Join(accept, ",")
+}
+
+// DeleteManifest deletes a manifest by digest. A manifest that is already
+// gone is not an error.
+func (c *Client) DeleteManifest(ctx context.Context, repo, digest string) error {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", c.baseURL, repo, digest)
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, url, nil)
+	if err != nil {
+		return fmt.Errorf("creating DELETE request: %w", err)
+	}
+
+	resp, err := c.do(metrics.OpManifestDelete, req)
+	if err != nil {
+		return fmt.Errorf("DELETE manifest: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusOK, http.StatusNotFound:
+		return nil
+	default:
+		return &StatusError{Op: http.MethodDelete, StatusCode: resp.StatusCode}
+	}
+}
+
+// ProbeDelete checks that deletions in repo would be accepted by deleting a
+// manifest that does not exist. A registry that allows deletion answers 404
+// or 202, one started without REGISTRY_STORAGE_DELETE_ENABLED=true 405.
+func (c *Client) ProbeDelete(ctx context.Context, repo string) error {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", c.baseURL, repo, probeDigest)
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, url, nil)
+	if err != nil {
+		return fmt.Errorf("creating DELETE request: %w", err)
+	}
+
+	resp, err := c.do(metrics.OpManifestDelete, req)
+	if err != nil {
+		return fmt.Errorf("DELETE manifest: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusOK, http.StatusNotFound:
+		return nil
+	case http.StatusMethodNotAllowed:
+		return fmt.Errorf("%w: deletion is disabled, start it with REGISTRY_STORAGE_DELETE_ENABLED=true",
+			ErrDeleteRefused)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: credentials lack delete permission (status %d)", ErrDeleteRefused, resp.StatusCode)
+	default:
+		return &StatusError{Op: http.MethodDelete, StatusCode: resp.StatusCode}
+	}
+}
+
+// RawManifest is a manifest exactly as the registry stores it, so it can be
+// uploaded again under the same digest.
+type RawManifest struct {
+	MediaType string `json:"media_type"`
+	Body      []byte `json:"body"`
+}
+
+// Digest returns the sha256 digest of the manifest as stored.
+func (m *RawManifest) Digest() string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(m.Body))
+}
 
+// GetManifest fetches the manifest repo:ref as is, accepting every manifest
+// and index type. It wraps ErrNotFound if the manifest does not exist.
+func (c *Client) GetManifest(ctx context.Context, repo, ref string) (*RawManifest, error) {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", c.baseURL, repo, ref)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("creating manifest request: %w", err)
 	}
+	req.Header.Set("Accept", c.digestAccept())
 
-	req.Header.Set("Accept",
-		"application/vnd.oci.image.manifest.v1+json,"+
-			"application/vnd.docker.distribution.manifest.v2+json")
+	resp, err := c.do(metrics.OpManifestGet, req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching manifest for %s:%s: %w", repo, ref, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
 
-	resp, err := c.httpClient.Do(req)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("manifest %s:%s: %w", repo, ref, ErrNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Op: http.MethodGet, StatusCode: resp.StatusCode}
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestBytes))
 	if err != nil {
-		return nil, fmt.Errorf("fetching manifest for %s:%s: %w", repo, tag, err)
+		return nil, fmt.Errorf("reading manifest for %s:%s: %w", repo, ref, err)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return &RawManifest{MediaType: mediaType, Body: body}, nil
+}
+
+// PutManifest uploads m as repo:tag and returns the digest the registry
+// stored it under.
+func (c *Client) PutManifest(ctx context.Context, repo, tag string, m *RawManifest) (string, error) {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", c.baseURL, repo, tag)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(m.Body))
+	if err != nil {
+		return "", fmt.Errorf("creating PUT request: %w", err)
+	}
+	req.Header.Set("Content-Type", m.MediaType)
+
+	resp, err := c.do(metrics.OpManifestPut, req)
+	if err != nil {
+		return "", fmt.Errorf("PUT manifest: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", &StatusError{Op: http.MethodPut, StatusCode: resp.StatusCode}
+	}
+	return responseDigest(resp), nil
+}
+
+// BlobExists reports whether repo still has the blob digest.
+func (c *Client) BlobExists(ctx context.Context, repo, digest string) (bool, error) {
+	url := fmt.Sprintf("%s/v2/%s/blobs/%s", c.baseURL, repo, digest)
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return false, fmt.Errorf("creating HEAD request: %w", err)
+	}
+
+	resp, err := c.do(metrics.OpBlobHead, req)
+	if err != nil {
+		return false, fmt.Errorf("HEAD blob: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("HEAD blob %s@%s returned %d", repo, digest, resp.StatusCode)
+	}
+}
+
+// GetBlob fetches the blob digest of repo, such as an image config. Blobs
+// larger than maxManifestBytes are refused, since it is not meant for layers.
+// It wraps ErrNotFound if the blob does not exist.
+func (c *Client) GetBlob(ctx context.Context, repo, digest string) ([]byte, error) {
+	url := fmt.Sprintf("%s/v2/%s/blobs/%s", c.baseURL, repo, digest)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating blob request: %w", err)
+	}
+
+	resp, err := c.do(metrics.OpBlobGet, req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching blob %s@%s: %w", repo, digest, err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("blob %s@%s: %w", repo, digest, ErrNotFound)
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("manifest request failed for %s:%s: status %d", repo, tag, resp.StatusCode)
+		return nil, &StatusError{Op: http.MethodGet, StatusCode: resp.StatusCode}
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading blob %s@%s: %w", repo, digest, err)
 	}
+	if len(body) > maxManifestBytes {
+		return nil, fmt.Errorf("blob %s@%s exceeds %d bytes", repo, digest, maxManifestBytes)
+	}
+	return body, nil
+}
 
-	// Extract digest from header
-	digest := resp.Header.Get("Docker-Content-Digest")
-	if digest == "" {
-		digest = strings.Trim(resp.Header.Get("ETag"), `"`)
+// PutBlob uploads body as the blob digest of repo in a single request after
+// opening an upload session.
+func (c *Client) PutBlob(ctx context.Context, repo, digest string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/v2/%s/blobs/uploads/", c.baseURL, repo), nil)
+	if err != nil {
+		return fmt.Errorf("creating upload request: %w", err)
+	}
+	resp, err := c.do(metrics.OpBlobUpload, req)
+	if err != nil {
+		return fmt.Errorf("starting blob upload: %w", err)
 	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return &StatusError{Op: http.MethodPost, StatusCode: resp.StatusCode}
+	}
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return fmt.Errorf("blob upload for %s returned no usable Location", repo)
+	}
+	q := location.Query()
+	q.Set("digest", digest)
+	location.RawQuery = q.Encode()
 
-	var manifest ManifestV2
-	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
-		return nil, fmt.Errorf("decoding manifest for %s:%s: %w", repo, tag, err)
+	req, err = http.NewRequestWithContext(ctx, http.MethodPut, location.String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating upload request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err = c.do(metrics.OpBlobUpload, req)
+	if err != nil {
+		return fmt.Errorf("uploading blob %s@%s: %w", repo, digest, err)
 	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return &StatusError{Op: http.MethodPut, StatusCode: resp.StatusCode}
+	}
+	return nil
+}
 
-	totalSize := manifest.Config.Size
-	for _, layer := range manifest.Layers {
-		totalSize += layer.Size
+// DeleteTag removes a single tag without touching the manifest it points at
+// or the repository's other tags. Registries that only support deletion by
+// digest reject the request; a tag that is already gone is not an error.
+func (c *Client) DeleteTag(ctx context.Context, repo, tag string) error {
+	return c.DeleteManifest(ctx, repo, tag)
+}
+
+// estimateSize sums the sizes of all descriptors referenced by a manifest of
+// unknown type. If none carry a size, the manifest's own length is used so
+// the artifact is not tracked as zero bytes.
+func estimateSize(body []byte) int64 {
+	var m genericManifest
+	if err := json.Unmarshal(body, &m); err == nil {
+		var total int64
+		if m.Config != nil {
+			total += m.Config.Size
+		}
+		for _, d := range m.Layers {
+			total += d.Size
+		}
+		for _, d := range m.Blobs {
+			total += d.Size
+		}
+		if total > 0 {
+			return total
+		}
+	}
+	return int64(len(body))
+}
+
+// classifyArtifact derives an artifact type from the manifest's artifactType
+// and config media type.
+func classifyArtifact(m *ManifestV2) string {
+	kind := m.ArtifactType
+	if kind == "" {
+		kind = m.Config.MediaType
+	}
+	switch {
+	case kind == mediaTypeHelmConfig:
+		return ArtifactHelm
+	case strings.Contains(kind, "wasm"):
+		return ArtifactWASM
+	case kind == "", kind == mediaTypeOCIImageConfig, kind == mediaTypeDockerImageConfig:
+		return ArtifactImage
+	default:
+		return ArtifactOther
+	}
+}
+
+// manifestMediaType returns the manifest's media type, preferring the
+// mediaType field in the body over a vendor Content-Type header.
+func manifestMediaType(resp *http.Response, manifest *ManifestV2) string {
+	if manifest.MediaType != "" {
+		return manifest.MediaType
+	}
+	ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if strings.HasPrefix(ct, "application/vnd.") {
+		return ct
+	}
+	return ""
+}
+
+// isSupportedManifest reports whether sizes can be computed for a manifest.
+// Manifests without a media type are assumed to be schema2/OCI.
+func isSupportedManifest(mediaType string, schemaVersion int) bool {
+	if schemaVersion == 1 {
+		return false
+	}
+	switch mediaType {
+	case "", MediaTypeOCIManifest, MediaTypeDockerManifest:
+		return true
+	default:
+		return false
+	}
+}
+
+// do sends req and records its latency under op. With credentials
+// configured it authorizes the request and answers one auth challenge.
+func (c *Client) do(op string, req *http.Request) (*http.Response, error) {
+	if c.auth == nil {
+		return c.send(op, req)
+	}
+	c.auth.authorize(c, req)
+	resp, err := c.send(op, req)
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || !replayable {
+		return resp, err
 	}
 
-	return &ManifestInfo{
-		Digest:    digest,
-		SizeBytes: totalSize,
-	}, nil
+	retry, err := c.auth.handleChallenge(c, req, resp)
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("authenticating: %w", err)
+	}
+	if !retry {
+		return resp, nil
+	}
+	_ = resp.Body.Close()
+
+	req = req.Clone(req.Context())
+	if req.GetBody != nil {
+		if req.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	req.Header.Del("Authorization")
+	c.auth.authorize(c, req)
+	return c.send(op, req)
+}
+
+// send performs a request, retrying transient failures as configured with
+// WithRetries, and records the latency of each attempt under op.
+func (c *Client) send(op string, req *http.Request) (*http.Response, error) {
+	backoff := c.retryBackoff
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		resp, err := c.httpClient.Do(req)
+		metrics.ObserveRegistryRequest(op, start, resp, err)
+		if attempt >= c.retries || !retryable(req, resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxManifestBytes))
+			_ = resp.Body.Close()
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+		metrics.RegistryRetries.WithLabelValues(op).Inc()
+
+		req = req.Clone(req.Context())
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// retryable reports whether a request that got resp or err is worth sending
+// again: its body can be replayed, its context is still live and it failed
+// in a way that may pass.
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	if req.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
 }
 
 // nextLink parses the Link header for pagination.
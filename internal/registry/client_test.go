@@ -3,9 +3,21 @@ package registry
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
 )
 
 const (
@@ -91,6 +103,50 @@ func TestListRepositories_Pagination(t *testing.T) {
 	}
 }
 
+func TestListRepositoriesAfter(t *testing.T) {
+	catalog := []string{"a", "b", "c", "d", "e"}
+	// The server pages like distribution, by n and the last repository.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+		last := r.URL.Query().Get("last")
+		i, _ := slices.BinarySearch(catalog, last)
+		if i < len(catalog) && catalog[i] == last {
+			i++
+		}
+		page := catalog[i:min(i+n, len(catalog))]
+		if i+n < len(catalog) {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/_catalog?n=%d&last=%s>; rel="next"`, n, page[len(page)-1]))
+		}
+		_ = json.NewEncoder(w).Encode(catalogResponse{Repositories: page})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	tests := []struct {
+		last     string
+		n        int
+		want     []string
+		wantNext string
+	}{
+		{"", 2, []string{"a", "b"}, "b"},
+		{"b", 2, []string{"c", "d"}, "d"},
+		{"d", 2, []string{"e"}, ""},
+		{"c", 2, []string{"d", "e"}, ""},
+		{"", 10, catalog, ""},
+		{"e", 2, nil, ""},
+	}
+	for _, tt := range tests {
+		repos, next, err := c.ListRepositoriesAfter(context.Background(), tt.last, tt.n)
+		if err != nil {
+			t.Fatalf("ListRepositoriesAfter(%q, %d): %v", tt.last, tt.n, err)
+		}
+		if !slices.Equal(repos, tt.want) || next != tt.wantNext {
+			t.Errorf("ListRepositoriesAfter(%q, %d) = %v, %q; want %v, %q",
+				tt.last, tt.n, repos, next, tt.want, tt.wantNext)
+		}
+	}
+}
+
 func TestListRepositories_HTTPErrors(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -345,3 +401,379 @@ func TestGetImageManifestInfo_InvalidJSON(t *testing.T) {
 		t.Fatal("expected error for invalid JSON, got nil")
 	}
 }
+
+func TestGetImageManifestInfo_AcceptTypes(t *testing.T) {
+	var gotAccept string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAccept = r.Header.Get("Accept")
+		_ = json.NewEncoder(w).Encode(ManifestV2{SchemaVersion: 2})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithAcceptTypes([]string{MediaTypeDockerManifest, MediaTypeDockerSchema1JWS}))
+	if _, err := c.GetImageManifestInfo(context.Background(), "myapp", "1h"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := MediaTypeDockerManifest + "," + MediaTypeDockerSchema1JWS
+	if gotAccept != want {
+		t.Errorf("expected Accept %q, got %q", want, gotAccept)
+	}
+}
+
+func TestManifestDigest_AcceptsAllManifestTypes(t *testing.T) {
+	var gotAccept string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAccept = r.Header.Get("Accept")
+		w.Header().Set("Docker-Content-Digest", "sha256:abc")
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithAcceptTypes([]string{MediaTypeDockerManifest}))
+	if _, _, err := c.ManifestDigest(context.Background(), "myapp", "1h"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := strings.Join([]string{
+		MediaTypeDockerManifest, MediaTypeOCIManifest, MediaTypeOCIIndex, MediaTypeDockerList,
+	}, ",")
+	if gotAccept != want {
+		t.Errorf("expected Accept %q, got %q", want, gotAccept)
+	}
+}
+
+func TestManifestReferences(t *testing.T) {
+	var gotAccept string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/myapp/manifests/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		gotAccept = r.Header.Get("Accept")
+		w.Header().Set("Docker-Content-Digest", "sha256:index")
+		_, _ = w.Write([]byte(`{"mediaType": "` + MediaTypeOCIIndex + `",
+			"manifests": [{"digest": "sha256:amd64", "size": 500}, {"digest": "sha256:arm64", "size": 501}]}`))
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	refs, err := c.ManifestReferences(context.Background(), "myapp", "1h")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if refs.Digest != "sha256:index" || len(refs.Blobs) != 0 || len(refs.Manifests) != 2 ||
+		refs.Manifests[1].Digest != "sha256:arm64" {
+		t.Errorf("references = %+v", refs)
+	}
+	if !strings.Contains(gotAccept, MediaTypeOCIIndex) || !strings.Contains(gotAccept, MediaTypeDockerList) {
+		t.Errorf("Accept %q should include index types", gotAccept)
+	}
+	if _, err := c.ManifestReferences(context.Background(), "myapp", "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestGetImageManifestInfo_UnsupportedFormats(t *testing.T) {
+	schema1 := `{"schemaVersion":1,"fsLayers":[{"blobSum":"sha256:aaa"}]}`
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantSize    int64
+	}{
+		{
+			name:        "schema1 uses manifest size",
+			contentType: MediaTypeDockerSchema1JWS,
+			body:        schema1,
+			wantSize:    int64(len(schema1)),
+		},
+		{
+			name:     "unknown media type sums layers",
+			body:     `{"schemaVersion":2,"mediaType":"application/vnd.example.custom+json","layers":[{"size":10}]}`,
+			wantSize: 10,
+		},
+		{
+			name: "artifact manifest sums blobs",
+			body: `{"mediaType":"application/vnd.oci.artifact.manifest.v1+json",` +
+				`"blobs":[{"size":100},{"size":23}]}`,
+			wantSize: 123,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Docker-Content-Digest", "sha256:legacy")
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			c := New(srv.URL)
+			info, err := c.GetImageManifestInfo(context.Background(), "myapp", "old")
+			if err != nil {
+				t.Fatalf("expected graceful handling, got error: %v", err)
+			}
+			if !info.Unsupported {
+				t.Error("expected manifest to be marked unsupported")
+			}
+			if info.SizeBytes != tt.wantSize {
+				t.Errorf("expected size %d, got %d", tt.wantSize, info.SizeBytes)
+			}
+			if info.Digest != "sha256:legacy" {
+				t.Errorf("expected digest to be preserved, got %q", info.Digest)
+			}
+		})
+	}
+}
+
+func TestClassifyArtifact(t *testing.T) {
+	tests := []struct {
+		name         string
+		artifactType string
+		configType   string
+		want         string
+	}{
+		{name: "docker image", configType: mediaTypeDockerImageConfig, want: ArtifactImage},
+		{name: "oci image", configType: mediaTypeOCIImageConfig, want: ArtifactImage},
+		{name: "no config media type", want: ArtifactImage},
+		{name: "helm chart", configType: mediaTypeHelmConfig, want: ArtifactHelm},
+		{name: "wasm config", configType: "application/vnd.wasm.config.v0+json", want: ArtifactWASM},
+		{
+			name:         "artifactType wins over config",
+			artifactType: "application/vnd.example.sbom+json",
+			configType:   "application/vnd.oci.empty.v1+json",
+			want:         ArtifactOther,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := ManifestV2{ArtifactType: tt.artifactType, Config: ManifestConfig{MediaType: tt.configType}}
+			if got := classifyArtifact(&m); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRequestDurationObserved(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	for _, op := range []string{metrics.OpTags, metrics.OpManifestGet} {
+		before := requestCount(t, op, "404")
+		switch op {
+		case metrics.OpTags:
+			_, _ = c.ListTags(context.Background(), "missing")
+		case metrics.OpManifestGet:
+			_, _ = c.GetImageManifestInfo(context.Background(), "missing", "1h")
+		}
+		if got := requestCount(t, op, "404"); got != before+1 {
+			t.Fatalf("%s: expected %d observations, got %d", op, before+1, got)
+		}
+	}
+}
+
+// requestCount returns the number of observations recorded for op and code.
+func requestCount(t *testing.T, op, code string) uint64 {
+	t.Helper()
+	var m dto.Metric
+	obs := metrics.RegistryRequestDuration.WithLabelValues(op, code)
+	if err := obs.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("reading histogram: %v", err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestRetries(t *testing.T) {
+	var calls, failures int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+	c := New(srv.URL, WithRetries(2, time.Millisecond))
+
+	failures = 2
+	if err := c.DeleteManifest(context.Background(), "app", "sha256:abc"); err != nil {
+		t.Fatalf("DeleteManifest: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("calls = %d, want 3", calls)
+	}
+
+	calls, failures = 0, 5
+	var statusErr *StatusError
+	if err := c.DeleteManifest(context.Background(), "app", "sha256:abc"); !errors.As(err, &statusErr) {
+		t.Fatalf("DeleteManifest = %v, want the last 503 once retries run out", err)
+	}
+	if calls != 3 {
+		t.Errorf("calls = %d, want 3 attempts", calls)
+	}
+}
+
+func TestRetries_NotForPermanentFailures(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithRetries(3, time.Millisecond))
+	_ = c.DeleteManifest(context.Background(), "app", "sha256:abc")
+	if calls != 1 {
+		t.Errorf("calls = %d, want 403 not retried", calls)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithTimeout(5*time.Millisecond))
+	if _, err := c.ListTags(context.Background(), "app"); err == nil {
+		t.Fatal("expected a timeout error")
+	}
+}
+
+func TestProbeDelete(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		refused bool
+		wantErr bool
+	}{
+		{name: "enabled", status: http.StatusNotFound},
+		{name: "accepted", status: http.StatusAccepted},
+		{name: "disabled", status: http.StatusMethodNotAllowed, refused: true, wantErr: true},
+		{name: "forbidden", status: http.StatusForbidden, refused: true, wantErr: true},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodDelete || r.URL.Path != "/v2/probe/manifests/"+probeDigest {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			err := New(srv.URL).ProbeDelete(context.Background(), "probe")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if errors.Is(err, ErrDeleteRefused) != tt.refused {
+				t.Errorf("expected refused %v, got %v", tt.refused, err)
+			}
+		})
+	}
+}
+
+func TestGetManifest_PutManifest(t *testing.T) {
+	stored := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			body, ok := stored[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", MediaTypeOCIIndex)
+			_, _ = w.Write(body)
+		case http.MethodPut:
+			if ct := r.Header.Get("Content-Type"); ct != MediaTypeOCIIndex {
+				t.Errorf("expected Content-Type %s, got %s", MediaTypeOCIIndex, ct)
+			}
+			stored[r.URL.Path], _ = io.ReadAll(r.Body)
+			w.Header().Set("Docker-Content-Digest", "sha256:idx")
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	if _, err := c.GetManifest(context.Background(), "app", "1h"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	body := []byte(`{"manifests":[{"digest":"sha256:amd64"}]}`)
+	digest, err := c.PutManifest(context.Background(), "app", "1h", &RawManifest{MediaType: MediaTypeOCIIndex, Body: body})
+	if err != nil || digest != "sha256:idx" {
+		t.Fatalf("PutManifest = %q, %v", digest, err)
+	}
+	m, err := c.GetManifest(context.Background(), "app", "1h")
+	if err != nil || m.MediaType != MediaTypeOCIIndex || string(m.Body) != string(body) {
+		t.Fatalf("GetManifest = %+v, %v", m, err)
+	}
+	refs, err := ParseReferences(m.Body)
+	if err != nil || len(refs.Manifests) != 1 || refs.Manifests[0].Digest != "sha256:amd64" {
+		t.Errorf("ParseReferences = %+v, %v", refs, err)
+	}
+}
+
+func TestBlobExists(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead || r.URL.Path != "/v2/app/blobs/sha256:present" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	for digest, want := range map[string]bool{"sha256:present": true, "sha256:collected": false} {
+		if got, err := c.BlobExists(context.Background(), "app", digest); err != nil || got != want {
+			t.Errorf("BlobExists(%s) = %v, %v; want %v", digest, got, err, want)
+		}
+	}
+}
+
+func TestGetBlob_PutBlob(t *testing.T) {
+	blobs := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v2/app/blobs/uploads/":
+			w.Header().Set("Location", "/v2/app/blobs/uploads/u1?state=x")
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodPut && r.URL.Path == "/v2/app/blobs/uploads/u1":
+			if r.URL.Query().Get("state") != "x" {
+				t.Errorf("expected upload state to be kept, got %q", r.URL.RawQuery)
+			}
+			blobs[r.URL.Query().Get("digest")], _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodGet:
+			body, ok := blobs[strings.TrimPrefix(r.URL.Path, "/v2/app/blobs/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(body)
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL)
+		}
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	if _, err := c.GetBlob(context.Background(), "app", "sha256:cfg"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := c.PutBlob(context.Background(), "app", "sha256:cfg", []byte("config")); err != nil {
+		t.Fatalf("PutBlob: %v", err)
+	}
+	body, err := c.GetBlob(context.Background(), "app", "sha256:cfg")
+	if err != nil || string(body) != "config" {
+		t.Errorf("GetBlob = %q, %v", body, err)
+	}
+}
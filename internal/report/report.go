@@ -0,0 +1,338 @@
+// Package report compiles periodic storage and retention summaries.
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+	"github.com/tamcore/ephemeron/internal/reaper"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// topN is the number of entries kept in each ranked section of a report.
+const topN = 10
+
+// overwritesMetric is the counter family read for overwrite hotspots.
+const overwritesMetric = "ephemeron_immutability_tag_overwrites_total"
+
+// Report summarizes a reporting period.
+type Report struct {
+	PeriodStart       time.Time    `json:"period_start"`
+	PeriodEnd         time.Time    `json:"period_end"`
+	TrackedImages     int          `json:"tracked_images"`
+	TrackedBytes      int64        `json:"tracked_bytes"`
+	TopRepositories   []RepoUsage  `json:"top_repositories"`
+	ReapedImages      int64        `json:"reaped_images"`
+	ReclaimedBytes    int64        `json:"reclaimed_bytes"`
+	OverwriteHotspots []RepoCount  `json:"overwrite_hotspots"`
+	BusiestCycles     []CycleStats `json:"busiest_cycles"`
+	// EstimatedMonthlyCost and SavedDollars price the tracked and reclaimed
+	// storage per month, when a storage price is configured.
+	EstimatedMonthlyCost float64 `json:"estimated_monthly_cost_dollars,omitempty"`
+	SavedDollars         float64 `json:"saved_dollars,omitempty"`
+}
+
+// RepoUsage is the tracked storage of a single repository.
+type RepoUsage struct {
+	Repository string `json:"repository"`
+	Images     int    `json:"images"`
+	Bytes      int64  `json:"bytes"`
+	// CostDollars is the estimated monthly cost of Bytes, when a storage
+	// price is configured.
+	CostDollars float64 `json:"estimated_monthly_cost_dollars,omitempty"`
+}
+
+// RepoCount is an event count for a single repository.
+type RepoCount struct {
+	Repository string `json:"repository"`
+	Count      int64  `json:"count"`
+}
+
+// CycleStats describes one reap cycle.
+type CycleStats struct {
+	Start           time.Time `json:"start"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Reaped          int       `json:"reaped"`
+	Failed          int       `json:"failed"`
+}
+
+// CycleSource provides recently completed reap cycles.
+type CycleSource interface {
+	CyclesSince(t time.Time) []reaper.Cycle
+}
+
+// baseline holds the cumulative counters at the start of a period, so a
+// report can show the change over the period.
+type baseline struct {
+	at         time.Time
+	reaped     int64
+	reclaimed  int64
+	overwrites map[string]float64
+}
+
+// Scheduler compiles a report every period and keeps the latest one.
+type Scheduler struct {
+	store    redisclient.Store
+	cycles   CycleSource
+	gatherer prometheus.Gatherer
+	period   time.Duration
+	logger   *slog.Logger
+	// pricePerGiB is the storage price in dollars per GiB-month; zero
+	// leaves costs out of reports.
+	pricePerGiB float64
+
+	mu     sync.Mutex
+	base   baseline
+	latest *Report
+}
+
+// Option configures a Scheduler.
+type Option func(*Scheduler)
+
+// WithStoragePrice adds cost estimates at pricePerGiB dollars per
+// GiB-month to reports.
+func WithStoragePrice(pricePerGiB float64) Option {
+	return func(s *Scheduler) { s.pricePerGiB = pricePerGiB }
+}
+
+// NewScheduler creates a Scheduler. gatherer is read for overwrite counts,
+// normally prometheus.DefaultGatherer.
+func NewScheduler(
+	store redisclient.Store,
+	cycles CycleSource,
+	gatherer prometheus.Gatherer,
+	period time.Duration,
+	logger *slog.Logger,
+	opts ...Option,
+) *Scheduler {
+	s := &Scheduler{
+		store:    store,
+		cycles:   cycles,
+		gatherer: gatherer,
+		period:   period,
+		logger:   logger,
+		base:     baseline{at: time.Now()},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Run records the starting baseline and compiles a report every period
+// until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	base, err := s.snapshot(ctx, time.Now())
+	if err != nil {
+		s.logger.Warn("failed to record report baseline", "error", err)
+	}
+	s.mu.Lock()
+	s.base = base
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(s.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Compile(ctx, true); err != nil {
+				s.logger.Error("failed to compile report", "error", err)
+			}
+		}
+	}
+}
+
+// Latest returns the most recently compiled report, or nil if none has
+// been compiled yet.
+func (s *Scheduler) Latest() *Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latest
+}
+
+// Compile builds a report for the period since the current baseline. When
+// rotate is true the report is stored as the latest one and a new period
+// begins.
+func (s *Scheduler) Compile(ctx context.Context, rotate bool) (*Report, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	base := s.base
+	s.mu.Unlock()
+
+	current, err := s.snapshot(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	rep := &Report{
+		PeriodStart:    base.at,
+		PeriodEnd:      now,
+		ReapedImages:   current.reaped - base.reaped,
+		ReclaimedBytes: current.reclaimed - base.reclaimed,
+	}
+	if err := s.addUsage(ctx, rep); err != nil {
+		return nil, err
+	}
+	if s.pricePerGiB > 0 {
+		rep.EstimatedMonthlyCost = metrics.MonthlyCost(rep.TrackedBytes, s.pricePerGiB)
+		rep.SavedDollars = metrics.MonthlyCost(rep.ReclaimedBytes, s.pricePerGiB)
+		for i := range rep.TopRepositories {
+			rep.TopRepositories[i].CostDollars = metrics.MonthlyCost(rep.TopRepositories[i].Bytes, s.pricePerGiB)
+		}
+	}
+	rep.OverwriteHotspots = hotspots(base.overwrites, current.overwrites)
+	rep.BusiestCycles = busiest(s.cycles.CyclesSince(base.at))
+
+	if rotate {
+		s.mu.Lock()
+		s.base = current
+		s.latest = rep
+		s.mu.Unlock()
+		s.logger.Info("compiled report",
+			"period_start", rep.PeriodStart.Format(time.RFC3339),
+			"tracked_bytes", rep.TrackedBytes,
+			"reaped_images", rep.ReapedImages,
+			"reclaimed_bytes", rep.ReclaimedBytes,
+		)
+	}
+	return rep, nil
+}
+
+// snapshot reads the cumulative counters at t.
+func (s *Scheduler) snapshot(ctx context.Context, t time.Time) (baseline, error) {
+	b := baseline{at: t, overwrites: s.overwriteCounts()}
+	reaped, reclaimed, err := s.store.ReapTotals(ctx)
+	if err != nil {
+		return b, fmt.Errorf("reading reap totals: %w", err)
+	}
+	b.reaped, b.reclaimed = reaped, reclaimed
+	return b, nil
+}
+
+// addUsage fills in the current tracked storage, overall and per repository.
+func (s *Scheduler) addUsage(ctx context.Context, rep *Report) error {
+	images, err := s.store.ListImages(ctx)
+	if err != nil {
+		return fmt.Errorf("listing images: %w", err)
+	}
+	byRepo := make(map[string]*RepoUsage)
+	for _, image := range images {
+		size, err := s.store.GetImageSize(ctx, image)
+		if err != nil {
+			return fmt.Errorf("getting size for %s: %w", image, err)
+		}
+		repo, _, _ := strings.Cut(image, ":")
+		u, ok := byRepo[repo]
+		if !ok {
+			u = &RepoUsage{Repository: repo}
+			byRepo[repo] = u
+		}
+		u.Images++
+		u.Bytes += size
+		rep.TrackedBytes += size
+	}
+	rep.TrackedImages = len(images)
+
+	rep.TopRepositories = make([]RepoUsage, 0, len(byRepo))
+	for _, u := range byRepo {
+		rep.TopRepositories = append(rep.TopRepositories, *u)
+	}
+	sort.Slice(rep.TopRepositories, func(i, j int) bool {
+		a, b := rep.TopRepositories[i], rep.TopRepositories[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Repository < b.Repository
+	})
+	rep.TopRepositories = rep.TopRepositories[:min(topN, len(rep.TopRepositories))]
+	return nil
+}
+
+// overwriteCounts returns this process's tag overwrite counters by repository.
+func (s *Scheduler) overwriteCounts() map[string]float64 {
+	counts := make(map[string]float64)
+	families, err := s.gatherer.Gather()
+	if err != nil {
+		s.logger.Warn("failed to gather overwrite counters", "error", err)
+	}
+	for _, mf := range families {
+		if mf.GetName() != overwritesMetric {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "repository" {
+					counts[l.GetValue()] += m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return counts
+}
+
+// hotspots ranks repositories by overwrites between two counter snapshots.
+func hotspots(before, after map[string]float64) []RepoCount {
+	out := make([]RepoCount, 0, len(after))
+	for repo, n := range after {
+		if d := int64(n - before[repo]); d > 0 {
+			out = append(out, RepoCount{Repository: repo, Count: d})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Repository < out[j].Repository
+	})
+	return out[:min(topN, len(out))]
+}
+
+// busiest ranks reap cycles by the number of images reaped.
+func busiest(cycles []reaper.Cycle) []CycleStats {
+	out := make([]CycleStats, 0, len(cycles))
+	for _, c := range cycles {
+		if c.Attempted == 0 {
+			continue
+		}
+		out = append(out, CycleStats{
+			Start:           c.Start,
+			DurationSeconds: c.Duration.Seconds(),
+			Reaped:          c.Reaped,
+			Failed:          c.Failed,
+		})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Reaped > out[j].Reaped })
+	return out[:min(topN, len(out))]
+}
+
+// Handler serves the latest report as JSON. Before the first period ends it
+// serves a report for the period so far.
+func (s *Scheduler) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rep := s.Latest()
+		if rep == nil {
+			var err error
+			if rep, err = s.Compile(r.Context(), false); err != nil {
+				s.logger.Error("failed to compile report", "error", err)
+				http.Error(w, "report unavailable", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(rep)
+	})
+}
@@ -0,0 +1,124 @@
+package report
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	"github.com/tamcore/ephemeron/internal/reaper"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+type fakeCycles []reaper.Cycle
+
+func (f fakeCycles) CyclesSince(time.Time) []reaper.Cycle { return f }
+
+func newOverwrites(reg *prometheus.Registry) *prometheus.CounterVec {
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: overwritesMetric,
+		Help: "test",
+	}, []string{"repository"})
+	reg.MustRegister(c)
+	return c
+}
+
+func TestCompile(t *testing.T) {
+	ctx := t.Context()
+	store := memstore.New()
+	exp := time.Now().Add(time.Hour)
+	_ = store.TrackImage(ctx, "big:1h", exp, 300, "", redisclient.ImageMeta{})
+	_ = store.TrackImage(ctx, "big:2h", exp, 200, "", redisclient.ImageMeta{})
+	_ = store.TrackImage(ctx, "small:1h", exp, 100, "", redisclient.ImageMeta{})
+	_ = store.RecordReap(ctx, 1000) // before the period starts
+
+	reg := prometheus.NewRegistry()
+	overwrites := newOverwrites(reg)
+	overwrites.WithLabelValues("big").Add(5)
+
+	cycles := fakeCycles{
+		{Start: time.Now(), Result: reaper.Result{Attempted: 1, Reaped: 1}},
+		{Start: time.Now(), Result: reaper.Result{Total: 3}},
+		{Start: time.Now(), Result: reaper.Result{Attempted: 4, Reaped: 3, Failed: 1}},
+	}
+	s := NewScheduler(store, cycles, reg, time.Hour, slog.Default())
+	base, err := s.snapshot(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.base = base
+
+	_ = store.RecordReap(ctx, 50)
+	overwrites.WithLabelValues("big").Inc()
+	overwrites.WithLabelValues("small").Add(2)
+
+	rep, err := s.Compile(ctx, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rep.TrackedImages != 3 || rep.TrackedBytes != 600 {
+		t.Errorf("tracked = %d images / %d bytes, want 3 / 600", rep.TrackedImages, rep.TrackedBytes)
+	}
+	if len(rep.TopRepositories) != 2 || rep.TopRepositories[0] != (RepoUsage{Repository: "big", Images: 2, Bytes: 500}) {
+		t.Errorf("unexpected top repositories: %+v", rep.TopRepositories)
+	}
+	if rep.ReapedImages != 1 || rep.ReclaimedBytes != 50 {
+		t.Errorf("reaped = %d / %d bytes, want 1 / 50", rep.ReapedImages, rep.ReclaimedBytes)
+	}
+	want := []RepoCount{{"small", 2}, {"big", 1}}
+	if len(rep.OverwriteHotspots) != 2 || rep.OverwriteHotspots[0] != want[0] || rep.OverwriteHotspots[1] != want[1] {
+		t.Errorf("hotspots = %+v, want %+v", rep.OverwriteHotspots, want)
+	}
+	if len(rep.BusiestCycles) != 2 || rep.BusiestCycles[0].Reaped != 3 {
+		t.Errorf("unexpected busiest cycles: %+v", rep.BusiestCycles)
+	}
+	if s.Latest() != rep {
+		t.Error("expected rotated report to become the latest")
+	}
+}
+
+func TestCompile_StoragePrice(t *testing.T) {
+	store := memstore.New()
+	_ = store.TrackImage(t.Context(), "app:1h", time.Now().Add(time.Hour), 2<<30, "", redisclient.ImageMeta{})
+	s := NewScheduler(store, fakeCycles{}, prometheus.NewRegistry(), time.Hour, slog.Default(), WithStoragePrice(0.25))
+	_ = store.RecordReap(t.Context(), 4<<30)
+
+	rep, err := s.Compile(t.Context(), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rep.EstimatedMonthlyCost != 0.5 || rep.SavedDollars != 1 {
+		t.Errorf("cost = $%v, saved = $%v, want $0.5, $1", rep.EstimatedMonthlyCost, rep.SavedDollars)
+	}
+	if rep.TopRepositories[0].CostDollars != 0.5 {
+		t.Errorf("repository cost = $%v, want $0.5", rep.TopRepositories[0].CostDollars)
+	}
+}
+
+func TestHandler_CompilesPeriodSoFar(t *testing.T) {
+	store := memstore.New()
+	_ = store.TrackImage(t.Context(), "app:1h", time.Now().Add(time.Hour), 42, "", redisclient.ImageMeta{})
+	s := NewScheduler(store, fakeCycles{}, prometheus.NewRegistry(), time.Hour, slog.Default())
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/api/reports/weekly", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var rep Report
+	if err := json.NewDecoder(rr.Body).Decode(&rep); err != nil {
+		t.Fatalf("decoding report: %v", err)
+	}
+	if rep.TrackedBytes != 42 {
+		t.Errorf("expected 42 tracked bytes, got %d", rep.TrackedBytes)
+	}
+	if s.Latest() != nil {
+		t.Error("an on-demand report must not start a new period")
+	}
+}
@@ -0,0 +1,359 @@
+// Package rules holds the retention rules operators manage at runtime —
+// immutable tag patterns, protected image patterns and per-repository TTL
+// policies — merged with the static ones from config, and serves the API
+// to change them without a redeploy.
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/hooks"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// kinds lists the rule kinds in the order they are served.
+var kinds = []string{redisclient.RuleImmutableTag, redisclient.RuleProtected, redisclient.RuleRepoPolicy}
+
+// Sources of a rule as reported by the API.
+const (
+	sourceConfig = "config"
+	sourceAPI    = "api"
+)
+
+// errInvalidRule marks rule requests rejected before touching the store.
+var errInvalidRule = errors.New("invalid rule")
+
+// Set is the merged view of static and runtime-managed rules. Runtime rules
+// are cached and refreshed from the store, so replicas pick up changes made
+// through another one within the refresh interval.
+type Set struct {
+	store  redisclient.Store
+	static map[string][]string
+	logger *slog.Logger
+
+	mu      sync.RWMutex
+	managed map[string][]redisclient.Rule
+}
+
+// New returns a Set with the immutable tag and protected image patterns
+// from config. Call Refresh to load the runtime rules.
+func New(store redisclient.Store, immutableTags, protected []string, logger *slog.Logger) *Set {
+	return &Set{
+		store: store,
+		static: map[string][]string{
+			redisclient.RuleImmutableTag: immutableTags,
+			redisclient.RuleProtected:    protected,
+		},
+		logger:  logger,
+		managed: make(map[string][]redisclient.Rule),
+	}
+}
+
+// Refresh reloads the runtime rules from the store.
+func (s *Set) Refresh(ctx context.Context) error {
+	managed := make(map[string][]redisclient.Rule, len(kinds))
+	for _, kind := range kinds {
+		list, err := s.store.ListRules(ctx, kind)
+		if err != nil {
+			return fmt.Errorf("listing %s rules: %w", kind, err)
+		}
+		managed[kind] = list
+	}
+	s.mu.Lock()
+	s.managed = managed
+	s.mu.Unlock()
+	return nil
+}
+
+// Start loads the runtime rules and then refreshes them every interval in
+// the background until ctx is cancelled. A failed first load leaves the
+// static rules in effect until a refresh succeeds.
+func (s *Set) Start(ctx context.Context, interval time.Duration) {
+	if err := s.Refresh(ctx); err != nil {
+		s.logger.Warn("failed to load rules, retrying in the background", "error", err)
+	}
+	go s.RefreshLoop(ctx, interval)
+}
+
+// RefreshLoop calls Refresh every interval until ctx is cancelled. Failed
+// refreshes keep the previous rules.
+func (s *Set) RefreshLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Refresh(ctx); err != nil {
+				s.logger.Warn("failed to refresh rules, keeping previous ones", "error", err)
+			}
+		}
+	}
+}
+
+// patterns returns the static and runtime patterns of kind.
+func (s *Set) patterns(kind string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := append([]string(nil), s.static[kind]...)
+	for _, r := range s.managed[kind] {
+		out = append(out, r.Pattern)
+	}
+	return out
+}
+
+// ImmutableTag reports whether tag matches an immutable tag pattern.
+func (s *Set) ImmutableTag(tag string) bool {
+	for _, pattern := range s.patterns(redisclient.RuleImmutableTag) {
+		if ok, _ := filepath.Match(pattern, tag); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Protected reports whether the "repo:tag" image matches a protected
+// pattern.
+func (s *Set) Protected(image string) bool {
+	for _, pattern := range s.patterns(redisclient.RuleProtected) {
+		if ok, _ := path.Match(pattern, image); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RepoPolicy returns the TTLs of the most specific repository policy
+// matching repo, the one with the longest pattern. Zero TTLs keep the
+// global values.
+func (s *Set) RepoPolicy(repo string) (defaultTTL, maxTTL time.Duration, ok bool) {
+	best, ok := s.repoPolicy(repo)
+	return best.DefaultTTL, best.MaxTTL, ok
+}
+
+// NonTTLTags returns the action for pushes of tags that are not TTLs set by
+// the most specific repository policy matching repo, or "" if it sets none.
+func (s *Set) NonTTLTags(repo string) string {
+	best, _ := s.repoPolicy(repo)
+	return best.NonTTLTags
+}
+
+// repoPolicy returns the most specific repository policy matching repo.
+func (s *Set) repoPolicy(repo string) (best redisclient.Rule, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, r := range s.managed[redisclient.RuleRepoPolicy] {
+		if match, _ := path.Match(r.Pattern, repo); !match {
+			continue
+		}
+		if !ok || len(r.Pattern) > len(best.Pattern) ||
+			len(r.Pattern) == len(best.Pattern) && r.Pattern < best.Pattern {
+			best, ok = r, true
+		}
+	}
+	return best, ok
+}
+
+// ruleView is a rule as served by the API.
+type ruleView struct {
+	Pattern    string     `json:"pattern"`
+	DefaultTTL string     `json:"default_ttl,omitempty"`
+	MaxTTL     string     `json:"max_ttl,omitempty"`
+	NonTTLTags string     `json:"non_ttl_tags,omitempty"`
+	Source     string     `json:"source"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+}
+
+// views returns the rules of kind, static ones first, sorted by pattern.
+func (s *Set) views(kind string) []ruleView {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := []ruleView{}
+	for _, pattern := range s.static[kind] {
+		out = append(out, ruleView{Pattern: pattern, Source: sourceConfig})
+	}
+	managed := append([]redisclient.Rule(nil), s.managed[kind]...)
+	sort.Slice(managed, func(i, j int) bool { return managed[i].Pattern < managed[j].Pattern })
+	for _, r := range managed {
+		v := ruleView{Pattern: r.Pattern, NonTTLTags: r.NonTTLTags, Source: sourceAPI, CreatedAt: &r.CreatedAt}
+		if r.DefaultTTL > 0 {
+			v.DefaultTTL = r.DefaultTTL.String()
+		}
+		if r.MaxTTL > 0 {
+			v.MaxTTL = r.MaxTTL.String()
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+// parseRule validates a rule of kind from the API. TTLs use the tag syntax
+// and, like the action for tags that are not TTLs, only apply to repository
+// policies.
+func parseRule(kind, pattern, defaultTTL, maxTTL, nonTTLTags string) (redisclient.Rule, error) {
+	r := redisclient.Rule{Pattern: pattern, NonTTLTags: nonTTLTags, CreatedAt: time.Now().UTC()}
+	if pattern == "" {
+		return r, fmt.Errorf("%w: pattern is required", errInvalidRule)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return r, fmt.Errorf("%w: pattern %q: %w", errInvalidRule, pattern, err)
+	}
+	if kind != redisclient.RuleRepoPolicy {
+		if defaultTTL != "" || maxTTL != "" || nonTTLTags != "" {
+			return r, fmt.Errorf("%w: TTLs and non_ttl_tags only apply to %s rules",
+				errInvalidRule, redisclient.RuleRepoPolicy)
+		}
+		return r, nil
+	}
+	if defaultTTL == "" && maxTTL == "" && nonTTLTags == "" {
+		return r, fmt.Errorf("%w: default_ttl, max_ttl or non_ttl_tags is required", errInvalidRule)
+	}
+	if nonTTLTags != "" && !slices.Contains(hooks.NonTTLActions, nonTTLTags) {
+		return r, fmt.Errorf("%w: non_ttl_tags must be one of %s", errInvalidRule,
+			strings.Join(hooks.NonTTLActions, ", "))
+	}
+	for _, f := range []struct {
+		spec string
+		dst  *time.Duration
+	}{{defaultTTL, &r.DefaultTTL}, {maxTTL, &r.MaxTTL}} {
+		if f.spec == "" {
+			continue
+		}
+		if *f.dst = hooks.ParseTTL(f.spec); *f.dst <= 0 {
+			return r, fmt.Errorf("%w: invalid duration %q", errInvalidRule, f.spec)
+		}
+	}
+	if r.DefaultTTL > 0 && r.MaxTTL > 0 && r.DefaultTTL > r.MaxTTL {
+		return r, fmt.Errorf("%w: default_ttl %s exceeds max_ttl %s", errInvalidRule, r.DefaultTTL, r.MaxTTL)
+	}
+	return r, nil
+}
+
+// Seed adds a rule of kind unless one with the same pattern exists, and
+// reports whether it did. Rules are validated as by the API.
+func Seed(
+	ctx context.Context,
+	store redisclient.Store,
+	kind, pattern, defaultTTL, maxTTL, nonTTLTags string,
+) (bool, error) {
+	if !slices.Contains(kinds, kind) {
+		return false, fmt.Errorf("%w: unknown kind %q, want one of %s", errInvalidRule, kind, strings.Join(kinds, ", "))
+	}
+	r, err := parseRule(kind, pattern, defaultTTL, maxTTL, nonTTLTags)
+	if err != nil {
+		return false, err
+	}
+	existing, err := store.ListRules(ctx, kind)
+	if err != nil {
+		return false, fmt.Errorf("listing %s rules: %w", kind, err)
+	}
+	if slices.ContainsFunc(existing, func(e redisclient.Rule) bool { return e.Pattern == pattern }) {
+		return false, nil
+	}
+	if err := store.PutRule(ctx, kind, r); err != nil {
+		return false, fmt.Errorf("storing %s rule %q: %w", kind, pattern, err)
+	}
+	return true, nil
+}
+
+// Handler serves the rules API, expecting the kind in the "kind" path
+// value: GET lists the rules, of one kind or of all kinds if none is
+// given; POST adds or replaces a rule from {"pattern", "default_ttl",
+// "max_ttl", "non_ttl_tags"}; DELETE removes the rule whose pattern is given as a query
+// parameter. Rules from config cannot be removed.
+func (s *Set) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		kind := req.PathValue("kind")
+		if kind != "" && !slices.Contains(kinds, kind) {
+			http.Error(w, "unknown rule kind, want one of "+strings.Join(kinds, ", "), http.StatusNotFound)
+			return
+		}
+		switch req.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			if kind != "" {
+				_ = json.NewEncoder(w).Encode(s.views(kind))
+				return
+			}
+			all := make(map[string][]ruleView, len(kinds))
+			for _, k := range kinds {
+				all[k] = s.views(k)
+			}
+			_ = json.NewEncoder(w).Encode(all)
+		case http.MethodPost:
+			var body struct {
+				Pattern    string `json:"pattern"`
+				DefaultTTL string `json:"default_ttl"`
+				MaxTTL     string `json:"max_ttl"`
+				NonTTLTags string `json:"non_ttl_tags"`
+			}
+			if kind == "" {
+				http.Error(w, "missing rule kind", http.StatusBadRequest)
+				return
+			}
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				http.Error(w, "invalid JSON body", http.StatusBadRequest)
+				return
+			}
+			r, err := parseRule(kind, body.Pattern, body.DefaultTTL, body.MaxTTL, body.NonTTLTags)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := s.store.PutRule(req.Context(), kind, r); err != nil {
+				s.logger.Error("failed to store rule", "kind", kind, "pattern", r.Pattern, "error", err)
+				http.Error(w, "rule update failed", http.StatusServiceUnavailable)
+				return
+			}
+			s.logger.Info("rule added", "kind", kind, "pattern", r.Pattern)
+			s.refreshAfterWrite(req.Context())
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			pattern := req.URL.Query().Get("pattern")
+			if kind == "" || pattern == "" {
+				http.Error(w, "missing rule kind or pattern", http.StatusBadRequest)
+				return
+			}
+			found, err := s.store.DeleteRule(req.Context(), kind, pattern)
+			if err != nil {
+				s.logger.Error("failed to delete rule", "kind", kind, "pattern", pattern, "error", err)
+				http.Error(w, "rule update failed", http.StatusServiceUnavailable)
+				return
+			}
+			if !found && slices.Contains(s.static[kind], pattern) {
+				http.Error(w, "rule is defined in config", http.StatusConflict)
+				return
+			}
+			if !found {
+				http.Error(w, "no such rule", http.StatusNotFound)
+				return
+			}
+			s.logger.Info("rule removed", "kind", kind, "pattern", pattern)
+			s.refreshAfterWrite(req.Context())
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// refreshAfterWrite applies a change made through this replica right away.
+func (s *Set) refreshAfterWrite(ctx context.Context) {
+	if err := s.Refresh(ctx); err != nil {
+		s.logger.Warn("failed to refresh rules after update", "error", err)
+	}
+}
@@ -0,0 +1,139 @@
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+func TestHandler(t *testing.T) {
+	store := memstore.New()
+	s := New(store, []string{"prod-*"}, nil, slog.Default())
+	mux := http.NewServeMux()
+	mux.Handle("GET /v1/api/rules", s.Handler())
+	mux.Handle("/v1/api/rules/{kind}", s.Handler())
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(method, target, bytes.NewReader([]byte(body))))
+		return rr
+	}
+
+	for _, tt := range []struct{ kind, body string }{
+		{redisclient.RuleImmutableTag, `{"pattern": "v*"}`},
+		{redisclient.RuleProtected, `{"pattern": "team/*:stable"}`},
+		{redisclient.RuleRepoPolicy, `{"pattern": "team/*", "default_ttl": "2h", "max_ttl": "1w"}`},
+		{redisclient.RuleRepoPolicy, `{"pattern": "release/*", "non_ttl_tags": "ignore"}`},
+	} {
+		if rr := do(http.MethodPost, "/v1/api/rules/"+tt.kind, tt.body); rr.Code != http.StatusCreated {
+			t.Fatalf("POST %s: expected 201, got %d: %s", tt.kind, rr.Code, rr.Body)
+		}
+	}
+	for _, tt := range []struct{ kind, body string }{
+		{redisclient.RuleImmutableTag, `{"pattern": ""}`},
+		{redisclient.RuleImmutableTag, `{"pattern": "["}`},
+		{redisclient.RuleProtected, `{"pattern": "app:*", "max_ttl": "1h"}`},
+		{redisclient.RuleRepoPolicy, `{"pattern": "app"}`},
+		{redisclient.RuleRepoPolicy, `{"pattern": "app", "max_ttl": "forever"}`},
+		{redisclient.RuleRepoPolicy, `{"pattern": "app", "default_ttl": "2d", "max_ttl": "1d"}`},
+		{redisclient.RuleRepoPolicy, `{"pattern": "app", "non_ttl_tags": "keep"}`},
+		{redisclient.RuleProtected, `{"pattern": "app:*", "non_ttl_tags": "ignore"}`},
+		{redisclient.RuleRepoPolicy, `nope`},
+	} {
+		if rr := do(http.MethodPost, "/v1/api/rules/"+tt.kind, tt.body); rr.Code != http.StatusBadRequest {
+			t.Errorf("POST %s %s: expected 400, got %d", tt.kind, tt.body, rr.Code)
+		}
+	}
+	if rr := do(http.MethodPost, "/v1/api/rules/unknown", `{"pattern": "x"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown kind: expected 404, got %d", rr.Code)
+	}
+
+	if !s.ImmutableTag("prod-1") || !s.ImmutableTag("v1.0") || s.ImmutableTag("latest") {
+		t.Error("immutable tags should combine config and API patterns")
+	}
+	if !s.Protected("team/app:stable") || s.Protected("team/app:1h") {
+		t.Error("protected pattern should match repo:tag")
+	}
+	if d, m, ok := s.RepoPolicy("team/app"); !ok || d != 2*time.Hour || m != 7*24*time.Hour {
+		t.Errorf("RepoPolicy = %v, %v, %v", d, m, ok)
+	}
+	if a, b := s.NonTTLTags("release/app"), s.NonTTLTags("team/app"); a != "ignore" || b != "" {
+		t.Errorf("NonTTLTags = %q, %q; want ignore for release/app only", a, b)
+	}
+
+	var all map[string][]ruleView
+	if err := json.NewDecoder(do(http.MethodGet, "/v1/api/rules", "").Body).Decode(&all); err != nil {
+		t.Fatal(err)
+	}
+	immutable := all[redisclient.RuleImmutableTag]
+	if len(immutable) != 2 || immutable[0].Source != sourceConfig || immutable[1].Source != sourceAPI {
+		t.Errorf("immutable rules = %+v, want config rule then API rule", immutable)
+	}
+	if p := all[redisclient.RuleRepoPolicy]; len(p) != 2 || p[0].NonTTLTags != "ignore" ||
+		p[1].DefaultTTL != "2h0m0s" || p[1].MaxTTL != "168h0m0s" {
+		t.Errorf("repo policies = %+v", p)
+	}
+
+	if rr := do(http.MethodDelete, "/v1/api/rules/immutable_tag?pattern=prod-*", ""); rr.Code != http.StatusConflict {
+		t.Errorf("DELETE config rule: expected 409, got %d", rr.Code)
+	}
+	if rr := do(http.MethodDelete, "/v1/api/rules/immutable_tag?pattern=nope", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("DELETE missing rule: expected 404, got %d", rr.Code)
+	}
+	if rr := do(http.MethodDelete, "/v1/api/rules/immutable_tag?pattern=v*", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: expected 204, got %d", rr.Code)
+	}
+	if s.ImmutableTag("v1.0") {
+		t.Error("deleted rule should no longer apply")
+	}
+}
+
+func TestSet_Refresh(t *testing.T) {
+	store := memstore.New()
+	s := New(store, nil, nil, slog.Default())
+	_ = store.PutRule(t.Context(), redisclient.RuleRepoPolicy, redisclient.Rule{Pattern: "*", MaxTTL: time.Hour})
+	_ = store.PutRule(t.Context(), redisclient.RuleRepoPolicy, redisclient.Rule{Pattern: "ci/*", DefaultTTL: time.Minute})
+
+	if _, _, ok := s.RepoPolicy("app"); ok {
+		t.Fatal("rules added through another replica should apply only after a refresh")
+	}
+	if err := s.Refresh(t.Context()); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if _, m, ok := s.RepoPolicy("app"); !ok || m != time.Hour {
+		t.Errorf("RepoPolicy(app) max = %v, %v; want 1h", m, ok)
+	}
+	if d, m, _ := s.RepoPolicy("ci/app"); d != time.Minute || m != 0 {
+		t.Errorf("RepoPolicy(ci/app) = %v, %v; want the more specific policy", d, m)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	store := memstore.New()
+
+	added, err := Seed(t.Context(), store, redisclient.RuleRepoPolicy, "ci/*", "1d", "", "")
+	if err != nil || !added {
+		t.Fatalf("Seed = %v, %v; want true, nil", added, err)
+	}
+	added, err = Seed(t.Context(), store, redisclient.RuleRepoPolicy, "ci/*", "2d", "", "")
+	if err != nil || added {
+		t.Fatalf("re-run Seed = %v, %v; want false, nil", added, err)
+	}
+	got, _ := store.ListRules(t.Context(), redisclient.RuleRepoPolicy)
+	if len(got) != 1 || got[0].DefaultTTL != 24*time.Hour {
+		t.Errorf("rules = %+v; want the first seeded rule kept", got)
+	}
+
+	if _, err := Seed(t.Context(), store, "unknown", "x", "", "", ""); err == nil {
+		t.Error("expected error for unknown kind")
+	}
+	if _, err := Seed(t.Context(), store, redisclient.RuleProtected, "x", "1d", "", ""); err == nil {
+		t.Error("expected error for TTLs on a protected rule")
+	}
+}
@@ -0,0 +1,215 @@
+// Package schedule decides when periodic work runs next: at a fixed
+// interval, or at the times matched by a cron expression, optionally
+// delayed by a random jitter so that several deployments sharing one
+// registry do not act in lockstep.
+package schedule
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule yields the activation times of periodic work.
+type Schedule interface {
+	// Next returns the first activation strictly after t, or the zero time
+	// if there is none.
+	Next(t time.Time) time.Time
+	String() string
+}
+
+// Every returns a schedule activating every d.
+func Every(d time.Duration) Schedule {
+	return every(d)
+}
+
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time { return t.Add(time.Duration(e)) }
+func (e every) String() string             { return "every " + time.Duration(e).String() }
+
+// WithJitter delays every activation of s by a random duration below
+// jitter. A non-positive jitter returns s unchanged.
+func WithJitter(s Schedule, jitter time.Duration) Schedule {
+	if jitter <= 0 {
+		return s
+	}
+	return jittered{s: s, jitter: jitter}
+}
+
+type jittered struct {
+	s      Schedule
+	jitter time.Duration
+}
+
+func (j jittered) Next(t time.Time) time.Time {
+	next := j.s.Next(t)
+	if next.IsZero() {
+		return next
+	}
+	return next.Add(rand.N(j.jitter))
+}
+
+func (j jittered) String() string {
+	return j.s.String() + " (jitter " + j.jitter.String() + ")"
+}
+
+// Cron is a schedule defined by a standard five-field cron expression:
+// minute, hour, day of month, month and day of week. Fields accept "*",
+// numbers, ranges ("1-5"), steps ("*/15", "0-30/10") and comma-separated
+// lists of those. Day of week runs from 0 (Sunday) to 7 (Sunday again).
+// As in Vixie cron, a day matches if either day field matches when both
+// are restricted. The macros @hourly, @daily, @weekly, @monthly and
+// @yearly are accepted as well.
+type Cron struct {
+	expr                          string
+	minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted  bool
+}
+
+// macros maps the supported cron shorthands to their expressions.
+var macros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+// field describes the value range of a cron field.
+type field struct {
+	name     string
+	min, max int
+}
+
+var fields = [5]field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// ParseCron parses a cron expression.
+func ParseCron(expr string) (*Cron, error) {
+	spec := strings.TrimSpace(expr)
+	if m, ok := macros[spec]; ok {
+		spec = m
+	}
+	parts := strings.Fields(spec)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("cron expression %q: want %d fields, got %d", expr, len(fields), len(parts))
+	}
+	var sets [5]uint64
+	for i, part := range parts {
+		set, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", expr, err)
+		}
+		sets[i] = set
+	}
+	c := &Cron{
+		expr:          expr,
+		minute:        sets[0],
+		hour:          sets[1],
+		dom:           sets[2],
+		month:         sets[3],
+		dow:           sets[4],
+		domRestricted: !strings.HasPrefix(parts[2], "*"),
+		dowRestricted: !strings.HasPrefix(parts[4], "*"),
+	}
+	// Sunday may be written as 0 or 7.
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+// parseField returns the values of f matched by spec as a bit set.
+func parseField(spec string, f field) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(spec, ",") {
+		rng, stepStr, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid %s step %q", f.name, stepStr)
+			}
+			step = n
+		}
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = parseValue(loStr, f); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = parseValue(hiStr, f); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = f.max
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid %s range %q", f.name, rng)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func parseValue(s string, f field) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s %q (want %d-%d)", f.name, s, f.min, f.max)
+	}
+	return v, nil
+}
+
+// maxSearch bounds how far ahead Next looks for a matching time, so that
+// expressions that never match, such as "0 0 31 2 *", cannot loop forever.
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+// Next returns the first minute after t matched by the expression, in t's
+// location, or the zero time if none exists within five years.
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxSearch)
+	for t.Before(limit) {
+		switch {
+		case !has(c.month, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !has(c.hour, t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !has(c.minute, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom, dow := has(c.dom, t.Day()), has(c.dow, int(t.Weekday()))
+	if c.domRestricted && c.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+func (c *Cron) String() string { return "cron " + c.expr }
+
+func has(set uint64, v int) bool { return set&(1<<v) != 0 }
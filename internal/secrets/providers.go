@@ -0,0 +1,176 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/sigv4"
+)
+
+// Vault is a Source reading a field of a secret from Vault's KV secrets
+// engine. Both KV version 1 and version 2 paths (with /data/) work.
+type Vault struct {
+	Addr      string
+	Token     string
+	TokenFile string
+	Path      string
+	Field     string
+	Client    *http.Client
+}
+
+// Fetch implements Source.
+func (v *Vault) Fetch(ctx context.Context) (string, error) {
+	token := v.Token
+	if v.TokenFile != "" {
+		t, err := readFile(v.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("reading vault token: %w", err)
+		}
+		token = t
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.Addr+"/v1/"+v.Path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned %d for %s", resp.StatusCode, v.Path)
+	}
+
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decoding vault response: %w", err)
+	}
+	// KV version 2 nests the secret's fields in data.data.
+	fields := body.Data
+	if nested, ok := fields["data"].(map[string]any); ok {
+		fields = nested
+	}
+	value, ok := fields[v.Field].(string)
+	if !ok || value == "" {
+		return "", fmt.Errorf("vault secret %s has no field %q", v.Path, v.Field)
+	}
+	return value, nil
+}
+
+// AWSCredentials authenticate requests to AWS APIs in Region.
+// SessionToken is only set for temporary credentials.
+type AWSCredentials struct {
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// call invokes the JSON API action target, e.g.
+// "secretsmanager.GetSecretValue", of service at endpoint and decodes the
+// response into out.
+func (c AWSCredentials) call(
+	ctx context.Context, client *http.Client, endpoint, service, target string, in, out any,
+) error {
+	payload, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+	sigv4.SignService(req, service, c.AccessKeyID, c.SecretAccessKey, c.Region,
+		sigv4.PayloadHash(payload), time.Now())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned %d: %s", service, resp.StatusCode, msg)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding %s response: %w", service, err)
+	}
+	return nil
+}
+
+// AWSSecretsManager is a Source reading a secret from AWS Secrets Manager.
+type AWSSecretsManager struct {
+	AWSCredentials
+	Endpoint string
+	SecretID string
+	Key      string
+	Client   *http.Client
+}
+
+// Fetch implements Source.
+func (a *AWSSecretsManager) Fetch(ctx context.Context) (string, error) {
+	var body struct {
+		SecretString string `json:"SecretString"`
+	}
+	err := a.call(ctx, a.Client, a.Endpoint, "secretsmanager", "secretsmanager.GetSecretValue",
+		map[string]string{"SecretId": a.SecretID}, &body)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", a.SecretID, err)
+	}
+	if a.Key == "" {
+		if body.SecretString == "" {
+			return "", fmt.Errorf("secret %s has no secret string", a.SecretID)
+		}
+		return body.SecretString, nil
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(body.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object: %w", a.SecretID, err)
+	}
+	value, ok := fields[a.Key].(string)
+	if !ok || value == "" {
+		return "", fmt.Errorf("secret %s has no key %q", a.SecretID, a.Key)
+	}
+	return value, nil
+}
+
+// AWSKMS is a Source decrypting a data key encrypted with AWS KMS, such as
+// one created with aws kms generate-data-key. It returns the plain key
+// base64-encoded.
+type AWSKMS struct {
+	AWSCredentials
+	Endpoint string
+	// Ciphertext is the base64-encoded ciphertext blob.
+	Ciphertext string
+	Client     *http.Client
+}
+
+// Fetch implements Source.
+func (k *AWSKMS) Fetch(ctx context.Context) (string, error) {
+	var body struct {
+		Plaintext string `json:"Plaintext"`
+	}
+	err := k.call(ctx, k.Client, k.Endpoint, "kms", "TrentService.Decrypt",
+		map[string]string{"CiphertextBlob": k.Ciphertext}, &body)
+	if err != nil {
+		return "", fmt.Errorf("decrypting data key: %w", err)
+	}
+	if body.Plaintext == "" {
+		return "", errors.New("kms returned no plaintext")
+	}
+	return body.Plaintext, nil
+}
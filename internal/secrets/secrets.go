@@ -0,0 +1,230 @@
+// Package secrets loads secrets such as the webhook token from files or
+// from a secret manager, and keeps them up to date while the server runs.
+//
+// A secret reference names where a secret lives:
+//
+//	file:/run/secrets/hook-token
+//	vault:secret/data/ephemeron#hook_token
+//	aws-sm:prod/ephemeron#hook_token
+//	aws-kms:AQIDAHh...
+//
+// Vault references are a KV path and a field. AWS Secrets Manager
+// references are a secret ID and, for secrets holding a JSON object, the
+// key to read; without a key the whole secret string is used. AWS KMS
+// references are a base64 data key encrypted with KMS, which is decrypted
+// to its base64 plain text, for encryption keys.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/metrics"
+)
+
+// Reference schemes.
+const (
+	SchemeFile  = "file"
+	SchemeVault = "vault"
+	SchemeAWSSM = "aws-sm"
+	SchemeKMS   = "aws-kms"
+)
+
+// fetchTimeout bounds a single request to a secret manager.
+const fetchTimeout = 10 * time.Second
+
+// Config holds the secret manager settings used to resolve references.
+type Config struct {
+	// VaultAddr is the base URL of the Vault server.
+	VaultAddr string
+
+	// VaultToken authenticates to Vault. VaultTokenFile, if set, is read
+	// on every fetch instead, so a token renewed by an agent is picked up.
+	VaultToken     string
+	VaultTokenFile string
+
+	// AWSRegion and the credentials authenticate to AWS Secrets Manager.
+	// AWSSessionToken is only set for temporary credentials.
+	AWSRegion          string
+	AWSAccessKeyID     string
+	AWSSecretAccessKey string
+	AWSSessionToken    string
+
+	// AWSEndpoint and AWSKMSEndpoint override the Secrets Manager and KMS
+	// endpoints of AWSRegion.
+	AWSEndpoint    string
+	AWSKMSEndpoint string
+
+	// RefreshInterval is how often secrets from references are fetched
+	// again while the server runs. Zero disables refreshing.
+	RefreshInterval time.Duration
+}
+
+// Source fetches the current value of a secret.
+type Source interface {
+	Fetch(ctx context.Context) (string, error)
+}
+
+// Parse returns the Source for the secret reference ref.
+func (c Config) Parse(ref string) (Source, error) {
+	scheme, rest, ok := strings.Cut(ref, ":")
+	if !ok || rest == "" {
+		return nil, fmt.Errorf("invalid secret reference %q, want scheme:location", ref)
+	}
+	location, key, _ := strings.Cut(rest, "#")
+	client := &http.Client{Timeout: fetchTimeout}
+	switch scheme {
+	case SchemeFile:
+		return File(rest), nil
+	case SchemeVault:
+		if c.VaultAddr == "" {
+			return nil, errors.New("VAULT_ADDR is required for vault secret references")
+		}
+		if c.VaultToken == "" && c.VaultTokenFile == "" {
+			return nil, errors.New("VAULT_TOKEN or VAULT_TOKEN_FILE is required for vault secret references")
+		}
+		if key == "" {
+			return nil, fmt.Errorf("vault secret reference %q has no #field", ref)
+		}
+		return &Vault{
+			Addr:      strings.TrimRight(c.VaultAddr, "/"),
+			Token:     c.VaultToken,
+			TokenFile: c.VaultTokenFile,
+			Path:      strings.Trim(location, "/"),
+			Field:     key,
+			Client:    client,
+		}, nil
+	case SchemeAWSSM, SchemeKMS:
+		if c.AWSRegion == "" {
+			return nil, fmt.Errorf("AWS_REGION is required for %s secret references", scheme)
+		}
+		if c.AWSAccessKeyID == "" || c.AWSSecretAccessKey == "" {
+			return nil, fmt.Errorf(
+				"AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required for %s secret references", scheme)
+		}
+		creds := AWSCredentials{
+			Region:          c.AWSRegion,
+			AccessKeyID:     c.AWSAccessKeyID,
+			SecretAccessKey: c.AWSSecretAccessKey,
+			SessionToken:    c.AWSSessionToken,
+		}
+		if scheme == SchemeKMS {
+			return &AWSKMS{
+				AWSCredentials: creds,
+				Endpoint:       awsEndpoint(c.AWSKMSEndpoint, "kms", c.AWSRegion),
+				Ciphertext:     rest,
+				Client:         client,
+			}, nil
+		}
+		return &AWSSecretsManager{
+			AWSCredentials: creds,
+			Endpoint:       awsEndpoint(c.AWSEndpoint, "secretsmanager", c.AWSRegion),
+			SecretID:       location,
+			Key:            key,
+			Client:         client,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown secret reference scheme %q, want %s, %s, %s or %s",
+			scheme, SchemeFile, SchemeVault, SchemeAWSSM, SchemeKMS)
+	}
+}
+
+// awsEndpoint returns override, or the regional endpoint of service.
+func awsEndpoint(override, service, region string) string {
+	if override != "" {
+		return strings.TrimRight(override, "/")
+	}
+	return "https://" + service + "." + region + ".amazonaws.com"
+}
+
+// File is a Source reading a secret from the file at its path. Leading and
+// trailing whitespace, such as a final newline, is trimmed.
+type File string
+
+// Fetch implements Source.
+func (f File) Fetch(context.Context) (string, error) {
+	return readFile(string(f))
+}
+
+func readFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	v := strings.TrimSpace(string(b))
+	if v == "" {
+		return "", fmt.Errorf("secret file %s is empty", path)
+	}
+	return v, nil
+}
+
+// Secret is a secret value that can be refreshed from its Source while it
+// is in use. It is safe for concurrent use.
+type Secret struct {
+	name   string
+	src    Source
+	logger *slog.Logger
+
+	mu    sync.RWMutex
+	value string
+}
+
+// New creates a Secret named name, e.g. "HOOK_TOKEN", with the initial
+// value. A nil src keeps the value fixed.
+func New(name string, src Source, value string, logger *slog.Logger) *Secret {
+	return &Secret{name: name, src: src, value: value, logger: logger}
+}
+
+// Value returns the current value of the secret.
+func (s *Secret) Value() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.value
+}
+
+// Refresh fetches the secret from its source. On error the previous value
+// is kept.
+func (s *Secret) Refresh(ctx context.Context) error {
+	if s.src == nil {
+		return nil
+	}
+	v, err := s.src.Fetch(ctx)
+	if err != nil {
+		metrics.SecretRefreshFailuresTotal.WithLabelValues(s.name).Inc()
+		return fmt.Errorf("fetching %s: %w", s.name, err)
+	}
+	s.mu.Lock()
+	changed := s.value != v
+	s.value = v
+	s.mu.Unlock()
+	if changed {
+		s.logger.Info("secret rotated", "secret", s.name)
+	}
+	return nil
+}
+
+// RefreshLoop refreshes the secret every interval until ctx is cancelled.
+func (s *Secret) RefreshLoop(ctx context.Context, interval time.Duration) {
+	if s.src == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Refresh(ctx); err != nil {
+				s.logger.Warn("failed to refresh secret, keeping the previous value", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	c := Config{
+		VaultAddr:          "https://vault:8200/",
+		VaultToken:         "s.token",
+		AWSRegion:          "eu-west-1",
+		AWSAccessKeyID:     "AKID",
+		AWSSecretAccessKey: "secret",
+	}
+	creds := AWSCredentials{Region: "eu-west-1", AccessKeyID: "AKID", SecretAccessKey: "secret"}
+	tests := []struct {
+		ref     string
+		want    Source
+		wantErr string
+	}{
+		{ref: "file:/run/secrets/token", want: File("/run/secrets/token")},
+		{ref: "/run/secrets/token", wantErr: "want scheme:location"},
+		{ref: "ssm:token", wantErr: "unknown secret reference scheme"},
+		{ref: "vault:secret/data/app", wantErr: "no #field"},
+		{ref: "vault:/secret/data/app#token", want: &Vault{
+			Addr: "https://vault:8200", Token: "s.token", Path: "secret/data/app", Field: "token",
+		}},
+		{ref: "aws-sm:prod/app", want: &AWSSecretsManager{
+			AWSCredentials: creds, Endpoint: "https://secretsmanager.eu-west-1.amazonaws.com", SecretID: "prod/app",
+		}},
+		{ref: "aws-kms:AQIDAHhBlob==", want: &AWSKMS{
+			AWSCredentials: creds, Endpoint: "https://kms.eu-west-1.amazonaws.com", Ciphertext: "AQIDAHhBlob==",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := c.Parse(tt.ref)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			switch s := got.(type) {
+			case *Vault:
+				s.Client = nil
+			case *AWSSecretsManager:
+				s.Client = nil
+			case *AWSKMS:
+				s.Client = nil
+			}
+			if gotJSON, wantJSON := mustJSON(t, got), mustJSON(t, tt.want); gotJSON != wantJSON {
+				t.Errorf("Parse() = %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+
+	if _, err := (Config{}).Parse("vault:secret/app#token"); err == nil {
+		t.Error("expected error for a vault reference without VAULT_ADDR")
+	}
+	if _, err := (Config{AWSRegion: "eu-west-1"}).Parse("aws-sm:app"); err == nil {
+		t.Error("expected error for an aws-sm reference without credentials")
+	}
+}
+
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := File(path).Fetch(t.Context()); err != nil || v != "s3cret" {
+		t.Errorf("Fetch() = %q, %v; want s3cret", v, err)
+	}
+	if err := os.WriteFile(path, []byte("\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := File(path).Fetch(t.Context()); err == nil {
+		t.Error("expected error for an empty file")
+	}
+}
+
+func TestVault(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/app":
+			_, _ = w.Write([]byte(`{"data":{"data":{"token":"v2-value"},"metadata":{"version":3}}}`))
+		case "/v1/kv/app":
+			_, _ = w.Write([]byte(`{"data":{"token":"v1-value"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "vault-token")
+	if err := os.WriteFile(tokenFile, []byte("s.token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c := Config{VaultAddr: srv.URL, VaultToken: "s.token"}
+	tests := []struct {
+		name, ref, want string
+		cfg             Config
+	}{
+		{name: "kv v2", ref: "vault:secret/data/app#token", want: "v2-value", cfg: c},
+		{name: "kv v1", ref: "vault:kv/app#token", want: "v1-value", cfg: c},
+		{name: "token file", ref: "vault:kv/app#token", want: "v1-value",
+			cfg: Config{VaultAddr: srv.URL, VaultTokenFile: tokenFile}},
+		{name: "missing field", ref: "vault:kv/app#password", cfg: c},
+		{name: "missing path", ref: "vault:kv/other#token", cfg: c},
+		{name: "wrong token", ref: "vault:kv/app#token", cfg: Config{VaultAddr: srv.URL, VaultToken: "bad"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := tt.cfg.Parse(tt.ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := src.Fetch(t.Context())
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("Fetch() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Fetch() = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAWSSecretsManager(t *testing.T) {
+	var gotReq *http.Request
+	var gotBody map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotReq = r
+		_ = json.NewDecoder(r.Body).Decode(&gotBody)
+		switch gotBody["SecretId"] {
+		case "prod/json":
+			_, _ = w.Write([]byte(`{"Name":"prod/json","SecretString":"{\"token\":\"from-json\"}"}`))
+		case "prod/plain":
+			_, _ = w.Write([]byte(`{"Name":"prod/plain","SecretString":"plain-value"}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type":"ResourceNotFoundException"}`))
+		}
+	}))
+	defer srv.Close()
+
+	c := Config{
+		AWSRegion:          "eu-west-1",
+		AWSAccessKeyID:     "AKID",
+		AWSSecretAccessKey: "secret",
+		AWSSessionToken:    "session",
+		AWSEndpoint:        srv.URL,
+	}
+	fetch := func(ref string) (string, error) {
+		src, err := c.Parse(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return src.Fetch(t.Context())
+	}
+
+	if v, err := fetch("aws-sm:prod/json#token"); err != nil || v != "from-json" {
+		t.Errorf("Fetch(json) = %q, %v; want from-json", v, err)
+	}
+	if got := gotReq.Header.Get("X-Amz-Target"); got != "secretsmanager.GetSecretValue" {
+		t.Errorf("X-Amz-Target = %q", got)
+	}
+	if got := gotReq.Header.Get("Authorization"); !strings.Contains(got, "/eu-west-1/secretsmanager/aws4_request") ||
+		!strings.Contains(got, "x-amz-security-token") {
+		t.Errorf("Authorization = %q, want a secretsmanager signature including the session token", got)
+	}
+	if v, err := fetch("aws-sm:prod/plain"); err != nil || v != "plain-value" {
+		t.Errorf("Fetch(plain) = %q, %v; want plain-value", v, err)
+	}
+	if _, err := fetch("aws-sm:prod/plain#token"); err == nil {
+		t.Error("expected error for a key in a non-JSON secret")
+	}
+	if _, err := fetch("aws-sm:prod/missing"); err == nil || !strings.Contains(err.Error(), "ResourceNotFound") {
+		t.Errorf("Fetch(missing) error = %v, want ResourceNotFoundException", err)
+	}
+}
+
+func TestAWSKMS(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&in)
+		if r.Header.Get("X-Amz-Target") != "TrentService.Decrypt" || in["CiphertextBlob"] != "Y2lwaGVy" ||
+			!strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/kms/aws4_request") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"KeyId":"arn:aws:kms:eu-west-1:1:key/k","Plaintext":"cGxhaW4="}`))
+	}))
+	defer srv.Close()
+
+	c := Config{AWSRegion: "eu-west-1", AWSAccessKeyID: "AKID", AWSSecretAccessKey: "secret", AWSKMSEndpoint: srv.URL}
+	src, err := c.Parse("aws-kms:Y2lwaGVy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := src.Fetch(t.Context()); err != nil || v != "cGxhaW4=" {
+		t.Errorf("Fetch() = %q, %v; want cGxhaW4=", v, err)
+	}
+}
+
+type fakeSource struct {
+	value string
+	err   error
+}
+
+func (f *fakeSource) Fetch(context.Context) (string, error) {
+	return f.value, f.err
+}
+
+func TestSecret_Refresh(t *testing.T) {
+	src := &fakeSource{value: "rotated"}
+	s := New("HOOK_TOKEN", src, "initial", slog.New(slog.DiscardHandler))
+	if got := s.Value(); got != "initial" {
+		t.Errorf("Value() = %q, want initial", got)
+	}
+	if err := s.Refresh(t.Context()); err != nil || s.Value() != "rotated" {
+		t.Errorf("after Refresh, Value() = %q, %v; want rotated", s.Value(), err)
+	}
+	src.err = errors.New("vault sealed")
+	if err := s.Refresh(t.Context()); err == nil || s.Value() != "rotated" {
+		t.Errorf("failed Refresh = %v, Value() = %q; want an error and the previous value", err, s.Value())
+	}
+
+	fixed := New("HOOK_TOKEN", nil, "static", slog.New(slog.DiscardHandler))
+	if err := fixed.Refresh(t.Context()); err != nil || fixed.Value() != "static" {
+		t.Errorf("Refresh without a source = %v, Value() = %q", err, fixed.Value())
+	}
+}
@@ -0,0 +1,318 @@
+// Package slack serves a Slack slash command for managing tracked images
+// from chat: "/ephemeron extend myapp:1h 4h" and "/ephemeron list team-a/*".
+// Requests are authenticated with Slack's signing secret, and each Slack
+// user may only act on the repositories in their scope.
+package slack
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/hooks"
+	"github.com/tamcore/ephemeron/internal/metrics"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// maxSkew is how far a request timestamp may be from now, bounding replays.
+const maxSkew = 5 * time.Minute
+
+// maxBody bounds the size of a slash command request.
+const maxBody = 64 << 10
+
+// maxListed bounds the images listed in one reply.
+const maxListed = 50
+
+// usage is the reply to "help" and to unknown subcommands.
+const usage = "Usage:\n" +
+	"• `extend <repo:tag> <duration>`: push back an image's expiry, e.g. `extend myapp:1h 4h`\n" +
+	"• `list [repo-pattern]`: list your images and when they expire, e.g. `list team-a/*`"
+
+// everyone is the user entry in scopes that applies to all Slack users.
+const everyone = "*"
+
+// Scopes maps Slack user IDs to the repository patterns they may manage.
+type Scopes map[string][]string
+
+// ParseScopes builds Scopes from "user=pattern" entries. A user may appear
+// several times; the user "*" grants a pattern to everyone.
+func ParseScopes(entries []string) (Scopes, error) {
+	scopes := make(Scopes)
+	for _, entry := range entries {
+		user, pattern, ok := strings.Cut(entry, "=")
+		user, pattern = strings.TrimSpace(user), strings.TrimSpace(pattern)
+		if !ok || user == "" || pattern == "" {
+			return nil, fmt.Errorf("invalid entry %q (want user=pattern)", entry)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		scopes[user] = append(scopes[user], pattern)
+	}
+	return scopes, nil
+}
+
+// allows reports whether user may manage repo.
+func (s Scopes) allows(user, repo string) bool {
+	for _, patterns := range [][]string{s[user], s[everyone]} {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, repo); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// repoPolicies provides per-repository TTL policies.
+type repoPolicies interface {
+	RepoPolicy(repo string) (defaultTTL, maxTTL time.Duration, ok bool)
+}
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithRules caps extensions at the max TTL of matching repository policies
+// instead of the global one.
+func WithRules(rules repoPolicies) Option {
+	return func(h *Handler) { h.rules = rules }
+}
+
+// Handler serves the slash command.
+type Handler struct {
+	store  redisclient.Store
+	secret []byte
+	scopes Scopes
+	maxTTL time.Duration
+	rules  repoPolicies
+	logger *slog.Logger
+	now    func() time.Time
+}
+
+// NewHandler returns a Handler verifying requests with signingSecret.
+// Extensions never push an expiry beyond maxTTL from now.
+func NewHandler(
+	store redisclient.Store,
+	signingSecret string,
+	scopes Scopes,
+	maxTTL time.Duration,
+	logger *slog.Logger,
+	opts ...Option,
+) *Handler {
+	h := &Handler{
+		store:  store,
+		secret: []byte(signingSecret),
+		scopes: scopes,
+		maxTTL: maxTTL,
+		logger: logger,
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// reply is the slash command response body. Replies are only shown to the
+// user who ran the command.
+type reply struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	if err != nil {
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !h.verify(r.Header.Get("X-Slack-Request-Timestamp"), r.Header.Get("X-Slack-Signature"), body) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "invalid form body", http.StatusBadRequest)
+		return
+	}
+
+	user := form.Get("user_id")
+	text, err := h.run(r.Context(), user, strings.Fields(form.Get("text")))
+	if err != nil {
+		h.logger.Error("slash command failed", "user", user, "text", form.Get("text"), "error", err)
+		text = "Something went wrong, please try again later."
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(reply{ResponseType: "ephemeral", Text: text})
+}
+
+// verify checks Slack's v0 request signature over the timestamp and body.
+func (h *Handler) verify(timestamp, signature string, body []byte) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := h.now().Sub(time.Unix(ts, 0)); skew > maxSkew || skew < -maxSkew {
+		return false
+	}
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	want := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(signature), []byte(want))
+}
+
+// run executes a subcommand for user and returns the reply text. Mistakes
+// by the user are explained in the reply; only store failures are errors.
+func (h *Handler) run(ctx context.Context, user string, args []string) (string, error) {
+	if len(args) == 0 {
+		return usage, nil
+	}
+	switch args[0] {
+	case "extend":
+		if len(args) != 3 {
+			return "Usage: `extend <repo:tag> <duration>`", nil
+		}
+		return h.extend(ctx, user, args[1], args[2])
+	case "list":
+		if len(args) > 2 {
+			return "Usage: `list [repo-pattern]`", nil
+		}
+		var pattern string
+		if len(args) == 2 {
+			pattern = args[1]
+		}
+		return h.list(ctx, user, pattern)
+	default:
+		return usage, nil
+	}
+}
+
+// extend pushes back the expiry of image by spec, counting from now if it
+// has already expired and capped at the max TTL from now.
+func (h *Handler) extend(ctx context.Context, user, image, spec string) (string, error) {
+	repo, tag, ok := strings.Cut(image, ":")
+	if !ok || repo == "" || tag == "" {
+		return fmt.Sprintf("`%s` is not an image reference, use `repo:tag`.", image), nil
+	}
+	d := hooks.ParseTTL(spec)
+	if d <= 0 {
+		return fmt.Sprintf("`%s` is not a duration, use e.g. `4h` or `2d`.", spec), nil
+	}
+	if !h.scopes.allows(user, repo) {
+		return fmt.Sprintf("You are not allowed to manage `%s`.", repo), nil
+	}
+
+	current, err := h.store.GetExpiry(ctx, image)
+	if err != nil {
+		return fmt.Sprintf("`%s` is not tracked.", image), nil
+	}
+	now := h.now()
+	expiresAt := time.UnixMilli(max(current, now.UnixMilli())).Add(d)
+	capped := false
+	if limit := now.Add(h.repoMaxTTL(repo)); expiresAt.After(limit) {
+		expiresAt, capped = limit, true
+	}
+	updated, err := h.store.SetExpiry(ctx, image, expiresAt)
+	if err != nil {
+		return "", fmt.Errorf("extending %s: %w", image, err)
+	}
+	if !updated {
+		return fmt.Sprintf("`%s` is not tracked.", image), nil
+	}
+	metrics.ExpiryExtensions.Inc()
+	h.logger.Info("extended image expiry from slack",
+		"image", image,
+		"user", user,
+		"extension", d.String(),
+		"expires_at", expiresAt.Format(time.RFC3339),
+	)
+	text := fmt.Sprintf("`%s` now expires %s.", image, when(expiresAt, now))
+	if capped {
+		text += " That is the maximum TTL for this repository."
+	}
+	return text, nil
+}
+
+// repoMaxTTL returns the max TTL that applies to repo.
+func (h *Handler) repoMaxTTL(repo string) time.Duration {
+	if h.rules != nil {
+		if _, m, ok := h.rules.RepoPolicy(repo); ok && m > 0 {
+			return m
+		}
+	}
+	return h.maxTTL
+}
+
+// list describes the tracked images in user's scope whose repository
+// matches pattern, or all of them if pattern is empty, soonest expiry first.
+func (h *Handler) list(ctx context.Context, user, pattern string) (string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Sprintf("`%s` is not a valid pattern.", pattern), nil
+	}
+	images, err := h.store.ListImages(ctx)
+	if err != nil {
+		return "", fmt.Errorf("listing images: %w", err)
+	}
+	type entry struct {
+		image     string
+		expiresAt time.Time
+	}
+	var entries []entry
+	for _, image := range images {
+		repo, _, _ := strings.Cut(image, ":")
+		if ok, _ := path.Match(pattern, repo); pattern != "" && !ok || !h.scopes.allows(user, repo) {
+			continue
+		}
+		ms, err := h.store.GetExpiry(ctx, image)
+		if err != nil {
+			continue // removed since it was listed
+		}
+		entries = append(entries, entry{image: image, expiresAt: time.UnixMilli(ms)})
+	}
+	if len(entries) == 0 && pattern == "" {
+		return "You have no images.", nil
+	}
+	if len(entries) == 0 {
+		return fmt.Sprintf("No images of yours match `%s`.", pattern), nil
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].expiresAt.Equal(entries[j].expiresAt) {
+			return entries[i].expiresAt.Before(entries[j].expiresAt)
+		}
+		return entries[i].image < entries[j].image
+	})
+
+	now := h.now()
+	var b strings.Builder
+	for _, e := range entries[:min(maxListed, len(entries))] {
+		fmt.Fprintf(&b, "• `%s` expires %s\n", e.image, when(e.expiresAt, now))
+	}
+	if len(entries) > maxListed {
+		fmt.Fprintf(&b, "…and %d more. Narrow the pattern to see them.", len(entries)-maxListed)
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+// when formats t for Slack, which renders it in the reader's time zone,
+// followed by the time left until then.
+func when(t, now time.Time) string {
+	left := "already expired"
+	if d := t.Sub(now).Round(time.Minute); d > 0 {
+		left = "in " + strings.TrimSuffix(d.String(), "0s")
+	}
+	return fmt.Sprintf("<!date^%d^{date_short_pretty} at {time}|%s> (%s)",
+		t.Unix(), t.UTC().Format(time.RFC3339), left)
+}
@@ -0,0 +1,171 @@
+package slack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+const secret = "8f742231b10e8888abcd99yyyzzz85a5"
+
+var now = time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func newHandler(t *testing.T, opts ...Option) (*Handler, *memstore.Store) {
+	t.Helper()
+	store := memstore.New()
+	_ = store.TrackImage(t.Context(), "team-a/app:1h", now.Add(time.Hour), 1, "", redisclient.ImageMeta{})
+	_ = store.TrackImage(t.Context(), "team-a/api:2h", now.Add(2*time.Hour), 1, "", redisclient.ImageMeta{})
+	_ = store.TrackImage(t.Context(), "team-b/app:1h", now.Add(time.Hour), 1, "", redisclient.ImageMeta{})
+	scopes, err := ParseScopes([]string{"U1=team-a/*", "*=demo/*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(store, secret, scopes, 24*time.Hour, slog.New(slog.DiscardHandler), opts...)
+	h.now = func() time.Time { return now }
+	return h, store
+}
+
+// command sends a signed slash command from user and returns the reply text.
+func command(t *testing.T, h *Handler, user, text string) string {
+	t.Helper()
+	body := url.Values{"command": {"/ephemeron"}, "user_id": {user}, "text": {text}}.Encode()
+	ts := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":" + body))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/hook/slack", strings.NewReader(body))
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var r reply
+	if err := json.NewDecoder(rec.Body).Decode(&r); err != nil || r.ResponseType != "ephemeral" {
+		t.Fatalf("expected ephemeral reply, got %+v (%v)", r, err)
+	}
+	return r.Text
+}
+
+func TestHandler_Signature(t *testing.T) {
+	h, _ := newHandler(t)
+	for name, mutate := range map[string]func(*http.Request){
+		"bad signature": func(r *http.Request) { r.Header.Set("X-Slack-Signature", "v0=00") },
+		"stale timestamp": func(r *http.Request) {
+			r.Header.Set("X-Slack-Request-Timestamp", strconv.FormatInt(now.Add(-time.Hour).Unix(), 10))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			body := "user_id=U1&text=list"
+			ts := strconv.FormatInt(now.Unix(), 10)
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write([]byte("v0:" + ts + ":" + body))
+			req := httptest.NewRequest(http.MethodPost, "/v1/hook/slack", strings.NewReader(body))
+			req.Header.Set("X-Slack-Request-Timestamp", ts)
+			req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+			mutate(req)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("expected 401, got %d", rec.Code)
+			}
+		})
+	}
+}
+
+func TestHandler_Extend(t *testing.T) {
+	h, store := newHandler(t)
+
+	text := command(t, h, "U1", "extend team-a/app:1h 4h")
+	if !strings.Contains(text, "`team-a/app:1h` now expires") {
+		t.Errorf("unexpected reply %q", text)
+	}
+	if got, _ := store.GetExpiry(t.Context(), "team-a/app:1h"); got != now.Add(5*time.Hour).UnixMilli() {
+		t.Errorf("expiry = %v, want %v", time.UnixMilli(got), now.Add(5*time.Hour))
+	}
+
+	text = command(t, h, "U1", "extend team-a/app:1h 7d")
+	if !strings.Contains(text, "maximum TTL") {
+		t.Errorf("expected capped extension, got %q", text)
+	}
+	if got, _ := store.GetExpiry(t.Context(), "team-a/app:1h"); got != now.Add(24*time.Hour).UnixMilli() {
+		t.Errorf("expiry = %v, want capped at %v", time.UnixMilli(got), now.Add(24*time.Hour))
+	}
+
+	if text := command(t, h, "U1", "extend team-b/app:1h 4h"); !strings.Contains(text, "not allowed") {
+		t.Errorf("expected out-of-scope extension to be refused, got %q", text)
+	}
+	if got, _ := store.GetExpiry(t.Context(), "team-b/app:1h"); got != now.Add(time.Hour).UnixMilli() {
+		t.Error("out-of-scope image was extended")
+	}
+	if text := command(t, h, "U1", "extend team-a/gone:1h 4h"); !strings.Contains(text, "not tracked") {
+		t.Errorf("expected untracked image to be reported, got %q", text)
+	}
+	if text := command(t, h, "U1", "extend team-a/app:1h soon"); !strings.Contains(text, "not a duration") {
+		t.Errorf("expected invalid duration to be reported, got %q", text)
+	}
+}
+
+func TestHandler_ExtendRepoPolicy(t *testing.T) {
+	h, store := newHandler(t, WithRules(fakeRules{"team-a/app": 2 * time.Hour}))
+	command(t, h, "U1", "extend team-a/app:1h 4h")
+	if got, _ := store.GetExpiry(t.Context(), "team-a/app:1h"); got != now.Add(2*time.Hour).UnixMilli() {
+		t.Errorf("expiry = %v, want capped by repository policy at %v", time.UnixMilli(got), now.Add(2*time.Hour))
+	}
+}
+
+type fakeRules map[string]time.Duration
+
+func (f fakeRules) RepoPolicy(repo string) (time.Duration, time.Duration, bool) {
+	m, ok := f[repo]
+	return 0, m, ok
+}
+
+func TestHandler_List(t *testing.T) {
+	h, _ := newHandler(t)
+
+	text := command(t, h, "U1", "list")
+	if !strings.Contains(text, "team-a/app:1h") || !strings.Contains(text, "team-a/api:2h") ||
+		strings.Contains(text, "team-b") {
+		t.Errorf("expected only in-scope images, got %q", text)
+	}
+	if strings.Index(text, "team-a/app:1h") > strings.Index(text, "team-a/api:2h") {
+		t.Errorf("expected soonest expiry first, got %q", text)
+	}
+	if text := command(t, h, "U1", "list team-a/api"); strings.Contains(text, "team-a/app") {
+		t.Errorf("expected pattern to filter images, got %q", text)
+	}
+	if text := command(t, h, "U2", "list"); text != "You have no images." {
+		t.Errorf("expected no images for user without scope, got %q", text)
+	}
+}
+
+func TestHandler_Usage(t *testing.T) {
+	h, _ := newHandler(t)
+	for _, text := range []string{"", "help", "delete everything"} {
+		if got := command(t, h, "U1", text); !strings.HasPrefix(got, "Usage:") {
+			t.Errorf("%q: expected usage, got %q", text, got)
+		}
+	}
+}
+
+func TestParseScopes_Invalid(t *testing.T) {
+	for _, entry := range []string{"U1", "=team-a/*", "U1=", "U1=[", ""} {
+		if _, err := ParseScopes([]string{entry}); err == nil {
+			t.Errorf("%q: expected error", entry)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// Package status summarizes the state of every ephemeron subsystem in one
+// JSON document, for dashboards and for support to look at first.
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/tamcore/ephemeron/internal/journal"
+	"github.com/tamcore/ephemeron/internal/reaper"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// Report is the document served by Handler. Parts that could not be read
+// carry an error instead of failing the whole report.
+type Report struct {
+	Version    string        `json:"version"`
+	ConfigHash string        `json:"config_hash"`
+	Store      StoreStatus   `json:"store"`
+	Registry   RegistryState `json:"registry"`
+	Reaper     ReaperState   `json:"reaper"`
+	Queues     Queues        `json:"queues"`
+}
+
+// StoreStatus describes the image store.
+type StoreStatus struct {
+	Healthy bool `json:"healthy"`
+	// Degraded is why the store is unsafe to delete by, if it is.
+	Degraded      string `json:"degraded,omitempty"`
+	TrackedImages int64  `json:"tracked_images"`
+	Error         string `json:"error,omitempty"`
+}
+
+// RegistryState describes the registry as seen by the reaper.
+type RegistryState struct {
+	Healthy             bool `json:"healthy"`
+	ConsecutiveFailures int  `json:"consecutive_failures"`
+}
+
+// ReaperState is the reaper.Status of this replica.
+type ReaperState struct {
+	reaper.Status
+	Error string `json:"error,omitempty"`
+}
+
+// Queues counts work waiting to be done. Journal is omitted unless the
+// webhook journal is enabled.
+type Queues struct {
+	Journal          *int `json:"journal,omitempty"`
+	Quarantined      int  `json:"quarantined"`
+	PendingDeletions int  `json:"pending_deletions"`
+	// ReapBacklog is the number of expired images the last reap cycle
+	// postponed to the next one.
+	ReapBacklog int `json:"reap_backlog"`
+}
+
+// RegistryHealth reports the health of the registry.
+type RegistryHealth interface {
+	IsHealthy() bool
+	ConsecutiveFailures() int
+}
+
+// ReaperStatus reports the state of the reaper.
+type ReaperStatus interface {
+	Status(ctx context.Context) (reaper.Status, error)
+}
+
+// Service builds status reports.
+type Service struct {
+	version    string
+	configHash string
+	store      redisclient.Store
+	registry   RegistryHealth
+	reaper     ReaperStatus
+	journal    *journal.Journal
+	logger     *slog.Logger
+}
+
+// Option configures a Service.
+type Option func(*Service)
+
+// WithJournal includes the pending entries of the webhook journal j.
+func WithJournal(j *journal.Journal) Option {
+	return func(s *Service) {
+		s.journal = j
+	}
+}
+
+// New returns a Service reporting on store, registry and reaper, labelled
+// with the running version and the hash of the active configuration.
+func New(
+	version, configHash string,
+	store redisclient.Store,
+	registry RegistryHealth,
+	reaper ReaperStatus,
+	logger *slog.Logger,
+	opts ...Option,
+) *Service {
+	s := &Service{
+		version:    version,
+		configHash: configHash,
+		store:      store,
+		registry:   registry,
+		reaper:     reaper,
+		logger:     logger,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Report collects the current status.
+func (s *Service) Report(ctx context.Context) Report {
+	rep := Report{
+		Version:    s.version,
+		ConfigHash: s.configHash,
+		Registry: RegistryState{
+			Healthy:             s.registry.IsHealthy(),
+			ConsecutiveFailures: s.registry.ConsecutiveFailures(),
+		},
+	}
+	rep.Store = s.storeStatus(ctx)
+
+	if st, err := s.reaper.Status(ctx); err != nil {
+		rep.Reaper.Error = err.Error()
+	} else {
+		rep.Reaper.Status = st
+		if st.LastCycle != nil {
+			rep.Queues.ReapBacklog = st.LastCycle.Postponed
+		}
+	}
+
+	if s.journal != nil {
+		if pending, err := s.journal.Pending(); err != nil {
+			s.logger.Warn("failed to read journal", "error", err)
+		} else {
+			n := len(pending)
+			rep.Queues.Journal = &n
+		}
+	}
+	if rep.Store.Healthy {
+		s.countQueues(ctx, &rep.Queues)
+	}
+	return rep
+}
+
+// storeStatus checks that the store is reachable and healthy.
+func (s *Service) storeStatus(ctx context.Context) StoreStatus {
+	if err := s.store.Ping(ctx); err != nil {
+		return StoreStatus{Error: err.Error()}
+	}
+	st := StoreStatus{Healthy: true}
+	degraded, err := s.store.Degraded(ctx)
+	if err != nil {
+		return StoreStatus{Error: err.Error()}
+	}
+	st.Degraded = degraded
+	if st.TrackedImages, err = s.store.ImageCount(ctx); err != nil {
+		st.Error = err.Error()
+	}
+	return st
+}
+
+// countQueues fills in the queues kept in the store.
+func (s *Service) countQueues(ctx context.Context, q *Queues) {
+	if quarantined, err := s.store.ListQuarantined(ctx); err != nil {
+		s.logger.Warn("failed to list quarantined images", "error", err)
+	} else {
+		q.Quarantined = len(quarantined)
+	}
+	if deletions, err := s.store.ListDeletions(ctx); err != nil {
+		s.logger.Warn("failed to list deletion requests", "error", err)
+	} else {
+		for _, d := range deletions {
+			if d.Status == redisclient.DeletionPending || d.Status == redisclient.DeletionApproved {
+				q.PendingDeletions++
+			}
+		}
+	}
+}
+
+// Handler serves the Report as JSON.
+func (s *Service) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s.Report(req.Context()))
+	})
+}
@@ -0,0 +1,103 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/journal"
+	"github.com/tamcore/ephemeron/internal/memstore"
+	"github.com/tamcore/ephemeron/internal/reaper"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+type fakeHealth struct{ failures int }
+
+func (h fakeHealth) IsHealthy() bool          { return h.failures < 3 }
+func (h fakeHealth) ConsecutiveFailures() int { return h.failures }
+
+type fakeReaper struct {
+	status reaper.Status
+	err    error
+}
+
+func (r fakeReaper) Status(context.Context) (reaper.Status, error) { return r.status, r.err }
+
+func TestHandler(t *testing.T) {
+	ctx := t.Context()
+	store := memstore.New()
+	meta := redisclient.ImageMeta{}
+	if err := store.TrackImage(ctx, "app:1h", time.Now().Add(time.Hour), 1, "", meta); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.QuarantineImage(ctx, redisclient.QuarantinedImage{Image: "old:1h"}); err != nil {
+		t.Fatal(err)
+	}
+	for id, st := range map[string]string{"a": redisclient.DeletionPending, "b": redisclient.DeletionExecuted} {
+		if err := store.PutDeletion(ctx, redisclient.DeletionRequest{ID: id, Image: "app:1h", Status: st}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j, err := journal.Open(filepath.Join(t.TempDir(), "journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Append(journal.Entry{Image: "app:2h"}); err != nil {
+		t.Fatal(err)
+	}
+	rp := fakeReaper{status: reaper.Status{
+		State:     reaper.StateIdle,
+		LastCycle: &reaper.Cycle{Result: reaper.Result{Reaped: 2, Postponed: 5}},
+	}}
+
+	s := New("v1.2.3", "abc123", store, fakeHealth{failures: 1}, rp, slog.Default(), WithJournal(j))
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/api/status", nil))
+
+	var got Report
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding report: %v", err)
+	}
+	if got.Version != "v1.2.3" || got.ConfigHash != "abc123" {
+		t.Errorf("version/config = %q/%q", got.Version, got.ConfigHash)
+	}
+	if !got.Store.Healthy || got.Store.TrackedImages != 1 || got.Store.Error != "" {
+		t.Errorf("store = %+v", got.Store)
+	}
+	if !got.Registry.Healthy || got.Registry.ConsecutiveFailures != 1 {
+		t.Errorf("registry = %+v", got.Registry)
+	}
+	if got.Reaper.State != reaper.StateIdle || got.Reaper.LastCycle == nil || got.Reaper.LastCycle.Reaped != 2 {
+		t.Errorf("reaper = %+v", got.Reaper)
+	}
+	want := Queues{Quarantined: 1, PendingDeletions: 1, ReapBacklog: 5}
+	if got.Queues.Journal == nil || *got.Queues.Journal != 1 {
+		t.Errorf("journal queue = %v, want 1", got.Queues.Journal)
+	}
+	got.Queues.Journal = nil
+	if got.Queues != want {
+		t.Errorf("queues = %+v, want %+v", got.Queues, want)
+	}
+}
+
+func TestReport_PartialFailure(t *testing.T) {
+	rp := fakeReaper{err: errors.New("store unreachable")}
+	s := New("dev", "", memstore.New(), fakeHealth{failures: 3}, rp, slog.Default())
+
+	got := s.Report(t.Context())
+	if got.Reaper.Error != "store unreachable" {
+		t.Errorf("reaper error = %q", got.Reaper.Error)
+	}
+	if got.Registry.Healthy {
+		t.Error("expected unhealthy registry")
+	}
+	if got.Queues.Journal != nil {
+		t.Errorf("journal queue = %v without a journal", *got.Queues.Journal)
+	}
+}
@@ -0,0 +1,905 @@
+// Package storetest provides a conformance suite that every Store
+// implementation must pass.
+package storetest
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// Factory returns a new, empty store for a single subtest. Implementations
+// should register any cleanup with t.Cleanup.
+type Factory func(t *testing.T) redisclient.Store
+
+// Run exercises the Store contract against stores produced by factory.
+func Run(t *testing.T, factory Factory) {
+	t.Run("TrackImage", func(t *testing.T) { testTrackImage(t, factory(t)) })
+	t.Run("Retrack", func(t *testing.T) { testRetrack(t, factory(t)) })
+	t.Run("SetExpiry", func(t *testing.T) { testSetExpiry(t, factory(t)) })
+	t.Run("SetManifestInfo", func(t *testing.T) { testSetManifestInfo(t, factory(t)) })
+	t.Run("SetImageSize", func(t *testing.T) { testSetImageSize(t, factory(t)) })
+	t.Run("Aliases", func(t *testing.T) { testAliases(t, factory(t)) })
+	t.Run("MissingImage", func(t *testing.T) { testMissingImage(t, factory(t)) })
+	t.Run("RemoveImage", func(t *testing.T) { testRemoveImage(t, factory(t)) })
+	t.Run("ReaperLock", func(t *testing.T) { testReaperLock(t, factory(t)) })
+	t.Run("Initialized", func(t *testing.T) { testInitialized(t, factory(t)) })
+	t.Run("ReapTotals", func(t *testing.T) { testReapTotals(t, factory(t)) })
+	t.Run("RepoStats", func(t *testing.T) { testRepoStats(t, factory(t)) })
+	t.Run("DailyUsage", func(t *testing.T) { testDailyUsage(t, factory(t)) })
+	t.Run("Superseded", func(t *testing.T) { testSuperseded(t, factory(t)) })
+	t.Run("Quarantine", func(t *testing.T) { testQuarantine(t, factory(t)) })
+	t.Run("Protected", func(t *testing.T) { testProtected(t, factory(t)) })
+	t.Run("Priority", func(t *testing.T) { testPriority(t, factory(t)) })
+	t.Run("Freezes", func(t *testing.T) { testFreezes(t, factory(t)) })
+	t.Run("Rules", func(t *testing.T) { testRules(t, factory(t)) })
+	t.Run("Deletions", func(t *testing.T) { testDeletions(t, factory(t)) })
+	t.Run("APITokens", func(t *testing.T) { testAPITokens(t, factory(t)) })
+	t.Run("Tombstones", func(t *testing.T) { testTombstones(t, factory(t)) })
+	t.Run("ReconcileProgress", func(t *testing.T) { testReconcileProgress(t, factory(t)) })
+	t.Run("ReconcileCursor", func(t *testing.T) { testReconcileCursor(t, factory(t)) })
+}
+
+func testTrackImage(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+	expires := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	before := time.Now().UnixMilli()
+	meta := redisclient.ImageMeta{
+		Actor:      "ci-bot",
+		SourceAddr: "10.0.0.1:5000",
+		UserAgent:  "docker/27.0",
+		Source:     "mirror-1",
+	}
+
+	if err := s.TrackImage(ctx, "app:1h", expires, 1024, "sha256:abc", meta); err != nil {
+		t.Fatalf("TrackImage: %v", err)
+	}
+
+	images, err := s.ListImages(ctx)
+	if err != nil {
+		t.Fatalf("ListImages: %v", err)
+	}
+	if !slices.Equal(images, []string{"app:1h"}) {
+		t.Errorf("ListImages = %v, want [app:1h]", images)
+	}
+	if n, err := s.ImageCount(ctx); err != nil || n != 1 {
+		t.Errorf("ImageCount = %d, %v; want 1, nil", n, err)
+	}
+	if got, err := s.TrackedBytes(ctx); err != nil || got != 1024 {
+		t.Errorf("TrackedBytes = %d, %v; want 1024, nil", got, err)
+	}
+	if got, err := s.GetExpiry(ctx, "app:1h"); err != nil || got != expires.UnixMilli() {
+		t.Errorf("GetExpiry = %d, %v; want %d, nil", got, err, expires.UnixMilli())
+	}
+	if got, err := s.GetImageSize(ctx, "app:1h"); err != nil || got != 1024 {
+		t.Errorf("GetImageSize = %d, %v; want 1024, nil", got, err)
+	}
+	if got, err := s.GetImageDigest(ctx, "app:1h"); err != nil || got != "sha256:abc" {
+		t.Errorf("GetImageDigest = %q, %v; want sha256:abc, nil", got, err)
+	}
+	if got, err := s.GetImageMeta(ctx, "app:1h"); err != nil || got != meta {
+		t.Errorf("GetImageMeta = %+v, %v; want %+v, nil", got, err, meta)
+	}
+	created, err := s.GetCreatedTimestamp(ctx, "app:1h")
+	if err != nil {
+		t.Fatalf("GetCreatedTimestamp: %v", err)
+	}
+	if created < before || created > time.Now().UnixMilli() {
+		t.Errorf("GetCreatedTimestamp = %d, want between %d and now", created, before)
+	}
+
+	record, err := s.GetImageRecord(ctx, "app:1h")
+	if err != nil {
+		t.Fatalf("GetImageRecord: %v", err)
+	}
+	want := map[string]string{
+		"created":     strconv.FormatInt(created, 10),
+		"expires":     strconv.FormatInt(expires.UnixMilli(), 10),
+		"size_bytes":  "1024",
+		"digest":      "sha256:abc",
+		"actor":       "ci-bot",
+		"source_addr": "10.0.0.1:5000",
+		"user_agent":  "docker/27.0",
+		"source":      "mirror-1",
+	}
+	for field, v := range want {
+		if record[field] != v {
+			t.Errorf("GetImageRecord()[%q] = %q, want %q", field, record[field], v)
+		}
+	}
+}
+
+func testRetrack(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+	first := time.Now().Add(time.Hour)
+	second := time.Now().Add(2 * time.Hour).Truncate(time.Millisecond)
+
+	if err := s.TrackImage(ctx, "app:1h", first, 1, "sha256:old", redisclient.ImageMeta{Actor: "old"}); err != nil {
+		t.Fatalf("TrackImage: %v", err)
+	}
+	if err := s.TrackImage(ctx, "app:1h", second, 2, "sha256:new", redisclient.ImageMeta{}); err != nil {
+		t.Fatalf("TrackImage: %v", err)
+	}
+
+	if n, _ := s.ImageCount(ctx); n != 1 {
+		t.Errorf("ImageCount = %d after re-track, want 1", n)
+	}
+	if got, _ := s.GetExpiry(ctx, "app:1h"); got != second.UnixMilli() {
+		t.Errorf("GetExpiry = %d, want %d", got, second.UnixMilli())
+	}
+	if got, _ := s.GetImageDigest(ctx, "app:1h"); got != "sha256:new" {
+		t.Errorf("GetImageDigest = %q, want sha256:new", got)
+	}
+	if got, _ := s.GetImageMeta(ctx, "app:1h"); got != (redisclient.ImageMeta{}) {
+		t.Errorf("GetImageMeta = %+v after re-track, want empty", got)
+	}
+	if got, err := s.TrackedBytes(ctx); err != nil || got != 2 {
+		t.Errorf("TrackedBytes = %d, %v after re-track; want 2, nil", got, err)
+	}
+}
+
+func testSetExpiry(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+	later := time.Now().Add(3 * time.Hour).Truncate(time.Millisecond)
+
+	if ok, err := s.SetExpiry(ctx, "missing:1h", later); err != nil || ok {
+		t.Errorf("SetExpiry(untracked) = %v, %v; want false, nil", ok, err)
+	}
+	if n, _ := s.ImageCount(ctx); n != 0 {
+		t.Errorf("ImageCount = %d after SetExpiry of untracked image, want 0", n)
+	}
+
+	expires := time.Now().Add(time.Hour)
+	if err := s.TrackImage(ctx, "app:1h", expires, 7, "sha256:abc", redisclient.ImageMeta{}); err != nil {
+		t.Fatalf("TrackImage: %v", err)
+	}
+	if ok, err := s.SetExpiry(ctx, "app:1h", later); err != nil || !ok {
+		t.Fatalf("SetExpiry = %v, %v; want true, nil", ok, err)
+	}
+	if got, _ := s.GetExpiry(ctx, "app:1h"); got != later.UnixMilli() {
+		t.Errorf("GetExpiry = %d, want %d", got, later.UnixMilli())
+	}
+	if got, _ := s.GetImageDigest(ctx, "app:1h"); got != "sha256:abc" {
+		t.Errorf("GetImageDigest = %q after SetExpiry, want sha256:abc", got)
+	}
+	if got, _ := s.GetImageSize(ctx, "app:1h"); got != 7 {
+		t.Errorf("GetImageSize = %d after SetExpiry, want 7", got)
+	}
+
+	updated, err := s.SetExpiries(ctx, map[string]time.Time{"app:1h": expires, "missing:1h": later})
+	if err != nil {
+		t.Fatalf("SetExpiries: %v", err)
+	}
+	if len(updated) != 1 || updated[0] != "app:1h" {
+		t.Errorf("SetExpiries updated %v, want [app:1h]", updated)
+	}
+	if got, _ := s.GetExpiry(ctx, "app:1h"); got != expires.UnixMilli() {
+		t.Errorf("GetExpiry = %d after SetExpiries, want %d", got, expires.UnixMilli())
+	}
+	if n, _ := s.ImageCount(ctx); n != 1 {
+		t.Errorf("ImageCount = %d after SetExpiries of untracked image, want 1", n)
+	}
+}
+
+func testSetImageSize(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+	expires := time.Now().Add(time.Hour)
+	if err := s.TrackImage(ctx, "app:1h", expires, 0, "sha256:abc", redisclient.ImageMeta{}); err != nil {
+		t.Fatalf("TrackImage: %v", err)
+	}
+	if err := s.TrackImage(ctx, "app:2h", expires, 5, "", redisclient.ImageMeta{}); err != nil {
+		t.Fatalf("TrackImage: %v", err)
+	}
+
+	if ok, err := s.SetImageSize(ctx, "missing:1h", "", 7); err != nil || ok {
+		t.Errorf("SetImageSize(untracked) = %v, %v; want false, nil", ok, err)
+	}
+	if ok, err := s.SetImageSize(ctx, "app:1h", "sha256:def", 7); err != nil || ok {
+		t.Errorf("SetImageSize(other digest) = %v, %v; want false, nil", ok, err)
+	}
+	if ok, err := s.SetImageSize(ctx, "app:1h", "sha256:abc", 7); err != nil || !ok {
+		t.Errorf("SetImageSize = %v, %v; want true, nil", ok, err)
+	}
+
+	got, err := s.GetManifestRecords(ctx, []string{"app:1h", "app:2h", "missing:1h"})
+	if err != nil {
+		t.Fatalf("GetManifestRecords: %v", err)
+	}
+	want := map[string]redisclient.ManifestRecord{
+		"app:1h": {SizeBytes: 7, Digest: "sha256:abc"},
+		"app:2h": {SizeBytes: 5},
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("GetManifestRecords = %v, want %v", got, want)
+	}
+}
+
+func testSetManifestInfo(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+
+	if ok, err := s.SetManifestInfo(ctx, "missing:1h", 7, "sha256:abc"); err != nil || ok {
+		t.Errorf("SetManifestInfo(untracked) = %v, %v; want false, nil", ok, err)
+	}
+	if n, _ := s.ImageCount(ctx); n != 0 {
+		t.Errorf("ImageCount = %d after SetManifestInfo of untracked image, want 0", n)
+	}
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	for _, image := range []string{"app:1h", "app:latest"} {
+		if err := s.TrackImage(ctx, image, expires, 0, "", redisclient.ImageMeta{Actor: "alice"}); err != nil {
+			t.Fatalf("TrackImage(%s): %v", image, err)
+		}
+	}
+	if err := s.SetProtected(ctx, "app:1h", true); err != nil {
+		t.Fatalf("SetProtected: %v", err)
+	}
+	for _, image := range []string{"app:1h", "app:latest"} {
+		if ok, err := s.SetManifestInfo(ctx, image, 7, "sha256:abc"); err != nil || !ok {
+			t.Fatalf("SetManifestInfo(%s) = %v, %v; want true, nil", image, ok, err)
+		}
+	}
+	if got, _ := s.GetImageSize(ctx, "app:1h"); got != 7 {
+		t.Errorf("GetImageSize = %d, want 7", got)
+	}
+	if got, _ := s.GetImageDigest(ctx, "app:1h"); got != "sha256:abc" {
+		t.Errorf("GetImageDigest = %q, want sha256:abc", got)
+	}
+	if got, _ := s.Aliases(ctx, "app:1h"); !slices.Equal(got, []string{"app:latest"}) {
+		t.Errorf("Aliases = %v, want [app:latest]", got)
+	}
+	if got, _ := s.TrackedBytes(ctx); got != 7 {
+		t.Errorf("TrackedBytes = %d, want 7 with the shared manifest counted once", got)
+	}
+	if got, _ := s.GetExpiry(ctx, "app:1h"); got != expires.UnixMilli() {
+		t.Errorf("GetExpiry = %d after SetManifestInfo, want %d", got, expires.UnixMilli())
+	}
+	if got, _ := s.IsProtected(ctx, "app:1h"); !got {
+		t.Error("SetManifestInfo cleared the protection mark")
+	}
+	if meta, _ := s.GetImageMeta(ctx, "app:1h"); meta.Actor != "alice" {
+		t.Errorf("GetImageMeta = %+v after SetManifestInfo, want actor alice", meta)
+	}
+
+	if _, err := s.SetManifestInfo(ctx, "app:latest", 9, "sha256:def"); err != nil {
+		t.Fatalf("SetManifestInfo: %v", err)
+	}
+	if got, _ := s.Aliases(ctx, "app:1h"); len(got) != 0 {
+		t.Errorf("Aliases = %v after the digest changed, want none", got)
+	}
+}
+
+func testAliases(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+	expires := time.Now().Add(time.Hour)
+	track := func(image string, size int64, digest string) {
+		t.Helper()
+		if err := s.TrackImage(ctx, image, expires, size, digest, redisclient.ImageMeta{}); err != nil {
+			t.Fatalf("TrackImage(%s): %v", image, err)
+		}
+	}
+	aliases := func(image string) []string {
+		t.Helper()
+		got, err := s.Aliases(ctx, image)
+		if err != nil {
+			t.Fatalf("Aliases(%s): %v", image, err)
+		}
+		slices.Sort(got)
+		return got
+	}
+
+	track("app:1h", 100, "sha256:abc")
+	track("app:latest", 100, "sha256:abc")
+	track("app:2h", 100, "sha256:abc")
+	track("other:1h", 50, "sha256:abc")
+	track("app:nodigest", 10, "")
+
+	if got := aliases("app:latest"); !slices.Equal(got, []string{"app:1h", "app:2h"}) {
+		t.Errorf("Aliases(app:latest) = %v, want [app:1h app:2h]", got)
+	}
+	if got := aliases("other:1h"); len(got) != 0 {
+		t.Errorf("Aliases(other:1h) = %v, want none across repositories", got)
+	}
+	if got := aliases("app:nodigest"); len(got) != 0 {
+		t.Errorf("Aliases(app:nodigest) = %v, want none", got)
+	}
+	if got, err := s.TrackedBytes(ctx); err != nil || got != 160 {
+		t.Errorf("TrackedBytes = %d, %v; want 160 with shared manifests counted once", got, err)
+	}
+
+	// Re-pushing with new content and removing both drop the alias.
+	track("app:2h", 100, "sha256:def")
+	if err := s.RemoveImage(ctx, "app:1h"); err != nil {
+		t.Fatalf("RemoveImage: %v", err)
+	}
+	if got := aliases("app:latest"); len(got) != 0 {
+		t.Errorf("Aliases(app:latest) = %v after re-push and removal, want none", got)
+	}
+	tagged, err := s.TaggedImages(ctx, "app", "sha256:abc")
+	if err != nil || !slices.Equal(tagged, []string{"app:latest"}) {
+		t.Errorf("TaggedImages(app, sha256:abc) = %v, %v; want [app:latest]", tagged, err)
+	}
+	if tagged, _ := s.TaggedImages(ctx, "app", "sha256:none"); len(tagged) != 0 {
+		t.Errorf("TaggedImages of an untracked digest = %v, want none", tagged)
+	}
+}
+
+func testMissingImage(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+
+	if _, err := s.GetExpiry(ctx, "missing:1h"); err == nil {
+		t.Error("GetExpiry of untracked image should return an error")
+	}
+	if got, err := s.GetImageSize(ctx, "missing:1h"); err != nil || got != 0 {
+		t.Errorf("GetImageSize = %d, %v; want 0, nil", got, err)
+	}
+	if got, err := s.GetImageDigest(ctx, "missing:1h"); err != nil || got != "" {
+		t.Errorf("GetImageDigest = %q, %v; want empty, nil", got, err)
+	}
+	if got, err := s.GetImageMeta(ctx, "missing:1h"); err != nil || got != (redisclient.ImageMeta{}) {
+		t.Errorf("GetImageMeta = %+v, %v; want empty, nil", got, err)
+	}
+	if got, err := s.GetCreatedTimestamp(ctx, "missing:1h"); err != nil || got != 0 {
+		t.Errorf("GetCreatedTimestamp = %d, %v; want 0, nil", got, err)
+	}
+	if got, err := s.GetImageRecord(ctx, "missing:1h"); err != nil || len(got) != 0 {
+		t.Errorf("GetImageRecord = %v, %v; want empty, nil", got, err)
+	}
+	if err := s.RemoveImage(ctx, "missing:1h"); err != nil {
+		t.Errorf("RemoveImage of untracked image: %v", err)
+	}
+}
+
+func testRemoveImage(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+	expires := time.Now().Add(time.Hour)
+
+	for _, image := range []string{"a:1h", "b:1h"} {
+		if err := s.TrackImage(ctx, image, expires, 0, "", redisclient.ImageMeta{}); err != nil {
+			t.Fatalf("TrackImage: %v", err)
+		}
+	}
+	if err := s.RemoveImage(ctx, "a:1h"); err != nil {
+		t.Fatalf("RemoveImage: %v", err)
+	}
+
+	images, _ := s.ListImages(ctx)
+	if !slices.Equal(images, []string{"b:1h"}) {
+		t.Errorf("ListImages = %v, want [b:1h]", images)
+	}
+	if _, err := s.GetExpiry(ctx, "a:1h"); err == nil {
+		t.Error("GetExpiry of removed image should return an error")
+	}
+}
+
+func testReaperLock(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+
+	first, err := s.AcquireReaperLock(ctx, time.Minute)
+	if err != nil || first <= 0 {
+		t.Fatalf("first AcquireReaperLock = %d, %v; want positive token, nil", first, err)
+	}
+	if token, err := s.AcquireReaperLock(ctx, time.Minute); err != nil || token != 0 {
+		t.Fatalf("second AcquireReaperLock = %d, %v; want 0, nil", token, err)
+	}
+	if ok, err := s.RenewReaperLock(ctx, first, time.Minute); err != nil || !ok {
+		t.Fatalf("RenewReaperLock by holder = %v, %v; want true, nil", ok, err)
+	}
+	if ok, err := s.RenewReaperLock(ctx, first+1000, time.Minute); err != nil || ok {
+		t.Fatalf("RenewReaperLock with foreign token = %v, %v; want false, nil", ok, err)
+	}
+	if err := s.ReleaseReaperLock(ctx, first+1000); err != nil {
+		t.Fatalf("ReleaseReaperLock with foreign token: %v", err)
+	}
+	if token, _ := s.AcquireReaperLock(ctx, time.Minute); token != 0 {
+		t.Fatal("release with a foreign token must not free the lock")
+	}
+	if err := s.ReleaseReaperLock(ctx, first); err != nil {
+		t.Fatalf("ReleaseReaperLock: %v", err)
+	}
+
+	second, err := s.AcquireReaperLock(ctx, 100*time.Millisecond)
+	if err != nil || second <= first {
+		t.Fatalf("AcquireReaperLock after release = %d, %v; want token > %d", second, err, first)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if ok, _ := s.RenewReaperLock(ctx, second, time.Minute); ok {
+		t.Fatal("RenewReaperLock after expiry should fail")
+	}
+	third, err := s.AcquireReaperLock(ctx, time.Minute)
+	if err != nil || third <= second {
+		t.Fatalf("AcquireReaperLock after expiry = %d, %v; want token > %d", third, err, second)
+	}
+	if err := s.ReleaseReaperLock(ctx, second); err != nil {
+		t.Fatalf("ReleaseReaperLock with stale token: %v", err)
+	}
+	if token, _ := s.AcquireReaperLock(ctx, time.Minute); token != 0 {
+		t.Fatal("release with a stale token must not free a taken-over lock")
+	}
+}
+
+func testInitialized(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+
+	if ok, err := s.IsInitialized(ctx); err != nil || ok {
+		t.Fatalf("IsInitialized = %v, %v; want false, nil", ok, err)
+	}
+	if err := s.SetInitialized(ctx); err != nil {
+		t.Fatalf("SetInitialized: %v", err)
+	}
+	if ok, err := s.IsInitialized(ctx); err != nil || !ok {
+		t.Fatalf("IsInitialized = %v, %v; want true, nil", ok, err)
+	}
+
+	if v, err := s.SchemaVersion(ctx); err != nil || v != 0 {
+		t.Fatalf("SchemaVersion = %d, %v; want 0, nil", v, err)
+	}
+	if err := s.SetSchemaVersion(ctx, redisclient.CurrentSchema); err != nil {
+		t.Fatalf("SetSchemaVersion: %v", err)
+	}
+	if v, err := s.SchemaVersion(ctx); err != nil || v != redisclient.CurrentSchema {
+		t.Fatalf("SchemaVersion = %d, %v; want %d, nil", v, err, redisclient.CurrentSchema)
+	}
+}
+
+func testReapTotals(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+
+	if images, bytes, err := s.ReapTotals(ctx); err != nil || images != 0 || bytes != 0 {
+		t.Fatalf("ReapTotals = %d, %d, %v; want 0, 0, nil", images, bytes, err)
+	}
+	for _, size := range []int64{100, 250} {
+		if err := s.RecordReap(ctx, size); err != nil {
+			t.Fatalf("RecordReap: %v", err)
+		}
+	}
+	if images, bytes, err := s.ReapTotals(ctx); err != nil || images != 2 || bytes != 350 {
+		t.Fatalf("ReapTotals = %d, %d, %v; want 2, 350, nil", images, bytes, err)
+	}
+}
+
+func testQuarantine(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+	expires := time.Now().Add(-time.Minute)
+
+	if n, err := s.RecordDeleteFailure(ctx, "untracked:1h"); err != nil || n != 0 {
+		t.Errorf("RecordDeleteFailure(untracked) = %d, %v; want 0, nil", n, err)
+	}
+	if err := s.TrackImage(ctx, "app:1h", expires, 1, "", redisclient.ImageMeta{}); err != nil {
+		t.Fatalf("TrackImage: %v", err)
+	}
+	for want := int64(1); want <= 2; want++ {
+		if n, err := s.RecordDeleteFailure(ctx, "app:1h"); err != nil || n != want {
+			t.Fatalf("RecordDeleteFailure = %d, %v; want %d, nil", n, err, want)
+		}
+	}
+
+	q := redisclient.QuarantinedImage{
+		Image:    "app:1h",
+		Reason:   "DELETE manifest returned 405",
+		Failures: 2,
+		Since:    time.Now().Truncate(time.Millisecond).UTC(),
+	}
+	if err := s.QuarantineImage(ctx, q); err != nil {
+		t.Fatalf("QuarantineImage: %v", err)
+	}
+	got, err := s.ListQuarantined(ctx)
+	if err != nil || len(got) != 1 || !got[0].Since.Equal(q.Since) || got[0].Reason != q.Reason {
+		t.Fatalf("ListQuarantined = %+v, %v; want [%+v]", got, err, q)
+	}
+
+	if err := s.ReleaseQuarantined(ctx, "app:1h"); err != nil {
+		t.Fatalf("ReleaseQuarantined: %v", err)
+	}
+	if got, _ := s.ListQuarantined(ctx); len(got) != 0 {
+		t.Errorf("ListQuarantined after release = %+v, want empty", got)
+	}
+	if n, _ := s.RecordDeleteFailure(ctx, "app:1h"); n != 1 {
+		t.Errorf("RecordDeleteFailure after release = %d, want 1", n)
+	}
+
+	// Re-pushing and removing an image both lift its quarantine.
+	if err := s.QuarantineImage(ctx, q); err != nil {
+		t.Fatalf("QuarantineImage: %v", err)
+	}
+	if err := s.TrackImage(ctx, "app:1h", expires, 1, "", redisclient.ImageMeta{}); err != nil {
+		t.Fatalf("TrackImage: %v", err)
+	}
+	if got, _ := s.ListQuarantined(ctx); len(got) != 0 {
+		t.Errorf("ListQuarantined after re-push = %+v, want empty", got)
+	}
+	if err := s.QuarantineImage(ctx, q); err != nil {
+		t.Fatalf("QuarantineImage: %v", err)
+	}
+	if err := s.RemoveImage(ctx, "app:1h"); err != nil {
+		t.Fatalf("RemoveImage: %v", err)
+	}
+	if got, _ := s.ListQuarantined(ctx); len(got) != 0 {
+		t.Errorf("ListQuarantined after removal = %+v, want empty", got)
+	}
+}
+
+func testProtected(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+	expires := time.Now().Add(time.Hour)
+
+	if err := s.SetProtected(ctx, "missing:1h", true); err != nil {
+		t.Fatalf("SetProtected(untracked): %v", err)
+	}
+	if n, _ := s.ImageCount(ctx); n != 0 {
+		t.Errorf("ImageCount = %d after SetProtected of untracked image, want 0", n)
+	}
+
+	if err := s.TrackImage(ctx, "app:1h", expires, 7, "sha256:abc", redisclient.ImageMeta{}); err != nil {
+		t.Fatalf("TrackImage: %v", err)
+	}
+	if ok, err := s.IsProtected(ctx, "app:1h"); err != nil || ok {
+		t.Fatalf("IsProtected = %v, %v before SetProtected; want false, nil", ok, err)
+	}
+	if err := s.SetProtected(ctx, "app:1h", true); err != nil {
+		t.Fatalf("SetProtected: %v", err)
+	}
+	if ok, _ := s.IsProtected(ctx, "app:1h"); !ok {
+		t.Error("IsProtected = false after SetProtected(true)")
+	}
+	if err := s.SetProtected(ctx, "app:1h", false); err != nil {
+		t.Fatalf("SetProtected: %v", err)
+	}
+	if ok, _ := s.IsProtected(ctx, "app:1h"); ok {
+		t.Error("IsProtected = true after SetProtected(false)")
+	}
+
+	_ = s.SetProtected(ctx, "app:1h", true)
+	if err := s.TrackImage(ctx, "app:1h", expires, 7, "sha256:def", redisclient.ImageMeta{}); err != nil {
+		t.Fatalf("TrackImage: %v", err)
+	}
+	if ok, _ := s.IsProtected(ctx, "app:1h"); ok {
+		t.Error("re-tracking should clear protection")
+	}
+}
+
+func testPriority(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+	expires := time.Now().Add(time.Hour)
+
+	if err := s.SetPriority(ctx, "missing:1h", 5); err != nil {
+		t.Fatalf("SetPriority(untracked): %v", err)
+	}
+	if n, _ := s.ImageCount(ctx); n != 0 {
+		t.Errorf("ImageCount = %d after SetPriority of untracked image, want 0", n)
+	}
+
+	if err := s.TrackImage(ctx, "app:1h", expires, 7, "sha256:abc", redisclient.ImageMeta{}); err != nil {
+		t.Fatalf("TrackImage: %v", err)
+	}
+	if p, err := s.GetPriority(ctx, "app:1h"); err != nil || p != 0 {
+		t.Fatalf("GetPriority = %d, %v before SetPriority; want 0, nil", p, err)
+	}
+	if err := s.SetPriority(ctx, "app:1h", -3); err != nil {
+		t.Fatalf("SetPriority: %v", err)
+	}
+	if p, _ := s.GetPriority(ctx, "app:1h"); p != -3 {
+		t.Errorf("GetPriority = %d after SetPriority(-3)", p)
+	}
+
+	_ = s.SetPriority(ctx, "app:1h", 5)
+	if err := s.TrackImage(ctx, "app:1h", expires, 7, "sha256:def", redisclient.ImageMeta{}); err != nil {
+		t.Fatalf("TrackImage: %v", err)
+	}
+	if p, _ := s.GetPriority(ctx, "app:1h"); p != 0 {
+		t.Errorf("GetPriority = %d after re-tracking, want 0", p)
+	}
+}
+
+func testFreezes(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+	now := time.Now().UTC().Truncate(time.Second)
+	active := redisclient.Freeze{Pattern: "team/*", Reason: "audit", Since: now, Until: now.Add(time.Hour)}
+
+	if err := s.SetFreeze(ctx, active); err != nil {
+		t.Fatalf("SetFreeze: %v", err)
+	}
+	if err := s.SetFreeze(ctx, redisclient.Freeze{Since: now.Add(-time.Hour), Until: now}); err != nil {
+		t.Fatalf("SetFreeze: %v", err)
+	}
+	list, err := s.ListFreezes(ctx)
+	if err != nil {
+		t.Fatalf("ListFreezes: %v", err)
+	}
+	if len(list) != 1 || !list[0].Until.Equal(active.Until) || list[0].Reason != "audit" {
+		t.Fatalf("ListFreezes = %+v, want only the active freeze", list)
+	}
+
+	if err := s.Unfreeze(ctx, "team/*"); err != nil {
+		t.Fatalf("Unfreeze: %v", err)
+	}
+	if list, _ := s.ListFreezes(ctx); len(list) != 0 {
+		t.Errorf("ListFreezes = %+v after Unfreeze, want none", list)
+	}
+}
+
+func testRules(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+	policy := redisclient.Rule{Pattern: "team/*", DefaultTTL: time.Hour, MaxTTL: 24 * time.Hour,
+		CreatedAt: time.Now().UTC().Truncate(time.Second)}
+
+	if err := s.PutRule(ctx, redisclient.RuleRepoPolicy, policy); err != nil {
+		t.Fatalf("PutRule: %v", err)
+	}
+	if err := s.PutRule(ctx, redisclient.RuleProtected, redisclient.Rule{Pattern: "app:stable"}); err != nil {
+		t.Fatalf("PutRule: %v", err)
+	}
+	list, err := s.ListRules(ctx, redisclient.RuleRepoPolicy)
+	if err != nil {
+		t.Fatalf("ListRules: %v", err)
+	}
+	if len(list) != 1 || list[0].Pattern != policy.Pattern || list[0].MaxTTL != policy.MaxTTL ||
+		!list[0].CreatedAt.Equal(policy.CreatedAt) {
+		t.Fatalf("ListRules = %+v, want [%+v]", list, policy)
+	}
+	if list, _ := s.ListRules(ctx, redisclient.RuleImmutableTag); len(list) != 0 {
+		t.Errorf("ListRules(immutable) = %+v, want none", list)
+	}
+
+	if found, err := s.DeleteRule(ctx, redisclient.RuleRepoPolicy, "team/*"); err != nil || !found {
+		t.Fatalf("DeleteRule = %v, %v; want true, nil", found, err)
+	}
+	if found, _ := s.DeleteRule(ctx, redisclient.RuleRepoPolicy, "team/*"); found {
+		t.Error("DeleteRule of a missing rule reported it found")
+	}
+	if list, _ := s.ListRules(ctx, redisclient.RuleProtected); len(list) != 1 {
+		t.Errorf("ListRules(protected) = %+v, want the rule of the other kind kept", list)
+	}
+}
+
+func testDeletions(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+	now := time.Now().UTC().Truncate(time.Second)
+	d := redisclient.DeletionRequest{
+		ID:          "abc",
+		Image:       "app:stable",
+		RequestedBy: "alice",
+		Status:      redisclient.DeletionPending,
+		Events:      []redisclient.DeletionEvent{{Action: "requested", Actor: "alice", At: now}},
+	}
+	if err := s.PutDeletion(ctx, d); err != nil {
+		t.Fatalf("PutDeletion: %v", err)
+	}
+	d.Status = redisclient.DeletionApproved
+	d.Events = append(d.Events, redisclient.DeletionEvent{Action: "approved", Actor: "bob", At: now})
+	if err := s.PutDeletion(ctx, d); err != nil {
+		t.Fatalf("PutDeletion: %v", err)
+	}
+
+	list, err := s.ListDeletions(ctx)
+	if err != nil {
+		t.Fatalf("ListDeletions: %v", err)
+	}
+	if len(list) != 1 || list[0].Status != redisclient.DeletionApproved || len(list[0].Events) != 2 ||
+		!list[0].Events[1].At.Equal(now) || list[0].Events[1].Actor != "bob" {
+		t.Errorf("ListDeletions = %+v, want the updated request", list)
+	}
+}
+
+func testAPITokens(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+	token := redisclient.APIToken{Name: "ci", Role: redisclient.RoleOperator, Repositories: []string{"team/*"},
+		Hash: "abc", CreatedAt: time.Now().UTC().Truncate(time.Second)}
+	if err := s.PutAPIToken(ctx, token); err != nil {
+		t.Fatalf("PutAPIToken: %v", err)
+	}
+	list, err := s.ListAPITokens(ctx)
+	if err != nil {
+		t.Fatalf("ListAPITokens: %v", err)
+	}
+	if len(list) != 1 || list[0].Hash != "abc" || list[0].Role != token.Role ||
+		len(list[0].Repositories) != 1 || !list[0].CreatedAt.Equal(token.CreatedAt) {
+		t.Fatalf("ListAPITokens = %+v, want [%+v]", list, token)
+	}
+
+	if found, err := s.DeleteAPIToken(ctx, "ci"); err != nil || !found {
+		t.Fatalf("DeleteAPIToken = %v, %v; want true, nil", found, err)
+	}
+	if found, _ := s.DeleteAPIToken(ctx, "ci"); found {
+		t.Error("DeleteAPIToken of a missing token reported it found")
+	}
+	if list, _ := s.ListAPITokens(ctx); len(list) != 0 {
+		t.Errorf("ListAPITokens = %+v after DeleteAPIToken, want none", list)
+	}
+}
+
+func testTombstones(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	old := redisclient.Tombstone{Image: "app:old", Repository: "app", Tag: "old", ReapedAt: now.Add(-2 * time.Hour),
+		Reason: "expired"}
+	recent := redisclient.Tombstone{Image: "app:new", Repository: "app", Tag: "new", Digest: "sha256:abc",
+		SizeBytes: 1024, ReapedAt: now, Reason: "evicted"}
+
+	if err := s.AddTombstone(ctx, old, now.Add(-3*time.Hour)); err != nil {
+		t.Fatalf("AddTombstone: %v", err)
+	}
+	if err := s.AddTombstone(ctx, recent, now.Add(-3*time.Hour)); err != nil {
+		t.Fatalf("AddTombstone: %v", err)
+	}
+	list, err := s.ListTombstones(ctx, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("ListTombstones: %v", err)
+	}
+	if len(list) != 1 || list[0].Image != "app:new" || list[0].Digest != "sha256:abc" ||
+		!list[0].ReapedAt.Equal(now) {
+		t.Fatalf("ListTombstones(last hour) = %+v, want [app:new]", list)
+	}
+
+	// Adding with a later cutoff drops the old tombstone for good.
+	if err := s.AddTombstone(ctx, recent, now.Add(-time.Hour)); err != nil {
+		t.Fatalf("AddTombstone: %v", err)
+	}
+	list, err = s.ListTombstones(ctx, time.Time{})
+	if err != nil {
+		t.Fatalf("ListTombstones: %v", err)
+	}
+	for _, tomb := range list {
+		if tomb.Image == "app:old" {
+			t.Errorf("expected tombstone before cutoff to be dropped, got %+v", list)
+		}
+	}
+}
+
+func testReconcileProgress(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+	cp, err := s.ReconcileProgress(ctx)
+	if err != nil || !cp.StartedAt.IsZero() || len(cp.Tags) != 0 {
+		t.Fatalf("ReconcileProgress = %+v, %v; want none", cp, err)
+	}
+
+	started := time.Now().Truncate(time.Millisecond)
+	if err := s.AddReconcileProgress(ctx, started, "app", []string{"1h", "latest"}); err != nil {
+		t.Fatalf("AddReconcileProgress: %v", err)
+	}
+	// A later run's start does not replace the checkpoint's.
+	if err := s.AddReconcileProgress(ctx, started.Add(time.Minute), "empty", []string{}); err != nil {
+		t.Fatalf("AddReconcileProgress: %v", err)
+	}
+	cp, err = s.ReconcileProgress(ctx)
+	if err != nil {
+		t.Fatalf("ReconcileProgress: %v", err)
+	}
+	if !cp.StartedAt.Equal(started) || len(cp.Tags) != 2 || len(cp.Tags["app"]) != 2 || cp.Tags["app"][1] != "latest" {
+		t.Fatalf("ReconcileProgress = %+v, want app and empty started at %v", cp, started)
+	}
+	if tags, ok := cp.Tags["empty"]; !ok || len(tags) != 0 {
+		t.Errorf("expected repository without tags to be recorded, got %v", cp.Tags)
+	}
+
+	if err := s.ClearReconcileProgress(ctx); err != nil {
+		t.Fatalf("ClearReconcileProgress: %v", err)
+	}
+	if cp, err = s.ReconcileProgress(ctx); err != nil || !cp.StartedAt.IsZero() || len(cp.Tags) != 0 {
+		t.Errorf("ReconcileProgress = %+v, %v after clear; want none", cp, err)
+	}
+}
+
+func testReconcileCursor(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+	if cursor, err := s.ReconcileCursor(ctx); err != nil || cursor != "" {
+		t.Fatalf("ReconcileCursor = %q, %v; want none", cursor, err)
+	}
+	if err := s.SetReconcileCursor(ctx, "team/app"); err != nil {
+		t.Fatalf("SetReconcileCursor: %v", err)
+	}
+	if cursor, err := s.ReconcileCursor(ctx); err != nil || cursor != "team/app" {
+		t.Fatalf("ReconcileCursor = %q, %v; want team/app", cursor, err)
+	}
+	if err := s.SetReconcileCursor(ctx, ""); err != nil {
+		t.Fatalf("SetReconcileCursor: %v", err)
+	}
+	if cursor, err := s.ReconcileCursor(ctx); err != nil || cursor != "" {
+		t.Errorf("ReconcileCursor = %q, %v after reset; want none", cursor, err)
+	}
+}
+
+func testRepoStats(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+	now := time.Now().Truncate(time.Millisecond)
+
+	if stats, err := s.ListRepoStats(ctx); err != nil || len(stats) != 0 {
+		t.Fatalf("ListRepoStats = %v, %v; want none", stats, err)
+	}
+	pushes := []struct {
+		repo string
+		size int64
+		at   time.Time
+	}{
+		{"team/web", 100, now.Add(-time.Hour)},
+		{"team/web", 0, now.Add(-time.Minute)},
+		{"team/web", 300, now},
+		{"api", 50, now},
+	}
+	for _, p := range pushes {
+		if err := s.RecordPush(ctx, p.repo, p.size, p.at); err != nil {
+			t.Fatalf("RecordPush: %v", err)
+		}
+	}
+	stats, err := s.ListRepoStats(ctx)
+	if err != nil {
+		t.Fatalf("ListRepoStats: %v", err)
+	}
+	if len(stats) != 2 || stats[0].Repository != "api" || stats[1].Repository != "team/web" {
+		t.Fatalf("ListRepoStats = %+v, want api and team/web", stats)
+	}
+	web := stats[1]
+	if web.Pushes != 3 || web.SizedPushes != 2 || web.TotalBytes != 400 || web.AverageBytes() != 200 {
+		t.Errorf("team/web = %+v, want 3 pushes, 2 sized, 400 bytes, 200 average", web)
+	}
+	if !web.LastPush.Equal(now) {
+		t.Errorf("team/web last push = %v, want %v", web.LastPush, now)
+	}
+}
+
+func testDailyUsage(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	day := func(n int) time.Time { return today.AddDate(0, 0, n) }
+
+	for _, u := range []redisclient.DailyUsage{
+		{Day: day(-3), Repositories: map[string]int64{"app": 10}},
+		{Day: day(-1), Repositories: map[string]int64{"app": 20}},
+		{Day: day(0), Repositories: map[string]int64{"app": 30, "db": 5}},
+		{Day: day(0), Repositories: map[string]int64{"app": 40}},
+	} {
+		if err := s.RecordDailyUsage(ctx, u, day(-2)); err != nil {
+			t.Fatalf("RecordDailyUsage: %v", err)
+		}
+	}
+	got, err := s.ListDailyUsage(ctx, day(-10))
+	if err != nil {
+		t.Fatalf("ListDailyUsage: %v", err)
+	}
+	if len(got) != 2 || !got[0].Day.Equal(day(-1)) || !got[1].Day.Equal(day(0)) {
+		t.Fatalf("ListDailyUsage = %+v, want yesterday and today, the day before the cutoff dropped", got)
+	}
+	if want := map[string]int64{"app": 40}; !maps.Equal(got[1].Repositories, want) {
+		t.Errorf("today = %v, want the latest sample %v", got[1].Repositories, want)
+	}
+	if got, err := s.ListDailyUsage(ctx, day(0)); err != nil || len(got) != 1 {
+		t.Errorf("ListDailyUsage(today) = %+v, %v; want today only", got, err)
+	}
+}
+
+func testSuperseded(t *testing.T, s redisclient.Store) {
+	ctx := t.Context()
+	now := time.Now().Truncate(time.Millisecond)
+
+	for _, m := range []redisclient.SupersededManifest{
+		{Repository: "ci/cache", Digest: "sha256:a", ExpiresAt: now.Add(-time.Hour)},
+		{Repository: "ci/cache", Digest: "sha256:b", ExpiresAt: now.Add(-2 * time.Hour)},
+		{Repository: "ci/cache", Digest: "sha256:c", ExpiresAt: now.Add(time.Hour)},
+		{Repository: "ci/cache", Digest: "sha256:a", ExpiresAt: now.Add(-3 * time.Hour)},
+	} {
+		if err := s.AddSuperseded(ctx, m); err != nil {
+			t.Fatalf("AddSuperseded: %v", err)
+		}
+	}
+	got, err := s.ExpiredSuperseded(ctx, now)
+	if err != nil {
+		t.Fatalf("ExpiredSuperseded: %v", err)
+	}
+	if len(got) != 2 || got[0].Digest != "sha256:b" || got[1].Digest != "sha256:a" {
+		t.Fatalf("ExpiredSuperseded = %+v, want sha256:b then sha256:a", got)
+	}
+	if !got[1].ExpiresAt.Equal(now.Add(-time.Hour)) || got[1].Repository != "ci/cache" {
+		t.Errorf("sha256:a = %+v, want ci/cache expiring an hour ago, the later expiry", got[1])
+	}
+
+	if err := s.RemoveSuperseded(ctx, "ci/cache", "sha256:b"); err != nil {
+		t.Fatalf("RemoveSuperseded: %v", err)
+	}
+	if got, err := s.ExpiredSuperseded(ctx, now.Add(2*time.Hour)); err != nil || len(got) != 2 {
+		t.Errorf("ExpiredSuperseded after removal = %+v, %v; want sha256:a and sha256:c", got, err)
+	}
+}
@@ -0,0 +1,166 @@
+// Package usage keeps daily aggregates of the storage tracked per
+// repository in the store, so storage trends over weeks can be charted
+// independently of Prometheus retention.
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// oneDay is the length of the aggregation period.
+const oneDay = 24 * time.Hour
+
+// Recorder samples the tracked bytes per repository into the day's
+// aggregate.
+type Recorder struct {
+	store     redisclient.Store
+	retention time.Duration
+	logger    *slog.Logger
+	now       func() time.Time
+}
+
+// New returns a Recorder keeping the days of the last retention.
+func New(store redisclient.Store, retention time.Duration, logger *slog.Logger) *Recorder {
+	return &Recorder{store: store, retention: retention, logger: logger, now: time.Now}
+}
+
+// Start runs RunLoop in the background if interval is positive.
+func (r *Recorder) Start(ctx context.Context, interval time.Duration) {
+	if interval > 0 {
+		go r.RunLoop(ctx, interval)
+	}
+}
+
+// RunLoop records a sample immediately and then at the given interval. It
+// blocks until the context is cancelled.
+func (r *Recorder) RunLoop(ctx context.Context, interval time.Duration) {
+	r.logger.Info("starting usage history recorder",
+		"interval", interval.String(),
+		"retention", r.retention.String(),
+	)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Record(ctx); err != nil && ctx.Err() == nil {
+			r.logger.Error("failed to record usage history", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Record stores the current tracked bytes per repository as today's usage,
+// replacing today's earlier sample, and drops days past the retention.
+func (r *Recorder) Record(ctx context.Context) error {
+	repos, err := r.repositoryBytes(ctx)
+	if err != nil {
+		return err
+	}
+	today := r.now().UTC().Truncate(oneDay)
+	cutoff := today.Add(-r.retention)
+	return r.store.RecordDailyUsage(ctx, redisclient.DailyUsage{Day: today, Repositories: repos}, cutoff)
+}
+
+// repositoryBytes returns the tracked bytes per repository. Tags of a
+// repository sharing a manifest count it once, like the tracked bytes total.
+func (r *Recorder) repositoryBytes(ctx context.Context) (map[string]int64, error) {
+	images, err := r.store.ListImages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing images: %w", err)
+	}
+	repos := make(map[string]int64)
+	counted := make(map[string]struct{})
+	for _, image := range images {
+		size, err := r.store.GetImageSize(ctx, image)
+		if err != nil {
+			return nil, fmt.Errorf("reading size of %s: %w", image, err)
+		}
+		digest, err := r.store.GetImageDigest(ctx, image)
+		if err != nil {
+			return nil, fmt.Errorf("reading digest of %s: %w", image, err)
+		}
+		repo, _, _ := strings.Cut(image, ":")
+		if digest != "" {
+			if _, ok := counted[repo+"@"+digest]; ok {
+				continue
+			}
+			counted[repo+"@"+digest] = struct{}{}
+		}
+		repos[repo] += size
+	}
+	return repos, nil
+}
+
+// Day is a day of usage history as served by Handler.
+type Day struct {
+	Day          time.Time        `json:"day"`
+	TotalBytes   int64            `json:"total_bytes"`
+	Repositories map[string]int64 `json:"repositories"`
+}
+
+// DefaultDays is how many days Handler serves without a days parameter.
+const DefaultDays = 30
+
+// Handler serves the usage history as JSON: the last days query parameter
+// days, 30 by default, of the repositories matching the repository
+// parameter, a path.Match pattern, or of all of them.
+func Handler(store redisclient.Store, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		days := DefaultDays
+		if v := q.Get("days"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "days must be a positive number", http.StatusBadRequest)
+				return
+			}
+			days = n
+		}
+		pattern := q.Get("repository")
+		if _, err := path.Match(pattern, ""); err != nil {
+			http.Error(w, "invalid repository pattern", http.StatusBadRequest)
+			return
+		}
+
+		since := time.Now().UTC().Truncate(oneDay).AddDate(0, 0, 1-days)
+		history, err := store.ListDailyUsage(req.Context(), since)
+		if err != nil {
+			logger.Error("failed to list usage history", "error", err)
+			http.Error(w, "usage history unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		out := make([]Day, 0, len(history))
+		for _, u := range history {
+			d := Day{Day: u.Day, Repositories: u.Repositories}
+			if pattern != "" {
+				d.Repositories = maps.Clone(u.Repositories)
+				maps.DeleteFunc(d.Repositories, func(repo string, _ int64) bool {
+					ok, _ := path.Match(pattern, repo)
+					return !ok
+				})
+			}
+			for _, bytes := range d.Repositories {
+				d.TotalBytes += bytes
+			}
+			out = append(out, d)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string][]Day{"days": out})
+	})
+}
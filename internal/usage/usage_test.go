@@ -0,0 +1,109 @@
+package usage
+
+import (
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/memstore"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+func TestRecord(t *testing.T) {
+	store := memstore.New()
+	for _, i := range []struct {
+		image, digest string
+		size          int64
+	}{
+		{"app:1h", "sha256:a", 100},
+		{"app:latest", "sha256:a", 100},
+		{"app:2h", "sha256:b", 50},
+		{"team/db:1h", "", 7},
+	} {
+		if err := store.TrackImage(t.Context(), i.image, time.Now().Add(time.Hour), i.size, i.digest,
+			redisclient.ImageMeta{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Date(2026, 3, 10, 15, 4, 5, 0, time.UTC)
+	old := redisclient.DailyUsage{Day: now.AddDate(0, 0, -40).Truncate(oneDay), Repositories: map[string]int64{"x": 1}}
+	if err := store.RecordDailyUsage(t.Context(), old, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(store, 30*oneDay, slog.Default())
+	r.now = func() time.Time { return now }
+	if err := r.Record(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.ListDailyUsage(t.Context(), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("history = %+v, want today only, the day past the retention dropped", got)
+	}
+	if want := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC); !got[0].Day.Equal(want) {
+		t.Errorf("day = %v, want %v", got[0].Day, want)
+	}
+	if want := map[string]int64{"app": 150, "team/db": 7}; !maps.Equal(got[0].Repositories, want) {
+		t.Errorf("repositories = %v, want %v with the shared manifest counted once", got[0].Repositories, want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	store := memstore.New()
+	today := time.Now().UTC().Truncate(oneDay)
+	for n, repos := range []map[string]int64{
+		{"app": 10, "team/db": 1},
+		{"app": 20, "team/db": 2, "team/web": 3},
+	} {
+		u := redisclient.DailyUsage{Day: today.AddDate(0, 0, n-1), Repositories: repos}
+		if err := store.RecordDailyUsage(t.Context(), u, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := Handler(store, slog.Default())
+
+	tests := []struct {
+		query  string
+		totals []int64
+	}{
+		{"", []int64{11, 25}},
+		{"?days=1", []int64{25}},
+		{"?repository=team/*", []int64{1, 5}},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/api/stats/usage"+tt.query, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%q: status = %d, want 200", tt.query, rr.Code)
+		}
+		var body struct {
+			Days []Day `json:"days"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		totals := make([]int64, len(body.Days))
+		for i, d := range body.Days {
+			totals[i] = d.TotalBytes
+		}
+		if !slices.Equal(totals, tt.totals) {
+			t.Errorf("%q: totals = %v, want %v", tt.query, totals, tt.totals)
+		}
+	}
+
+	for _, query := range []string{"?days=0", "?repository=["} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/api/stats/usage"+query, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%q: status = %d, want 400", query, rr.Code)
+		}
+	}
+}
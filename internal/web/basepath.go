@@ -0,0 +1,31 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+)
+
+// StripBasePath serves next under basePath, for ingresses that mount the
+// service below a path: requests below basePath reach next with the prefix
+// removed, basePath itself redirects to basePath+"/" and any other path is
+// not found. An empty basePath serves next as is.
+func StripBasePath(basePath string, next http.Handler) http.Handler {
+	if basePath == "" {
+		return next
+	}
+	strip := http.StripPrefix(basePath, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, basePath+"/"):
+			strip.ServeHTTP(w, r)
+		case r.URL.Path == basePath:
+			target := basePath + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStripBasePath(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("home")) })
+	mux.HandleFunc("GET /v1/api/status", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path))
+	})
+	h := StripBasePath("/ephemeron", mux)
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+		wantLoc    string
+	}{
+		{"/ephemeron/", http.StatusOK, "home", ""},
+		{"/ephemeron/v1/api/status", http.StatusOK, "/v1/api/status", ""},
+		{"/ephemeron", http.StatusMovedPermanently, "", "/ephemeron/"},
+		{"/ephemeron?x=1", http.StatusMovedPermanently, "", "/ephemeron/?x=1"},
+		{"/v1/api/status", http.StatusNotFound, "", ""},
+		{"/ephemeronx/v1/api/status", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rr.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.path, rr.Code, tt.wantStatus)
+			continue
+		}
+		if tt.wantBody != "" && rr.Body.String() != tt.wantBody {
+			t.Errorf("%s: body = %q, want %q", tt.path, rr.Body.String(), tt.wantBody)
+		}
+		if loc := rr.Header().Get("Location"); loc != tt.wantLoc {
+			t.Errorf("%s: Location = %q, want %q", tt.path, loc, tt.wantLoc)
+		}
+	}
+}
+
+func TestStripBasePath_Empty(t *testing.T) {
+	mux := http.NewServeMux()
+	if h := StripBasePath("", mux); h != http.Handler(mux) {
+		t.Error("expected an empty base path to serve the handler as is")
+	}
+}
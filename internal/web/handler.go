@@ -3,10 +3,15 @@ package web
 import (
 	"bytes"
 	"embed"
+	"errors"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"log/slog"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -19,45 +24,145 @@ type TemplateData struct {
 	DefaultTTL string
 	MaxTTL     string
 	Version    string
+	// ExampleTag is the TTL tag of the example commands, DefaultTTL's.
+	ExampleTag string
+	// ExampleTTLs are TTL tags to suggest, up to MaxTTL.
+	ExampleTTLs []string
+	// Snippets are copy-pasteable push commands for common tools.
+	Snippets []Snippet
+	// DefaultTTLSeconds and MaxTTLSeconds drive the TTL calculator.
+	DefaultTTLSeconds int64
+	MaxTTLSeconds     int64
+	// BasePath prefixes the page's links to the service's own routes.
+	BasePath string
+	// SignIn shows a link to the OIDC sign-in.
+	SignIn bool
+	// Vars holds operator-defined values, such as a company name or support
+	// link, for custom templates.
+	Vars map[string]string
 }
 
-// Handler serves the embedded landing page.
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithBasePath prefixes the page's links with basePath, the BASE_PATH the
+// service is served under.
+func WithBasePath(basePath string) Option {
+	return func(h *Handler) { h.data.BasePath = basePath }
+}
+
+// WithSignIn links to the OIDC sign-in from the page.
+func WithSignIn() Option {
+	return func(h *Handler) { h.data.SignIn = true }
+}
+
+// WithTemplateDir renders dir/index.html instead of the embedded page, if it
+// exists, and serves the files below dir/static at /static/.
+func WithTemplateDir(dir string) Option {
+	return func(h *Handler) { h.templateDir = dir }
+}
+
+// WithTemplateVars makes vars available to the template as .Vars.
+func WithTemplateVars(vars map[string]string) Option {
+	return func(h *Handler) { h.data.Vars = vars }
+}
+
+// ParseTemplateVars builds the values for WithTemplateVars from "key=value"
+// entries.
+func ParseTemplateVars(entries []string) (map[string]string, error) {
+	vars := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		key, value, ok := strings.Cut(entry, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid entry %q (want key=value)", entry)
+		}
+		vars[key] = strings.TrimSpace(value)
+	}
+	return vars, nil
+}
+
+// Handler serves the landing page.
 type Handler struct {
-	rendered []byte
-	logger   *slog.Logger
+	rendered    []byte
+	assets      http.Handler
+	data        TemplateData
+	templateDir string
+	logger      *slog.Logger
 }
 
 // NewHandler creates a new web handler that renders the landing page
 // with the given hostname and TTL values.
 func NewHandler(
-	hostname string, defaultTTL, maxTTL time.Duration, version string, logger *slog.Logger,
+	hostname string, defaultTTL, maxTTL time.Duration, version string, logger *slog.Logger, opts ...Option,
 ) (*Handler, error) {
-	tmplBytes, err := staticFS.ReadFile("static/index.html")
+	exampleTag := ttlTag(min(defaultTTL, maxTTL))
+	h := &Handler{
+		data: TemplateData{
+			Hostname:          hostname,
+			DefaultTTL:        formatDuration(defaultTTL),
+			MaxTTL:            formatDuration(maxTTL),
+			Version:           version,
+			ExampleTag:        exampleTag,
+			ExampleTTLs:       exampleTTLs(defaultTTL, maxTTL),
+			Snippets:          snippets(hostname, exampleTag),
+			DefaultTTLSeconds: int64(defaultTTL.Seconds()),
+			MaxTTLSeconds:     int64(maxTTL.Seconds()),
+		},
+		logger: logger,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	tmplBytes, err := h.template()
 	if err != nil {
 		return nil, err
 	}
-
 	tmpl, err := template.New("index").Parse(string(tmplBytes))
 	if err != nil {
 		return nil, err
 	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, h.data); err != nil {
+		return nil, err
+	}
+	h.rendered = buf.Bytes()
 
-	data := TemplateData{
-		Hostname:   hostname,
-		DefaultTTL: formatDuration(defaultTTL),
-		MaxTTL:     formatDuration(maxTTL),
-		Version:    version,
+	if h.templateDir != "" {
+		dir := filepath.Join(h.templateDir, "static")
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			h.assets = assetServer(dir)
+		}
 	}
+	return h, nil
+}
 
-	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, data); err != nil {
-		return nil, err
+// template returns the custom template from the template directory, falling
+// back to the embedded one when there is none.
+func (h *Handler) template() ([]byte, error) {
+	if h.templateDir != "" {
+		name := filepath.Join(h.templateDir, "index.html")
+		b, err := os.ReadFile(name)
+		if err == nil {
+			h.logger.Info("using custom landing page template", "path", name)
+			return b, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("reading template: %w", err)
+		}
+		h.logger.Info("no custom landing page template, using the embedded one", "path", name)
 	}
+	return staticFS.ReadFile("static/index.html")
+}
 
-	return &Handler{
-		rendered: buf.Bytes(),
-		logger:   logger,
-	}, nil
+// Register adds the landing page and, with a template directory that has
+// them, its static assets to mux.
+func (h *Handler) Register(mux *http.ServeMux) {
+	mux.Handle("GET /{$}", h)
+	if h.assets != nil {
+		mux.Handle("GET /static/", http.StripPrefix("/static/", h.assets))
+	}
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -67,6 +172,19 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(h.rendered)
 }
 
+// assetServer serves the files below dir, without directory listings.
+func assetServer(dir string) http.Handler {
+	files := http.FileServerFS(os.DirFS(dir))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "" || strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		files.ServeHTTP(w, r)
+	})
+}
+
 func formatDuration(d time.Duration) string {
 	switch {
 	case d >= 24*time.Hour && d%(24*time.Hour) == 0:
@@ -4,6 +4,8 @@ import (
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -30,6 +32,12 @@ func TestHandler_ServesPage(t *testing.T) {
 	if !strings.Contains(body, "1h") {
 		t.Error("expected default TTL to appear in rendered page")
 	}
+	if !strings.Contains(body, `data-command="crane copy docker.io/library/alpine:3 reg.test.dev/alpine:{tag}"`) {
+		t.Error("expected the crane snippet in rendered page")
+	}
+	if !strings.Contains(body, `data-default="3600" data-max="86400"`) {
+		t.Error("expected the TTL calculator limits in rendered page")
+	}
 
 	ct := rr.Header().Get("Content-Type")
 	if !strings.HasPrefix(ct, "text/html") {
@@ -37,6 +45,109 @@ func TestHandler_ServesPage(t *testing.T) {
 	}
 }
 
+func TestHandler_SignInLink(t *testing.T) {
+	h, err := NewHandler("reg.test.dev", time.Hour, 24*time.Hour, "v0.1.0", slog.Default(),
+		WithBasePath("/ephemeron"), WithSignIn())
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if want := `href="/ephemeron/auth/login?return_to=%2fephemeron/"`; !strings.Contains(rr.Body.String(), want) {
+		t.Errorf("expected the sign-in link %s in rendered page", want)
+	}
+}
+
+func TestHandler_TemplateDir(t *testing.T) {
+	dir := t.TempDir()
+	tmpl := `<h1>{{.Vars.company}} registry</h1><img src="{{.BasePath}}/static/logo.svg"> {{.Hostname}}`
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte(tmpl), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "static", "img"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "static", "logo.svg"), []byte("<svg/>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHandler("reg.test.dev", time.Hour, 24*time.Hour, "v0.1.0", slog.Default(),
+		WithTemplateDir(dir), WithTemplateVars(map[string]string{"company": "Acme"}))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	mux := http.NewServeMux()
+	h.Register(mux)
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"/", http.StatusOK, `<h1>Acme registry</h1><img src="/static/logo.svg"> reg.test.dev`},
+		{"/static/logo.svg", http.StatusOK, "<svg/>"},
+		{"/static/", http.StatusNotFound, ""},
+		{"/static/img/", http.StatusNotFound, ""},
+		{"/static/missing.css", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rr.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.path, rr.Code, tt.wantStatus)
+			continue
+		}
+		if tt.wantBody != "" && rr.Body.String() != tt.wantBody {
+			t.Errorf("%s: body = %q, want %q", tt.path, rr.Body.String(), tt.wantBody)
+		}
+	}
+}
+
+func TestHandler_TemplateDir_FallsBack(t *testing.T) {
+	h, err := NewHandler("reg.test.dev", time.Hour, 24*time.Hour, "v0.1.0", slog.Default(),
+		WithTemplateDir(t.TempDir()))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	mux := http.NewServeMux()
+	h.Register(mux)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(rr.Body.String(), "Ephemeral container registry") {
+		t.Error("expected the embedded page without a custom template")
+	}
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/static/logo.svg", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected no static assets without a static directory, got %d", rr.Code)
+	}
+}
+
+func TestHandler_TemplateDir_InvalidTemplate(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("{{.Hostname"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewHandler("reg.test.dev", time.Hour, 24*time.Hour, "v0.1.0", slog.Default(),
+		WithTemplateDir(dir)); err == nil {
+		t.Error("expected error for an invalid custom template")
+	}
+}
+
+func TestParseTemplateVars(t *testing.T) {
+	vars, err := ParseTemplateVars([]string{"company=Acme", " support = https://help.example.com "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars["company"] != "Acme" || vars["support"] != "https://help.example.com" {
+		t.Errorf("vars = %v", vars)
+	}
+	if _, err := ParseTemplateVars([]string{"company"}); err == nil {
+		t.Error("expected error for entry without =")
+	}
+}
+
 func TestFormatDuration(t *testing.T) {
 	tests := []struct {
 		d    time.Duration
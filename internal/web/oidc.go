@@ -0,0 +1,456 @@
+package web
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/apiauth"
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// Cookies set by the login flow.
+const (
+	sessionCookie = "ephemeron_session"
+	loginCookie   = "ephemeron_login"
+)
+
+// loginTTL bounds how long a user may take at the identity provider.
+const loginTTL = 10 * time.Minute
+
+// everyone is the group entry in OIDCConfig.GroupRoles that applies to all
+// users who sign in.
+const everyone = "*"
+
+// OIDCConfig configures sign-in through an OpenID Connect provider.
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the absolute URL of the callback route.
+	RedirectURL string
+	Scopes      []string
+	// GroupsClaim is the ID token claim listing the user's groups.
+	GroupsClaim string
+	// GroupRoles maps groups to RBAC roles; the group "*" matches every
+	// user. A user gets the highest role of their groups.
+	GroupRoles map[string]string
+	// SessionSecret signs session cookies.
+	SessionSecret string
+	SessionTTL    time.Duration
+	// BasePath is the BASE_PATH the routes are served under. It scopes the
+	// cookies and prefixes the redirects back to the landing page.
+	BasePath string
+}
+
+// ParseGroupRoles builds OIDCConfig.GroupRoles from "group=role" entries.
+func ParseGroupRoles(entries []string) (map[string]string, error) {
+	roles := make(map[string]string)
+	for _, entry := range entries {
+		group, role, ok := strings.Cut(entry, "=")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if !ok || group == "" {
+			return nil, fmt.Errorf("invalid entry %q (want group=role)", entry)
+		}
+		if !slices.Contains(apiauth.Roles, role) {
+			return nil, fmt.Errorf("invalid role %q in %q (want one of %s)",
+				role, entry, strings.Join(apiauth.Roles, ", "))
+		}
+		roles[group] = role
+	}
+	return roles, nil
+}
+
+// Session is a signed-in user.
+type Session struct {
+	Subject string    `json:"sub"`
+	Name    string    `json:"name"`
+	Role    string    `json:"role"`
+	Expires time.Time `json:"expires"`
+}
+
+// OIDC signs users in with the authorization code flow and keeps them
+// signed in with a signed session cookie.
+type OIDC struct {
+	cfg    OIDCConfig
+	client *http.Client
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu       sync.Mutex
+	provider *provider
+}
+
+// provider is the subset of the provider's discovery document used.
+type provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+// NewOIDC returns an OIDC login. The provider is discovered on the first
+// sign-in, so an unavailable provider does not prevent startup.
+func NewOIDC(cfg OIDCConfig, logger *slog.Logger) *OIDC {
+	return &OIDC{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+// Register adds the login routes to mux.
+func (o *OIDC) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /auth/login", o.login)
+	mux.HandleFunc("GET /auth/callback", o.callback)
+	mux.HandleFunc("POST /auth/logout", o.logout)
+	mux.HandleFunc("GET /auth/session", o.session)
+}
+
+// Session returns the signed-in user of r, if any.
+func (o *OIDC) Session(r *http.Request) (Session, bool) {
+	var s Session
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || !o.verify(c.Value, &s) || !o.now().Before(s.Expires) {
+		return Session{}, false
+	}
+	return s, true
+}
+
+// Authenticate lets the API accept sessions in place of API tokens.
+func (o *OIDC) Authenticate(r *http.Request) (redisclient.APIToken, bool) {
+	s, ok := o.Session(r)
+	if !ok {
+		return redisclient.APIToken{}, false
+	}
+	return redisclient.APIToken{Name: "oidc:" + s.Name, Role: s.Role}, true
+}
+
+// pendingLogin is the sign-in in progress, kept in the login cookie.
+type pendingLogin struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	ReturnTo string    `json:"return_to"`
+	Expires  time.Time `json:"expires"`
+}
+
+func (o *OIDC) login(w http.ResponseWriter, r *http.Request) {
+	p, err := o.discover(r.Context())
+	if err != nil {
+		o.logger.Error("OIDC discovery failed", "error", err)
+		http.Error(w, "identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+	pending := pendingLogin{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: randomString(),
+		ReturnTo: returnTo(r.URL.Query().Get("return_to"), o.cfg.BasePath+"/"),
+		Expires:  o.now().Add(loginTTL),
+	}
+	o.setCookie(w, loginCookie, o.sign(pending), loginTTL)
+
+	challenge := sha256.Sum256([]byte(pending.Verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.cfg.ClientID},
+		"redirect_uri":          {o.cfg.RedirectURL},
+		"scope":                 {strings.Join(o.cfg.Scopes, " ")},
+		"state":                 {pending.State},
+		"nonce":                 {pending.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, p.AuthorizationEndpoint+sep+q.Encode(), http.StatusFound)
+}
+
+func (o *OIDC) callback(w http.ResponseWriter, r *http.Request) {
+	var pending pendingLogin
+	c, err := r.Cookie(loginCookie)
+	if err != nil || !o.verify(c.Value, &pending) || !o.now().Before(pending.Expires) {
+		http.Error(w, "sign-in expired, please try again", http.StatusBadRequest)
+		return
+	}
+	o.setCookie(w, loginCookie, "", -1)
+	q := r.URL.Query()
+	if !hmac.Equal([]byte(q.Get("state")), []byte(pending.State)) {
+		http.Error(w, "invalid sign-in state", http.StatusBadRequest)
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		o.logger.Warn("OIDC sign-in refused by provider", "error", e, "description", q.Get("error_description"))
+		http.Error(w, "sign-in failed: "+e, http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := o.exchange(r.Context(), q.Get("code"), pending)
+	if err != nil {
+		o.logger.Warn("OIDC sign-in failed", "error", err)
+		http.Error(w, "sign-in failed", http.StatusUnauthorized)
+		return
+	}
+	name := claims.name()
+	role := o.role(claims.groups(o.cfg.GroupsClaim))
+	if role == "" {
+		o.logger.Warn("OIDC user has no role", "user", name)
+		http.Error(w, "you are not a member of any group with access", http.StatusForbidden)
+		return
+	}
+
+	s := Session{Subject: claims.Subject, Name: name, Role: role, Expires: o.now().Add(o.cfg.SessionTTL)}
+	o.setCookie(w, sessionCookie, o.sign(s), o.cfg.SessionTTL)
+	o.logger.Info("user signed in", "user", name, "role", role)
+	http.Redirect(w, r, pending.ReturnTo, http.StatusFound)
+}
+
+func (o *OIDC) logout(w http.ResponseWriter, r *http.Request) {
+	o.setCookie(w, sessionCookie, "", -1)
+	http.Redirect(w, r, o.cfg.BasePath+"/", http.StatusSeeOther)
+}
+
+func (o *OIDC) session(w http.ResponseWriter, r *http.Request) {
+	s, ok := o.Session(r)
+	if !ok {
+		http.Error(w, "not signed in", http.StatusUnauthorized)
+		return
+	}
+	c, _ := r.Cookie(sessionCookie)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(struct {
+		Session
+		CSRFToken string `json:"csrf_token"`
+	}{s, o.csrfToken(c.Value)})
+}
+
+// discover fetches and caches the provider's discovery document.
+func (o *OIDC) discover(ctx context.Context) (*provider, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.provider != nil {
+		return o.provider, nil
+	}
+	issuer := strings.TrimRight(o.cfg.IssuerURL, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery returned %d", resp.StatusCode)
+	}
+	var p provider
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return nil, fmt.Errorf("decoding discovery document: %w", err)
+	}
+	if strings.TrimRight(p.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("discovery issuer %q does not match %q", p.Issuer, o.cfg.IssuerURL)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" {
+		return nil, errors.New("discovery document lacks endpoints")
+	}
+	o.provider = &p
+	return o.provider, nil
+}
+
+// claims are the ID token claims used.
+type claims struct {
+	Issuer            string          `json:"iss"`
+	Subject           string          `json:"sub"`
+	Audience          json.RawMessage `json:"aud"`
+	Expiry            int64           `json:"exp"`
+	Nonce             string          `json:"nonce"`
+	Name              string          `json:"name"`
+	Email             string          `json:"email"`
+	PreferredUsername string          `json:"preferred_username"`
+	raw               map[string]json.RawMessage
+}
+
+func (c claims) name() string {
+	for _, n := range []string{c.Email, c.PreferredUsername, c.Name} {
+		if n != "" {
+			return n
+		}
+	}
+	return c.Subject
+}
+
+// groups returns the string or string list in claim.
+func (c claims) groups(claim string) []string {
+	var list []string
+	if err := json.Unmarshal(c.raw[claim], &list); err == nil {
+		return list
+	}
+	var single string
+	if err := json.Unmarshal(c.raw[claim], &single); err == nil && single != "" {
+		return []string{single}
+	}
+	return nil
+}
+
+func (c claims) hasAudience(clientID string) bool {
+	var list []string
+	if err := json.Unmarshal(c.Audience, &list); err == nil {
+		return slices.Contains(list, clientID)
+	}
+	var single string
+	return json.Unmarshal(c.Audience, &single) == nil && single == clientID
+}
+
+// exchange redeems code at the token endpoint and returns the validated
+// ID token claims. The ID token comes straight from the token endpoint over
+// TLS with client authentication, so, as OpenID Connect Core 3.1.3.7
+// permits, the TLS server identity stands in for its signature.
+func (o *OIDC) exchange(ctx context.Context, code string, pending pendingLogin) (claims, error) {
+	var c claims
+	p, err := o.discover(ctx)
+	if err != nil {
+		return c, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.cfg.RedirectURL},
+		"code_verifier": {pending.Verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return c, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(o.cfg.ClientID), url.QueryEscape(o.cfg.ClientSecret))
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return c, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return c, fmt.Errorf("token endpoint returned %d", resp.StatusCode)
+	}
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return c, fmt.Errorf("decoding token response: %w", err)
+	}
+
+	parts := strings.Split(tokens.IDToken, ".")
+	if len(parts) != 3 {
+		return c, errors.New("malformed ID token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return c, fmt.Errorf("decoding ID token: %w", err)
+	}
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return c, fmt.Errorf("decoding ID token claims: %w", err)
+	}
+	if err := json.Unmarshal(payload, &c.raw); err != nil {
+		return c, fmt.Errorf("decoding ID token claims: %w", err)
+	}
+	switch {
+	case strings.TrimRight(c.Issuer, "/") != strings.TrimRight(p.Issuer, "/"):
+		return c, fmt.Errorf("unexpected issuer %q", c.Issuer)
+	case !c.hasAudience(o.cfg.ClientID):
+		return c, errors.New("ID token not issued for this client")
+	case !o.now().Before(time.Unix(c.Expiry, 0)):
+		return c, errors.New("ID token expired")
+	case !hmac.Equal([]byte(c.Nonce), []byte(pending.Nonce)):
+		return c, errors.New("ID token nonce mismatch")
+	case c.Subject == "":
+		return c, errors.New("ID token lacks a subject")
+	}
+	return c, nil
+}
+
+// role returns the highest role granted to groups, or "" if none is.
+func (o *OIDC) role(groups []string) string {
+	best := -1
+	for _, group := range append(slices.Clone(groups), everyone) {
+		if role, ok := o.cfg.GroupRoles[group]; ok {
+			best = max(best, slices.Index(apiauth.Roles, role))
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	return apiauth.Roles[best]
+}
+
+// sign encodes v as a cookie value authenticated with the session secret.
+func (o *OIDC) sign(v any) string {
+	data, _ := json.Marshal(v)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + o.mac(payload)
+}
+
+// verify decodes a cookie value created by sign into v.
+func (o *OIDC) verify(value string, v any) bool {
+	payload, mac, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(o.mac(payload))) {
+		return false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	return err == nil && json.Unmarshal(data, v) == nil
+}
+
+func (o *OIDC) mac(payload string) string {
+	m := hmac.New(sha256.New, []byte(o.cfg.SessionSecret))
+	m.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// setCookie sets name to value for maxAge; a negative maxAge deletes it.
+func (o *OIDC) setCookie(w http.ResponseWriter, name, value string, maxAge time.Duration) {
+	seconds := int(maxAge.Seconds())
+	if maxAge < 0 {
+		seconds = -1
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     o.cfg.BasePath + "/",
+		MaxAge:   seconds,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(o.cfg.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// returnTo only allows local paths, so the login cannot be used as an
+// open redirect; anything else returns home.
+func returnTo(target, home string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return home
+	}
+	return target
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,190 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+// fakeProvider serves discovery and a token endpoint issuing ID tokens for
+// a user in groups, checking the PKCE verifier against the challenge.
+func fakeProvider(t *testing.T, groups []string) *httptest.Server {
+	t.Helper()
+	var challenge, nonce string
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"issuer":                 srv.URL,
+				"authorization_endpoint": srv.URL + "/authorize",
+				"token_endpoint":         srv.URL + "/token",
+			})
+		case "/authorize":
+			challenge, nonce = r.URL.Query().Get("code_challenge"), r.URL.Query().Get("nonce")
+		case "/token":
+			sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+			if id, secret, _ := r.BasicAuth(); id != "ephemeron" || secret != "client-secret" ||
+				r.FormValue("code") != "abc" || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			payload, _ := json.Marshal(map[string]any{
+				"iss": srv.URL, "aud": []string{"ephemeron"}, "sub": "u1", "email": "alice@example.com",
+				"exp": time.Now().Add(time.Hour).Unix(), "nonce": nonce, "groups": groups,
+			})
+			idToken := "e30." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+			_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newOIDC(issuer string) (*OIDC, *http.ServeMux) {
+	o := NewOIDC(OIDCConfig{
+		IssuerURL:     issuer,
+		ClientID:      "ephemeron",
+		ClientSecret:  "client-secret",
+		RedirectURL:   "https://reg.example.com/auth/callback",
+		Scopes:        []string{"openid"},
+		GroupsClaim:   "groups",
+		GroupRoles:    map[string]string{"devs": redisclient.RoleReadOnly, "sre": redisclient.RoleAdmin},
+		SessionSecret: "0123456789abcdef0123456789abcdef",
+		SessionTTL:    time.Hour,
+	}, slog.New(slog.DiscardHandler))
+	mux := http.NewServeMux()
+	o.Register(mux)
+	return o, mux
+}
+
+// signIn runs the login flow and returns the callback response.
+func signIn(t *testing.T, mux *http.ServeMux, state func(string) string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/login?return_to=/reports", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected redirect to provider, got %d: %s", rec.Code, rec.Body)
+	}
+	loc, _ := url.Parse(rec.Header().Get("Location"))
+	// Play the provider's part of the redirect.
+	resp, err := http.Get(loc.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/callback?code=abc&state="+state(loc.Query().Get("state")), nil)
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestOIDC_SignIn(t *testing.T) {
+	o, mux := newOIDC(fakeProvider(t, []string{"devs", "sre"}).URL)
+	rec := signIn(t, mux, func(s string) string { return s })
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/reports" {
+		t.Fatalf("expected redirect back to /reports, got %d %q: %s", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/session", nil)
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionCookie {
+			if !c.HttpOnly || !c.Secure {
+				t.Error("expected an HttpOnly, Secure session cookie")
+			}
+			req.AddCookie(c)
+		}
+	}
+	s, ok := o.Session(req)
+	if !ok || s.Name != "alice@example.com" || s.Role != redisclient.RoleAdmin {
+		t.Fatalf("expected admin session for alice, got %+v (%v)", s, ok)
+	}
+	if token, ok := o.Authenticate(req); !ok || token.Role != redisclient.RoleAdmin {
+		t.Errorf("expected session to authenticate as admin, got %+v", token)
+	}
+
+	o.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, ok := o.Session(req); ok {
+		t.Error("expected session to expire")
+	}
+}
+
+func TestOIDC_NoRole(t *testing.T) {
+	_, mux := newOIDC(fakeProvider(t, []string{"sales"}).URL)
+	if rec := signIn(t, mux, func(s string) string { return s }); rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for user without role, got %d", rec.Code)
+	}
+}
+
+func TestOIDC_StateMismatch(t *testing.T) {
+	_, mux := newOIDC(fakeProvider(t, []string{"devs"}).URL)
+	if rec := signIn(t, mux, func(string) string { return "forged" }); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for forged state, got %d", rec.Code)
+	}
+}
+
+func TestOIDC_TamperedSession(t *testing.T) {
+	o, _ := newOIDC("https://idp.example.com")
+	forged := Session{Name: "mallory", Role: redisclient.RoleAdmin, Expires: time.Now().Add(time.Hour)}
+	data, _ := json.Marshal(forged)
+	req := httptest.NewRequest(http.MethodGet, "/auth/session", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: base64.RawURLEncoding.EncodeToString(data) + ".bogus"})
+	if _, ok := o.Session(req); ok {
+		t.Error("expected unsigned session to be rejected")
+	}
+}
+
+func TestOIDC_Logout_BasePath(t *testing.T) {
+	o := NewOIDC(OIDCConfig{SessionSecret: "0123456789abcdef0123456789abcdef", BasePath: "/ephemeron"},
+		slog.New(slog.DiscardHandler))
+	mux := http.NewServeMux()
+	o.Register(mux)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/auth/logout", nil))
+	if loc := rr.Header().Get("Location"); loc != "/ephemeron/" {
+		t.Errorf("Location = %q, want /ephemeron/", loc)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Path != "/ephemeron/" {
+		t.Errorf("cookies = %v, want the session cookie cleared on /ephemeron/", cookies)
+	}
+}
+
+func TestParseGroupRoles(t *testing.T) {
+	roles, err := ParseGroupRoles([]string{"sre=admin", "*=read-only"})
+	if err != nil || roles["sre"] != redisclient.RoleAdmin || roles["*"] != redisclient.RoleReadOnly {
+		t.Fatalf("unexpected result %v (%v)", roles, err)
+	}
+	for _, entry := range []string{"sre", "=admin", "sre=root"} {
+		if _, err := ParseGroupRoles([]string{entry}); err == nil {
+			t.Errorf("%q: expected error", entry)
+		}
+	}
+}
+
+func TestReturnTo(t *testing.T) {
+	for target, want := range map[string]string{
+		"/reports":             "/reports",
+		"":                     "/",
+		"https://evil.example": "/",
+		"//evil.example":       "/",
+		`/\evil.example`:       "/",
+	} {
+		if got := returnTo(target, "/"); got != want {
+			t.Errorf("returnTo(%q) = %q, want %q", target, got, want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	redisclient "github.com/tamcore/ephemeron/internal/redis"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	rec := httptest.NewRecorder()
+	SecurityHeaders(SecurityConfig{ContentSecurityPolicy: DefaultContentSecurityPolicy, HSTSMaxAge: 24 * time.Hour}, ok).
+		ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	want := map[string]string{
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "DENY",
+		"Content-Security-Policy":   DefaultContentSecurityPolicy,
+		"Strict-Transport-Security": "max-age=86400; includeSubDomains",
+	}
+	for name, value := range want {
+		if got := rec.Header().Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	SecurityHeaders(SecurityConfig{}, ok).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Header().Get("Strict-Transport-Security") != "" || rec.Header().Get("Content-Security-Policy") != "" {
+		t.Error("expected HSTS and CSP to be omitted when not configured")
+	}
+}
+
+func TestCSRFProtect(t *testing.T) {
+	o, mux := newOIDC("https://idp.example.com")
+	mux.HandleFunc("POST /v1/api/freeze", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	h := o.CSRFProtect(mux)
+	session := &http.Cookie{
+		Name:  sessionCookie,
+		Value: o.sign(Session{Name: "alice", Role: redisclient.RoleAdmin, Expires: time.Now().Add(time.Hour)}),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/session", nil)
+	req.AddCookie(session)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var body struct {
+		Name      string `json:"name"`
+		CSRFToken string `json:"csrf_token"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.CSRFToken == "" {
+		t.Fatalf("expected CSRF token in session, got %v %+v", err, body)
+	}
+
+	form := url.Values{csrfField: {body.CSRFToken}}.Encode()
+	tests := []struct {
+		name   string
+		req    func() *http.Request
+		status int
+	}{
+		{"without token", func() *http.Request {
+			r := httptest.NewRequest(http.MethodPost, "/v1/api/freeze", nil)
+			r.AddCookie(session)
+			return r
+		}, http.StatusForbidden},
+		{"wrong token", func() *http.Request {
+			r := httptest.NewRequest(http.MethodPost, "/v1/api/freeze", nil)
+			r.AddCookie(session)
+			r.Header.Set(csrfHeader, "forged")
+			return r
+		}, http.StatusForbidden},
+		{"header token", func() *http.Request {
+			r := httptest.NewRequest(http.MethodPost, "/v1/api/freeze", nil)
+			r.AddCookie(session)
+			r.Header.Set(csrfHeader, body.CSRFToken)
+			return r
+		}, http.StatusNoContent},
+		{"form token", func() *http.Request {
+			r := httptest.NewRequest(http.MethodPost, "/v1/api/freeze", strings.NewReader(form))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.AddCookie(session)
+			return r
+		}, http.StatusNoContent},
+		{"no session", func() *http.Request {
+			return httptest.NewRequest(http.MethodPost, "/v1/api/freeze", nil)
+		}, http.StatusNoContent},
+		{"bearer token", func() *http.Request {
+			r := httptest.NewRequest(http.MethodPost, "/v1/api/freeze", nil)
+			r.AddCookie(session)
+			r.Header.Set("Authorization", "Bearer eph_x")
+			return r
+		}, http.StatusNoContent},
+		{"logout without token", func() *http.Request {
+			r := httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+			r.AddCookie(session)
+			return r
+		}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, tt.req())
+			if rec.Code != tt.status {
+				t.Errorf("expected %d, got %d: %s", tt.status, rec.Code, rec.Body)
+			}
+		})
+	}
+}
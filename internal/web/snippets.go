@@ -0,0 +1,96 @@
+package web
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// exampleTTLCandidates are the TTLs offered as example tags, as far as
+// MAX_TTL allows.
+var exampleTTLCandidates = []time.Duration{
+	5 * time.Minute, 30 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour, 7 * 24 * time.Hour,
+}
+
+// Snippet is a copy-pasteable sequence of commands for one tool.
+type Snippet struct {
+	Tool     string
+	Commands []Command
+}
+
+// Command is a shell command rendered with the example tag. Template holds
+// it with "{tag}" in place of the tag, for the TTL calculator to rewrite.
+type Command struct {
+	Text     string
+	Template string
+}
+
+// snippets returns the commands pushing an image to hostname with tag for
+// each supported tool.
+func snippets(hostname, tag string) []Snippet {
+	tools := []struct {
+		name     string
+		commands []string
+	}{
+		{"docker", []string{
+			"docker tag myapp {host}/myapp:{tag}",
+			"docker push {host}/myapp:{tag}",
+		}},
+		{"crane", []string{
+			"crane copy docker.io/library/alpine:3 {host}/alpine:{tag}",
+		}},
+		{"skopeo", []string{
+			"skopeo copy docker://docker.io/library/alpine:3 docker://{host}/alpine:{tag}",
+		}},
+	}
+	result := make([]Snippet, 0, len(tools))
+	for _, tool := range tools {
+		s := Snippet{Tool: tool.name}
+		for _, c := range tool.commands {
+			tmpl := strings.ReplaceAll(c, "{host}", hostname)
+			s.Commands = append(s.Commands, Command{Text: strings.ReplaceAll(tmpl, "{tag}", tag), Template: tmpl})
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+// exampleTTLs returns the example TTL tags up to maxTTL, including the one
+// for defaultTTL, shortest first.
+func exampleTTLs(defaultTTL, maxTTL time.Duration) []string {
+	ttls := []time.Duration{min(defaultTTL, maxTTL)}
+	for _, d := range exampleTTLCandidates {
+		if d <= maxTTL && !slices.Contains(ttls, d) {
+			ttls = append(ttls, d)
+		}
+	}
+	slices.Sort(ttls)
+	tags := make([]string, len(ttls))
+	for i, d := range ttls {
+		tags[i] = ttlTag(d)
+	}
+	return tags
+}
+
+// ttlTag formats d as a TTL tag, such as "1d" or "1h30m", that the webhook
+// parses back to d.
+func ttlTag(d time.Duration) string {
+	units := []struct {
+		unit   time.Duration
+		suffix string
+	}{
+		{24 * time.Hour, "d"}, {time.Hour, "h"}, {time.Minute, "m"}, {time.Second, "s"},
+	}
+	var b strings.Builder
+	for _, u := range units {
+		if n := d / u.unit; n > 0 {
+			fmt.Fprintf(&b, "%d%s", n, u.suffix)
+			d -= n * u.unit
+		}
+	}
+	if b.Len() == 0 {
+		return "0s"
+	}
+	return b.String()
+}
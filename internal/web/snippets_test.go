@@ -0,0 +1,59 @@
+package web
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/tamcore/ephemeron/internal/hooks"
+)
+
+func TestTTLTag_ParsesBack(t *testing.T) {
+	for _, d := range []time.Duration{
+		30 * time.Second, 5 * time.Minute, 90 * time.Minute, 24 * time.Hour, 30 * time.Hour, 14 * 24 * time.Hour,
+	} {
+		tag := ttlTag(d)
+		if got := hooks.ParseTTL(tag); got != d {
+			t.Errorf("ParseTTL(ttlTag(%v) = %q) = %v", d, tag, got)
+		}
+	}
+	if got := ttlTag(24 * time.Hour); got != "1d" {
+		t.Errorf("ttlTag(24h) = %q, want 1d", got)
+	}
+}
+
+func TestExampleTTLs(t *testing.T) {
+	tests := []struct {
+		defaultTTL, maxTTL time.Duration
+		want               []string
+	}{
+		{time.Hour, 24 * time.Hour, []string{"5m", "30m", "1h", "6h", "1d"}},
+		{2 * time.Hour, 6 * time.Hour, []string{"5m", "30m", "1h", "2h", "6h"}},
+		{time.Hour, 20 * time.Minute, []string{"5m", "20m"}},
+		{time.Hour, 30 * 24 * time.Hour, []string{"5m", "30m", "1h", "6h", "1d", "7d"}},
+	}
+	for _, tt := range tests {
+		if got := exampleTTLs(tt.defaultTTL, tt.maxTTL); !slices.Equal(got, tt.want) {
+			t.Errorf("exampleTTLs(%v, %v) = %v, want %v", tt.defaultTTL, tt.maxTTL, got, tt.want)
+		}
+	}
+}
+
+func TestSnippets(t *testing.T) {
+	got := snippets("reg.test.dev", "2h")
+	tools := make([]string, len(got))
+	for i, s := range got {
+		tools[i] = s.Tool
+	}
+	if want := []string{"docker", "crane", "skopeo"}; !slices.Equal(tools, want) {
+		t.Fatalf("tools = %v, want %v", tools, want)
+	}
+	skopeo := got[2].Commands[0]
+	if want := "skopeo copy docker://docker.io/library/alpine:3 docker://reg.test.dev/alpine:2h"; skopeo.Text != want {
+		t.Errorf("skopeo command = %q, want %q", skopeo.Text, want)
+	}
+	want := "skopeo copy docker://docker.io/library/alpine:3 docker://reg.test.dev/alpine:{tag}"
+	if skopeo.Template != want {
+		t.Errorf("skopeo template = %q, want %q", skopeo.Template, want)
+	}
+}